@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"changkun.de/wallfacer/internal/envconfig"
+)
+
+// secretFlags lists run flags whose values are redacted in the config dump.
+// Webhook URLs embed the credential in the path (e.g. Slack incoming hooks).
+var secretFlags = map[string]bool{
+	"webhook-url": true,
+	"api-key":     true,
+}
+
+// configEntry is one resolved setting in the effective configuration.
+type configEntry struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"` // "flag", "env", "env-file", or "default"
+	Env    string `json:"env,omitempty"`
+}
+
+// effectiveConfig is the output of `wallfacer config`.
+type effectiveConfig struct {
+	Flags      []configEntry `json:"flags"`
+	Workspaces []string      `json:"workspaces"`
+	EnvFile    []configEntry `json:"env_file"`
+}
+
+// runConfigDump prints the configuration `wallfacer run` would resolve for
+// the same arguments, with the source of each value and secrets redacted.
+func runConfigDump(configDir string, args []string) {
+	f := newRunFlags("config", configDir)
+	fs := f.fs
+	jsonOut := fs.Bool("json", false, "print JSON instead of a table")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: wallfacer config [-json] [run flags] [workspace ...]\n\n")
+		fmt.Fprintf(os.Stderr, "Print the effective configuration 'wallfacer run' would use with the same\n")
+		fmt.Fprintf(os.Stderr, "arguments, showing whether each value came from a flag, the environment,\n")
+		fmt.Fprintf(os.Stderr, "or the default. Secrets are redacted.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cfg := resolveConfig(fs, *f.envFile)
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(cfg)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FLAG\tVALUE\tSOURCE")
+	for _, e := range cfg.Flags {
+		fmt.Fprintf(tw, "-%s\t%s\t%s\n", e.Name, e.Value, sourceLabel(e))
+	}
+	fmt.Fprintln(tw)
+	for _, ws := range cfg.Workspaces {
+		fmt.Fprintf(tw, "workspace\t%s\t\n", ws)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "ENV FILE (%s)\t\t\n", *f.envFile)
+	for _, e := range cfg.EnvFile {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", e.Name, e.Value, e.Source)
+	}
+	tw.Flush()
+}
+
+// resolveConfig collects every run flag with its resolved value and source,
+// the workspaces, and the known env-file keys. The "json" flag of the config
+// command itself is skipped.
+func resolveConfig(fs *flag.FlagSet, envFile string) effectiveConfig {
+	set := map[string]bool{}
+	fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+
+	var cfg effectiveConfig
+	fs.VisitAll(func(fl *flag.Flag) {
+		if fl.Name == "json" {
+			return
+		}
+		e := configEntry{Name: fl.Name, Value: fl.Value.String(), Source: "default", Env: runFlagEnv[fl.Name]}
+		switch {
+		case set[fl.Name]:
+			e.Source = "flag"
+		case e.Env != "" && os.Getenv(e.Env) != "":
+			e.Source = "env"
+		}
+		if secretFlags[fl.Name] {
+			e.Value = envconfig.MaskToken(e.Value)
+		}
+		cfg.Flags = append(cfg.Flags, e)
+	})
+
+	cfg.Workspaces = fs.Args()
+	if len(cfg.Workspaces) == 0 {
+		if cwd, err := os.Getwd(); err == nil {
+			cfg.Workspaces = []string{cwd}
+		}
+	}
+	for i, ws := range cfg.Workspaces {
+		if abs, err := filepath.Abs(ws); err == nil {
+			cfg.Workspaces[i] = abs
+		}
+	}
+
+	cfg.EnvFile = []configEntry{}
+	if env, err := envconfig.Parse(envFile); err == nil {
+		for _, e := range []configEntry{
+			{Name: "CLAUDE_CODE_OAUTH_TOKEN", Value: envconfig.MaskToken(env.OAuthToken)},
+			{Name: "ANTHROPIC_API_KEY", Value: envconfig.MaskToken(env.APIKey)},
+			{Name: "ANTHROPIC_BASE_URL", Value: env.BaseURL},
+			{Name: "CLAUDE_CODE_MODEL", Value: env.Model},
+		} {
+			if e.Value == "" {
+				continue
+			}
+			e.Source = "env-file"
+			cfg.EnvFile = append(cfg.EnvFile, e)
+		}
+	}
+	return cfg
+}
+
+// sourceLabel renders an entry's source for the table, naming the
+// environment variable when it won.
+func sourceLabel(e configEntry) string {
+	if e.Source == "env" {
+		return "env " + e.Env
+	}
+	return e.Source
+}
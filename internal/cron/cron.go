@@ -0,0 +1,199 @@
+// Package cron parses standard five-field cron expressions (minute, hour,
+// day of month, month, day of week) and computes when they next fire. Fields
+// accept "*", numbers, ranges "a-b", steps "*/n" and "a-b/n", and
+// comma-separated lists; months and weekdays also accept three-letter names.
+// The shorthands @yearly, @annually, @monthly, @weekly, @daily, @midnight,
+// and @hourly are recognised. As in Vixie cron, when both day fields are
+// restricted a time matches if either of them does.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression. Each field is a bitset of the
+// values it matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar record an unrestricted day field, which decides
+	// whether the two day fields are combined with AND or OR.
+	domStar, dowStar bool
+}
+
+// field describes the range and value names of one cron field.
+type field struct {
+	name     string
+	min, max int
+	names    []string // names[i] stands for min+i
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12,
+		names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	// Day of week also accepts 7 for Sunday.
+	dowField = field{name: "day of week", min: 0, max: 7,
+		names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// shorthands maps the @-forms to their five-field equivalents.
+var shorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if full, ok := shorthands[strings.ToLower(expr)]; ok {
+		expr = full
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields, got %d in %q", len(fields), expr)
+	}
+	s := &Schedule{
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}
+	var err error
+	if s.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if s.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if s.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if s.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if s.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 << 0
+	}
+	return s, nil
+}
+
+// parse returns the bitset of values matched by the comma-separated list v.
+func (f field) parse(v string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(v, ",") {
+		b, err := f.parseRange(part)
+		if err != nil {
+			return 0, err
+		}
+		bits |= b
+	}
+	return bits, nil
+}
+
+// parseRange parses one list element: "*", "n", or "a-b", each optionally
+// followed by "/step".
+func (f field) parseRange(v string) (uint64, error) {
+	rng, stepStr, hasStep := strings.Cut(v, "/")
+	step := 1
+	if hasStep {
+		n, err := strconv.Atoi(stepStr)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("cron: invalid step %q in %s field", stepStr, f.name)
+		}
+		step = n
+	}
+	lo, hi := f.min, f.max
+	if rng != "*" {
+		loStr, hiStr, isRange := strings.Cut(rng, "-")
+		var err error
+		if lo, err = f.value(loStr); err != nil {
+			return 0, err
+		}
+		hi = lo
+		if isRange {
+			if hi, err = f.value(hiStr); err != nil {
+				return 0, err
+			}
+		} else if hasStep {
+			hi = f.max
+		}
+		if hi < lo {
+			return 0, fmt.Errorf("cron: invalid range %q in %s field", rng, f.name)
+		}
+	}
+	var bits uint64
+	for i := lo; i <= hi; i += step {
+		bits |= 1 << i
+	}
+	return bits, nil
+}
+
+// value parses a single number or name of the field.
+func (f field) value(v string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(v, name) {
+			return f.min + i, nil
+		}
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("cron: invalid %s %q", f.name, v)
+	}
+	return n, nil
+}
+
+// maxSearch bounds how far ahead Next looks, so an expression that can
+// never match (such as "0 0 30 2 *") does not loop forever.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// Next returns the first time after t, at minute precision and in t's
+// location, that s matches. It returns the zero time if s does not match
+// within the next five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			// Rebuilt from the wall clock: t.Truncate works on absolute
+			// time, which is off the hour in half-hour zones.
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			if !next.After(t) {
+				// The next hour repeats one already passed (DST ending).
+				next = t.Add(time.Minute)
+			}
+			t = next
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether t's day satisfies the day-of-month and
+// day-of-week fields.
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
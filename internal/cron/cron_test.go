@@ -0,0 +1,96 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// Friday 2026-01-16 10:30 UTC.
+	from := time.Date(2026, 1, 16, 10, 30, 0, 0, time.UTC)
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 1, 16, 10, 31, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2026, 1, 17, 10, 30, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2026, 1, 17, 2, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 1, 16, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2026, 1, 16, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * mon", time.Date(2026, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 1, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 feb *", time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: the 20th or any Sunday.
+		{"0 0 20 * sun", time.Date(2026, 1, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 1, 16, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, c := range cases {
+		s, err := Parse(c.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", c.expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(c.want) {
+			t.Errorf("Next(%q) = %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestNextInLocalTime(t *testing.T) {
+	load := func(name string) *time.Location {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Skipf("time zone %s: %v", name, err)
+		}
+		return loc
+	}
+	kolkata := load("Asia/Kolkata")        // UTC+5:30
+	newYork := load("America/New_York")    // DST from 2026-03-08 02:00
+	adelaide := load("Australia/Adelaide") // UTC+10:30, DST ends 2026-04-05 03:00
+	cases := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"0 10 * * *", time.Date(2026, 1, 16, 9, 45, 0, 0, kolkata), time.Date(2026, 1, 16, 10, 0, 0, 0, kolkata)},
+		{"30 * * * *", time.Date(2026, 1, 16, 9, 45, 0, 0, kolkata), time.Date(2026, 1, 16, 10, 30, 0, 0, kolkata)},
+		{"0 3 * * *", time.Date(2026, 3, 8, 1, 30, 0, 0, newYork), time.Date(2026, 3, 8, 3, 0, 0, 0, newYork)},
+		{"0 9 * * *", time.Date(2026, 3, 7, 10, 0, 0, 0, newYork), time.Date(2026, 3, 8, 9, 0, 0, 0, newYork)},
+		// 02:30 does not exist on the day DST starts; the next one is a day later.
+		{"30 2 * * *", time.Date(2026, 3, 8, 1, 0, 0, 0, newYork), time.Date(2026, 3, 9, 2, 30, 0, 0, newYork)},
+		{"0 4 * * *", time.Date(2026, 4, 5, 1, 15, 0, 0, adelaide), time.Date(2026, 4, 5, 4, 0, 0, 0, adelaide)},
+	}
+	for _, c := range cases {
+		s, err := Parse(c.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.expr, err)
+		}
+		if got := s.Next(c.from); !got.Equal(c.want) {
+			t.Errorf("Next(%q, %v) = %v, want %v", c.expr, c.from, got, c.want)
+		}
+	}
+}
+
+func TestParseRejectsInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"x * * * *",
+		"@sometimes",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", expr)
+		}
+	}
+}
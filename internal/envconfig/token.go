@@ -0,0 +1,15 @@
+package envconfig
+
+// Placeholder values written into a new env file by wallfacer. They mark a
+// token the user has not filled in yet.
+const (
+	PlaceholderOAuthToken = "your-oauth-token-here"
+	PlaceholderAPIKey     = "sk-ant-..."
+)
+
+// HasToken reports whether the config sets an OAuth token or API key other
+// than the placeholders.
+func (c Config) HasToken() bool {
+	return (c.OAuthToken != "" && c.OAuthToken != PlaceholderOAuthToken) ||
+		(c.APIKey != "" && c.APIKey != PlaceholderAPIKey)
+}
@@ -0,0 +1,225 @@
+// Package forge opens pull requests on the code host behind a repository's
+// remote: GitHub pull requests, GitLab merge requests, and Bitbucket pull
+// requests. The provider is chosen from the remote URL's host and
+// authenticates with a token from a provider-specific environment variable.
+package forge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Provider opens a pull (or merge) request for branch head against base on
+// the repository identified by remoteURL and returns the request's web URL.
+type Provider interface {
+	OpenPullRequest(remoteURL, head, base, title, body string) (string, error)
+}
+
+// Environment variables holding each provider's API token.
+const (
+	GitHubTokenEnv    = "GITHUB_TOKEN"
+	GitLabTokenEnv    = "GITLAB_TOKEN"
+	BitbucketTokenEnv = "BITBUCKET_TOKEN"
+)
+
+// requestTimeout bounds a single API call.
+const requestTimeout = 30 * time.Second
+
+// Detect returns the provider for remoteURL based on its host: github.com
+// (or a host containing "github") selects GitHub, a host containing
+// "gitlab" selects GitLab, and bitbucket.org selects Bitbucket. Self-hosted
+// GitHub and GitLab instances use the API on the same host.
+func Detect(remoteURL string) (Provider, error) {
+	host, _, err := parseRemote(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case host == "github.com":
+		return &GitHub{APIBase: "https://api.github.com", Token: os.Getenv(GitHubTokenEnv)}, nil
+	case strings.Contains(host, "github"):
+		return &GitHub{APIBase: "https://" + host + "/api/v3", Token: os.Getenv(GitHubTokenEnv)}, nil
+	case strings.Contains(host, "gitlab"):
+		return &GitLab{APIBase: "https://" + host + "/api/v4", Token: os.Getenv(GitLabTokenEnv)}, nil
+	case host == "bitbucket.org":
+		return &Bitbucket{APIBase: "https://api.bitbucket.org/2.0", Token: os.Getenv(BitbucketTokenEnv)}, nil
+	}
+	return nil, fmt.Errorf("unsupported code host %q", host)
+}
+
+// CommitURL returns the web page of commit hash on the code host behind
+// remoteURL, or "" when the host is not one Detect recognises.
+func CommitURL(remoteURL, hash string) string {
+	host, repoPath, err := parseRemote(remoteURL)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case strings.Contains(host, "github"):
+		return "https://" + host + "/" + repoPath + "/commit/" + hash
+	case strings.Contains(host, "gitlab"):
+		return "https://" + host + "/" + repoPath + "/-/commit/" + hash
+	case host == "bitbucket.org":
+		return "https://" + host + "/" + repoPath + "/commits/" + hash
+	}
+	return ""
+}
+
+// parseRemote splits a git remote URL into its host and repository path
+// (without a leading slash or trailing ".git"). It accepts https://, ssh://
+// and scp-like "git@host:owner/repo.git" forms.
+func parseRemote(remoteURL string) (host, repoPath string, err error) {
+	raw := strings.TrimSpace(remoteURL)
+	if !strings.Contains(raw, "://") {
+		// scp-like syntax: [user@]host:path
+		at := strings.LastIndex(raw, "@")
+		colon := strings.Index(raw, ":")
+		if colon <= at+1 {
+			return "", "", fmt.Errorf("unrecognised remote URL %q", remoteURL)
+		}
+		host, repoPath = raw[at+1:colon], raw[colon+1:]
+	} else {
+		u, perr := url.Parse(raw)
+		if perr != nil {
+			return "", "", fmt.Errorf("parse remote URL %q: %w", remoteURL, perr)
+		}
+		host, repoPath = u.Hostname(), u.Path
+	}
+	repoPath = strings.TrimSuffix(strings.Trim(repoPath, "/"), ".git")
+	if host == "" || !strings.Contains(repoPath, "/") {
+		return "", "", fmt.Errorf("unrecognised remote URL %q", remoteURL)
+	}
+	return strings.ToLower(host), repoPath, nil
+}
+
+// GitHub opens pull requests through the GitHub REST API.
+type GitHub struct {
+	APIBase string
+	Token   string
+}
+
+// OpenPullRequest implements Provider.
+func (g *GitHub) OpenPullRequest(remoteURL, head, base, title, body string) (string, error) {
+	if g.Token == "" {
+		return "", fmt.Errorf("github: %s is not set", GitHubTokenEnv)
+	}
+	_, repoPath, err := parseRemote(remoteURL)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		HTMLURL string `json:"html_url"`
+	}
+	err = postJSON(g.APIBase+"/repos/"+repoPath+"/pulls", map[string]string{
+		"Authorization": "Bearer " + g.Token,
+		"Accept":        "application/vnd.github+json",
+	}, map[string]string{"title": title, "head": head, "base": base, "body": body}, &resp)
+	if err != nil {
+		return "", fmt.Errorf("github: %w", err)
+	}
+	return resp.HTMLURL, nil
+}
+
+// GitLab opens merge requests through the GitLab REST API.
+type GitLab struct {
+	APIBase string
+	Token   string
+}
+
+// OpenPullRequest implements Provider.
+func (g *GitLab) OpenPullRequest(remoteURL, head, base, title, body string) (string, error) {
+	if g.Token == "" {
+		return "", fmt.Errorf("gitlab: %s is not set", GitLabTokenEnv)
+	}
+	_, repoPath, err := parseRemote(remoteURL)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		WebURL string `json:"web_url"`
+	}
+	err = postJSON(g.APIBase+"/projects/"+url.PathEscape(repoPath)+"/merge_requests", map[string]string{
+		"PRIVATE-TOKEN": g.Token,
+	}, map[string]string{"source_branch": head, "target_branch": base, "title": title, "description": body}, &resp)
+	if err != nil {
+		return "", fmt.Errorf("gitlab: %w", err)
+	}
+	return resp.WebURL, nil
+}
+
+// Bitbucket opens pull requests through the Bitbucket Cloud REST API.
+type Bitbucket struct {
+	APIBase string
+	Token   string
+}
+
+// OpenPullRequest implements Provider.
+func (b *Bitbucket) OpenPullRequest(remoteURL, head, base, title, body string) (string, error) {
+	if b.Token == "" {
+		return "", fmt.Errorf("bitbucket: %s is not set", BitbucketTokenEnv)
+	}
+	_, repoPath, err := parseRemote(remoteURL)
+	if err != nil {
+		return "", err
+	}
+	type branch struct {
+		Name string `json:"name"`
+	}
+	type ref struct {
+		Branch branch `json:"branch"`
+	}
+	var resp struct {
+		Links struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		} `json:"links"`
+	}
+	err = postJSON(b.APIBase+"/repositories/"+repoPath+"/pullrequests", map[string]string{
+		"Authorization": "Bearer " + b.Token,
+	}, map[string]any{
+		"title":       title,
+		"description": body,
+		"source":      ref{Branch: branch{Name: head}},
+		"destination": ref{Branch: branch{Name: base}},
+	}, &resp)
+	if err != nil {
+		return "", fmt.Errorf("bitbucket: %w", err)
+	}
+	return resp.Links.HTML.Href, nil
+}
+
+// postJSON posts payload as JSON to endpoint with the given headers and
+// decodes a successful response into out.
+func postJSON(endpoint string, headers map[string]string, payload, out any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: requestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,127 @@
+package forge
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseRemote(t *testing.T) {
+	cases := []struct {
+		url, host, path string
+	}{
+		{"https://github.com/owner/repo.git", "github.com", "owner/repo"},
+		{"https://github.com/owner/repo", "github.com", "owner/repo"},
+		{"git@github.com:owner/repo.git", "github.com", "owner/repo"},
+		{"ssh://git@gitlab.example.com:2222/group/sub/repo.git", "gitlab.example.com", "group/sub/repo"},
+		{"https://user@bitbucket.org/team/repo.git", "bitbucket.org", "team/repo"},
+	}
+	for _, tc := range cases {
+		host, path, err := parseRemote(tc.url)
+		if err != nil || host != tc.host || path != tc.path {
+			t.Errorf("parseRemote(%q) = %q, %q, %v; want %q, %q", tc.url, host, path, err, tc.host, tc.path)
+		}
+	}
+	for _, bad := range []string{"", "/local/path", "https://github.com/", "host:"} {
+		if _, _, err := parseRemote(bad); err == nil {
+			t.Errorf("parseRemote(%q): expected error", bad)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		url     string
+		want    string
+		apiBase string
+	}{
+		{"git@github.com:owner/repo.git", "github", "https://api.github.com"},
+		{"https://github.corp.example/owner/repo", "github", "https://github.corp.example/api/v3"},
+		{"https://gitlab.com/group/repo.git", "gitlab", "https://gitlab.com/api/v4"},
+		{"git@gitlab.internal:group/repo.git", "gitlab", "https://gitlab.internal/api/v4"},
+		{"git@bitbucket.org:team/repo.git", "bitbucket", "https://api.bitbucket.org/2.0"},
+	}
+	for _, tc := range cases {
+		p, err := Detect(tc.url)
+		if err != nil {
+			t.Errorf("Detect(%q): %v", tc.url, err)
+			continue
+		}
+		var kind, apiBase string
+		switch p := p.(type) {
+		case *GitHub:
+			kind, apiBase = "github", p.APIBase
+		case *GitLab:
+			kind, apiBase = "gitlab", p.APIBase
+		case *Bitbucket:
+			kind, apiBase = "bitbucket", p.APIBase
+		}
+		if kind != tc.want || apiBase != tc.apiBase {
+			t.Errorf("Detect(%q) = %s %s; want %s %s", tc.url, kind, apiBase, tc.want, tc.apiBase)
+		}
+	}
+	if _, err := Detect("https://codeberg.org/owner/repo.git"); err == nil {
+		t.Error("expected an error for an unsupported host")
+	}
+}
+
+func TestCommitURL(t *testing.T) {
+	cases := []struct{ remote, want string }{
+		{"git@github.com:owner/repo.git", "https://github.com/owner/repo/commit/abc"},
+		{"https://gitlab.com/group/sub/repo.git", "https://gitlab.com/group/sub/repo/-/commit/abc"},
+		{"git@bitbucket.org:team/repo.git", "https://bitbucket.org/team/repo/commits/abc"},
+		{"https://codeberg.org/owner/repo.git", ""},
+		{"/srv/git/repo.git", ""},
+	}
+	for _, tc := range cases {
+		if got := CommitURL(tc.remote, "abc"); got != tc.want {
+			t.Errorf("CommitURL(%q) = %q, want %q", tc.remote, got, tc.want)
+		}
+	}
+}
+
+func TestOpenPullRequest(t *testing.T) {
+	var gotPath, gotAuth string
+	var gotBody map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+		gotAuth = r.Header.Get("Authorization") + r.Header.Get("PRIVATE-TOKEN")
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"html_url":"https://h/pr/1","web_url":"https://h/mr/1","links":{"html":{"href":"https://h/bb/1"}}}`))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name     string
+		p        Provider
+		remote   string
+		wantPath string
+		wantAuth string
+		wantURL  string
+		headKey  string
+	}{
+		{"github", &GitHub{APIBase: srv.URL, Token: "t"}, "git@github.com:o/r.git", "/repos/o/r/pulls", "Bearer t", "https://h/pr/1", "head"},
+		{"gitlab", &GitLab{APIBase: srv.URL, Token: "t"}, "git@gitlab.com:g/sub/r.git", "/projects/g%2Fsub%2Fr/merge_requests", "t", "https://h/mr/1", "source_branch"},
+		{"bitbucket", &Bitbucket{APIBase: srv.URL, Token: "t"}, "git@bitbucket.org:w/r.git", "/repositories/w/r/pullrequests", "Bearer t", "https://h/bb/1", "source"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.p.OpenPullRequest(tc.remote, "task/abc", "main", "Title", "Body")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.wantURL || gotPath != tc.wantPath || gotAuth != tc.wantAuth {
+				t.Errorf("url=%q path=%q auth=%q; want %q %q %q", got, gotPath, gotAuth, tc.wantURL, tc.wantPath, tc.wantAuth)
+			}
+			if gotBody[tc.headKey] == nil {
+				t.Errorf("request body missing %q: %v", tc.headKey, gotBody)
+			}
+		})
+	}
+
+	if _, err := (&GitHub{APIBase: srv.URL}).OpenPullRequest("git@github.com:o/r.git", "h", "b", "t", ""); err == nil {
+		t.Error("expected an error without a token")
+	}
+}
@@ -0,0 +1,103 @@
+package gitutil
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// BlameLine attributes one line of a file to the commit that last changed it.
+type BlameLine struct {
+	Line   int    `json:"line"`
+	Author string `json:"author"`
+	Commit string `json:"commit"`
+}
+
+// Blame runs git blame on file (relative to repoPath) at HEAD and returns
+// one entry per line.
+func Blame(repoPath, file string) ([]BlameLine, error) {
+	out, err := exec.Command("git", "-C", repoPath, "blame", "--line-porcelain", "HEAD", "--", file).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git blame %s in %s: %w", file, repoPath, err)
+	}
+	return parseBlamePorcelain(out), nil
+}
+
+// parseBlamePorcelain parses `git blame --line-porcelain` output, where each
+// line starts with a "<sha> <orig-line> <final-line> [<count>]" header, is
+// followed by "key value" metadata, and ends with the content prefixed by a
+// tab.
+func parseBlamePorcelain(out []byte) []BlameLine {
+	var lines []BlameLine
+	var cur BlameLine
+	inEntry := false
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		text := sc.Text()
+		switch {
+		case strings.HasPrefix(text, "\t"):
+			if inEntry {
+				lines = append(lines, cur)
+			}
+			inEntry = false
+		case !inEntry:
+			fields := strings.Fields(text)
+			if len(fields) < 3 {
+				continue
+			}
+			n, err := strconv.Atoi(fields[2])
+			if err != nil {
+				continue
+			}
+			cur = BlameLine{Line: n, Commit: fields[0]}
+			inEntry = true
+		case strings.HasPrefix(text, "author "):
+			cur.Author = strings.TrimPrefix(text, "author ")
+		}
+	}
+	return lines
+}
+
+// CommitsInRange returns the hashes of commits reachable from to but not
+// from from, i.e. `git rev-list from..to`, newest first. An empty from lists
+// every commit reachable from to.
+func CommitsInRange(repoPath, from, to string) ([]string, error) {
+	spec := to
+	if from != "" {
+		spec = from + ".." + to
+	}
+	out, err := exec.Command("git", "-C", repoPath, "rev-list", spec).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git rev-list %s..%s in %s: %w", from, to, repoPath, err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// ResolveCommit resolves ref to a full commit hash, rejecting anything that
+// does not name a commit (including option-like strings).
+func ResolveCommit(repoPath, ref string) (string, error) {
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("invalid ref %q", ref)
+	}
+	out, err := exec.Command("git", "-C", repoPath, "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}").Output()
+	if err != nil {
+		return "", fmt.Errorf("unknown commit %q in %s", ref, repoPath)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// FormatPatch writes commit as an mbox-formatted patch to w, as produced by
+// `git format-patch --stdout`.
+func FormatPatch(repoPath, commit string, w io.Writer) error {
+	cmd := exec.Command("git", "-C", repoPath, "format-patch", "-1", "--stdout", commit)
+	cmd.Stdout = w
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git format-patch %s in %s: %w", commit, repoPath, err)
+	}
+	return nil
+}
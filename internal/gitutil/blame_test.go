@@ -0,0 +1,54 @@
+package gitutil
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBlame(t *testing.T) {
+	repo := setupRepo(t)
+	first := gitRun(t, repo, "rev-parse", "HEAD")
+	writeFile(t, filepath.Join(repo, "file.txt"), "initial\nsecond\n")
+	gitRun(t, repo, "-c", "user.name=Agent", "commit", "-am", "add line")
+	second := gitRun(t, repo, "rev-parse", "HEAD")
+
+	lines, err := Blame(repo, "file.txt")
+	if err != nil {
+		t.Fatalf("Blame: %v", err)
+	}
+	want := []BlameLine{
+		{Line: 1, Author: "Test", Commit: first},
+		{Line: 2, Author: "Agent", Commit: second},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d: %+v", len(lines), len(want), lines)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i+1, lines[i], want[i])
+		}
+	}
+
+	if _, err := Blame(repo, "missing.txt"); err == nil {
+		t.Error("expected error for untracked file")
+	}
+}
+
+func TestCommitsInRange(t *testing.T) {
+	repo := setupRepo(t)
+	base := gitRun(t, repo, "rev-parse", "HEAD")
+	writeFile(t, filepath.Join(repo, "a.txt"), "a\n")
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-m", "a")
+	writeFile(t, filepath.Join(repo, "b.txt"), "b\n")
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-m", "b")
+
+	commits, err := CommitsInRange(repo, base, "HEAD")
+	if err != nil {
+		t.Fatalf("CommitsInRange: %v", err)
+	}
+	if len(commits) != 2 {
+		t.Errorf("got %d commits, want 2", len(commits))
+	}
+}
@@ -0,0 +1,71 @@
+package gitutil
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// lfsPointerPrefix starts every Git LFS pointer file.
+const lfsPointerPrefix = "version https://git-lfs.github.com/spec/v1"
+
+// UsesLFS reports whether the checkout at path routes any files through
+// Git LFS, i.e. its top-level .gitattributes sets filter=lfs.
+func UsesLFS(path string) bool {
+	data, err := os.ReadFile(filepath.Join(path, ".gitattributes"))
+	return err == nil && bytes.Contains(data, []byte("filter=lfs"))
+}
+
+// LFSAvailable reports whether the git-lfs extension is installed.
+func LFSAvailable() bool {
+	return exec.Command("git", "lfs", "version").Run() == nil
+}
+
+// PullLFS downloads and checks out the LFS objects of the worktree at
+// worktreePath, replacing pointer files with their content.
+func PullLFS(worktreePath string) error {
+	out, err := exec.Command("git", "-C", worktreePath, "lfs", "pull").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git lfs pull in %s: %w\n%s", worktreePath, err, out)
+	}
+	return nil
+}
+
+// InvalidLFSPointers returns the LFS-tracked files changed between base and
+// HEAD in repoPath whose committed blob is not an LFS pointer. Such files
+// were committed without the LFS clean filter (e.g. with git-lfs missing)
+// and would put their full content into history.
+func InvalidLFSPointers(repoPath, base string) ([]string, error) {
+	out, err := exec.Command("git", "-C", repoPath, "diff", "-z", "--name-only", "--diff-filter=AM", base, "HEAD").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff in %s: %w", repoPath, err)
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	changed := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	attrs, err := exec.Command("git", append([]string{"-C", repoPath, "check-attr", "-z", "filter", "--"}, changed...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git check-attr in %s: %w", repoPath, err)
+	}
+	var invalid []string
+	// Output is a sequence of NUL-terminated <path> <attribute> <value>.
+	fields := strings.Split(string(attrs), "\x00")
+	for i := 0; i+2 < len(fields); i += 3 {
+		path, value := fields[i], fields[i+2]
+		if value != "lfs" {
+			continue
+		}
+		blob, err := exec.Command("git", "-C", repoPath, "cat-file", "blob", "HEAD:"+path).Output()
+		if err != nil {
+			return nil, fmt.Errorf("git cat-file %s in %s: %w", path, repoPath, err)
+		}
+		if !bytes.HasPrefix(blob, []byte(lfsPointerPrefix)) {
+			invalid = append(invalid, path)
+		}
+	}
+	return invalid, nil
+}
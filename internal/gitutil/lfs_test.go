@@ -0,0 +1,46 @@
+package gitutil
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestUsesLFS(t *testing.T) {
+	repo := setupRepo(t)
+	if UsesLFS(repo) {
+		t.Error("UsesLFS = true without .gitattributes")
+	}
+	writeFile(t, filepath.Join(repo, ".gitattributes"), "*.bin filter=lfs diff=lfs merge=lfs -text\n")
+	if !UsesLFS(repo) {
+		t.Error("UsesLFS = false with filter=lfs in .gitattributes")
+	}
+}
+
+func TestInvalidLFSPointers(t *testing.T) {
+	repo := setupRepo(t)
+	writeFile(t, filepath.Join(repo, ".gitattributes"), "*.bin filter=lfs diff=lfs merge=lfs -text\n")
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-m", "track *.bin with LFS")
+	base := gitRun(t, repo, "rev-parse", "HEAD")
+
+	// Without git-lfs the clean filter never runs, so these blobs are
+	// stored exactly as written.
+	writeFile(t, filepath.Join(repo, "pointer.bin"), lfsPointerPrefix+"\noid sha256:0000\nsize 4\n")
+	writeFile(t, filepath.Join(repo, "raw data.bin"), "\x00\x01\x02\x03")
+	writeFile(t, filepath.Join(repo, "notes.txt"), "not tracked by LFS\n")
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-m", "add files")
+
+	invalid, err := InvalidLFSPointers(repo, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(invalid, []string{"raw data.bin"}) {
+		t.Errorf("InvalidLFSPointers = %q, want [raw data.bin]", invalid)
+	}
+
+	if invalid, err := InvalidLFSPointers(repo, "HEAD"); err != nil || len(invalid) != 0 {
+		t.Errorf("InvalidLFSPointers(HEAD) = %q, %v; want none", invalid, err)
+	}
+}
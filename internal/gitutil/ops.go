@@ -1,8 +1,11 @@
 package gitutil
 
 import (
+	"bytes"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -10,31 +13,102 @@ import (
 // RebaseOntoDefault rebases the task branch (currently checked out in worktreePath)
 // onto the default branch of repoPath. On conflict it aborts the rebase and returns
 // ErrConflict so the caller can invoke conflict resolution and retry.
-func RebaseOntoDefault(repoPath, worktreePath string) error {
+// Extra rebase arguments, such as "-X", "theirs", are passed through.
+func RebaseOntoDefault(repoPath, worktreePath string, args ...string) error {
 	defBranch, err := DefaultBranch(repoPath)
 	if err != nil {
 		return err
 	}
-	out, err := exec.Command("git", "-C", worktreePath, "rebase", defBranch).CombinedOutput()
-	if err != nil {
+	return RebaseOnto(worktreePath, defBranch, args...)
+}
+
+// RebaseOnto rebases the branch checked out in worktreePath onto target,
+// aborting and returning ErrConflict on conflicts like RebaseOntoDefault.
+// The conflict is a *ConflictError naming the conflicted files. Extra
+// arguments go before target, e.g. "-X", "ours" to settle conflicting hunks
+// in favour of target.
+func RebaseOnto(worktreePath, target string, args ...string) error {
+	err := StartRebase(worktreePath, target, args...)
+	if err != nil && RebaseInProgress(worktreePath) {
 		// Abort so the repo is not stuck mid-rebase.
-		exec.Command("git", "-C", worktreePath, "rebase", "--abort").Run()
+		AbortRebase(worktreePath)
+	}
+	return err
+}
+
+// StartRebase is RebaseOnto without the abort: on conflicts the rebase is
+// left stopped in worktreePath for someone to resolve and continue with
+// ContinueRebase, and the *ConflictError describes where it stopped.
+func StartRebase(worktreePath, target string, args ...string) error {
+	cmdArgs := append([]string{"-C", worktreePath, "rebase"}, args...)
+	out, err := exec.Command("git", append(cmdArgs, target)...).CombinedOutput()
+	if err != nil {
 		if IsConflictOutput(string(out)) {
-			return fmt.Errorf("%w in %s", ErrConflict, worktreePath)
+			return conflictDetails(worktreePath)
 		}
 		return fmt.Errorf("git rebase in %s: %w\n%s", worktreePath, err, out)
 	}
 	return nil
 }
 
+// ContinueRebase continues the rebase stopped in worktreePath once its
+// conflicts are resolved and staged. Unresolved paths, or a conflict in a
+// later commit of the rebase, are returned as a *ConflictError with the
+// rebase still in progress. Nothing is done when no rebase is in progress.
+func ContinueRebase(worktreePath string) error {
+	if !RebaseInProgress(worktreePath) {
+		return nil
+	}
+	if c := conflictDetails(worktreePath); len(c.Files) > 0 {
+		return c
+	}
+	cmd := exec.Command("git", "-C", worktreePath, "rebase", "--continue")
+	// Keep the replayed commit messages instead of opening an editor.
+	cmd.Env = append(os.Environ(), "GIT_EDITOR=true")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if IsConflictOutput(string(out)) {
+			return conflictDetails(worktreePath)
+		}
+		return fmt.Errorf("git rebase --continue in %s: %w\n%s", worktreePath, err, out)
+	}
+	return nil
+}
+
+// conflictDetails describes the conflict of a rebase stopped in
+// worktreePath: the unmerged paths, the subject of the commit being
+// replayed, and the conflict hunks of those paths.
+func conflictDetails(worktreePath string) *ConflictError {
+	c := &ConflictError{Path: worktreePath}
+	if names, err := exec.Command("git", "-C", worktreePath, "diff", "--name-only", "--diff-filter=U").Output(); err == nil && len(bytes.TrimSpace(names)) > 0 {
+		c.Files = strings.Split(string(bytes.TrimSpace(names)), "\n")
+	}
+	if subject, err := exec.Command("git", "-C", worktreePath, "log", "-1", "--format=%s", "REBASE_HEAD").Output(); err == nil {
+		c.Commit = strings.TrimSpace(string(subject))
+	}
+	if hunks, err := exec.Command("git", "-C", worktreePath, "diff", "--diff-filter=U").Output(); err == nil {
+		if len(hunks) > MaxConflictHunks {
+			hunks = append(hunks[:MaxConflictHunks:MaxConflictHunks], "\n... (truncated)\n"...)
+		}
+		c.Hunks = string(hunks)
+	}
+	return c
+}
+
 // FFMerge fast-forward merges branchName into the default branch of repoPath.
 func FFMerge(repoPath, branchName string) error {
 	defBranch, err := DefaultBranch(repoPath)
 	if err != nil {
 		return err
 	}
-	if out, err := exec.Command("git", "-C", repoPath, "checkout", defBranch).CombinedOutput(); err != nil {
-		return fmt.Errorf("git checkout %s in %s: %w\n%s", defBranch, repoPath, err, out)
+	return FFMergeInto(repoPath, defBranch, branchName)
+}
+
+// FFMergeInto checks out target in repoPath and fast-forward merges
+// branchName into it.
+func FFMergeInto(repoPath, target, branchName string) error {
+	if out, err := exec.Command("git", "-C", repoPath, "checkout", target).CombinedOutput(); err != nil {
+		return fmt.Errorf("git checkout %s in %s: %w\n%s", target, repoPath, err, out)
 	}
 	out, err := exec.Command("git", "-C", repoPath, "merge", "--ff-only", branchName).CombinedOutput()
 	if err != nil {
@@ -43,6 +117,87 @@ func FFMerge(repoPath, branchName string) error {
 	return nil
 }
 
+// MergeNoFF checks out target in repoPath and merges branchName into it with
+// an explicit merge commit carrying message, even when a fast-forward would
+// be possible. config holds extra "-c key=value" arguments for git, such as
+// the committer identity. A failed merge is aborted so the checkout is not
+// left mid-merge.
+func MergeNoFF(repoPath, target, branchName, message string, config ...string) error {
+	if out, err := exec.Command("git", "-C", repoPath, "checkout", target).CombinedOutput(); err != nil {
+		return fmt.Errorf("git checkout %s in %s: %w\n%s", target, repoPath, err, out)
+	}
+	args := append([]string{"-C", repoPath}, config...)
+	args = append(args, "merge", "--no-ff", "-m", message, branchName)
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		exec.Command("git", "-C", repoPath, "merge", "--abort").Run()
+		return fmt.Errorf("git merge --no-ff %s in %s: %w\n%s", branchName, repoPath, err, out)
+	}
+	return nil
+}
+
+// PullFFOnly checks out branch in repoPath and fast-forwards it to the tip
+// of origin/<branch>. If the local branch has commits the remote does not,
+// it returns ErrDiverged instead of creating a merge commit.
+func PullFFOnly(repoPath, branch string) error {
+	if out, err := exec.Command("git", "-C", repoPath, "checkout", branch).CombinedOutput(); err != nil {
+		return fmt.Errorf("git checkout %s in %s: %w\n%s", branch, repoPath, err, out)
+	}
+	out, err := exec.Command("git", "-C", repoPath, "pull", "--ff-only", "origin", branch).CombinedOutput()
+	if err != nil {
+		s := string(out)
+		if strings.Contains(s, "Not possible to fast-forward") ||
+			strings.Contains(s, "diverging branches") ||
+			strings.Contains(s, "not possible to fast-forward") {
+			return fmt.Errorf("%w: %s in %s", ErrDiverged, branch, repoPath)
+		}
+		return fmt.Errorf("git pull --ff-only origin %s in %s: %w\n%s", branch, repoPath, err, out)
+	}
+	return nil
+}
+
+// PullRebase checks out branch in repoPath and rebases its local commits
+// onto origin/<branch>. On conflicts the rebase is aborted and ErrConflict
+// is returned.
+func PullRebase(repoPath, branch string) error {
+	if out, err := exec.Command("git", "-C", repoPath, "checkout", branch).CombinedOutput(); err != nil {
+		return fmt.Errorf("git checkout %s in %s: %w\n%s", branch, repoPath, err, out)
+	}
+	out, err := exec.Command("git", "-C", repoPath, "pull", "--rebase", "origin", branch).CombinedOutput()
+	if err != nil {
+		exec.Command("git", "-C", repoPath, "rebase", "--abort").Run()
+		if IsConflictOutput(string(out)) {
+			return &ConflictError{Path: repoPath}
+		}
+		return fmt.Errorf("git pull --rebase origin %s in %s: %w\n%s", branch, repoPath, err, out)
+	}
+	return nil
+}
+
+// Push pushes branch of repoPath to the same branch on remote. A rejected
+// non-fast-forward update returns ErrPushRejected.
+func Push(repoPath, remote, branch string) error {
+	out, err := exec.Command("git", "-C", repoPath, "push", remote, branch+":"+branch).CombinedOutput()
+	if err != nil {
+		s := string(out)
+		if strings.Contains(s, "non-fast-forward") || strings.Contains(s, "fetch first") {
+			return fmt.Errorf("%w: %s to %s in %s", ErrPushRejected, branch, remote, repoPath)
+		}
+		return fmt.Errorf("git push %s %s in %s: %w\n%s", remote, branch, repoPath, err, out)
+	}
+	return nil
+}
+
+// ForcePush pushes branch of repoPath to remote, replacing the remote
+// branch as long as it still points where this repo last saw it.
+func ForcePush(repoPath, remote, branch string) error {
+	out, err := exec.Command("git", "-C", repoPath, "push", "--force-with-lease", remote, branch+":"+branch).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git push --force-with-lease %s %s in %s: %w\n%s", remote, branch, repoPath, err, out)
+	}
+	return nil
+}
+
 // CommitsBehind returns the number of commits the default branch has ahead of
 // the worktree's HEAD (i.e. how many commits the task branch is behind).
 func CommitsBehind(repoPath, worktreePath string) (int, error) {
@@ -50,9 +205,15 @@ func CommitsBehind(repoPath, worktreePath string) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	return CommitsBehindBranch(worktreePath, defBranch)
+}
+
+// CommitsBehindBranch returns the number of commits target has ahead of the
+// worktree's HEAD.
+func CommitsBehindBranch(worktreePath, target string) (int, error) {
 	out, err := exec.Command(
 		"git", "-C", worktreePath,
-		"rev-list", "--count", "HEAD.."+defBranch,
+		"rev-list", "--count", "HEAD.."+target,
 	).Output()
 	if err != nil {
 		return 0, fmt.Errorf("git rev-list in %s: %w", worktreePath, err)
@@ -74,6 +235,29 @@ func HasCommitsAheadOf(worktreePath, baseBranch string) (bool, error) {
 	return n > 0, nil
 }
 
+// SquashOnto replaces the commits between base and HEAD in worktreePath with
+// a single commit on top of base carrying message. The new commit reuses
+// HEAD's tree, so the combined diff is unchanged; the index and working tree
+// are left alone. config holds extra "-c key=value" arguments for git, such
+// as the committer identity.
+func SquashOnto(worktreePath, base, message string, config ...string) error {
+	tree, err := exec.Command("git", "-C", worktreePath, "rev-parse", "HEAD^{tree}").Output()
+	if err != nil {
+		return fmt.Errorf("git rev-parse HEAD^{tree} in %s: %w", worktreePath, err)
+	}
+	args := append([]string{"-C", worktreePath}, config...)
+	args = append(args, "commit-tree", strings.TrimSpace(string(tree)), "-p", base, "-m", message)
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return fmt.Errorf("git commit-tree in %s: %w", worktreePath, err)
+	}
+	commit := strings.TrimSpace(string(out))
+	if out, err := exec.Command("git", "-C", worktreePath, "reset", "--soft", commit).CombinedOutput(); err != nil {
+		return fmt.Errorf("git reset --soft in %s: %w\n%s", worktreePath, err, out)
+	}
+	return nil
+}
+
 // MergeBase returns the best common ancestor (merge-base) of two refs,
 // evaluated in the given repository/worktree path.
 func MergeBase(repoPath, ref1, ref2 string) (string, error) {
@@ -84,9 +268,145 @@ func MergeBase(repoPath, ref1, ref2 string) (string, error) {
 	return strings.TrimSpace(string(out)), nil
 }
 
+// ConflictMarkers returns the "path:line" locations of conflict markers
+// ("<<<<<<<") added by the changes staged in worktreePath, as found by
+// git diff --cached --check. Binary files are skipped. Only opening markers
+// are reported, since "=======" alone is also a Markdown heading underline.
+// The staged content is read from the working tree, so call it right after
+// staging everything.
+func ConflictMarkers(worktreePath string) ([]string, error) {
+	out, err := exec.Command("git", "-C", worktreePath, "-c", "core.quotePath=false",
+		"diff", "--cached", "--check").Output()
+	if err != nil && len(out) == 0 {
+		return nil, fmt.Errorf("git diff --check in %s: %w", worktreePath, err)
+	}
+	const suffix = ": leftover conflict marker"
+	var locations []string
+	files := map[string][]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		loc, ok := strings.CutSuffix(line, suffix)
+		if !ok {
+			continue
+		}
+		i := strings.LastIndex(loc, ":")
+		if i < 0 {
+			continue
+		}
+		path := loc[:i]
+		n, err := strconv.Atoi(loc[i+1:])
+		if err != nil {
+			continue
+		}
+		lines, ok := files[path]
+		if !ok {
+			data, _ := os.ReadFile(filepath.Join(worktreePath, path))
+			lines = strings.Split(string(data), "\n")
+			files[path] = lines
+		}
+		if n >= 1 && n <= len(lines) && strings.HasPrefix(lines[n-1], "<<<<<<<") {
+			locations = append(locations, loc)
+		}
+	}
+	return locations, nil
+}
+
 // IsConflictOutput reports whether git output text indicates a merge conflict.
 func IsConflictOutput(s string) bool {
 	return strings.Contains(s, "CONFLICT") ||
 		strings.Contains(s, "Merge conflict") ||
 		strings.Contains(s, "conflict")
 }
+
+// DiffStat summarises the size of a change as reported by git --shortstat.
+type DiffStat struct {
+	FilesChanged int `json:"files_changed"`
+	Insertions   int `json:"insertions"`
+	Deletions    int `json:"deletions"`
+}
+
+// DiffShortStat returns the files changed, insertions and deletions between
+// two commits in repoPath.
+func DiffShortStat(repoPath, from, to string) (DiffStat, error) {
+	out, err := exec.Command("git", "-C", repoPath, "diff", "--shortstat", from, to).Output()
+	if err != nil {
+		return DiffStat{}, fmt.Errorf("git diff --shortstat %s %s in %s: %w", from, to, repoPath, err)
+	}
+	return parseShortStat(string(out)), nil
+}
+
+// parseShortStat parses a line such as
+// " 4 files changed, 120 insertions(+), 30 deletions(-)".
+// Either count may be absent when it is zero.
+func parseShortStat(s string) DiffStat {
+	var st DiffStat
+	for _, part := range strings.Split(strings.TrimSpace(s), ",") {
+		fields := strings.Fields(part)
+		if len(fields) < 2 {
+			continue
+		}
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(fields[1], "file"):
+			st.FilesChanged = n
+		case strings.HasPrefix(fields[1], "insertion"):
+			st.Insertions = n
+		case strings.HasPrefix(fields[1], "deletion"):
+			st.Deletions = n
+		}
+	}
+	return st
+}
+
+// RebaseInProgress reports whether a rebase is stopped midway in
+// worktreePath, e.g. left behind by an interrupted conflict resolver.
+func RebaseInProgress(worktreePath string) bool {
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		out, err := exec.Command("git", "-C", worktreePath, "rev-parse", "--git-path", dir).Output()
+		if err != nil {
+			continue
+		}
+		p := strings.TrimSpace(string(out))
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(worktreePath, p)
+		}
+		if _, err := os.Stat(p); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// AbortRebase aborts the rebase in progress in worktreePath.
+func AbortRebase(worktreePath string) error {
+	if out, err := exec.Command("git", "-C", worktreePath, "rebase", "--abort").CombinedOutput(); err != nil {
+		return fmt.Errorf("git rebase --abort in %s: %w\n%s", worktreePath, err, out)
+	}
+	return nil
+}
+
+// RewindBranch moves branch in repoPath from from back to to, failing if
+// the branch no longer points at from. A checked-out branch is moved with
+// git reset --keep, which keeps uncommitted changes in the checkout and
+// refuses to overwrite them.
+func RewindBranch(repoPath, branch, from, to string) error {
+	if cur, err := CurrentBranch(repoPath); err == nil && cur == branch {
+		head, err := GetCommitHash(repoPath)
+		if err != nil {
+			return err
+		}
+		if head != from {
+			return fmt.Errorf("%s in %s moved to %s", branch, repoPath, head)
+		}
+		if out, err := exec.Command("git", "-C", repoPath, "reset", "--keep", to).CombinedOutput(); err != nil {
+			return fmt.Errorf("git reset --keep %s in %s: %w\n%s", to, repoPath, err, out)
+		}
+		return nil
+	}
+	if out, err := exec.Command("git", "-C", repoPath, "update-ref", "refs/heads/"+branch, to, from).CombinedOutput(); err != nil {
+		return fmt.Errorf("git update-ref %s in %s: %w\n%s", branch, repoPath, err, out)
+	}
+	return nil
+}
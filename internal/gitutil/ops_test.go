@@ -2,7 +2,10 @@ package gitutil
 
 import (
 	"errors"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -25,6 +28,27 @@ func TestIsConflictOutput(t *testing.T) {
 	}
 }
 
+func TestConflictMarkers(t *testing.T) {
+	repo := setupRepo(t)
+	if locs, err := ConflictMarkers(repo); err != nil || len(locs) != 0 {
+		t.Fatalf("clean repo: ConflictMarkers = %v, %v", locs, err)
+	}
+
+	writeFile(t, filepath.Join(repo, "file.txt"), "initial\n<<<<<<< HEAD\nmain\n=======\ntask\n>>>>>>> task\n")
+	// A Markdown heading underline alone is not a conflict.
+	writeFile(t, filepath.Join(repo, "doc.md"), "Title\n=======\n")
+	writeFile(t, filepath.Join(repo, "blob.bin"), "\x00<<<<<<< HEAD\n")
+	gitRun(t, repo, "add", "-A")
+
+	locs, err := ConflictMarkers(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locs) != 1 || locs[0] != "file.txt:2" {
+		t.Errorf("ConflictMarkers = %v, want [file.txt:2]", locs)
+	}
+}
+
 func TestMergeBase(t *testing.T) {
 	t.Run("returns correct ancestor for diverged branches", func(t *testing.T) {
 		repo := setupRepo(t)
@@ -172,6 +196,83 @@ func TestRebaseOntoDefault(t *testing.T) {
 		if !errors.Is(err, ErrConflict) {
 			t.Errorf("expected ErrConflict, got %v", err)
 		}
+		var ce *ConflictError
+		if !errors.As(err, &ce) || len(ce.Files) != 1 || ce.Files[0] != "file.txt" {
+			t.Fatalf("expected conflicted files [file.txt], got %v", err)
+		}
+		if ce.Commit != "task: change file.txt" {
+			t.Errorf("expected the replayed commit subject, got %q", ce.Commit)
+		}
+		for _, want := range []string{"<<<<<<<", "main version", "task version", ">>>>>>>"} {
+			if !strings.Contains(ce.Hunks, want) {
+				t.Errorf("conflict hunks missing %q:\n%s", want, ce.Hunks)
+			}
+		}
+	})
+
+	t.Run("conflicts are left in progress by StartRebase", func(t *testing.T) {
+		repo := setupRepo(t)
+		wtDir := filepath.Join(t.TempDir(), "wt")
+		gitRun(t, repo, "worktree", "add", "-b", "task", wtDir, "HEAD")
+		t.Cleanup(func() { RemoveWorktree(repo, wtDir, "task") })
+
+		writeFile(t, filepath.Join(repo, "file.txt"), "main version\n")
+		gitRun(t, repo, "add", ".")
+		gitRun(t, repo, "commit", "-m", "main: change file.txt")
+
+		writeFile(t, filepath.Join(wtDir, "file.txt"), "task version\n")
+		gitRun(t, wtDir, "add", ".")
+		gitRun(t, wtDir, "commit", "-m", "task: change file.txt")
+
+		if err := StartRebase(wtDir, "main"); !errors.Is(err, ErrConflict) {
+			t.Fatalf("expected ErrConflict, got %v", err)
+		}
+		if !RebaseInProgress(wtDir) {
+			t.Fatal("rebase should still be in progress")
+		}
+		var ce *ConflictError
+		if err := ContinueRebase(wtDir); !errors.As(err, &ce) || len(ce.Files) != 1 {
+			t.Fatalf("continuing with unresolved files should report them, got %v", err)
+		}
+
+		writeFile(t, filepath.Join(wtDir, "file.txt"), "merged version\n")
+		gitRun(t, wtDir, "add", "file.txt")
+		if err := ContinueRebase(wtDir); err != nil {
+			t.Fatalf("ContinueRebase: %v", err)
+		}
+		if RebaseInProgress(wtDir) {
+			t.Fatal("rebase still in progress after continuing")
+		}
+		if got := gitRun(t, wtDir, "log", "-1", "--format=%s"); got != "task: change file.txt" {
+			t.Errorf("HEAD subject = %q", got)
+		}
+	})
+
+	t.Run("strategy option settles conflicting hunks", func(t *testing.T) {
+		repo := setupRepo(t)
+		wtDir := filepath.Join(t.TempDir(), "wt")
+		gitRun(t, repo, "worktree", "add", "-b", "task", wtDir, "HEAD")
+		t.Cleanup(func() { RemoveWorktree(repo, wtDir, "task") })
+
+		writeFile(t, filepath.Join(repo, "file.txt"), "main version\n")
+		gitRun(t, repo, "add", ".")
+		gitRun(t, repo, "commit", "-m", "main: change file.txt")
+
+		writeFile(t, filepath.Join(wtDir, "file.txt"), "task version\n")
+		gitRun(t, wtDir, "add", ".")
+		gitRun(t, wtDir, "commit", "-m", "task: change file.txt")
+
+		// While rebasing, "theirs" is the commit being replayed.
+		if err := RebaseOntoDefault(repo, wtDir, "-X", "theirs"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := os.ReadFile(filepath.Join(wtDir, "file.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "task version\n" {
+			t.Errorf("file.txt = %q, want the task's version", got)
+		}
 	})
 }
 
@@ -206,3 +307,231 @@ func TestFFMerge(t *testing.T) {
 		}
 	})
 }
+
+func TestSquashOnto(t *testing.T) {
+	repo := setupRepo(t)
+	base := gitRun(t, repo, "rev-parse", "HEAD")
+	gitRun(t, repo, "checkout", "-b", "task")
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeFile(t, filepath.Join(repo, name), name+"\n")
+		gitRun(t, repo, "add", ".")
+		gitRun(t, repo, "commit", "-m", "add "+name)
+	}
+	before := gitRun(t, repo, "diff", base, "HEAD")
+
+	if err := SquashOnto(repo, base, "task: add three files", "-c", "user.name=Squasher"); err != nil {
+		t.Fatalf("SquashOnto: %v", err)
+	}
+	if n := gitRun(t, repo, "rev-list", "--count", base+"..HEAD"); n != "1" {
+		t.Errorf("commits after squash = %s, want 1", n)
+	}
+	if got := gitRun(t, repo, "log", "-1", "--format=%s|%cn"); got != "task: add three files|Squasher" {
+		t.Errorf("squashed commit = %q", got)
+	}
+	if after := gitRun(t, repo, "diff", base, "HEAD"); after != before {
+		t.Errorf("combined diff changed by squash:\n%s\nvs\n%s", before, after)
+	}
+	if st := gitRun(t, repo, "status", "--porcelain"); st != "" {
+		t.Errorf("worktree dirty after squash: %q", st)
+	}
+}
+
+func TestMergeNoFF(t *testing.T) {
+	repo := setupRepo(t)
+	base := gitRun(t, repo, "rev-parse", "HEAD")
+	gitRun(t, repo, "checkout", "-b", "task")
+	writeFile(t, filepath.Join(repo, "task.txt"), "task\n")
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-m", "task commit")
+	taskHead := gitRun(t, repo, "rev-parse", "HEAD")
+
+	if err := MergeNoFF(repo, "main", "task", "Merge task"); err != nil {
+		t.Fatalf("MergeNoFF: %v", err)
+	}
+	if got := gitRun(t, repo, "rev-parse", "main^@"); got != base+"\n"+taskHead {
+		t.Errorf("merge commit parents = %q, want %s and %s", got, base, taskHead)
+	}
+	if got := gitRun(t, repo, "log", "-1", "--format=%s", "main"); got != "Merge task" {
+		t.Errorf("merge commit subject = %q", got)
+	}
+}
+
+// setupClone creates a bare origin seeded from a fresh repo and returns
+// (origin, clone) paths. The clone tracks origin/main.
+func setupClone(t *testing.T) (string, string) {
+	t.Helper()
+	src := setupRepo(t)
+	origin := filepath.Join(t.TempDir(), "origin.git")
+	gitRun(t, src, "clone", "--bare", src, origin)
+	clone := filepath.Join(t.TempDir(), "clone")
+	gitRun(t, src, "clone", origin, clone)
+	gitRun(t, clone, "config", "user.email", "test@example.com")
+	gitRun(t, clone, "config", "user.name", "Test")
+	return origin, clone
+}
+
+// pushFromOther commits a file in a second clone of origin and pushes it.
+func pushFromOther(t *testing.T, origin, name string) {
+	t.Helper()
+	other := filepath.Join(t.TempDir(), "other")
+	gitRun(t, origin, "clone", origin, other)
+	gitRun(t, other, "config", "user.email", "test@example.com")
+	gitRun(t, other, "config", "user.name", "Test")
+	writeFile(t, filepath.Join(other, name), "remote\n")
+	gitRun(t, other, "add", ".")
+	gitRun(t, other, "commit", "-m", "remote change")
+	gitRun(t, other, "push", "origin", "main")
+}
+
+func TestPullFFOnly(t *testing.T) {
+	t.Run("fast-forwards to the remote tip", func(t *testing.T) {
+		origin, clone := setupClone(t)
+		pushFromOther(t, origin, "r.txt")
+
+		if err := PullFFOnly(clone, "main"); err != nil {
+			t.Fatalf("PullFFOnly: %v", err)
+		}
+		want := gitRun(t, origin, "rev-parse", "main")
+		if got := gitRun(t, clone, "rev-parse", "main"); got != want {
+			t.Errorf("main = %s, want %s", got, want)
+		}
+	})
+
+	t.Run("returns ErrDiverged when local has diverged", func(t *testing.T) {
+		origin, clone := setupClone(t)
+		pushFromOther(t, origin, "r.txt")
+		writeFile(t, filepath.Join(clone, "l.txt"), "local\n")
+		gitRun(t, clone, "add", ".")
+		gitRun(t, clone, "commit", "-m", "local change")
+
+		err := PullFFOnly(clone, "main")
+		if !errors.Is(err, ErrDiverged) {
+			t.Fatalf("PullFFOnly error = %v, want ErrDiverged", err)
+		}
+	})
+}
+
+func TestPushAndPullRebase(t *testing.T) {
+	origin, clone := setupClone(t)
+	writeFile(t, filepath.Join(clone, "l.txt"), "local\n")
+	gitRun(t, clone, "add", ".")
+	gitRun(t, clone, "commit", "-m", "local change")
+	pushFromOther(t, origin, "r.txt")
+
+	if err := Push(clone, "origin", "main"); !errors.Is(err, ErrPushRejected) {
+		t.Fatalf("Push error = %v, want ErrPushRejected", err)
+	}
+	if err := PullRebase(clone, "main"); err != nil {
+		t.Fatalf("PullRebase: %v", err)
+	}
+	if err := Push(clone, "origin", "main"); err != nil {
+		t.Fatalf("Push after rebase: %v", err)
+	}
+	if got, want := gitRun(t, origin, "rev-parse", "main"), gitRun(t, clone, "rev-parse", "main"); got != want {
+		t.Errorf("origin main = %s, want %s", got, want)
+	}
+}
+
+func TestHasRemote(t *testing.T) {
+	_, clone := setupClone(t)
+	if !HasRemote(clone, "origin") {
+		t.Error("expected clone to have origin remote")
+	}
+	if HasRemote(setupRepo(t), "origin") {
+		t.Error("expected fresh repo to have no origin remote")
+	}
+}
+
+func TestDiffShortStat(t *testing.T) {
+	repo := setupRepo(t)
+	base := gitRun(t, repo, "rev-parse", "HEAD")
+	writeFile(t, filepath.Join(repo, "file.txt"), "changed\nadded\n")
+	writeFile(t, filepath.Join(repo, "new.txt"), "one\ntwo\nthree\n")
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-m", "change")
+
+	st, err := DiffShortStat(repo, base, "HEAD")
+	if err != nil {
+		t.Fatalf("DiffShortStat: %v", err)
+	}
+	if want := (DiffStat{FilesChanged: 2, Insertions: 5, Deletions: 1}); st != want {
+		t.Errorf("DiffShortStat = %+v, want %+v", st, want)
+	}
+}
+
+func TestParseShortStat(t *testing.T) {
+	cases := []struct {
+		in   string
+		want DiffStat
+	}{
+		{" 4 files changed, 120 insertions(+), 30 deletions(-)\n", DiffStat{4, 120, 30}},
+		{" 1 file changed, 1 insertion(+)", DiffStat{1, 1, 0}},
+		{" 1 file changed, 2 deletions(-)", DiffStat{1, 0, 2}},
+		{"", DiffStat{}},
+	}
+	for _, c := range cases {
+		if got := parseShortStat(c.in); got != c.want {
+			t.Errorf("parseShortStat(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestAbortRebaseInProgress(t *testing.T) {
+	repo := setupRepo(t)
+	gitRun(t, repo, "checkout", "-b", "task")
+	writeFile(t, filepath.Join(repo, "file.txt"), "task\n")
+	gitRun(t, repo, "commit", "-am", "task edit")
+	gitRun(t, repo, "checkout", "main")
+	writeFile(t, filepath.Join(repo, "file.txt"), "main\n")
+	gitRun(t, repo, "commit", "-am", "main edit")
+	gitRun(t, repo, "checkout", "task")
+
+	if RebaseInProgress(repo) {
+		t.Fatal("RebaseInProgress = true before rebasing")
+	}
+	if err := exec.Command("git", "-C", repo, "rebase", "main").Run(); err == nil {
+		t.Fatal("expected the rebase to stop on a conflict")
+	}
+	if !RebaseInProgress(repo) {
+		t.Fatal("RebaseInProgress = false while stopped on a conflict")
+	}
+	if err := AbortRebase(repo); err != nil {
+		t.Fatalf("AbortRebase: %v", err)
+	}
+	if RebaseInProgress(repo) {
+		t.Error("RebaseInProgress = true after AbortRebase")
+	}
+}
+
+func TestRewindBranch(t *testing.T) {
+	repo := setupRepo(t)
+	base := gitRun(t, repo, "rev-parse", "HEAD")
+	writeFile(t, filepath.Join(repo, "new.txt"), "new\n")
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-m", "merged")
+	merged := gitRun(t, repo, "rev-parse", "HEAD")
+	gitRun(t, repo, "branch", "other")
+
+	// Not checked out: moved with update-ref, guarded by the old value.
+	if err := RewindBranch(repo, "other", base, base); err == nil {
+		t.Error("RewindBranch succeeded although the branch is not at from")
+	}
+	if err := RewindBranch(repo, "other", merged, base); err != nil {
+		t.Fatalf("RewindBranch other: %v", err)
+	}
+	if got := gitRun(t, repo, "rev-parse", "other"); got != base {
+		t.Errorf("other = %s, want %s", got, base)
+	}
+
+	// Checked out: uncommitted changes in the checkout survive.
+	writeFile(t, filepath.Join(repo, "file.txt"), "local edit\n")
+	if err := RewindBranch(repo, "main", merged, base); err != nil {
+		t.Fatalf("RewindBranch main: %v", err)
+	}
+	if got := gitRun(t, repo, "rev-parse", "main"); got != base {
+		t.Errorf("main = %s, want %s", got, base)
+	}
+	if got := gitRun(t, repo, "status", "--porcelain", "file.txt"); got != "M file.txt" {
+		t.Errorf("local edit lost: status %q", got)
+	}
+}
@@ -4,17 +4,66 @@ import (
 	"errors"
 	"fmt"
 	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
 // ErrConflict is returned by RebaseOntoDefault when a merge conflict is detected.
 var ErrConflict = errors.New("rebase conflict")
 
+// ConflictError is the ErrConflict returned by RebaseOnto. It lists the
+// files that were left conflicted when the rebase stopped, and the commit
+// being replayed with its conflict hunks.
+type ConflictError struct {
+	Path   string   // worktree the rebase ran in
+	Files  []string // conflicted paths, sorted as git reports them
+	Commit string   // subject of the commit that failed to apply, if known
+	Hunks  string   // combined diff of the conflicted files, capped at MaxConflictHunks
+}
+
+// MaxConflictHunks caps ConflictError.Hunks so a conflict in a large
+// generated file cannot bloat the event log.
+const MaxConflictHunks = 64 << 10
+
+func (e *ConflictError) Error() string { return fmt.Sprintf("%v in %s", ErrConflict, e.Path) }
+
+func (e *ConflictError) Unwrap() error { return ErrConflict }
+
+// ErrDiverged is returned by PullFFOnly when the local branch cannot be
+// fast-forwarded to its remote counterpart.
+var ErrDiverged = errors.New("local branch has diverged from remote")
+
+// ErrPushRejected is returned by Push when the remote refuses the update
+// because it is not a fast-forward of the remote branch.
+var ErrPushRejected = errors.New("push rejected: non-fast-forward")
+
 // IsGitRepo reports whether path is inside a git repository.
 func IsGitRepo(path string) bool {
 	return exec.Command("git", "-C", path, "rev-parse", "--git-dir").Run() == nil
 }
 
+// GitCommonDir returns the absolute path of the directory holding the
+// objects and refs of the repository at path. For a plain repository that
+// is its .git directory; for a linked worktree, a repository created with
+// --separate-git-dir, or a worktree of a bare repository it lies elsewhere.
+func GitCommonDir(path string) (string, error) {
+	out, err := exec.Command("git", "-C", path, "rev-parse", "--git-common-dir").Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse --git-common-dir in %s: %w", path, err)
+	}
+	dir := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(path, dir)
+	}
+	return canonicalPath(dir), nil
+}
+
+// IsValidBranchName reports whether name is acceptable as a git branch name,
+// as checked by git check-ref-format.
+func IsValidBranchName(name string) bool {
+	return exec.Command("git", "check-ref-format", "--branch", name).Run() == nil
+}
+
 // DefaultBranch returns the default branch name for a repo (tries origin/HEAD,
 // falls back to the current local HEAD branch, then "main").
 func DefaultBranch(repoPath string) (string, error) {
@@ -39,6 +88,39 @@ func DefaultBranch(repoPath string) (string, error) {
 	return branch, nil
 }
 
+// HasRemote reports whether repoPath has a remote with the given name.
+func HasRemote(repoPath, remote string) bool {
+	return exec.Command("git", "-C", repoPath, "remote", "get-url", remote).Run() == nil
+}
+
+// RemoteURL returns the URL of the named remote of repoPath.
+func RemoteURL(repoPath, remote string) (string, error) {
+	out, err := exec.Command("git", "-C", repoPath, "remote", "get-url", remote).Output()
+	if err != nil {
+		return "", fmt.Errorf("git remote get-url %s in %s: %w", remote, repoPath, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// CurrentBranch returns the branch checked out in repoPath, or an error when
+// HEAD is detached.
+func CurrentBranch(repoPath string) (string, error) {
+	out, err := exec.Command("git", "-C", repoPath, "branch", "--show-current").Output()
+	if err != nil {
+		return "", fmt.Errorf("git branch --show-current in %s: %w", repoPath, err)
+	}
+	branch := strings.TrimSpace(string(out))
+	if branch == "" {
+		return "", fmt.Errorf("detached HEAD in %s", repoPath)
+	}
+	return branch, nil
+}
+
+// BranchExists reports whether a local branch with the given name exists.
+func BranchExists(repoPath, branch string) bool {
+	return exec.Command("git", "-C", repoPath, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch).Run() == nil
+}
+
 // GetCommitHash returns the current HEAD commit hash in repoPath.
 func GetCommitHash(repoPath string) (string, error) {
 	out, err := exec.Command("git", "-C", repoPath, "rev-parse", "HEAD").Output()
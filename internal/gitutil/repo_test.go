@@ -21,6 +21,34 @@ func TestIsGitRepo(t *testing.T) {
 	}
 }
 
+func TestGitCommonDir(t *testing.T) {
+	repo := setupRepo(t)
+	want, _ := filepath.EvalSymlinks(filepath.Join(repo, ".git"))
+	if got, err := GitCommonDir(repo); err != nil || got != want {
+		t.Errorf("GitCommonDir(repo) = %q, %v; want %q", got, err, want)
+	}
+
+	// A linked worktree shares its repository's git directory.
+	wt := filepath.Join(t.TempDir(), "wt")
+	gitRun(t, repo, "worktree", "add", "-b", "task", wt)
+	if got, err := GitCommonDir(wt); err != nil || got != want {
+		t.Errorf("GitCommonDir(worktree) = %q, %v; want %q", got, err, want)
+	}
+
+	// With GIT_DIR elsewhere the git directory is outside the work tree.
+	gitDir := filepath.Join(t.TempDir(), "sep.git")
+	sep := t.TempDir()
+	gitRun(t, sep, "init", "--separate-git-dir", gitDir)
+	want, _ = filepath.EvalSymlinks(gitDir)
+	if got, err := GitCommonDir(sep); err != nil || got != want {
+		t.Errorf("GitCommonDir(separate git dir) = %q, %v; want %q", got, err, want)
+	}
+
+	if _, err := GitCommonDir(t.TempDir()); err == nil {
+		t.Error("GitCommonDir on a plain directory succeeded")
+	}
+}
+
 func TestDefaultBranch(t *testing.T) {
 	t.Run("local HEAD branch without remote", func(t *testing.T) {
 		repo := setupRepo(t)
@@ -65,6 +93,19 @@ func TestDefaultBranch(t *testing.T) {
 	})
 }
 
+func TestCurrentBranch(t *testing.T) {
+	repo := setupRepo(t)
+	gitRun(t, repo, "checkout", "-b", "develop")
+	if branch, err := CurrentBranch(repo); err != nil || branch != "develop" {
+		t.Fatalf("CurrentBranch = %q, %v; want develop", branch, err)
+	}
+
+	gitRun(t, repo, "checkout", "--detach")
+	if _, err := CurrentBranch(repo); err == nil {
+		t.Error("expected error for detached HEAD")
+	}
+}
+
 func TestGetCommitHashForRef(t *testing.T) {
 	t.Run("returns main HEAD when on different branch", func(t *testing.T) {
 		repo := setupRepo(t)
@@ -0,0 +1,60 @@
+package gitutil
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// SnapshotDiff returns a unified diff from originalDir to snapshotDir, where
+// snapshotDir is a non-git workspace copy tracked by its own local repo.
+// Both directories are hashed into trees of the snapshot's object store
+// through a throwaway index, so the comparison is file by file against the
+// original's current contents, ignores the snapshot's .git directory, and
+// reports binary files as "Binary files ... differ" rather than their bytes.
+// Extra arguments, such as "--stat" or a "--" pathspec, are passed to git
+// diff after the two trees.
+func SnapshotDiff(snapshotDir, originalDir string, args ...string) ([]byte, error) {
+	gitDir := filepath.Join(snapshotDir, ".git")
+	tmp, err := os.MkdirTemp("", "wallfacer-snapdiff-")
+	if err != nil {
+		return nil, fmt.Errorf("create temp index dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	from, err := dirTree(gitDir, originalDir, filepath.Join(tmp, "original"))
+	if err != nil {
+		return nil, err
+	}
+	to, err := dirTree(gitDir, snapshotDir, filepath.Join(tmp, "snapshot"))
+	if err != nil {
+		return nil, err
+	}
+	diffArgs := append([]string{"--git-dir", gitDir, "diff", "--no-color", "--no-ext-diff", from, to}, args...)
+	out, err := exec.Command("git", diffArgs...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff %s %s in %s: %w", from, to, snapshotDir, err)
+	}
+	return out, nil
+}
+
+// dirTree stages every file in workTree into a fresh index at indexPath and
+// writes it as a tree object in gitDir, returning the tree hash.
+func dirTree(gitDir, workTree, indexPath string) (string, error) {
+	env := append(os.Environ(), "GIT_INDEX_FILE="+indexPath)
+	add := exec.Command("git", "--git-dir", gitDir, "--work-tree", workTree, "add", "-A", ".")
+	add.Dir = workTree
+	add.Env = env
+	if out, err := add.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("git add in %s: %w\n%s", workTree, err, out)
+	}
+	write := exec.Command("git", "--git-dir", gitDir, "write-tree")
+	write.Env = env
+	out, err := write.Output()
+	if err != nil {
+		return "", fmt.Errorf("git write-tree for %s: %w", workTree, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,45 @@
+package gitutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotDiff(t *testing.T) {
+	original := t.TempDir()
+	writeFile(t, filepath.Join(original, "keep.txt"), "same\n")
+	writeFile(t, filepath.Join(original, "edit.txt"), "before\n")
+	writeFile(t, filepath.Join(original, "gone.txt"), "bye\n")
+	writeFile(t, filepath.Join(original, "blob.bin"), "\x00\x01\x02")
+
+	snapshot := t.TempDir()
+	gitRun(t, snapshot, "init")
+	writeFile(t, filepath.Join(snapshot, "keep.txt"), "same\n")
+	writeFile(t, filepath.Join(snapshot, "edit.txt"), "after\n")
+	writeFile(t, filepath.Join(snapshot, "new.txt"), "hello\n")
+	writeFile(t, filepath.Join(snapshot, "blob.bin"), "\x00\x03\x04")
+
+	out, err := SnapshotDiff(snapshot, original)
+	if err != nil {
+		t.Fatalf("SnapshotDiff: %v", err)
+	}
+	diff := string(out)
+	for _, want := range []string{
+		"-before", "+after",
+		"+hello",
+		"deleted file mode", "-bye",
+		"Binary files a/blob.bin and b/blob.bin differ",
+	} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("diff missing %q:\n%s", want, diff)
+		}
+	}
+	if strings.Contains(diff, "keep.txt") || strings.Contains(diff, ".git/") {
+		t.Errorf("diff includes unchanged or tracking files:\n%s", diff)
+	}
+	if _, err := os.Stat(filepath.Join(original, ".git")); !os.IsNotExist(err) {
+		t.Error("SnapshotDiff must not create a repo in the original directory")
+	}
+}
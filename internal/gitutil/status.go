@@ -52,3 +52,13 @@ func WorkspaceStatus(path string) WorkspaceGitStatus {
 
 	return s
 }
+
+// StatusPorcelain returns `git status --porcelain` for path, listing every
+// untracked file rather than only their directories.
+func StatusPorcelain(path string) (string, error) {
+	out, err := exec.Command("git", "-C", path, "status", "--porcelain", "--untracked-files=all").Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
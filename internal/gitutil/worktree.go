@@ -2,10 +2,43 @@ package gitutil
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+
+	"changkun.de/wallfacer/internal/logger"
 )
 
+// HasCommits reports whether HEAD in repoPath points at a commit. It does
+// not in a freshly initialised repository, whose branch is still unborn.
+func HasCommits(repoPath string) bool {
+	return exec.Command("git", "-C", repoPath, "rev-parse", "--verify", "--quiet", "HEAD^{commit}").Run() == nil
+}
+
+// CreateInitialCommit gives the unborn branch of HEAD in repoPath an empty
+// root commit, so worktrees can branch from HEAD. Only the branch ref is
+// written: the index and working tree are left as they are, so staged and
+// untracked files stay staged and untracked. It fails if the branch gained a
+// commit in the meantime, or if git has no committer identity.
+func CreateInitialCommit(repoPath string) error {
+	tree, err := exec.Command("git", "-C", repoPath, "mktree").Output()
+	if err != nil {
+		return fmt.Errorf("git mktree in %s: %w", repoPath, err)
+	}
+	out, err := exec.Command("git", "-C", repoPath, "commit-tree", strings.TrimSpace(string(tree)), "-m", "Initial commit").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git commit-tree in %s: %w\n%s", repoPath, err, out)
+	}
+	commit := strings.TrimSpace(string(out))
+	// The empty old value makes update-ref refuse to overwrite an existing
+	// branch; HEAD is followed to the branch it names.
+	if out, err := exec.Command("git", "-C", repoPath, "update-ref", "-m", "wallfacer: initial commit", "HEAD", commit, "").CombinedOutput(); err != nil {
+		return fmt.Errorf("git update-ref HEAD in %s: %w\n%s", repoPath, err, out)
+	}
+	return nil
+}
+
 // CreateWorktree creates a new branch and checks it out as a worktree at worktreePath.
 // If branchName already exists (e.g. the worktree directory was lost after a server
 // restart but the branch was preserved), it checks out the existing branch instead.
@@ -45,7 +78,14 @@ func CreateWorktree(repoPath, worktreePath, branchName string) error {
 }
 
 // RemoveWorktree removes a worktree and deletes the associated branch.
+// The branch is only force-deleted when git's worktree registry confirms it
+// was checked out in worktreePath; otherwise deletion is skipped with a
+// warning so a stale or colliding branch name cannot destroy unrelated work.
 func RemoveWorktree(repoPath, worktreePath, branchName string) error {
+	// Look up the registered branch before removal, while the entry still
+	// exists (a missing directory stays listed as prunable until pruned).
+	registered, found := worktreeBranch(repoPath, worktreePath)
+
 	out, err := exec.Command(
 		"git", "-C", repoPath,
 		"worktree", "remove", "--force", worktreePath,
@@ -61,8 +101,73 @@ func RemoveWorktree(repoPath, worktreePath, branchName string) error {
 			return fmt.Errorf("git worktree remove %s: %w\n%s", worktreePath, err, out)
 		}
 	}
-	// Delete the branch (best-effort) — always attempted so stale branches
-	// are cleaned up even when the worktree directory was already missing.
+	if !found || registered != branchName {
+		if BranchExists(repoPath, branchName) {
+			logger.Git.Warn("not deleting branch: cannot confirm it belongs to worktree",
+				"repo", repoPath, "worktree", worktreePath,
+				"branch", branchName, "registered", registered)
+		}
+		return nil
+	}
+	// Delete the branch (best-effort) — attempted even when the worktree
+	// directory was already missing so stale branches are cleaned up.
 	exec.Command("git", "-C", repoPath, "branch", "-D", branchName).Run()
 	return nil
 }
+
+// HasSubmodules reports whether the checkout at path declares submodules in
+// a .gitmodules file.
+func HasSubmodules(path string) bool {
+	_, err := os.Stat(filepath.Join(path, ".gitmodules"))
+	return err == nil
+}
+
+// InitSubmodules checks out the submodules of the worktree at worktreePath,
+// recursively. It is a no-op when the worktree has no .gitmodules.
+func InitSubmodules(worktreePath string) error {
+	if !HasSubmodules(worktreePath) {
+		return nil
+	}
+	out, err := exec.Command("git", "-C", worktreePath, "submodule", "update", "--init", "--recursive").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git submodule update in %s: %w\n%s", worktreePath, err, out)
+	}
+	return nil
+}
+
+// worktreeBranch returns the branch registered for worktreePath according to
+// "git worktree list --porcelain". found is false when the path is not a
+// registered worktree; branch is empty for a detached HEAD.
+func worktreeBranch(repoPath, worktreePath string) (branch string, found bool) {
+	out, err := exec.Command("git", "-C", repoPath, "worktree", "list", "--porcelain").Output()
+	if err != nil {
+		return "", false
+	}
+	want := canonicalPath(worktreePath)
+	inEntry := false
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "worktree "):
+			if found {
+				return branch, true
+			}
+			inEntry = canonicalPath(strings.TrimPrefix(line, "worktree ")) == want
+			found = inEntry
+		case inEntry && strings.HasPrefix(line, "branch "):
+			branch = strings.TrimPrefix(strings.TrimPrefix(line, "branch "), "refs/heads/")
+		}
+	}
+	return branch, found
+}
+
+// canonicalPath resolves symlinks where possible (e.g. /var → /private/var on
+// macOS) so paths reported by git compare equal to the ones we passed in.
+func canonicalPath(p string) string {
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		return resolved
+	}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(p)); err == nil {
+		return filepath.Join(dir, filepath.Base(p))
+	}
+	return filepath.Clean(p)
+}
@@ -3,6 +3,7 @@ package gitutil
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -43,6 +44,40 @@ func TestCreateWorktree(t *testing.T) {
 	})
 }
 
+func TestCreateInitialCommit(t *testing.T) {
+	repo := t.TempDir()
+	gitRun(t, repo, "init", "-b", "trunk")
+	gitRun(t, repo, "config", "user.email", "test@example.com")
+	gitRun(t, repo, "config", "user.name", "Test")
+	writeFile(t, filepath.Join(repo, "staged.txt"), "staged\n")
+	gitRun(t, repo, "add", "staged.txt")
+
+	if HasCommits(repo) {
+		t.Fatal("HasCommits = true for a fresh repository")
+	}
+	if err := CreateInitialCommit(repo); err != nil {
+		t.Fatal(err)
+	}
+	if !HasCommits(repo) {
+		t.Fatal("HasCommits = false after CreateInitialCommit")
+	}
+	if files := gitRun(t, repo, "ls-tree", "-r", "--name-only", "trunk"); files != "" {
+		t.Errorf("initial commit on trunk has files %q, want none", files)
+	}
+	if status := gitRun(t, repo, "status", "--porcelain"); status != "A  staged.txt" {
+		t.Errorf("status = %q, want the file still staged", status)
+	}
+	if err := CreateInitialCommit(repo); err == nil {
+		t.Error("second CreateInitialCommit succeeded, want it to refuse to move the branch")
+	}
+
+	wtDir := filepath.Join(t.TempDir(), "wt")
+	if err := CreateWorktree(repo, wtDir, "task"); err != nil {
+		t.Fatalf("CreateWorktree after the initial commit: %v", err)
+	}
+	t.Cleanup(func() { RemoveWorktree(repo, wtDir, "task") })
+}
+
 func TestRemoveWorktree(t *testing.T) {
 	t.Run("removes existing worktree and branch", func(t *testing.T) {
 		repo := setupRepo(t)
@@ -56,6 +91,9 @@ func TestRemoveWorktree(t *testing.T) {
 		if _, err := os.Stat(wtDir); !os.IsNotExist(err) {
 			t.Error("worktree directory still exists after removal")
 		}
+		if BranchExists(repo, "rm-branch") {
+			t.Error("branch still exists after removal")
+		}
 	})
 
 	t.Run("graceful when path was never registered", func(t *testing.T) {
@@ -77,4 +115,83 @@ func TestRemoveWorktree(t *testing.T) {
 			t.Errorf("unexpected error: %v", err)
 		}
 	})
+	t.Run("keeps branch not registered to the worktree", func(t *testing.T) {
+		repo := setupRepo(t)
+		wtDir := filepath.Join(t.TempDir(), "wt")
+		if err := CreateWorktree(repo, wtDir, "task-branch"); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+		gitRun(t, repo, "branch", "unrelated")
+		if err := RemoveWorktree(repo, wtDir, "unrelated"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if !BranchExists(repo, "unrelated") {
+			t.Error("unrelated branch was deleted")
+		}
+	})
+
+	t.Run("keeps branch when path was never registered", func(t *testing.T) {
+		repo := setupRepo(t)
+		gitRun(t, repo, "branch", "keep-me")
+		ghost := filepath.Join(t.TempDir(), "ghost")
+		if err := RemoveWorktree(repo, ghost, "keep-me"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if !BranchExists(repo, "keep-me") {
+			t.Error("branch was deleted without a registered worktree")
+		}
+	})
+
+	t.Run("deletes branch after directory deleted externally", func(t *testing.T) {
+		repo := setupRepo(t)
+		wtDir := filepath.Join(t.TempDir(), "wt")
+		if err := CreateWorktree(repo, wtDir, "gone-branch"); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+		os.RemoveAll(wtDir)
+		if err := RemoveWorktree(repo, wtDir, "gone-branch"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if BranchExists(repo, "gone-branch") {
+			t.Error("branch registered to the missing worktree was not deleted")
+		}
+	})
+}
+
+func TestInitSubmodules(t *testing.T) {
+	// Submodules are added from a local path, which git refuses by default.
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "protocol.file.allow")
+	t.Setenv("GIT_CONFIG_VALUE_0", "always")
+
+	sub := setupRepo(t)
+	repo := setupRepo(t)
+	gitRun(t, repo, "submodule", "add", sub, "lib")
+	gitRun(t, repo, "commit", "-m", "add submodule")
+
+	wtDir := filepath.Join(t.TempDir(), "wt")
+	if err := CreateWorktree(repo, wtDir, "task"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitSubmodules(wtDir); err != nil {
+		t.Fatalf("InitSubmodules: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(wtDir, "lib")); len(entries) == 0 {
+		t.Fatal("submodule directory is empty after InitSubmodules")
+	}
+
+	// Removing the worktree must leave the main checkout's submodule
+	// registration alone; the config is shared between them.
+	if err := RemoveWorktree(repo, wtDir, "task"); err != nil {
+		t.Fatalf("RemoveWorktree with initialized submodules: %v", err)
+	}
+	if status := gitRun(t, repo, "submodule", "status"); strings.HasPrefix(status, "-") {
+		t.Errorf("main checkout's submodule unregistered: %q", status)
+	}
+
+	// Worktrees without .gitmodules are left alone.
+	if err := InitSubmodules(t.TempDir()); err != nil {
+		t.Errorf("InitSubmodules without .gitmodules: %v", err)
+	}
 }
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/runner"
+)
+
+// RenormalizePositions compacts task positions within each status column to
+// 0..N-1, undoing the drift left behind by repeated reorders.
+func (h *Handler) RenormalizePositions(w http.ResponseWriter, r *http.Request) {
+	updated, err := h.store.RenormalizePositions(r.Context())
+	if err != nil {
+		logger.Handler.Error("renormalize positions", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"updated": updated})
+}
+
+// ResetClaudeConfig removes all task sandboxes, and with them the Claude
+// Code sessions and caches inside, as a recovery path when sessions stop
+// resuming. It returns 409 while any task is active.
+func (h *Handler) ResetClaudeConfig(w http.ResponseWriter, r *http.Request) {
+	removed, err := h.runner.ResetClaudeConfig()
+	if errors.Is(err, runner.ErrTasksActive) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Handler.Error("reset claude config", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"removed": removed})
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/runner"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// Actions accepted by BulkTasks.
+const (
+	bulkArchive   = "archive"
+	bulkUnarchive = "unarchive"
+	bulkDelete    = "delete"
+)
+
+// maxBulkTasks caps how many ids a single bulk request may name.
+const maxBulkTasks = 500
+
+// bulkRequest is the JSON body accepted by BulkTasks. Exactly one of IDs
+// and Status selects the tasks the action applies to.
+type bulkRequest struct {
+	Action string      `json:"action"`
+	IDs    []uuid.UUID `json:"ids"`
+	Status string      `json:"status"`
+}
+
+// bulkResult reports the outcome of a bulk action for one task.
+type bulkResult struct {
+	ID    uuid.UUID `json:"id"`
+	OK    bool      `json:"ok"`
+	Error string    `json:"error,omitempty"`
+}
+
+// errNotTerminal is reported for a task a bulk delete skipped because it is
+// still active and ?force=true was not given.
+var errNotTerminal = errors.New("only done, failed or cancelled tasks can be bulk-deleted without force=true")
+
+// terminalStatus reports whether a task in status has finished for good.
+func terminalStatus(status string) bool {
+	return status == "done" || status == "failed" || status == "cancelled"
+}
+
+// BulkTasks archives, unarchives or deletes several tasks at once, chosen
+// either by id or by status, and returns one result per task. Bulk deletes
+// are limited to terminal tasks unless ?force=true is given.
+func (h *Handler) BulkTasks(w http.ResponseWriter, r *http.Request) {
+	var req bulkRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	switch req.Action {
+	case bulkArchive, bulkUnarchive, bulkDelete:
+	default:
+		http.Error(w, "action must be archive, unarchive or delete", http.StatusBadRequest)
+		return
+	}
+	if (len(req.IDs) == 0) == (req.Status == "") {
+		http.Error(w, "exactly one of ids or status is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) > maxBulkTasks {
+		http.Error(w, "too many ids (max "+strconv.Itoa(maxBulkTasks)+")", http.StatusBadRequest)
+		return
+	}
+	if req.Status != "" && !validStatuses[req.Status] {
+		http.Error(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+	force := r.URL.Query().Get("force") == "true"
+
+	ctx := r.Context()
+	ids := req.IDs
+	if req.Status != "" {
+		tasks, _, err := h.store.ListTasksFiltered(ctx, store.TaskFilter{
+			IncludeArchived: req.Action != bulkArchive,
+			Statuses:        []string{req.Status},
+		})
+		if err != nil {
+			logger.Handler.Error("bulk list tasks", "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		for _, t := range tasks {
+			if req.Action == bulkUnarchive && !t.Archived {
+				continue
+			}
+			ids = append(ids, t.ID)
+		}
+	}
+
+	results := make([]bulkResult, 0, len(ids))
+	for _, id := range ids {
+		res := bulkResult{ID: id}
+		if err := h.bulkApply(ctx, req.Action, id, force); err != nil {
+			res.Error = err.Error()
+		} else {
+			res.OK = true
+		}
+		results = append(results, res)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"results": results})
+}
+
+// bulkApply runs action on one task with the same logic as the per-task
+// endpoints. Errors are returned in a form fit for the per-id result.
+func (h *Handler) bulkApply(ctx context.Context, action string, id uuid.UUID, force bool) error {
+	task, err := h.store.GetTask(ctx, id)
+	if err != nil {
+		return errors.New("task not found")
+	}
+	switch action {
+	case bulkArchive:
+		err = h.archiveTask(ctx, task)
+		if errors.Is(err, errNotArchivable) {
+			return err
+		}
+	case bulkUnarchive:
+		err = h.unarchiveTask(ctx, id)
+	case bulkDelete:
+		if !force && !terminalStatus(task.Status) {
+			return errNotTerminal
+		}
+		// Forced deletes stop an active task first, as deleting it
+		// from the board does.
+		err = h.runner.DeleteTask(id)
+		if errors.Is(err, runner.ErrCommitLanded) {
+			return err
+		}
+	}
+	if err != nil {
+		logger.Handler.Error("bulk "+action, "task", id, "error", err)
+		return errors.New("internal server error")
+	}
+	return nil
+}
@@ -6,10 +6,12 @@ import (
 	"changkun.de/wallfacer/internal/instructions"
 )
 
-// GetConfig returns the server configuration (workspaces, instructions path).
+// GetConfig returns the server configuration (workspaces, instructions path,
+// and the models tasks may select).
 func (h *Handler) GetConfig(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{
 		"workspaces":        h.runner.Workspaces(),
 		"instructions_path": instructions.FilePath(h.configDir, h.workspaces),
+		"models":            h.runner.AllowedModels(),
 	})
 }
@@ -1,6 +1,14 @@
 package handler
 
-import "net/http"
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/runner"
+	"github.com/google/uuid"
+)
 
 // GetContainers returns the list of wallfacer sandbox containers visible to the
 // container runtime, mimicking `docker ps -a --filter name=wallfacer`.
@@ -12,3 +20,56 @@ func (h *Handler) GetContainers(w http.ResponseWriter, r *http.Request) {
 	}
 	writeJSON(w, http.StatusOK, containers)
 }
+
+// GetRunnerStatus returns how many tasks are running and how many are queued
+// behind the concurrency limit, and whether autopilot is enabled.
+func (h *Handler) GetRunnerStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.runner.Status())
+}
+
+// SetAutopilot turns autopilot on or off. The setting persists across restarts.
+func (h *Handler) SetAutopilot(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+		http.Error(w, "body must be {\"enabled\": bool}", http.StatusBadRequest)
+		return
+	}
+	if err := h.runner.SetAutopilot(*req.Enabled); err != nil {
+		logger.Handler.Error("set autopilot", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"enabled": *req.Enabled})
+}
+
+// GetRunQueue returns the IDs of the tasks waiting for a concurrency slot,
+// in the order they will start.
+func (h *Handler) GetRunQueue(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string][]uuid.UUID{"queue": h.runner.RunQueue()})
+}
+
+// SetRunQueue reorders the run queue. The body's "order" lists queued task
+// IDs to move to the front, in that order; the rest keep their relative
+// order behind them.
+func (h *Handler) SetRunQueue(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Order []uuid.UUID `json:"order"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "body must be {\"order\": [task IDs]}", http.StatusBadRequest)
+		return
+	}
+	if err := h.runner.SetRunQueue(req.Order); errors.Is(err, runner.ErrNotQueued) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		logger.Handler.Error("set run queue", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]uuid.UUID{"queue": h.runner.RunQueue()})
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// expandedEvent is a TaskEvent whose data has been decoded into the typed
+// fields documented for its event type. Returned by GetEvents when the
+// request sets ?expand=true.
+type expandedEvent struct {
+	ID        int64           `json:"id"`
+	TaskID    uuid.UUID       `json:"task_id"`
+	EventType store.EventType `json:"event_type"`
+	Data      any             `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// stateChangeData is the data of a state_change event. From is empty for a
+// newly created task. DurationMS is set when a turn ended the in_progress
+// state, to that turn's wall-clock time.
+type stateChangeData struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	DurationMS *int   `json:"duration_ms,omitempty"`
+}
+
+// outputData is the data of an output event: the result of one Claude turn
+// and, for turns recorded since durations were tracked, its wall-clock time.
+type outputData struct {
+	Result     string `json:"result"`
+	StopReason string `json:"stop_reason"`
+	SessionID  string `json:"session_id"`
+	DurationMS *int   `json:"duration_ms,omitempty"`
+}
+
+// feedbackData is the data of a feedback event.
+type feedbackData struct {
+	Message string `json:"message"`
+}
+
+// errorData is the data of an error event. ExitCode and Signal are set when
+// the failure came from the sandbox process exiting.
+type errorData struct {
+	Error    string `json:"error"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Signal   *int   `json:"signal,omitempty"`
+}
+
+// systemData is the data of a system event. The session fields are set only
+// when the event records a change of Claude Code session.
+type systemData struct {
+	Result        string `json:"result"`
+	PrevSessionID string `json:"prev_session_id,omitempty"`
+	SessionID     string `json:"session_id,omitempty"`
+}
+
+// checkpointData is the data of a checkpoint event: a user label and the
+// worktree HEAD of each git repo when it was recorded.
+type checkpointData struct {
+	Label   string            `json:"label"`
+	Commits map[string]string `json:"commits"` // repoPath → commit hash
+}
+
+// conflictData is the data of a conflict event: the rebase conflict that
+// made the commit pipeline give up on a repo.
+type conflictData struct {
+	Repo   string   `json:"repo"`
+	Target string   `json:"target"`
+	Files  []string `json:"files"`
+	Commit string   `json:"commit,omitempty"`
+	Hunks  string   `json:"hunks,omitempty"`
+	Reason string   `json:"reason"`
+}
+
+// expandEvent decodes ev.Data into the typed struct for its event type.
+// Events whose data cannot be decoded, or whose type is unknown, keep the
+// raw JSON so no information is lost.
+func expandEvent(ev store.TaskEvent) expandedEvent {
+	out := expandedEvent{
+		ID:        ev.ID,
+		TaskID:    ev.TaskID,
+		EventType: ev.EventType,
+		Data:      ev.Data,
+		CreatedAt: ev.CreatedAt,
+	}
+	switch ev.EventType {
+	case store.EventTypeCheckpoint:
+		var cp checkpointData
+		if err := json.Unmarshal(ev.Data, &cp); err == nil {
+			out.Data = cp
+		}
+		return out
+	case store.EventTypeConflict:
+		var c conflictData
+		if err := json.Unmarshal(ev.Data, &c); err == nil {
+			out.Data = c
+		}
+		return out
+	case store.EventTypeContainerStart:
+		var c store.ContainerStart
+		if err := json.Unmarshal(ev.Data, &c); err == nil {
+			out.Data = c
+		}
+		return out
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(ev.Data, &raw); err != nil {
+		return out
+	}
+	switch ev.EventType {
+	case store.EventTypeStateChange:
+		out.Data = stateChangeData{From: raw["from"], To: raw["to"], DurationMS: optInt(raw, "duration_ms")}
+	case store.EventTypeOutput:
+		out.Data = outputData{Result: raw["result"], StopReason: raw["stop_reason"], SessionID: raw["session_id"],
+			DurationMS: optInt(raw, "duration_ms")}
+	case store.EventTypeFeedback:
+		out.Data = feedbackData{Message: raw["message"]}
+	case store.EventTypeError:
+		out.Data = errorData{Error: raw["error"], ExitCode: optInt(raw, "exit_code"), Signal: optInt(raw, "signal")}
+	case store.EventTypeSystem:
+		out.Data = systemData{Result: raw["result"], PrevSessionID: raw["prev_session_id"], SessionID: raw["session_id"]}
+	}
+	return out
+}
+
+// optInt parses m[key] as an integer, returning nil when absent or invalid.
+func optInt(m map[string]string, key string) *int {
+	v, ok := m[key]
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+// parseEventFilter reads the ?types= and ?since_id= query parameters of an
+// events request.
+func parseEventFilter(r *http.Request) (store.EventFilter, error) {
+	var f store.EventFilter
+	q := r.URL.Query()
+	for _, t := range strings.Split(q.Get("types"), ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !store.ValidEventType(store.EventType(t)) {
+			return f, fmt.Errorf("unknown event type %q", t)
+		}
+		f.Types = append(f.Types, store.EventType(t))
+	}
+	if v := q.Get("since_id"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("invalid since_id %q", v)
+		}
+		f.SinceID = n
+	}
+	return f, nil
+}
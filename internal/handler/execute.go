@@ -3,10 +3,13 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 
+	"changkun.de/wallfacer/internal/gitutil"
 	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/runner"
 	"changkun.de/wallfacer/internal/store"
 	"github.com/google/uuid"
 )
@@ -36,9 +39,7 @@ func (h *Handler) SubmitFeedback(w http.ResponseWriter, r *http.Request, id uuid
 		return
 	}
 
-	if err := h.store.UpdateTaskStatus(r.Context(), id, "in_progress"); err != nil {
-		logger.Handler.Error("update status for feedback", "task", id, "error", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+	if !h.claimTask(w, id, "waiting") {
 		return
 	}
 
@@ -82,26 +83,7 @@ func (h *Handler) CompleteTask(w http.ResponseWriter, r *http.Request, id uuid.U
 			"from": "waiting",
 			"to":   "committing",
 		})
-		sessionID := *task.SessionID
-		go func() {
-			bgCtx := context.Background()
-			if err := h.runner.Commit(id, sessionID); err != nil {
-				h.store.UpdateTaskStatus(bgCtx, id, "failed")
-				h.store.InsertEvent(bgCtx, id, store.EventTypeError, map[string]string{
-					"error": "commit failed: " + err.Error(),
-				})
-				h.store.InsertEvent(bgCtx, id, store.EventTypeStateChange, map[string]string{
-					"from": "committing",
-					"to":   "failed",
-				})
-				return
-			}
-			h.store.UpdateTaskStatus(bgCtx, id, "done")
-			h.store.InsertEvent(bgCtx, id, store.EventTypeStateChange, map[string]string{
-				"from": "committing",
-				"to":   "done",
-			})
-		}()
+		go h.commitInBackground(id, *task.SessionID)
 	} else {
 		// No session to commit — go directly to done.
 		if err := h.store.UpdateTaskStatus(r.Context(), id, "done"); err != nil {
@@ -113,12 +95,89 @@ func (h *Handler) CompleteTask(w http.ResponseWriter, r *http.Request, id uuid.U
 			"from": "waiting",
 			"to":   "done",
 		})
+		h.runner.NotifyDone(id)
 	}
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-// CancelTask cancels a task in backlog, in_progress, waiting, or failed state.
+// commitInBackground runs the commit pipeline of a task in "committing" and
+// moves it to done or failed.
+func (h *Handler) commitInBackground(id uuid.UUID, sessionID string) {
+	bgCtx := context.Background()
+	if err := h.runner.Commit(id, sessionID); errors.Is(err, runner.ErrCommitCancelled) {
+		return // CancelTask sets the status.
+	} else if errors.Is(err, runner.ErrConflictPaused) {
+		return // The task waits in "conflict" for ContinueRebase.
+	} else if err != nil {
+		h.store.UpdateTaskStatus(bgCtx, id, "failed")
+		h.store.InsertEvent(bgCtx, id, store.EventTypeError, map[string]string{
+			"error": "commit failed: " + err.Error(),
+		})
+		h.store.InsertEvent(bgCtx, id, store.EventTypeStateChange, map[string]string{
+			"from": "committing",
+			"to":   "failed",
+		})
+		h.runner.NotifyFailed(id)
+		return
+	}
+	h.store.UpdateTaskStatus(bgCtx, id, "done")
+	h.store.InsertEvent(bgCtx, id, store.EventTypeStateChange, map[string]string{
+		"from": "committing",
+		"to":   "done",
+	})
+	h.runner.NotifyDone(id)
+}
+
+// ContinueRebase resumes the commit pipeline of a task in "conflict" after
+// its conflicts were resolved in the worktree. Conflicts that remain are
+// reported with 409 and the task stays in "conflict".
+func (h *Handler) ContinueRebase(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	task, err := h.store.GetTask(r.Context(), id)
+	if err != nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if task.Status != "conflict" {
+		http.Error(w, "only tasks in conflict can continue their rebase", http.StatusBadRequest)
+		return
+	}
+	if err := h.runner.ContinueRebase(id); err != nil {
+		var conflict *gitutil.ConflictError
+		if errors.As(err, &conflict) {
+			writeJSON(w, http.StatusConflict, map[string]any{
+				"error":    "unresolved conflicts",
+				"worktree": task.ConflictWorktree,
+				"files":    conflict.Files,
+			})
+			return
+		}
+		logger.Handler.Error("continue rebase", "task", id, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.store.UpdateTaskStatus(r.Context(), id, "committing"); err != nil {
+		logger.Handler.Error("update status to committing", "task", id, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.store.InsertEvent(r.Context(), id, store.EventTypeStateChange, map[string]string{
+		"from": "conflict",
+		"to":   "committing",
+	})
+	sessionID := ""
+	if task.SessionID != nil {
+		sessionID = *task.SessionID
+	}
+	go h.commitInBackground(id, sessionID)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "committing"})
+}
+
+// CancelTask cancels a task in backlog, queued, in_progress, waiting,
+// committing, or failed state. A running commit pipeline is stopped and its
+// partial merges rolled back first; once it has merged every repo the
+// cancel is refused with 409.
 func (h *Handler) CancelTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
 	task, err := h.store.GetTask(r.Context(), id)
 	if err != nil {
@@ -128,8 +187,11 @@ func (h *Handler) CancelTask(w http.ResponseWriter, r *http.Request, id uuid.UUI
 
 	cancellable := map[string]bool{
 		"backlog":     true,
+		"queued":      true,
 		"in_progress": true,
 		"waiting":     true,
+		"committing":  true,
+		"conflict":    true,
 		"failed":      true,
 	}
 	if !cancellable[task.Status] {
@@ -139,6 +201,23 @@ func (h *Handler) CancelTask(w http.ResponseWriter, r *http.Request, id uuid.UUI
 
 	oldStatus := task.Status
 
+	// Tasks auto-commit while in_progress, and CompleteTask commits them in
+	// committing; stop and roll back such a pipeline before anything else.
+	if oldStatus == "in_progress" || oldStatus == "committing" {
+		if _, err := h.runner.CancelCommit(id); errors.Is(err, runner.ErrCommitLanded) {
+			http.Error(w, "commit already landed; the task can no longer be cancelled", http.StatusConflict)
+			return
+		}
+	}
+
+	// A rebase stopped on a conflict is aborted and earlier merges rolled
+	// back, as for a cancelled commit pipeline.
+	if oldStatus == "conflict" {
+		if err := h.runner.CancelConflict(id); err != nil {
+			logger.Handler.Warn("roll back conflict", "task", id, "error", err)
+		}
+	}
+
 	// For in_progress tasks: kill the running container first.
 	if oldStatus == "in_progress" {
 		h.runner.KillContainer(id)
@@ -166,11 +245,20 @@ func (h *Handler) CancelTask(w http.ResponseWriter, r *http.Request, id uuid.UUI
 // ResumeTask resumes a failed task using its existing session.
 func (h *Handler) ResumeTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
 	var req struct {
-		Timeout *int `json:"timeout"`
+		Timeout *int    `json:"timeout"`
+		Model   *string `json:"model"`
 	}
 	// Body is optional — ignore parse errors for backward compatibility.
 	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
 	json.NewDecoder(r.Body).Decode(&req)
+	if req.Model != nil && !validModelName(*req.Model) {
+		http.Error(w, "invalid model", http.StatusBadRequest)
+		return
+	}
+	if req.Model != nil && *req.Model != "" && !h.runner.ModelAllowed(*req.Model) {
+		http.Error(w, "model is not allowed", http.StatusBadRequest)
+		return
+	}
 
 	task, err := h.store.GetTask(r.Context(), id)
 	if err != nil {
@@ -186,7 +274,10 @@ func (h *Handler) ResumeTask(w http.ResponseWriter, r *http.Request, id uuid.UUI
 		return
 	}
 
-	if err := h.store.ResumeTask(r.Context(), id, req.Timeout); err != nil {
+	if !h.claimTask(w, id, "failed") {
+		return
+	}
+	if err := h.store.ResumeTask(r.Context(), id, req.Timeout, req.Model); err != nil {
 		logger.Handler.Error("resume task", "task", id, "error", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
@@ -202,6 +293,10 @@ func (h *Handler) ResumeTask(w http.ResponseWriter, r *http.Request, id uuid.UUI
 	writeJSON(w, http.StatusOK, map[string]string{"status": "resumed"})
 }
 
+// errNotArchivable is returned by archiveTask for a task that is neither
+// done nor cancelled.
+var errNotArchivable = errors.New("only done or cancelled tasks can be archived")
+
 // ArchiveTask archives a done task.
 func (h *Handler) ArchiveTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
 	task, err := h.store.GetTask(r.Context(), id)
@@ -209,19 +304,29 @@ func (h *Handler) ArchiveTask(w http.ResponseWriter, r *http.Request, id uuid.UU
 		http.Error(w, "task not found", http.StatusNotFound)
 		return
 	}
-	if task.Status != "done" && task.Status != "cancelled" {
-		http.Error(w, "only done or cancelled tasks can be archived", http.StatusBadRequest)
+	if err := h.archiveTask(r.Context(), task); errors.Is(err, errNotArchivable) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
-	}
-	if err := h.store.SetTaskArchived(r.Context(), id, true); err != nil {
+	} else if err != nil {
 		logger.Handler.Error("archive task", "task", id, "error", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
-	h.store.InsertEvent(r.Context(), id, store.EventTypeStateChange, map[string]string{
+	writeJSON(w, http.StatusOK, map[string]string{"status": "archived"})
+}
+
+// archiveTask archives a done or cancelled task and records the change.
+func (h *Handler) archiveTask(ctx context.Context, task *store.Task) error {
+	if task.Status != "done" && task.Status != "cancelled" {
+		return errNotArchivable
+	}
+	if err := h.store.SetTaskArchived(ctx, task.ID, true); err != nil {
+		return err
+	}
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeStateChange, map[string]string{
 		"to": "archived",
 	})
-	writeJSON(w, http.StatusOK, map[string]string{"status": "archived"})
+	return nil
 }
 
 // UnarchiveTask restores an archived task.
@@ -230,15 +335,52 @@ func (h *Handler) UnarchiveTask(w http.ResponseWriter, r *http.Request, id uuid.
 		http.Error(w, "task not found", http.StatusNotFound)
 		return
 	}
-	if err := h.store.SetTaskArchived(r.Context(), id, false); err != nil {
+	if err := h.unarchiveTask(r.Context(), id); err != nil {
 		logger.Handler.Error("unarchive task", "task", id, "error", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
-	h.store.InsertEvent(r.Context(), id, store.EventTypeStateChange, map[string]string{
+	writeJSON(w, http.StatusOK, map[string]string{"status": "unarchived"})
+}
+
+// unarchiveTask restores an archived task and records the change.
+func (h *Handler) unarchiveTask(ctx context.Context, id uuid.UUID) error {
+	if err := h.store.SetTaskArchived(ctx, id, false); err != nil {
+		return err
+	}
+	h.store.InsertEvent(ctx, id, store.EventTypeStateChange, map[string]string{
 		"to": "unarchived",
 	})
-	writeJSON(w, http.StatusOK, map[string]string{"status": "unarchived"})
+	return nil
+}
+
+// ToggleReviewTask flips the reviewed marker on a done task, letting the
+// board separate reviewed work from work still awaiting review.
+func (h *Handler) ToggleReviewTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	task, err := h.store.GetTask(r.Context(), id)
+	if err != nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if task.Status != "done" {
+		http.Error(w, "only done tasks can be reviewed", http.StatusBadRequest)
+		return
+	}
+	reviewed, err := h.store.ToggleTaskReviewed(r.Context(), id)
+	if err != nil {
+		logger.Handler.Error("toggle reviewed", "task", id, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	// The marker is not a status, so it is logged as a system event.
+	msg := "Marked unreviewed."
+	if reviewed {
+		msg = "Marked reviewed."
+	}
+	h.store.InsertEvent(r.Context(), id, store.EventTypeSystem, map[string]string{
+		"result": msg,
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"reviewed": reviewed})
 }
 
 // SyncTask rebases task worktrees onto the latest default branch without merging.
@@ -258,9 +400,7 @@ func (h *Handler) SyncTask(w http.ResponseWriter, r *http.Request, id uuid.UUID)
 	}
 
 	oldStatus := task.Status
-	if err := h.store.UpdateTaskStatus(r.Context(), id, "in_progress"); err != nil {
-		logger.Handler.Error("sync task status update", "task", id, "error", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+	if !h.claimTask(w, id, oldStatus) {
 		return
 	}
 	h.store.InsertEvent(r.Context(), id, store.EventTypeStateChange, map[string]string{
@@ -275,3 +415,24 @@ func (h *Handler) SyncTask(w http.ResponseWriter, r *http.Request, id uuid.UUID)
 	go h.runner.SyncWorktrees(id, sessionID, oldStatus)
 	writeJSON(w, http.StatusOK, map[string]string{"status": "syncing"})
 }
+
+// claimTask moves a task from status from to in_progress through the
+// runner, which enforces the one active task of no-worktree mode. It answers
+// 409 when another task holds the workspaces or the task left from in the
+// meantime, and reports whether the caller may go on.
+func (h *Handler) claimTask(w http.ResponseWriter, id uuid.UUID, from string) bool {
+	ok, err := h.runner.ClaimTask(id, from)
+	switch {
+	case errors.Is(err, runner.ErrWorkspaceBusy):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return false
+	case err != nil:
+		logger.Handler.Error("claim task", "task", id, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return false
+	case !ok:
+		http.Error(w, "task is no longer "+from, http.StatusConflict)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"strconv"
+
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// maxImportSize caps the body of an import request (64 MB), which may carry
+// the events of every task on a board.
+const maxImportSize = 64 << 20
+
+// exportRecord is one line of an export: a task and, when requested, its
+// events in ID order.
+type exportRecord struct {
+	Task   store.Task        `json:"task"`
+	Events []store.TaskEvent `json:"events,omitempty"`
+}
+
+// ExportTasks streams every task, archived ones included, as NDJSON with one
+// exportRecord per line. ?events=true adds each task's events. Per-task env
+// values are redacted like in every other response.
+func (h *Handler) ExportTasks(w http.ResponseWriter, r *http.Request) {
+	withEvents := r.URL.Query().Get("events") == "true"
+	tasks, err := h.store.ListTasks(r.Context(), true)
+	if err != nil {
+		logger.Handler.Error("export tasks", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="wallfacer-export.ndjson"`)
+	enc := json.NewEncoder(w)
+	for _, t := range tasks {
+		redactEnv(&t)
+		rec := exportRecord{Task: t}
+		if withEvents {
+			if rec.Events, err = h.store.GetEvents(r.Context(), t.ID); err != nil {
+				logger.Handler.Error("export events", "task", t.ID, "error", err)
+				return
+			}
+		}
+		if err := enc.Encode(rec); err != nil {
+			return // client disconnected
+		}
+	}
+}
+
+// ImportTasks reads the NDJSON written by ExportTasks and creates each task
+// under its original ID unless a task with that ID already exists. The whole
+// body is validated before anything is imported. Worktrees, branches,
+// sessions and commit hashes belong to the exporting host and are dropped.
+// Tasks exported while running, waiting or merging cannot continue here and
+// are imported as failed.
+func (h *Handler) ImportTasks(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	dec := json.NewDecoder(r.Body)
+	var records []exportRecord
+	for n := 1; ; n++ {
+		var rec exportRecord
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			http.Error(w, "record "+strconv.Itoa(n)+": invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if msg := validateImport(&rec.Task); msg != "" {
+			http.Error(w, "record "+strconv.Itoa(n)+": "+msg, http.StatusBadRequest)
+			return
+		}
+		records = append(records, rec)
+	}
+
+	imported := []uuid.UUID{}
+	skipped := []uuid.UUID{}
+	for _, rec := range records {
+		// Exports carry env names only; a redacted value must not reach
+		// the container as a literal.
+		maps.DeleteFunc(rec.Task.Env, func(_, v string) bool { return v == redactedEnvValue })
+		clearHostState(&rec.Task)
+		interrupted := importInterrupted(rec.Task.Status)
+		if interrupted {
+			rec.Task.Status = "failed"
+		}
+		ok, err := h.store.ImportTask(r.Context(), rec.Task, rec.Events)
+		if err != nil {
+			logger.Handler.Error("import task", "task", rec.Task.ID, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			skipped = append(skipped, rec.Task.ID)
+			continue
+		}
+		if interrupted {
+			h.store.InsertEvent(r.Context(), rec.Task.ID, store.EventTypeSystem, map[string]string{
+				"result": "Imported while it was still running, waiting or merging; marked as failed.",
+			})
+		}
+		imported = append(imported, rec.Task.ID)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"imported": imported,
+		"skipped":  skipped,
+	})
+}
+
+// validateImport returns why t cannot be imported, or "" if it can.
+func validateImport(t *store.Task) string {
+	switch {
+	case t.ID == uuid.Nil:
+		return "task id is required"
+	case t.Prompt == "":
+		return "task prompt is required"
+	case !validStatuses[t.Status]:
+		return "invalid task status " + strconv.Quote(t.Status)
+	}
+	return ""
+}
+
+// clearHostState drops the fields of t that point into the exporting host's
+// repositories and container sessions.
+func clearHostState(t *store.Task) {
+	t.WorktreePaths = nil
+	t.BranchName = ""
+	t.SessionID = nil
+	t.CommitHashes = nil
+	t.BaseCommitHashes = nil
+	t.ConflictRepo = ""
+	t.ConflictWorktree = ""
+}
+
+// importInterrupted reports whether a task exported in status needs a
+// container, a worktree or a rebase that an import cannot bring along.
+func importInterrupted(status string) bool {
+	switch status {
+	case "in_progress", "committing", "queued", "conflict", "waiting":
+		return true
+	}
+	return false
+}
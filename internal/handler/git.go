@@ -1,17 +1,26 @@
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"changkun.de/wallfacer/internal/gitutil"
 	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/runner"
+	"changkun.de/wallfacer/internal/store"
 	"github.com/google/uuid"
 )
 
@@ -100,10 +109,12 @@ func (h *Handler) GitPush(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !h.isAllowedWorkspace(req.Workspace) {
+	ws, ok := h.isConfiguredWorkspace(req.Workspace)
+	if !ok {
 		http.Error(w, "workspace not configured", http.StatusBadRequest)
 		return
 	}
+	req.Workspace = ws
 
 	logger.Git.Info("push", "workspace", req.Workspace)
 	out, err := exec.CommandContext(r.Context(), "git", "-C", req.Workspace, "push").CombinedOutput()
@@ -134,10 +145,12 @@ func (h *Handler) GitSyncWorkspace(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !h.isAllowedWorkspace(req.Workspace) {
+	ws, ok := h.isConfiguredWorkspace(req.Workspace)
+	if !ok {
 		http.Error(w, "workspace not configured", http.StatusBadRequest)
 		return
 	}
+	req.Workspace = ws
 
 	logger.Git.Info("sync workspace", "workspace", req.Workspace)
 
@@ -162,53 +175,470 @@ func (h *Handler) GitSyncWorkspace(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"output": string(out)})
 }
 
-// TaskDiff returns the git diff for a task's worktrees versus the default branch.
+// maxCheckpointLabel bounds the length of a checkpoint label.
+const maxCheckpointLabel = 200
+
+// CheckpointTask records the current HEAD of each of a task's git worktrees
+// under a user label as a checkpoint event, so TaskDiff can later diff
+// against it with ?against=checkpoint:<label>.
+func (h *Handler) CheckpointTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	var req struct {
+		Label string `json:"label"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	req.Label = strings.TrimSpace(req.Label)
+	if req.Label == "" || len(req.Label) > maxCheckpointLabel {
+		http.Error(w, "label must be 1-200 characters", http.StatusBadRequest)
+		return
+	}
+
+	task, err := h.store.GetTask(r.Context(), id)
+	if err != nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	commits := make(map[string]string)
+	for repoPath, worktreePath := range task.WorktreePaths {
+		if !gitutil.IsGitRepo(repoPath) {
+			continue
+		}
+		if hash, err := gitutil.GetCommitHash(worktreePath); err == nil {
+			commits[repoPath] = hash
+		}
+	}
+	if len(commits) == 0 {
+		http.Error(w, "task has no git worktrees to checkpoint", http.StatusConflict)
+		return
+	}
+
+	data := checkpointData{Label: req.Label, Commits: commits}
+	if err := h.store.InsertEvent(r.Context(), id, store.EventTypeCheckpoint, data); err != nil {
+		logger.Handler.Error("insert checkpoint", "task", id, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, data)
+}
+
+// DiagnoseTask replays the rebase of a failed task's surviving worktrees
+// with git tracing and returns where to download the diagnostics file.
+func (h *Handler) DiagnoseTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	task, err := h.store.GetTask(r.Context(), id)
+	if err != nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if task.Status != "failed" {
+		http.Error(w, "only failed tasks can be diagnosed", http.StatusConflict)
+		return
+	}
+	name, err := h.runner.DiagnoseRebase(r.Context(), id)
+	if errors.Is(err, runner.ErrNothingToDiagnose) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Handler.Error("diagnose task", "task", id, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	// Link relative to the request path so it also works under -base-path.
+	reqPath := r.URL.Path
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		reqPath = u.Path
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"file": name,
+		"url":  path.Join(path.Dir(reqPath), "outputs", name),
+	})
+}
+
+// findCheckpoint returns the commits of the most recent checkpoint event
+// with the given label, or nil if there is none.
+func (h *Handler) findCheckpoint(r *http.Request, id uuid.UUID, label string) (map[string]string, error) {
+	events, err := h.store.GetEvents(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].EventType != store.EventTypeCheckpoint {
+			continue
+		}
+		var cp checkpointData
+		if json.Unmarshal(events[i].Data, &cp) == nil && cp.Label == label {
+			return cp.Commits, nil
+		}
+	}
+	return nil, nil
+}
+
+// diffOptions shapes the git diffs TaskDiff produces. The zero value (with
+// context -1) yields the plain patch.
+type diffOptions struct {
+	stat    bool   // --stat summary instead of the patch
+	file    string // only this path, relative to each repo
+	context int    // unified context lines; -1 keeps git's default
+}
+
+// parseDiffOptions reads the ?format=, ?file= and ?context= query
+// parameters of TaskDiff.
+func parseDiffOptions(q url.Values) (diffOptions, error) {
+	opts := diffOptions{file: q.Get("file"), context: -1}
+	switch q.Get("format") {
+	case "", "patch":
+	case "stat":
+		opts.stat = true
+	default:
+		return opts, errors.New("format must be patch or stat")
+	}
+	if opts.file != "" && (filepath.IsAbs(opts.file) || strings.HasPrefix(path.Clean(opts.file), "..")) {
+		return opts, errors.New("file must be a path inside the repository")
+	}
+	if v := q.Get("context"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, errors.New("context must be a non-negative integer")
+		}
+		opts.context = n
+	}
+	return opts, nil
+}
+
+// formatArgs returns the git diff flags selecting the output format.
+func (o diffOptions) formatArgs() []string {
+	var args []string
+	if o.stat {
+		args = append(args, "--stat")
+	}
+	if o.context >= 0 {
+		args = append(args, fmt.Sprintf("-U%d", o.context))
+	}
+	return args
+}
+
+// pathspec returns the trailing git arguments scoping a diff to o.file.
+func (o diffOptions) pathspec() []string {
+	if o.file == "" {
+		return nil
+	}
+	return []string{"--", o.file}
+}
+
+// diffFile is one changed file in a TaskDiff response. Additions and
+// deletions are zero for binary files.
+type diffFile struct {
+	Repo      string `json:"repo"`
+	Path      string `json:"path"`
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+	Binary    bool   `json:"binary,omitempty"`
+}
+
+// parseNumstat parses git diff --numstat output into the files of repo.
+func parseNumstat(repo string, out []byte) []diffFile {
+	var files []diffFile
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		f := diffFile{Repo: repo, Path: fields[2]}
+		if fields[0] == "-" && fields[1] == "-" {
+			f.Binary = true
+		} else {
+			f.Additions, _ = strconv.Atoi(fields[0])
+			f.Deletions, _ = strconv.Atoi(fields[1])
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// gitDiff runs git -C dir with args (e.g. "diff", base), shaped by opts,
+// and returns its output together with the changed files of repo.
+func gitDiff(r *http.Request, dir, repo string, opts diffOptions, args ...string) ([]byte, []diffFile) {
+	run := func(extra ...string) []byte {
+		cmd := append([]string{"-C", dir}, args...)
+		cmd = append(cmd, extra...)
+		out, _ := exec.CommandContext(r.Context(), "git", append(cmd, opts.pathspec()...)...).Output()
+		return out
+	}
+	return run(opts.formatArgs()...), parseNumstat(repo, run("--numstat"))
+}
+
+// taskDiff is the response of TaskDiff and each event of TaskDiffStream.
+type taskDiff struct {
+	Diff         string         `json:"diff"`
+	Files        []diffFile     `json:"files"`
+	BehindCounts map[string]int `json:"behind_counts"`
+}
+
+// TaskDiff returns the git diff for a task's worktrees versus the default
+// branch. With ?against=checkpoint:<label> it instead diffs each repo
+// against the commit recorded by the latest checkpoint with that label.
+// ?format=stat returns a --stat summary instead of the patch, ?file= scopes
+// the diff to one path, and ?context=N sets the unified context lines. The
+// response also lists the changed files with their line counts.
 func (h *Handler) TaskDiff(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
 	task, err := h.store.GetTask(r.Context(), id)
 	if err != nil {
 		http.Error(w, "task not found", http.StatusNotFound)
 		return
 	}
-	if len(task.WorktreePaths) == 0 {
-		writeJSON(w, http.StatusOK, map[string]any{"diff": "", "behind_counts": map[string]int{}})
+	opts, checkpoint, ok := h.diffParams(w, r, id)
+	if !ok {
 		return
 	}
+	writeJSON(w, http.StatusOK, computeTaskDiff(r, task, opts, checkpoint))
+}
+
+// TaskDiffStream sends the task's diff as Server-Sent Events: once on
+// connect and again whenever it changes. Every few seconds it compares a
+// cheap fingerprint of the worktrees (see diffFingerprint) and recomputes
+// the diff only when that moved. The stream ends after the diff of a task
+// that is done, failed, cancelled, or archived has been sent, since it no
+// longer changes, with a final "end" event that tells EventSource clients
+// not to reconnect. It accepts the same query parameters as TaskDiff.
+func (h *Handler) TaskDiffStream(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	task, err := h.store.GetTask(r.Context(), id)
+	if err != nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	opts, checkpoint, ok := h.diffParams(w, r, id)
+	if !ok {
+		return
+	}
+	if !acquireSSESlot(w) {
+		return
+	}
+	defer releaseSSESlot()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	var current []byte
+	send := func(task *store.Task) bool {
+		data, err := json.Marshal(computeTaskDiff(r, task, opts, checkpoint))
+		if err != nil || bytes.Equal(data, current) {
+			return err == nil
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return false
+		}
+		flusher.Flush()
+		current = data
+		return true
+	}
+
+	end := func() {
+		fmt.Fprint(w, "event: end\ndata: settled\n\n")
+		flusher.Flush()
+	}
+
+	fingerprint := diffFingerprint(task)
+	if !send(task) {
+		return
+	}
+	if diffSettled(task) {
+		end()
+		return
+	}
+
+	ticker := time.NewTicker(taskDiffStreamInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			// Reload so worktrees created or committed since connecting
+			// are picked up; a deleted task ends the stream.
+			task, err := h.store.GetTask(r.Context(), id)
+			if err != nil {
+				return
+			}
+			if fp := diffFingerprint(task); fp != fingerprint {
+				fingerprint = fp
+				if !send(task) {
+					return
+				}
+			}
+			if diffSettled(task) {
+				end()
+				return
+			}
+		}
+	}
+}
+
+// diffSettled reports whether a task's diff can no longer change, which
+// ends its diff stream.
+func diffSettled(task *store.Task) bool {
+	if task.Archived {
+		return true
+	}
+	switch task.Status {
+	case "done", "failed", "cancelled":
+		return true
+	}
+	return false
+}
+
+// diffFingerprint summarises what a task's diff depends on for a fraction
+// of the cost of computing it: the task's recorded worktrees and commit
+// hashes, the HEAD of each worktree and of its repo, and the porcelain
+// status of each worktree with the size and modification time of every
+// file it lists, so edits to an already modified file are seen too.
+func diffFingerprint(task *store.Task) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %v %v %v\n", task.Status, task.WorktreePaths, task.CommitHashes, task.BaseCommitHashes)
+	for _, repoPath := range slices.Sorted(maps.Keys(task.WorktreePaths)) {
+		wt := task.WorktreePaths[repoPath]
+		head, _ := gitutil.GetCommitHash(wt)
+		repoHead, _ := gitutil.GetCommitHash(repoPath)
+		fmt.Fprintf(&b, "%s %s %s\n", wt, head, repoHead)
+		status, _ := gitutil.StatusPorcelain(wt)
+		for line := range strings.Lines(status) {
+			line = strings.TrimRight(line, "\n")
+			if len(line) < 4 {
+				continue
+			}
+			name := line[3:]
+			if _, to, ok := strings.Cut(name, " -> "); ok {
+				name = to
+			}
+			if fi, err := os.Stat(filepath.Join(wt, name)); err == nil {
+				fmt.Fprintf(&b, "%s %d %d\n", line, fi.Size(), fi.ModTime().UnixNano())
+			} else {
+				fmt.Fprintf(&b, "%s\n", line)
+			}
+		}
+	}
+	return b.String()
+}
+
+// taskDiffStreamInterval is how often TaskDiffStream recomputes the diff.
+var taskDiffStreamInterval = 3 * time.Second
+
+// diffParams parses the query parameters shared by TaskDiff and
+// TaskDiffStream, writing an error response and returning ok=false when
+// they are invalid.
+func (h *Handler) diffParams(w http.ResponseWriter, r *http.Request, id uuid.UUID) (opts diffOptions, checkpoint map[string]string, ok bool) {
+	opts, err := parseDiffOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return opts, nil, false
+	}
+	if against := r.URL.Query().Get("against"); against != "" {
+		label, ok := strings.CutPrefix(against, "checkpoint:")
+		if !ok || label == "" {
+			http.Error(w, "against must be checkpoint:<label>", http.StatusBadRequest)
+			return opts, nil, false
+		}
+		checkpoint, err = h.findCheckpoint(r, id, label)
+		if err != nil {
+			logger.Handler.Error("get events for checkpoint", "task", id, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return opts, nil, false
+		}
+		if checkpoint == nil {
+			http.Error(w, "checkpoint not found", http.StatusNotFound)
+			return opts, nil, false
+		}
+	}
+	return opts, checkpoint, true
+}
+
+// computeTaskDiff diffs each of the task's worktrees against the default
+// branch, or against checkpoint when it is non-nil.
+func computeTaskDiff(r *http.Request, task *store.Task, opts diffOptions, checkpoint map[string]string) taskDiff {
+	id := task.ID
+	if len(task.WorktreePaths) == 0 {
+		return taskDiff{Files: []diffFile{}, BehindCounts: map[string]int{}}
+	}
 
 	var combined strings.Builder
+	changed := []diffFile{}
 	behindCounts := make(map[string]int)
 
 	for repoPath, worktreePath := range task.WorktreePaths {
-		// If the worktree directory no longer exists, fall back to stored commit hashes.
-		if _, statErr := os.Stat(worktreePath); statErr != nil {
+		repo := filepath.Base(repoPath)
+		add := func(out []byte, files []diffFile) {
+			if len(out) > 0 {
+				if len(task.WorktreePaths) > 1 {
+					fmt.Fprintf(&combined, "=== %s ===\n", repo)
+				}
+				combined.Write(out)
+			}
+			changed = append(changed, files...)
+		}
+		if checkpoint != nil {
+			// Repos the checkpoint did not record have no reference point.
+			base := checkpoint[repoPath]
+			if base == "" {
+				continue
+			}
+			if _, statErr := os.Stat(worktreePath); statErr == nil {
+				out, files := gitDiff(r, worktreePath, repo, opts, "diff", base)
+				uOut, uFiles := untrackedDiff(r, worktreePath, repo, opts)
+				add(append(out, uOut...), append(files, uFiles...))
+			} else if commitHash := task.CommitHashes[repoPath]; commitHash != "" {
+				add(gitDiff(r, repoPath, repo, opts, "diff", base, commitHash))
+			}
+			continue
+		}
+
+		// If the worktree directory no longer exists, or an in-place task has
+		// already committed, fall back to stored commit hashes.
+		inPlace := worktreePath == repoPath
+		if !inPlace && !gitutil.IsGitRepo(repoPath) {
+			// Non-git workspace: diff the snapshot against the original
+			// directory. Once the snapshot is extracted and removed the
+			// two are identical, so there is nothing left to show.
+			if _, statErr := os.Stat(worktreePath); statErr != nil {
+				continue
+			}
+			out, err := gitutil.SnapshotDiff(worktreePath, repoPath, append(opts.formatArgs(), opts.pathspec()...)...)
+			if err != nil {
+				logger.Git.Warn("snapshot diff", "task", id, "workspace", repoPath, "error", err)
+				continue
+			}
+			numstat, _ := gitutil.SnapshotDiff(worktreePath, repoPath, append([]string{"--numstat"}, opts.pathspec()...)...)
+			add(out, parseNumstat(repo, numstat))
+			continue
+		}
+		if _, statErr := os.Stat(worktreePath); statErr != nil || (inPlace && task.CommitHashes[repoPath] != "") {
 			commitHash := task.CommitHashes[repoPath]
-			var out []byte
 			if commitHash != "" {
 				if baseHash := task.BaseCommitHashes[repoPath]; baseHash != "" {
-					out, _ = exec.CommandContext(r.Context(), "git", "-C", repoPath,
-						"diff", baseHash, commitHash).Output()
+					add(gitDiff(r, repoPath, repo, opts, "diff", baseHash, commitHash))
 				} else {
-					out, _ = exec.CommandContext(r.Context(), "git", "-C", repoPath,
-						"show", commitHash).Output()
+					add(gitDiff(r, repoPath, repo, opts, "show", "--format=", commitHash))
 				}
 			} else if task.BranchName != "" {
 				if defBranch, err := gitutil.DefaultBranch(repoPath); err == nil {
 					// Use merge-base so we only see changes introduced on the task
 					// branch, not the inverse of commits that advanced main.
 					if base, mbErr := gitutil.MergeBase(repoPath, defBranch, task.BranchName); mbErr == nil {
-						out, _ = exec.CommandContext(r.Context(), "git", "-C", repoPath,
-							"diff", base, task.BranchName).Output()
+						add(gitDiff(r, repoPath, repo, opts, "diff", base, task.BranchName))
 					} else {
-						out, _ = exec.CommandContext(r.Context(), "git", "-C", repoPath,
-							"diff", defBranch+".."+task.BranchName).Output()
+						add(gitDiff(r, repoPath, repo, opts, "diff", defBranch+".."+task.BranchName))
 					}
 				}
 			}
-			if len(out) > 0 {
-				if len(task.WorktreePaths) > 1 {
-					fmt.Fprintf(&combined, "=== %s ===\n", filepath.Base(repoPath))
-				}
-				combined.Write(out)
-			}
 			continue
 		}
 
@@ -223,44 +653,173 @@ func (h *Handler) TaskDiff(w http.ResponseWriter, r *http.Request, id uuid.UUID)
 		if err != nil {
 			base = defBranch
 		}
-		out, _ := exec.CommandContext(r.Context(), "git", "-C", worktreePath, "diff", base).Output()
+		if b := task.BaseCommitHashes[repoPath]; inPlace && b != "" {
+			// In-place tasks diff against where the workspace started.
+			base = b
+		}
+		out, files := gitDiff(r, worktreePath, repo, opts, "diff", base)
+		uOut, uFiles := untrackedDiff(r, worktreePath, repo, opts)
+		add(append(out, uOut...), append(files, uFiles...))
 
-		// Include untracked files via --no-index diffs.
-		if untrackedRaw, err := exec.CommandContext(r.Context(), "git", "-C", worktreePath,
-			"ls-files", "--others", "--exclude-standard").Output(); err == nil {
-			for _, file := range strings.Split(strings.TrimSpace(string(untrackedRaw)), "\n") {
-				if file == "" {
-					continue
-				}
-				fd, _ := exec.CommandContext(r.Context(), "git", "-C", worktreePath,
-					"diff", "--no-index", "/dev/null", file).Output()
-				out = append(out, fd...)
-			}
+		if n, err := gitutil.CommitsBehind(repoPath, worktreePath); err == nil && n > 0 {
+			behindCounts[repo] = n
 		}
+	}
 
-		if len(out) > 0 {
-			if len(task.WorktreePaths) > 1 {
-				fmt.Fprintf(&combined, "=== %s ===\n", filepath.Base(repoPath))
-			}
-			combined.Write(out)
+	return taskDiff{Diff: combined.String(), Files: changed, BehindCounts: behindCounts}
+}
+
+// untrackedDiff returns --no-index diffs that add each untracked, non-ignored
+// file in worktreePath, so new files show up alongside tracked changes, and
+// the files they add to repo.
+func untrackedDiff(r *http.Request, worktreePath, repo string, opts diffOptions) ([]byte, []diffFile) {
+	untrackedRaw, err := exec.CommandContext(r.Context(), "git", "-C", worktreePath,
+		"ls-files", "--others", "--exclude-standard").Output()
+	if err != nil {
+		return nil, nil
+	}
+	var out []byte
+	var files []diffFile
+	for _, file := range strings.Split(strings.TrimSpace(string(untrackedRaw)), "\n") {
+		if file == "" || (opts.file != "" && file != path.Clean(opts.file)) {
+			continue
 		}
-		if n, err := gitutil.CommitsBehind(repoPath, worktreePath); err == nil && n > 0 {
-			behindCounts[filepath.Base(repoPath)] = n
+		args := append([]string{"-C", worktreePath, "diff", "--no-index"}, opts.formatArgs()...)
+		fd, _ := exec.CommandContext(r.Context(), "git", append(args, "/dev/null", file)...).Output()
+		out = append(out, fd...)
+		ns, _ := exec.CommandContext(r.Context(), "git", "-C", worktreePath,
+			"diff", "--no-index", "--numstat", "/dev/null", file).Output()
+		for _, f := range parseNumstat(repo, ns) {
+			// --no-index reports the path as given on the command line.
+			f.Path = file
+			files = append(files, f)
 		}
 	}
+	return out, files
+}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"diff":          combined.String(),
-		"behind_counts": behindCounts,
-	})
+// blameLine is one line of GitBlame output. TaskID is set when the line's
+// commit was produced by a wallfacer task.
+type blameLine struct {
+	gitutil.BlameLine
+	TaskID *uuid.UUID `json:"task_id"`
 }
 
-// isAllowedWorkspace checks that the workspace path is one the server was started with.
-func (h *Handler) isAllowedWorkspace(ws string) bool {
-	for _, configured := range h.runner.Workspaces() {
-		if configured == ws {
-			return true
+// GitBlame runs git blame on a file in a configured workspace and attributes
+// each line to the task whose merged commits include the line's commit.
+func (h *Handler) GitBlame(w http.ResponseWriter, r *http.Request) {
+	ws := r.URL.Query().Get("workspace")
+	file := r.URL.Query().Get("file")
+	ws, ok := h.isConfiguredWorkspace(ws)
+	if !ok {
+		http.Error(w, "workspace not configured", http.StatusBadRequest)
+		return
+	}
+	if file == "" || !filepath.IsLocal(file) {
+		http.Error(w, "invalid file path", http.StatusBadRequest)
+		return
+	}
+
+	lines, err := gitutil.Blame(ws, filepath.ToSlash(filepath.Clean(file)))
+	if err != nil {
+		logger.Git.Warn("blame", "workspace", ws, "file", file, "error", err)
+		http.Error(w, "blame failed: file not tracked at HEAD", http.StatusNotFound)
+		return
+	}
+
+	owners, err := h.taskCommits(r, ws)
+	if err != nil {
+		logger.Handler.Error("list tasks for blame", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	result := make([]blameLine, len(lines))
+	for i, l := range lines {
+		result[i] = blameLine{BlameLine: l}
+		if id, ok := owners[l.Commit]; ok {
+			result[i].TaskID = &id
 		}
 	}
-	return false
+	writeJSON(w, http.StatusOK, result)
+}
+
+// GitPatches streams the task-authored commits in a workspace as one mbox,
+// oldest first, so the work can be replayed elsewhere with `git am`. With
+// ?since=<ref> only commits after ref are included.
+func (h *Handler) GitPatches(w http.ResponseWriter, r *http.Request) {
+	ws := r.URL.Query().Get("workspace")
+	ws, ok := h.isConfiguredWorkspace(ws)
+	if !ok {
+		http.Error(w, "workspace not configured", http.StatusBadRequest)
+		return
+	}
+	since := ""
+	if ref := r.URL.Query().Get("since"); ref != "" {
+		hash, err := gitutil.ResolveCommit(ws, ref)
+		if err != nil {
+			http.Error(w, "invalid since ref", http.StatusBadRequest)
+			return
+		}
+		since = hash
+	}
+
+	commits, err := gitutil.CommitsInRange(ws, since, "HEAD")
+	if err != nil {
+		logger.Git.Error("list commits for patches", "workspace", ws, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	owners, err := h.taskCommits(r, ws)
+	if err != nil {
+		logger.Handler.Error("list tasks for patches", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	slices.Reverse(commits)
+
+	w.Header().Set("Content-Type", "application/mbox")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-wallfacer.mbox"`, filepath.Base(ws)))
+	for _, c := range commits {
+		if _, ok := owners[c]; !ok {
+			continue
+		}
+		if err := gitutil.FormatPatch(ws, c, w); err != nil {
+			// Headers are already sent; log and stop the stream.
+			logger.Git.Error("format patch", "workspace", ws, "commit", c, "error", err)
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// taskCommits maps every commit that tasks merged into workspace ws to the
+// task that produced it. A task's commits are the range from its base hash
+// to its stored commit hash, or just the commit hash when no base is known.
+func (h *Handler) taskCommits(r *http.Request, ws string) (map[string]uuid.UUID, error) {
+	tasks, err := h.store.ListTasks(r.Context(), true)
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[string]uuid.UUID)
+	for _, t := range tasks {
+		commit := t.CommitHashes[ws]
+		if commit == "" {
+			continue
+		}
+		owners[commit] = t.ID
+		base := t.BaseCommitHashes[ws]
+		if base == "" {
+			continue
+		}
+		commits, err := gitutil.CommitsInRange(ws, base, commit)
+		if err != nil {
+			continue
+		}
+		for _, c := range commits {
+			owners[c] = t.ID
+		}
+	}
+	return owners, nil
 }
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"changkun.de/wallfacer/internal/runner"
 	"changkun.de/wallfacer/internal/store"
@@ -57,12 +59,18 @@ func newTestHandler(t *testing.T) *Handler {
 // diffResponse is the JSON shape returned by TaskDiff.
 type diffResponse struct {
 	Diff         string         `json:"diff"`
+	Files        []diffFile     `json:"files"`
 	BehindCounts map[string]int `json:"behind_counts"`
 }
 
 func callTaskDiff(t *testing.T, h *Handler, taskID uuid.UUID) diffResponse {
 	t.Helper()
-	req := httptest.NewRequest(http.MethodGet, "/api/tasks/"+taskID.String()+"/diff", nil)
+	return callTaskDiffQuery(t, h, taskID, "")
+}
+
+func callTaskDiffQuery(t *testing.T, h *Handler, taskID uuid.UUID, query string) diffResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks/"+taskID.String()+"/diff"+query, nil)
 	w := httptest.NewRecorder()
 	h.TaskDiff(w, req, taskID)
 	if w.Code != http.StatusOK {
@@ -158,6 +166,141 @@ func TestTaskDiffIncludesUntrackedFiles(t *testing.T) {
 	}
 }
 
+func TestTaskDiffOptions(t *testing.T) {
+	repo := setupRepo(t)
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	wtDir := filepath.Join(t.TempDir(), "wt")
+	gitRun(t, repo, "worktree", "add", "-b", "task", wtDir, "HEAD")
+	os.WriteFile(filepath.Join(wtDir, "file.txt"), []byte("modified\n"), 0644)
+	os.WriteFile(filepath.Join(wtDir, "new-file.txt"), []byte("one\ntwo\n"), 0644)
+
+	task, _ := h.store.CreateTask(ctx, "test", 5, false)
+	h.store.UpdateTaskWorktrees(ctx, task.ID, map[string]string{repo: wtDir}, "task")
+
+	resp := callTaskDiff(t, h, task.ID)
+	want := map[string][2]int{"file.txt": {1, 1}, "new-file.txt": {2, 0}}
+	if len(resp.Files) != len(want) {
+		t.Fatalf("files = %+v, want %d entries", resp.Files, len(want))
+	}
+	for _, f := range resp.Files {
+		if counts, ok := want[f.Path]; !ok || [2]int{f.Additions, f.Deletions} != counts || f.Repo != filepath.Base(repo) {
+			t.Errorf("unexpected file entry %+v", f)
+		}
+	}
+
+	resp = callTaskDiffQuery(t, h, task.ID, "?file=new-file.txt")
+	if strings.Contains(resp.Diff, "a/file.txt") || !strings.Contains(resp.Diff, "new-file.txt") {
+		t.Errorf("?file= diff not scoped to new-file.txt:\n%s", resp.Diff)
+	}
+	if len(resp.Files) != 1 || resp.Files[0].Path != "new-file.txt" {
+		t.Errorf("?file= files = %+v, want only new-file.txt", resp.Files)
+	}
+
+	resp = callTaskDiffQuery(t, h, task.ID, "?format=stat")
+	if strings.Contains(resp.Diff, "@@") || !strings.Contains(resp.Diff, "file.txt | 2") {
+		t.Errorf("?format=stat did not return a stat summary:\n%s", resp.Diff)
+	}
+
+	resp = callTaskDiffQuery(t, h, task.ID, "?file=file.txt&context=0")
+	if !strings.Contains(resp.Diff, "@@ -1 +1 @@") {
+		t.Errorf("?context=0 diff has unexpected hunk header:\n%s", resp.Diff)
+	}
+
+	for _, q := range []string{"?format=html", "?context=-1", "?file=../etc/passwd"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/diff"+q, nil)
+		w := httptest.NewRecorder()
+		h.TaskDiff(w, req, task.ID)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", q, w.Code)
+		}
+	}
+}
+
+func TestTaskDiffStreamPushesChanges(t *testing.T) {
+	repo := setupRepo(t)
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	wtDir := filepath.Join(t.TempDir(), "wt")
+	gitRun(t, repo, "worktree", "add", "-b", "task", wtDir, "HEAD")
+	task, _ := h.store.CreateTask(ctx, "test", 5, false)
+	h.store.UpdateTaskWorktrees(ctx, task.ID, map[string]string{repo: wtDir}, "task")
+
+	old := taskDiffStreamInterval
+	taskDiffStreamInterval = 20 * time.Millisecond
+	t.Cleanup(func() { taskDiffStreamInterval = old })
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.TaskDiffStream(w, r, task.ID)
+	}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	events := make(chan diffResponse)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var d diffResponse
+			if json.Unmarshal([]byte(data), &d) == nil {
+				events <- d
+			}
+		}
+		close(events)
+	}()
+	next := func() diffResponse {
+		t.Helper()
+		select {
+		case d, ok := <-events:
+			if !ok {
+				t.Fatal("stream closed")
+			}
+			return d
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a diff event")
+		}
+		return diffResponse{}
+	}
+
+	if d := next(); d.Diff != "" {
+		t.Errorf("initial diff = %q, want empty", d.Diff)
+	}
+	os.WriteFile(filepath.Join(wtDir, "file.txt"), []byte("edited by claude\n"), 0644)
+	if d := next(); !strings.Contains(d.Diff, "edited by claude") {
+		t.Errorf("pushed diff does not include the edit:\n%s", d.Diff)
+	}
+	// A second edit of an already modified file leaves the porcelain
+	// status unchanged but must still be pushed.
+	os.WriteFile(filepath.Join(wtDir, "file.txt"), []byte("edited by claude twice\n"), 0644)
+	if d := next(); !strings.Contains(d.Diff, "edited by claude twice") {
+		t.Errorf("pushed diff does not include the second edit:\n%s", d.Diff)
+	}
+
+	// Once the task is done the stream ends.
+	h.store.UpdateTaskStatus(ctx, task.ID, "done")
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("stream still open after the task finished")
+		}
+	}
+}
+
 func TestTaskDiffEmptyWhenNoChanges(t *testing.T) {
 	repo := setupRepo(t)
 	h := newTestHandler(t)
@@ -342,3 +485,289 @@ func TestTaskDiffIsolationConcurrent(t *testing.T) {
 		t.Error("task B diff should not contain only-a.txt")
 	}
 }
+
+func TestTaskDiffNonGitSnapshot(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	ws := t.TempDir()
+	os.WriteFile(filepath.Join(ws, "notes.txt"), []byte("draft\n"), 0644)
+
+	// Mirror setupNonGitSnapshot: copy the workspace into a tracked snapshot.
+	snap := filepath.Join(t.TempDir(), "snap")
+	os.MkdirAll(snap, 0755)
+	gitRun(t, snap, "init")
+	os.WriteFile(filepath.Join(snap, "notes.txt"), []byte("final\n"), 0644)
+
+	task, _ := h.store.CreateTask(ctx, "test", 5, false)
+	h.store.UpdateTaskWorktrees(ctx, task.ID, map[string]string{ws: snap}, "task")
+
+	resp := callTaskDiff(t, h, task.ID)
+	if !strings.Contains(resp.Diff, "-draft") || !strings.Contains(resp.Diff, "+final") {
+		t.Errorf("expected synthetic diff of notes.txt, got:\n%s", resp.Diff)
+	}
+}
+
+func TestGitBlameAttributesTaskLines(t *testing.T) {
+	repo := setupRepo(t)
+	s, err := store.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(s, runner.NewRunner(s, runner.RunnerConfig{Workspaces: repo}), t.TempDir(), nil)
+	ctx := context.Background()
+
+	base := gitRun(t, repo, "rev-parse", "HEAD")
+	os.WriteFile(filepath.Join(repo, "file.txt"), []byte("initial\nfrom task\n"), 0644)
+	gitRun(t, repo, "commit", "-am", "task change")
+	head := gitRun(t, repo, "rev-parse", "HEAD")
+
+	task, _ := s.CreateTask(ctx, "p", 5, false)
+	s.UpdateTaskBaseCommitHashes(ctx, task.ID, map[string]string{repo: base})
+	s.UpdateTaskCommitHashes(ctx, task.ID, map[string]string{repo: head})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/git/blame?workspace="+repo+"&file=file.txt", nil)
+	w := httptest.NewRecorder()
+	h.GitBlame(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var lines []blameLine
+	if err := json.Unmarshal(w.Body.Bytes(), &lines); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	if lines[0].TaskID != nil {
+		t.Errorf("line 1 predates the task, got task %v", *lines[0].TaskID)
+	}
+	if lines[1].TaskID == nil || *lines[1].TaskID != task.ID || lines[1].Commit != head {
+		t.Errorf("line 2 = %+v, want task %s commit %s", lines[1], task.ID, head)
+	}
+
+	for _, file := range []string{"../etc/passwd", "/etc/passwd", ""} {
+		req = httptest.NewRequest(http.MethodGet, "/api/git/blame?workspace="+repo+"&file="+file, nil)
+		w = httptest.NewRecorder()
+		h.GitBlame(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("file %q: expected 400, got %d", file, w.Code)
+		}
+	}
+}
+
+func TestGitPatchesStreamsTaskCommits(t *testing.T) {
+	repo := setupRepo(t)
+	s, err := store.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(s, runner.NewRunner(s, runner.RunnerConfig{Workspaces: repo}), t.TempDir(), nil)
+	ctx := context.Background()
+
+	base := gitRun(t, repo, "rev-parse", "HEAD")
+	commit := func(name, msg string) string {
+		os.WriteFile(filepath.Join(repo, name), []byte(msg+"\n"), 0644)
+		gitRun(t, repo, "add", ".")
+		gitRun(t, repo, "commit", "-m", msg)
+		return gitRun(t, repo, "rev-parse", "HEAD")
+	}
+	commit("a.txt", "task commit one")
+	mid := commit("b.txt", "task commit two")
+	commit("c.txt", "manual commit")
+
+	task, _ := s.CreateTask(ctx, "p", 5, false)
+	s.UpdateTaskBaseCommitHashes(ctx, task.ID, map[string]string{repo: base})
+	s.UpdateTaskCommitHashes(ctx, task.ID, map[string]string{repo: mid})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/git/patches?"+query, nil)
+		w := httptest.NewRecorder()
+		h.GitPatches(w, req)
+		return w
+	}
+
+	w := get("workspace=" + repo)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	one, two := strings.Index(body, "task commit one"), strings.Index(body, "task commit two")
+	if one < 0 || two < 0 || one > two {
+		t.Errorf("expected both task commits oldest first:\n%s", body)
+	}
+	if strings.Contains(body, "manual commit") {
+		t.Error("non-task commit must not be exported")
+	}
+
+	w = get("workspace=" + repo + "&since=HEAD~2")
+	if body := w.Body.String(); strings.Contains(body, "task commit one") || !strings.Contains(body, "task commit two") {
+		t.Errorf("since should exclude earlier commits:\n%s", body)
+	}
+
+	for _, q := range []string{"workspace=/nope", "workspace=" + repo + "&since=--output=/tmp/x", "workspace=" + repo + "&since=nosuchref"} {
+		if w := get(q); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", q, w.Code)
+		}
+	}
+}
+
+func TestIsConfiguredWorkspaceCanonicalizes(t *testing.T) {
+	repo := setupRepo(t)
+	s, err := store.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(s, runner.NewRunner(s, runner.RunnerConfig{Workspaces: repo}), t.TempDir(), nil)
+
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(repo, link); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		repo,
+		repo + "/",
+		filepath.Join(repo, "sub") + "/..",
+		link,
+	} {
+		got, ok := h.isConfiguredWorkspace(path)
+		if !ok || got != repo {
+			t.Errorf("isConfiguredWorkspace(%q) = %q, %v; want %q, true", path, got, ok, repo)
+		}
+	}
+	for _, path := range []string{"", "/", filepath.Dir(repo), filepath.Join(repo, "sub"), repo + "/../other"} {
+		if got, ok := h.isConfiguredWorkspace(path); ok {
+			t.Errorf("isConfiguredWorkspace(%q) = %q, true; want false", path, got)
+		}
+	}
+}
+
+func TestTaskDiffAgainstCheckpoint(t *testing.T) {
+	repo := setupRepo(t)
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	wt := filepath.Join(t.TempDir(), "wt")
+	gitRun(t, repo, "worktree", "add", "-b", "task-cp", wt, "HEAD")
+	task, _ := h.store.CreateTask(ctx, "checkpoint", 5, false)
+	h.store.UpdateTaskWorktrees(ctx, task.ID, map[string]string{repo: wt}, "task-cp")
+
+	os.WriteFile(filepath.Join(wt, "before.txt"), []byte("before\n"), 0644)
+	gitRun(t, wt, "add", ".")
+	gitRun(t, wt, "commit", "-m", "before refactor")
+
+	checkpoint := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/tasks/"+task.ID.String()+"/checkpoint", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.CheckpointTask(w, req, task.ID)
+		return w
+	}
+	if w := checkpoint(`{"label":"before the big refactor"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := checkpoint(`{"label":"  "}`); w.Code != http.StatusBadRequest {
+		t.Errorf("empty label: expected 400, got %d", w.Code)
+	}
+
+	os.WriteFile(filepath.Join(wt, "after.txt"), []byte("after\n"), 0644)
+	gitRun(t, wt, "add", ".")
+	gitRun(t, wt, "commit", "-m", "after refactor")
+	os.WriteFile(filepath.Join(wt, "new.txt"), []byte("untracked\n"), 0644)
+
+	diff := func(against string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/diff?against="+against, nil)
+		w := httptest.NewRecorder()
+		h.TaskDiff(w, req, task.ID)
+		return w
+	}
+	w := diff("checkpoint:before+the+big+refactor")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp diffResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !strings.Contains(resp.Diff, "after.txt") || !strings.Contains(resp.Diff, "new.txt") {
+		t.Errorf("expected changes since the checkpoint, got:\n%s", resp.Diff)
+	}
+	if strings.Contains(resp.Diff, "before.txt") {
+		t.Errorf("changes before the checkpoint must be excluded, got:\n%s", resp.Diff)
+	}
+
+	if w := diff("checkpoint:missing"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown label: expected 404, got %d", w.Code)
+	}
+	if w := diff("HEAD~1"); w.Code != http.StatusBadRequest {
+		t.Errorf("bad against: expected 400, got %d", w.Code)
+	}
+}
+
+// TestDiagnoseTaskReplaysConflictingRebase verifies that diagnosing a failed
+// task writes a traced rebase transcript naming the conflicted file, while
+// leaving the task branch and main untouched.
+func TestDiagnoseTaskReplaysConflictingRebase(t *testing.T) {
+	repo := setupRepo(t)
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	wt := filepath.Join(t.TempDir(), "wt")
+	gitRun(t, repo, "worktree", "add", "-b", "task-x", wt, "HEAD")
+	os.WriteFile(filepath.Join(wt, "file.txt"), []byte("task version\n"), 0644)
+	gitRun(t, wt, "commit", "-am", "task change")
+	os.WriteFile(filepath.Join(repo, "file.txt"), []byte("main version\n"), 0644)
+	gitRun(t, repo, "commit", "-am", "main change")
+	mainBefore := gitRun(t, repo, "rev-parse", "main")
+	taskBefore := gitRun(t, wt, "rev-parse", "HEAD")
+
+	task, _ := h.store.CreateTask(ctx, "conflicting", 5, false)
+	h.store.UpdateTaskWorktrees(ctx, task.ID, map[string]string{repo: wt}, "task-x")
+
+	call := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/tasks/"+task.ID.String()+"/diagnose", nil)
+		w := httptest.NewRecorder()
+		h.DiagnoseTask(w, req, task.ID)
+		return w
+	}
+	if w := call(); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a task that has not failed, got %d", w.Code)
+	}
+
+	h.store.UpdateTaskStatus(ctx, task.ID, "failed")
+	w := call()
+	if w.Code != http.StatusCreated {
+		t.Fatalf("DiagnoseTask returned %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		File string `json:"file"`
+		URL  string `json:"url"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.URL != "/api/tasks/"+task.ID.String()+"/outputs/"+resp.File {
+		t.Errorf("unexpected url %q", resp.URL)
+	}
+	report, err := os.ReadFile(filepath.Join(h.store.OutputsDir(task.ID), resp.File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"$ git rebase main", "trace:", "file.txt"} {
+		if !strings.Contains(string(report), want) {
+			t.Errorf("diagnostics missing %q", want)
+		}
+	}
+
+	if got := gitRun(t, repo, "rev-parse", "main"); got != mainBefore {
+		t.Error("main moved during diagnosis")
+	}
+	if got := gitRun(t, wt, "rev-parse", "HEAD"); got != taskBefore {
+		t.Error("task branch moved during diagnosis")
+	}
+	if list := gitRun(t, repo, "worktree", "list"); strings.Count(list, "\n") != 1 {
+		t.Errorf("scratch worktree left behind:\n%s", list)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, resp.URL, nil)
+	rec := httptest.NewRecorder()
+	h.ServeOutput(rec, req, task.ID, resp.File)
+	if rec.Code != http.StatusOK {
+		t.Errorf("ServeOutput returned %d for the diagnostics file", rec.Code)
+	}
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"changkun.de/wallfacer/internal/envconfig"
+	"changkun.de/wallfacer/internal/gitutil"
+)
+
+// healthCheckTimeout bounds the container runtime probe.
+const healthCheckTimeout = 5 * time.Second
+
+// healthCheck is one sub-check of GET /api/health. A failing critical check
+// makes the server unready; other failures only degrade it.
+type healthCheck struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Critical bool   `json:"critical"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// Health is a readiness probe. It checks that the container runtime
+// answers, the data directory is writable, the env file holds a real token,
+// and each workspace still exists as a git repo. The response reports
+// status "ok", "degraded" (a workspace check failed) or "unavailable" (a
+// critical check failed, answered with 503).
+func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
+	checks := []healthCheck{
+		h.checkContainerRuntime(r.Context()),
+		h.checkDataDir(),
+		h.checkToken(),
+	}
+	for _, ws := range h.workspaces {
+		checks = append(checks, checkWorkspace(ws))
+	}
+
+	status, code := "ok", http.StatusOK
+	for _, c := range checks {
+		switch {
+		case c.OK:
+		case c.Critical:
+			status, code = "unavailable", http.StatusServiceUnavailable
+		case status == "ok":
+			status = "degraded"
+		}
+	}
+	writeJSON(w, code, map[string]any{"status": status, "checks": checks})
+}
+
+// checkContainerRuntime verifies the container command is on PATH and
+// answers `version`.
+func (h *Handler) checkContainerRuntime(ctx context.Context) healthCheck {
+	c := healthCheck{Name: "container_runtime", Critical: true}
+	cmd := h.runner.Command()
+	path, err := exec.LookPath(cmd)
+	if err != nil {
+		c.Detail = fmt.Sprintf("%s not found: %v", cmd, err)
+		return c
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, path, "version").CombinedOutput(); err != nil {
+		c.Detail = fmt.Sprintf("%s version: %v: %s", cmd, err, strings.TrimSpace(string(out)))
+		return c
+	}
+	c.OK = true
+	c.Detail = path
+	return c
+}
+
+// checkDataDir verifies a file can be created in the store's data
+// directory.
+func (h *Handler) checkDataDir() healthCheck {
+	c := healthCheck{Name: "data_dir", Critical: true, Detail: h.store.Dir()}
+	f, err := os.CreateTemp(h.store.Dir(), ".health-*")
+	if err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	f.Close()
+	os.Remove(f.Name())
+	c.OK = true
+	return c
+}
+
+// checkToken verifies the env file sets a token that is not a placeholder.
+func (h *Handler) checkToken() healthCheck {
+	c := healthCheck{Name: "token", Critical: true}
+	cfg, err := envconfig.Parse(h.envFile)
+	switch {
+	case err != nil:
+		c.Detail = "read env file: " + err.Error()
+	case !cfg.HasToken():
+		c.Detail = "no CLAUDE_CODE_OAUTH_TOKEN or ANTHROPIC_API_KEY set in " + h.envFile
+	default:
+		c.OK = true
+	}
+	return c
+}
+
+// checkWorkspace verifies a workspace directory exists and is a git repo.
+func checkWorkspace(ws string) healthCheck {
+	c := healthCheck{Name: "workspace:" + ws}
+	info, err := os.Stat(ws)
+	switch {
+	case err != nil:
+		c.Detail = err.Error()
+	case !info.IsDir():
+		c.Detail = "not a directory"
+	case !gitutil.IsGitRepo(ws):
+		c.Detail = "not a git repository"
+	default:
+		c.OK = true
+	}
+	return c
+}
@@ -9,9 +9,28 @@ import (
 	"changkun.de/wallfacer/internal/logger"
 )
 
-// GetInstructions returns the current workspace CLAUDE.md content.
+// instructionsPath resolves the instructions file selected by the "scope"
+// query parameter: "global" for the file shared by every workspace
+// combination, empty or "workspace" for the workspace-scoped CLAUDE.md.
+func (h *Handler) instructionsPath(r *http.Request) (string, bool) {
+	switch r.URL.Query().Get("scope") {
+	case "", "workspace":
+		return instructions.FilePath(h.configDir, h.workspaces), true
+	case "global":
+		return instructions.GlobalFilePath(h.configDir), true
+	default:
+		return "", false
+	}
+}
+
+// GetInstructions returns the current workspace CLAUDE.md content, or the
+// global instructions when called with ?scope=global.
 func (h *Handler) GetInstructions(w http.ResponseWriter, r *http.Request) {
-	path := instructions.FilePath(h.configDir, h.workspaces)
+	path, ok := h.instructionsPath(r)
+	if !ok {
+		http.Error(w, "invalid scope", http.StatusBadRequest)
+		return
+	}
 	content, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -28,8 +47,14 @@ func (h *Handler) GetInstructions(w http.ResponseWriter, r *http.Request) {
 // maxInstructionsSize is the body limit for CLAUDE.md updates (512 KB).
 const maxInstructionsSize = 512 << 10
 
-// UpdateInstructions replaces the workspace CLAUDE.md with the provided content.
+// UpdateInstructions replaces the workspace CLAUDE.md (or the global
+// instructions with ?scope=global) with the provided content.
 func (h *Handler) UpdateInstructions(w http.ResponseWriter, r *http.Request) {
+	path, ok := h.instructionsPath(r)
+	if !ok {
+		http.Error(w, "invalid scope", http.StatusBadRequest)
+		return
+	}
 	var req struct {
 		Content string `json:"content"`
 	}
@@ -38,7 +63,6 @@ func (h *Handler) UpdateInstructions(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid JSON", http.StatusBadRequest)
 		return
 	}
-	path := instructions.FilePath(h.configDir, h.workspaces)
 	if err := os.WriteFile(path, []byte(req.Content), 0600); err != nil {
 		logger.Handler.Error("write instructions", "error", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// GetStats returns server-wide runtime statistics: the rolling hourly spend,
+// whether new turns are being throttled by the spend cap, and the disk used
+// by task worktrees against its cap.
+func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
+	spent := h.runner.HourlySpend()
+	limit := h.runner.MaxHourlySpend()
+	diskUsed, _ := h.runner.WorktreesDiskUsage()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"hourly_spend_usd":         spent,
+		"max_hourly_spend_usd":     limit,
+		"throttled":                limit > 0 && spent >= limit,
+		"worktrees_disk_bytes":     diskUsed,
+		"max_worktrees_disk_bytes": h.runner.MaxWorktreesDiskBytes(),
+	})
+}
+
+// GetUsageToday returns the usage recorded across all tasks since local
+// midnight and the daily cost limit it counts against. cost_usd comes from
+// the spend ledger the limit uses, so it includes deleted tasks; usage
+// covers the tasks still present.
+func (h *Handler) GetUsageToday(w http.ResponseWriter, r *http.Request) {
+	spent, since := h.runner.DailySpend()
+	usage := h.store.SumUsageSince(r.Context(), since)
+	limit := h.runner.DailyCostLimit()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"since":                since,
+		"cost_usd":             spent,
+		"usage":                usage,
+		"daily_cost_limit_usd": limit,
+		"limit_reached":        limit > 0 && spent >= limit,
+	})
+}
+
+// GetUsage returns token usage and cost summed over all tasks, broken down
+// by task status and workspace. An optional ?since=RFC3339 query limits the
+// totals to turns recorded at or after that time.
+func (h *Handler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "invalid since: want RFC3339", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+	writeJSON(w, http.StatusOK, h.store.SummarizeUsage(r.Context(), since))
+}
+
+// Metrics serves server-wide counters and gauges in the Prometheus text
+// exposition format: tasks by status, containers run, token usage and
+// cost, commit pipeline outcomes, rebase conflicts, and turn durations.
+// Token and cost totals are persisted and count deleted tasks, so they never
+// go down; runner counters reset when the server restarts.
+func (h *Handler) Metrics(w http.ResponseWriter, r *http.Request) {
+	byStatus := make(map[string]int, len(validStatuses))
+	for status := range validStatuses {
+		byStatus[status] = 0
+	}
+	tasks, err := h.store.ListTasks(r.Context(), true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, t := range tasks {
+		byStatus[t.Status]++
+	}
+	usage := h.store.UsageTotals()
+	m := h.runner.Metrics()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metricHeader(w, "wallfacer_tasks", "gauge", "Tasks by status, including archived ones.")
+	for _, status := range slices.Sorted(maps.Keys(byStatus)) {
+		fmt.Fprintf(w, "wallfacer_tasks{status=%q} %d\n", status, byStatus[status])
+	}
+	metricHeader(w, "wallfacer_containers_run_total", "counter", "Claude Code containers run.")
+	fmt.Fprintf(w, "wallfacer_containers_run_total %d\n", m.ContainersRun)
+	metricHeader(w, "wallfacer_tokens_total", "counter", "Tokens used across all tasks, deleted ones included.")
+	fmt.Fprintf(w, "wallfacer_tokens_total{type=\"input\"} %d\n", usage.InputTokens)
+	fmt.Fprintf(w, "wallfacer_tokens_total{type=\"output\"} %d\n", usage.OutputTokens)
+	fmt.Fprintf(w, "wallfacer_tokens_total{type=\"cache_read\"} %d\n", usage.CacheReadInputTokens)
+	fmt.Fprintf(w, "wallfacer_tokens_total{type=\"cache_creation\"} %d\n", usage.CacheCreationTokens)
+	metricHeader(w, "wallfacer_cost_usd_total", "counter", "Cost in USD across all tasks, deleted ones included.")
+	fmt.Fprintf(w, "wallfacer_cost_usd_total %g\n", usage.CostUSD)
+	metricHeader(w, "wallfacer_commit_pipelines_total", "counter", "Finished commit pipelines by result.")
+	fmt.Fprintf(w, "wallfacer_commit_pipelines_total{result=\"success\"} %d\n", m.CommitSuccesses)
+	fmt.Fprintf(w, "wallfacer_commit_pipelines_total{result=\"failure\"} %d\n", m.CommitFailures)
+	metricHeader(w, "wallfacer_rebase_conflicts_total", "counter", "Task branch rebases that stopped on a conflict.")
+	fmt.Fprintf(w, "wallfacer_rebase_conflicts_total %d\n", m.RebaseConflicts)
+	metricHeader(w, "wallfacer_turns_total", "counter", "Task turns run.")
+	fmt.Fprintf(w, "wallfacer_turns_total %d\n", m.Turns)
+	metricHeader(w, "wallfacer_turn_duration_seconds_total", "counter", "Total wall-clock time of task turns.")
+	fmt.Fprintf(w, "wallfacer_turn_duration_seconds_total %g\n", m.TurnDuration.Seconds())
+	metricHeader(w, "wallfacer_turn_duration_seconds_avg", "gauge", "Average wall-clock time of a task turn.")
+	fmt.Fprintf(w, "wallfacer_turn_duration_seconds_avg %g\n", m.AvgTurnDuration().Seconds())
+}
+
+// metricHeader writes the HELP and TYPE lines that precede a metric.
+func metricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
@@ -1,16 +1,18 @@
 package handler
 
 import (
+	"archive/zip"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
+	"changkun.de/wallfacer/internal/logger"
 	"changkun.de/wallfacer/internal/store"
 	"github.com/google/uuid"
 )
@@ -67,6 +69,7 @@ func (h *Handler) StreamTasks(w http.ResponseWriter, r *http.Request) {
 		if tasks == nil {
 			tasks = []store.Task{}
 		}
+		redactTasks(tasks)
 		data, err := json.Marshal(tasks)
 		if err != nil {
 			return false
@@ -94,6 +97,88 @@ func (h *Handler) StreamTasks(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// StreamEvents streams a task's events as SSE, one message per event with
+// the event ID as the SSE id. Events after ?since_id= (or the Last-Event-ID
+// header of a reconnecting client) are sent first, then each new event as it
+// is inserted. ?types= and ?expand=true work as for GetEvents. The stream
+// ends when the task is deleted.
+func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > filter.SinceID {
+			filter.SinceID = n
+		}
+	}
+	expand := r.URL.Query().Get("expand") == "true"
+	if _, err := h.store.GetTask(r.Context(), id); err != nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+
+	if !acquireSSESlot(w) {
+		return
+	}
+	defer releaseSSESlot()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// Subscribe before the first read so no event slips in between.
+	subID, ch := h.store.SubscribeEvents(id)
+	defer h.store.Unsubscribe(subID)
+
+	send := func() bool {
+		if _, err := h.store.GetTask(r.Context(), id); err != nil {
+			return false // deleted
+		}
+		events, err := h.store.GetEventsFiltered(r.Context(), id, filter)
+		if err != nil {
+			return false
+		}
+		for _, ev := range events {
+			var payload any = ev
+			if expand {
+				payload = expandEvent(ev)
+			}
+			data, err := json.Marshal(payload)
+			if err != nil {
+				return false
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", ev.ID, data); err != nil {
+				return false
+			}
+			filter.SinceID = ev.ID
+		}
+		flusher.Flush()
+		return true
+	}
+
+	if !send() {
+		return
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ch:
+			if !send() {
+				return
+			}
+		}
+	}
+}
+
 // StreamLogs serves logs for a task. For in-progress tasks with a live.log
 // file, it tails the file in real-time. For completed tasks, it serves
 // the saved turn outputs.
@@ -185,9 +270,8 @@ func (h *Handler) tailLiveLog(w http.ResponseWriter, r *http.Request, path strin
 
 // serveStoredLogs serves saved turn output for tasks no longer running.
 func (h *Handler) serveStoredLogs(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
-	outputsDir := h.store.OutputsDir(id)
-	entries, err := os.ReadDir(outputsDir)
-	if err != nil {
+	names, err := h.store.ListOutputs(id)
+	if err != nil || names == nil {
 		http.Error(w, "no logs saved for this task", http.StatusNotFound)
 		return
 	}
@@ -197,18 +281,14 @@ func (h *Handler) serveStoredLogs(w http.ResponseWriter, r *http.Request, id uui
 	w.WriteHeader(http.StatusOK)
 
 	wrote := false
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		name := entry.Name()
+	for _, name := range names {
 		if !strings.HasPrefix(name, "turn-") {
 			continue
 		}
 		if !strings.HasSuffix(name, ".json") && !strings.HasSuffix(name, ".stderr.txt") {
 			continue
 		}
-		content, readErr := os.ReadFile(filepath.Join(outputsDir, name))
+		content, readErr := h.store.ReadOutput(id, name)
 		if readErr != nil || len(strings.TrimSpace(string(content))) == 0 {
 			continue
 		}
@@ -220,3 +300,74 @@ func (h *Handler) serveStoredLogs(w http.ResponseWriter, r *http.Request, id uui
 		fmt.Fprintln(w, "(no output saved for this task)")
 	}
 }
+
+// DownloadLogs serves a zip of everything recorded for a task: task.json,
+// one traces/NNNN.json per event, and the saved turn outputs and diagnose
+// transcripts under outputs/. All entries sit in a wallfacer-<id8>/
+// directory so the archive extracts cleanly next to others.
+func (h *Handler) DownloadLogs(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	task, err := h.store.GetTask(r.Context(), id)
+	if err != nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	events, err := h.store.GetEvents(r.Context(), id)
+	if err != nil {
+		logger.Handler.Error("get events for log bundle", "task", id, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	// Per-task env values may be secrets; the bundle only names them.
+	redactEnv(task)
+	// A task without saved outputs still has its record and events.
+	names, _ := h.store.ListOutputs(id)
+
+	root := "wallfacer-" + id.String()[:8] + "/"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="wallfacer-%s-logs.zip"`, id.String()[:8]))
+
+	zw := zip.NewWriter(w)
+	add := func(name string, data []byte) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: root + name, Method: zip.Deflate, Modified: task.UpdatedAt})
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		return err
+	}
+	err = func() error {
+		data, err := json.MarshalIndent(task, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := add("task.json", data); err != nil {
+			return err
+		}
+		for _, e := range events {
+			data, err := json.MarshalIndent(e, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := add(fmt.Sprintf("traces/%04d.json", e.ID), data); err != nil {
+				return err
+			}
+		}
+		for _, name := range names {
+			if !validOutputFilename.MatchString(name) {
+				continue
+			}
+			data, err := h.store.ReadOutput(id, name)
+			if err != nil {
+				return err
+			}
+			if err := add("outputs/"+name, data); err != nil {
+				return err
+			}
+		}
+		return zw.Close()
+	}()
+	if err != nil {
+		// Headers are already sent; log and leave the archive truncated.
+		logger.Handler.Error("write log bundle", "task", id, "error", err)
+	}
+}
@@ -1,16 +1,22 @@
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
 	"io/fs"
+	"maps"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/runner"
 	"changkun.de/wallfacer/internal/store"
 	"github.com/google/uuid"
 )
@@ -24,18 +30,49 @@ var validStatuses = map[string]bool{
 	"failed":      true,
 	"cancelled":   true,
 	"committing":  true,
+	"queued":      true,
+	"conflict":    true,
 }
 
-// validOutputFilename matches expected turn output filenames.
-var validOutputFilename = regexp.MustCompile(`^turn-\d+\.(json|stderr\.txt)$`)
+// validOutputFilename matches expected turn output and diagnostics filenames.
+var validOutputFilename = regexp.MustCompile(`^(turn-\d+\.(json|stderr\.txt)|diagnose-\d+\.txt)$`)
 
 // maxBodySize is the default request body limit (1 MB).
 const maxBodySize = 1 << 20
 
-// ListTasks returns all tasks, optionally including archived ones.
+// ListTasks returns tasks in board order, optionally including archived
+// ones. ?status= (comma-separated) keeps only tasks in those states, and
+// ?offset= and ?limit= select a page. The number of matching tasks before
+// paging is reported in the X-Total-Count header.
 func (h *Handler) ListTasks(w http.ResponseWriter, r *http.Request) {
-	includeArchived := r.URL.Query().Get("include_archived") == "true"
-	tasks, err := h.store.ListTasks(r.Context(), includeArchived)
+	q := r.URL.Query()
+	filter := store.TaskFilter{IncludeArchived: q.Get("include_archived") == "true"}
+	if v := q.Get("status"); v != "" {
+		for _, st := range strings.Split(v, ",") {
+			if !validStatuses[st] {
+				http.Error(w, "invalid status: "+st, http.StatusBadRequest)
+				return
+			}
+			filter.Statuses = append(filter.Statuses, st)
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"offset", &filter.Offset}, {"limit", &filter.Limit}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid "+p.name, http.StatusBadRequest)
+			return
+		}
+		*p.dst = n
+	}
+
+	tasks, total, err := h.store.ListTasksFiltered(r.Context(), filter)
 	if err != nil {
 		logger.Handler.Error("list tasks", "error", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -44,29 +81,271 @@ func (h *Handler) ListTasks(w http.ResponseWriter, r *http.Request) {
 	if tasks == nil {
 		tasks = []store.Task{}
 	}
+	redactTasks(tasks)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// SearchTasks returns the tasks, archived ones included, whose title,
+// prompt, earlier prompts, or result contain ?q=, ignoring case.
+func (h *Handler) SearchTasks(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	tasks, err := h.store.SearchTasks(r.Context(), q)
+	if err != nil {
+		logger.Handler.Error("search tasks", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if tasks == nil {
+		tasks = []store.Task{}
+	}
+	redactTasks(tasks)
 	writeJSON(w, http.StatusOK, tasks)
 }
 
-// CreateTask creates a new task in backlog status.
+// createTaskRequest is the JSON body accepted by CreateTask and PreviewTask.
+type createTaskRequest struct {
+	Prompt         string            `json:"prompt"`
+	Timeout        int               `json:"timeout"`
+	MountWorktrees bool              `json:"mount_worktrees"`
+	CommitTitle    string            `json:"commit_title"`
+	AutoExtend     bool              `json:"auto_extend"`
+	SandboxImage   string            `json:"sandbox_image"`
+	MemoryLimit    string            `json:"memory_limit"`
+	CPULimit       string            `json:"cpu_limit"`
+	Env            map[string]string `json:"env"`
+	Model          string            `json:"model"`
+	MaxCostUSD     float64           `json:"max_cost_usd"`
+	Priority       string            `json:"priority"`
+	DependsOn      []uuid.UUID       `json:"depends_on"`
+	MergeStrategy  string            `json:"merge_strategy"`
+	TurnTimeout    int               `json:"turn_timeout"`
+	Deadline       *time.Time        `json:"deadline"`
+	DeadlineIn     string            `json:"deadline_in"`
+}
+
+// deadline returns the task deadline req asks for: Deadline, or DeadlineIn
+// (a Go duration such as "48h") from now. It returns nil when neither is set
+// or DeadlineIn does not parse; validate reports the latter.
+func (req *createTaskRequest) deadline(now time.Time) *time.Time {
+	if req.Deadline != nil {
+		return req.Deadline
+	}
+	if req.DeadlineIn == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(req.DeadlineIn)
+	if err != nil {
+		return nil
+	}
+	at := now.Add(d)
+	return &at
+}
+
+// apply copies the options in req onto a task that is being created.
+func (req *createTaskRequest) apply(t *store.Task) {
+	t.CommitTitle = req.CommitTitle
+	t.MergeStrategy = req.MergeStrategy
+	t.TurnTimeout = req.TurnTimeout
+	t.AutoExtend = req.AutoExtend
+	t.SandboxImage = req.SandboxImage
+	t.MemoryLimit, t.CPULimit = req.MemoryLimit, req.CPULimit
+	if len(req.Env) > 0 {
+		t.Env = maps.Clone(req.Env)
+	}
+	t.Model = req.Model
+	t.MaxCostUSD = req.MaxCostUSD
+	t.Deadline = req.deadline(t.CreatedAt)
+	if req.Priority != store.PriorityNormal {
+		t.Priority = req.Priority
+	}
+	t.DependsOn = req.DependsOn
+}
+
+// validate returns the problems that would make CreateTask reject req.
+// imageAllowed and modelAllowed report whether a sandbox image or model is
+// on the server's allowlists.
+func (req *createTaskRequest) validate(imageAllowed, modelAllowed func(string) bool) []string {
+	var errs []string
+	if strings.TrimSpace(req.Prompt) == "" {
+		errs = append(errs, "prompt is required")
+	}
+	if !validCommitTitle(req.CommitTitle) {
+		errs = append(errs, "invalid commit_title")
+	}
+	if !validMergeStrategy(req.MergeStrategy) {
+		errs = append(errs, "invalid merge_strategy")
+	}
+	if req.SandboxImage != "" && !imageAllowed(req.SandboxImage) {
+		errs = append(errs, "sandbox_image is not allowed")
+	}
+	if !runner.ValidMemoryLimit(req.MemoryLimit) {
+		errs = append(errs, "invalid memory_limit")
+	}
+	if !runner.ValidCPULimit(req.CPULimit) {
+		errs = append(errs, "invalid cpu_limit")
+	}
+	for k, v := range req.Env {
+		if !validEnvKey.MatchString(k) || strings.ContainsAny(v, "\n\r\x00") {
+			errs = append(errs, "invalid env")
+			break
+		}
+	}
+	if req.Priority != "" && !store.ValidPriority(req.Priority) {
+		errs = append(errs, "invalid priority")
+	}
+	if req.MaxCostUSD < 0 {
+		errs = append(errs, "invalid max_cost_usd")
+	}
+	if req.TurnTimeout < 0 {
+		errs = append(errs, "invalid turn_timeout")
+	}
+	if req.Deadline != nil && req.DeadlineIn != "" {
+		errs = append(errs, "deadline and deadline_in are mutually exclusive")
+	} else if d, err := time.ParseDuration(req.DeadlineIn); req.DeadlineIn != "" && (err != nil || d <= 0) {
+		errs = append(errs, "invalid deadline_in")
+	} else if req.Deadline != nil && !req.Deadline.After(time.Now()) {
+		errs = append(errs, "deadline is in the past")
+	}
+	if !validModelName(req.Model) {
+		errs = append(errs, "invalid model")
+	} else if req.Model != "" && !modelAllowed(req.Model) {
+		errs = append(errs, "model is not allowed")
+	}
+	return errs
+}
+
+// CreateTask creates a new task in backlog status. With ?template=name the
+// body may be empty; the template supplies the prompt, timeout, and model the
+// body leaves unset.
 func (h *Handler) CreateTask(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Prompt         string `json:"prompt"`
-		Timeout        int    `json:"timeout"`
-		MountWorktrees bool   `json:"mount_worktrees"`
+	req, ok := h.readCreateRequest(w, r)
+	if !ok {
+		return
+	}
+	if errs := req.validate(h.runner.SandboxImageAllowed, h.runner.ModelAllowed); len(errs) > 0 {
+		http.Error(w, errs[0], http.StatusBadRequest)
+		return
+	}
+
+	for _, dep := range req.DependsOn {
+		if _, err := h.store.GetTask(r.Context(), dep); err != nil {
+			http.Error(w, "dependency not found: "+dep.String(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	task, err := h.store.CreateTaskWith(r.Context(), req.Prompt, req.Timeout, req.MountWorktrees, req.apply)
+	if err != nil {
+		logger.Handler.Error("create task", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
 	}
+
+	h.store.InsertEvent(r.Context(), task.ID, store.EventTypeStateChange, map[string]string{
+		"to": "backlog",
+	})
+
+	go h.runner.GenerateTitle(task.ID, task.Prompt)
+
+	redactEnv(task)
+	writeJSON(w, http.StatusCreated, task)
+}
+
+// maxBatchTasks caps how many tasks a single batch request may create.
+const maxBatchTasks = 100
+
+// batchCreateRequest is the JSON body accepted by BatchCreateTasks.
+type batchCreateRequest struct {
+	Prompts        []string `json:"prompts"`
+	Timeout        int      `json:"timeout"`
+	MountWorktrees bool     `json:"mount_worktrees"`
+	Labels         []string `json:"labels"`
+}
+
+// BatchCreateTasks creates one backlog task per prompt in a single store
+// operation and returns the created tasks in request order.
+func (h *Handler) BatchCreateTasks(w http.ResponseWriter, r *http.Request) {
+	var req batchCreateRequest
 	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid JSON", http.StatusBadRequest)
 		return
 	}
-	if strings.TrimSpace(req.Prompt) == "" {
-		http.Error(w, "prompt is required", http.StatusBadRequest)
+	if len(req.Prompts) == 0 {
+		http.Error(w, "prompts is required", http.StatusBadRequest)
 		return
 	}
+	if len(req.Prompts) > maxBatchTasks {
+		http.Error(w, "too many prompts (max "+strconv.Itoa(maxBatchTasks)+")", http.StatusBadRequest)
+		return
+	}
+	for i, p := range req.Prompts {
+		if strings.TrimSpace(p) == "" {
+			http.Error(w, "prompt "+strconv.Itoa(i)+" is empty", http.StatusBadRequest)
+			return
+		}
+	}
+	var labels []string
+	for _, l := range req.Labels {
+		if l = strings.TrimSpace(l); l != "" {
+			labels = append(labels, l)
+		}
+	}
 
-	task, err := h.store.CreateTask(r.Context(), req.Prompt, req.Timeout, req.MountWorktrees)
+	tasks, err := h.store.CreateTasks(r.Context(), req.Prompts, req.Timeout, req.MountWorktrees, labels)
 	if err != nil {
-		logger.Handler.Error("create task", "error", err)
+		logger.Handler.Error("batch create tasks", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	for _, task := range tasks {
+		h.store.InsertEvent(r.Context(), task.ID, store.EventTypeStateChange, map[string]string{
+			"to": "backlog",
+		})
+		go h.runner.GenerateTitle(task.ID, task.Prompt)
+	}
+
+	writeJSON(w, http.StatusCreated, tasks)
+}
+
+// CloneTask creates a backlog task with the prompt, timeout, model, and
+// fresh-start setting of an existing task, but none of its session,
+// worktrees, or history. The optional body {"prompt": "..."} replaces the
+// copied prompt.
+func (h *Handler) CloneTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	var req struct {
+		Prompt *string `json:"prompt"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	src, err := h.store.GetTask(r.Context(), id)
+	if err != nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	prompt := src.Prompt
+	if req.Prompt != nil {
+		if strings.TrimSpace(*req.Prompt) == "" {
+			http.Error(w, "prompt must not be empty", http.StatusBadRequest)
+			return
+		}
+		prompt = *req.Prompt
+	}
+
+	task, err := h.store.CreateTaskWith(r.Context(), prompt, src.Timeout, false, func(t *store.Task) {
+		t.FreshStart = src.FreshStart
+		t.Model = src.Model
+	})
+	if err != nil {
+		logger.Handler.Error("clone task", "task", id, "error", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -74,27 +353,174 @@ func (h *Handler) CreateTask(w http.ResponseWriter, r *http.Request) {
 	h.store.InsertEvent(r.Context(), task.ID, store.EventTypeStateChange, map[string]string{
 		"to": "backlog",
 	})
+	h.store.InsertEvent(r.Context(), task.ID, store.EventTypeSystem, map[string]string{
+		"result": "Cloned from task " + id.String() + ".",
+	})
 
 	go h.runner.GenerateTitle(task.ID, task.Prompt)
 
+	redactEnv(task)
 	writeJSON(w, http.StatusCreated, task)
 }
 
+// readCreateRequest decodes a create-task body and, with ?template=name,
+// fills the fields it leaves unset from that template, in which case the
+// body may be empty. On failure it writes the error response and returns
+// false.
+func (h *Handler) readCreateRequest(w http.ResponseWriter, r *http.Request) (createTaskRequest, bool) {
+	var req createTaskRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	name := r.URL.Query().Get("template")
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && (name == "" || !errors.Is(err, io.EOF)) {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return req, false
+	}
+	if name != "" {
+		tmpl, ok := h.store.GetTemplate(name)
+		if !ok {
+			http.Error(w, "template not found", http.StatusNotFound)
+			return req, false
+		}
+		req.applyTemplate(tmpl)
+	}
+	return req, true
+}
+
+// PreviewTask reads a create-task body the way CreateTask does, including
+// ?template=name, validates it, and returns the prompt as it would be sent
+// to Claude on the first turn, without persisting anything.
+func (h *Handler) PreviewTask(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.readCreateRequest(w, r)
+	if !ok {
+		return
+	}
+	errs := req.validate(h.runner.SandboxImageAllowed, h.runner.ModelAllowed)
+	if errs == nil {
+		errs = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"prompt": h.runner.WrapPrompt(req.Prompt),
+		"valid":  len(errs) == 0,
+		"errors": errs,
+	})
+}
+
+// validCommitTitle reports whether v is an accepted Task.CommitTitle mode.
+func validCommitTitle(v string) bool {
+	return v == "" || v == store.CommitTitlePrefix || v == store.CommitTitleSuffix
+}
+
+// validMergeStrategy reports whether v is an accepted Task.MergeStrategy;
+// empty uses the server default.
+func validMergeStrategy(v string) bool {
+	return v == "" || store.ValidMergeStrategy(v)
+}
+
+// validEnvKey matches environment variable names accepted in Task.Env.
+var validEnvKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// redactedEnvValue stands in for the values of Task.Env in API responses.
+// They may be secrets, so the API accepts them on write but never returns
+// them.
+const redactedEnvValue = "***"
+
+// redactEnv replaces the values of t.Env with redactedEnvValue. The map is
+// replaced rather than modified because store copies share it.
+func redactEnv(t *store.Task) {
+	if len(t.Env) == 0 {
+		return
+	}
+	env := make(map[string]string, len(t.Env))
+	for k := range t.Env {
+		env[k] = redactedEnvValue
+	}
+	t.Env = env
+}
+
+// redactTasks applies redactEnv to every task.
+func redactTasks(tasks []store.Task) {
+	for i := range tasks {
+		redactEnv(&tasks[i])
+	}
+}
+
+// validModel matches Claude model names and aliases such as "opus" or
+// "claude-sonnet-4-5-20250929"; an empty string clears the override.
+var validModel = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:@/\[\]-]{0,127}$`)
+
+// validModelName reports whether v is an acceptable Task.Model value.
+func validModelName(v string) bool {
+	return v == "" || validModel.MatchString(v)
+}
+
 // UpdateTask handles PATCH requests: status transitions, position, prompt, etc.
 func (h *Handler) UpdateTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
 	var req struct {
-		Status         *string `json:"status"`
-		Position       *int    `json:"position"`
-		Prompt         *string `json:"prompt"`
-		Timeout        *int    `json:"timeout"`
-		FreshStart     *bool   `json:"fresh_start"`
-		MountWorktrees *bool   `json:"mount_worktrees"`
+		Status         *string      `json:"status"`
+		Position       *int         `json:"position"`
+		Prompt         *string      `json:"prompt"`
+		Timeout        *int         `json:"timeout"`
+		FreshStart     *bool        `json:"fresh_start"`
+		MountWorktrees *bool        `json:"mount_worktrees"`
+		CommitTitle    *string      `json:"commit_title"`
+		AutoExtend     *bool        `json:"auto_extend"`
+		SandboxImage   *string      `json:"sandbox_image"`
+		MemoryLimit    *string      `json:"memory_limit"`
+		CPULimit       *string      `json:"cpu_limit"`
+		Model          *string      `json:"model"`
+		MaxCostUSD     *float64     `json:"max_cost_usd"`
+		Priority       *string      `json:"priority"`
+		DependsOn      *[]uuid.UUID `json:"depends_on"`
+		MergeStrategy  *string      `json:"merge_strategy"`
+		TurnTimeout    *int         `json:"turn_timeout"`
 	}
 	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid JSON", http.StatusBadRequest)
 		return
 	}
+	if req.CommitTitle != nil && !validCommitTitle(*req.CommitTitle) {
+		http.Error(w, "invalid commit_title", http.StatusBadRequest)
+		return
+	}
+	if req.MergeStrategy != nil && !validMergeStrategy(*req.MergeStrategy) {
+		http.Error(w, "invalid merge_strategy", http.StatusBadRequest)
+		return
+	}
+	if req.SandboxImage != nil && *req.SandboxImage != "" && !h.runner.SandboxImageAllowed(*req.SandboxImage) {
+		http.Error(w, "sandbox_image is not allowed", http.StatusBadRequest)
+		return
+	}
+	if req.MemoryLimit != nil && !runner.ValidMemoryLimit(*req.MemoryLimit) {
+		http.Error(w, "invalid memory_limit", http.StatusBadRequest)
+		return
+	}
+	if req.CPULimit != nil && !runner.ValidCPULimit(*req.CPULimit) {
+		http.Error(w, "invalid cpu_limit", http.StatusBadRequest)
+		return
+	}
+	if req.Priority != nil && *req.Priority != "" && !store.ValidPriority(*req.Priority) {
+		http.Error(w, "invalid priority", http.StatusBadRequest)
+		return
+	}
+	if req.MaxCostUSD != nil && *req.MaxCostUSD < 0 {
+		http.Error(w, "invalid max_cost_usd", http.StatusBadRequest)
+		return
+	}
+	if req.TurnTimeout != nil && *req.TurnTimeout < 0 {
+		http.Error(w, "invalid turn_timeout", http.StatusBadRequest)
+		return
+	}
+	if req.Model != nil {
+		if !validModelName(*req.Model) {
+			http.Error(w, "invalid model", http.StatusBadRequest)
+			return
+		}
+		if *req.Model != "" && !h.runner.ModelAllowed(*req.Model) {
+			http.Error(w, "model is not allowed", http.StatusBadRequest)
+			return
+		}
+	}
 
 	task, err := h.store.GetTask(r.Context(), id)
 	if err != nil {
@@ -102,6 +528,110 @@ func (h *Handler) UpdateTask(w http.ResponseWriter, r *http.Request, id uuid.UUI
 		return
 	}
 
+	// The commit title mode only matters at commit time, so it can be changed
+	// until the task starts committing.
+	if req.CommitTitle != nil && task.Status != "committing" && task.Status != "done" {
+		if err := h.store.SetTaskCommitTitle(r.Context(), id, *req.CommitTitle); err != nil {
+			logger.Handler.Error("set commit title", "task", id, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Like the commit title, the merge strategy is only read by the commit
+	// pipeline.
+	if req.MergeStrategy != nil && task.Status != "committing" && task.Status != "done" {
+		if err := h.store.SetTaskMergeStrategy(r.Context(), id, *req.MergeStrategy); err != nil {
+			logger.Handler.Error("set merge strategy", "task", id, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Auto-extension is read when a run starts, so it can be toggled until
+	// the task starts committing; a change applies from the next run.
+	if req.AutoExtend != nil && task.Status != "committing" && task.Status != "done" {
+		if err := h.store.SetTaskAutoExtend(r.Context(), id, *req.AutoExtend); err != nil {
+			logger.Handler.Error("set auto extend", "task", id, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The per-turn timeout is read when a run starts; a change applies from
+	// the next run.
+	if req.TurnTimeout != nil && task.Status != "committing" && task.Status != "done" {
+		if err := h.store.SetTaskTurnTimeout(r.Context(), id, *req.TurnTimeout); err != nil {
+			logger.Handler.Error("set turn timeout", "task", id, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The budget is checked before every turn, so it can be raised (e.g. to
+	// resume a task that ran out) until the task starts committing.
+	if req.MaxCostUSD != nil && task.Status != "committing" && task.Status != "done" {
+		if err := h.store.SetTaskMaxCost(r.Context(), id, *req.MaxCostUSD); err != nil {
+			logger.Handler.Error("set max cost", "task", id, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The sandbox is created from the image when a task starts, so the image
+	// can only be changed while the task is in the backlog.
+	if req.SandboxImage != nil && task.Status == "backlog" {
+		if err := h.store.SetTaskSandboxImage(r.Context(), id, *req.SandboxImage); err != nil {
+			logger.Handler.Error("set sandbox image", "task", id, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Resource limits are applied when the sandbox is created, like the
+	// image.
+	if (req.MemoryLimit != nil || req.CPULimit != nil) && task.Status == "backlog" {
+		memory, cpus := task.MemoryLimit, task.CPULimit
+		if req.MemoryLimit != nil {
+			memory = *req.MemoryLimit
+		}
+		if req.CPULimit != nil {
+			cpus = *req.CPULimit
+		}
+		if err := h.store.SetTaskResourceLimits(r.Context(), id, memory, cpus); err != nil {
+			logger.Handler.Error("set resource limits", "task", id, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The model is edited in the backlog like the image; failed tasks pick
+	// a new one on resume.
+	if req.Model != nil && task.Status == "backlog" {
+		if err := h.store.SetTaskModel(r.Context(), id, *req.Model); err != nil {
+			logger.Handler.Error("set task model", "task", id, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Priority only orders the backlog, so it is editable there.
+	if req.Priority != nil && task.Status == "backlog" {
+		if err := h.store.SetTaskPriority(r.Context(), id, *req.Priority); err != nil {
+			logger.Handler.Error("set priority", "task", id, "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Dependencies gate the start of a task, so they are edited in the backlog.
+	if req.DependsOn != nil && task.Status == "backlog" {
+		if err := h.store.SetTaskDependsOn(r.Context(), id, *req.DependsOn); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Allow editing prompt, timeout, fresh_start, and mount_worktrees for backlog tasks.
 	if task.Status == "backlog" && (req.Prompt != nil || req.Timeout != nil || req.FreshStart != nil || req.MountWorktrees != nil) {
 		if err := h.store.UpdateTaskBacklog(r.Context(), id, req.Prompt, req.Timeout, req.FreshStart, req.MountWorktrees); err != nil {
@@ -120,7 +650,8 @@ func (h *Handler) UpdateTask(w http.ResponseWriter, r *http.Request, id uuid.UUI
 	}
 
 	if req.Status != nil {
-		if !validStatuses[*req.Status] {
+		// queued and conflict are entered by the runner only.
+		if !validStatuses[*req.Status] || *req.Status == "queued" || *req.Status == "conflict" {
 			http.Error(w, "invalid status", http.StatusBadRequest)
 			return
 		}
@@ -152,22 +683,37 @@ func (h *Handler) UpdateTask(w http.ResponseWriter, r *http.Request, id uuid.UUI
 				"to":   "backlog",
 			})
 		} else {
-			if err := h.store.UpdateTaskStatus(r.Context(), id, newStatus); err != nil {
-				logger.Handler.Error("update status", "task", id, "error", err)
-				http.Error(w, "internal server error", http.StatusInternalServerError)
-				return
+			if newStatus == "in_progress" && oldStatus == "backlog" {
+				if ok, pending, _ := h.store.CanStart(r.Context(), id); !ok {
+					http.Error(w, "task is waiting on unfinished dependencies: "+h.taskNames(r, pending), http.StatusConflict)
+					return
+				}
 			}
-			h.store.InsertEvent(r.Context(), id, store.EventTypeStateChange, map[string]string{
-				"from": oldStatus,
-				"to":   newStatus,
-			})
-
 			if newStatus == "in_progress" && oldStatus == "backlog" {
-				sessionID := ""
-				if !task.FreshStart && task.SessionID != nil {
-					sessionID = *task.SessionID
+				started, err := h.runner.StartTask(id, "")
+				if errors.Is(err, runner.ErrWorkspaceBusy) {
+					http.Error(w, err.Error(), http.StatusConflict)
+					return
+				}
+				if err != nil {
+					logger.Handler.Error("start task", "task", id, "error", err)
+					http.Error(w, "internal server error", http.StatusInternalServerError)
+					return
 				}
-				go h.runner.Run(id, task.Prompt, sessionID, false)
+				if !started {
+					http.Error(w, "task has already left the backlog", http.StatusConflict)
+					return
+				}
+			} else {
+				if err := h.store.UpdateTaskStatus(r.Context(), id, newStatus); err != nil {
+					logger.Handler.Error("update status", "task", id, "error", err)
+					http.Error(w, "internal server error", http.StatusInternalServerError)
+					return
+				}
+				h.store.InsertEvent(r.Context(), id, store.EventTypeStateChange, map[string]string{
+					"from": oldStatus,
+					"to":   newStatus,
+				})
 			}
 		}
 	}
@@ -178,15 +724,37 @@ func (h *Handler) UpdateTask(w http.ResponseWriter, r *http.Request, id uuid.UUI
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	redactEnv(updated)
 	writeJSON(w, http.StatusOK, updated)
 }
 
-// DeleteTask removes a task and its data.
-func (h *Handler) DeleteTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
-	if task, err := h.store.GetTask(r.Context(), id); err == nil && len(task.WorktreePaths) > 0 {
-		h.runner.CleanupWorktrees(id, task.WorktreePaths, task.BranchName)
+// taskNames renders task IDs as a comma-separated list of titles (or short
+// IDs for untitled tasks) with their status, for error messages.
+func (h *Handler) taskNames(r *http.Request, ids []uuid.UUID) string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		t, err := h.store.GetTask(r.Context(), id)
+		if err != nil {
+			names = append(names, id.String()[:8])
+			continue
+		}
+		name := id.String()[:8]
+		if t.Title != "" {
+			name = strconv.Quote(t.Title)
+		}
+		names = append(names, name+" ("+t.Status+")")
 	}
-	if err := h.store.DeleteTask(r.Context(), id); err != nil {
+	return strings.Join(names, ", ")
+}
+
+// DeleteTask stops a task if it is still active and removes it and its
+// data. A task whose commit has already landed is refused with 409.
+func (h *Handler) DeleteTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	if err := h.runner.DeleteTask(id); err != nil {
+		if errors.Is(err, runner.ErrCommitLanded) {
+			http.Error(w, "commit already landed; delete the task once it is done", http.StatusConflict)
+			return
+		}
 		logger.Handler.Error("delete task", "task", id, "error", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
@@ -194,9 +762,17 @@ func (h *Handler) DeleteTask(w http.ResponseWriter, r *http.Request, id uuid.UUI
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// GetEvents returns the event timeline for a task.
+// GetEvents returns the event timeline for a task. With ?expand=true each
+// event's data is decoded into the typed fields for its event type.
+// ?types= (comma-separated) keeps only those event types, and ?since_id=N
+// only events after N for incremental polling.
 func (h *Handler) GetEvents(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
-	events, err := h.store.GetEvents(r.Context(), id)
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := h.store.GetEventsFiltered(r.Context(), id, filter)
 	if err != nil {
 		logger.Handler.Error("get events", "task", id, "error", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -205,9 +781,111 @@ func (h *Handler) GetEvents(w http.ResponseWriter, r *http.Request, id uuid.UUID
 	if events == nil {
 		events = []store.TaskEvent{}
 	}
+	if r.URL.Query().Get("expand") == "true" {
+		expanded := make([]expandedEvent, len(events))
+		for i, ev := range events {
+			expanded[i] = expandEvent(ev)
+		}
+		writeJSON(w, http.StatusOK, expanded)
+		return
+	}
 	writeJSON(w, http.StatusOK, events)
 }
 
+// sessionInfo describes the Claude Code session a task carries between turns.
+type sessionInfo struct {
+	SessionID  string   `json:"session_id"`
+	FreshStart bool     `json:"fresh_start"`
+	Turns      int      `json:"turns"`
+	History    []string `json:"history"` // distinct session IDs reported by each turn, oldest first
+}
+
+// GetCommand returns the last command a turn of the task was started with,
+// from its most recent container_start event, so a failure can be
+// reproduced by hand.
+func (h *Handler) GetCommand(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	if _, err := h.store.GetTask(r.Context(), id); err != nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	events, err := h.store.GetEventsFiltered(r.Context(), id, store.EventFilter{
+		Types: []store.EventType{store.EventTypeContainerStart},
+	})
+	if err != nil {
+		logger.Handler.Error("get events for command", "task", id, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(events) == 0 {
+		http.Error(w, "no container has been started for this task", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, expandEvent(events[len(events)-1]))
+}
+
+// CompactTask gzip-compresses the saved turn outputs of a done, failed or
+// cancelled task. Logs and output downloads keep working on compacted tasks.
+func (h *Handler) CompactTask(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	task, err := h.store.GetTask(r.Context(), id)
+	if err != nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	switch task.Status {
+	case "done", "failed", "cancelled":
+	default:
+		http.Error(w, "only done, failed or cancelled tasks can be compacted", http.StatusConflict)
+		return
+	}
+	n, err := h.store.CompactTask(r.Context(), id)
+	if err != nil {
+		logger.Handler.Error("compact task", "task", id, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"compacted": n})
+}
+
+// GetSession returns the stored session ID for a task along with every
+// session ID observed in its output events, to help debug --resume issues.
+func (h *Handler) GetSession(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	task, err := h.store.GetTask(r.Context(), id)
+	if err != nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	events, err := h.store.GetEvents(r.Context(), id)
+	if err != nil {
+		logger.Handler.Error("get events for session", "task", id, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	info := sessionInfo{
+		FreshStart: task.FreshStart,
+		Turns:      task.Turns,
+		History:    []string{},
+	}
+	if task.SessionID != nil {
+		info.SessionID = *task.SessionID
+	}
+	for _, ev := range events {
+		if ev.EventType != store.EventTypeOutput {
+			continue
+		}
+		var data struct {
+			SessionID string `json:"session_id"`
+		}
+		if json.Unmarshal(ev.Data, &data) != nil || data.SessionID == "" {
+			continue
+		}
+		if n := len(info.History); n == 0 || info.History[n-1] != data.SessionID {
+			info.History = append(info.History, data.SessionID)
+		}
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
 // ServeOutput serves a raw turn output file for a task.
 func (h *Handler) ServeOutput(w http.ResponseWriter, r *http.Request, id uuid.UUID, filename string) {
 	// Strict whitelist: only allow expected turn output filenames.
@@ -225,7 +903,9 @@ func (h *Handler) ServeOutput(w http.ResponseWriter, r *http.Request, id uuid.UU
 		return
 	}
 
-	if _, err := os.Stat(fullPath); err != nil {
+	// Outputs of compacted tasks are stored gzipped; ReadOutput inflates them.
+	data, err := h.store.ReadOutput(id, filename)
+	if err != nil {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
@@ -235,7 +915,30 @@ func (h *Handler) ServeOutput(w http.ResponseWriter, r *http.Request, id uuid.UU
 	} else {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	}
-	http.ServeFile(w, r, fullPath)
+	http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(data))
+}
+
+// GenerateTaskTitle generates a title for one task. With ?wait=true it runs
+// synchronously and returns the title; otherwise it starts generation in the
+// background and returns 202 Accepted.
+func (h *Handler) GenerateTaskTitle(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	task, err := h.store.GetTask(r.Context(), id)
+	if err != nil {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("wait") != "true" {
+		go h.runner.GenerateTitle(task.ID, task.Prompt)
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "generating"})
+		return
+	}
+	title, err := h.runner.GenerateTitleContext(r.Context(), task.ID, task.Prompt)
+	if err != nil {
+		logger.Handler.Error("generate title", "task", id, "error", err)
+		http.Error(w, "title generation failed", http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"title": title})
 }
 
 // GenerateMissingTitles triggers background title generation for untitled tasks.
@@ -0,0 +1,1321 @@
+package handler
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"changkun.de/wallfacer/internal/instructions"
+	"changkun.de/wallfacer/internal/runner"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// previewResponse is the JSON shape returned by PreviewTask.
+type previewResponse struct {
+	Prompt string   `json:"prompt"`
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors"`
+}
+
+func callPreviewTask(t *testing.T, h *Handler, body string) previewResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks/preview", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.PreviewTask(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PreviewTask returned %d: %s", w.Code, w.Body.String())
+	}
+	var resp previewResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal preview response: %v", err)
+	}
+	return resp
+}
+
+func TestListTasksPaginates(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	for _, p := range []string{"a", "b", "c"} {
+		h.store.CreateTask(ctx, p, 5, false)
+	}
+	done, _ := h.store.CreateTask(ctx, "d", 5, false)
+	h.store.UpdateTaskStatus(ctx, done.ID, "done")
+
+	list := func(query string) (*httptest.ResponseRecorder, []store.Task) {
+		w := httptest.NewRecorder()
+		h.ListTasks(w, httptest.NewRequest(http.MethodGet, "/api/tasks"+query, nil))
+		var tasks []store.Task
+		json.Unmarshal(w.Body.Bytes(), &tasks)
+		return w, tasks
+	}
+
+	w, tasks := list("?status=backlog&offset=1&limit=1")
+	if w.Code != http.StatusOK || len(tasks) != 1 || tasks[0].Prompt != "b" {
+		t.Fatalf("backlog page = %d %+v, want only b", w.Code, tasks)
+	}
+	if got := w.Header().Get("X-Total-Count"); got != "3" {
+		t.Errorf("X-Total-Count = %q, want 3", got)
+	}
+	if w, _ := list(""); w.Header().Get("X-Total-Count") != "4" {
+		t.Errorf("unfiltered X-Total-Count = %q, want 4", w.Header().Get("X-Total-Count"))
+	}
+	for _, q := range []string{"?status=bogus", "?limit=-1", "?offset=x"} {
+		if w, _ := list(q); w.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %d, want 400", q, w.Code)
+		}
+	}
+}
+
+func TestPreviewTaskValid(t *testing.T) {
+	h := newTestHandler(t)
+	resp := callPreviewTask(t, h, `{"prompt":"fix the tests","commit_title":"prefix"}`)
+	if !resp.Valid || len(resp.Errors) != 0 {
+		t.Fatalf("expected valid preview, got %+v", resp)
+	}
+	if resp.Prompt != "fix the tests" {
+		t.Errorf("prompt = %q, want %q", resp.Prompt, "fix the tests")
+	}
+
+	tasks, _ := h.store.ListTasks(context.Background(), true)
+	if len(tasks) != 0 {
+		t.Fatalf("preview must not create tasks, found %d", len(tasks))
+	}
+}
+
+func TestPreviewTaskReportsErrors(t *testing.T) {
+	h := newTestHandler(t)
+	resp := callPreviewTask(t, h, `{"prompt":"  ","commit_title":"middle"}`)
+	if resp.Valid {
+		t.Fatal("expected invalid preview")
+	}
+	if len(resp.Errors) != 2 {
+		t.Fatalf("expected 2 errors, got %v", resp.Errors)
+	}
+}
+
+func TestPreviewTaskValidatesPriority(t *testing.T) {
+	h := newTestHandler(t)
+	if resp := callPreviewTask(t, h, `{"prompt":"p","priority":"urgent"}`); !resp.Valid {
+		t.Fatalf("expected urgent to be accepted, got %v", resp.Errors)
+	}
+	resp := callPreviewTask(t, h, `{"prompt":"p","priority":"critical"}`)
+	if resp.Valid || len(resp.Errors) != 1 || resp.Errors[0] != "invalid priority" {
+		t.Fatalf("expected invalid priority error, got %+v", resp)
+	}
+}
+
+func TestPreviewTaskFromTemplate(t *testing.T) {
+	s, err := store.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(s, runner.NewRunner(s, runner.RunnerConfig{
+		PromptPrefix: "Be brief.",
+	}), t.TempDir(), nil)
+	if err := s.SaveTemplate(context.Background(), store.Template{Name: "weekly", Prompt: "update dependencies"}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks/preview?template=weekly", nil)
+	w := httptest.NewRecorder()
+	h.PreviewTask(w, req)
+	var resp previewResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("PreviewTask returned %d: %s", w.Code, w.Body.String())
+	}
+	if want := "Be brief.\n\nupdate dependencies"; resp.Prompt != want || !resp.Valid {
+		t.Fatalf("preview = %+v, want valid with prompt %q", resp, want)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/tasks/preview?template=missing", nil)
+	w = httptest.NewRecorder()
+	h.PreviewTask(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing template, got %d", w.Code)
+	}
+}
+
+func TestCreateTaskSandboxImageAllowlist(t *testing.T) {
+	s, err := store.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(s, runner.NewRunner(s, runner.RunnerConfig{
+		SandboxImageAllowlist: []string{"claude-go:1.22"},
+	}), t.TempDir(), nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(`{"prompt":"p","sandbox_image":"claude-rust:1.80"}`))
+	w := httptest.NewRecorder()
+	h.CreateTask(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for image not on the allowlist, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(`{"prompt":"p","sandbox_image":"claude-go:1.22"}`))
+	w = httptest.NewRecorder()
+	h.CreateTask(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var task store.Task
+	json.Unmarshal(w.Body.Bytes(), &task)
+	got, _ := s.GetTask(context.Background(), task.ID)
+	if got.SandboxImage != "claude-go:1.22" {
+		t.Errorf("SandboxImage = %q, want claude-go:1.22", got.SandboxImage)
+	}
+}
+
+func TestCreateTaskMergeStrategy(t *testing.T) {
+	h := newTestHandler(t)
+	create := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.CreateTask(w, httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(body)))
+		return w
+	}
+
+	if w := create(`{"prompt":"p","merge_strategy":"rebase"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown merge_strategy returned %d, want 400", w.Code)
+	}
+	w := create(`{"prompt":"p","merge_strategy":"pull-request"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var task store.Task
+	json.Unmarshal(w.Body.Bytes(), &task)
+	got, _ := h.store.GetTask(context.Background(), task.ID)
+	if got.MergeStrategy != store.MergeStrategyPullRequest {
+		t.Errorf("MergeStrategy = %q, want pull-request", got.MergeStrategy)
+	}
+}
+
+func TestCreateTaskDeadline(t *testing.T) {
+	h := newTestHandler(t)
+	create := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.CreateTask(w, httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(body)))
+		return w
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	for _, body := range []string{
+		`{"prompt":"p","deadline_in":"soon"}`,
+		`{"prompt":"p","deadline_in":"-1h"}`,
+		`{"prompt":"p","deadline":"2000-01-01T00:00:00Z"}`,
+		`{"prompt":"p","deadline":"` + future + `","deadline_in":"1h"}`,
+	} {
+		if w := create(body); w.Code != http.StatusBadRequest {
+			t.Errorf("body %s: expected 400, got %d", body, w.Code)
+		}
+	}
+
+	before := time.Now()
+	w := create(`{"prompt":"p","deadline_in":"48h"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var task store.Task
+	json.Unmarshal(w.Body.Bytes(), &task)
+	got, _ := h.store.GetTask(context.Background(), task.ID)
+	if got.Deadline == nil || got.Deadline.Before(before.Add(48*time.Hour)) || got.Deadline.After(time.Now().Add(48*time.Hour)) {
+		t.Errorf("Deadline = %v, want 48h from creation", got.Deadline)
+	}
+	if w := create(`{"prompt":"p","deadline":"` + future + `"}`); w.Code != http.StatusCreated {
+		t.Errorf("absolute deadline: expected 201, got %d", w.Code)
+	}
+}
+
+func TestCreateTaskEnv(t *testing.T) {
+	h := newTestHandler(t)
+	create := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.CreateTask(w, httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(body)))
+		return w
+	}
+
+	for _, body := range []string{
+		`{"prompt":"p","env":{"1BAD":"x"}}`,
+		`{"prompt":"p","env":{"A-B":"x"}}`,
+		`{"prompt":"p","env":{"OK":"line\nbreak"}}`,
+	} {
+		if w := create(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %d, want 400", body, w.Code)
+		}
+	}
+	w := create(`{"prompt":"p","env":{"DATABASE_URL":"postgres://u:secret@db/test"}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("create response leaks the env value: %s", w.Body.String())
+	}
+	var task store.Task
+	json.Unmarshal(w.Body.Bytes(), &task)
+	if task.Env["DATABASE_URL"] != "***" {
+		t.Errorf("create response Env = %v, want the name with a redacted value", task.Env)
+	}
+	got, _ := h.store.GetTask(context.Background(), task.ID)
+	if got.Env["DATABASE_URL"] != "postgres://u:secret@db/test" {
+		t.Errorf("Env = %v", got.Env)
+	}
+
+	// Reads of the board redact the value too.
+	for name, read := range map[string]func(*httptest.ResponseRecorder){
+		"list": func(w *httptest.ResponseRecorder) {
+			h.ListTasks(w, httptest.NewRequest(http.MethodGet, "/api/tasks", nil))
+		},
+		"search": func(w *httptest.ResponseRecorder) {
+			h.SearchTasks(w, httptest.NewRequest(http.MethodGet, "/api/tasks/search?q=p", nil))
+		},
+		"export": func(w *httptest.ResponseRecorder) {
+			h.ExportTasks(w, httptest.NewRequest(http.MethodGet, "/api/export", nil))
+		},
+		"update": func(w *httptest.ResponseRecorder) {
+			h.UpdateTask(w, httptest.NewRequest(http.MethodPatch, "/api/tasks/"+task.ID.String(), strings.NewReader(`{"position":3}`)), task.ID)
+		},
+	} {
+		w := httptest.NewRecorder()
+		read(w)
+		if !strings.Contains(w.Body.String(), "DATABASE_URL") || strings.Contains(w.Body.String(), "secret") {
+			t.Errorf("%s: env not redacted: %s", name, w.Body.String())
+		}
+	}
+	if got, _ := h.store.GetTask(context.Background(), task.ID); got.Env["DATABASE_URL"] != "postgres://u:secret@db/test" {
+		t.Errorf("redacting a response changed the stored value: %v", got.Env)
+	}
+
+	// The log bundle names the variable but not its value.
+	dl := httptest.NewRecorder()
+	h.DownloadLogs(dl, httptest.NewRequest(http.MethodGet, "/", nil), task.ID)
+	zr, err := zip.NewReader(bytes.NewReader(dl.Body.Bytes()), int64(dl.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if strings.Contains(string(data), "secret") {
+			t.Errorf("%s leaks the env value", f.Name)
+		}
+	}
+}
+
+func TestTaskModelAllowlist(t *testing.T) {
+	s, err := store.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(s, runner.NewRunner(s, runner.RunnerConfig{
+		ModelAllowlist: []string{"haiku", "opus"},
+	}), t.TempDir(), nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(`{"prompt":"p","model":"opsu"}`))
+	w := httptest.NewRecorder()
+	h.CreateTask(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for model not on the allowlist, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(`{"prompt":"p","model":"haiku"}`))
+	w = httptest.NewRecorder()
+	h.CreateTask(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var task store.Task
+	json.Unmarshal(w.Body.Bytes(), &task)
+
+	patch := func(body string) int {
+		req := httptest.NewRequest(http.MethodPatch, "/api/tasks/"+task.ID.String(), strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.UpdateTask(w, req, task.ID)
+		return w.Code
+	}
+	if code := patch(`{"model":"sonnet"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 when patching to a model not on the allowlist, got %d", code)
+	}
+	if code := patch(`{"model":"opus"}`); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	got, _ := s.GetTask(context.Background(), task.ID)
+	if got.Model != "opus" {
+		t.Errorf("Model = %q, want opus", got.Model)
+	}
+	if code := patch(`{"model":""}`); code != http.StatusOK {
+		t.Fatalf("clearing the model: expected 200, got %d", code)
+	}
+	if got, _ := s.GetTask(context.Background(), task.ID); got.Model != "" {
+		t.Errorf("Model = %q after clearing, want default", got.Model)
+	}
+}
+
+func TestBatchCreateTasksRejectsInvalidBodies(t *testing.T) {
+	h := newTestHandler(t)
+	for _, body := range []string{
+		`{"prompts":[]}`,
+		`{"prompts":["ok","  "]}`,
+		`not json`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/tasks/batch", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.BatchCreateTasks(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("body %s: expected 400, got %d", body, w.Code)
+		}
+	}
+
+	tasks, _ := h.store.ListTasks(context.Background(), true)
+	if len(tasks) != 0 {
+		t.Fatalf("rejected batches must not create tasks, found %d", len(tasks))
+	}
+}
+
+func TestBulkTasks(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	done, _ := h.store.CreateTask(ctx, "done", 5, false)
+	h.store.UpdateTaskStatus(ctx, done.ID, "done")
+	done2, _ := h.store.CreateTask(ctx, "done2", 5, false)
+	h.store.UpdateTaskStatus(ctx, done2.ID, "done")
+	backlog, _ := h.store.CreateTask(ctx, "backlog", 5, false)
+
+	bulk := func(query, body string) (int, []bulkResult) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/tasks/bulk"+query, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.BulkTasks(w, req)
+		var resp struct {
+			Results []bulkResult `json:"results"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Results
+	}
+
+	for _, body := range []string{
+		`{"action":"purge","ids":["` + done.ID.String() + `"]}`,
+		`{"action":"archive"}`,
+		`{"action":"archive","status":"done","ids":["` + done.ID.String() + `"]}`,
+		`{"action":"archive","status":"bogus"}`,
+	} {
+		if code, _ := bulk("", body); code != http.StatusBadRequest {
+			t.Errorf("body %s: expected 400, got %d", body, code)
+		}
+	}
+
+	code, res := bulk("", `{"action":"archive","status":"done"}`)
+	if code != http.StatusOK || len(res) != 2 || !res[0].OK || !res[1].OK {
+		t.Fatalf("archive by status: code %d, results %+v", code, res)
+	}
+	if got, _ := h.store.GetTask(ctx, done2.ID); !got.Archived {
+		t.Error("done task not archived")
+	}
+
+	code, res = bulk("", `{"action":"archive","ids":["`+backlog.ID.String()+`","`+uuid.NewString()+`"]}`)
+	if code != http.StatusOK || len(res) != 2 || res[0].OK || res[0].Error != errNotArchivable.Error() || res[1].Error != "task not found" {
+		t.Fatalf("archive by ids: code %d, results %+v", code, res)
+	}
+
+	_, res = bulk("", `{"action":"unarchive","status":"done"}`)
+	if len(res) != 2 || !res[0].OK {
+		t.Fatalf("unarchive by status: results %+v", res)
+	}
+
+	ids := `{"action":"delete","ids":["` + done.ID.String() + `","` + backlog.ID.String() + `"]}`
+	_, res = bulk("", ids)
+	if !res[0].OK || res[1].OK || res[1].Error != errNotTerminal.Error() {
+		t.Fatalf("delete without force: results %+v", res)
+	}
+	if _, err := h.store.GetTask(ctx, backlog.ID); err != nil {
+		t.Fatal("non-terminal task deleted without force")
+	}
+	_, res = bulk("?force=true", `{"action":"delete","ids":["`+backlog.ID.String()+`"]}`)
+	if len(res) != 1 || !res[0].OK {
+		t.Fatalf("delete with force: results %+v", res)
+	}
+	if _, err := h.store.GetTask(ctx, backlog.ID); err == nil {
+		t.Error("task still present after forced delete")
+	}
+}
+
+func TestExportImportTasks(t *testing.T) {
+	src := newTestHandler(t)
+	ctx := context.Background()
+	done, _ := src.store.CreateTask(ctx, "done", 5, false)
+	src.store.UpdateTaskStatus(ctx, done.ID, "done")
+	src.store.InsertEvent(ctx, done.ID, store.EventTypeStateChange, map[string]string{"to": "done"})
+	src.store.UpdateTaskResult(ctx, done.ID, "ok", "sess1", "end_turn", 1)
+	src.store.UpdateTaskWorktrees(ctx, done.ID, map[string]string{"/repo": "/wt/repo"}, "task/abc")
+	src.store.UpdateTaskCommitHashes(ctx, done.ID, map[string]string{"/repo": "deadbeef"})
+	running, _ := src.store.CreateTask(ctx, "running", 5, false)
+	src.store.UpdateTaskStatus(ctx, running.ID, "in_progress")
+	waiting, _ := src.store.CreateTask(ctx, "waiting", 5, false)
+	src.store.UpdateTaskStatus(ctx, waiting.ID, "waiting")
+
+	w := httptest.NewRecorder()
+	src.ExportTasks(w, httptest.NewRequest(http.MethodGet, "/api/export?events=true", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: expected 200, got %d", w.Code)
+	}
+	export := w.Body.String()
+	if n := strings.Count(export, "\n"); n != 3 {
+		t.Fatalf("export has %d lines, want 3:\n%s", n, export)
+	}
+
+	dst := newTestHandler(t)
+	existing, _ := dst.store.CreateTask(ctx, "existing", 5, false)
+	dup := `{"task":{"id":"` + existing.ID.String() + `","prompt":"other","status":"backlog"}}` + "\n"
+
+	for _, body := range []string{"not json", `{"task":{"prompt":"p","status":"backlog"}}`, `{"task":{"id":"` + uuid.NewString() + `","prompt":"p","status":"bogus"}}`} {
+		w = httptest.NewRecorder()
+		dst.ImportTasks(w, httptest.NewRequest(http.MethodPost, "/api/import", strings.NewReader(export+body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("body %q: expected 400, got %d", body, w.Code)
+		}
+	}
+	if tasks, _ := dst.store.ListTasks(ctx, true); len(tasks) != 1 {
+		t.Fatalf("rejected imports must not create tasks, found %d", len(tasks))
+	}
+
+	w = httptest.NewRecorder()
+	dst.ImportTasks(w, httptest.NewRequest(http.MethodPost, "/api/import", strings.NewReader(export+dup)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("import: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Imported []uuid.UUID `json:"imported"`
+		Skipped  []uuid.UUID `json:"skipped"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Imported) != 3 || len(resp.Skipped) != 1 || resp.Skipped[0] != existing.ID {
+		t.Fatalf("import result = %+v", resp)
+	}
+	if got, _ := dst.store.GetTask(ctx, existing.ID); got.Prompt != "existing" {
+		t.Error("import overwrote an existing task")
+	}
+	if got, err := dst.store.GetTask(ctx, done.ID); err != nil || got.Status != "done" {
+		t.Errorf("imported done task = %+v, %v", got, err)
+	} else if got.SessionID != nil || got.WorktreePaths != nil || got.BranchName != "" || got.CommitHashes != nil {
+		t.Errorf("imported task kept the exporting host's state: %+v", got)
+	}
+	if evts, _ := dst.store.GetEvents(ctx, done.ID); len(evts) != 1 || evts[0].EventType != store.EventTypeStateChange {
+		t.Errorf("imported events = %+v", evts)
+	}
+	for _, id := range []uuid.UUID{running.ID, waiting.ID} {
+		if got, _ := dst.store.GetTask(ctx, id); got.Status != "failed" {
+			t.Errorf("task %q imported as %q, want failed", got.Prompt, got.Status)
+		}
+	}
+}
+
+func TestCloneTask(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	src, _ := h.store.CreateTask(ctx, "original prompt", 30, false)
+	fresh := true
+	h.store.UpdateTaskBacklog(ctx, src.ID, nil, nil, &fresh, nil)
+	h.store.SetTaskModel(ctx, src.ID, "opus")
+	h.store.UpdateTaskStatus(ctx, src.ID, "done")
+	h.store.UpdateTaskResult(ctx, src.ID, "ok", "sess1", "", 3)
+
+	clone := func(body string) (int, store.Task) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/tasks/"+src.ID.String()+"/clone", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.CloneTask(w, req, src.ID)
+		var task store.Task
+		json.Unmarshal(w.Body.Bytes(), &task)
+		return w.Code, task
+	}
+
+	code, got := clone("")
+	if code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+	if got.ID == src.ID || got.Status != "backlog" || got.Prompt != "original prompt" ||
+		got.Timeout != 30 || got.Model != "opus" || !got.FreshStart || got.SessionID != nil || got.Turns != 0 {
+		t.Errorf("clone = %+v", got)
+	}
+
+	if code, got = clone(`{"prompt":"new prompt"}`); code != http.StatusCreated || got.Prompt != "new prompt" {
+		t.Errorf("clone with prompt override: code %d, prompt %q", code, got.Prompt)
+	}
+	if code, _ = clone(`{"prompt":"  "}`); code != http.StatusBadRequest {
+		t.Errorf("blank prompt override: expected 400, got %d", code)
+	}
+}
+
+func TestCreateTaskFromTemplate(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	for _, body := range []string{`{"name":"bad name","prompt":"p"}`, `{"name":"weekly","prompt":" "}`, `{"name":"weekly","prompt":"p","schedule":"every night"}`} {
+		w := httptest.NewRecorder()
+		h.SaveTemplate(w, httptest.NewRequest(http.MethodPost, "/api/templates", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("body %s: expected 400, got %d", body, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.SaveTemplate(w, httptest.NewRequest(http.MethodPost, "/api/templates",
+		strings.NewReader(`{"name":"weekly","prompt":"update dependencies","timeout":60,"model":"opus"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("save template: expected 201, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ListTemplates(w, httptest.NewRequest(http.MethodGet, "/api/templates", nil))
+	var templates []store.Template
+	json.Unmarshal(w.Body.Bytes(), &templates)
+	if len(templates) != 1 || templates[0].Name != "weekly" {
+		t.Fatalf("templates = %+v", templates)
+	}
+
+	create := func(query, body string) (int, store.Task) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.CreateTask(w, httptest.NewRequest(http.MethodPost, "/api/tasks"+query, strings.NewReader(body)))
+		var task store.Task
+		json.Unmarshal(w.Body.Bytes(), &task)
+		return w.Code, task
+	}
+	code, task := create("?template=weekly", "")
+	if code != http.StatusCreated || task.Prompt != "update dependencies" || task.Timeout != 60 || task.Model != "opus" {
+		t.Errorf("from template: code %d, task %+v", code, task)
+	}
+	code, task = create("?template=weekly", `{"prompt":"only this repo","timeout":5}`)
+	if code != http.StatusCreated || task.Prompt != "only this repo" || task.Timeout != 5 || task.Model != "opus" {
+		t.Errorf("with overrides: code %d, task %+v", code, task)
+	}
+	if code, _ = create("?template=missing", ""); code != http.StatusNotFound {
+		t.Errorf("unknown template: expected 404, got %d", code)
+	}
+	if code, _ = create("", ""); code != http.StatusBadRequest {
+		t.Errorf("empty body without template: expected 400, got %d", code)
+	}
+	if tasks, _ := h.store.ListTasks(ctx, false); len(tasks) != 2 {
+		t.Errorf("created %d tasks, want 2", len(tasks))
+	}
+
+	del := func(name string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/templates/"+name, nil)
+		req.SetPathValue("name", name)
+		w := httptest.NewRecorder()
+		h.DeleteTemplate(w, req)
+		return w.Code
+	}
+	if code := del("weekly"); code != http.StatusNoContent {
+		t.Fatalf("delete template: expected 204, got %d", code)
+	}
+	if code := del("weekly"); code != http.StatusNotFound {
+		t.Errorf("delete missing template: expected 404, got %d", code)
+	}
+	if code, _ = create("?template=weekly", ""); code != http.StatusNotFound {
+		t.Errorf("create from deleted template: expected 404, got %d", code)
+	}
+}
+
+func TestResumeTaskRejectsInvalidModel(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	task, _ := h.store.CreateTask(ctx, "p", 5, false)
+	h.store.UpdateTaskStatus(ctx, task.ID, "failed")
+	h.store.UpdateTaskResult(ctx, task.ID, "boom", "sess1", "", 1)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks/"+task.ID.String()+"/resume",
+		strings.NewReader(`{"timeout":30,"model":"bad model; rm -rf"}`))
+	w := httptest.NewRecorder()
+	h.ResumeTask(w, req, task.ID)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+
+	got, _ := h.store.GetTask(ctx, task.ID)
+	if got.Status != "failed" || got.Model != "" {
+		t.Fatalf("rejected resume must not change the task, got status=%q model=%q", got.Status, got.Model)
+	}
+}
+
+func TestResumeTaskKeepsModelWhenClaimFails(t *testing.T) {
+	s, err := store.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(s, runner.NewRunner(s, runner.RunnerConfig{NoWorktree: true}), t.TempDir(), nil)
+	ctx := context.Background()
+	holder, _ := s.CreateTask(ctx, "holder", 5, false)
+	s.UpdateTaskStatus(ctx, holder.ID, "waiting")
+	task, _ := s.CreateTask(ctx, "p", 5, false)
+	s.UpdateTaskStatus(ctx, task.ID, "failed")
+	s.UpdateTaskResult(ctx, task.ID, "boom", "sess1", "", 1)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks/"+task.ID.String()+"/resume",
+		strings.NewReader(`{"model":"opus"}`))
+	w := httptest.NewRecorder()
+	h.ResumeTask(w, req, task.ID)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", w.Code)
+	}
+	got, _ := s.GetTask(ctx, task.ID)
+	if got.Status != "failed" || got.Model != "" {
+		t.Fatalf("refused resume must not change the task, got status=%q model=%q", got.Status, got.Model)
+	}
+}
+
+func TestValidModelName(t *testing.T) {
+	for _, v := range []string{"", "opus", "claude-sonnet-4-5-20250929", "us.anthropic.claude-opus-4-1-v1:0"} {
+		if !validModelName(v) {
+			t.Errorf("expected %q to be valid", v)
+		}
+	}
+	for _, v := range []string{" opus", "opus --dangerous", "-flag", "a\nb"} {
+		if validModelName(v) {
+			t.Errorf("expected %q to be invalid", v)
+		}
+	}
+}
+
+func TestUpdateTaskRejectsSecondTaskWithoutWorktrees(t *testing.T) {
+	s, err := store.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(s, runner.NewRunner(s, runner.RunnerConfig{NoWorktree: true}), t.TempDir(), nil)
+	ctx := context.Background()
+
+	running, _ := s.CreateTask(ctx, "running", 5, false)
+	s.UpdateTaskStatus(ctx, running.ID, "waiting")
+	next, _ := s.CreateTask(ctx, "next", 5, false)
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/tasks/"+next.ID.String(),
+		strings.NewReader(`{"status":"in_progress"}`))
+	w := httptest.NewRecorder()
+	h.UpdateTask(w, req, next.ID)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+	got, _ := s.GetTask(ctx, next.ID)
+	if got.Status != "backlog" {
+		t.Fatalf("rejected task must stay in backlog, got %q", got.Status)
+	}
+}
+
+// TestUpdateTaskRejectsRunnerStatuses verifies that queued and conflict,
+// which only the runner enters, cannot be set through a PATCH.
+func TestUpdateTaskRejectsRunnerStatuses(t *testing.T) {
+	h := newTestHandler(t)
+	task, _ := h.store.CreateTask(context.Background(), "p", 5, false)
+	for _, status := range []string{"queued", "conflict"} {
+		req := httptest.NewRequest(http.MethodPatch, "/api/tasks/"+task.ID.String(),
+			strings.NewReader(`{"status":"`+status+`"}`))
+		w := httptest.NewRecorder()
+		h.UpdateTask(w, req, task.ID)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("PATCH status %s: got %d, want 400", status, w.Code)
+		}
+	}
+}
+
+func TestUpdateTaskRejectsUnfinishedDependencies(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	refactor, _ := h.store.CreateTask(ctx, "refactor", 5, false)
+	h.store.UpdateTaskTitle(ctx, refactor.ID, "Refactor parser")
+
+	body := `{"prompt":"write tests","depends_on":["` + refactor.ID.String() + `"]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.CreateTask(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("CreateTask returned %d: %s", w.Code, w.Body.String())
+	}
+	var tests store.Task
+	json.Unmarshal(w.Body.Bytes(), &tests)
+	if len(tests.DependsOn) != 1 || tests.DependsOn[0] != refactor.ID {
+		t.Fatalf("depends_on = %v, want [%s]", tests.DependsOn, refactor.ID)
+	}
+
+	req = httptest.NewRequest(http.MethodPatch, "/api/tasks/"+tests.ID.String(),
+		strings.NewReader(`{"status":"in_progress"}`))
+	w = httptest.NewRecorder()
+	h.UpdateTask(w, req, tests.ID)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"Refactor parser" (backlog)`) {
+		t.Errorf("error should name the unfinished dependency, got %q", w.Body.String())
+	}
+	got, _ := h.store.GetTask(ctx, tests.ID)
+	if got.Status != "backlog" {
+		t.Fatalf("rejected task must stay in backlog, got %q", got.Status)
+	}
+
+	body = `{"prompt":"p","depends_on":["` + uuid.New().String() + `"]}`
+	req = httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	h.CreateTask(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown dependency, got %d", w.Code)
+	}
+}
+
+func TestToggleReviewTaskLogsSystemEvent(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	task, _ := h.store.CreateTask(ctx, "p", 5, false)
+	h.store.UpdateTaskStatus(ctx, task.ID, "done")
+
+	for _, want := range []string{"Marked reviewed.", "Marked unreviewed."} {
+		w := httptest.NewRecorder()
+		h.ToggleReviewTask(w, httptest.NewRequest(http.MethodPost, "/api/tasks/"+task.ID.String()+"/review/toggle", nil), task.ID)
+		if w.Code != http.StatusOK {
+			t.Fatalf("toggle returned %d", w.Code)
+		}
+		evts, _ := h.store.GetEvents(ctx, task.ID)
+		last := evts[len(evts)-1]
+		if last.EventType != store.EventTypeSystem || !strings.Contains(string(last.Data), want) {
+			t.Errorf("last event = %s %s, want a system event %q", last.EventType, last.Data, want)
+		}
+	}
+}
+
+func TestGetEventsExpand(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	task, _ := h.store.CreateTask(ctx, "p", 5, false)
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeStateChange, map[string]string{"from": "backlog", "to": "in_progress"})
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeError, map[string]string{"error": "killed", "exit_code": "137", "signal": "9"})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/events?expand=true", nil)
+	w := httptest.NewRecorder()
+	h.GetEvents(w, req, task.ID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GetEvents returned %d", w.Code)
+	}
+
+	var events []struct {
+		EventType string          `json:"event_type"`
+		Data      json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+
+	var change stateChangeData
+	json.Unmarshal(events[0].Data, &change)
+	if change.From != "backlog" || change.To != "in_progress" {
+		t.Errorf("unexpected state_change data: %s", events[0].Data)
+	}
+	var failure struct {
+		Error    string `json:"error"`
+		ExitCode int    `json:"exit_code"`
+		Signal   int    `json:"signal"`
+	}
+	json.Unmarshal(events[1].Data, &failure)
+	if failure.Error != "killed" || failure.ExitCode != 137 || failure.Signal != 9 {
+		t.Errorf("expected typed exit_code/signal, got %s", events[1].Data)
+	}
+}
+
+func TestGetCommand(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	task, _ := h.store.CreateTask(ctx, "p", 5, false)
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/command", nil)
+		w := httptest.NewRecorder()
+		h.GetCommand(w, req, task.ID)
+		return w
+	}
+	if w := get(); w.Code != http.StatusNotFound {
+		t.Fatalf("before any container: status %d, want 404", w.Code)
+	}
+
+	for _, cmd := range []string{"docker sandbox exec wf-1 claude -p first", "docker sandbox exec wf-1 claude -p second"} {
+		h.store.InsertEvent(ctx, task.ID, store.EventTypeContainerStart, store.ContainerStart{
+			Command: cmd, Args: strings.Fields(cmd),
+		})
+	}
+	w := get()
+	if w.Code != http.StatusOK {
+		t.Fatalf("GetCommand returned %d", w.Code)
+	}
+	var resp struct {
+		EventType string               `json:"event_type"`
+		Data      store.ContainerStart `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.EventType != "container_start" || resp.Data.Command != "docker sandbox exec wf-1 claude -p second" {
+		t.Errorf("want the last invocation, got %s", w.Body.String())
+	}
+}
+
+func TestCompactTask(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	task, _ := h.store.CreateTask(ctx, "p", 5, false)
+	if err := h.store.SaveTurnOutput(task.ID, 1, []byte(`{"result":"done"}`), []byte("warning\n")); err != nil {
+		t.Fatal(err)
+	}
+	compact := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/tasks/"+task.ID.String()+"/compact", nil)
+		w := httptest.NewRecorder()
+		h.CompactTask(w, req, task.ID)
+		return w
+	}
+
+	h.store.UpdateTaskStatus(ctx, task.ID, "in_progress")
+	if w := compact(); w.Code != http.StatusConflict {
+		t.Fatalf("in_progress task: status %d, want 409", w.Code)
+	}
+
+	h.store.UpdateTaskStatus(ctx, task.ID, "failed")
+	w := compact()
+	if w.Code != http.StatusOK {
+		t.Fatalf("CompactTask returned %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Compacted int `json:"compacted"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Compacted != 2 {
+		t.Fatalf("response = %s, want 2 files compacted", w.Body.String())
+	}
+
+	// Logs and raw outputs read through the compression.
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/logs", nil)
+	w = httptest.NewRecorder()
+	h.StreamLogs(w, req, task.ID)
+	if body := w.Body.String(); !strings.Contains(body, `{"result":"done"}`) || !strings.Contains(body, "warning") {
+		t.Errorf("stored logs after compaction = %q", body)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/outputs/turn-0001.json", nil)
+	w = httptest.NewRecorder()
+	h.ServeOutput(w, req, task.ID, "turn-0001.json")
+	if w.Code != http.StatusOK || w.Body.String() != `{"result":"done"}` {
+		t.Errorf("ServeOutput after compaction = %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestGetEventsFilter(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	task, _ := h.store.CreateTask(ctx, "p", 5, false)
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeStateChange, map[string]string{"from": "backlog", "to": "in_progress"})
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeSystem, map[string]string{"result": "noise"})
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeError, map[string]string{"error": "boom"})
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeSystem, map[string]string{"result": "more noise"})
+
+	get := func(query string) (int, []store.TaskEvent) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/events?"+query, nil)
+		w := httptest.NewRecorder()
+		h.GetEvents(w, req, task.ID)
+		var events []store.TaskEvent
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &events); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, events
+	}
+
+	if code, events := get("types=state_change,error"); code != http.StatusOK || len(events) != 2 ||
+		events[0].EventType != store.EventTypeStateChange || events[1].EventType != store.EventTypeError {
+		t.Errorf("types filter: code %d, events %+v", code, events)
+	}
+	if code, events := get("since_id=2"); code != http.StatusOK || len(events) != 2 || events[0].ID != 3 {
+		t.Errorf("since_id filter: code %d, events %+v", code, events)
+	}
+	if code, events := get("since_id=1&types=system"); code != http.StatusOK || len(events) != 2 || events[0].ID != 2 {
+		t.Errorf("combined filter: code %d, events %+v", code, events)
+	}
+	if code, events := get("since_id=10"); code != http.StatusOK || len(events) != 0 {
+		t.Errorf("since_id past the end: code %d, events %+v", code, events)
+	}
+	for _, bad := range []string{"types=bogus", "since_id=-1", "since_id=x"} {
+		if code, _ := get(bad); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", bad, code)
+		}
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	task, _ := h.store.CreateTask(ctx, "p", 5, false)
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeSystem, map[string]string{"result": "before"})
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeSystem, map[string]string{"result": "replayed"})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.StreamEvents(w, r, task.ID)
+	}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "?since_id=1&types=system,error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	events := make(chan store.TaskEvent)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var ev store.TaskEvent
+			if json.Unmarshal([]byte(data), &ev) == nil {
+				events <- ev
+			}
+		}
+		close(events)
+	}()
+	next := func() (store.TaskEvent, bool) {
+		t.Helper()
+		select {
+		case ev, ok := <-events:
+			return ev, ok
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+		}
+		return store.TaskEvent{}, false
+	}
+
+	if ev, _ := next(); ev.ID != 2 {
+		t.Fatalf("first streamed event = %d, want the replay of event 2", ev.ID)
+	}
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeFeedback, map[string]string{"message": "filtered out"})
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeError, map[string]string{"error": "live"})
+	if ev, _ := next(); ev.ID != 4 || ev.EventType != store.EventTypeError {
+		t.Fatalf("expected live error event 4, got %d %s", ev.ID, ev.EventType)
+	}
+
+	h.store.DeleteTask(ctx, task.ID)
+	if _, ok := next(); ok {
+		t.Error("stream should end when the task is deleted")
+	}
+}
+
+// TestStreamWS drives the WebSocket endpoint with a minimal client: the
+// task list arrives on connect and on changes, and a subscription replays
+// and then follows one task's events.
+func TestStreamWS(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	task, _ := h.store.CreateTask(ctx, "p", 5, false)
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeSystem, map[string]string{"result": "before"})
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeSystem, map[string]string{"result": "replayed"})
+
+	srv := httptest.NewServer(http.HandlerFunc(h.StreamWS))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: "+host+"\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: %v %v", resp, err)
+	}
+
+	send := func(v any) {
+		t.Helper()
+		payload, _ := json.Marshal(v)
+		frame := []byte{0x81, 0x80 | byte(len(payload)), 0, 0, 0, 0} // zero mask
+		if _, err := conn.Write(append(frame, payload...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recv := func() wsMessage {
+		t.Helper()
+		var hdr [2]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			t.Fatal(err)
+		}
+		n := int(hdr[1] & 0x7F)
+		switch n {
+		case 126:
+			var ext [2]byte
+			io.ReadFull(br, ext[:])
+			n = int(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			io.ReadFull(br, ext[:])
+			n = int(binary.BigEndian.Uint64(ext[:]))
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			t.Fatal(err)
+		}
+		var msg wsMessage
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			t.Fatalf("message %q: %v", payload, err)
+		}
+		return msg
+	}
+
+	if msg := recv(); msg.Type != "tasks" || len(msg.Tasks) != 1 || msg.Tasks[0].ID != task.ID {
+		t.Fatalf("first message = %+v, want the task list", msg)
+	}
+	send(map[string]any{"type": "subscribe", "task_id": uuid.New()})
+	if msg := recv(); msg.Type != "error" {
+		t.Fatalf("subscribe to unknown task: got %+v, want an error", msg)
+	}
+	send(map[string]any{"type": "subscribe", "task_id": task.ID, "since_id": 1})
+	if msg := recv(); msg.Type != "event" || *msg.TaskID != task.ID || msg.Event.ID != 2 {
+		t.Fatalf("got %+v, want the replay of event 2", msg)
+	}
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeError, map[string]string{"error": "live"})
+	if msg := recv(); msg.Type != "event" || msg.Event.ID != 3 {
+		t.Fatalf("got %+v, want live event 3", msg)
+	}
+
+	send(map[string]any{"type": "unsubscribe"})
+	// Round-trip a command so the unsubscribe is handled before the insert.
+	send(map[string]any{"type": "bogus"})
+	if msg := recv(); msg.Type != "error" {
+		t.Fatalf("got %+v, want an error for an unknown command", msg)
+	}
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeSystem, map[string]string{"result": "unsubscribed"})
+	h.store.UpdateTaskStatus(ctx, task.ID, "waiting")
+	if msg := recv(); msg.Type != "tasks" || msg.Tasks[0].Status != "waiting" {
+		t.Fatalf("got %+v, want the updated task list", msg)
+	}
+}
+
+// TestInstructionsGlobalScope verifies that ?scope=global reads and writes
+// the global instructions file without touching the workspace file.
+func TestInstructionsGlobalScope(t *testing.T) {
+	h := newTestHandler(t)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/instructions?scope=global", strings.NewReader(`{"content":"be terse"}`))
+	w := httptest.NewRecorder()
+	h.UpdateInstructions(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("update: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	data, err := os.ReadFile(instructions.GlobalFilePath(h.configDir))
+	if err != nil || string(data) != "be terse" {
+		t.Fatalf("global file = %q, %v", data, err)
+	}
+
+	for scope, want := range map[string]string{"global": "be terse", "workspace": ""} {
+		req = httptest.NewRequest(http.MethodGet, "/api/instructions?scope="+scope, nil)
+		w = httptest.NewRecorder()
+		h.GetInstructions(w, req)
+		var resp map[string]string
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp["content"] != want {
+			t.Errorf("scope %s: content = %q, want %q", scope, resp["content"], want)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/instructions?scope=bogus", nil)
+	w = httptest.NewRecorder()
+	h.GetInstructions(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid scope: expected 400, got %d", w.Code)
+	}
+}
+
+// TestGenerateTaskTitleWaitReturnsExisting verifies that ?wait=true returns
+// the task's title synchronously and that unknown tasks are rejected.
+func TestGenerateTaskTitleWaitReturnsExisting(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	task, _ := h.store.CreateTask(ctx, "p", 5, false)
+	h.store.UpdateTaskTitle(ctx, task.ID, "Existing Title")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks/"+task.ID.String()+"/title/generate?wait=true", nil)
+	w := httptest.NewRecorder()
+	h.GenerateTaskTitle(w, req, task.ID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]string
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp["title"] != "Existing Title" {
+		t.Errorf("title = %q, want Existing Title", resp["title"])
+	}
+
+	missing := uuid.New()
+	req = httptest.NewRequest(http.MethodPost, "/api/tasks/"+missing.String()+"/title/generate?wait=true", nil)
+	w = httptest.NewRecorder()
+	h.GenerateTaskTitle(w, req, missing)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing task: expected 404, got %d", w.Code)
+	}
+}
+
+func TestDownloadLogsZipsTaskRecord(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	task, _ := h.store.CreateTask(ctx, "bundle me", 5, false)
+	h.store.InsertEvent(ctx, task.ID, store.EventTypeSystem, map[string]string{"result": "hello"})
+	if err := h.store.SaveTurnOutput(task.ID, 1, []byte(`{"result":"done"}`), []byte("warning\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks/"+task.ID.String()+"/logs/download", nil)
+	w := httptest.NewRecorder()
+	h.DownloadLogs(w, req, task.ID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("DownloadLogs returned %d: %s", w.Code, w.Body.String())
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "attachment") {
+		t.Errorf("Content-Disposition = %q, want an attachment", cd)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+	root := "wallfacer-" + task.ID.String()[:8] + "/"
+	if !strings.Contains(files[root+"task.json"], "bundle me") {
+		t.Errorf("task.json missing or wrong: %q", files[root+"task.json"])
+	}
+	if files[root+"outputs/turn-0001.stderr.txt"] != "warning\n" {
+		t.Errorf("stderr output missing; archive has %v", slices.Sorted(maps.Keys(files)))
+	}
+	var traced bool
+	for name, data := range files {
+		if strings.HasPrefix(name, root+"traces/") && strings.Contains(data, "hello") {
+			traced = true
+		}
+	}
+	if !traced {
+		t.Errorf("event trace missing; archive has %v", slices.Sorted(maps.Keys(files)))
+	}
+
+	w = httptest.NewRecorder()
+	h.DownloadLogs(w, req, uuid.New())
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown task: status %d, want 404", w.Code)
+	}
+}
+
+// TestMetricsExposition verifies that GET /metrics reports tasks by status
+// and the summed usage in the Prometheus text format.
+func TestMetricsExposition(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	done, _ := h.store.CreateTask(ctx, "a", 5, false)
+	h.store.UpdateTaskStatus(ctx, done.ID, "done")
+	h.store.AccumulateTaskUsage(ctx, done.ID, store.TaskUsage{InputTokens: 100, OutputTokens: 20, CostUSD: 0.5})
+	failed, _ := h.store.CreateTask(ctx, "b", 5, false)
+	h.store.UpdateTaskStatus(ctx, failed.ID, "failed")
+	h.store.CreateTask(ctx, "c", 5, false)
+	// Counters keep the usage of deleted tasks.
+	deleted, _ := h.store.CreateTask(ctx, "d", 5, false)
+	h.store.AccumulateTaskUsage(ctx, deleted.ID, store.TaskUsage{InputTokens: 1, CostUSD: 0.25})
+	h.store.DeleteTask(ctx, deleted.ID)
+
+	w := httptest.NewRecorder()
+	h.Metrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("content type = %q", ct)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE wallfacer_tasks gauge",
+		`wallfacer_tasks{status="backlog"} 1`,
+		`wallfacer_tasks{status="done"} 1`,
+		`wallfacer_tasks{status="failed"} 1`,
+		`wallfacer_tasks{status="in_progress"} 0`,
+		`wallfacer_tasks{status="queued"} 0`,
+		`wallfacer_tasks{status="conflict"} 0`,
+		`wallfacer_tokens_total{type="input"} 101`,
+		`wallfacer_tokens_total{type="output"} 20`,
+		"wallfacer_cost_usd_total 0.75",
+		`wallfacer_commit_pipelines_total{result="failure"} 0`,
+		"wallfacer_turn_duration_seconds_avg 0",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+// TestHealthChecks verifies the readiness probe: a placeholder token is a
+// critical failure answered with 503, and a missing workspace only degrades
+// the status.
+func TestHealthChecks(t *testing.T) {
+	s, err := store.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	envFile := filepath.Join(t.TempDir(), ".env")
+	os.WriteFile(envFile, []byte("CLAUDE_CODE_OAUTH_TOKEN=your-oauth-token-here\n"), 0600)
+	r := runner.NewRunner(s, runner.RunnerConfig{Command: "echo", EnvFile: envFile})
+	missing := filepath.Join(t.TempDir(), "gone")
+	h := NewHandler(s, r, t.TempDir(), []string{setupRepo(t), missing})
+
+	call := func() (int, map[string]any) {
+		w := httptest.NewRecorder()
+		h.Health(w, httptest.NewRequest(http.MethodGet, "/api/health", nil))
+		var resp struct {
+			Status string        `json:"status"`
+			Checks []healthCheck `json:"checks"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]any{"status": resp.Status}
+		for _, c := range resp.Checks {
+			got[c.Name] = c.OK
+		}
+		return w.Code, got
+	}
+
+	code, got := call()
+	if code != http.StatusServiceUnavailable || got["status"] != "unavailable" || got["token"] != false {
+		t.Errorf("placeholder token: code %d, %v", code, got)
+	}
+	if got["container_runtime"] != true || got["data_dir"] != true || got["workspace:"+missing] != false {
+		t.Errorf("checks = %v", got)
+	}
+
+	os.WriteFile(envFile, []byte("ANTHROPIC_API_KEY=sk-ant-real\n"), 0600)
+	code, got = call()
+	if code != http.StatusOK || got["status"] != "degraded" {
+		t.Errorf("missing workspace: code %d, %v", code, got)
+	}
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"changkun.de/wallfacer/internal/cron"
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+)
+
+// validTemplateName matches accepted task template names.
+var validTemplateName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// ListTemplates returns the saved task templates sorted by name.
+func (h *Handler) ListTemplates(w http.ResponseWriter, r *http.Request) {
+	templates := h.store.Templates()
+	if templates == nil {
+		templates = []store.Template{}
+	}
+	writeJSON(w, http.StatusOK, templates)
+}
+
+// SaveTemplate stores a task template, replacing any template of the same
+// name. A template with a cron schedule creates a backlog task each time the
+// schedule fires.
+func (h *Handler) SaveTemplate(w http.ResponseWriter, r *http.Request) {
+	var req store.Template
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case !validTemplateName.MatchString(req.Name):
+		http.Error(w, "invalid name", http.StatusBadRequest)
+		return
+	case strings.TrimSpace(req.Prompt) == "":
+		http.Error(w, "prompt is required", http.StatusBadRequest)
+		return
+	case req.Timeout < 0:
+		http.Error(w, "invalid timeout", http.StatusBadRequest)
+		return
+	case !validModelName(req.Model):
+		http.Error(w, "invalid model", http.StatusBadRequest)
+		return
+	case req.Model != "" && !h.runner.ModelAllowed(req.Model):
+		http.Error(w, "model is not allowed", http.StatusBadRequest)
+		return
+	}
+	if req.Schedule != "" {
+		if _, err := cron.Parse(req.Schedule); err != nil {
+			http.Error(w, "invalid schedule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	req.LastRun = nil // kept from the template being replaced
+	if err := h.store.SaveTemplate(r.Context(), req); err != nil {
+		logger.Handler.Error("save template", "name", req.Name, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, req)
+}
+
+// DeleteTemplate removes a saved task template, and with it its schedule.
+func (h *Handler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	found, err := h.store.DeleteTemplate(r.Context(), name)
+	if err != nil {
+		logger.Handler.Error("delete template", "name", name, "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "template not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scheduleInfo describes one scheduled template in the ListSchedules
+// response.
+type scheduleInfo struct {
+	Template string     `json:"template"`
+	Schedule string     `json:"schedule"`
+	LastRun  *time.Time `json:"last_run,omitempty"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+}
+
+// ListSchedules returns the templates that have a schedule, with when each
+// last fired and fires next.
+func (h *Handler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	schedules := []scheduleInfo{}
+	for _, tmpl := range h.store.Templates() {
+		if tmpl.Schedule == "" {
+			continue
+		}
+		info := scheduleInfo{Template: tmpl.Name, Schedule: tmpl.Schedule, LastRun: tmpl.LastRun}
+		if sched, err := cron.Parse(tmpl.Schedule); err == nil {
+			from := now
+			if tmpl.LastRun != nil {
+				from = tmpl.LastRun.Local()
+			}
+			if next := sched.Next(from); !next.IsZero() {
+				info.NextRun = &next
+			}
+		}
+		schedules = append(schedules, info)
+	}
+	writeJSON(w, http.StatusOK, schedules)
+}
+
+// applyTemplate fills the prompt, timeout, and model of req that the
+// request left unset from tmpl.
+func (req *createTaskRequest) applyTemplate(tmpl store.Template) {
+	if strings.TrimSpace(req.Prompt) == "" {
+		req.Prompt = tmpl.Prompt
+	}
+	if req.Timeout == 0 {
+		req.Timeout = tmpl.Timeout
+	}
+	if req.Model == "" {
+		req.Model = tmpl.Model
+	}
+}
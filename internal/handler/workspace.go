@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"path/filepath"
+)
+
+// isConfiguredWorkspace reports whether path names one of the workspaces
+// the server was started with, returning the configured spelling of that
+// workspace. Every endpoint that accepts a workspace argument must go
+// through it. Both sides are canonicalized (made absolute, cleaned, and
+// symlinks resolved) before comparing, so "..", trailing slashes, or a
+// symlink to a workspace still match, while callers always continue with
+// the configured path that task bookkeeping is keyed by.
+func (h *Handler) isConfiguredWorkspace(path string) (string, bool) {
+	if path == "" {
+		return "", false
+	}
+	want := canonicalPath(path)
+	for _, configured := range h.runner.Workspaces() {
+		if canonicalPath(configured) == want {
+			return configured, true
+		}
+	}
+	return "", false
+}
+
+// canonicalPath returns the absolute, cleaned, symlink-resolved form of
+// path. Paths that cannot be resolved (e.g. they do not exist) fall back to
+// their cleaned absolute form.
+func canonicalPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved
+	}
+	return abs
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"changkun.de/wallfacer/internal/websocket"
+	"github.com/google/uuid"
+)
+
+// wsPingInterval is how often StreamWS pings an otherwise quiet client, so
+// proxies do not close the connection as idle.
+const wsPingInterval = 30 * time.Second
+
+// wsCommand is a message from a WebSocket client. "subscribe" follows the
+// events of TaskID after SinceID, replacing any earlier subscription;
+// "unsubscribe" stops following them.
+type wsCommand struct {
+	Type    string    `json:"type"`
+	TaskID  uuid.UUID `json:"task_id"`
+	SinceID int64     `json:"since_id"`
+}
+
+// wsMessage is a message to a WebSocket client: "tasks" carries the task
+// list, "event" one event of the subscribed task, and "error" a refused
+// command.
+type wsMessage struct {
+	Type   string           `json:"type"`
+	Tasks  []store.Task     `json:"tasks,omitzero"`
+	TaskID *uuid.UUID       `json:"task_id,omitempty"`
+	Event  *store.TaskEvent `json:"event,omitempty"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// StreamWS serves the task list over a WebSocket, for clients behind proxies
+// that break SSE. Like StreamTasks it sends the list on connect and on every
+// change (?include_archived=true includes archived tasks). A client may also
+// send {"type":"subscribe","task_id":...,"since_id":...} to receive that
+// task's events, as StreamEvents would, until it unsubscribes, subscribes to
+// another task, or the task is deleted. Connections count towards the SSE
+// connection limit.
+func (h *Handler) StreamWS(w http.ResponseWriter, r *http.Request) {
+	if !acquireSSESlot(w) {
+		return
+	}
+	defer releaseSSESlot()
+
+	includeArchived := r.URL.Query().Get("include_archived") == "true"
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.ReadLimit = 64 << 10
+
+	// The request context is not cancelled when a hijacked connection
+	// closes, so the reader cancels ctx instead.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	boardSub, boardCh := h.store.Subscribe()
+	defer h.store.Unsubscribe(boardSub)
+
+	cmds := make(chan wsCommand)
+	go func() {
+		defer cancel()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var cmd wsCommand
+			if err := json.Unmarshal(data, &cmd); err != nil {
+				cmd = wsCommand{Type: "invalid"}
+			}
+			select {
+			case cmds <- cmd:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	write := func(msg wsMessage) bool {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			logger.Handler.Error("marshal websocket message", "error", err)
+			return false
+		}
+		return conn.WriteText(data) == nil
+	}
+	sendTasks := func() bool {
+		tasks, err := h.store.ListTasks(ctx, includeArchived)
+		if err != nil {
+			return false
+		}
+		if tasks == nil {
+			tasks = []store.Task{}
+		}
+		redactTasks(tasks)
+		return write(wsMessage{Type: "tasks", Tasks: tasks})
+	}
+
+	// The current event subscription, if any; a nil eventCh blocks forever.
+	var (
+		eventTask uuid.UUID
+		eventSub  int
+		eventCh   <-chan struct{}
+		sinceID   int64
+	)
+	unsubscribe := func() {
+		if eventCh != nil {
+			h.store.Unsubscribe(eventSub)
+			eventCh = nil
+		}
+	}
+	defer unsubscribe()
+	sendEvents := func() bool {
+		if _, err := h.store.GetTask(ctx, eventTask); err != nil {
+			unsubscribe() // deleted
+			return true
+		}
+		events, err := h.store.GetEventsFiltered(ctx, eventTask, store.EventFilter{SinceID: sinceID})
+		if err != nil {
+			return false
+		}
+		for i := range events {
+			if !write(wsMessage{Type: "event", TaskID: &eventTask, Event: &events[i]}) {
+				return false
+			}
+			sinceID = events[i].ID
+		}
+		return true
+	}
+	handle := func(cmd wsCommand) bool {
+		switch cmd.Type {
+		case "subscribe":
+			if _, err := h.store.GetTask(ctx, cmd.TaskID); err != nil {
+				return write(wsMessage{Type: "error", Error: "task not found"})
+			}
+			unsubscribe()
+			// Subscribe before the first read so no event slips in between.
+			eventTask, sinceID = cmd.TaskID, cmd.SinceID
+			eventSub, eventCh = h.store.SubscribeEvents(eventTask)
+			return sendEvents()
+		case "unsubscribe":
+			unsubscribe()
+			return true
+		default:
+			return write(wsMessage{Type: "error", Error: "unknown command " + cmd.Type})
+		}
+	}
+
+	if !sendTasks() {
+		return
+	}
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case <-boardCh:
+			ok = sendTasks()
+		case <-eventCh:
+			ok = sendEvents()
+		case cmd := <-cmds:
+			ok = handle(cmd)
+		case <-ping.C:
+			ok = conn.Ping() == nil
+		}
+		if !ok {
+			conn.CloseWithCode(websocket.CloseNormal, "")
+			return
+		}
+	}
+}
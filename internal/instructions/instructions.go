@@ -1,6 +1,7 @@
 package instructions
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"fmt"
 	"os"
@@ -49,13 +50,39 @@ create files or directories directly under ` + "`/workspace/`" + `.
 `
 
 // Key returns a stable 16-char hex key for a given set of workspace paths.
-// The key is derived from the SHA-256 of the sorted, colon-joined absolute paths,
-// so the same set of workspaces always maps to the same file regardless of order.
+// The key is derived from the SHA-256 of the sorted, de-duplicated,
+// colon-joined absolute paths, so the same set of workspaces always maps to
+// the same file regardless of order or repetition.
 func Key(workspaces []string) string {
 	sorted := make([]string, len(workspaces))
 	copy(sorted, workspaces)
 	sort.Strings(sorted)
-	h := sha256.Sum256([]byte(strings.Join(sorted, ":")))
+	uniq := sorted[:0]
+	for i, ws := range sorted {
+		if i == 0 || ws != sorted[i-1] {
+			uniq = append(uniq, ws)
+		}
+	}
+	return hashKey(uniq)
+}
+
+// LegacyKey returns the key the previous scheme produced for workspaces,
+// which hashed the sorted list without removing duplicates, or "" when it
+// equals Key(workspaces). Callers use it to find and migrate data stored
+// under the old key.
+func LegacyKey(workspaces []string) string {
+	sorted := make([]string, len(workspaces))
+	copy(sorted, workspaces)
+	sort.Strings(sorted)
+	if k := hashKey(sorted); k != Key(workspaces) {
+		return k
+	}
+	return ""
+}
+
+// hashKey hashes the colon-joined paths in the order given.
+func hashKey(paths []string) string {
+	h := sha256.Sum256([]byte(strings.Join(paths, ":")))
 	return fmt.Sprintf("%x", h[:8]) // 16 hex chars
 }
 
@@ -66,6 +93,33 @@ func FilePath(configDir string, workspaces []string) string {
 	return filepath.Join(dir, Key(workspaces)+".md")
 }
 
+// GlobalFilePath returns the path to the global instructions file, which is
+// injected for every workspace combination ahead of the scoped CLAUDE.md.
+func GlobalFilePath(configDir string) string {
+	return filepath.Join(configDir, "instructions.md")
+}
+
+// Combine returns the global instructions followed by the workspace-scoped
+// instructions, separated by a blank line. Missing or empty files are
+// skipped; nil is returned when neither file has content.
+func Combine(globalPath, scopedPath string) []byte {
+	var parts [][]byte
+	for _, p := range []string{globalPath, scopedPath} {
+		if p == "" {
+			continue
+		}
+		raw, err := os.ReadFile(p)
+		if err != nil || len(bytes.TrimSpace(raw)) == 0 {
+			continue
+		}
+		parts = append(parts, bytes.TrimRight(raw, "\n"))
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return append(bytes.Join(parts, []byte("\n\n")), '\n')
+}
+
 // Ensure ensures the CLAUDE.md for the given workspace set exists.
 // If it does not exist yet it is created from the default template plus any CLAUDE.md
 // files found in the workspace directories. Returns the path to the file.
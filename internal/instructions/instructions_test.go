@@ -43,6 +43,33 @@ func TestInstructionsKeyDifferentWorkspaces(t *testing.T) {
 	}
 }
 
+// TestInstructionsKeyIgnoresDuplicates verifies that repeating a workspace
+// argument does not change the key.
+func TestInstructionsKeyIgnoresDuplicates(t *testing.T) {
+	ws1 := []string{"/home/user/alpha", "/home/user/beta"}
+	ws2 := []string{"/home/user/beta", "/home/user/alpha", "/home/user/beta"}
+	if Key(ws1) != Key(ws2) {
+		t.Fatalf("duplicates must not affect the key: %q != %q", Key(ws1), Key(ws2))
+	}
+}
+
+// TestLegacyKey verifies that the previous key, which kept duplicates, is
+// reported only when it differs from Key.
+func TestLegacyKey(t *testing.T) {
+	if got := LegacyKey([]string{"/home/user/beta", "/home/user/alpha"}); got != "" {
+		t.Fatalf("expected no legacy key for unique input, got %q", got)
+	}
+
+	dup := []string{"/home/user/beta", "/home/user/alpha", "/home/user/beta"}
+	want := hashKey([]string{"/home/user/alpha", "/home/user/beta", "/home/user/beta"})
+	if got := LegacyKey(dup); got != want {
+		t.Fatalf("LegacyKey(%v) = %q, want %q", dup, got, want)
+	}
+	if want == Key(dup) {
+		t.Fatal("legacy key must differ from the current key")
+	}
+}
+
 // TestInstructionsKeyLength verifies the key is exactly 16 hex characters.
 func TestInstructionsKeyLength(t *testing.T) {
 	k := Key([]string{"/some/path"})
@@ -290,3 +317,43 @@ func TestReinitWorkspaceInstructionsOverwrites(t *testing.T) {
 		t.Fatalf("Reinit should include fresh workspace CLAUDE.md; got:\n%s", data)
 	}
 }
+
+// ---------------------------------------------------------------------------
+// Global instructions
+// ---------------------------------------------------------------------------
+
+// TestCombineGlobalFirst verifies that global instructions precede the
+// workspace-scoped instructions, separated by a blank line.
+func TestCombineGlobalFirst(t *testing.T) {
+	configDir := t.TempDir()
+	global := GlobalFilePath(configDir)
+	scoped := filepath.Join(configDir, "scoped.md")
+	os.WriteFile(global, []byte("global rules\n"), 0644)
+	os.WriteFile(scoped, []byte("scoped rules"), 0644)
+
+	got := string(Combine(global, scoped))
+	if want := "global rules\n\nscoped rules\n"; got != want {
+		t.Fatalf("Combine = %q, want %q", got, want)
+	}
+}
+
+// TestCombineSkipsMissingAndEmpty verifies that a missing or blank file is
+// left out instead of producing stray separators.
+func TestCombineSkipsMissingAndEmpty(t *testing.T) {
+	configDir := t.TempDir()
+	scoped := filepath.Join(configDir, "scoped.md")
+	os.WriteFile(scoped, []byte("scoped rules\n"), 0644)
+
+	if got := string(Combine(GlobalFilePath(configDir), scoped)); got != "scoped rules\n" {
+		t.Fatalf("missing global: got %q", got)
+	}
+
+	os.WriteFile(GlobalFilePath(configDir), []byte("\n  \n"), 0644)
+	if got := string(Combine(GlobalFilePath(configDir), scoped)); got != "scoped rules\n" {
+		t.Fatalf("blank global: got %q", got)
+	}
+
+	if got := Combine("", filepath.Join(configDir, "missing.md")); got != nil {
+		t.Fatalf("expected nil when nothing to combine, got %q", got)
+	}
+}
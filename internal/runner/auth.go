@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"changkun.de/wallfacer/internal/envconfig"
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// StopReasonAuthFailed is recorded as the stop_reason of a task that failed
+// because the API rejected the credentials in the env file.
+const StopReasonAuthFailed = "auth_failed"
+
+// authFailureSignatures are lowercase fragments of the messages Claude Code
+// and the API give for a missing, invalid, expired, or revoked token.
+var authFailureSignatures = []string{
+	"invalid api key",
+	"invalid bearer token",
+	"oauth token has expired",
+	"oauth token has been revoked",
+	"authentication_error",
+	"please run /login",
+	"api error: 401",
+}
+
+// isAuthFailure reports whether any of texts carries an authentication
+// failure signature.
+func isAuthFailure(texts ...string) bool {
+	for _, text := range texts {
+		text = strings.ToLower(text)
+		for _, sig := range authFailureSignatures {
+			if strings.Contains(text, sig) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authPollInterval is how often a task held by a rejected token re-checks
+// the env file and its own status. A variable so tests can shorten it.
+var authPollInterval = 10 * time.Second
+
+// credentials returns the token and API key currently in the env file, for
+// telling whether they changed since the API rejected them.
+func (r *Runner) credentials() string {
+	if r.envFile == "" {
+		return ""
+	}
+	cfg, _ := envconfig.Parse(r.envFile)
+	return cfg.OAuthToken + "\x00" + cfg.APIKey
+}
+
+// markAuthFailed records that the API rejected the credentials now in the
+// env file, which holds new turns until they change.
+func (r *Runner) markAuthFailed() {
+	creds := r.credentials()
+	r.authMu.Lock()
+	defer r.authMu.Unlock()
+	r.authFailed = true
+	r.rejectedCreds = creds
+}
+
+// AuthFailed reports whether the env file still holds credentials the API
+// rejected. Changing the token or API key, in the env file or through the
+// settings, clears it. The env file is only read while the flag is set, so
+// the common case costs no I/O.
+func (r *Runner) AuthFailed() bool {
+	r.authMu.Lock()
+	failed := r.authFailed
+	r.authMu.Unlock()
+	if !failed {
+		return false
+	}
+	creds := r.credentials()
+	r.authMu.Lock()
+	defer r.authMu.Unlock()
+	if r.authFailed && creds != r.rejectedCreds {
+		logger.Runner.Info("credentials changed, resuming tasks held by the rejected token")
+		r.authFailed = false
+	}
+	return r.authFailed
+}
+
+// failAuth moves a task whose turn was refused for authentication to
+// failed with StopReasonAuthFailed, explains how to fix it, and marks the
+// credentials rejected so other tasks wait instead of failing one by one.
+func (r *Runner) failAuth(taskID uuid.UUID, sessionID string, turns int, detail string) {
+	bgCtx := context.Background()
+	r.markAuthFailed()
+	logger.Runner.Error("claude authentication failed", "task", taskID, "detail", truncate(detail, 200))
+	result := ""
+	if task, err := r.store.GetTask(bgCtx, taskID); err == nil && task.Result != nil {
+		result = *task.Result
+	}
+	r.store.UpdateTaskStatus(bgCtx, taskID, "failed")
+	r.store.UpdateTaskResult(bgCtx, taskID, result, sessionID, StopReasonAuthFailed, turns)
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeError, map[string]string{
+		"error": "Claude rejected the credentials: the token in the env file is invalid or has expired. " +
+			"Refresh CLAUDE_CODE_OAUTH_TOKEN (or ANTHROPIC_API_KEY) in Settings or the env file, then retry the task. " +
+			"Other tasks are held until the credentials change. Detail: " + truncate(detail, 500),
+	})
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
+		"from": "in_progress", "to": "failed",
+	})
+}
+
+// waitForAuth holds a task in the "queued" status while the credentials in
+// the env file are ones the API rejected, moving it back to in_progress
+// once they change. Time spent queued is added back to deadline. Returns
+// false if the task left the queue while waiting (cancelled or deleted), in
+// which case the caller must stop without touching its status.
+func (r *Runner) waitForAuth(taskID uuid.UUID, deadline *taskDeadline) bool {
+	if !r.AuthFailed() {
+		return true
+	}
+	logger.Runner.Warn("credentials rejected, queueing task", "task", taskID)
+	reason := "Claude rejected the credentials in the env file. " +
+		"The task is queued until the token is refreshed."
+	return r.holdQueued(taskID, deadline, authPollInterval, reason, r.AuthFailed)
+}
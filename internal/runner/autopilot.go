@@ -0,0 +1,189 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// autopilotInterval is how often autopilot re-checks the backlog when no
+// task change wakes it. A variable so tests can shorten it.
+var autopilotInterval = 10 * time.Second
+
+// Autopilot reports whether autopilot is enabled.
+func (r *Runner) Autopilot() bool {
+	return r.store.Settings().Autopilot
+}
+
+// SetAutopilot enables or disables autopilot. The state is persisted so it
+// survives restarts.
+func (r *Runner) SetAutopilot(enabled bool) error {
+	if err := r.store.SetAutopilot(context.Background(), enabled); err != nil {
+		return err
+	}
+	logger.Runner.Info("autopilot", "enabled", enabled)
+	return nil
+}
+
+// RunAutopilot starts backlog tasks on its own while autopilot is enabled,
+// until ctx is done. It wakes on every task change and on a timer.
+func (r *Runner) RunAutopilot(ctx context.Context) {
+	subID, changed := r.store.Subscribe()
+	defer r.store.Unsubscribe(subID)
+	ticker := time.NewTicker(autopilotInterval)
+	defer ticker.Stop()
+	for {
+		if r.Autopilot() {
+			r.autopilotStep()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// autopilotStep starts the top backlog task whose dependencies are done, if
+// there is room for it. Room means fewer active tasks than MaxConcurrent, or
+// none at all when there is no limit, so autopilot works through the
+// backlog one task at a time by default. In no-worktree mode any task holding
+// the workspaces also blocks it, and nothing starts while worktree disk usage
+// is at its cap or the API rejects the credentials.
+func (r *Runner) autopilotStep() {
+	if full, _ := r.worktreeDiskFull(); full {
+		return
+	}
+	if r.AuthFailed() {
+		return
+	}
+	if r.noWorktree && r.workspaceHolder(uuid.Nil) != nil {
+		return
+	}
+	ctx := context.Background()
+	tasks, err := r.store.ListTasks(ctx, false)
+	if err != nil {
+		logger.Runner.Warn("autopilot: list tasks", "error", err)
+		return
+	}
+	limit := max(cap(r.slots), 1)
+	active := 0
+	// ListTasks orders the backlog by priority, then position.
+	var next *store.Task
+	for i := range tasks {
+		t := &tasks[i]
+		switch t.Status {
+		case "in_progress", "queued", "committing":
+			active++
+		case "backlog":
+			if next != nil {
+				continue
+			}
+			if ok, _, err := r.store.CanStart(ctx, t.ID); err == nil && ok {
+				next = t
+			}
+		}
+	}
+	if next != nil && active < limit {
+		r.startBacklogTask(next, "Started by autopilot.")
+	}
+}
+
+// startBacklogTask starts a backlog task for the given reason, logging
+// rather than returning failures.
+func (r *Runner) startBacklogTask(t *store.Task, reason string) {
+	logger.Runner.Info("starting task", "task", t.ID, "reason", reason)
+	if _, err := r.StartTask(t.ID, reason); err != nil {
+		logger.Runner.Warn("start task", "task", t.ID, "error", err)
+	}
+}
+
+// StartTask moves a backlog task to in_progress, records why when reason is
+// set, and runs it in the background. The move is a compare-and-set, so
+// when the autopilot, a finished dependency, and the user try to start the
+// same task at once, only one Run is launched; the others get false.
+func (r *Runner) StartTask(id uuid.UUID, reason string) (bool, error) {
+	ctx := context.Background()
+	ok, err := r.ClaimTask(id, "backlog")
+	if err != nil || !ok {
+		return false, err
+	}
+	t, err := r.store.GetTask(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if reason != "" {
+		r.store.InsertEvent(ctx, id, store.EventTypeSystem, map[string]string{
+			"result": reason,
+		})
+	}
+	r.store.InsertEvent(ctx, id, store.EventTypeStateChange, map[string]string{
+		"from": "backlog", "to": "in_progress",
+	})
+	go r.Run(id, t.Prompt, runSessionID(t), false)
+	return true, nil
+}
+
+// ErrWorkspaceBusy is returned by ClaimTask in no-worktree mode when another
+// task holds the workspaces.
+var ErrWorkspaceBusy = errors.New("only one task can be active when running without worktrees")
+
+// ClaimTask moves a task from status from to in_progress through a
+// compare-and-set and reports whether it did. In no-worktree mode it first
+// checks that no other task holds the workspaces, under a lock so that two
+// claims cannot both pass the check, and returns an error wrapping
+// ErrWorkspaceBusy if one does. Every path that puts a task to work in the
+// workspaces goes through here.
+func (r *Runner) ClaimTask(id uuid.UUID, from string) (bool, error) {
+	if r.noWorktree {
+		r.startMu.Lock()
+		defer r.startMu.Unlock()
+		if holder := r.workspaceHolder(id); holder != nil {
+			return false, fmt.Errorf("workspace is in use by task %s (%s): %w", holder.ID, holder.Status, ErrWorkspaceBusy)
+		}
+	}
+	return r.store.CompareAndSetStatus(context.Background(), id, from, "in_progress")
+}
+
+// workspaceHolder returns a task other than exceptID that holds the
+// workspaces in no-worktree mode, or nil. A task holds them while it runs,
+// is queued, waits for feedback, commits, or waits on a rebase conflict,
+// and after failing until it is retried, since its changes are still in
+// the workspaces.
+func (r *Runner) workspaceHolder(exceptID uuid.UUID) *store.Task {
+	tasks, err := r.store.ListTasks(context.Background(), false)
+	if err != nil {
+		logger.Runner.Warn("list tasks for workspace holder", "error", err)
+		return nil
+	}
+	for i := range tasks {
+		t := &tasks[i]
+		if t.ID == exceptID {
+			continue
+		}
+		switch t.Status {
+		case "in_progress", "queued", "waiting", "committing", "conflict":
+			return t
+		case "failed":
+			if len(t.WorktreePaths) > 0 {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// runSessionID is the session a task resumes when it starts from the top:
+// its previous session unless it asked for a fresh start.
+func runSessionID(t *store.Task) string {
+	if !t.FreshStart && t.SessionID != nil {
+		return *t.SessionID
+	}
+	return ""
+}
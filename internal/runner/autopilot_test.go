@@ -0,0 +1,268 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// TestAutopilotStepStartsTopReadyTask verifies that autopilot starts one task
+// at a time, highest priority first, and skips tasks whose dependencies are
+// not done yet.
+func TestAutopilotStepStartsTopReadyTask(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupRunnerWithCmd(t, []string{repo}, fakeCmdScript(t, endTurnOutput, 0))
+
+	low, _ := s.CreateTask(bg(), "low", 5, false)
+	s.SetTaskPriority(bg(), low.ID, store.PriorityLow)
+	dep, _ := s.CreateTask(bg(), "dep", 5, false)
+	top, _ := s.CreateTask(bg(), "top", 5, false)
+	s.SetTaskPriority(bg(), top.ID, store.PriorityUrgent)
+	if err := s.SetTaskDependsOn(bg(), top.ID, []uuid.UUID{dep.ID}); err != nil {
+		t.Fatal(err)
+	}
+	busy, _ := s.CreateTask(bg(), "busy", 5, false)
+	s.UpdateTaskStatus(bg(), busy.ID, "in_progress")
+
+	r.autopilotStep()
+	for _, id := range []uuid.UUID{low.ID, dep.ID, top.ID} {
+		if got, _ := s.GetTask(bg(), id); got.Status != "backlog" {
+			t.Fatalf("started %q while another task was active", got.Prompt)
+		}
+	}
+
+	s.UpdateTaskStatus(bg(), busy.ID, "done")
+	r.autopilotStep()
+	if got, _ := s.GetTask(bg(), dep.ID); got.Status == "backlog" {
+		t.Fatal("expected the blocked task's dependency to start first")
+	}
+	waitStatus(t, s, dep.ID, "done")
+	waitIdle(t, r)
+	if got, _ := s.GetTask(bg(), top.ID); got.Status != "backlog" {
+		t.Fatalf("dependent status = %q, want backlog before the next step", got.Status)
+	}
+
+	r.autopilotStep()
+	waitStatus(t, s, top.ID, "done")
+	waitIdle(t, r)
+	if got, _ := s.GetTask(bg(), low.ID); got.Status != "backlog" {
+		t.Errorf("low priority task status = %q, want backlog", got.Status)
+	}
+}
+
+// TestRunAutopilotFollowsToggle verifies that the autopilot loop only pulls
+// tasks while autopilot is enabled.
+func TestRunAutopilotFollowsToggle(t *testing.T) {
+	old := autopilotInterval
+	autopilotInterval = 10 * time.Millisecond
+	t.Cleanup(func() { autopilotInterval = old })
+
+	repo := setupTestRepo(t)
+	s, r := setupRunnerWithCmd(t, []string{repo}, fakeCmdScript(t, endTurnOutput, 0))
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.RunAutopilot(ctx)
+
+	time.Sleep(50 * time.Millisecond)
+	if got, _ := s.GetTask(bg(), task.ID); got.Status != "backlog" {
+		t.Fatalf("status = %q with autopilot off, want backlog", got.Status)
+	}
+	if err := r.SetAutopilot(true); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, s, task.ID, "done")
+	waitIdle(t, r)
+	if !r.Status().Autopilot {
+		t.Error("status should report autopilot enabled")
+	}
+}
+
+// TestRunAutopilotHonorsDependsOnAtCreation verifies that a task created
+// with an unmet dependency is never started by a running autopilot, which
+// wakes on the notification the creation sends.
+func TestRunAutopilotHonorsDependsOnAtCreation(t *testing.T) {
+	old := autopilotInterval
+	autopilotInterval = 10 * time.Millisecond
+	t.Cleanup(func() { autopilotInterval = old })
+
+	repo := setupTestRepo(t)
+	s, r := setupRunnerWithCmd(t, []string{repo}, fakeCmdScript(t, endTurnOutput, 0))
+	dep, _ := s.CreateTask(bg(), "dep", 5, false)
+	s.UpdateTaskStatus(bg(), dep.ID, "failed")
+	if err := r.SetAutopilot(true); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.RunAutopilot(ctx)
+
+	for range 20 {
+		task, err := s.CreateTaskWith(bg(), "p", 5, false, func(t *store.Task) {
+			t.DependsOn = []uuid.UUID{dep.ID}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if got, _ := s.GetTask(bg(), task.ID); got.Status != "backlog" {
+			t.Fatalf("status = %q with an unmet dependency, want backlog", got.Status)
+		}
+	}
+	time.Sleep(5 * autopilotInterval)
+	tasks, _ := s.ListTasks(bg(), false)
+	for _, task := range tasks {
+		if task.ID != dep.ID && task.Status != "backlog" {
+			t.Fatalf("status = %q with an unmet dependency, want backlog", task.Status)
+		}
+	}
+}
+
+// TestScheduleStepCreatesTaskWhenDue verifies that a template schedule first
+// only records its last run, then creates one task per due fire, including
+// once for runs missed while the server was down.
+func TestScheduleStepCreatesTaskWhenDue(t *testing.T) {
+	// The dummy command makes title generation fail fast instead of
+	// writing titles into the store while the test cleans it up.
+	s, r := setupTestRunner(t, nil)
+	s.SaveTemplate(bg(), store.Template{Name: "nightly", Prompt: "fix flaky tests", Timeout: 30, Model: "opus", Schedule: "0 2 * * *"})
+	s.SaveTemplate(bg(), store.Template{Name: "manual", Prompt: "never scheduled"})
+
+	countTasks := func() int {
+		tasks, _ := s.ListTasks(bg(), false)
+		return len(tasks)
+	}
+	day := time.Date(2026, 3, 10, 1, 0, 0, 0, time.Local)
+
+	r.scheduleStep(day)
+	if n := countTasks(); n != 0 {
+		t.Fatalf("first sight of a schedule created %d tasks", n)
+	}
+	r.scheduleStep(day.Add(59 * time.Minute))
+	if n := countTasks(); n != 0 {
+		t.Fatalf("schedule fired early: %d tasks", n)
+	}
+	r.scheduleStep(day.Add(61 * time.Minute))
+	tasks, _ := s.ListTasks(bg(), false)
+	if len(tasks) != 1 || tasks[0].Prompt != "fix flaky tests" || tasks[0].Timeout != 30 || tasks[0].Model != "opus" {
+		t.Fatalf("after the fire time, tasks = %+v", tasks)
+	}
+	r.scheduleStep(day.Add(62 * time.Minute))
+	if n := countTasks(); n != 1 {
+		t.Fatalf("schedule fired twice for one fire time: %d tasks", n)
+	}
+
+	// Three nights missed while down: one catch-up task.
+	r.scheduleStep(day.Add(4 * 24 * time.Hour))
+	if n := countTasks(); n != 2 {
+		t.Fatalf("after missed runs, %d tasks, want 2", n)
+	}
+	if tmpl, _ := s.GetTemplate("manual"); tmpl.LastRun != nil {
+		t.Error("a template without a schedule got a last run")
+	}
+}
+
+// TestStartTaskRunsOnce verifies that concurrent starts of one backlog task
+// launch a single Run.
+func TestStartTaskRunsOnce(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupRunnerWithCmd(t, []string{repo}, fakeCmdScript(t, endTurnOutput, 0))
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+
+	results := make(chan bool, 8)
+	for range cap(results) {
+		go func() {
+			ok, err := r.StartTask(task.ID, "")
+			if err != nil {
+				t.Error(err)
+			}
+			results <- ok
+		}()
+	}
+	started := 0
+	for range cap(results) {
+		if <-results {
+			started++
+		}
+	}
+	if started != 1 {
+		t.Fatalf("%d starts succeeded, want 1", started)
+	}
+	waitStatus(t, s, task.ID, "done")
+	waitIdle(t, r)
+
+	events, _ := s.GetEvents(bg(), task.ID)
+	transitions := 0
+	for _, e := range events {
+		if e.EventType == store.EventTypeStateChange && strings.Contains(string(e.Data), `"from":"backlog"`) {
+			transitions++
+		}
+	}
+	if transitions != 1 {
+		t.Errorf("%d backlog transitions recorded, want 1", transitions)
+	}
+}
+
+// TestClaimTaskOneActiveWithoutWorktrees verifies that in no-worktree mode
+// concurrent claims of different tasks let exactly one through, and that a
+// failed task still holding the workspaces keeps blocking others.
+func TestClaimTaskOneActiveWithoutWorktrees(t *testing.T) {
+	s, r := setupTestRunner(t, nil)
+	r.noWorktree = true
+
+	ids := make([]uuid.UUID, 8)
+	for i := range ids {
+		task, _ := s.CreateTask(bg(), "p", 5, false)
+		ids[i] = task.ID
+	}
+	type result struct {
+		id  uuid.UUID
+		ok  bool
+		err error
+	}
+	results := make(chan result, len(ids))
+	for _, id := range ids {
+		go func() {
+			ok, err := r.ClaimTask(id, "backlog")
+			results <- result{id, ok, err}
+		}()
+	}
+	var winner uuid.UUID
+	for range ids {
+		res := <-results
+		switch {
+		case res.ok:
+			if winner != uuid.Nil {
+				t.Fatal("two tasks claimed the workspaces")
+			}
+			winner = res.id
+		case !errors.Is(res.err, ErrWorkspaceBusy):
+			t.Errorf("claim %s: ok=false, err=%v; want ErrWorkspaceBusy", res.id, res.err)
+		}
+	}
+	if winner == uuid.Nil {
+		t.Fatal("no claim succeeded")
+	}
+
+	s.UpdateTaskWorktrees(bg(), winner, map[string]string{"/ws": "/ws"}, "")
+	s.UpdateTaskStatus(bg(), winner, "failed")
+	other := ids[0]
+	if other == winner {
+		other = ids[1]
+	}
+	if _, err := r.ClaimTask(other, "backlog"); !errors.Is(err, ErrWorkspaceBusy) {
+		t.Fatalf("claim next to a failed task holding the workspaces: err=%v, want ErrWorkspaceBusy", err)
+	}
+
+	s.ResetTaskForRetry(bg(), winner, "p", false)
+	if ok, err := r.ClaimTask(other, "backlog"); !ok || err != nil {
+		t.Fatalf("claim after the failed task was reset: ok=%v err=%v", ok, err)
+	}
+}
@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"changkun.de/wallfacer/internal/gitutil"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// DefaultBranchTemplate is the task branch naming scheme used when no
+// BranchTemplate is configured.
+const DefaultBranchTemplate = "task/{short_id}"
+
+// maxSlugLen caps the {title-slug} placeholder so branch names stay readable.
+const maxSlugLen = 40
+
+// renderBranchName expands a branch template for a task. Supported
+// placeholders are {short_id} (first 8 characters of the task ID), {id} (the
+// full ID), and {title-slug} (the task title, or the first line of its prompt
+// while it has none, reduced to lowercase letters, digits and dashes).
+// Templates that name neither ID placeholder get "-{short_id}" appended so
+// two tasks with the same title never share a branch.
+func renderBranchName(tmpl string, id uuid.UUID, title string) string {
+	if tmpl == "" {
+		tmpl = DefaultBranchTemplate
+	}
+	if !strings.Contains(tmpl, "{short_id}") && !strings.Contains(tmpl, "{id}") {
+		tmpl += "-{short_id}"
+	}
+	return strings.NewReplacer(
+		"{short_id}", id.String()[:8],
+		"{id}", id.String(),
+		"{title-slug}", slugify(title),
+	).Replace(tmpl)
+}
+
+// slugify reduces s to a git-safe slug: lowercase ASCII letters and digits,
+// with every other run of characters collapsed into a single dash. An empty
+// result falls back to "task".
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(s) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(c)
+			if b.Len() >= maxSlugLen {
+				break
+			}
+			continue
+		}
+		dash = true
+	}
+	if b.Len() == 0 {
+		return "task"
+	}
+	return b.String()
+}
+
+// ValidateBranchTemplate reports whether tmpl expands to a valid git branch
+// name. Slugs only ever contain letters, digits and dashes, so checking one
+// sample expansion covers every task.
+func ValidateBranchTemplate(tmpl string) error {
+	name := renderBranchName(tmpl, uuid.New(), "sample title")
+	if !gitutil.IsValidBranchName(name) {
+		return fmt.Errorf("branch template %q expands to invalid branch name %q", tmpl, name)
+	}
+	return nil
+}
+
+// branchNameFor returns the branch a task's worktrees are created on. A name
+// already recorded on the task is kept, so a title generated after the first
+// run does not rename the branch of a resumed task.
+func (r *Runner) branchNameFor(task *store.Task) string {
+	if task.BranchName != "" {
+		return task.BranchName
+	}
+	title := task.Title
+	if title == "" {
+		title, _, _ = strings.Cut(strings.TrimSpace(task.Prompt), "\n")
+	}
+	return renderBranchName(r.branchTemplate, task.ID, title)
+}
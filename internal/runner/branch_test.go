@@ -0,0 +1,79 @@
+// Tests for branch.go: branch template expansion and its use when creating
+// task worktrees.
+package runner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRenderBranchName(t *testing.T) {
+	id := uuid.MustParse("0123abcd-0000-0000-0000-000000000000")
+	tests := []struct {
+		tmpl, title, want string
+	}{
+		{"", "", "task/0123abcd"},
+		{"wallfacer/{short_id}", "", "wallfacer/0123abcd"},
+		{"{title-slug}/{short_id}", "Fix the *Login* page!", "fix-the-login-page/0123abcd"},
+		{"feat/{title-slug}", "Add  search", "feat/add-search-0123abcd"},
+		{"{title-slug}/{id}", "", "task/" + id.String()},
+		{"{title-slug}/{short_id}", strings.Repeat("ab ", 30), "ab-ab-ab-ab-ab-ab-ab-ab-ab-ab-ab-ab-ab-a/0123abcd"},
+	}
+	for _, tt := range tests {
+		if got := renderBranchName(tt.tmpl, id, tt.title); got != tt.want {
+			t.Errorf("renderBranchName(%q, %q) = %q, want %q", tt.tmpl, tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestValidateBranchTemplate(t *testing.T) {
+	for _, tmpl := range []string{"", "wallfacer/{short_id}", "{title-slug}/{short_id}"} {
+		if err := ValidateBranchTemplate(tmpl); err != nil {
+			t.Errorf("ValidateBranchTemplate(%q) = %v", tmpl, err)
+		}
+	}
+	for _, tmpl := range []string{"bad..name/{short_id}", "{short_id}.lock", "with space/{id}"} {
+		if err := ValidateBranchTemplate(tmpl); err == nil {
+			t.Errorf("ValidateBranchTemplate(%q) accepted an invalid branch name", tmpl)
+		}
+	}
+}
+
+// TestSetupWorktreesBranchTemplate verifies that worktrees are created on
+// the templated branch and that a name recorded on the task survives a later
+// title change.
+func TestSetupWorktreesBranchTemplate(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupTestRunner(t, []string{repo})
+	r.branchTemplate = "wallfacer/{title-slug}/{short_id}"
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "Fix flaky login test\nmore details", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, branch, err := r.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal("setupWorktrees:", err)
+	}
+	want := "wallfacer/fix-flaky-login-test/" + task.ID.String()[:8]
+	if branch != want {
+		t.Fatalf("branch = %q, want %q", branch, want)
+	}
+	gitRun(t, repo, "rev-parse", "--verify", "refs/heads/"+want)
+
+	if err := s.UpdateTaskWorktrees(ctx, task.ID, nil, branch); err != nil {
+		t.Fatal(err)
+	}
+	s.UpdateTaskTitle(ctx, task.ID, "Something else")
+	_, again, err := r.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal("second setupWorktrees:", err)
+	}
+	if again != want {
+		t.Errorf("branch after title change = %q, want %q", again, want)
+	}
+}
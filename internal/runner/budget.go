@@ -0,0 +1,43 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// StopReasonBudgetExceeded is recorded as the stop_reason of a task that
+// was failed because its accumulated cost reached its MaxCostUSD.
+const StopReasonBudgetExceeded = "budget_exceeded"
+
+// failIfOverBudget moves the task from in_progress to failed when its
+// accumulated cost has reached its MaxCostUSD, keeping the last result so
+// the partial work stays reviewable. It reports whether the task is over
+// its budget; the caller must then stop without starting another turn or
+// touching the status. A task that left in_progress meanwhile, e.g. was
+// cancelled, keeps its status and gets no events.
+func (r *Runner) failIfOverBudget(taskID uuid.UUID, sessionID string, turns int) bool {
+	bgCtx := context.Background()
+	task, err := r.store.GetTask(bgCtx, taskID)
+	if err != nil || task.MaxCostUSD <= 0 || task.Usage.CostUSD < task.MaxCostUSD {
+		return false
+	}
+	if ok, err := r.store.CompareAndSetStatus(bgCtx, taskID, "in_progress", "failed"); err != nil || !ok {
+		return true
+	}
+	msg := fmt.Sprintf("task cost $%.4f reached its budget of $%.2f", task.Usage.CostUSD, task.MaxCostUSD)
+	logger.Runner.Warn("task budget exceeded", "task", taskID, "cost", task.Usage.CostUSD, "budget", task.MaxCostUSD)
+	result := ""
+	if task.Result != nil {
+		result = *task.Result
+	}
+	r.store.UpdateTaskResult(bgCtx, taskID, result, sessionID, StopReasonBudgetExceeded, turns)
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeError, map[string]string{"error": msg})
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
+		"from": "in_progress", "to": "failed",
+	})
+	return true
+}
@@ -0,0 +1,180 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+
+	"changkun.de/wallfacer/internal/gitutil"
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// ErrCommitCancelled is returned by the commit pipeline when it was stopped
+// by CancelCommit. Partial merges have been rolled back where possible; the
+// caller that cancelled owns the task's status.
+var ErrCommitCancelled = errors.New("commit cancelled")
+
+// ErrCommitLanded is returned by CancelCommit once the pipeline has merged
+// every repo and moved on to pushing and cleanup, where it can no longer be
+// rolled back.
+var ErrCommitLanded = errors.New("commit already landed")
+
+// commitRun tracks a running commit pipeline so it can be cancelled.
+type commitRun struct {
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+
+	mu        sync.Mutex
+	cancelled bool
+	landed    bool
+}
+
+// startCommitRun registers the commit pipeline of taskID and returns its
+// cancellable context. finish must be called when the pipeline returns.
+func (r *Runner) startCommitRun(ctx context.Context, taskID uuid.UUID) (context.Context, *commitRun) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	run := &commitRun{cancel: cancel, done: make(chan struct{})}
+	r.commitRuns.Store(taskID, run)
+	return ctx, run
+}
+
+func (r *Runner) finishCommitRun(taskID uuid.UUID, run *commitRun) {
+	r.commitRuns.CompareAndDelete(taskID, run)
+	run.cancel(nil)
+	close(run.done)
+}
+
+// land marks the point after which the pipeline no longer stops. It reports
+// false if the pipeline was cancelled before reaching it.
+func (run *commitRun) land() bool {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.cancelled {
+		return false
+	}
+	run.landed = true
+	return true
+}
+
+// commitCancelled reports whether ctx belongs to a cancelled commit pipeline.
+func commitCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrCommitCancelled)
+}
+
+// CancelCommit stops the running commit pipeline of a task: it kills the
+// conflict resolver and commit message sandboxes, and waits until the
+// pipeline has aborted in-progress rebases and rolled back merges it already
+// made. It reports whether a pipeline was running, and returns
+// ErrCommitLanded when the pipeline is already past the point where it
+// could be rolled back.
+func (r *Runner) CancelCommit(taskID uuid.UUID) (bool, error) {
+	v, ok := r.commitRuns.Load(taskID)
+	if !ok {
+		return false, nil
+	}
+	run := v.(*commitRun)
+	run.mu.Lock()
+	if run.landed {
+		run.mu.Unlock()
+		return true, ErrCommitLanded
+	}
+	run.cancelled = true
+	run.mu.Unlock()
+
+	run.cancel(ErrCommitCancelled)
+	r.KillContainer(taskID)
+	exec.Command(r.command, "sandbox", "rm", "wf-c-"+taskID.String()[:8]).Run()
+	<-run.done
+	return true, nil
+}
+
+// DeleteTask stops whatever a task is still doing and then removes its
+// worktrees and branch, and the task and its data. Like the deadline reaper,
+// it rolls back a running commit pipeline or a paused conflict and marks an
+// active task cancelled, so its Run leaves it alone, before killing its
+// sandbox. It returns ErrCommitLanded, deleting nothing, when the task's
+// commit is already past the point where it could be rolled back.
+func (r *Runner) DeleteTask(taskID uuid.UUID) error {
+	bgCtx := context.Background()
+	t, err := r.store.GetTask(bgCtx, taskID)
+	if err != nil {
+		return err
+	}
+	switch t.Status {
+	case "in_progress", "committing":
+		if _, err := r.CancelCommit(taskID); err != nil {
+			return err
+		}
+	case "conflict":
+		if err := r.CancelConflict(taskID); err != nil {
+			logger.Runner.Warn("delete: roll back conflict", "task", taskID, "error", err)
+		}
+	}
+	switch t.Status {
+	case "queued", "in_progress", "waiting", "committing", "conflict":
+		if _, err := r.store.CompareAndSetStatus(bgCtx, taskID, t.Status, "cancelled"); err != nil {
+			return err
+		}
+		r.KillContainer(taskID)
+	}
+
+	if len(t.WorktreePaths) > 0 {
+		r.cleanupWorktrees(taskID, t.WorktreePaths, t.BranchName)
+	}
+	return r.store.DeleteTask(bgCtx, taskID)
+}
+
+// rollbackCommit undoes what a cancelled pipeline did in Phase 2: it aborts
+// rebases left in progress in the worktrees and moves each target branch
+// the task was already merged into back to its base commit. Branches that
+// have moved on since the merge, files copied into non-git workspaces, and
+// pull requests that were opened are left alone and reported as events.
+func (r *Runner) rollbackCommit(taskID uuid.UUID, worktreePaths, commitHashes, baseHashes map[string]string) {
+	bgCtx := context.Background()
+	report := func(msg string) {
+		r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{"result": msg})
+	}
+
+	for repoPath, wt := range worktreePaths {
+		if !gitutil.IsGitRepo(wt) || !gitutil.RebaseInProgress(wt) {
+			continue
+		}
+		if err := gitutil.AbortRebase(wt); err != nil {
+			logger.Runner.Warn("rollback: abort rebase", "task", taskID, "repo", repoPath, "error", err)
+			report(fmt.Sprintf("Could not abort the rebase in %s: %v", wt, err))
+			continue
+		}
+		report(fmt.Sprintf("Aborted the rebase in progress for %s.", repoPath))
+	}
+
+	task, err := r.store.GetTask(bgCtx, taskID)
+	if err != nil {
+		return
+	}
+	for repoPath, hash := range commitHashes {
+		switch {
+		case !gitutil.IsGitRepo(repoPath):
+			report(fmt.Sprintf("Changes already copied into %s cannot be rolled back.", repoPath))
+			continue
+		case task.PullRequestURLs[repoPath] != "":
+			report(fmt.Sprintf("Pull request %s for %s stays open; close it on the code host.", task.PullRequestURLs[repoPath], repoPath))
+			continue
+		}
+		base := baseHashes[repoPath]
+		target := task.TargetBranches[repoPath]
+		if base == "" || target == "" {
+			report(fmt.Sprintf("No base commit recorded for %s; its merge was not rolled back.", repoPath))
+			continue
+		}
+		if err := gitutil.RewindBranch(repoPath, target, hash, base); err != nil {
+			logger.Runner.Warn("rollback: rewind branch", "task", taskID, "repo", repoPath, "error", err)
+			report(fmt.Sprintf("Could not roll back %s of %s: %v", target, repoPath, err))
+			continue
+		}
+		report(fmt.Sprintf("Rolled %s of %s back to %s.", target, repoPath, shortHash(base)))
+	}
+}
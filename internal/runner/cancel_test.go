@@ -0,0 +1,208 @@
+// Tests for cancel.go: cancelling a running commit pipeline and rolling
+// back its partial merges.
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// TestCancelCommitDuringResolver verifies that cancelling a pipeline while
+// the conflict resolver runs kills the resolver, leaves the default branch
+// untouched, and ends the pipeline with ErrCommitCancelled.
+func TestCancelCommitDuringResolver(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupRunnerWithCmd(t, []string{repo}, fakeHangingCmd(t, 100, endTurnOutput))
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "Conflict", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktreePaths, branchName, err := r.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt := worktreePaths[repo]
+	if err := os.WriteFile(filepath.Join(repo, "README.md"), []byte("main version\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, repo, "commit", "-am", "main change")
+	mainHead := gitRun(t, repo, "rev-parse", "main")
+	if err := os.WriteFile(filepath.Join(wt, "README.md"), []byte("task version\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, wt, "commit", "-am", "task change")
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- r.commit(ctx, task.ID, "", 1, worktreePaths, branchName) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !hasEvent(t, s, task.ID, "running resolver") {
+		if time.Now().After(deadline) {
+			t.Fatal("resolver never started")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	running, err := r.CancelCommit(task.ID)
+	if !running || err != nil {
+		t.Fatalf("CancelCommit = %v, %v; want true, nil", running, err)
+	}
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrCommitCancelled) {
+			t.Fatalf("commit returned %v, want ErrCommitCancelled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("commit pipeline did not stop after CancelCommit")
+	}
+	if got := gitRun(t, repo, "rev-parse", "main"); got != mainHead {
+		t.Errorf("main moved to %s during a cancelled commit", got)
+	}
+	if running, _ := r.CancelCommit(task.ID); running {
+		t.Error("pipeline still registered after it returned")
+	}
+}
+
+// TestDeleteTaskStopsCommitPipeline verifies that deleting a committing task
+// first stops its pipeline, leaving the default branch untouched, and then
+// removes its worktree and the task.
+func TestDeleteTaskStopsCommitPipeline(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupRunnerWithCmd(t, []string{repo}, fakeHangingCmd(t, 100, endTurnOutput))
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "Conflict", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktreePaths, branchName, err := r.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.UpdateTaskWorktrees(ctx, task.ID, worktreePaths, branchName)
+	s.UpdateTaskStatus(ctx, task.ID, "committing")
+	wt := worktreePaths[repo]
+	if err := os.WriteFile(filepath.Join(repo, "README.md"), []byte("main version\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, repo, "commit", "-am", "main change")
+	mainHead := gitRun(t, repo, "rev-parse", "main")
+	if err := os.WriteFile(filepath.Join(wt, "README.md"), []byte("task version\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, wt, "commit", "-am", "task change")
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- r.commit(ctx, task.ID, "", 1, worktreePaths, branchName) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for !hasEvent(t, s, task.ID, "running resolver") {
+		if time.Now().After(deadline) {
+			t.Fatal("resolver never started")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if err := r.DeleteTask(task.ID); err != nil {
+		t.Fatalf("DeleteTask: %v", err)
+	}
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrCommitCancelled) {
+			t.Fatalf("commit returned %v, want ErrCommitCancelled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("commit pipeline still running after DeleteTask")
+	}
+	if got := gitRun(t, repo, "rev-parse", "main"); got != mainHead {
+		t.Errorf("main moved to %s while the task was deleted", got)
+	}
+	if _, err := s.GetTask(ctx, task.ID); err == nil {
+		t.Error("task still present after DeleteTask")
+	}
+	if _, err := os.Stat(wt); !os.IsNotExist(err) {
+		t.Errorf("worktree of the deleted task still exists: %v", err)
+	}
+}
+
+// TestCancelCommitAfterLanding verifies that a pipeline past its last merge
+// refuses to be cancelled.
+func TestCancelCommitAfterLanding(t *testing.T) {
+	_, r := setupTestRunner(t, []string{setupTestRepo(t)})
+	id := uuid.New()
+	_, run := r.startCommitRun(context.Background(), id)
+	defer r.finishCommitRun(id, run)
+	if !run.land() {
+		t.Fatal("land() = false for a pipeline nobody cancelled")
+	}
+	if _, err := r.CancelCommit(id); !errors.Is(err, ErrCommitLanded) {
+		t.Errorf("CancelCommit = %v, want ErrCommitLanded", err)
+	}
+}
+
+// TestRollbackCommitRewindsMergedBranch verifies that a merge the pipeline
+// already made is undone when the target still points at it.
+func TestRollbackCommitRewindsMergedBranch(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupTestRunner(t, []string{repo})
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "Merged then cancelled", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktreePaths, branchName, err := r.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateTaskTargetBranches(ctx, task.ID, r.targetBranches(worktreePaths)); err != nil {
+		t.Fatal(err)
+	}
+	wt := worktreePaths[repo]
+	base := gitRun(t, repo, "rev-parse", "main")
+	if err := os.WriteFile(filepath.Join(wt, "new.txt"), []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, wt, "add", ".")
+	gitRun(t, wt, "commit", "-m", "add new.txt")
+
+	commitHashes, baseHashes := map[string]string{}, map[string]string{}
+	if err := r.rebaseAndMergeOne(ctx, task.ID, repo, wt, branchName, "", ctx,
+		commitHashes, baseHashes, newPhaseTimer(task.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if gitRun(t, repo, "rev-parse", "main") == base {
+		t.Fatal("merge did not advance main")
+	}
+
+	r.rollbackCommit(task.ID, worktreePaths, commitHashes, baseHashes)
+	if got := gitRun(t, repo, "rev-parse", "main"); got != base {
+		t.Errorf("main = %s after rollback, want base %s", got, base)
+	}
+	if !hasEvent(t, s, task.ID, "Rolled main") {
+		t.Error("no rollback event recorded")
+	}
+}
+
+// hasEvent reports whether any event of the task contains substr.
+func hasEvent(t *testing.T, s *store.Store, id uuid.UUID, substr string) bool {
+	t.Helper()
+	events, err := s.GetEvents(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		if strings.Contains(string(e.Data), substr) {
+			return true
+		}
+	}
+	return false
+}
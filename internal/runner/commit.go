@@ -2,9 +2,13 @@ package runner
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -42,9 +46,28 @@ func (r *Runner) commit(
 	turns int,
 	worktreePaths map[string]string,
 	branchName string,
-) error {
+) (err error) {
 	bgCtx := context.Background()
 	logger.Runner.Info("auto-commit", "task", taskID, "session", sessionID)
+	ctx, run := r.startCommitRun(ctx, taskID)
+	defer r.finishCommitRun(taskID, run)
+	defer func() {
+		switch {
+		case err == nil:
+			r.metrics.commitSuccesses.Add(1)
+		case !errors.Is(err, ErrCommitCancelled) && !errors.Is(err, ErrConflictPaused):
+			r.metrics.commitFailures.Add(1)
+		}
+	}()
+
+	// Record how long each phase takes; the timeline is logged per phase and
+	// summarised in a system event whether the pipeline succeeds or fails.
+	timer := newPhaseTimer(taskID)
+	defer func() {
+		r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+			"result": "Commit pipeline timing: " + timer.summary(),
+		})
+	}()
 
 	// Phase 1: stage and commit all uncommitted changes on the host.
 	r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
@@ -55,7 +78,13 @@ func (r *Runner) commit(
 	if task != nil {
 		taskPrompt = task.Prompt
 	}
-	if _, stageErr := r.hostStageAndCommit(taskID, worktreePaths, taskPrompt); stageErr != nil {
+	stageStart := time.Now()
+	_, stageErr := r.hostStageAndCommit(taskID, worktreePaths, taskPrompt)
+	timer.track("stage", "", stageStart)
+	if commitCancelled(ctx) {
+		return r.cancelCommitPipeline(taskID, worktreePaths, nil, nil)
+	}
+	if stageErr != nil {
 		logger.Runner.Error("host stage/commit failed", "task", taskID, "error", stageErr)
 		r.store.InsertEvent(bgCtx, taskID, store.EventTypeError, map[string]string{
 			"error": "stage/commit failed: " + stageErr.Error(),
@@ -63,11 +92,44 @@ func (r *Runner) commit(
 		return fmt.Errorf("stage and commit: %w", stageErr)
 	}
 
-	// Phase 2: host-side rebase and merge for each git worktree.
-	r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
-		"result": "Phase 2/3: Rebasing and merging into default branch...",
-	})
-	commitHashes, baseHashes, mergeErr := r.rebaseAndMerge(ctx, taskID, worktreePaths, branchName, sessionID)
+	// Phase 2: host-side rebase and merge for each git worktree. In-place
+	// tasks committed directly on the workspace branch, so there is nothing
+	// to merge.
+	var commitHashes, baseHashes map[string]string
+	var mergeErr error
+	if r.noWorktree {
+		r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+			"result": "Phase 2/3: Committed in place; no merge needed.",
+		})
+		commitHashes, baseHashes = r.inPlaceCommitHashes(taskID, worktreePaths)
+	} else {
+		msg := "Phase 2/3: Rebasing and merging into default branch..."
+		if r.mergeStrategyFor(task) == store.MergeStrategyPullRequest {
+			msg = "Phase 2/3: Rebasing and opening pull requests..."
+		}
+		r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+			"result": msg,
+		})
+		commitHashes, baseHashes, mergeErr = r.rebaseAndMerge(ctx, taskID, worktreePaths, branchName, sessionID, timer)
+		if mergeErr == nil && task != nil {
+			carryOverMerged(task, commitHashes, baseHashes)
+		}
+	}
+	// Past this point the pipeline pushes and cleans up, which cannot be
+	// undone, so a cancel either rolls back here or is refused.
+	if commitCancelled(ctx) || (mergeErr == nil && !run.land()) {
+		if r.noWorktree {
+			// In-place commits stay on the workspace branch like the
+			// rest of the task's edits.
+			commitHashes = nil
+		}
+		return r.cancelCommitPipeline(taskID, worktreePaths, commitHashes, baseHashes)
+	}
+	if errors.Is(mergeErr, ErrConflictPaused) {
+		logger.Runner.Info("commit paused on conflict", "task", taskID, "error", mergeErr)
+		r.enterConflict(taskID, commitHashes, baseHashes)
+		return mergeErr
+	}
 	if mergeErr != nil {
 		logger.Runner.Error("rebase/merge failed", "task", taskID, "error", mergeErr)
 		r.store.InsertEvent(bgCtx, taskID, store.EventTypeError, map[string]string{
@@ -90,7 +152,12 @@ func (r *Runner) commit(
 			logger.Runner.Warn("save base commit hashes", "task", taskID, "error", err)
 		}
 	}
+	if r.autoPush && r.mergeStrategyFor(task) != store.MergeStrategyPullRequest {
+		r.pushMerged(taskID, commitHashes, timer)
+	}
+	cleanupStart := time.Now()
 	r.cleanupWorktrees(taskID, worktreePaths, branchName)
+	timer.track("cleanup", "", cleanupStart)
 
 	r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
 		"result": "Commit pipeline completed.",
@@ -99,9 +166,25 @@ func (r *Runner) commit(
 	return nil
 }
 
+// cancelCommitPipeline rolls back a cancelled pipeline and returns
+// ErrCommitCancelled.
+func (r *Runner) cancelCommitPipeline(taskID uuid.UUID, worktreePaths, commitHashes, baseHashes map[string]string) error {
+	logger.Runner.Info("commit cancelled, rolling back", "task", taskID)
+	r.store.InsertEvent(context.Background(), taskID, store.EventTypeSystem, map[string]string{
+		"result": "Commit pipeline cancelled. Rolling back...",
+	})
+	r.rollbackCommit(taskID, worktreePaths, commitHashes, baseHashes)
+	return ErrCommitCancelled
+}
+
+// maxMarkerLocations caps how many conflict marker locations per repository
+// hostStageAndCommit lists in its error.
+const maxMarkerLocations = 10
+
 // hostStageAndCommit stages and commits all uncommitted changes in each
 // worktree directly on the host. Returns true if any new commits were created.
-// Returns an error if changes were present but could not be staged or committed.
+// Returns an error if changes were present but could not be staged or committed,
+// or if they add unresolved conflict markers, in which case nothing is committed.
 func (r *Runner) hostStageAndCommit(taskID uuid.UUID, worktreePaths map[string]string, prompt string) (bool, error) {
 	// First pass: stage all changes and collect diff stats for each worktree
 	// that has pending changes.
@@ -110,11 +193,16 @@ func (r *Runner) hostStageAndCommit(taskID uuid.UUID, worktreePaths map[string]s
 		worktreePath string
 		diffStat     string
 		recentLog    string
+		pathPrefix   string
 	}
 	var pending []pendingCommit
-	var errs []string
+	var errs, markers []string
 
 	for repoPath, worktreePath := range worktreePaths {
+		if r.noWorktree && !gitutil.IsGitRepo(worktreePath) {
+			// Non-git workspaces edited in place have nothing to commit.
+			continue
+		}
 		if out, err := exec.Command("git", "-C", worktreePath, "add", "-A").CombinedOutput(); err != nil {
 			logger.Runner.Warn("host commit: git add -A", "repo", repoPath, "error", err, "output", string(out))
 			errs = append(errs, fmt.Sprintf("git add in %s: %v", repoPath, err))
@@ -127,9 +215,43 @@ func (r *Runner) hostStageAndCommit(taskID uuid.UUID, worktreePaths map[string]s
 			continue
 		}
 
+		// A resolver that gave up can leave conflict markers behind;
+		// committing them would carry broken files into the default branch.
+		if locs, err := gitutil.ConflictMarkers(worktreePath); err != nil {
+			logger.Runner.Warn("host commit: conflict marker check", "repo", repoPath, "error", err)
+		} else if len(locs) > 0 {
+			logger.Runner.Error("host commit: unresolved conflict markers", "repo", repoPath, "locations", locs)
+			if len(locs) > maxMarkerLocations {
+				locs = append(locs[:maxMarkerLocations], fmt.Sprintf("and %d more", len(locs)-maxMarkerLocations))
+			}
+			markers = append(markers, fmt.Sprintf("%s (%s)", repoPath, strings.Join(locs, ", ")))
+			continue
+		}
+
 		statOut, _ := exec.Command("git", "-C", worktreePath, "diff", "--cached", "--stat").Output()
 		logOut, _ := exec.Command("git", "-C", worktreePath, "log", "--format=%s", "-5").Output()
-		pending = append(pending, pendingCommit{repoPath, worktreePath, strings.TrimSpace(string(statOut)), strings.TrimSpace(string(logOut))})
+		namesOut, _ := exec.Command("git", "-C", worktreePath, "diff", "--cached", "--name-only").Output()
+		pending = append(pending, pendingCommit{
+			repoPath, worktreePath,
+			strings.TrimSpace(string(statOut)), strings.TrimSpace(string(logOut)),
+			commonPathPrefix(strings.Fields(string(namesOut))),
+		})
+	}
+
+	// The primary workspace leads the commit message context; the rest
+	// follow in a stable order.
+	primary := r.primaryWorkspace()
+	sort.SliceStable(pending, func(i, j int) bool {
+		if (pending[i].repoPath == primary) != (pending[j].repoPath == primary) {
+			return pending[i].repoPath == primary
+		}
+		return pending[i].repoPath < pending[j].repoPath
+	})
+
+	if len(markers) > 0 {
+		sort.Strings(markers)
+		return false, fmt.Errorf("refusing to commit unresolved conflict markers in %s; resolve them and commit again",
+			strings.Join(markers, "; "))
 	}
 
 	if len(pending) == 0 {
@@ -145,8 +267,12 @@ func (r *Runner) hostStageAndCommit(taskID uuid.UUID, worktreePaths map[string]s
 	var allLogs strings.Builder
 	for _, p := range pending {
 		if len(pending) > 1 {
-			allStats.WriteString("Repository: " + p.repoPath + "\n")
-			allLogs.WriteString("Repository: " + p.repoPath + "\n")
+			label := "Repository: " + p.repoPath
+			if p.repoPath == primary {
+				label += " (primary)"
+			}
+			allStats.WriteString(label + "\n")
+			allLogs.WriteString(label + "\n")
 		}
 		allStats.WriteString(p.diffStat + "\n")
 		if p.recentLog != "" {
@@ -154,26 +280,28 @@ func (r *Runner) hostStageAndCommit(taskID uuid.UUID, worktreePaths map[string]s
 		}
 	}
 	msg := r.generateCommitMessage(taskID, prompt, allStats.String(), allLogs.String())
-
-	// Second pass: commit each worktree with the generated message.
-	// Use global git identity to prevent sandbox-set local configs from
-	// overriding the host user's author information.
-	var gitConfigOverrides []string
-	if out, err := exec.Command("git", "config", "--global", "user.name").Output(); err == nil {
-		if n := strings.TrimSpace(string(out)); n != "" {
-			gitConfigOverrides = append(gitConfigOverrides, "-c", "user.name="+n)
-		}
-	}
-	if out, err := exec.Command("git", "config", "--global", "user.email").Output(); err == nil {
-		if e := strings.TrimSpace(string(out)); e != "" {
-			gitConfigOverrides = append(gitConfigOverrides, "-c", "user.email="+e)
+	var title, titleMode string
+	if task, err := r.store.GetTask(context.Background(), taskID); err == nil && task.CommitTitle != "" {
+		// Title generation is asynchronous; an untitled task keeps the plain message.
+		if task.Title == "" {
+			logger.Runner.Info("commit title requested but task has no title yet", "task", taskID)
 		}
+		title, titleMode = task.Title, task.CommitTitle
 	}
 
+	// Second pass: commit each worktree with the generated message.
+	gitConfigOverrides := hostIdentityArgs()
+
 	committed := false
 	for _, p := range pending {
 		args := append([]string{"-C", p.worktreePath}, gitConfigOverrides...)
-		args = append(args, "commit", "-m", msg)
+		repoMsg := msg
+		if len(pending) > 1 {
+			// Each repo's commit names the paths it actually changed.
+			repoMsg = scopeCommitSubject(msg, p.pathPrefix)
+		}
+		repoMsg = labelCommitMessage(repoMsg, title, titleMode)
+		args = append(args, "commit", "-m", repoMsg)
 		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
 			logger.Runner.Warn("host commit: git commit", "repo", p.repoPath, "error", err, "output", string(out))
 			errs = append(errs, fmt.Sprintf("git commit in %s: %v", p.repoPath, err))
@@ -189,16 +317,44 @@ func (r *Runner) hostStageAndCommit(taskID uuid.UUID, worktreePaths map[string]s
 	return committed, nil
 }
 
-// generateCommitMessage runs a lightweight one-shot sandbox to produce a
-// descriptive git commit message from the task prompt, staged diff stats, and
-// recent git log history (used to match the project's commit style).
-// Falls back to a truncated prompt on any error.
+// hostIdentityArgs returns "-c" arguments that set the host user's global
+// git identity, so sandbox-set local configs cannot override the author of
+// commits made on the host.
+func hostIdentityArgs() []string {
+	var args []string
+	if out, err := exec.Command("git", "config", "--global", "user.name").Output(); err == nil {
+		if n := strings.TrimSpace(string(out)); n != "" {
+			args = append(args, "-c", "user.name="+n)
+		}
+	}
+	if out, err := exec.Command("git", "config", "--global", "user.email").Output(); err == nil {
+		if e := strings.TrimSpace(string(out)); e != "" {
+			args = append(args, "-c", "user.email="+e)
+		}
+	}
+	return args
+}
+
+// generateCommitMessage writes the commit message for a task's changes
+// according to the commit message mode. In CommitMessageClaude mode it runs a
+// lightweight one-shot sandbox to produce a descriptive message from the task
+// prompt, staged diff stats, and recent git log history (used to match the
+// project's commit style), falling back to the commit message template on any
+// error. A template that fails to render falls back to a truncated prompt.
 func (r *Runner) generateCommitMessage(taskID uuid.UUID, prompt, diffStat, recentLog string) string {
-	firstLine := prompt
-	if idx := strings.IndexByte(firstLine, '\n'); idx >= 0 {
-		firstLine = firstLine[:idx]
+	var title string
+	if task, err := r.store.GetTask(context.Background(), taskID); err == nil {
+		title = task.Title
+	}
+	fields := commitMessageFields(taskID, title, prompt, diffStat)
+	promptMsg := "wallfacer: " + fields["prompt_first_line"]
+
+	switch r.commitMessageMode {
+	case CommitMessagePrompt:
+		return promptMsg
+	case CommitMessageTemplate:
+		return r.templateCommitMessage(taskID, fields, promptMsg)
 	}
-	fallback := "wallfacer: " + truncate(firstLine, 72)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
 	defer cancel()
@@ -213,7 +369,8 @@ func (r *Runner) generateCommitMessage(taskID uuid.UUID, prompt, diffStat, recen
 		"- Single line only — no body, no blank lines\n" +
 		"- Max 72 characters total, no trailing period\n" +
 		"- Output ONLY the raw commit message text, no markdown, no code fences, no explanation\n" +
-		"- Match the style and tone of the recent commit history shown below\n\n" +
+		"- Match the style and tone of the recent commit history shown below\n" +
+		"- If several repositories are listed, take <primary-path> from the one marked (primary)\n\n" +
 		"Task:\n" + prompt + "\n\n" +
 		"Changed files:\n" + diffStat
 	if recentLog != "" {
@@ -223,7 +380,7 @@ func (r *Runner) generateCommitMessage(taskID uuid.UUID, prompt, diffStat, recen
 	output, err := r.runOneShotSandbox(ctx, name, commitPrompt, nil)
 	if err != nil {
 		logger.Runner.Warn("commit message generation failed", "task", taskID, "error", err)
-		return fallback
+		return r.templateCommitMessage(taskID, fields, promptMsg)
 	}
 
 	msg := strings.TrimSpace(output.Result)
@@ -231,14 +388,134 @@ func (r *Runner) generateCommitMessage(taskID uuid.UUID, prompt, diffStat, recen
 	msg = strings.TrimSpace(msg)
 	if msg == "" {
 		logger.Runner.Warn("commit message generation: blank result", "task", taskID)
-		return fallback
+		return r.templateCommitMessage(taskID, fields, promptMsg)
 	}
 
 	return msg
 }
 
+// templateCommitMessage renders the configured commit message template,
+// returning fallback when it fails.
+func (r *Runner) templateCommitMessage(taskID uuid.UUID, fields map[string]string, fallback string) string {
+	msg, err := renderCommitMessage(r.commitMessageTemplate, fields)
+	if err != nil {
+		logger.Runner.Warn("commit message template failed", "task", taskID, "error", err)
+		return fallback
+	}
+	return msg
+}
+
+// commonPathPrefix returns the path prefix shared by files: the file itself
+// when only one changed, otherwise their deepest common directory. Returns ""
+// when the files share no directory.
+func commonPathPrefix(files []string) string {
+	if len(files) == 0 {
+		return ""
+	}
+	if len(files) == 1 {
+		return files[0]
+	}
+	common := strings.Split(path.Dir(files[0]), "/")
+	for _, f := range files[1:] {
+		parts := strings.Split(path.Dir(f), "/")
+		n := 0
+		for n < len(common) && n < len(parts) && common[n] == parts[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	prefix := strings.Join(common, "/")
+	if prefix == "." {
+		return ""
+	}
+	return prefix
+}
+
+// scopeCommitSubject replaces the "<path>: " prefix of msg's subject with
+// prefix, or prepends one when the subject has none. msg is returned
+// unchanged when prefix is empty.
+func scopeCommitSubject(msg, prefix string) string {
+	if prefix == "" {
+		return msg
+	}
+	subject, rest, hasBody := strings.Cut(msg, "\n")
+	if head, desc, ok := strings.Cut(subject, ": "); ok && !strings.ContainsAny(head, " \t") {
+		subject = desc
+	}
+	subject = prefix + ": " + subject
+	if hasBody {
+		return subject + "\n" + rest
+	}
+	return subject
+}
+
+// maxCommitSubject is the subject-line length cap used for generated commit messages.
+const maxCommitSubject = 72
+
+// labelCommitMessage embeds the task title into msg according to mode
+// (store.CommitTitlePrefix or store.CommitTitleSuffix). When the labelled
+// subject would exceed maxCommitSubject, the title goes into the body instead.
+func labelCommitMessage(msg, title, mode string) string {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return msg
+	}
+	subject, body, _ := strings.Cut(msg, "\n")
+	body = strings.TrimLeft(body, "\n")
+
+	var labelled string
+	switch mode {
+	case store.CommitTitlePrefix:
+		labelled = title + ": " + subject
+	case store.CommitTitleSuffix:
+		labelled = subject + " (" + title + ")"
+	default:
+		return msg
+	}
+	if len(labelled) > maxCommitSubject {
+		labelled = subject
+		if body != "" {
+			body = "Task: " + title + "\n\n" + body
+		} else {
+			body = "Task: " + title
+		}
+	}
+	if body == "" {
+		return labelled
+	}
+	return labelled + "\n\n" + body
+}
+
+// inPlaceCommitHashes returns the commit and base hashes of an in-place task:
+// the workspace HEAD after committing, paired with the HEAD recorded when the
+// task started. Repos whose HEAD did not move are omitted.
+func (r *Runner) inPlaceCommitHashes(taskID uuid.UUID, worktreePaths map[string]string) (map[string]string, map[string]string) {
+	bgCtx := context.Background()
+	commitHashes := make(map[string]string)
+	baseHashes := make(map[string]string)
+	task, _ := r.store.GetTask(bgCtx, taskID)
+	for repoPath, hash := range headHashes(worktreePaths) {
+		base := ""
+		if task != nil {
+			base = task.BaseCommitHashes[repoPath]
+		}
+		if base == hash {
+			continue
+		}
+		commitHashes[repoPath] = hash
+		if base != "" {
+			baseHashes[repoPath] = base
+		}
+		r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+			"result": fmt.Sprintf("Committed in %s — commit %s", repoPath, hash[:8]),
+		})
+	}
+	return commitHashes, baseHashes
+}
+
 // rebaseAndMerge performs the host-side git pipeline for all worktrees:
 // rebase onto default branch (with conflict-resolution retries), ff-merge, collect hashes.
+// Phase durations are recorded on timer, which may be nil.
 // Returns (commitHashes, baseHashes, error).
 func (r *Runner) rebaseAndMerge(
 	ctx context.Context,
@@ -246,21 +523,27 @@ func (r *Runner) rebaseAndMerge(
 	worktreePaths map[string]string,
 	branchName string,
 	sessionID string,
+	timer *phaseTimer,
 ) (map[string]string, map[string]string, error) {
 	bgCtx := context.Background()
 	commitHashes := make(map[string]string)
 	baseHashes := make(map[string]string)
 
 	for repoPath, worktreePath := range worktreePaths {
+		if commitCancelled(ctx) {
+			return commitHashes, baseHashes, ErrCommitCancelled
+		}
 		logger.Runner.Info("rebase+merge", "task", taskID, "repo", repoPath)
 
 		// Serialize rebase+merge per repo so concurrent tasks on the same
 		// repo don't race (the second task sees the first task's merge
 		// before rebasing). Tasks on different repos remain fully concurrent.
 		mu := r.repoLock(repoPath)
+		lockStart := time.Now()
 		mu.Lock()
+		timer.track("lock_wait", repoPath, lockStart)
 
-		err := r.rebaseAndMergeOne(ctx, taskID, repoPath, worktreePath, branchName, sessionID, bgCtx, commitHashes, baseHashes)
+		err := r.rebaseAndMergeOne(ctx, taskID, repoPath, worktreePath, branchName, sessionID, bgCtx, commitHashes, baseHashes, timer)
 		mu.Unlock()
 		if err != nil {
 			return commitHashes, baseHashes, err
@@ -278,13 +561,17 @@ func (r *Runner) rebaseAndMergeOne(
 	repoPath, worktreePath, branchName, sessionID string,
 	bgCtx context.Context,
 	commitHashes, baseHashes map[string]string,
+	timer *phaseTimer,
 ) error {
 	if !gitutil.IsGitRepo(repoPath) {
 		// Non-git workspace: copy snapshot changes back to the original directory.
 		r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
 			"result": fmt.Sprintf("Extracting changes from sandbox to %s...", filepath.Base(repoPath)),
 		})
-		if err := extractSnapshotToWorkspace(worktreePath, repoPath); err != nil {
+		extractStart := time.Now()
+		err := extractSnapshotToWorkspace(worktreePath, repoPath)
+		timer.track("extract", repoPath, extractStart)
+		if err != nil {
 			return fmt.Errorf("extract snapshot for %s: %w", repoPath, err)
 		}
 		if hash, err := gitutil.GetCommitHash(worktreePath); err == nil {
@@ -296,11 +583,25 @@ func (r *Runner) rebaseAndMergeOne(
 		return nil
 	}
 
-	defBranch, err := gitutil.DefaultBranch(repoPath)
+	defBranch, err := r.mergeTarget(bgCtx, taskID, repoPath)
 	if err != nil {
 		return fmt.Errorf("defaultBranch for %s: %w", repoPath, err)
 	}
 
+	// Bring the local default branch up to date with origin so the task
+	// rebases onto the true remote tip and the merge result can be pushed.
+	if r.syncRemote && gitutil.HasRemote(repoPath, "origin") {
+		r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+			"result": fmt.Sprintf("Pulling origin/%s into %s...", defBranch, repoPath),
+		})
+		syncStart := time.Now()
+		err := gitutil.PullFFOnly(repoPath, defBranch)
+		timer.track("sync", repoPath, syncStart)
+		if err != nil {
+			return fmt.Errorf("sync %s with origin before merge: %w", repoPath, err)
+		}
+	}
+
 	// Always capture defBranch HEAD for diff reconstruction, even if there
 	// are no commits to merge. This ensures TaskDiff can show "genuinely no
 	// changes" rather than failing silently when the early return fires.
@@ -321,26 +622,74 @@ func (r *Runner) rebaseAndMergeOne(
 		return nil
 	}
 
+	if err := r.squashTaskCommits(taskID, repoPath, worktreePath, defBranch, timer); err != nil {
+		return err
+	}
+
 	// Rebase with conflict-resolution retry loop.
 	var rebaseErr error
-	for attempt := 1; attempt <= maxRebaseRetries; attempt++ {
+	var prevConflicts []string
+	attempts := r.rebaseRetries + 1
+	for attempt := 1; attempt <= attempts; attempt++ {
 		r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
-			"result": fmt.Sprintf("Rebasing %s onto %s (attempt %d/%d)...", repoPath, defBranch, attempt, maxRebaseRetries),
+			"result": fmt.Sprintf("Rebasing %s onto %s (attempt %d/%d)...", repoPath, defBranch, attempt, attempts),
 		})
 
-		rebaseErr = gitutil.RebaseOntoDefault(repoPath, worktreePath)
+		rebaseStart := time.Now()
+		if r.resolveMode == ResolveManual {
+			rebaseErr = gitutil.StartRebase(worktreePath, defBranch, r.rebaseArgs()...)
+		} else {
+			rebaseErr = gitutil.RebaseOnto(worktreePath, defBranch, r.rebaseArgs()...)
+		}
+		timer.track("rebase", repoPath, rebaseStart)
 		if rebaseErr == nil {
 			break
 		}
+		if isConflictError(rebaseErr) {
+			r.metrics.rebaseConflicts.Add(1)
+			if r.resolveMode == ResolveManual {
+				return r.pauseRebase(taskID, repoPath, worktreePath, defBranch, rebaseErr)
+			}
+		}
 
-		if attempt == maxRebaseRetries {
+		// Stop early when the resolver ran but the rebase conflicts on
+		// exactly the same files again; further attempts would only burn
+		// tokens on the same stuck state.
+		var conflict *gitutil.ConflictError
+		if errors.As(rebaseErr, &conflict) && len(conflict.Files) > 0 {
+			if slices.Equal(conflict.Files, prevConflicts) {
+				files := strings.Join(conflict.Files, ", ")
+				r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+					"result": fmt.Sprintf("Rebase of %s conflicted on the same files after conflict resolution; giving up: %s", repoPath, files),
+				})
+				r.recordConflict(taskID, repoPath, defBranch, rebaseErr, "resolver made no progress")
+				return fmt.Errorf("rebase %s: resolver not making progress on: %s", repoPath, files)
+			}
+			prevConflicts = conflict.Files
+		}
+
+		if !isConflictError(rebaseErr) {
+			return fmt.Errorf("rebase %s: %w", repoPath, rebaseErr)
+		}
+		if r.rebaseRetries == 0 {
+			r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+				"result": fmt.Sprintf("Conflict in %s — not running the resolver (rebase retries are disabled).", repoPath),
+			})
+			r.recordConflict(taskID, repoPath, defBranch, rebaseErr, "rebase retries are disabled")
+			return fmt.Errorf("rebase %s: %w", repoPath, rebaseErr)
+		}
+		if attempt == attempts {
+			r.recordConflict(taskID, repoPath, defBranch, rebaseErr, "out of rebase attempts")
 			return fmt.Errorf(
 				"rebase failed after %d attempts in %s: %w",
-				maxRebaseRetries, repoPath, rebaseErr,
+				attempts, repoPath, rebaseErr,
 			)
 		}
-
-		if !isConflictError(rebaseErr) {
+		if r.rebaseConflict == RebaseConflictAbort {
+			r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+				"result": fmt.Sprintf("Conflict in %s — not running the resolver (conflict strategy is abort).", repoPath),
+			})
+			r.recordConflict(taskID, repoPath, defBranch, rebaseErr, "conflict strategy is abort")
 			return fmt.Errorf("rebase %s: %w", repoPath, rebaseErr)
 		}
 
@@ -350,16 +699,49 @@ func (r *Runner) rebaseAndMergeOne(
 			"result": fmt.Sprintf("Conflict in %s — running resolver (attempt %d)...", repoPath, attempt),
 		})
 
-		if resolveErr := r.resolveConflicts(ctx, taskID, repoPath, worktreePath, sessionID); resolveErr != nil {
+		resolveStart := time.Now()
+		resolveErr := r.resolveConflicts(ctx, taskID, repoPath, worktreePath, sessionID)
+		timer.track("resolve_conflicts", repoPath, resolveStart)
+		if resolveErr != nil {
+			r.recordConflict(taskID, repoPath, defBranch, rebaseErr, "conflict resolution failed")
 			return fmt.Errorf("conflict resolution failed: %w", resolveErr)
 		}
 	}
 
-	r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
-		"result": fmt.Sprintf("Fast-forward merging %s into %s...", branchName, defBranch),
-	})
-	if err := gitutil.FFMerge(repoPath, branchName); err != nil {
-		return fmt.Errorf("ff-merge %s: %w", repoPath, err)
+	// Refuse to land LFS-tracked files committed as raw content; they
+	// would bloat history and read as corrupt pointers to everyone else.
+	if gitutil.UsesLFS(worktreePath) {
+		invalid, err := gitutil.InvalidLFSPointers(worktreePath, defBranch)
+		if err != nil {
+			return fmt.Errorf("check LFS pointers in %s: %w", repoPath, err)
+		}
+		if len(invalid) > 0 {
+			return fmt.Errorf("%s: LFS-tracked files committed without the LFS filter (is git-lfs installed?): %s",
+				repoPath, strings.Join(invalid, ", "))
+		}
+	}
+
+	task, err := r.store.GetTask(bgCtx, taskID)
+	if err != nil {
+		return err
+	}
+	switch r.mergeStrategyFor(task) {
+	case store.MergeStrategyPullRequest:
+		return r.openPullRequest(task, repoPath, worktreePath, branchName, defBranch, commitHashes, timer)
+	case store.MergeStrategyMerge:
+		if err := r.mergeNoFF(taskID, repoPath, worktreePath, branchName, defBranch, timer); err != nil {
+			return err
+		}
+	default:
+		r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+			"result": fmt.Sprintf("Fast-forward merging %s into %s...", branchName, defBranch),
+		})
+		mergeStart := time.Now()
+		err = gitutil.FFMergeInto(repoPath, defBranch, branchName)
+		timer.track("merge", repoPath, mergeStart)
+		if err != nil {
+			return fmt.Errorf("ff-merge %s: %w", repoPath, err)
+		}
 	}
 
 	hash, err := gitutil.GetCommitHash(repoPath)
@@ -375,6 +757,81 @@ func (r *Runner) rebaseAndMergeOne(
 	return nil
 }
 
+// mergeStrategyFor returns how the commit pipeline lands task: its own
+// MergeStrategy, or the server default when it sets none or is nil.
+func (r *Runner) mergeStrategyFor(task *store.Task) string {
+	if task != nil && task.MergeStrategy != "" {
+		return task.MergeStrategy
+	}
+	if r.mergeStrategy != "" {
+		return r.mergeStrategy
+	}
+	return store.MergeStrategyFF
+}
+
+// mergeNoFF merges the rebased task branch into target with an explicit
+// merge commit under store.MergeStrategyMerge. The merge commit carries a message
+// generated from the task's combined changes.
+func (r *Runner) mergeNoFF(taskID uuid.UUID, repoPath, worktreePath, branchName, target string, timer *phaseTimer) error {
+	bgCtx := context.Background()
+	task, err := r.store.GetTask(bgCtx, taskID)
+	if err != nil {
+		return err
+	}
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+		"result": fmt.Sprintf("Merging %s into %s with a merge commit...", branchName, target),
+	})
+	mergeStart := time.Now()
+	msg := r.rangeCommitMessage(task, worktreePath, target)
+	err = gitutil.MergeNoFF(repoPath, target, branchName, msg, hostIdentityArgs()...)
+	timer.track("merge", repoPath, mergeStart)
+	if err != nil {
+		return fmt.Errorf("merge %s: %w", repoPath, err)
+	}
+	return nil
+}
+
+// rebaseArgs returns the extra git rebase arguments for the configured
+// conflict strategy: -X theirs or -X ours settle conflicting hunks before
+// the resolver is needed.
+func (r *Runner) rebaseArgs() []string {
+	switch r.rebaseConflict {
+	case RebaseConflictTheirs, RebaseConflictOurs:
+		return []string{"-X", r.rebaseConflict}
+	}
+	return nil
+}
+
+// conflictEvent is the data of a store.EventTypeConflict event: the rebase
+// conflict that stopped a task from merging into Target.
+type conflictEvent struct {
+	Repo   string   `json:"repo"`
+	Target string   `json:"target"`
+	Files  []string `json:"files"`
+	Commit string   `json:"commit,omitempty"` // subject of the commit that failed to apply
+	Hunks  string   `json:"hunks,omitempty"`  // combined diff of the conflicted files
+	Reason string   `json:"reason"`           // why the pipeline gave up
+}
+
+// recordConflict records the details of a rebase conflict the commit
+// pipeline gives up on as a conflict event, so the cause of the failure can
+// be inspected without re-running the rebase. Errors that carry no
+// *gitutil.ConflictError are ignored.
+func (r *Runner) recordConflict(taskID uuid.UUID, repoPath, target string, err error, reason string) {
+	var conflict *gitutil.ConflictError
+	if !errors.As(err, &conflict) {
+		return
+	}
+	r.store.InsertEvent(context.Background(), taskID, store.EventTypeConflict, conflictEvent{
+		Repo:   repoPath,
+		Target: target,
+		Files:  conflict.Files,
+		Commit: conflict.Commit,
+		Hunks:  conflict.Hunks,
+		Reason: reason,
+	})
+}
+
 // isConflictError reports whether err wraps ErrConflict.
 func isConflictError(err error) bool {
 	return err != nil && strings.Contains(err.Error(), gitutil.ErrConflict.Error())
@@ -412,7 +869,7 @@ func (r *Runner) resolveConflicts(
 	if task != nil {
 		turns = task.Turns + 1
 	}
-	r.store.SaveTurnOutput(taskID, turns, rawStdout, rawStderr)
+	r.saveTurnOutput(taskID, turns, rawStdout, rawStderr)
 
 	if err != nil {
 		return fmt.Errorf("conflict resolver container: %w", err)
@@ -426,3 +883,38 @@ func (r *Runner) resolveConflicts(
 	})
 	return nil
 }
+
+// mergeTarget returns the branch the task's work in repoPath merges into:
+// the target recorded when the worktree was created, so switching branches
+// in the main checkout mid-task cannot redirect the merge. When nothing was
+// recorded, or the recorded branch no longer exists, the default branch is
+// detected again and recorded, with an event if it replaces a stale target.
+func (r *Runner) mergeTarget(ctx context.Context, taskID uuid.UUID, repoPath string) (string, error) {
+	task, err := r.store.GetTask(ctx, taskID)
+	if err != nil {
+		return "", err
+	}
+	prev := task.TargetBranches[repoPath]
+	if prev != "" && gitutil.BranchExists(repoPath, prev) {
+		return prev, nil
+	}
+	defBranch, err := gitutil.DefaultBranch(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if prev != "" {
+		r.store.InsertEvent(ctx, taskID, store.EventTypeSystem, map[string]string{
+			"result": fmt.Sprintf("Target branch of %s changed from %s to %s since the task started (%s no longer exists); merging into %s.",
+				filepath.Base(repoPath), prev, defBranch, prev, defBranch),
+		})
+	}
+	targets := make(map[string]string, len(task.TargetBranches)+1)
+	for k, v := range task.TargetBranches {
+		targets[k] = v
+	}
+	targets[repoPath] = defBranch
+	if err := r.store.UpdateTaskTargetBranches(ctx, taskID, targets); err != nil {
+		logger.Runner.Warn("update target branch", "task", taskID, "repo", repoPath, "error", err)
+	}
+	return defBranch, nil
+}
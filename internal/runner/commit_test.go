@@ -1,12 +1,17 @@
 package runner
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
+	"changkun.de/wallfacer/internal/gitutil"
 	"changkun.de/wallfacer/internal/store"
 	"github.com/google/uuid"
 )
@@ -193,6 +198,82 @@ func TestGenerateCommitMessageNDJSON(t *testing.T) {
 	}
 }
 
+// TestGenerateCommitMessageModes verifies that template and prompt modes
+// write the message locally without starting a container, and that a failed
+// Claude generation falls back to the configured template.
+func TestGenerateCommitMessageModes(t *testing.T) {
+	const tmpl = "{{.title}}: {{.prompt_first_line}}\n\nTask {{.task_id}}\n{{.diff_stat}}"
+	prompt := "Fix the login bug\nwith details"
+
+	newRunner := func(t *testing.T, cmd, mode string) (*Runner, uuid.UUID) {
+		t.Helper()
+		r := runnerWithCmd(t, cmd)
+		r.commitMessageMode = mode
+		r.commitMessageTemplate = tmpl
+		task, err := r.store.CreateTask(bg(), prompt, 5, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.store.UpdateTaskTitle(bg(), task.ID, "Auth"); err != nil {
+			t.Fatal(err)
+		}
+		return r, task.ID
+	}
+
+	t.Run("template", func(t *testing.T) {
+		r, id := newRunner(t, fakeCmdScript(t, validStreamJSON, 0), CommitMessageTemplate)
+		msg := r.generateCommitMessage(id, prompt, "login.go | 3 +-", "")
+		want := "Auth: Fix the login bug\n\nTask " + id.String() + "\nlogin.go | 3 +-"
+		if msg != want {
+			t.Fatalf("expected %q, got %q", want, msg)
+		}
+		if got := r.Metrics().ContainersRun; got != 0 {
+			t.Fatalf("template mode started %d containers", got)
+		}
+	})
+
+	t.Run("prompt", func(t *testing.T) {
+		r, id := newRunner(t, fakeCmdScript(t, validStreamJSON, 0), CommitMessagePrompt)
+		if msg := r.generateCommitMessage(id, prompt, "login.go | 3 +-", ""); msg != "wallfacer: Fix the login bug" {
+			t.Fatalf("unexpected prompt-mode message %q", msg)
+		}
+		if got := r.Metrics().ContainersRun; got != 0 {
+			t.Fatalf("prompt mode started %d containers", got)
+		}
+	})
+
+	t.Run("claude falls back to template", func(t *testing.T) {
+		r, id := newRunner(t, fakeCmdScript(t, "", 1), CommitMessageClaude)
+		msg := r.generateCommitMessage(id, prompt, "login.go | 3 +-", "")
+		if !strings.HasPrefix(msg, "Auth: Fix the login bug\n\nTask "+id.String()) {
+			t.Fatalf("expected template fallback, got %q", msg)
+		}
+	})
+
+	t.Run("broken template", func(t *testing.T) {
+		r, id := newRunner(t, "echo", CommitMessageTemplate)
+		r.commitMessageTemplate = "{{.missing}}"
+		if msg := r.generateCommitMessage(id, prompt, "", ""); msg != "wallfacer: Fix the login bug" {
+			t.Fatalf("expected prompt fallback, got %q", msg)
+		}
+	})
+}
+
+func TestValidateCommitMessageTemplate(t *testing.T) {
+	for tmpl, ok := range map[string]bool{
+		"":                           true,
+		DefaultCommitMessageTemplate: true,
+		"{{.title}} ({{.task_id}})":  true,
+		"{{.prompt_first_line":       false,
+		"{{.unknown}}":               false,
+		"   ":                        false,
+	} {
+		if err := ValidateCommitMessageTemplate(tmpl); (err == nil) != ok {
+			t.Errorf("ValidateCommitMessageTemplate(%q) = %v, want ok=%v", tmpl, err, ok)
+		}
+	}
+}
+
 // ---------------------------------------------------------------------------
 // hostStageAndCommit integration tests
 // ---------------------------------------------------------------------------
@@ -299,3 +380,487 @@ func TestHostStageAndCommitFallsBackOnContainerFailure(t *testing.T) {
 		t.Fatalf("fallback commit message should contain prompt, got: %q", subject)
 	}
 }
+
+// TestLabelCommitMessage verifies title placement in the subject and the
+// fallback to the body when the labelled subject would be too long.
+func TestLabelCommitMessage(t *testing.T) {
+	long := strings.Repeat("x", 70)
+	cases := []struct {
+		name, msg, title, mode, want string
+	}{
+		{"empty title", "ui: fix button", "", store.CommitTitlePrefix, "ui: fix button"},
+		{"no mode", "ui: fix button", "Fix Button", "", "ui: fix button"},
+		{"prefix", "ui: fix button", "Fix Button", store.CommitTitlePrefix, "Fix Button: ui: fix button"},
+		{"suffix", "ui: fix button", "Fix Button", store.CommitTitleSuffix, "ui: fix button (Fix Button)"},
+		{"too long goes to body", long, "Fix Button", store.CommitTitlePrefix, long + "\n\nTask: Fix Button"},
+		{"keeps existing body", "ui: fix\n\ndetails", "T", store.CommitTitleSuffix, "ui: fix (T)\n\ndetails"},
+	}
+	for _, c := range cases {
+		if got := labelCommitMessage(c.msg, c.title, c.mode); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestCommonPathPrefix verifies the path prefix derived from changed files.
+func TestCommonPathPrefix(t *testing.T) {
+	cases := []struct {
+		files []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"Makefile"}, "Makefile"},
+		{[]string{"internal/runner/a.go", "internal/runner/b.go"}, "internal/runner"},
+		{[]string{"internal/runner/a.go", "internal/store/b.go"}, "internal"},
+		{[]string{"README.md", "ui/js/app.js"}, ""},
+	}
+	for _, c := range cases {
+		if got := commonPathPrefix(c.files); got != c.want {
+			t.Errorf("commonPathPrefix(%v) = %q, want %q", c.files, got, c.want)
+		}
+	}
+}
+
+// TestScopeCommitSubject verifies that the subject's path prefix is replaced
+// or added without touching the body.
+func TestScopeCommitSubject(t *testing.T) {
+	cases := []struct{ msg, prefix, want string }{
+		{"internal/runner: add retries", "ui/js", "ui/js: add retries"},
+		{"wallfacer: Add feature", "api", "api: Add feature"},
+		{"Add feature", "api", "api: Add feature"},
+		{"Fix bug: handle nil", "api", "api: Fix bug: handle nil"},
+		{"ui: fix\n\ndetails", "docs", "docs: fix\n\ndetails"},
+		{"ui: fix", "", "ui: fix"},
+	}
+	for _, c := range cases {
+		if got := scopeCommitSubject(c.msg, c.prefix); got != c.want {
+			t.Errorf("scopeCommitSubject(%q, %q) = %q, want %q", c.msg, c.prefix, got, c.want)
+		}
+	}
+}
+
+// TestHostStageAndCommitScopesMultiRepoSubjects verifies that in a
+// multi-repo task each repo's commit subject names its own changed paths.
+func TestHostStageAndCommitScopesMultiRepoSubjects(t *testing.T) {
+	repoA := setupTestRepo(t)
+	repoB := setupTestRepo(t)
+	cmd := fakeCmdScript(t, "", 1) // fallback message
+	_, runner := setupRunnerWithCmd(t, []string{repoA, repoB}, cmd)
+
+	taskID := uuid.New()
+	worktreePaths, branchName, err := runner.setupWorktrees(taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { runner.cleanupWorktrees(taskID, worktreePaths, branchName) })
+
+	os.MkdirAll(filepath.Join(worktreePaths[repoA], "api"), 0755)
+	os.WriteFile(filepath.Join(worktreePaths[repoA], "api", "auth.go"), []byte("package api\n"), 0644)
+	os.MkdirAll(filepath.Join(worktreePaths[repoB], "web"), 0755)
+	os.WriteFile(filepath.Join(worktreePaths[repoB], "web", "login.js"), []byte("//\n"), 0644)
+
+	if _, err := runner.hostStageAndCommit(taskID, worktreePaths, "Add login"); err != nil {
+		t.Fatalf("hostStageAndCommit error: %v", err)
+	}
+	if got := gitRun(t, worktreePaths[repoA], "log", "--format=%s", "-1"); got != "api/auth.go: Add login" {
+		t.Errorf("repo A subject = %q", got)
+	}
+	if got := gitRun(t, worktreePaths[repoB], "log", "--format=%s", "-1"); got != "web/login.js: Add login" {
+		t.Errorf("repo B subject = %q", got)
+	}
+}
+
+// TestPrimaryWorkspace verifies the configured primary is honoured only when
+// it is a workspace, falling back to the first one.
+func TestPrimaryWorkspace(t *testing.T) {
+	_, r := setupTestRunner(t, []string{"/a", "/b"})
+	if got := r.primaryWorkspace(); got != "/a" {
+		t.Errorf("default primary = %q, want /a", got)
+	}
+	r.primary = "/b"
+	if got := r.primaryWorkspace(); got != "/b" {
+		t.Errorf("configured primary = %q, want /b", got)
+	}
+	r.primary = "/elsewhere"
+	if got := r.primaryWorkspace(); got != "/a" {
+		t.Errorf("unknown primary = %q, want /a", got)
+	}
+}
+
+// TestRebaseStopsWhenResolverMakesNoProgress verifies that when the rebase
+// conflicts on the same files after a resolver run, the retry loop gives up
+// with a no-progress error instead of spending the remaining attempts.
+func TestRebaseStopsWhenResolverMakesNoProgress(t *testing.T) {
+	repo := setupTestRepo(t)
+	// The resolver "succeeds" without touching the worktree.
+	s, r := setupRunnerWithCmd(t, []string{repo}, fakeCmdScript(t, endTurnOutput, 0))
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "Conflict", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktreePaths, branchName, err := r.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt := worktreePaths[repo]
+
+	if err := os.WriteFile(filepath.Join(repo, "README.md"), []byte("main version\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, repo, "commit", "-am", "main change")
+	if err := os.WriteFile(filepath.Join(wt, "README.md"), []byte("task version\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, wt, "commit", "-am", "task change")
+
+	err = r.rebaseAndMergeOne(ctx, task.ID, repo, wt, branchName, "", ctx,
+		map[string]string{}, map[string]string{}, newPhaseTimer(task.ID))
+	if err == nil || !strings.Contains(err.Error(), "resolver not making progress on: README.md") {
+		t.Fatalf("expected no-progress error, got %v", err)
+	}
+
+	events, _ := s.GetEvents(ctx, task.ID)
+	resolverRuns, gaveUp := 0, false
+	for _, e := range events {
+		data := string(e.Data)
+		if strings.Contains(data, "running resolver") {
+			resolverRuns++
+		}
+		if strings.Contains(data, "conflicted on the same files") {
+			gaveUp = true
+		}
+	}
+	if resolverRuns != 1 {
+		t.Errorf("expected 1 resolver run, got %d", resolverRuns)
+	}
+	if !gaveUp {
+		t.Error("expected an event explaining the early stop")
+	}
+}
+
+// TestRebaseAndMergeSquash verifies that a task with the squash merge
+// strategy lands as a single commit on the default branch with the same
+// combined diff.
+func TestRebaseAndMergeSquash(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupTestRunner(t, []string{repo})
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "Add two files", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetTaskMergeStrategy(ctx, task.ID, store.MergeStrategySquash); err != nil {
+		t.Fatal(err)
+	}
+	worktreePaths, branchName, err := r.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt := worktreePaths[repo]
+	base := gitRun(t, repo, "rev-parse", "HEAD")
+	for _, name := range []string{"one.txt", "two.txt"} {
+		if err := os.WriteFile(filepath.Join(wt, name), []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		gitRun(t, wt, "add", ".")
+		gitRun(t, wt, "commit", "-m", "add "+name)
+	}
+	want := gitRun(t, wt, "diff", base, "HEAD")
+
+	commitHashes := map[string]string{}
+	err = r.rebaseAndMergeOne(ctx, task.ID, repo, wt, branchName, "", ctx,
+		commitHashes, map[string]string{}, newPhaseTimer(task.ID))
+	if err != nil {
+		t.Fatal("rebaseAndMergeOne:", err)
+	}
+	if n := gitRun(t, repo, "rev-list", "--count", base+"..main"); n != "1" {
+		t.Errorf("commits merged into main = %s, want 1", n)
+	}
+	if got := gitRun(t, repo, "diff", base, "main"); got != want {
+		t.Errorf("merged diff differs from the task's combined diff:\n%s\nvs\n%s", got, want)
+	}
+	if commitHashes[repo] != gitRun(t, repo, "rev-parse", "main") {
+		t.Errorf("recorded commit %s is not main's head", commitHashes[repo])
+	}
+}
+
+// TestMergeStrategyFor verifies that a task's own merge strategy wins over
+// the server default, which falls back to fast-forward.
+func TestMergeStrategyFor(t *testing.T) {
+	_, r := setupTestRunner(t, nil)
+	own := &store.Task{MergeStrategy: store.MergeStrategyPullRequest}
+	tests := []struct {
+		name, server string
+		task         *store.Task
+		want         string
+	}{
+		{"no default", "", &store.Task{}, store.MergeStrategyFF},
+		{"server default", store.MergeStrategySquash, &store.Task{}, store.MergeStrategySquash},
+		{"task override", store.MergeStrategySquash, own, store.MergeStrategyPullRequest},
+		{"nil task", store.MergeStrategyMerge, nil, store.MergeStrategyMerge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r.mergeStrategy = tt.server
+			if got := r.mergeStrategyFor(tt.task); got != tt.want {
+				t.Errorf("mergeStrategyFor = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRebaseAndMergeMergeStrategy verifies that the merge strategy, set as
+// the server default, lands the task with a merge commit and records that
+// commit's hash.
+func TestRebaseAndMergeMergeStrategy(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupTestRunner(t, []string{repo})
+	r.mergeStrategy = store.MergeStrategyMerge
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "Add a file", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktreePaths, branchName, err := r.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt := worktreePaths[repo]
+	if err := os.WriteFile(filepath.Join(wt, "new.txt"), []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, wt, "add", ".")
+	gitRun(t, wt, "commit", "-m", "add new.txt")
+	taskHead := gitRun(t, wt, "rev-parse", "HEAD")
+
+	commitHashes := map[string]string{}
+	err = r.rebaseAndMergeOne(ctx, task.ID, repo, wt, branchName, "", ctx,
+		commitHashes, map[string]string{}, newPhaseTimer(task.ID))
+	if err != nil {
+		t.Fatal("rebaseAndMergeOne:", err)
+	}
+	head := gitRun(t, repo, "rev-parse", "main")
+	if head == taskHead {
+		t.Fatal("main was fast-forwarded; want a merge commit")
+	}
+	if second := gitRun(t, repo, "rev-parse", "main^2"); second != taskHead {
+		t.Errorf("merge commit's second parent = %s, want task head %s", second, taskHead)
+	}
+	if commitHashes[repo] != head {
+		t.Errorf("recorded commit %s, want merge commit %s", commitHashes[repo], head)
+	}
+}
+
+// setupConflictingTask creates a task whose worktree and the default branch
+// both change README.md, so rebasing the task conflicts.
+func setupConflictingTask(t *testing.T, r *Runner, s *store.Store, repo string) (uuid.UUID, string, string) {
+	t.Helper()
+	task, err := s.CreateTask(context.Background(), "Edit README", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktreePaths, branchName, err := r.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt := worktreePaths[repo]
+	if err := os.WriteFile(filepath.Join(wt, "README.md"), []byte("task version\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, wt, "add", ".")
+	gitRun(t, wt, "commit", "-m", "task: edit README")
+	if err := os.WriteFile(filepath.Join(repo, "README.md"), []byte("main version\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-m", "main: edit README")
+	return task.ID, wt, branchName
+}
+
+func TestRebaseAndMergeConflictStrategy(t *testing.T) {
+	for _, tc := range []struct {
+		strategy string
+		want     string // README.md on main after the merge
+	}{
+		{RebaseConflictTheirs, "task version\n"},
+		{RebaseConflictOurs, "main version\n"},
+	} {
+		t.Run(tc.strategy, func(t *testing.T) {
+			repo := setupTestRepo(t)
+			s, r := setupTestRunner(t, []string{repo})
+			r.rebaseConflict = tc.strategy
+			ctx := context.Background()
+
+			id, wt, branchName := setupConflictingTask(t, r, s, repo)
+			err := r.rebaseAndMergeOne(ctx, id, repo, wt, branchName, "", ctx,
+				map[string]string{}, map[string]string{}, newPhaseTimer(id))
+			if err != nil {
+				t.Fatal("rebaseAndMergeOne:", err)
+			}
+			if hasEvent(t, s, id, "running resolver") {
+				t.Error("resolver ran although -X settled the conflict")
+			}
+			if got := gitRun(t, repo, "show", "main:README.md"); got+"\n" != tc.want {
+				t.Errorf("README.md on main = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	t.Run(RebaseConflictAbort, func(t *testing.T) {
+		repo := setupTestRepo(t)
+		s, r := setupTestRunner(t, []string{repo})
+		r.rebaseConflict = RebaseConflictAbort
+		ctx := context.Background()
+
+		id, wt, branchName := setupConflictingTask(t, r, s, repo)
+		err := r.rebaseAndMergeOne(ctx, id, repo, wt, branchName, "", ctx,
+			map[string]string{}, map[string]string{}, newPhaseTimer(id))
+		if !isConflictError(err) {
+			t.Fatalf("expected a conflict error, got %v", err)
+		}
+		if hasEvent(t, s, id, "running resolver") {
+			t.Error("resolver ran with the abort strategy")
+		}
+		if got := r.Metrics().RebaseConflicts; got != 1 {
+			t.Errorf("rebase conflicts metric = %d, want 1", got)
+		}
+
+		events, err := s.GetEvents(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var conflict *conflictEvent
+		for _, ev := range events {
+			if ev.EventType == store.EventTypeConflict {
+				conflict = new(conflictEvent)
+				if err := json.Unmarshal(ev.Data, conflict); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if conflict == nil {
+			t.Fatal("no conflict event recorded")
+		}
+		if conflict.Repo != repo || conflict.Target != "main" || !slices.Equal(conflict.Files, []string{"README.md"}) {
+			t.Errorf("unexpected conflict event %+v", conflict)
+		}
+		if conflict.Commit != "task: edit README" || !strings.Contains(conflict.Hunks, "<<<<<<<") {
+			t.Errorf("conflict event lacks commit or hunks: %+v", conflict)
+		}
+	})
+
+	t.Run("no retries", func(t *testing.T) {
+		repo := setupTestRepo(t)
+		s, r := setupTestRunner(t, []string{repo})
+		r.rebaseRetries = 0
+		ctx := context.Background()
+
+		id, wt, branchName := setupConflictingTask(t, r, s, repo)
+		err := r.rebaseAndMergeOne(ctx, id, repo, wt, branchName, "", ctx,
+			map[string]string{}, map[string]string{}, newPhaseTimer(id))
+		if !isConflictError(err) {
+			t.Fatalf("expected a conflict error, got %v", err)
+		}
+		if hasEvent(t, s, id, "running resolver") {
+			t.Error("resolver ran with rebase retries disabled")
+		}
+		if !hasEvent(t, s, id, "rebase retries are disabled") {
+			t.Error("missing event explaining the resolver was skipped")
+		}
+	})
+}
+
+func TestManualConflictResolution(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupTestRunner(t, []string{repo})
+	r.resolveMode = ResolveManual
+	ctx := context.Background()
+
+	id, wt, branchName := setupConflictingTask(t, r, s, repo)
+	if err := s.UpdateTaskWorktrees(ctx, id, map[string]string{repo: wt}, branchName); err != nil {
+		t.Fatal(err)
+	}
+	s.UpdateTaskStatus(ctx, id, "committing")
+
+	if err := r.Commit(id, ""); !errors.Is(err, ErrConflictPaused) {
+		t.Fatalf("expected ErrConflictPaused, got %v", err)
+	}
+	task, _ := s.GetTask(ctx, id)
+	if task.Status != "conflict" || task.ConflictRepo != repo || task.ConflictWorktree != wt {
+		t.Fatalf("unexpected task after pause: status=%s repo=%q worktree=%q", task.Status, task.ConflictRepo, task.ConflictWorktree)
+	}
+	if !gitutil.RebaseInProgress(wt) {
+		t.Fatal("rebase should be left in progress")
+	}
+	if hasEvent(t, s, id, "running resolver") {
+		t.Error("resolver ran in manual mode")
+	}
+
+	var conflict *gitutil.ConflictError
+	if err := r.ContinueRebase(id); !errors.As(err, &conflict) || !slices.Equal(conflict.Files, []string{"README.md"}) {
+		t.Fatalf("continuing an unresolved rebase should report README.md, got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(wt, "README.md"), []byte("resolved\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, wt, "add", "README.md")
+	if err := r.ContinueRebase(id); err != nil {
+		t.Fatal("ContinueRebase:", err)
+	}
+	if task, _ := s.GetTask(ctx, id); task.ConflictWorktree != "" {
+		t.Error("conflict worktree not cleared")
+	}
+	s.UpdateTaskStatus(ctx, id, "committing")
+	if err := r.Commit(id, ""); err != nil {
+		t.Fatal("Commit after continuing:", err)
+	}
+	if got := gitRun(t, repo, "show", "main:README.md"); got != "resolved" {
+		t.Errorf("README.md on main = %q, want the manual resolution", got)
+	}
+	if err := r.ContinueRebase(id); !errors.Is(err, ErrNotInConflict) {
+		t.Errorf("expected ErrNotInConflict, got %v", err)
+	}
+}
+
+func TestRebaseAndMergeRejectsRawLFSContent(t *testing.T) {
+	repo := setupTestRepo(t)
+	if err := os.WriteFile(filepath.Join(repo, ".gitattributes"), []byte("*.bin filter=lfs diff=lfs merge=lfs -text\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-m", "track *.bin with LFS")
+	s, r := setupTestRunner(t, []string{repo})
+	r.lfsMode = LFSOff
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "Add an asset", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktreePaths, branchName, err := r.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt := worktreePaths[repo]
+	// Committed without the LFS clean filter, as when git-lfs is missing.
+	if err := os.WriteFile(filepath.Join(wt, "asset.bin"), []byte("\x00raw"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, wt, "add", ".")
+	gitRun(t, wt, "commit", "-m", "add asset")
+	mainBefore := gitRun(t, repo, "rev-parse", "main")
+
+	err = r.rebaseAndMergeOne(ctx, task.ID, repo, wt, branchName, "", ctx,
+		map[string]string{}, map[string]string{}, newPhaseTimer(task.ID))
+	if err == nil || !strings.Contains(err.Error(), "asset.bin") {
+		t.Fatalf("expected an error naming asset.bin, got %v", err)
+	}
+	if got := gitRun(t, repo, "rev-parse", "main"); got != mainBefore {
+		t.Error("main moved although the task committed raw LFS content")
+	}
+}
@@ -0,0 +1,67 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/google/uuid"
+)
+
+// Commit message modes decide how hostStageAndCommit writes the message of
+// the commits it makes on a task's behalf.
+const (
+	// CommitMessageClaude asks a one-shot sandbox to summarize the change,
+	// falling back to the commit message template when that fails.
+	CommitMessageClaude = "claude"
+	// CommitMessageTemplate renders the commit message template locally.
+	CommitMessageTemplate = "template"
+	// CommitMessagePrompt uses the first line of the task prompt.
+	CommitMessagePrompt = "prompt"
+)
+
+// DefaultCommitMessageTemplate is the commit message template used when no
+// CommitMessageTemplate is configured.
+const DefaultCommitMessageTemplate = "wallfacer: {{.prompt_first_line}}"
+
+// commitMessageFields returns the data a commit message template is
+// executed with: task_id, title, prompt_first_line (truncated to
+// maxCommitSubject) and diff_stat.
+func commitMessageFields(taskID uuid.UUID, title, prompt, diffStat string) map[string]string {
+	firstLine, _, _ := strings.Cut(strings.TrimSpace(prompt), "\n")
+	return map[string]string{
+		"task_id":           taskID.String(),
+		"title":             title,
+		"prompt_first_line": truncate(strings.TrimSpace(firstLine), maxCommitSubject),
+		"diff_stat":         diffStat,
+	}
+}
+
+// renderCommitMessage executes the text/template tmpl (DefaultCommitMessageTemplate
+// when empty) with fields. Surrounding whitespace is trimmed; a template that
+// renders blank is an error.
+func renderCommitMessage(tmpl string, fields map[string]string) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultCommitMessageTemplate
+	}
+	t, err := template.New("commit").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, fields); err != nil {
+		return "", err
+	}
+	msg := strings.TrimSpace(b.String())
+	if msg == "" {
+		return "", fmt.Errorf("commit message template %q renders blank", tmpl)
+	}
+	return msg, nil
+}
+
+// ValidateCommitMessageTemplate reports whether tmpl parses and renders a
+// non-blank message for a sample task.
+func ValidateCommitMessageTemplate(tmpl string) error {
+	_, err := renderCommitMessage(tmpl, commitMessageFields(uuid.New(), "sample title", "sample prompt", "README.md | 1 +"))
+	return err
+}
@@ -0,0 +1,131 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"changkun.de/wallfacer/internal/gitutil"
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// Ways the commit pipeline handles a rebase conflict that the conflict
+// strategy did not settle.
+const (
+	// ResolveAuto follows the conflict strategy: the resolver container,
+	// or failing the task under RebaseConflictAbort.
+	ResolveAuto = "auto"
+	// ResolveManual leaves the rebase stopped in the task worktree and
+	// moves the task to "conflict" until ContinueRebase is called.
+	ResolveManual = "manual"
+)
+
+// ErrConflictPaused is returned by the commit pipeline when it stopped on a
+// rebase conflict under ResolveManual. The task has been moved to
+// "conflict"; the caller must leave its status alone.
+var ErrConflictPaused = errors.New("rebase paused for manual conflict resolution")
+
+// ErrNotInConflict is returned by ContinueRebase and CancelConflict for a
+// task whose status is not "conflict".
+var ErrNotInConflict = errors.New("task is not waiting on a rebase conflict")
+
+// pauseRebase records the conflict that stopped the rebase of repoPath and
+// the worktree it was left in, and returns ErrConflictPaused.
+func (r *Runner) pauseRebase(taskID uuid.UUID, repoPath, worktreePath, target string, rebaseErr error) error {
+	bgCtx := context.Background()
+	r.recordConflict(taskID, repoPath, target, rebaseErr, "waiting for manual resolution")
+	if err := r.store.SetTaskConflict(bgCtx, taskID, repoPath, worktreePath); err != nil {
+		logger.Runner.Warn("save conflict worktree", "task", taskID, "error", err)
+	}
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+		"result": fmt.Sprintf("Rebase of %s onto %s stopped on a conflict. Resolve it in %s, stage the result, then continue the rebase.",
+			repoPath, target, worktreePath),
+	})
+	return fmt.Errorf("%w: %s", ErrConflictPaused, repoPath)
+}
+
+// enterConflict saves what the paused pipeline already merged, so the
+// resumed pipeline keeps it, and moves the task to "conflict".
+func (r *Runner) enterConflict(taskID uuid.UUID, commitHashes, baseHashes map[string]string) {
+	bgCtx := context.Background()
+	if len(commitHashes) > 0 {
+		if err := r.store.UpdateTaskCommitHashes(bgCtx, taskID, commitHashes); err != nil {
+			logger.Runner.Warn("save commit hashes", "task", taskID, "error", err)
+		}
+	}
+	if len(baseHashes) > 0 {
+		if err := r.store.UpdateTaskBaseCommitHashes(bgCtx, taskID, baseHashes); err != nil {
+			logger.Runner.Warn("save base commit hashes", "task", taskID, "error", err)
+		}
+	}
+	from := "committing"
+	if task, err := r.store.GetTask(bgCtx, taskID); err == nil {
+		from = task.Status
+	}
+	r.store.UpdateTaskStatus(bgCtx, taskID, "conflict")
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
+		"from": from, "to": "conflict",
+	})
+}
+
+// carryOverMerged adds the repos a paused pipeline merged before it stopped
+// to the hashes of the resumed one, which skips them as having nothing left
+// to merge.
+func carryOverMerged(task *store.Task, commitHashes, baseHashes map[string]string) {
+	for repoPath, hash := range task.CommitHashes {
+		if _, ok := commitHashes[repoPath]; ok {
+			continue
+		}
+		commitHashes[repoPath] = hash
+		if base, ok := task.BaseCommitHashes[repoPath]; ok {
+			baseHashes[repoPath] = base
+		}
+	}
+}
+
+// ContinueRebase continues the rebase of a task in "conflict" once its
+// conflicts have been resolved and staged in the worktree; a rebase the
+// user already finished by hand is accepted as is. Conflicts that remain
+// are returned as a *gitutil.ConflictError and recorded as a conflict event,
+// leaving the task in "conflict". On success the caller runs the commit
+// pipeline again to merge the task.
+func (r *Runner) ContinueRebase(taskID uuid.UUID) error {
+	bgCtx := context.Background()
+	task, err := r.store.GetTask(bgCtx, taskID)
+	if err != nil {
+		return err
+	}
+	if task.Status != "conflict" {
+		return ErrNotInConflict
+	}
+	if err := gitutil.ContinueRebase(task.ConflictWorktree); err != nil {
+		target := task.TargetBranches[task.ConflictRepo]
+		r.recordConflict(taskID, task.ConflictRepo, target, err, "conflicts remain after continuing")
+		return err
+	}
+	if err := r.store.SetTaskConflict(bgCtx, taskID, "", ""); err != nil {
+		return err
+	}
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+		"result": fmt.Sprintf("Rebase of %s continued; resuming the commit pipeline.", task.ConflictRepo),
+	})
+	return nil
+}
+
+// CancelConflict rolls back a task in "conflict" like a cancelled commit
+// pipeline: the stopped rebase is aborted and repos merged before the
+// conflict are rewound. The caller sets the task's status.
+func (r *Runner) CancelConflict(taskID uuid.UUID) error {
+	bgCtx := context.Background()
+	task, err := r.store.GetTask(bgCtx, taskID)
+	if err != nil {
+		return err
+	}
+	if task.Status != "conflict" {
+		return ErrNotInConflict
+	}
+	r.rollbackCommit(taskID, task.WorktreePaths, task.CommitHashes, task.BaseCommitHashes)
+	return r.store.SetTaskConflict(bgCtx, taskID, "", "")
+}
@@ -3,17 +3,26 @@ package runner
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"changkun.de/wallfacer/internal/envconfig"
+	"changkun.de/wallfacer/internal/instructions"
 	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
 	"github.com/google/uuid"
 )
 
@@ -52,8 +61,8 @@ func (r *Runner) CreateSandbox(ctx context.Context, taskID uuid.UUID, workspaceP
 	exec.Command(r.command, "sandbox", "stop", name).Run()
 	exec.Command(r.command, "sandbox", "rm", name).Run()
 
-	args := []string{"sandbox", "create", "--name", name, "claude"}
-	args = append(args, workspacePaths...)
+	opts := r.taskSandboxOptions(taskID)
+	args := sandboxCreateArgs(name, opts, workspacePaths)
 
 	var lastErr error
 	for attempt := 1; attempt <= 3; attempt++ {
@@ -66,7 +75,12 @@ func (r *Runner) CreateSandbox(ctx context.Context, taskID uuid.UUID, workspaceP
 		cmd := exec.CommandContext(ctx, r.command, args...)
 		out, err := cmd.CombinedOutput()
 		if err == nil {
-			logger.Runner.Info("sandbox created", "name", name, "workspaces", workspacePaths)
+			r.sandboxCmds.Store(taskID, shellJoin(append([]string{r.command}, args...)))
+			logger.Runner.Info("sandbox created", "name", name, "image", opts.image,
+				"memory", opts.memory, "cpus", opts.cpus, "workspaces", workspacePaths)
+			r.store.InsertEvent(context.Background(), taskID, store.EventTypeSystem, map[string]string{
+				"result": "Sandbox created (" + opts.describe() + ").",
+			})
 			return nil
 		}
 		lastErr = fmt.Errorf("create sandbox %s: %w (output: %s)", name, err, strings.TrimSpace(string(out)))
@@ -75,6 +89,73 @@ func (r *Runner) CreateSandbox(ctx context.Context, taskID uuid.UUID, workspaceP
 	return lastErr
 }
 
+// sandboxOptions are the settings a sandbox is created with. Empty fields
+// keep the docker sandbox defaults.
+type sandboxOptions struct {
+	image   string
+	memory  string   // --memory, e.g. "4g"
+	cpus    string   // --cpus, e.g. "1.5"
+	network string   // --network; NetworkHost and empty keep the default
+	refs    []string // read-only reference directories
+}
+
+// describe renders the options for the sandbox-created event.
+func (o sandboxOptions) describe() string {
+	orDefault := func(v, def string) string {
+		if v == "" {
+			return def
+		}
+		return v
+	}
+	return fmt.Sprintf("image %s, memory limit %s, CPU limit %s, network %s",
+		orDefault(o.image, "default"), orDefault(o.memory, "none"), orDefault(o.cpus, "none"),
+		orDefault(o.network, NetworkHost))
+}
+
+// sandboxCreateArgs builds the docker sandbox create arguments for a claude
+// sandbox named name over the given workspaces.
+func sandboxCreateArgs(name string, opts sandboxOptions, workspacePaths []string) []string {
+	args := []string{"sandbox", "create", "--name", name}
+	if opts.image != "" {
+		args = append(args, "--template", opts.image)
+	}
+	if opts.memory != "" {
+		args = append(args, "--memory", opts.memory)
+	}
+	if opts.cpus != "" {
+		args = append(args, "--cpus", opts.cpus)
+	}
+	if opts.network != "" && opts.network != NetworkHost {
+		args = append(args, "--network", opts.network)
+	}
+	args = append(args, "claude")
+	args = append(args, workspacePaths...)
+	for _, ref := range opts.refs {
+		args = append(args, ref+":ro")
+	}
+	return args
+}
+
+// memoryLimitPattern matches docker's --memory values: a number with an
+// optional b, k, m, or g unit.
+var memoryLimitPattern = regexp.MustCompile(`^(?i)[0-9]+(\.[0-9]+)?[bkmg]?$`)
+
+// ValidMemoryLimit reports whether v is a valid sandbox memory limit. Empty
+// means no limit.
+func ValidMemoryLimit(v string) bool {
+	return v == "" || memoryLimitPattern.MatchString(v)
+}
+
+// ValidCPULimit reports whether v is a valid sandbox CPU limit: a positive
+// number of CPUs. Empty means no limit.
+func ValidCPULimit(v string) bool {
+	if v == "" {
+		return true
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	return err == nil && n > 0
+}
+
 // StopSandbox stops a sandbox without removing it (preserves session).
 func (r *Runner) StopSandbox(taskID uuid.UUID) {
 	name := sandboxName(taskID)
@@ -86,6 +167,72 @@ func (r *Runner) RemoveSandbox(taskID uuid.UUID) {
 	name := sandboxName(taskID)
 	exec.Command(r.command, "sandbox", "stop", name).Run()
 	exec.Command(r.command, "sandbox", "rm", name).Run()
+	r.sandboxCmds.Delete(taskID)
+}
+
+// containerExitError reports that a sandbox exec ended with a non-zero exit
+// status, distinguishing a plain failure from termination by a signal (e.g.
+// an OOM kill surfaces as exit 137 / signal 9).
+type containerExitError struct {
+	ExitCode int
+	Signal   syscall.Signal // zero when the process was not signalled
+	detail   string
+}
+
+func (e *containerExitError) Error() string {
+	msg := fmt.Sprintf("container exited with code %d", e.ExitCode)
+	if e.Signal != 0 {
+		msg += fmt.Sprintf(" (signal %d: %s)", int(e.Signal), e.Signal)
+	}
+	if e.detail != "" {
+		msg += ": " + e.detail
+	}
+	return msg
+}
+
+// eventData returns the exit status as structured fields for a task event.
+func (e *containerExitError) eventData() map[string]string {
+	data := map[string]string{"exit_code": fmt.Sprint(e.ExitCode)}
+	if e.Signal != 0 {
+		data["signal"] = fmt.Sprint(int(e.Signal))
+	}
+	return data
+}
+
+// exitContainerStart is the exit status the docker CLI reports when it
+// failed itself, for example because the daemon could not start the exec
+// session, before anything ran in the sandbox.
+const exitContainerStart = 125
+
+// containerStartError reports that the container runtime failed to start
+// Claude Code, as opposed to Claude Code running and failing.
+type containerStartError struct {
+	err error
+}
+
+func (e *containerStartError) Error() string { return "failed to start container: " + e.err.Error() }
+func (e *containerStartError) Unwrap() error { return e.err }
+
+// newContainerExitError extracts the exit code and terminating signal from
+// an *exec.ExitError. Container runtimes report a killed child as 128+signal,
+// so that convention is decoded too when the CLI itself exited normally.
+// Returns nil if err is not an exit error.
+func newContainerExitError(err error, detail string) *containerExitError {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return nil
+	}
+	e := &containerExitError{ExitCode: exitErr.ExitCode(), detail: detail}
+	if ws, ok := exitErr.Sys().(interface {
+		Signaled() bool
+		Signal() syscall.Signal
+	}); ok && ws.Signaled() {
+		e.Signal = ws.Signal()
+		e.ExitCode = 128 + int(e.Signal)
+	} else if e.ExitCode > 128 && e.ExitCode < 128+65 {
+		e.Signal = syscall.Signal(e.ExitCode - 128)
+	}
+	return e
 }
 
 // execInSandbox runs Claude Code in an existing sandbox and parses its NDJSON output.
@@ -101,11 +248,15 @@ func (r *Runner) execInSandbox(
 	if r.envFile != "" {
 		args = append(args, "--env-file", r.envFile)
 	}
+	env := r.taskEnv(taskID)
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		args = append(args, "-e", k+"="+env[k])
+	}
 	if workdir != "" {
 		args = append(args, "-w", workdir)
 	}
 	args = append(args, name, "claude", "-p", prompt, "--verbose", "--output-format", "stream-json", "--dangerously-skip-permissions")
-	if model := r.modelFromEnv(); model != "" {
+	if model := r.taskModel(taskID); model != "" {
 		args = append(args, "--model", model)
 	}
 	if sessionID != "" {
@@ -130,7 +281,9 @@ func (r *Runner) execInSandbox(
 		cmd.Stderr = &stderr
 	}
 
-	logger.Runner.Debug("exec sandbox", "cmd", r.command, "args", strings.Join(args, " "))
+	logger.Runner.Debug("exec sandbox", "cmd", r.command, "args", strings.Join(redactEnvArgs(args), " "))
+	r.recordContainerStart(taskID, args, prompt)
+	r.metrics.containersRun.Add(1)
 	runErr := cmd.Run()
 
 	// Clean up the live log after execution is done.
@@ -140,17 +293,23 @@ func (r *Runner) execInSandbox(
 	}
 
 	if ctx.Err() != nil {
+		if exitErr := newContainerExitError(runErr, ""); exitErr != nil {
+			return nil, stdout.Bytes(), stderr.Bytes(), fmt.Errorf("container terminated: %w: %w", ctx.Err(), exitErr)
+		}
 		return nil, stdout.Bytes(), stderr.Bytes(), fmt.Errorf("container terminated: %w", ctx.Err())
 	}
 
 	raw := strings.TrimSpace(stdout.String())
 	if raw == "" {
 		if runErr != nil {
-			if exitErr, ok := runErr.(*exec.ExitError); ok {
-				return nil, stdout.Bytes(), stderr.Bytes(),
-					fmt.Errorf("container exited with code %d: stderr=%s", exitErr.ExitCode(), stderr.String())
+			if exitErr := newContainerExitError(runErr, "stderr="+stderr.String()); exitErr != nil {
+				if exitErr.ExitCode == exitContainerStart {
+					return nil, stdout.Bytes(), stderr.Bytes(), &containerStartError{exitErr}
+				}
+				return nil, stdout.Bytes(), stderr.Bytes(), exitErr
 			}
-			return nil, stdout.Bytes(), stderr.Bytes(), fmt.Errorf("exec container: %w", runErr)
+			// The container command itself could not be run.
+			return nil, stdout.Bytes(), stderr.Bytes(), &containerStartError{fmt.Errorf("exec container: %w", runErr)}
 		}
 		stderrStr := strings.TrimSpace(stderr.String())
 		if stderrStr != "" {
@@ -163,10 +322,9 @@ func (r *Runner) execInSandbox(
 	output, parseErr := parseOutput(raw)
 	if parseErr != nil {
 		if runErr != nil {
-			if exitErr, ok := runErr.(*exec.ExitError); ok {
-				return nil, stdout.Bytes(), stderr.Bytes(),
-					fmt.Errorf("container exited with code %d: stderr=%s stdout=%s",
-						exitErr.ExitCode(), stderr.String(), truncate(raw, 500))
+			if exitErr := newContainerExitError(runErr,
+				fmt.Sprintf("stderr=%s stdout=%s", stderr.String(), truncate(raw, 500))); exitErr != nil {
+				return nil, stdout.Bytes(), stderr.Bytes(), exitErr
 			}
 			return nil, stdout.Bytes(), stderr.Bytes(), fmt.Errorf("exec container: %w", runErr)
 		}
@@ -175,9 +333,9 @@ func (r *Runner) execInSandbox(
 	}
 
 	if runErr != nil {
-		if exitErr, ok := runErr.(*exec.ExitError); ok {
+		if exitErr := newContainerExitError(runErr, ""); exitErr != nil {
 			logger.Runner.Warn("sandbox exited non-zero but produced valid output",
-				"task", taskID, "code", exitErr.ExitCode())
+				"task", taskID, "code", exitErr.ExitCode, "signal", int(exitErr.Signal))
 		} else {
 			logger.Runner.Warn("sandbox error but produced valid output", "task", taskID, "error", runErr)
 		}
@@ -186,9 +344,77 @@ func (r *Runner) execInSandbox(
 	return output, stdout.Bytes(), stderr.Bytes(), nil
 }
 
+// recordContainerStart records the sandbox exec invocation with args as a
+// container_start event. The prompt, which can be long and is the same for
+// many turns, is saved once to a file named after its hash and referenced
+// as $(cat file); when the file cannot be written it stays inline.
+func (r *Runner) recordContainerStart(taskID uuid.UUID, args []string, prompt string) {
+	argv := append([]string{r.command}, redactEnvArgs(args)...)
+	ev := store.ContainerStart{Command: shellJoin(argv), Args: argv}
+	if i := slices.Index(argv, "-p"); i >= 0 && i+1 < len(argv) && argv[i+1] == prompt {
+		if name, path, err := r.savePromptFile(taskID, prompt); err != nil {
+			logger.Runner.Warn("save prompt file", "task", taskID, "error", err)
+		} else {
+			ref := `"$(cat ` + shellJoin([]string{path}) + `)"`
+			argv[i+1] = ref
+			ev.Command = shellJoin(argv[:i+1]) + " " + ref + " " + shellJoin(argv[i+2:])
+			ev.PromptFile = name
+		}
+	}
+	if create, ok := r.sandboxCmds.Load(taskID); ok {
+		ev.Sandbox = create.(string)
+	}
+	r.store.InsertEvent(context.Background(), taskID, store.EventTypeContainerStart, ev)
+}
+
+// savePromptFile writes prompt to prompt-<hash>.txt in the task's outputs
+// unless a turn already saved the same prompt, and returns the file's name
+// and path.
+func (r *Runner) savePromptFile(taskID uuid.UUID, prompt string) (name, path string, err error) {
+	sum := sha256.Sum256([]byte(prompt))
+	name = fmt.Sprintf("prompt-%x.txt", sum[:6])
+	dir := r.store.OutputsDir(taskID)
+	path = filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return name, path, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(path, []byte(prompt), 0600); err != nil {
+		return "", "", err
+	}
+	return name, path, nil
+}
+
+// shellJoin quotes args for a POSIX shell and joins them with spaces.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a != "" && strings.Trim(a, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-+=@%:,./") == "" {
+			quoted[i] = a
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// containerStartAttempts bounds how often runContainer tries a turn whose
+// container failed to start.
+const containerStartAttempts = 4
+
+// containerStartBackoff is the wait before the first retry of a container
+// that failed to start; it doubles after each retry. A variable so tests can
+// shorten it.
+var containerStartBackoff = 2 * time.Second
+
 // runContainer executes Claude Code in a sandbox and parses its NDJSON output.
 // This is the main entry point called by the turn loop in execute.go.
-// The sandbox must already exist (created by CreateSandbox).
+// The sandbox must already exist (created by CreateSandbox). A container
+// that fails to start, which the container runtime sometimes does
+// transiently, is retried with exponential backoff, each retry recorded as
+// an event; once Claude Code has run its result is returned as is.
 func (r *Runner) runContainer(
 	ctx context.Context,
 	taskID uuid.UUID,
@@ -204,7 +430,50 @@ func (r *Runner) runContainer(
 			workdir = wt
 		}
 	}
-	return r.execInSandbox(ctx, taskID, prompt, sessionID, workdir)
+	prompt = r.WrapPrompt(prompt)
+	wait := containerStartBackoff
+	for attempt := 1; ; attempt++ {
+		output, stdout, stderr, err := r.execInSandbox(ctx, taskID, prompt, sessionID, workdir)
+		var startErr *containerStartError
+		if !errors.As(err, &startErr) {
+			return output, stdout, stderr, err
+		}
+		if attempt == containerStartAttempts {
+			return output, stdout, stderr, fmt.Errorf("%w (gave up after %d attempts)", err, attempt)
+		}
+		logger.Runner.Warn("container failed to start, retrying", "task", taskID,
+			"attempt", attempt, "wait", wait, "error", err)
+		r.store.InsertEvent(context.Background(), taskID, store.EventTypeSystem, map[string]string{
+			"result": fmt.Sprintf("Container failed to start (attempt %d of %d): %v. Retrying in %s...",
+				attempt, containerStartAttempts, err, wait),
+		})
+		select {
+		case <-ctx.Done():
+			return output, stdout, stderr, err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// WrapPrompt surrounds prompt with the configured prefix and suffix, and
+// points out the read-only reference directories. Empty prompts
+// (auto-continue turns) are passed through unchanged.
+func (r *Runner) WrapPrompt(prompt string) string {
+	if prompt == "" {
+		return prompt
+	}
+	if len(r.refMounts) > 0 {
+		prompt = "Read-only reference directories (read them for context; they cannot be modified): " +
+			strings.Join(r.refMounts, ", ") + "\n\n" + prompt
+	}
+	if p := strings.TrimSpace(r.promptPrefix); p != "" {
+		prompt = p + "\n\n" + prompt
+	}
+	if s := strings.TrimSpace(r.promptSuffix); s != "" {
+		prompt = prompt + "\n\n" + s
+	}
+	return prompt
 }
 
 // runOneShotSandbox creates a temporary sandbox, runs a Claude command, and removes it.
@@ -214,10 +483,13 @@ func (r *Runner) runOneShotSandbox(ctx context.Context, name, prompt string, wor
 	exec.Command(r.command, "sandbox", "rm", name).Run()
 
 	// Create sandbox.
-	createArgs := []string{"sandbox", "create", "--name", name, "claude"}
-	if len(workspacePaths) > 0 {
-		createArgs = append(createArgs, workspacePaths...)
-	} else {
+	createArgs := sandboxCreateArgs(name, sandboxOptions{
+		image:   r.sandboxImage,
+		memory:  r.memoryLimit,
+		cpus:    r.cpuLimit,
+		network: r.networkMode,
+	}, workspacePaths)
+	if len(workspacePaths) == 0 {
 		// Need at least one workspace; use a temp directory.
 		tmpDir, err := os.MkdirTemp("", "wallfacer-oneshot-*")
 		if err != nil {
@@ -251,6 +523,7 @@ func (r *Runner) runOneShotSandbox(ctx context.Context, name, prompt string, wor
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
+	r.metrics.containersRun.Add(1)
 	runErr := cmd.Run()
 	if ctx.Err() != nil {
 		return nil, fmt.Errorf("oneshot sandbox terminated: %w", ctx.Err())
@@ -337,15 +610,47 @@ func (r *Runner) ListContainers() ([]ContainerInfo, error) {
 	return result, nil
 }
 
-// copyInstructionsToWorktrees copies the workspace CLAUDE.md into each
-// worktree root so Claude Code can discover it. Docker sandbox doesn't
-// support arbitrary volume mounts, so we copy the file instead.
-func copyInstructionsToWorktrees(instructionsPath string, worktreePaths map[string]string) {
-	if instructionsPath == "" {
-		return
+// ErrTasksActive is returned by ResetClaudeConfig while a task still uses
+// its sandbox.
+var ErrTasksActive = errors.New("tasks are still active")
+
+// ResetClaudeConfig removes every wallfacer sandbox, discarding the Claude
+// Code sessions and caches kept inside them, and returns how many it
+// removed. Tasks get a fresh sandbox on their next run. It refuses with an
+// error wrapping ErrTasksActive while any task runs, is queued, commits, or
+// waits for feedback, since those still use their sandbox.
+func (r *Runner) ResetClaudeConfig() (int, error) {
+	tasks, err := r.store.ListTasks(context.Background(), false)
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range tasks {
+		switch t.Status {
+		case "in_progress", "queued", "committing", "waiting":
+			return 0, fmt.Errorf("task %s is %s: %w", t.ID, t.Status, ErrTasksActive)
+		}
 	}
-	content, err := os.ReadFile(instructionsPath)
+	sandboxes, err := r.ListSandboxes()
 	if err != nil {
+		return 0, err
+	}
+	for _, s := range sandboxes {
+		exec.Command(r.command, "sandbox", "stop", s.Name).Run()
+		if out, err := exec.Command(r.command, "sandbox", "rm", s.Name).CombinedOutput(); err != nil {
+			return 0, fmt.Errorf("remove sandbox %s: %w (output: %s)", s.Name, err, strings.TrimSpace(string(out)))
+		}
+	}
+	logger.Runner.Info("reset claude config", "sandboxes", len(sandboxes))
+	return len(sandboxes), nil
+}
+
+// copyInstructionsToWorktrees writes the global instructions followed by the
+// workspace CLAUDE.md into each worktree root so Claude Code can discover it.
+// Docker sandbox doesn't support arbitrary volume mounts, so we copy the file
+// instead.
+func copyInstructionsToWorktrees(globalPath, instructionsPath string, worktreePaths map[string]string) {
+	content := instructions.Combine(globalPath, instructionsPath)
+	if content == nil {
 		return
 	}
 	for _, wt := range worktreePaths {
@@ -371,6 +676,65 @@ func (r *Runner) modelFromEnv() string {
 	return cfg.Model
 }
 
+// taskModel returns the model to use for a task's turns: the task's own
+// override when set, otherwise the env-file default.
+func (r *Runner) taskModel(taskID uuid.UUID) string {
+	if task, err := r.store.GetTask(context.Background(), taskID); err == nil && task.Model != "" {
+		return task.Model
+	}
+	return r.modelFromEnv()
+}
+
+// taskEnv returns the extra environment variables of a task.
+func (r *Runner) taskEnv(taskID uuid.UUID) map[string]string {
+	if task, err := r.store.GetTask(context.Background(), taskID); err == nil {
+		return task.Env
+	}
+	return nil
+}
+
+// redactEnvArgs returns a copy of args with the value of every -e KEY=VALUE
+// pair masked, for logging.
+func redactEnvArgs(args []string) []string {
+	out := slices.Clone(args)
+	for i := 1; i < len(out); i++ {
+		if out[i-1] != "-e" {
+			continue
+		}
+		if k, _, ok := strings.Cut(out[i], "="); ok {
+			out[i] = k + "=***"
+		}
+	}
+	return out
+}
+
+// taskSandboxOptions returns the image, resource limits and network for a
+// task's sandbox: the task's own image and limits where set, otherwise the
+// global defaults.
+func (r *Runner) taskSandboxOptions(taskID uuid.UUID) sandboxOptions {
+	opts := sandboxOptions{
+		image:   r.sandboxImage,
+		memory:  r.memoryLimit,
+		cpus:    r.cpuLimit,
+		network: r.networkMode,
+		refs:    r.refMounts,
+	}
+	task, err := r.store.GetTask(context.Background(), taskID)
+	if err != nil {
+		return opts
+	}
+	if task.SandboxImage != "" {
+		opts.image = task.SandboxImage
+	}
+	if task.MemoryLimit != "" {
+		opts.memory = task.MemoryLimit
+	}
+	if task.CPULimit != "" {
+		opts.cpus = task.CPULimit
+	}
+	return opts
+}
+
 // parseOutput tries to parse raw as a single JSON object first; if that fails
 // it scans backwards through NDJSON lines looking for the last valid object.
 func parseOutput(raw string) (*claudeOutput, error) {
@@ -0,0 +1,180 @@
+package runner
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// Defaults for auto-extending task deadlines when the runner config leaves
+// them unset.
+const (
+	defaultAutoExtendStep = 15 * time.Minute
+	defaultAutoExtendMax  = 4 * time.Hour
+)
+
+// taskDeadline is a context that ends when the task's time budget runs out,
+// like one from context.WithTimeout, except that the deadline can be pushed
+// back while it has not yet passed.
+type taskDeadline struct {
+	context.Context
+	cancel context.CancelCauseFunc
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	start    time.Time
+	deadline time.Time
+}
+
+func newTaskDeadline(parent context.Context, timeout time.Duration) *taskDeadline {
+	ctx, cancel := context.WithCancelCause(parent)
+	now := time.Now()
+	d := &taskDeadline{Context: ctx, cancel: cancel, start: now, deadline: now.Add(timeout)}
+	d.timer = time.AfterFunc(timeout, func() { cancel(context.DeadlineExceeded) })
+	return d
+}
+
+// Deadline reports the current, possibly extended, deadline.
+func (d *taskDeadline) Deadline() (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.deadline, true
+}
+
+// Err reports context.DeadlineExceeded once the budget has run out, matching
+// context.WithTimeout so callers see the same errors as before.
+func (d *taskDeadline) Err() error {
+	if err := d.Context.Err(); err != nil {
+		if cause := context.Cause(d.Context); cause == context.DeadlineExceeded {
+			return cause
+		}
+		return err
+	}
+	return nil
+}
+
+// stop releases the timer and cancels the context.
+func (d *taskDeadline) stop() {
+	d.timer.Stop()
+	d.cancel(context.Canceled)
+}
+
+// remaining returns the time left before the deadline.
+func (d *taskDeadline) remaining() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return time.Until(d.deadline)
+}
+
+// extend pushes the deadline back by step, never past start+max, and
+// returns how much was added. It adds nothing once the deadline has passed.
+func (d *taskDeadline) extend(step, max time.Duration) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	next := d.deadline.Add(step)
+	if limit := d.start.Add(max); next.After(limit) {
+		next = limit
+	}
+	added := next.Sub(d.deadline)
+	if added <= 0 || !d.timer.Stop() {
+		return 0
+	}
+	d.deadline = next
+	d.timer.Reset(time.Until(next))
+	return added
+}
+
+// postpone pushes the deadline and the auto-extension limit back by d, for
+// time the task spent held rather than working. It does nothing once the
+// deadline has passed.
+func (d *taskDeadline) postpone(by time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if by <= 0 || !d.timer.Stop() {
+		return
+	}
+	d.start = d.start.Add(by)
+	d.deadline = d.deadline.Add(by)
+	d.timer.Reset(time.Until(d.deadline))
+}
+
+// autoExtendLimits returns the configured extension step and hard maximum
+// budget, falling back to the defaults when unset.
+func (r *Runner) autoExtendLimits() (step, max time.Duration) {
+	step, max = r.autoExtendStep, r.autoExtendMax
+	if step <= 0 {
+		step = defaultAutoExtendStep
+	}
+	if max <= 0 {
+		max = defaultAutoExtendMax
+	}
+	return step, max
+}
+
+// maybeExtendDeadline extends an auto-extending task's deadline after a turn
+// that changed its worktrees (new commits or file changes) when less than
+// one extension step is left. A turn without progress leaves the deadline
+// as it is. Each extension is recorded as a system event.
+func (r *Runner) maybeExtendDeadline(taskID uuid.UUID, turn int, deadline *taskDeadline, before, after string) {
+	step, max := r.autoExtendLimits()
+	left := deadline.remaining()
+	if left >= step || before == after {
+		return
+	}
+	added := deadline.extend(step, max)
+	if added <= 0 {
+		return
+	}
+	logger.Runner.Info("extended task deadline", "task", taskID, "turn", turn, "added", added)
+	r.store.InsertEvent(context.Background(), taskID, store.EventTypeSystem, map[string]string{
+		"result": fmt.Sprintf("Turn %d made progress with %s left; extended the task deadline by %s (hard limit %s from start).",
+			turn, left.Round(time.Second), added.Round(time.Second), max),
+	})
+}
+
+// worktreeProgress returns a fingerprint of each worktree's HEAD and
+// uncommitted changes. Two fingerprints differ when a turn committed or
+// changed files in between.
+func worktreeProgress(worktreePaths map[string]string) string {
+	repos := make([]string, 0, len(worktreePaths))
+	for repo := range worktreePaths {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	var b strings.Builder
+	for _, repo := range repos {
+		wt := worktreePaths[repo]
+		head, _ := exec.Command("git", "-C", wt, "rev-parse", "HEAD").Output()
+		status, _ := exec.Command("git", "-C", wt, "status", "--porcelain").Output()
+		diff, _ := exec.Command("git", "-C", wt, "diff", "HEAD").Output()
+		fmt.Fprintf(&b, "%s\n%s\n%s\n%x\n", repo, head, status, sha256.Sum256(diff))
+	}
+	return b.String()
+}
+
+// taskTurnTimeout returns the bound on a single turn of task: its own
+// TurnTimeout when set, else the server's. Zero means no per-turn bound.
+func (r *Runner) taskTurnTimeout(task *store.Task) time.Duration {
+	if task.TurnTimeout > 0 {
+		return time.Duration(task.TurnTimeout) * time.Minute
+	}
+	return r.turnTimeout
+}
+
+// turnContext derives the context for one turn from the task deadline,
+// bounded by the per-turn timeout when there is one.
+func turnContext(ctx context.Context, turnTimeout time.Duration) (context.Context, context.CancelFunc) {
+	if turnTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, turnTimeout)
+}
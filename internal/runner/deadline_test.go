@@ -0,0 +1,261 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// TestTaskDeadlineExpires verifies that the deadline context ends with
+// context.DeadlineExceeded like context.WithTimeout.
+func TestTaskDeadlineExpires(t *testing.T) {
+	d := newTaskDeadline(context.Background(), 20*time.Millisecond)
+	defer d.stop()
+	select {
+	case <-d.Done():
+	case <-time.After(time.Second):
+		t.Fatal("deadline did not fire")
+	}
+	if !errors.Is(d.Err(), context.DeadlineExceeded) {
+		t.Fatalf("Err() = %v, want DeadlineExceeded", d.Err())
+	}
+	if added := d.extend(time.Minute, time.Hour); added != 0 {
+		t.Fatalf("extend after expiry added %s", added)
+	}
+}
+
+// TestTaskDeadlineExtendBounded verifies that extensions move the deadline
+// but never past the hard maximum.
+func TestTaskDeadlineExtendBounded(t *testing.T) {
+	d := newTaskDeadline(context.Background(), 50*time.Millisecond)
+	defer d.stop()
+	before, _ := d.Deadline()
+	if added := d.extend(time.Second, 300*time.Millisecond); added <= 0 || added > 300*time.Millisecond {
+		t.Fatalf("extend added %s, want (0, 300ms]", added)
+	}
+	after, _ := d.Deadline()
+	if !after.After(before) {
+		t.Fatal("deadline did not move")
+	}
+	if added := d.extend(time.Second, 300*time.Millisecond); added != 0 {
+		t.Fatalf("extend past the hard max added %s", added)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if d.Err() != nil {
+		t.Fatal("extended deadline fired at the original time")
+	}
+}
+
+// TestMaybeExtendDeadline verifies that only a turn that changed the
+// worktree extends a deadline that is about to pass, and that the extension
+// is recorded as an event.
+func TestMaybeExtendDeadline(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupTestRunner(t, []string{repo})
+	r.autoExtendStep = time.Minute
+	r.autoExtendMax = time.Hour
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	paths := map[string]string{repo: repo}
+
+	d := newTaskDeadline(context.Background(), 10*time.Second)
+	defer d.stop()
+
+	same := worktreeProgress(paths)
+	r.maybeExtendDeadline(task.ID, 1, d, same, worktreeProgress(paths))
+	if d.remaining() > 10*time.Second {
+		t.Fatal("a turn without progress must not extend the deadline")
+	}
+
+	os.WriteFile(filepath.Join(repo, "new.txt"), []byte("work\n"), 0644)
+	r.maybeExtendDeadline(task.ID, 2, d, same, worktreeProgress(paths))
+	if d.remaining() <= 10*time.Second {
+		t.Fatal("a productive turn near the deadline should extend it")
+	}
+
+	events, _ := s.GetEvents(bg(), task.ID)
+	found := false
+	for _, e := range events {
+		if e.EventType == store.EventTypeSystem && strings.Contains(string(e.Data), "extended the task deadline") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected an extension event")
+	}
+}
+
+// fakeHangingCmd returns a container command whose first hangs sandbox
+// execs never finish and whose later execs print output.
+func fakeHangingCmd(t *testing.T, hangs int, output string) string {
+	t.Helper()
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "counter")
+	outPath := filepath.Join(dir, "out.txt")
+	if err := os.WriteFile(outPath, []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+if [ "$1" != sandbox ] || [ "$2" != exec ]; then exit 0; fi
+count=$(cat %[1]s 2>/dev/null || echo 0)
+echo $((count+1)) > %[1]s
+if [ "$count" -lt %[2]d ]; then exec sleep 30; fi
+cat %[3]s
+`, counter, hangs, outPath)
+	path := filepath.Join(dir, "fake-hang")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestRunRetriesTimedOutTurn verifies that a turn hanging past the per-turn
+// timeout is killed and retried once instead of using up the task timeout.
+func TestRunRetriesTimedOutTurn(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupRunnerWithCmd(t, []string{repo}, fakeHangingCmd(t, 1, endTurnOutput))
+	r.turnTimeout = 200 * time.Millisecond
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "hangs once", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Run(task.ID, task.Prompt, "", false)
+
+	got, _ := s.GetTask(ctx, task.ID)
+	if got.Status != "done" {
+		t.Fatalf("status = %q, want done after retrying the hung turn", got.Status)
+	}
+	events, _ := s.GetEvents(ctx, task.ID)
+	retried := false
+	for _, e := range events {
+		if strings.Contains(string(e.Data), "exceeded the per-turn timeout") {
+			retried = true
+		}
+	}
+	if !retried {
+		t.Error("no event recorded for the timed-out turn")
+	}
+}
+
+// TestRunFailsAfterRepeatedTurnTimeout verifies that a turn timing out again
+// after its retry fails the task, and that a task's own TurnTimeout
+// overrides the server setting.
+func TestRunFailsAfterRepeatedTurnTimeout(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupRunnerWithCmd(t, []string{repo}, fakeHangingCmd(t, 2, endTurnOutput))
+	r.turnTimeout = time.Hour
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "hangs twice", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.taskTurnTimeout(task); got != time.Hour {
+		t.Fatalf("taskTurnTimeout = %v, want the server's 1h", got)
+	}
+	r.turnTimeout = 200 * time.Millisecond
+	start := time.Now()
+	r.Run(task.ID, task.Prompt, "", false)
+
+	got, _ := s.GetTask(ctx, task.ID)
+	if got.Status != "failed" {
+		t.Fatalf("status = %q, want failed", got.Status)
+	}
+	if got.Result == nil {
+		t.Error("no result recorded")
+	} else if !strings.Contains(*got.Result, "per-turn timeout") {
+		t.Errorf("result = %q, want a per-turn timeout error", *got.Result)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("run took %v; hung turns were not killed", d)
+	}
+
+	if err := s.SetTaskTurnTimeout(ctx, task.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+	task, _ = s.GetTask(ctx, task.ID)
+	if got := r.taskTurnTimeout(task); got != 3*time.Minute {
+		t.Errorf("taskTurnTimeout = %v, want the task's 3m", got)
+	}
+}
+
+// TestReapStepFailsOverdueTasks verifies that the deadline reaper fails
+// unfinished tasks past their deadline, whatever status they wait in, and
+// leaves finished tasks and future deadlines alone.
+func TestReapStepFailsOverdueTasks(t *testing.T) {
+	s, r := setupTestRunner(t, nil)
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+
+	overdue, _ := s.CreateTask(bg(), "overdue backlog", 5, false)
+	s.SetTaskDeadline(bg(), overdue.ID, &past)
+	waiting, _ := s.CreateTask(bg(), "overdue waiting", 5, false)
+	s.SetTaskDeadline(bg(), waiting.ID, &past)
+	s.UpdateTaskStatus(bg(), waiting.ID, "waiting")
+	s.UpdateTaskResult(bg(), waiting.ID, "partial", "sess1", "", 2)
+	done, _ := s.CreateTask(bg(), "done", 5, false)
+	s.SetTaskDeadline(bg(), done.ID, &past)
+	s.UpdateTaskStatus(bg(), done.ID, "done")
+	later, _ := s.CreateTask(bg(), "later", 5, false)
+	s.SetTaskDeadline(bg(), later.ID, &future)
+
+	r.reapStep(now)
+
+	for _, id := range []uuid.UUID{overdue.ID, waiting.ID} {
+		got, _ := s.GetTask(bg(), id)
+		if got.Status != "failed" || got.StopReason == nil || *got.StopReason != StopReasonDeadlineExceeded {
+			t.Errorf("%q: status %q, stop_reason %v; want failed, %s", got.Prompt, got.Status, got.StopReason, StopReasonDeadlineExceeded)
+		}
+		if !hasEvent(t, s, id, "did not finish by its deadline") {
+			t.Errorf("%q: no deadline error event", got.Prompt)
+		}
+	}
+	if got, _ := s.GetTask(bg(), waiting.ID); *got.Result != "partial" || *got.SessionID != "sess1" || got.Turns != 2 {
+		t.Errorf("waiting task lost its result or session: %+v", got)
+	}
+	if got, _ := s.GetTask(bg(), done.ID); got.Status != "done" {
+		t.Errorf("done task moved to %q", got.Status)
+	}
+	if got, _ := s.GetTask(bg(), later.ID); got.Status != "backlog" {
+		t.Errorf("task with a future deadline moved to %q", got.Status)
+	}
+	if !r.statusTaken(overdue.ID) {
+		t.Error("statusTaken = false for a task failed past its deadline")
+	}
+
+	s.ResetTaskForRetry(bg(), overdue.ID, "retry", false)
+	if got, _ := s.GetTask(bg(), overdue.ID); got.Deadline != nil {
+		t.Errorf("retry kept the passed deadline %v", got.Deadline)
+	}
+}
+
+// TestFailPastDeadlineRereadsTask verifies that a task that finished after
+// the reaper listed it keeps its outcome.
+func TestFailPastDeadlineRereadsTask(t *testing.T) {
+	s, r := setupTestRunner(t, nil)
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	s.SetTaskDeadline(bg(), task.ID, &past)
+	s.UpdateTaskStatus(bg(), task.ID, "waiting")
+	stale, _ := s.GetTask(bg(), task.ID)
+
+	s.UpdateTaskStatus(bg(), task.ID, "done")
+	r.failPastDeadline(stale.ID, now)
+
+	if got, _ := s.GetTask(bg(), task.ID); got.Status != "done" || got.StopReason != nil {
+		t.Fatalf("status %q, stop_reason %v; want done without a stop reason", got.Status, got.StopReason)
+	}
+	if hasEvent(t, s, task.ID, "did not finish by its deadline") {
+		t.Error("deadline error recorded for a task that finished")
+	}
+}
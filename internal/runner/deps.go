@@ -0,0 +1,44 @@
+package runner
+
+import (
+	"context"
+
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// startReadyDependents starts the backlog tasks that depend on doneID and
+// have no other unfinished dependencies. It does nothing unless
+// AutoStartDependents is set, and never in no-worktree mode, where only one
+// task may be active at a time.
+func (r *Runner) startReadyDependents(doneID uuid.UUID) {
+	if !r.autoStartDependents || r.noWorktree {
+		return
+	}
+	ctx := context.Background()
+	tasks, err := r.store.ListTasks(ctx, false)
+	if err != nil {
+		logger.Runner.Warn("list tasks for dependents", "task", doneID, "error", err)
+		return
+	}
+	for _, t := range tasks {
+		if t.Status != "backlog" || !dependsOn(&t, doneID) {
+			continue
+		}
+		if ok, _, err := r.store.CanStart(ctx, t.ID); err != nil || !ok {
+			continue
+		}
+		r.startBacklogTask(&t, "All dependencies are done; starting automatically.")
+	}
+}
+
+// dependsOn reports whether t lists id as a direct dependency.
+func dependsOn(t *store.Task, id uuid.UUID) bool {
+	for _, dep := range t.DependsOn {
+		if dep == id {
+			return true
+		}
+	}
+	return false
+}
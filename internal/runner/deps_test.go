@@ -0,0 +1,38 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// TestNotifyDoneStartsReadyDependents verifies that a backlog task is started
+// once its last dependency is done, and only when AutoStartDependents is set.
+func TestNotifyDoneStartsReadyDependents(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupRunnerWithCmd(t, []string{repo}, fakeCmdScript(t, endTurnOutput, 0))
+
+	a, _ := s.CreateTask(bg(), "a", 5, false)
+	b, _ := s.CreateTask(bg(), "b", 5, false)
+	c, _ := s.CreateTask(bg(), "c", 5, false)
+	if err := s.SetTaskDependsOn(bg(), c.ID, []uuid.UUID{a.ID, b.ID}); err != nil {
+		t.Fatal(err)
+	}
+	s.UpdateTaskStatus(bg(), a.ID, "done")
+
+	r.NotifyDone(a.ID)
+	if got, _ := s.GetTask(bg(), c.ID); got.Status != "backlog" {
+		t.Fatalf("disabled: status = %q, want backlog", got.Status)
+	}
+
+	r.autoStartDependents = true
+	r.NotifyDone(a.ID)
+	if got, _ := s.GetTask(bg(), c.ID); got.Status != "backlog" {
+		t.Fatalf("one dependency left: status = %q, want backlog", got.Status)
+	}
+
+	s.UpdateTaskStatus(bg(), b.ID, "done")
+	r.NotifyDone(b.ID)
+	waitStatus(t, s, c.ID, "done")
+	waitIdle(t, r)
+}
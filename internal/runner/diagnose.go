@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"changkun.de/wallfacer/internal/gitutil"
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// ErrNothingToDiagnose is returned by DiagnoseRebase when none of the task's
+// git worktrees survive to replay the rebase from.
+var ErrNothingToDiagnose = errors.New("task has no surviving git worktrees to diagnose")
+
+// DiagnoseRebase replays the rebase step of the commit pipeline for each of
+// the task's surviving git worktrees with git tracing enabled, and writes
+// the full transcript to a diagnostics file in the task's outputs directory.
+// The replay runs in a temporary detached worktree that is removed again,
+// so neither the task branch nor the target branch is changed. It returns
+// the diagnostics file name.
+func (r *Runner) DiagnoseRebase(ctx context.Context, taskID uuid.UUID) (string, error) {
+	task, err := r.store.GetTask(ctx, taskID)
+	if err != nil {
+		return "", err
+	}
+	repos := make([]string, 0, len(task.WorktreePaths))
+	for repo, wt := range task.WorktreePaths {
+		if _, err := os.Stat(wt); err == nil && gitutil.IsGitRepo(repo) {
+			repos = append(repos, repo)
+		}
+	}
+	if len(repos) == 0 {
+		return "", ErrNothingToDiagnose
+	}
+	sort.Strings(repos)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "wallfacer rebase diagnostics\ntask:   %s\nbranch: %s\ntime:   %s\n",
+		taskID, task.BranchName, time.Now().Format(time.RFC3339))
+	for _, repo := range repos {
+		b.WriteString("\n")
+		r.diagnoseRepo(ctx, &b, task, repo, task.WorktreePaths[repo])
+	}
+
+	dir := r.store.OutputsDir(taskID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("create outputs dir: %w", err)
+	}
+	name := fmt.Sprintf("diagnose-%d.txt", time.Now().UnixNano())
+	if err := os.WriteFile(filepath.Join(dir, name), b.Bytes(), 0600); err != nil {
+		return "", fmt.Errorf("write diagnostics: %w", err)
+	}
+	r.store.InsertEvent(context.Background(), taskID, store.EventTypeSystem, map[string]string{
+		"result": fmt.Sprintf("Rebase diagnostics written to %s (%d repo(s)).", name, len(repos)),
+	})
+	return name, nil
+}
+
+// diagnoseRepo writes the state of one repository and a traced replay of
+// rebasing the task's worktree HEAD onto its merge target.
+func (r *Runner) diagnoseRepo(ctx context.Context, b *bytes.Buffer, task *store.Task, repo, worktree string) {
+	section := func(title string) { fmt.Fprintf(b, "\n--- %s ---\n", title) }
+	run := func(dir string, env []string, args ...string) {
+		fmt.Fprintf(b, "$ git %s\n", strings.Join(args, " "))
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), env...)
+		out, err := cmd.CombinedOutput()
+		b.Write(out)
+		if err != nil {
+			fmt.Fprintf(b, "[%v]\n", err)
+		}
+	}
+
+	target := task.TargetBranches[repo]
+	if target == "" || !gitutil.BranchExists(repo, target) {
+		target, _ = gitutil.DefaultBranch(repo)
+	}
+	fmt.Fprintf(b, "=== %s ===\nworktree: %s\ntarget:   %s (recorded: %q)\n", repo, worktree, target, task.TargetBranches[repo])
+
+	section("task worktree")
+	run(worktree, nil, "rev-parse", "HEAD")
+	run(worktree, nil, "status", "--porcelain=v1", "--branch")
+	section("target and merge base")
+	run(repo, nil, "rev-parse", target)
+	run(worktree, nil, "merge-base", target, "HEAD")
+	section("commits to replay")
+	run(worktree, nil, "log", "--oneline", "--stat", target+"..HEAD")
+	section("upstream commits since merge base")
+	run(worktree, nil, "log", "--oneline", "--stat", "HEAD.."+target)
+
+	head, err := gitutil.GetCommitHash(worktree)
+	if err != nil {
+		fmt.Fprintf(b, "cannot resolve worktree HEAD: %v\n", err)
+		return
+	}
+	scratch, err := os.MkdirTemp("", "wallfacer-diagnose-*")
+	if err != nil {
+		fmt.Fprintf(b, "cannot create scratch dir: %v\n", err)
+		return
+	}
+	scratch = filepath.Join(scratch, "wt")
+	defer func() {
+		exec.Command("git", "-C", repo, "worktree", "remove", "--force", scratch).Run()
+		os.RemoveAll(filepath.Dir(scratch))
+		exec.Command("git", "-C", repo, "worktree", "prune").Run()
+	}()
+
+	section("replay")
+	if out, err := exec.Command("git", "-C", repo, "worktree", "add", "--detach", scratch, head).CombinedOutput(); err != nil {
+		fmt.Fprintf(b, "cannot create scratch worktree: %v\n%s", err, out)
+		return
+	}
+	run(scratch, []string{"GIT_TRACE=1", "GIT_MERGE_VERBOSITY=5"}, "rebase", target)
+	section("state after replay")
+	run(scratch, nil, "status", "--porcelain=v1")
+	run(scratch, nil, "diff", "--name-only", "--diff-filter=U")
+	run(scratch, nil, "diff")
+	run(scratch, nil, "rebase", "--abort")
+	logger.Runner.Info("rebase diagnostics replayed", "task", task.ID, "repo", repo)
+}
@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// worktreeDiskPollInterval is how often a task held for disk space re-checks
+// worktree usage. A variable so tests can shorten it.
+var worktreeDiskPollInterval = 10 * time.Second
+
+// MaxWorktreesDiskBytes returns the configured cap on disk used by task
+// worktrees. Zero means unlimited.
+func (r *Runner) MaxWorktreesDiskBytes() int64 {
+	return r.maxWorktreesDisk
+}
+
+// WorktreesDiskUsage returns the total size of all task worktrees and the
+// number of task directories they belong to.
+func (r *Runner) WorktreesDiskUsage() (int64, int) {
+	var total int64
+	tasks := 0
+	for _, root := range r.worktreeRoots() {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			tasks++
+			total += dirSize(filepath.Join(root, e.Name()))
+		}
+	}
+	return total, tasks
+}
+
+// dirSize sums the sizes of regular files under path. Unreadable entries are
+// skipped so a partially removed worktree never fails the check.
+func dirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// worktreeDiskFull reports whether creating another task's worktrees would
+// push usage past the cap. A new worktree is assumed to be as large as the
+// average existing one; with no worktrees on disk a task may always start,
+// so the limit can never block every task.
+func (r *Runner) worktreeDiskFull() (bool, int64) {
+	if r.maxWorktreesDisk <= 0 || r.noWorktree {
+		return false, 0
+	}
+	used, tasks := r.WorktreesDiskUsage()
+	if tasks == 0 {
+		return false, used
+	}
+	return used+used/int64(tasks) > r.maxWorktreesDisk, used
+}
+
+// waitForWorktreeDisk holds a task in the backlog while worktree disk usage
+// is at the cap, moving it back to in_progress once other tasks clean up.
+// Returns false if the task left the backlog for another reason while
+// waiting (cancelled, deleted, or restarted by the user), in which case the
+// caller must stop without touching its status.
+func (r *Runner) waitForWorktreeDisk(taskID uuid.UUID) bool {
+	full, used := r.worktreeDiskFull()
+	if !full {
+		return true
+	}
+	bgCtx := context.Background()
+	logger.Runner.Warn("worktree disk limit reached, holding task",
+		"task", taskID, "used", used, "limit", r.maxWorktreesDisk)
+	r.store.UpdateTaskStatus(bgCtx, taskID, "backlog")
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
+		"from": "in_progress", "to": "backlog",
+	})
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+		"result": fmt.Sprintf("Waiting for worktree disk space (%d of %d bytes used). "+
+			"The task starts automatically once other tasks clean up.", used, r.maxWorktreesDisk),
+	})
+	for {
+		time.Sleep(worktreeDiskPollInterval)
+		task, err := r.store.GetTask(bgCtx, taskID)
+		if err != nil || task.Status != "backlog" {
+			return false
+		}
+		if full, _ := r.worktreeDiskFull(); full {
+			continue
+		}
+		r.store.UpdateTaskStatus(bgCtx, taskID, "in_progress")
+		r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
+			"from": "backlog", "to": "in_progress",
+		})
+		return true
+	}
+}
@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"changkun.de/wallfacer/internal/store"
+)
+
+// writeSized creates a file of n bytes, creating parent directories.
+func writeSized(t *testing.T, path string, n int) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, n), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestWorktreesDiskUsage verifies that usage sums files across task dirs.
+func TestWorktreesDiskUsage(t *testing.T) {
+	_, r := setupTestRunner(t, nil)
+	writeSized(t, filepath.Join(r.worktreesDir, "task-a", "repo", "f"), 300)
+	writeSized(t, filepath.Join(r.worktreesDir, "task-b", "repo", "sub", "g"), 100)
+
+	used, tasks := r.WorktreesDiskUsage()
+	if used != 400 || tasks != 2 {
+		t.Fatalf("usage = %d bytes over %d tasks, want 400 over 2", used, tasks)
+	}
+}
+
+// TestWorktreeDiskFull verifies the cap accounts for the next worktree and
+// never blocks when no worktrees exist.
+func TestWorktreeDiskFull(t *testing.T) {
+	_, r := setupTestRunner(t, nil)
+	r.maxWorktreesDisk = 500
+	if full, _ := r.worktreeDiskFull(); full {
+		t.Fatal("empty worktrees dir must never be full")
+	}
+
+	writeSized(t, filepath.Join(r.worktreesDir, "task-a", "repo", "f"), 200)
+	if full, _ := r.worktreeDiskFull(); full {
+		t.Error("200 + 200 estimated should fit in 500")
+	}
+	writeSized(t, filepath.Join(r.worktreesDir, "task-b", "repo", "f"), 200)
+	if full, _ := r.worktreeDiskFull(); !full {
+		t.Error("400 + 200 estimated should exceed 500")
+	}
+
+	r.maxWorktreesDisk = 0
+	if full, _ := r.worktreeDiskFull(); full {
+		t.Error("zero limit must disable the check")
+	}
+}
+
+// TestWaitForWorktreeDisk verifies that a held task returns to the backlog
+// and resumes once space frees up.
+func TestWaitForWorktreeDisk(t *testing.T) {
+	old := worktreeDiskPollInterval
+	worktreeDiskPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { worktreeDiskPollInterval = old })
+
+	s, r := setupTestRunner(t, nil)
+	r.maxWorktreesDisk = 100
+	other := filepath.Join(r.worktreesDir, "other")
+	writeSized(t, filepath.Join(other, "repo", "f"), 100)
+
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	s.UpdateTaskStatus(bg(), task.ID, "in_progress")
+
+	done := make(chan bool, 1)
+	go func() { done <- r.waitForWorktreeDisk(task.ID) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, _ := s.GetTask(bg(), task.ID)
+		if got.Status == "backlog" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("task was not held in backlog")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	os.RemoveAll(other)
+
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatal("expected task to resume")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("task did not resume after space freed")
+	}
+	got, _ := s.GetTask(bg(), task.ID)
+	if got.Status != "in_progress" {
+		t.Errorf("status = %q, want in_progress", got.Status)
+	}
+	events, _ := s.GetEvents(bg(), task.ID)
+	found := false
+	for _, e := range events {
+		if e.EventType == store.EventTypeSystem && strings.Contains(string(e.Data), "Waiting for worktree disk space") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected a waiting-for-disk system event")
+	}
+}
@@ -2,9 +2,11 @@ package runner
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -35,22 +37,35 @@ func (r *Runner) Run(taskID uuid.UUID, prompt, sessionID string, resumedFromWait
 				"to":   "failed",
 			})
 		}
+		// Done is notified where the commit lands; the other outcomes of
+		// a run are notified here.
+		if task, err := r.store.GetTask(bgCtx, taskID); err == nil {
+			switch task.Status {
+			case "failed":
+				r.NotifyFailed(taskID)
+			case "waiting":
+				r.notifyWebhook(taskID, task.Status)
+			}
+		}
 	}()
 
+	// The title is cosmetic; don't let its sandbox run alongside the task.
+	r.cancelTitleGeneration(taskID)
+
+	release, ok := r.acquireSlot(taskID)
+	if !ok {
+		// Cancelled or deleted while queued; whoever did it owns the status.
+		statusSet = true
+		return
+	}
+	defer release()
+
 	task, err := r.store.GetTask(bgCtx, taskID)
 	if err != nil {
 		logger.Runner.Error("get task", "task", taskID, "error", err)
 		return // defer moves to "failed"
 	}
 
-	// Apply per-task total timeout across all turns.
-	timeout := time.Duration(task.Timeout) * time.Minute
-	if timeout <= 0 {
-		timeout = defaultTaskTimeout
-	}
-	ctx, cancel := context.WithTimeout(bgCtx, timeout)
-	defer cancel()
-
 	// Set up worktrees only if not already present.
 	worktreePaths := task.WorktreePaths
 	branchName := task.BranchName
@@ -66,6 +81,12 @@ func (r *Runner) Run(taskID uuid.UUID, prompt, sessionID string, resumedFromWait
 			}
 		}
 	}
+	if needSetup && !r.waitForWorktreeDisk(taskID) {
+		// The task left the backlog while held for disk space; whoever
+		// moved it owns its status now.
+		statusSet = true
+		return
+	}
 	if needSetup {
 		worktreePaths, branchName, err = r.setupWorktrees(taskID)
 		if err != nil {
@@ -83,20 +104,49 @@ func (r *Runner) Run(taskID uuid.UUID, prompt, sessionID string, resumedFromWait
 			logger.Runner.Error("save worktree paths", "task", taskID, "error", err)
 		}
 	}
+	if needSetup || len(task.TargetBranches) == 0 {
+		if err := r.store.UpdateTaskTargetBranches(bgCtx, taskID, r.targetBranches(worktreePaths)); err != nil {
+			logger.Runner.Error("save target branches", "task", taskID, "error", err)
+		}
+	}
+	if r.noWorktree && needSetup {
+		// Remember where the workspace started so the task's diff and commit
+		// hashes can be reconstructed after committing in place.
+		if err := r.store.UpdateTaskBaseCommitHashes(bgCtx, taskID, headHashes(worktreePaths)); err != nil {
+			logger.Runner.Error("save base commit hashes", "task", taskID, "error", err)
+		}
+	}
+
+	// Apply per-task total timeout across all turns. It starts after any
+	// wait for worktree disk space so held tasks keep their full budget.
+	timeout := time.Duration(task.Timeout) * time.Minute
+	if timeout <= 0 {
+		timeout = defaultTaskTimeout
+	}
+	deadline := newTaskDeadline(bgCtx, timeout)
+	defer deadline.stop()
+	var ctx context.Context = deadline
 
 	turns := task.Turns
 
-	// Copy CLAUDE.md into worktree roots.
-	copyInstructionsToWorktrees(r.instructionsPath, worktreePaths)
+	// Copy CLAUDE.md into worktree roots. Skipped in place so the file does
+	// not end up committed to the user's workspace.
+	if !r.noWorktree {
+		copyInstructionsToWorktrees(r.globalInstructionsPath, r.instructionsPath, worktreePaths)
+	}
 
 	// Create sandbox only on first run. When resuming from "waiting", the
 	// sandbox is still alive (we kept it via removeSandbox=false).
+	var sandboxWorkspaces []string
+	for _, wt := range worktreePaths {
+		sandboxWorkspaces = append(sandboxWorkspaces, wt)
+	}
+	// Read-only, so the agent cannot plant hooks or config in a git
+	// directory that host-side commits and rebases use.
+	for _, dir := range gitDirMounts(sandboxWorkspaces) {
+		sandboxWorkspaces = append(sandboxWorkspaces, dir+":ro")
+	}
 	if !resumedFromWaiting {
-		var sandboxWorkspaces []string
-		for _, wt := range worktreePaths {
-			sandboxWorkspaces = append(sandboxWorkspaces, wt)
-		}
-
 		if err := r.CreateSandbox(ctx, taskID, sandboxWorkspaces); err != nil {
 			logger.Runner.Error("create sandbox", "task", taskID, "error", err)
 			statusSet = true
@@ -136,7 +186,34 @@ func (r *Runner) Run(taskID uuid.UUID, prompt, sessionID string, resumedFromWait
 		siblingMounts = r.buildSiblingMounts(taskID)
 	}
 
+	turnTimeout := r.taskTurnTimeout(task)
+	turnTimedOut := false // the previous turn ran past turnTimeout
 	for {
+		if !r.waitForSpendBudget(taskID, deadline) {
+			// Cancelled or deleted while queued for the hourly spend cap.
+			statusSet = true
+			return
+		}
+
+		if !r.waitForDailyBudget(taskID, deadline) {
+			// Cancelled or deleted while queued for the daily limit.
+			statusSet = true
+			return
+		}
+
+		if !r.waitForAuth(taskID, deadline) {
+			// Cancelled or deleted while queued for a rejected token.
+			statusSet = true
+			return
+		}
+
+		// Stop before a turn that would run past the task's cost budget;
+		// this also ends runaway max_tokens continuations.
+		if r.failIfOverBudget(taskID, sessionID, turns) {
+			statusSet = true
+			return
+		}
+
 		turns++
 		logger.Runner.Info("turn", "task", taskID, "turn", turns, "session", sessionID, "timeout", timeout)
 
@@ -147,8 +224,20 @@ func (r *Runner) Run(taskID uuid.UUID, prompt, sessionID string, resumedFromWait
 			}
 		}
 
-		output, rawStdout, rawStderr, err := r.runContainer(ctx, taskID, prompt, sessionID, worktreePaths, boardDir, siblingMounts)
-		if saveErr := r.store.SaveTurnOutput(taskID, turns, rawStdout, rawStderr); saveErr != nil {
+		var progressBefore string
+		if task.AutoExtend {
+			progressBefore = worktreeProgress(worktreePaths)
+		}
+		turnCtx, cancelTurn := turnContext(ctx, turnTimeout)
+		turnStart := time.Now()
+		output, rawStdout, rawStderr, err := r.runContainer(turnCtx, taskID, prompt, sessionID, worktreePaths, boardDir, siblingMounts)
+		turnDuration := time.Since(turnStart)
+		r.metrics.recordTurn(turnDuration)
+		r.store.AccumulateTaskActiveTime(bgCtx, taskID, turnDuration)
+		turnMS := strconv.FormatInt(turnDuration.Milliseconds(), 10)
+		hitTurnTimeout := turnCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+		cancelTurn()
+		if saveErr := r.saveTurnOutput(taskID, turns, rawStdout, rawStderr); saveErr != nil {
 			logger.Runner.Error("save turn output", "task", taskID, "turn", turns, "error", saveErr)
 		}
 		if err != nil {
@@ -160,6 +249,14 @@ func (r *Runner) Run(taskID uuid.UUID, prompt, sessionID string, resumedFromWait
 				}
 			}
 
+			if isAuthFailure(err.Error(), string(rawStderr)) {
+				statusSet = true
+				if !r.statusTaken(taskID) {
+					r.failAuth(taskID, sessionID, turns, err.Error())
+				}
+				return
+			}
+
 			// If resume produced empty output, drop the session and retry.
 			if sessionID != "" && strings.Contains(err.Error(), "empty output from container") {
 				logger.Runner.Warn("resume produced empty output, retrying without session",
@@ -172,29 +269,73 @@ func (r *Runner) Run(taskID uuid.UUID, prompt, sessionID string, resumedFromWait
 				continue
 			}
 
+			// A turn that hung past the per-turn timeout is retried once in
+			// a fresh sandbox, resuming its session; the task timeout still
+			// bounds the whole run.
+			if hitTurnTimeout {
+				if !turnTimedOut {
+					turnTimedOut = true
+					logger.Runner.Warn("turn timed out, retrying", "task", taskID, "turn", turns, "turn_timeout", turnTimeout)
+					r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+						"result": fmt.Sprintf("Turn %d exceeded the per-turn timeout of %s. Restarting the sandbox and retrying the turn...", turns, turnTimeout),
+					})
+					cerr := r.CreateSandbox(ctx, taskID, sandboxWorkspaces)
+					if cerr == nil {
+						if sessionID != "" {
+							prompt = ""
+						}
+						continue
+					}
+					err = fmt.Errorf("restart sandbox after turn timeout: %w", cerr)
+				} else {
+					err = fmt.Errorf("turn %d exceeded the per-turn timeout of %s after a retry: %w", turns, turnTimeout, err)
+				}
+			}
+
 			logger.Runner.Error("container error", "task", taskID, "error", err)
-			// Don't overwrite a cancelled status.
-			if cur, _ := r.store.GetTask(bgCtx, taskID); cur != nil && cur.Status == "cancelled" {
+			// Don't overwrite a cancelled or deadline-failed status.
+			if r.statusTaken(taskID) {
 				statusSet = true
 				return
 			}
 			statusSet = true
 			r.store.UpdateTaskStatus(bgCtx, taskID, "failed")
 			r.store.UpdateTaskResult(bgCtx, taskID, err.Error(), sessionID, "", turns)
-			r.store.InsertEvent(bgCtx, taskID, store.EventTypeError, map[string]string{"error": err.Error()})
+			errData := map[string]string{"error": err.Error()}
+			var exitErr *containerExitError
+			if errors.As(err, &exitErr) {
+				for k, v := range exitErr.eventData() {
+					errData[k] = v
+				}
+			}
+			r.store.InsertEvent(bgCtx, taskID, store.EventTypeError, errData)
 			r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
-				"from": "in_progress", "to": "failed",
+				"from": "in_progress", "to": "failed", "duration_ms": turnMS,
 			})
 			return
 		}
 
+		turnTimedOut = false
+
 		r.store.InsertEvent(bgCtx, taskID, store.EventTypeOutput, map[string]string{
 			"result":      output.Result,
 			"stop_reason": output.StopReason,
 			"session_id":  output.SessionID,
+			"duration_ms": turnMS,
 		})
 
 		if output.SessionID != "" {
+			if sessionID != "" && output.SessionID != sessionID {
+				// Claude Code may hand back a new session when --resume cannot
+				// find the requested one; record it so resume issues are visible.
+				logger.Runner.Warn("session id changed", "task", taskID,
+					"from", sessionID, "to", output.SessionID)
+				r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+					"result":          fmt.Sprintf("Session changed from %s to %s.", sessionID, output.SessionID),
+					"prev_session_id": sessionID,
+					"session_id":      output.SessionID,
+				})
+			}
 			sessionID = output.SessionID
 		}
 		r.store.UpdateTaskResult(bgCtx, taskID, output.Result, sessionID, output.StopReason, turns)
@@ -206,42 +347,86 @@ func (r *Runner) Run(taskID uuid.UUID, prompt, sessionID string, resumedFromWait
 			CostUSD:              output.TotalCostUSD,
 		})
 
+		if output.IsError && isAuthFailure(output.Result) {
+			statusSet = true
+			if !r.statusTaken(taskID) {
+				r.failAuth(taskID, sessionID, turns, output.Result)
+			}
+			return
+		}
 		if output.IsError {
 			statusSet = true
 			r.store.UpdateTaskStatus(bgCtx, taskID, "failed")
 			r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
-				"from": "in_progress", "to": "failed",
+				"from": "in_progress", "to": "failed", "duration_ms": turnMS,
 			})
 			return
 		}
 
-		switch output.StopReason {
+		stopReason := output.StopReason
+		if stopReason != "end_turn" && stopReason != "max_tokens" && stopReason != "pause_turn" {
+			switch r.emptyStopReason {
+			case EmptyStopReasonComplete:
+				logger.Runner.Info("completing on empty stop_reason", "task", taskID, "stop_reason", stopReason)
+				r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+					"result": fmt.Sprintf("Turn ended with stop_reason %q; completing per the empty stop_reason policy.", stopReason),
+				})
+				stopReason = "end_turn"
+			case EmptyStopReasonFail:
+				if r.statusTaken(taskID) {
+					statusSet = true
+					return
+				}
+				statusSet = true
+				r.store.UpdateTaskStatus(bgCtx, taskID, "failed")
+				r.store.InsertEvent(bgCtx, taskID, store.EventTypeError, map[string]string{
+					"error": fmt.Sprintf("turn ended with stop_reason %q", stopReason),
+				})
+				r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
+					"from": "in_progress", "to": "failed", "duration_ms": turnMS,
+				})
+				return
+			}
+		}
+
+		switch stopReason {
 		case "end_turn":
 			statusSet = true
-			if err := r.commit(ctx, taskID, sessionID, turns, worktreePaths, branchName); err != nil {
+			if err := r.commit(ctx, taskID, sessionID, turns, worktreePaths, branchName); errors.Is(err, ErrCommitCancelled) {
+				// Whoever cancelled the commit owns the task's status.
+				return
+			} else if errors.Is(err, ErrConflictPaused) {
+				// The task waits in "conflict" for ContinueRebase.
+				return
+			} else if err != nil {
 				r.store.UpdateTaskStatus(bgCtx, taskID, "failed")
 				r.store.InsertEvent(bgCtx, taskID, store.EventTypeError, map[string]string{
 					"error": "commit failed: " + err.Error(),
 				})
 				r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
-					"from": "in_progress", "to": "failed",
+					"from": "in_progress", "to": "failed", "duration_ms": turnMS,
 				})
 			} else {
 				r.store.UpdateTaskStatus(bgCtx, taskID, "done")
 				r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
-					"from": "in_progress", "to": "done",
+					"from": "in_progress", "to": "done", "duration_ms": turnMS,
 				})
+				r.NotifyDone(taskID)
 			}
 			return
 
 		case "max_tokens", "pause_turn":
 			logger.Runner.Info("auto-continuing", "task", taskID, "stop_reason", output.StopReason)
+			if task.AutoExtend {
+				r.maybeExtendDeadline(taskID, turns, deadline, progressBefore, worktreeProgress(worktreePaths))
+			}
 			prompt = ""
 			continue
 
 		default:
-			// Empty or unknown stop_reason — waiting for user feedback.
-			if cur, _ := r.store.GetTask(bgCtx, taskID); cur != nil && cur.Status == "cancelled" {
+			// Empty or unknown stop_reason under the default policy —
+			// waiting for user feedback.
+			if r.statusTaken(taskID) {
 				statusSet = true
 				return
 			}
@@ -249,7 +434,7 @@ func (r *Runner) Run(taskID uuid.UUID, prompt, sessionID string, resumedFromWait
 			removeSandbox = false // Keep sandbox alive for resume.
 			r.store.UpdateTaskStatus(bgCtx, taskID, "waiting")
 			r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
-				"from": "in_progress", "to": "waiting",
+				"from": "in_progress", "to": "waiting", "duration_ms": turnMS,
 			})
 			return
 		}
@@ -290,7 +475,7 @@ func (r *Runner) SyncWorktrees(taskID uuid.UUID, sessionID, prevStatus string) {
 	defer cancel()
 
 	r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
-		"result": "Syncing worktrees with latest changes on default branch...",
+		"result": "Syncing worktrees with latest changes on their target branches...",
 	})
 
 	for repoPath, worktreePath := range task.WorktreePaths {
@@ -301,42 +486,47 @@ func (r *Runner) SyncWorktrees(taskID uuid.UUID, sessionID, prevStatus string) {
 			continue
 		}
 
-		defBranch, err := gitutil.DefaultBranch(repoPath)
+		// Sync onto the branch the commit pipeline will merge into.
+		target, err := r.mergeTarget(bgCtx, taskID, repoPath)
 		if err != nil {
 			statusSet = true
 			r.failSync(bgCtx, taskID, sessionID, task.Turns,
-				fmt.Sprintf("defaultBranch for %s: %v", filepath.Base(repoPath), err))
+				fmt.Sprintf("target branch for %s: %v", filepath.Base(repoPath), err))
 			return
 		}
 
-		n, _ := gitutil.CommitsBehind(repoPath, worktreePath)
+		n, _ := gitutil.CommitsBehindBranch(worktreePath, target)
 		if n == 0 {
 			r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
-				"result": fmt.Sprintf("%s is already up to date with %s.", filepath.Base(repoPath), defBranch),
+				"result": fmt.Sprintf("%s is already up to date with %s.", filepath.Base(repoPath), target),
 			})
 			continue
 		}
 
 		r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
-			"result": fmt.Sprintf("Rebasing %s onto %s (%d new commit(s))...", filepath.Base(repoPath), defBranch, n),
+			"result": fmt.Sprintf("Rebasing %s onto %s (%d new commit(s))...", filepath.Base(repoPath), target, n),
 		})
 
 		stashed := gitutil.StashIfDirty(worktreePath)
 
 		var rebaseErr error
-		for attempt := 1; attempt <= maxRebaseRetries; attempt++ {
-			rebaseErr = gitutil.RebaseOntoDefault(repoPath, worktreePath)
+		attempts := r.rebaseRetries + 1
+		for attempt := 1; attempt <= attempts; attempt++ {
+			rebaseErr = gitutil.RebaseOnto(worktreePath, target, r.rebaseArgs()...)
 			if rebaseErr == nil {
 				break
 			}
-			if attempt == maxRebaseRetries || !isConflictError(rebaseErr) {
+			if isConflictError(rebaseErr) {
+				r.metrics.rebaseConflicts.Add(1)
+			}
+			if attempt == attempts || !isConflictError(rebaseErr) || r.rebaseConflict == RebaseConflictAbort {
 				break
 			}
 			logger.Runner.Warn("sync rebase conflict, invoking resolver",
 				"task", taskID, "repo", repoPath, "attempt", attempt)
 			r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
 				"result": fmt.Sprintf("Conflict in %s — running resolver (attempt %d/%d)...",
-					filepath.Base(repoPath), attempt, maxRebaseRetries),
+					filepath.Base(repoPath), attempt, r.rebaseRetries),
 			})
 			if resolveErr := r.resolveConflicts(ctx, taskID, repoPath, worktreePath, sessionID); resolveErr != nil {
 				rebaseErr = fmt.Errorf("conflict resolution failed: %w", resolveErr)
@@ -356,7 +546,7 @@ func (r *Runner) SyncWorktrees(taskID uuid.UUID, sessionID, prevStatus string) {
 		}
 
 		r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
-			"result": fmt.Sprintf("Successfully synced %s with %s.", filepath.Base(repoPath), defBranch),
+			"result": fmt.Sprintf("Successfully synced %s with %s.", filepath.Base(repoPath), target),
 		})
 	}
 
@@ -367,7 +557,7 @@ func (r *Runner) SyncWorktrees(taskID uuid.UUID, sessionID, prevStatus string) {
 		"to":   prevStatus,
 	})
 	r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
-		"result": "Sync complete. Worktrees are up to date with the default branch.",
+		"result": "Sync complete. Worktrees are up to date with their target branches.",
 	})
 	logger.Runner.Info("sync completed", "task", taskID)
 }
@@ -382,5 +572,5 @@ func (r *Runner) failSync(ctx context.Context, taskID uuid.UUID, sessionID strin
 		"to":   "failed",
 	})
 	r.store.UpdateTaskResult(ctx, taskID, "Sync failed: "+msg, sessionID, "sync_failed", turns)
+	r.NotifyFailed(taskID)
 }
-
@@ -83,9 +83,10 @@ func setupRunnerWithCmd(t *testing.T, workspaces []string, cmd string) (*store.S
 		t.Fatal(err)
 	}
 	r := NewRunner(s, RunnerConfig{
-		Command:      cmd,
-		Workspaces:   strings.Join(workspaces, " "),
-		WorktreesDir: worktreesDir,
+		Command:       cmd,
+		Workspaces:    strings.Join(workspaces, " "),
+		WorktreesDir:  worktreesDir,
+		RebaseRetries: DefaultRebaseRetries,
 	})
 	return s, r
 }
@@ -147,6 +148,41 @@ func TestRunWaitingTransitionsToWaiting(t *testing.T) {
 	}
 }
 
+// TestRunEmptyStopReasonPolicy verifies that each EmptyStopReasonPolicy
+// decides the outcome of a turn with an empty stop_reason.
+func TestRunEmptyStopReasonPolicy(t *testing.T) {
+	cases := []struct {
+		policy string
+		want   string
+	}{
+		{"", "waiting"},
+		{EmptyStopReasonWait, "waiting"},
+		{EmptyStopReasonComplete, "done"},
+		{EmptyStopReasonFail, "failed"},
+	}
+	for _, tc := range cases {
+		t.Run("policy="+tc.policy, func(t *testing.T) {
+			repo := setupTestRepo(t)
+			cmd := fakeCmdScript(t, waitingOutput, 0)
+			s, r := setupRunnerWithCmd(t, []string{repo}, cmd)
+			r.emptyStopReason = tc.policy
+			ctx := context.Background()
+
+			task, err := s.CreateTask(ctx, "Test empty stop_reason", 5, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r.Run(task.ID, "some prompt", "", false)
+
+			updated, _ := s.GetTask(ctx, task.ID)
+			if updated.Status != tc.want {
+				t.Fatalf("expected status=%s, got %q", tc.want, updated.Status)
+			}
+		})
+	}
+}
+
 // TestRunIsErrorTransitionsToFailed verifies that IsError=true moves the
 // task to "failed".
 func TestRunIsErrorTransitionsToFailed(t *testing.T) {
@@ -189,6 +225,94 @@ func TestRunContainerErrorTransitionsToFailed(t *testing.T) {
 	}
 }
 
+// TestRunAuthFailureHoldsBoard verifies that a turn refused for an expired
+// token fails its task with stop_reason auth_failed, and that the next task
+// is held in queued until the token in the env file changes.
+func TestRunAuthFailureHoldsBoard(t *testing.T) {
+	orig := authPollInterval
+	authPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { authPollInterval = orig })
+
+	repo := setupTestRepo(t)
+	authOutput := `{"result":"OAuth token has expired. Please obtain a new token or refresh your existing token.","session_id":"sess1","is_error":true}`
+	s, r := setupRunnerWithCmd(t, []string{repo}, fakeStatefulCmd(t, []string{authOutput, endTurnOutput}))
+	r.envFile = filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(r.envFile, []byte("CLAUDE_CODE_OAUTH_TOKEN=old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	first, _ := s.CreateTask(ctx, "first", 5, false)
+	r.Run(first.ID, "prompt", "", false)
+	got, _ := s.GetTask(ctx, first.ID)
+	if got.Status != "failed" || got.StopReason == nil || *got.StopReason != StopReasonAuthFailed {
+		t.Fatalf("first task: status %q stop_reason %v, want failed/auth_failed", got.Status, got.StopReason)
+	}
+	if !hasEvent(t, s, first.ID, "Refresh CLAUDE_CODE_OAUTH_TOKEN") {
+		t.Error("missing event telling to refresh the token")
+	}
+	if !r.AuthFailed() || !r.Status().AuthFailed {
+		t.Fatal("AuthFailed should be set after the rejection")
+	}
+
+	second, _ := s.CreateTask(ctx, "second", 5, false)
+	s.UpdateTaskStatus(ctx, second.ID, "in_progress")
+	done := make(chan struct{})
+	go func() {
+		r.Run(second.ID, "prompt", "", false)
+		close(done)
+	}()
+	waitStatus(t, s, second.ID, "queued")
+	if err := os.WriteFile(r.envFile, []byte("CLAUDE_CODE_OAUTH_TOKEN=new\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if got, _ := s.GetTask(ctx, second.ID); got.Status != "done" {
+		t.Fatalf("second task: status %q after the token changed, want done", got.Status)
+	}
+	if r.AuthFailed() {
+		t.Error("AuthFailed should clear once the token changes")
+	}
+}
+
+// TestRunAuthFailureKeepsCancelledStatus verifies that an auth failure
+// returned by a turn whose task was cancelled meanwhile leaves the task
+// cancelled and the board unheld.
+func TestRunAuthFailureKeepsCancelledStatus(t *testing.T) {
+	repo := setupTestRepo(t)
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.txt")
+	authOutput := `{"result":"Invalid API key","session_id":"sess1","is_error":true}`
+	if err := os.WriteFile(out, []byte(authOutput), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := filepath.Join(dir, "fake-slow")
+	script := fmt.Sprintf("#!/bin/sh\nif [ \"$1\" != sandbox ] || [ \"$2\" != exec ]; then exit 0; fi\nsleep 0.3\ncat %s\n", out)
+	if err := os.WriteFile(cmd, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s, r := setupRunnerWithCmd(t, []string{repo}, cmd)
+	ctx := context.Background()
+
+	task, _ := s.CreateTask(ctx, "p", 5, false)
+	s.UpdateTaskStatus(ctx, task.ID, "in_progress")
+	done := make(chan struct{})
+	go func() {
+		r.Run(task.ID, "prompt", "", false)
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	s.UpdateTaskStatus(ctx, task.ID, "cancelled")
+	<-done
+
+	if got, _ := s.GetTask(ctx, task.ID); got.Status != "cancelled" {
+		t.Fatalf("status = %q, want cancelled", got.Status)
+	}
+	if r.AuthFailed() {
+		t.Error("a cancelled task must not hold the board")
+	}
+}
+
 // TestRunMaxTokensAutoContinues verifies that max_tokens triggers an
 // auto-continue turn and the task eventually reaches the terminal state.
 func TestRunMaxTokensAutoContinues(t *testing.T) {
@@ -214,6 +338,59 @@ func TestRunMaxTokensAutoContinues(t *testing.T) {
 	}
 }
 
+// TestRunStopsAtTaskBudget verifies that a task whose turns keep hitting
+// max_tokens is failed with stop_reason budget_exceeded once its cost
+// reaches MaxCostUSD, instead of auto-continuing forever.
+func TestRunStopsAtTaskBudget(t *testing.T) {
+	repo := setupTestRepo(t)
+	cmd := fakeCmdScript(t, maxTokensOutput, 0)
+	s, r := setupRunnerWithCmd(t, []string{repo}, cmd)
+	ctx := context.Background()
+
+	task, _ := s.CreateTask(ctx, "Test budget", 5, false)
+	s.SetTaskMaxCost(ctx, task.ID, 0.0025)
+	s.UpdateTaskStatus(ctx, task.ID, "in_progress")
+
+	r.Run(task.ID, "prompt", "", false)
+
+	updated, _ := s.GetTask(ctx, task.ID)
+	if updated.Status != "failed" {
+		t.Fatalf("expected status=failed, got %q", updated.Status)
+	}
+	if updated.StopReason == nil || *updated.StopReason != StopReasonBudgetExceeded {
+		t.Fatalf("expected stop_reason %q, got %v", StopReasonBudgetExceeded, updated.StopReason)
+	}
+	if updated.Turns != 3 {
+		t.Errorf("expected 3 turns ($0.001 each) before reaching $0.0025, got %d", updated.Turns)
+	}
+	if updated.Result == nil || *updated.Result != "partial result" {
+		t.Errorf("expected the last result to be kept, got %v", updated.Result)
+	}
+}
+
+// TestFailIfOverBudgetKeepsCancelledStatus verifies that a task cancelled
+// during the turn that used up its budget stays cancelled and gets no
+// failure events.
+func TestFailIfOverBudgetKeepsCancelledStatus(t *testing.T) {
+	s, r := setupTestRunner(t, nil)
+	ctx := context.Background()
+	task, _ := s.CreateTask(ctx, "p", 5, false)
+	s.SetTaskMaxCost(ctx, task.ID, 1)
+	s.AccumulateTaskUsage(ctx, task.ID, store.TaskUsage{CostUSD: 2})
+	s.UpdateTaskStatus(ctx, task.ID, "cancelled")
+
+	if !r.failIfOverBudget(task.ID, "", 1) {
+		t.Fatal("expected the over-budget task to stop")
+	}
+	got, _ := s.GetTask(ctx, task.ID)
+	if got.Status != "cancelled" || got.StopReason != nil {
+		t.Fatalf("status %q, stop_reason %v; want cancelled and unset", got.Status, got.StopReason)
+	}
+	if hasEvent(t, s, task.ID, "budget") {
+		t.Error("a budget failure event was recorded for a cancelled task")
+	}
+}
+
 // TestRunUnknownTaskDoesNotPanic verifies that Run handles a missing task
 // gracefully (returns without panicking; deferred status update is a no-op).
 func TestRunUnknownTaskDoesNotPanic(t *testing.T) {
@@ -257,6 +434,7 @@ func TestRunEndTurnRecordsResult(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	s.UpdateTaskStatus(ctx, task.ID, "in_progress")
 
 	r.Run(task.ID, "do the task", "", false)
 
@@ -267,6 +445,53 @@ func TestRunEndTurnRecordsResult(t *testing.T) {
 	if updated.SessionID == nil || *updated.SessionID == "" {
 		t.Fatal("expected session ID to be recorded")
 	}
+	if updated.StartedAt == nil || updated.EndedAt == nil || updated.ActiveSeconds <= 0 {
+		t.Errorf("timing not recorded: started %v, ended %v, active %gs",
+			updated.StartedAt, updated.EndedAt, updated.ActiveSeconds)
+	}
+	events, _ := s.GetEvents(ctx, task.ID)
+	timed := map[store.EventType]bool{}
+	for _, e := range events {
+		if strings.Contains(string(e.Data), `"duration_ms"`) {
+			timed[e.EventType] = true
+		}
+	}
+	if !timed[store.EventTypeOutput] || !timed[store.EventTypeStateChange] {
+		t.Errorf("duration_ms missing from output or state_change events: %v", timed)
+	}
+}
+
+// TestRunSessionChangeRecordsEvent verifies that a turn reporting a different
+// session ID than the one being resumed emits a system event and the new ID
+// is stored on the task.
+func TestRunSessionChangeRecordsEvent(t *testing.T) {
+	repo := setupTestRepo(t)
+	changed := `{"result":"task complete","session_id":"sess2","stop_reason":"end_turn","is_error":false,"total_cost_usd":0.001}`
+	cmd := fakeStatefulCmd(t, []string{maxTokensOutput, changed})
+	s, r := setupRunnerWithCmd(t, []string{repo}, cmd)
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "Session change test", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Run(task.ID, "prompt", "", false)
+
+	updated, _ := s.GetTask(ctx, task.ID)
+	if updated.SessionID == nil || *updated.SessionID != "sess2" {
+		t.Fatalf("expected session ID sess2, got %v", updated.SessionID)
+	}
+	events, _ := s.GetEvents(ctx, task.ID)
+	found := false
+	for _, ev := range events {
+		if ev.EventType == store.EventTypeSystem && strings.Contains(string(ev.Data), `"prev_session_id":"sess1"`) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected a system event recording the session change")
+	}
 }
 
 // ---------------------------------------------------------------------------
@@ -352,6 +577,52 @@ func TestSyncWorktreesBehindMain(t *testing.T) {
 	}
 }
 
+// TestSyncWorktreesOntoRecordedTarget verifies that a task whose target
+// branch was recorded as a non-default branch is synced onto that branch,
+// not onto the default branch.
+func TestSyncWorktreesOntoRecordedTarget(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, runner := setupTestRunner(t, []string{repo})
+	ctx := context.Background()
+
+	task, _ := s.CreateTask(ctx, "sync target test", 5, false)
+	wt, br, err := runner.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { runner.cleanupWorktrees(task.ID, wt, br) })
+	s.UpdateTaskWorktrees(ctx, task.ID, wt, br)
+	s.UpdateTaskTargetBranches(ctx, task.ID, map[string]string{repo: "release"})
+	s.UpdateTaskStatus(ctx, task.ID, "waiting")
+
+	// Advance both the default branch and the recorded target.
+	gitRun(t, repo, "branch", "release")
+	if err := os.WriteFile(filepath.Join(repo, "main.txt"), []byte("main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-m", "advance default branch")
+	gitRun(t, repo, "checkout", "release")
+	if err := os.WriteFile(filepath.Join(repo, "release.txt"), []byte("release\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-m", "advance release branch")
+	gitRun(t, repo, "checkout", "-")
+
+	runner.SyncWorktrees(task.ID, "", "waiting")
+
+	if updated, _ := s.GetTask(ctx, task.ID); updated.Status != "waiting" {
+		t.Fatalf("expected status=waiting after sync, got %q", updated.Status)
+	}
+	if _, err := os.Stat(filepath.Join(wt[repo], "release.txt")); err != nil {
+		t.Fatal("release.txt should be in the worktree after syncing onto release:", err)
+	}
+	if _, err := os.Stat(filepath.Join(wt[repo], "main.txt")); err == nil {
+		t.Fatal("main.txt must not be in the worktree: it was synced onto the default branch")
+	}
+}
+
 // TestSyncWorktreesNonGitWorkspaceSkipped verifies that non-git workspaces
 // are skipped during sync (logged as informational, not an error).
 func TestSyncWorktreesNonGitWorkspaceSkipped(t *testing.T) {
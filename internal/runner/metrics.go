@@ -0,0 +1,62 @@
+package runner
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// metrics counts runner activity since the server started. The counters
+// are exported through Metrics for GET /metrics.
+type metrics struct {
+	containersRun   atomic.Int64
+	commitSuccesses atomic.Int64
+	commitFailures  atomic.Int64
+	rebaseConflicts atomic.Int64
+	turns           atomic.Int64
+	turnNanos       atomic.Int64
+}
+
+// Metrics is a snapshot of the runner's activity counters.
+type Metrics struct {
+	// ContainersRun counts Claude Code invocations: task turns, conflict
+	// resolvers, and one-shot title and commit-message sandboxes.
+	ContainersRun int64
+	// CommitSuccesses and CommitFailures count finished commit pipelines;
+	// cancelled pipelines count as neither.
+	CommitSuccesses int64
+	CommitFailures  int64
+	// RebaseConflicts counts rebases of a task branch that stopped on a
+	// conflict, in the commit pipeline and in sync.
+	RebaseConflicts int64
+	// Turns is the number of task turns run and TurnDuration their total
+	// wall-clock time.
+	Turns        int64
+	TurnDuration time.Duration
+}
+
+// AvgTurnDuration is the mean duration of a task turn, or zero before the
+// first turn.
+func (m Metrics) AvgTurnDuration() time.Duration {
+	if m.Turns == 0 {
+		return 0
+	}
+	return m.TurnDuration / time.Duration(m.Turns)
+}
+
+// Metrics returns the current activity counters.
+func (r *Runner) Metrics() Metrics {
+	return Metrics{
+		ContainersRun:   r.metrics.containersRun.Load(),
+		CommitSuccesses: r.metrics.commitSuccesses.Load(),
+		CommitFailures:  r.metrics.commitFailures.Load(),
+		RebaseConflicts: r.metrics.rebaseConflicts.Load(),
+		Turns:           r.metrics.turns.Load(),
+		TurnDuration:    time.Duration(r.metrics.turnNanos.Load()),
+	}
+}
+
+// recordTurn adds one task turn that took d.
+func (m *metrics) recordTurn(d time.Duration) {
+	m.turns.Add(1)
+	m.turnNanos.Add(int64(d))
+}
@@ -2,10 +2,13 @@ package runner
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -71,6 +74,40 @@ func TestKillContainer(t *testing.T) {
 	r.KillContainer(uuid.New())
 }
 
+// TestResetClaudeConfig verifies that every wallfacer sandbox is removed
+// once no task is active, and that nothing is removed while one is.
+func TestResetClaudeConfig(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "rm.log")
+	script := filepath.Join(dir, "fake-cmd")
+	os.WriteFile(script, []byte(fmt.Sprintf(`#!/bin/sh
+case "$2" in
+  ls) echo '{"vms":[{"name":"wf-aaaaaaaa"},{"name":"other"},{"name":"wf-bbbbbbbb"}]}' ;;
+  rm) echo "$3" >> %s ;;
+esac
+`, logPath)), 0755)
+	s, r := setupRunnerWithCmd(t, nil, script)
+
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	s.UpdateTaskStatus(bg(), task.ID, "waiting")
+	if _, err := r.ResetClaudeConfig(); !errors.Is(err, ErrTasksActive) {
+		t.Fatalf("ResetClaudeConfig with a waiting task = %v, want ErrTasksActive", err)
+	}
+	if _, err := os.Stat(logPath); err == nil {
+		t.Fatal("sandboxes were removed while a task was active")
+	}
+
+	s.UpdateTaskStatus(bg(), task.ID, "done")
+	removed, err := r.ResetClaudeConfig()
+	if err != nil || removed != 2 {
+		t.Fatalf("ResetClaudeConfig = %d, %v; want 2, nil", removed, err)
+	}
+	got, _ := os.ReadFile(logPath)
+	if string(got) != "wf-aaaaaaaa\nwf-bbbbbbbb\n" {
+		t.Fatalf("removed sandboxes %q, want the two wallfacer ones", got)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // isConflictError
 // ---------------------------------------------------------------------------
@@ -280,6 +317,31 @@ func TestPruneOrphanedWorktrees(t *testing.T) {
 	}
 }
 
+// TestPruneOrphanedWorktreesNearRepo verifies that orphaned task directories
+// next to a workspace are pruned while unrelated directories are left alone.
+func TestPruneOrphanedWorktreesNearRepo(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, runner := setupTestRunner(t, []string{repo})
+
+	root := filepath.Join(filepath.Dir(repo), adjacentWorktreesDirName)
+	orphanDir := filepath.Join(root, uuid.New().String())
+	otherDir := filepath.Join(root, "not-a-task")
+	for _, d := range []string{orphanDir, otherDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runner.PruneOrphanedWorktrees(s)
+
+	if _, err := os.Stat(orphanDir); !os.IsNotExist(err) {
+		t.Fatal("orphan worktree dir next to the repo should be pruned")
+	}
+	if _, err := os.Stat(otherDir); err != nil {
+		t.Fatal("non-task dir next to the repo should be preserved:", err)
+	}
+}
+
 // TestPruneOrphanedWorktreesMissingDir verifies PruneOrphanedWorktrees handles
 // a missing worktrees directory gracefully (no panic).
 func TestPruneOrphanedWorktreesMissingDir(t *testing.T) {
@@ -375,6 +437,95 @@ func TestRunContainerEmptyOutputZeroExit(t *testing.T) {
 	}
 }
 
+// TestRunContainerExitCodeDecodesSignal verifies that an exit status of
+// 128+N (how container runtimes report a killed child) is surfaced as
+// signal N.
+func TestRunContainerExitCodeDecodesSignal(t *testing.T) {
+	cmd := fakeCmdScript(t, "", 137)
+	r := runnerWithCmd(t, cmd)
+
+	_, _, _, err := r.runContainer(context.Background(), uuid.New(), "prompt", "", nil, "", nil)
+	var exitErr *containerExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected *containerExitError, got: %v", err)
+	}
+	if exitErr.ExitCode != 137 || exitErr.Signal != syscall.SIGKILL {
+		t.Fatalf("expected exit 137 / SIGKILL, got exit %d signal %d", exitErr.ExitCode, exitErr.Signal)
+	}
+	data := exitErr.eventData()
+	if data["exit_code"] != "137" || data["signal"] != "9" {
+		t.Fatalf("unexpected event data: %v", data)
+	}
+}
+
+// TestRunContainerRetriesFailedStart verifies that a container the runtime
+// failed to start (exit 125, no output) is retried with an event per retry,
+// while a non-zero exit of Claude Code itself is returned at once.
+func TestRunContainerRetriesFailedStart(t *testing.T) {
+	orig := containerStartBackoff
+	containerStartBackoff = time.Millisecond
+	t.Cleanup(func() { containerStartBackoff = orig })
+
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "attempts")
+	scriptPath := filepath.Join(dir, "flaky-cmd")
+	script := fmt.Sprintf(`#!/bin/sh
+case "$1" in sandbox) case "$2" in create|stop|rm|ls) exit 0 ;; esac ;; esac
+echo x >> %s
+if [ "$(wc -l < %s)" -lt 3 ]; then echo "layer already exists" >&2; exit 125; fi
+echo '%s'
+`, counter, counter, validStreamJSON)
+	if err := os.WriteFile(scriptPath, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r := runnerWithCmd(t, scriptPath)
+	task, _ := r.store.CreateTask(context.Background(), "p", 5, false)
+
+	output, _, _, err := r.runContainer(context.Background(), task.ID, "prompt", "", nil, "", nil)
+	if err != nil || output.SessionID != "abc123" {
+		t.Fatalf("runContainer = %+v, %v; want the output of the third attempt", output, err)
+	}
+	events, _ := r.store.GetEvents(context.Background(), task.ID)
+	retries := 0
+	for _, ev := range events {
+		if strings.Contains(string(ev.Data), "Container failed to start") {
+			retries++
+		}
+	}
+	if retries != 2 {
+		t.Errorf("retry events = %d, want 2", retries)
+	}
+
+	// Claude Code exiting non-zero is not a start failure.
+	r = runnerWithCmd(t, fakeCmdScript(t, "", 1))
+	_, _, _, err = r.runContainer(context.Background(), uuid.New(), "prompt", "", nil, "", nil)
+	var startErr *containerStartError
+	if err == nil || errors.As(err, &startErr) {
+		t.Fatalf("exit 1: err = %v, want a plain container error", err)
+	}
+}
+
+// TestRunContainerKilledBySignal verifies that a process terminated by a
+// signal reports that signal rather than an opaque error.
+func TestRunContainerKilledBySignal(t *testing.T) {
+	dir := t.TempDir()
+	scriptPath := filepath.Join(dir, "killed-cmd")
+	script := "#!/bin/sh\ncase \"$1\" in sandbox) case \"$2\" in create|stop|rm|ls) exit 0 ;; esac ;; esac\nkill -TERM $$\n"
+	if err := os.WriteFile(scriptPath, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r := runnerWithCmd(t, scriptPath)
+
+	_, _, _, err := r.runContainer(context.Background(), uuid.New(), "prompt", "", nil, "", nil)
+	var exitErr *containerExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected *containerExitError, got: %v", err)
+	}
+	if exitErr.Signal != syscall.SIGTERM {
+		t.Fatalf("expected SIGTERM, got signal %d (%v)", exitErr.Signal, err)
+	}
+}
+
 // TestRunContainerSessionID verifies that a non-empty sessionID is passed to
 // the container args as --resume.
 func TestRunContainerWithSessionID(t *testing.T) {
@@ -391,6 +542,253 @@ func TestRunContainerWithSessionID(t *testing.T) {
 	}
 }
 
+// TestWrapPrompt verifies that the configured prefix and suffix surround the
+// prompt and that empty auto-continue prompts are left untouched.
+func TestWrapPrompt(t *testing.T) {
+	r := runnerWithCmd(t, "echo")
+	if got := r.WrapPrompt("do it"); got != "do it" {
+		t.Fatalf("expected prompt unchanged without config, got %q", got)
+	}
+
+	r.promptPrefix = "Always run the linter."
+	r.promptSuffix = "Keep changes small.\n"
+	want := "Always run the linter.\n\ndo it\n\nKeep changes small."
+	if got := r.WrapPrompt("do it"); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if got := r.WrapPrompt(""); got != "" {
+		t.Fatalf("expected empty prompt to stay empty, got %q", got)
+	}
+}
+
+// TestTaskModelPrefersTaskOverride verifies that a task's own model takes
+// precedence over the env-file default.
+func TestTaskModelPrefersTaskOverride(t *testing.T) {
+	s, r := setupRunnerWithCmd(t, nil, "echo")
+	envFile := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(envFile, []byte("CLAUDE_CODE_MODEL=sonnet\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r.envFile = envFile
+
+	task, err := s.CreateTask(context.Background(), "p", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.taskModel(task.ID); got != "sonnet" {
+		t.Fatalf("expected env default sonnet, got %q", got)
+	}
+	s.SetTaskModel(context.Background(), task.ID, "opus")
+	if got := r.taskModel(task.ID); got != "opus" {
+		t.Fatalf("expected task override opus, got %q", got)
+	}
+}
+
+// TestModelAllowed verifies the model allowlist: anything goes without one,
+// otherwise only listed models and the env-file default.
+func TestModelAllowed(t *testing.T) {
+	_, r := setupRunnerWithCmd(t, nil, "echo")
+	envFile := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(envFile, []byte("CLAUDE_CODE_MODEL=sonnet\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r.envFile = envFile
+
+	if !r.ModelAllowed("anything") {
+		t.Error("expected any model to be allowed without an allowlist")
+	}
+	r.allowedModels = []string{"haiku"}
+	for model, want := range map[string]bool{"haiku": true, "sonnet": true, "opus": false} {
+		if got := r.ModelAllowed(model); got != want {
+			t.Errorf("ModelAllowed(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
+
+// TestTaskImageAndAllowlist verifies the per-task sandbox image override,
+// the allowlist check, and the resulting sandbox create arguments.
+func TestTaskImageAndAllowlist(t *testing.T) {
+	s, r := setupRunnerWithCmd(t, nil, "echo")
+	r.sandboxImage = "claude-base:latest"
+	r.allowedImages = []string{"claude-go:1.22"}
+
+	for image, want := range map[string]bool{
+		"claude-base:latest": true,
+		"claude-go:1.22":     true,
+		"claude-rust:1.80":   false,
+	} {
+		if got := r.SandboxImageAllowed(image); got != want {
+			t.Errorf("SandboxImageAllowed(%q) = %v, want %v", image, got, want)
+		}
+	}
+
+	task, err := s.CreateTask(context.Background(), "p", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.taskSandboxOptions(task.ID).image; got != "claude-base:latest" {
+		t.Fatalf("expected global image, got %q", got)
+	}
+	s.SetTaskSandboxImage(context.Background(), task.ID, "claude-go:1.22")
+	if got := r.taskSandboxOptions(task.ID).image; got != "claude-go:1.22" {
+		t.Fatalf("expected task image, got %q", got)
+	}
+
+	args := strings.Join(sandboxCreateArgs("wf-1", sandboxOptions{image: "claude-go:1.22"}, []string{"/w"}), " ")
+	if args != "sandbox create --name wf-1 --template claude-go:1.22 claude /w" {
+		t.Errorf("unexpected create args: %s", args)
+	}
+	args = strings.Join(sandboxCreateArgs("wf-1", sandboxOptions{}, []string{"/w"}), " ")
+	if args != "sandbox create --name wf-1 claude /w" {
+		t.Errorf("unexpected create args without image: %s", args)
+	}
+}
+
+func TestExecInSandboxPassesTaskEnv(t *testing.T) {
+	s, r := setupTestRunner(t, nil)
+	task, err := s.CreateTask(context.Background(), "p", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetTaskEnv(context.Background(), task.ID, map[string]string{"B": "2", "A": "x=1"})
+
+	// The test runner's command is echo, so stdout holds the arguments.
+	_, stdout, _, _ := r.execInSandbox(context.Background(), task.ID, "hi", "", "")
+	if !strings.Contains(string(stdout), "sandbox exec -e A=x=1 -e B=2 ") {
+		t.Errorf("exec args = %s", stdout)
+	}
+
+	got := strings.Join(redactEnvArgs([]string{"sandbox", "exec", "-e", "A=x=1", "-w", "/w"}), " ")
+	if got != "sandbox exec -e A=*** -w /w" {
+		t.Errorf("redacted args = %s", got)
+	}
+}
+
+func TestExecInSandboxRecordsCommand(t *testing.T) {
+	s, r := setupTestRunner(t, nil)
+	task, err := s.CreateTask(context.Background(), "p", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetTaskEnv(context.Background(), task.ID, map[string]string{"TOKEN": "secret"})
+	r.CreateSandbox(context.Background(), task.ID, []string{"/w"})
+	const prompt = "fix it's bug"
+	r.execInSandbox(context.Background(), task.ID, prompt, "", "/w")
+	r.execInSandbox(context.Background(), task.ID, prompt, "", "/w")
+
+	events, err := s.GetEventsFiltered(context.Background(), task.ID, store.EventFilter{
+		Types: []store.EventType{store.EventTypeContainerStart},
+	})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("container_start events = %d, %v; want 2", len(events), err)
+	}
+	var ev store.ContainerStart
+	if err := json.Unmarshal(events[1].Data, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(events[1].Data), "secret") {
+		t.Errorf("env value leaked into the event: %s", events[1].Data)
+	}
+	if strings.Contains(string(events[1].Data), "bug") {
+		t.Errorf("prompt repeated in the event: %s", events[1].Data)
+	}
+
+	// The prompt is saved once and the command reads it back.
+	if got, err := s.ReadOutput(task.ID, ev.PromptFile); err != nil || string(got) != prompt {
+		t.Fatalf("prompt file %q = %q, %v", ev.PromptFile, got, err)
+	}
+	if outputs, _ := s.ListOutputs(task.ID); len(outputs) != 1 {
+		t.Errorf("outputs = %v, want the one prompt file", outputs)
+	}
+	promptPath := filepath.Join(s.OutputsDir(task.ID), ev.PromptFile)
+	wantPrefix := "echo sandbox exec -e 'TOKEN=***' -w /w wf-" + task.ID.String()[:8] + ` claude -p "$(cat ` + promptPath + `)" --verbose `
+	if !strings.HasPrefix(ev.Command, wantPrefix) {
+		t.Errorf("command = %s\nwant prefix %s", ev.Command, wantPrefix)
+	}
+	if len(ev.Args) == 0 || ev.Args[0] != "echo" {
+		t.Errorf("args = %v", ev.Args)
+	}
+	if ev.Sandbox != "echo sandbox create --name wf-"+task.ID.String()[:8]+" claude /w" {
+		t.Errorf("sandbox = %q", ev.Sandbox)
+	}
+}
+
+func TestSandboxReferenceMounts(t *testing.T) {
+	s, r := setupTestRunner(t, nil)
+	r.refMounts = []string{"/docs", "/src/sibling"}
+	task, err := s.CreateTask(context.Background(), "p", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := strings.Join(sandboxCreateArgs("wf-1", r.taskSandboxOptions(task.ID), []string{"/w"}), " ")
+	if args != "sandbox create --name wf-1 claude /w /docs:ro /src/sibling:ro" {
+		t.Errorf("unexpected create args: %s", args)
+	}
+	want := "Read-only reference directories (read them for context; they cannot be modified): /docs, /src/sibling\n\ndo it"
+	if got := r.WrapPrompt("do it"); got != want {
+		t.Errorf("WrapPrompt = %q, want %q", got, want)
+	}
+}
+
+func TestSandboxNetworkMode(t *testing.T) {
+	s, r := setupTestRunner(t, nil)
+	task, err := s.CreateTask(context.Background(), "p", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for mode, want := range map[string]string{
+		"":            "sandbox create --name wf-1 claude /w",
+		NetworkHost:   "sandbox create --name wf-1 claude /w",
+		NetworkBridge: "sandbox create --name wf-1 --network bridge claude /w",
+		NetworkNone:   "sandbox create --name wf-1 --network none claude /w",
+	} {
+		r.networkMode = mode
+		args := strings.Join(sandboxCreateArgs("wf-1", r.taskSandboxOptions(task.ID), []string{"/w"}), " ")
+		if args != want {
+			t.Errorf("network %q: create args = %s, want %s", mode, args, want)
+		}
+	}
+}
+
+func TestSandboxResourceLimits(t *testing.T) {
+	s, r := setupTestRunner(t, nil)
+	r.memoryLimit = "4g"
+	r.cpuLimit = "2"
+
+	task, err := s.CreateTask(context.Background(), "p", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts := r.taskSandboxOptions(task.ID); opts.memory != "4g" || opts.cpus != "2" {
+		t.Fatalf("expected global limits, got %+v", opts)
+	}
+	s.SetTaskResourceLimits(context.Background(), task.ID, "512m", "")
+	opts := r.taskSandboxOptions(task.ID)
+	if opts.memory != "512m" || opts.cpus != "2" {
+		t.Fatalf("expected task memory and global cpus, got %+v", opts)
+	}
+
+	args := strings.Join(sandboxCreateArgs("wf-1", opts, []string{"/w"}), " ")
+	if args != "sandbox create --name wf-1 --memory 512m --cpus 2 claude /w" {
+		t.Errorf("unexpected create args: %s", args)
+	}
+	if got := opts.describe(); got != "image default, memory limit 512m, CPU limit 2, network host" {
+		t.Errorf("describe = %q", got)
+	}
+
+	for v, want := range map[string]bool{"": true, "4g": true, "512M": true, "1.5g": true, "1024": true, "4gb": false, "-1g": false, "lots": false} {
+		if got := ValidMemoryLimit(v); got != want {
+			t.Errorf("ValidMemoryLimit(%q) = %v, want %v", v, got, want)
+		}
+	}
+	for v, want := range map[string]bool{"": true, "2": true, "0.5": true, "0": false, "-1": false, "two": false} {
+		if got := ValidCPULimit(v); got != want {
+			t.Errorf("ValidCPULimit(%q) = %v, want %v", v, got, want)
+		}
+	}
+}
+
 // ---------------------------------------------------------------------------
 // GenerateTitle
 // ---------------------------------------------------------------------------
@@ -417,6 +815,67 @@ func TestGenerateTitleSuccess(t *testing.T) {
 	}
 }
 
+// TestGenerateTitleContextReturnsTitle verifies that the synchronous form
+// returns the persisted title and surfaces container errors.
+func TestGenerateTitleContextReturnsTitle(t *testing.T) {
+	cmd := fakeCmdScript(t, titleOutput, 0)
+	s, r := setupRunnerWithCmd(t, nil, cmd)
+	ctx := context.Background()
+
+	task, _ := s.CreateTask(ctx, "Fix the login bug", 5, false)
+	title, err := r.GenerateTitleContext(ctx, task.ID, task.Prompt)
+	if err != nil || title != "Fix Login Bug" {
+		t.Fatalf("GenerateTitleContext = %q, %v", title, err)
+	}
+
+	failing := fakeCmdScript(t, "", 1)
+	s2, r2 := setupRunnerWithCmd(t, nil, failing)
+	task2, _ := s2.CreateTask(ctx, "test prompt", 5, false)
+	if _, err := r2.GenerateTitleContext(ctx, task2.ID, task2.Prompt); err == nil {
+		t.Fatal("expected error when the container fails")
+	}
+}
+
+// TestCancelTitleGeneration verifies that starting a task aborts its
+// in-flight title generation instead of letting both sandboxes run.
+func TestCancelTitleGeneration(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "slow-cmd")
+	body := "#!/bin/sh\nif [ \"$2\" = exec ]; then exec sleep 30; fi\nexit 0\n"
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s, r := setupRunnerWithCmd(t, nil, script)
+	ctx := context.Background()
+	task, _ := s.CreateTask(ctx, "test prompt", 5, false)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := r.GenerateTitleContext(ctx, task.ID, task.Prompt)
+		errc <- err
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.cancelTitleGeneration(task.ID) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("title generation was never tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("expected cancelled generation to fail")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("title generation was not aborted")
+	}
+	if updated, _ := s.GetTask(ctx, task.ID); updated.Title != "" {
+		t.Errorf("expected no title after cancel, got %q", updated.Title)
+	}
+}
+
 // TestGenerateTitleSkipsExistingTitle verifies that GenerateTitle is a no-op
 // when the task already has a title.
 func TestGenerateTitleSkipsExistingTitle(t *testing.T) {
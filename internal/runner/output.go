@@ -0,0 +1,54 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// saveTurnOutput persists a turn's raw stdout/stderr, bounding each stream
+// to the configured MaxTurnOutputBytes. Callers parse the full in-memory
+// output; only the stored artifact is truncated, and a system event records
+// how much was dropped.
+func (r *Runner) saveTurnOutput(taskID uuid.UUID, turn int, stdout, stderr []byte) error {
+	stdout, outElided := elideMiddle(stdout, r.maxTurnOutput)
+	stderr, errElided := elideMiddle(stderr, r.maxTurnOutput)
+	if outElided > 0 || errElided > 0 {
+		logger.Runner.Warn("turn output truncated", "task", taskID, "turn", turn,
+			"stdout_elided", outElided, "stderr_elided", errElided, "limit", r.maxTurnOutput)
+		r.store.InsertEvent(context.Background(), taskID, store.EventTypeSystem, map[string]string{
+			"result": fmt.Sprintf("Turn %d output exceeded %d bytes; stored copy truncated "+
+				"(%d stdout and %d stderr bytes elided).", turn, r.maxTurnOutput, outElided, errElided),
+		})
+	}
+	return r.store.SaveTurnOutput(taskID, turn, stdout, stderr)
+}
+
+// elideMiddle keeps roughly the first and last max/2 bytes of b, joined by
+// a marker line, and returns the number of bytes dropped. Cut points move to
+// line boundaries when one is in range so NDJSON lines, in particular the
+// final result line, are kept whole. A max of zero or less disables it.
+func elideMiddle(b []byte, max int64) ([]byte, int64) {
+	if max <= 0 || int64(len(b)) <= max {
+		return b, 0
+	}
+	headEnd := int(max / 2)
+	tailStart := len(b) - int(max-max/2)
+	if i := bytes.LastIndexByte(b[:headEnd], '\n'); i >= 0 {
+		headEnd = i + 1
+	}
+	if i := bytes.IndexByte(b[tailStart:], '\n'); i >= 0 && tailStart+i+1 < len(b) {
+		tailStart += i + 1
+	}
+	elided := int64(tailStart - headEnd)
+	marker := fmt.Sprintf("\n... [%d bytes elided] ...\n", elided)
+	out := make([]byte, 0, headEnd+len(marker)+len(b)-tailStart)
+	out = append(out, b[:headEnd]...)
+	out = append(out, marker...)
+	out = append(out, b[tailStart:]...)
+	return out, elided
+}
@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"changkun.de/wallfacer/internal/store"
+)
+
+// TestElideMiddle verifies that oversized output keeps whole head and tail
+// lines around an elision marker and that small output is untouched.
+func TestElideMiddle(t *testing.T) {
+	small := []byte("line1\nline2\n")
+	if got, n := elideMiddle(small, 100); n != 0 || !bytes.Equal(got, small) {
+		t.Fatalf("small output changed: %q (%d elided)", got, n)
+	}
+	if got, n := elideMiddle(small, 0); n != 0 || !bytes.Equal(got, small) {
+		t.Fatalf("limit 0 should disable truncation, got %q", got)
+	}
+
+	var b bytes.Buffer
+	for i := 0; i < 100; i++ {
+		b.WriteString(`{"type":"assistant","text":"xxxxxxxxxxxxxxxxxxxx"}` + "\n")
+	}
+	b.WriteString(endTurnOutput + "\n")
+	raw := b.Bytes()
+
+	got, n := elideMiddle(raw, 400)
+	if n == 0 {
+		t.Fatal("expected truncation")
+	}
+	if int64(len(got)) >= int64(len(raw)) {
+		t.Fatalf("truncated output (%d) not smaller than original (%d)", len(got), len(raw))
+	}
+	if !bytes.Contains(got, []byte("bytes elided")) {
+		t.Fatalf("missing elision marker: %q", got)
+	}
+	if !bytes.HasPrefix(got, raw[:strings.IndexByte(string(raw), '\n')+1]) {
+		t.Fatal("head line not kept whole")
+	}
+	if out, err := parseOutput(string(got)); err != nil || out.StopReason != "end_turn" {
+		t.Fatalf("result line lost after truncation: %v %+v", err, out)
+	}
+}
+
+// TestRunTruncatesStoredTurnOutput verifies that a turn exceeding
+// MaxTurnOutputBytes is stored truncated with an event, while the task still
+// completes from the full output.
+func TestRunTruncatesStoredTurnOutput(t *testing.T) {
+	repo := setupTestRepo(t)
+	output := strings.Repeat(`{"type":"assistant","text":"noise"}`+"\n", 1000) + endTurnOutput + "\n"
+	cmd := fakeCmdScript(t, output, 0)
+	s, r := setupRunnerWithCmd(t, []string{repo}, cmd)
+	r.maxTurnOutput = 2048
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "Test truncation", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Run(task.ID, "do the task", "", false)
+
+	updated, _ := s.GetTask(ctx, task.ID)
+	if updated.Status != "done" {
+		t.Fatalf("expected status=done, got %q", updated.Status)
+	}
+	stored, err := s.ReadOutput(task.ID, "turn-0001.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) > 2048+64 {
+		t.Fatalf("stored output is %d bytes, want about 2048", len(stored))
+	}
+
+	events, _ := s.GetEvents(ctx, task.ID)
+	found := false
+	for _, e := range events {
+		if e.EventType == store.EventTypeSystem && strings.Contains(string(e.Data), "truncated") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected a truncation event")
+	}
+}
@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"changkun.de/wallfacer/internal/gitutil"
+	"changkun.de/wallfacer/internal/store"
+)
+
+// openPullRequest lands a rebased task branch under
+// MergeStrategyPullRequest: it pushes the branch to origin and opens a pull
+// request against target instead of merging into it locally. The PR URL is
+// stored on the task and the task's head commit is recorded in commitHashes.
+func (r *Runner) openPullRequest(
+	task *store.Task,
+	repoPath, worktreePath, branchName, target string,
+	commitHashes map[string]string,
+	timer *phaseTimer,
+) error {
+	bgCtx := context.Background()
+	remoteURL, err := gitutil.RemoteURL(repoPath, "origin")
+	if err != nil {
+		return fmt.Errorf("pull-request strategy needs an origin remote in %s: %w", repoPath, err)
+	}
+	provider, err := r.forgeFor(remoteURL)
+	if err != nil {
+		return fmt.Errorf("open pull request for %s: %w", repoPath, err)
+	}
+
+	r.store.InsertEvent(bgCtx, task.ID, store.EventTypeSystem, map[string]string{
+		"result": fmt.Sprintf("Pushing %s of %s to origin...", branchName, repoPath),
+	})
+	start := time.Now()
+	if err := gitutil.ForcePush(repoPath, "origin", branchName); err != nil {
+		timer.track("push", repoPath, start)
+		return fmt.Errorf("push %s: %w", branchName, err)
+	}
+	timer.track("push", repoPath, start)
+
+	start = time.Now()
+	url, err := provider.OpenPullRequest(remoteURL, branchName, target, pullRequestTitle(task), pullRequestBody(task))
+	timer.track("pull_request", repoPath, start)
+	if err != nil {
+		return fmt.Errorf("open pull request for %s: %w", repoPath, err)
+	}
+	if err := r.store.SetTaskPullRequestURL(bgCtx, task.ID, repoPath, url); err != nil {
+		return fmt.Errorf("save pull request URL: %w", err)
+	}
+	if hash, err := gitutil.GetCommitHash(worktreePath); err == nil {
+		commitHashes[repoPath] = hash
+	}
+	r.store.InsertEvent(bgCtx, task.ID, store.EventTypeSystem, map[string]string{
+		"result": fmt.Sprintf("Opened pull request for %s into %s: %s", repoPath, target, url),
+	})
+	return nil
+}
+
+// pullRequestTitle is the task title, or the first line of its prompt cut
+// to a subject-line length when the task has no title yet.
+func pullRequestTitle(t *store.Task) string {
+	if t.Title != "" {
+		return t.Title
+	}
+	title, _, _ := strings.Cut(strings.TrimSpace(t.Prompt), "\n")
+	if r := []rune(title); len(r) > 72 {
+		title = string(r[:69]) + "..."
+	}
+	return title
+}
+
+// pullRequestBody quotes the task prompt and names the task it came from.
+func pullRequestBody(t *store.Task) string {
+	return strings.TrimSpace(t.Prompt) + "\n\n---\nOpened by Wallfacer for task " + t.ID.String() + "."
+}
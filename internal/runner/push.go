@@ -0,0 +1,121 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"changkun.de/wallfacer/internal/gitutil"
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// pushMerged pushes the branch each changed repo was merged into to origin.
+// A rejected push is retried once after rebasing the branch onto the remote
+// tip, which rewrites the task's commits, so their recorded hashes are
+// updated after such a retry. Failures are reported as error events; the task's merge stands
+// either way, so they do not fail the commit pipeline.
+func (r *Runner) pushMerged(taskID uuid.UUID, commitHashes map[string]string, timer *phaseTimer) {
+	bgCtx := context.Background()
+	task, err := r.store.GetTask(bgCtx, taskID)
+	if err != nil {
+		return
+	}
+	for repoPath := range commitHashes {
+		if !gitutil.IsGitRepo(repoPath) || !gitutil.HasRemote(repoPath, "origin") {
+			continue
+		}
+		branch := task.TargetBranches[repoPath]
+		if branch == "" {
+			if branch, err = gitutil.CurrentBranch(repoPath); err != nil {
+				logger.Runner.Warn("auto-push: current branch", "task", taskID, "repo", repoPath, "error", err)
+				continue
+			}
+		}
+
+		mu := r.repoLock(repoPath)
+		mu.Lock()
+		pushStart := time.Now()
+		err := r.pushBranch(taskID, repoPath, branch)
+		timer.track("push", repoPath, pushStart)
+		mu.Unlock()
+		if err != nil {
+			logger.Runner.Error("auto-push failed", "task", taskID, "repo", repoPath, "error", err)
+			r.store.InsertEvent(bgCtx, taskID, store.EventTypeError, map[string]string{
+				"error": fmt.Sprintf("auto-push of %s to origin failed: %v", branch, err),
+			})
+		}
+	}
+}
+
+// pushBranch pushes branch to origin, pulling with rebase and retrying once
+// when the remote has moved on. Caller must hold the repo lock.
+func (r *Runner) pushBranch(taskID uuid.UUID, repoPath, branch string) error {
+	bgCtx := context.Background()
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+		"result": fmt.Sprintf("Pushing %s of %s to origin...", branch, repoPath),
+	})
+	err := gitutil.Push(repoPath, "origin", branch)
+	if errors.Is(err, gitutil.ErrPushRejected) {
+		r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+			"result": fmt.Sprintf("Push rejected: origin/%s has new commits. Rebasing onto it and retrying...", branch),
+		})
+		if err := gitutil.PullRebase(repoPath, branch); err != nil {
+			return fmt.Errorf("rebase onto origin/%s: %w", branch, err)
+		}
+		if err = gitutil.Push(repoPath, "origin", branch); err == nil {
+			r.rerecordHashes(taskID, repoPath, branch)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	hash, _ := gitutil.GetCommitHashForRef(repoPath, branch)
+	if len(hash) > 8 {
+		hash = hash[:8]
+	}
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+		"result": fmt.Sprintf("Pushed %s of %s to origin at %s.", branch, repoPath, hash),
+	})
+	return nil
+}
+
+// rerecordHashes updates the commit and base hashes recorded for repoPath
+// after the task's commits on branch were rebased onto the tip just fetched
+// from origin, so the task's diff and commit links point at what was pushed.
+func (r *Runner) rerecordHashes(taskID uuid.UUID, repoPath, branch string) {
+	bgCtx := context.Background()
+	task, err := r.store.GetTask(bgCtx, taskID)
+	if err != nil {
+		return
+	}
+	head, err := gitutil.GetCommitHashForRef(repoPath, branch)
+	if err != nil {
+		logger.Runner.Warn("auto-push: rebased head", "task", taskID, "repo", repoPath, "error", err)
+		return
+	}
+	commitHashes := maps.Clone(task.CommitHashes)
+	if commitHashes == nil {
+		commitHashes = make(map[string]string)
+	}
+	commitHashes[repoPath] = head
+	if err := r.store.UpdateTaskCommitHashes(bgCtx, taskID, commitHashes); err != nil {
+		logger.Runner.Warn("save rebased commit hashes", "task", taskID, "error", err)
+	}
+	if _, ok := task.BaseCommitHashes[repoPath]; !ok {
+		return
+	}
+	base, err := gitutil.GetCommitHashForRef(repoPath, "FETCH_HEAD")
+	if err != nil {
+		logger.Runner.Warn("auto-push: rebased base", "task", taskID, "repo", repoPath, "error", err)
+		return
+	}
+	baseHashes := maps.Clone(task.BaseCommitHashes)
+	baseHashes[repoPath] = base
+	if err := r.store.UpdateTaskBaseCommitHashes(bgCtx, taskID, baseHashes); err != nil {
+		logger.Runner.Warn("save rebased base commit hashes", "task", taskID, "error", err)
+	}
+}
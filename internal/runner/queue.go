@@ -0,0 +1,285 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// queuePollInterval is how often a queued task re-checks whether it was
+// cancelled or deleted while waiting for a slot. A variable so tests can
+// shorten it.
+var queuePollInterval = time.Second
+
+// RunnerStatus reports how many tasks are running and queued.
+type RunnerStatus struct {
+	Running       int  `json:"running"`
+	Queued        int  `json:"queued"`
+	MaxConcurrent int  `json:"max_concurrent"` // 0 means unlimited
+	Autopilot     bool `json:"autopilot"`
+	AuthFailed    bool `json:"auth_failed"` // tasks are held for a rejected token
+}
+
+// Status returns the current running and queued task counts, whether
+// autopilot is enabled, and whether tasks are held for a rejected token.
+func (r *Runner) Status() RunnerStatus {
+	return RunnerStatus{
+		Running:       int(r.running.Load()),
+		Queued:        int(r.queued.Load()),
+		MaxConcurrent: cap(r.slots),
+		Autopilot:     r.Autopilot(),
+		AuthFailed:    r.AuthFailed(),
+	}
+}
+
+// ErrNotQueued is returned by SetRunQueue for a task that is not waiting
+// in the run queue.
+var ErrNotQueued = errors.New("task is not in the run queue")
+
+// acquireSlot blocks until taskID may run under the MaxConcurrent limit and
+// returns a function that gives the slot back. While no slot is free the
+// task is moved to "queued" and appended to the run queue, which hands out
+// slots in order; the task moves back to "in_progress" once it gets one. A
+// task that is already queued, i.e. resumed by ResumeQueue, keeps its
+// place. Returns false if the task was cancelled or deleted before it was
+// queued or while it waited, in which case the caller must stop without
+// touching its status.
+func (r *Runner) acquireSlot(taskID uuid.UUID) (release func(), ok bool) {
+	release = func() {
+		if r.slots != nil {
+			<-r.slots
+		}
+		r.running.Add(-1)
+	}
+	bgCtx := context.Background()
+	resumed := false
+	if task, err := r.store.GetTask(bgCtx, taskID); err == nil {
+		resumed = task.Status == "queued"
+	}
+	if r.slots == nil {
+		r.running.Add(1)
+		if resumed && !r.leaveQueue(taskID) {
+			release()
+			return nil, false
+		}
+		return release, true
+	}
+	if !resumed && r.queueHead() == uuid.Nil {
+		select {
+		case r.slots <- struct{}{}:
+			r.running.Add(1)
+			return release, true
+		default:
+		}
+	}
+
+	r.queued.Add(1)
+	defer r.queued.Add(-1)
+	if !resumed {
+		logger.Runner.Info("concurrency limit reached, queueing task", "task", taskID, "limit", cap(r.slots))
+		if ok, err := r.store.CompareAndSetStatus(bgCtx, taskID, "in_progress", "queued"); err != nil || !ok {
+			return nil, false
+		}
+		r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
+			"from": "in_progress", "to": "queued",
+		})
+		r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+			"result": fmt.Sprintf("%d task(s) already running (limit %d). "+
+				"The task starts automatically once a slot frees.", len(r.slots), cap(r.slots)),
+		})
+	}
+	if err := r.store.EnqueueRun(bgCtx, taskID); err != nil {
+		logger.Runner.Warn("save run queue", "task", taskID, "error", err)
+	}
+
+	subID, changed := r.store.Subscribe()
+	defer r.store.Unsubscribe(subID)
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+	for {
+		// Only the head of the queue competes for a slot; a nil channel
+		// never receives, so the others wait for the queue to move.
+		var slot chan struct{}
+		if r.queueHead() == taskID {
+			slot = r.slots
+		}
+		select {
+		case slot <- struct{}{}:
+			r.running.Add(1)
+			// A cancel may have landed just before the slot did.
+			if !r.leaveQueue(taskID) {
+				release()
+				return nil, false
+			}
+			return release, true
+		case <-changed:
+		case <-ticker.C:
+		}
+		if task, err := r.store.GetTask(bgCtx, taskID); err != nil || task.Status != "queued" {
+			r.store.DequeueRun(bgCtx, taskID)
+			return nil, false
+		}
+	}
+}
+
+// leaveQueue removes a task that got its slot from the run queue and moves
+// it back to in_progress. It reports false, leaving the status alone, when
+// the task is no longer queued because it was cancelled or deleted while
+// it waited.
+func (r *Runner) leaveQueue(taskID uuid.UUID) bool {
+	bgCtx := context.Background()
+	if err := r.store.DequeueRun(bgCtx, taskID); err != nil {
+		logger.Runner.Warn("save run queue", "task", taskID, "error", err)
+	}
+	if ok, err := r.store.CompareAndSetStatus(bgCtx, taskID, "queued", "in_progress"); err != nil || !ok {
+		return false
+	}
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
+		"from": "queued", "to": "in_progress",
+	})
+	return true
+}
+
+// queueHead returns the first task in the run queue that is still queued,
+// or uuid.Nil when none is.
+func (r *Runner) queueHead() uuid.UUID {
+	if q := r.RunQueue(); len(q) > 0 {
+		return q[0]
+	}
+	return uuid.Nil
+}
+
+// RunQueue returns the tasks waiting for a slot in the order they will
+// start. Entries whose task is no longer queued are skipped.
+func (r *Runner) RunQueue() []uuid.UUID {
+	ctx := context.Background()
+	ids := []uuid.UUID{}
+	for _, id := range r.store.RunQueue() {
+		if task, err := r.store.GetTask(ctx, id); err == nil && task.Status == "queued" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// SetRunQueue reorders the run queue: the tasks in order go first, in that
+// order, followed by the remaining queued tasks in their current order.
+// Every task in order must be in the queue, or ErrNotQueued is returned.
+func (r *Runner) SetRunQueue(order []uuid.UUID) error {
+	current := r.RunQueue()
+	for _, id := range order {
+		if !slices.Contains(current, id) {
+			return fmt.Errorf("%w: %s", ErrNotQueued, id)
+		}
+	}
+	queue := slices.Clone(order)
+	for _, id := range current {
+		if !slices.Contains(queue, id) {
+			queue = append(queue, id)
+		}
+	}
+	return r.store.SetRunQueue(context.Background(), queue)
+}
+
+// ResumeQueue re-enters tasks a previous server process left queued. They
+// keep their saved run-queue order, followed by queued tasks missing from
+// it (held by the daily cost limit) in board order. Tasks that had already
+// run turns were queued mid-run with a prompt that did not survive the
+// restart, so they move to waiting instead.
+func (r *Runner) ResumeQueue() {
+	ctx := context.Background()
+	tasks, _, err := r.store.ListTasksFiltered(ctx, store.TaskFilter{IncludeArchived: true, Statuses: []string{"queued"}})
+	if err != nil {
+		logger.Runner.Error("resume queue: list tasks", "error", err)
+		return
+	}
+	byID := make(map[uuid.UUID]*store.Task, len(tasks))
+	for i := range tasks {
+		byID[tasks[i].ID] = &tasks[i]
+	}
+	var order []uuid.UUID
+	for _, id := range r.store.RunQueue() {
+		if byID[id] != nil && !slices.Contains(order, id) {
+			order = append(order, id)
+		}
+	}
+	for _, t := range tasks {
+		if !slices.Contains(order, t.ID) {
+			order = append(order, t.ID)
+		}
+	}
+
+	var resumed []*store.Task
+	for _, id := range order {
+		t := byID[id]
+		if t.Turns > 0 {
+			logger.Runner.Warn("task was queued mid-run at startup, moving to waiting", "task", id)
+			r.store.UpdateTaskStatus(ctx, id, "waiting")
+			r.store.InsertEvent(ctx, id, store.EventTypeSystem, map[string]string{
+				"result": "Server restarted while task was queued between turns. Review the output and send feedback to continue.",
+			})
+			r.store.InsertEvent(ctx, id, store.EventTypeStateChange, map[string]string{
+				"from": "queued", "to": "waiting",
+			})
+			continue
+		}
+		resumed = append(resumed, t)
+	}
+	ids := make([]uuid.UUID, len(resumed))
+	for i, t := range resumed {
+		ids[i] = t.ID
+	}
+	if err := r.store.SetRunQueue(ctx, ids); err != nil {
+		logger.Runner.Error("resume queue: save run queue", "error", err)
+	}
+	// The queue is saved before any run starts so slots go out in order.
+	for i, t := range resumed {
+		logger.Runner.Info("resuming queued task", "task", t.ID, "position", i+1)
+		r.store.InsertEvent(ctx, t.ID, store.EventTypeSystem, map[string]string{
+			"result": fmt.Sprintf("Server restarted while task was queued. It keeps its place in the run queue (#%d).", i+1),
+		})
+		go r.Run(t.ID, t.Prompt, runSessionID(t), false)
+	}
+}
+
+// holdQueued moves a running task to "queued" with a system event giving
+// reason, and polls every interval until blocked reports false, then moves
+// it back to in_progress. Time spent queued is added back to deadline.
+// Returns false if the task left the queue while waiting (cancelled or
+// deleted), in which case the caller must stop without touching its status.
+func (r *Runner) holdQueued(taskID uuid.UUID, deadline *taskDeadline, interval time.Duration, reason string, blocked func() bool) bool {
+	bgCtx := context.Background()
+	if ok, _ := r.store.CompareAndSetStatus(bgCtx, taskID, "in_progress", "queued"); !ok {
+		return false
+	}
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
+		"from": "in_progress", "to": "queued",
+	})
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+		"result": reason,
+	})
+	start := time.Now()
+	for {
+		time.Sleep(interval)
+		if blocked() {
+			if task, err := r.store.GetTask(bgCtx, taskID); err != nil || task.Status != "queued" {
+				return false
+			}
+			continue
+		}
+		if ok, _ := r.store.CompareAndSetStatus(bgCtx, taskID, "queued", "in_progress"); !ok {
+			return false
+		}
+		deadline.postpone(time.Since(start))
+		r.store.InsertEvent(bgCtx, taskID, store.EventTypeStateChange, map[string]string{
+			"from": "queued", "to": "in_progress",
+		})
+		return true
+	}
+}
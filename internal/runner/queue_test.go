@@ -0,0 +1,235 @@
+package runner
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// TestAcquireSlotQueuesBeyondLimit verifies that a task started while every
+// slot is taken waits as queued, starts once a slot frees, and never starts
+// if it is cancelled while queued.
+func TestAcquireSlotQueuesBeyondLimit(t *testing.T) {
+	old := queuePollInterval
+	queuePollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { queuePollInterval = old })
+
+	s, r := setupTestRunner(t, nil)
+	r.slots = make(chan struct{}, 1)
+	a, _ := s.CreateTask(bg(), "a", 5, false)
+	b, _ := s.CreateTask(bg(), "b", 5, false)
+	c, _ := s.CreateTask(bg(), "c", 5, false)
+	s.UpdateTaskStatus(bg(), a.ID, "in_progress")
+	s.UpdateTaskStatus(bg(), b.ID, "in_progress")
+	s.UpdateTaskStatus(bg(), c.ID, "in_progress")
+
+	releaseA, ok := r.acquireSlot(a.ID)
+	if !ok {
+		t.Fatal("first task should get a slot")
+	}
+
+	type result struct {
+		release func()
+		ok      bool
+	}
+	bDone := make(chan result, 1)
+	cDone := make(chan result, 1)
+	go func() { rel, ok := r.acquireSlot(b.ID); bDone <- result{rel, ok} }()
+	waitStatus(t, s, b.ID, "queued")
+	go func() { rel, ok := r.acquireSlot(c.ID); cDone <- result{rel, ok} }()
+	waitStatus(t, s, c.ID, "queued")
+	if st := r.Status(); st.Running != 1 || st.Queued != 2 || st.MaxConcurrent != 1 {
+		t.Fatalf("status = %+v, want 1 running, 2 queued, limit 1", st)
+	}
+
+	s.UpdateTaskStatus(bg(), c.ID, "cancelled")
+	if res := <-cDone; res.ok {
+		t.Fatal("cancelled task must not get a slot")
+	}
+
+	releaseA()
+	res := <-bDone
+	if !res.ok {
+		t.Fatal("queued task should start once a slot frees")
+	}
+	if got, _ := s.GetTask(bg(), b.ID); got.Status != "in_progress" {
+		t.Errorf("status = %q, want in_progress", got.Status)
+	}
+	if got, _ := s.GetTask(bg(), c.ID); got.Status != "cancelled" {
+		t.Errorf("cancelled task status = %q", got.Status)
+	}
+	res.release()
+	if st := r.Status(); st.Running != 0 || st.Queued != 0 {
+		t.Errorf("status after release = %+v", st)
+	}
+}
+
+// TestAcquireSlotKeepsCancelledStatus verifies that a task cancelled
+// before it is queued, or before it leaves the queue, is never moved back
+// to queued or in_progress.
+func TestAcquireSlotKeepsCancelledStatus(t *testing.T) {
+	s, r := setupTestRunner(t, nil)
+	r.slots = make(chan struct{}, 1)
+	holder, _ := s.CreateTask(bg(), "holder", 5, false)
+	s.UpdateTaskStatus(bg(), holder.ID, "in_progress")
+	release, _ := r.acquireSlot(holder.ID)
+	defer release()
+
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	s.UpdateTaskStatus(bg(), task.ID, "cancelled")
+	if _, ok := r.acquireSlot(task.ID); ok {
+		t.Fatal("cancelled task must not get a slot")
+	}
+	if got, _ := s.GetTask(bg(), task.ID); got.Status != "cancelled" {
+		t.Fatalf("status = %q after acquireSlot, want cancelled", got.Status)
+	}
+	if q := s.RunQueue(); len(q) != 0 {
+		t.Fatalf("run queue = %v, want empty", q)
+	}
+
+	if r.leaveQueue(task.ID) {
+		t.Fatal("leaveQueue moved a cancelled task")
+	}
+	if got, _ := s.GetTask(bg(), task.ID); got.Status != "cancelled" {
+		t.Fatalf("status = %q after leaveQueue, want cancelled", got.Status)
+	}
+	if st := r.Status(); st.Running != 1 || st.Queued != 0 {
+		t.Errorf("status = %+v, want 1 running, 0 queued", st)
+	}
+}
+
+// TestAcquireSlotFollowsRunQueue verifies that slots go out in run-queue
+// order and that SetRunQueue moves a task to the front.
+func TestAcquireSlotFollowsRunQueue(t *testing.T) {
+	old := queuePollInterval
+	queuePollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { queuePollInterval = old })
+
+	s, r := setupTestRunner(t, nil)
+	r.slots = make(chan struct{}, 1)
+	var ids []uuid.UUID
+	for _, p := range []string{"a", "b", "c"} {
+		task, _ := s.CreateTask(bg(), p, 5, false)
+		s.UpdateTaskStatus(bg(), task.ID, "in_progress")
+		ids = append(ids, task.ID)
+	}
+	a, b, c := ids[0], ids[1], ids[2]
+
+	releaseA, _ := r.acquireSlot(a)
+	got := make(chan uuid.UUID, 2)
+	for _, id := range []uuid.UUID{b, c} {
+		go func() {
+			if release, ok := r.acquireSlot(id); ok {
+				got <- id
+				release()
+			}
+		}()
+		waitStatus(t, s, id, "queued")
+	}
+	if q := r.RunQueue(); !slices.Equal(q, []uuid.UUID{b, c}) {
+		t.Fatalf("run queue = %v, want [b c]", q)
+	}
+	if err := r.SetRunQueue([]uuid.UUID{a}); !errors.Is(err, ErrNotQueued) {
+		t.Errorf("reordering a running task: err = %v, want ErrNotQueued", err)
+	}
+	if err := r.SetRunQueue([]uuid.UUID{c}); err != nil {
+		t.Fatal(err)
+	}
+	if q := s.RunQueue(); !slices.Equal(q, []uuid.UUID{c, b}) {
+		t.Fatalf("saved run queue = %v, want [c b]", q)
+	}
+
+	releaseA()
+	if first, second := <-got, <-got; first != c || second != b {
+		t.Errorf("start order = %v, %v; want c, b", first, second)
+	}
+	if q := s.RunQueue(); len(q) != 0 {
+		t.Errorf("run queue after start = %v, want empty", q)
+	}
+}
+
+// TestResumeQueueKeepsSavedOrder verifies that tasks left queued by a
+// previous process re-enter the queue in their saved order, and that a task
+// queued between turns moves to waiting.
+func TestResumeQueueKeepsSavedOrder(t *testing.T) {
+	old := queuePollInterval
+	queuePollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { queuePollInterval = old })
+
+	s, r := setupTestRunner(t, nil)
+	r.slots = make(chan struct{}, 1)
+	holder, _ := s.CreateTask(bg(), "holder", 5, false)
+	s.UpdateTaskStatus(bg(), holder.ID, "in_progress")
+	release, _ := r.acquireSlot(holder.ID)
+	defer release()
+
+	var ids []uuid.UUID
+	for _, p := range []string{"x", "y", "z"} {
+		task, _ := s.CreateTask(bg(), p, 5, false)
+		s.UpdateTaskStatus(bg(), task.ID, "queued")
+		ids = append(ids, task.ID)
+	}
+	x, y, z := ids[0], ids[1], ids[2]
+	s.UpdateTaskResult(bg(), z, "partial", "sess", "max_tokens", 1)
+	s.SetRunQueue(bg(), []uuid.UUID{y, z, x})
+
+	r.ResumeQueue()
+	if q := r.RunQueue(); !slices.Equal(q, []uuid.UUID{y, x}) {
+		t.Errorf("run queue = %v, want [y x]", q)
+	}
+	if task, _ := s.GetTask(bg(), z); task.Status != "waiting" {
+		t.Errorf("task queued between turns: status = %q, want waiting", task.Status)
+	}
+
+	// Let the resumed runs leave the queue before the test ends.
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Status().Queued < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("resumed runs did not queue")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for _, id := range []uuid.UUID{x, y} {
+		s.UpdateTaskStatus(bg(), id, "cancelled")
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for r.Status().Queued > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("resumed runs did not leave the queue")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitStatus polls until the task reaches status or the test times out.
+func waitStatus(t *testing.T, s *store.Store, id uuid.UUID, status string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		task, _ := s.GetTask(bg(), id)
+		if task != nil && task.Status == status {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task %s did not reach %q", id, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitIdle polls until no Run holds a slot, so background runs started by
+// the code under test finish before the test's temp dirs are removed.
+func waitIdle(t *testing.T, r *Runner) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Status().Running > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("runs did not finish")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
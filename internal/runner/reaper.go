@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// StopReasonDeadlineExceeded is recorded as the stop_reason of a task that
+// was failed because it had not finished by its Deadline.
+const StopReasonDeadlineExceeded = "deadline_exceeded"
+
+// deadlineReapInterval is how often the deadline reaper looks for overdue
+// tasks. A variable so tests can shorten it.
+var deadlineReapInterval = 30 * time.Second
+
+// RunDeadlineReaper fails tasks that are still unfinished past their
+// Deadline, until ctx is done.
+func (r *Runner) RunDeadlineReaper(ctx context.Context) {
+	ticker := time.NewTicker(deadlineReapInterval)
+	defer ticker.Stop()
+	for {
+		r.reapStep(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reapStep fails every unfinished task whose deadline is before now.
+func (r *Runner) reapStep(now time.Time) {
+	tasks, err := r.store.ListTasks(context.Background(), false)
+	if err != nil {
+		logger.Runner.Warn("deadline reaper: list tasks", "error", err)
+		return
+	}
+	for i := range tasks {
+		t := &tasks[i]
+		if t.Deadline == nil || !now.After(*t.Deadline) {
+			continue
+		}
+		switch t.Status {
+		case "done", "failed", "cancelled":
+			continue
+		}
+		r.failPastDeadline(t.ID, now)
+	}
+}
+
+// failPastDeadline moves an overdue task to failed with stop_reason
+// StopReasonDeadlineExceeded, stopping whatever it is doing the way a cancel
+// would: a running commit pipeline is rolled back, a stopped rebase aborted,
+// and a running container killed. The worktrees are kept so the task can
+// be resumed. A commit pipeline past the point of rollback is left to land.
+//
+// The task is re-read first and failed through a compare-and-set on the
+// status it was read in, so a task that finishes, or is cancelled, while
+// the reaper works keeps that outcome.
+func (r *Runner) failPastDeadline(id uuid.UUID, now time.Time) {
+	bgCtx := context.Background()
+	t, err := r.store.GetTask(bgCtx, id)
+	if err != nil || t.Deadline == nil || !now.After(*t.Deadline) {
+		return
+	}
+	switch t.Status {
+	case "done", "failed", "cancelled":
+		return
+	case "in_progress", "committing":
+		if _, err := r.CancelCommit(t.ID); errors.Is(err, ErrCommitLanded) {
+			return
+		}
+	case "conflict":
+		if err := r.CancelConflict(t.ID); err != nil {
+			logger.Runner.Warn("deadline reaper: roll back conflict", "task", t.ID, "error", err)
+		}
+	}
+
+	if ok, err := r.store.CompareAndSetStatus(bgCtx, t.ID, t.Status, "failed"); err != nil || !ok {
+		return
+	}
+	msg := fmt.Sprintf("task did not finish by its deadline of %s", t.Deadline.Format(time.RFC3339))
+	logger.Runner.Warn("task deadline exceeded", "task", t.ID, "status", t.Status, "deadline", *t.Deadline)
+	result, sessionID := "", ""
+	if t.Result != nil {
+		result = *t.Result
+	}
+	if t.SessionID != nil {
+		sessionID = *t.SessionID
+	}
+	r.store.UpdateTaskResult(bgCtx, t.ID, result, sessionID, StopReasonDeadlineExceeded, t.Turns)
+	r.store.InsertEvent(bgCtx, t.ID, store.EventTypeError, map[string]string{"error": msg})
+	r.store.InsertEvent(bgCtx, t.ID, store.EventTypeStateChange, map[string]string{
+		"from": t.Status, "to": "failed",
+	})
+
+	switch t.Status {
+	case "in_progress":
+		// Run sees the failed status once the container dies, leaves it
+		// alone, and notifies the webhook.
+		r.KillContainer(t.ID)
+	case "queued":
+		// Run leaves the queue on the status change and notifies.
+	case "waiting":
+		r.RemoveSandbox(t.ID)
+		r.NotifyFailed(t.ID)
+	default:
+		r.NotifyFailed(t.ID)
+	}
+}
+
+// statusTaken reports whether a running task was cancelled or failed past
+// its deadline while it ran, in which case whoever did it owns its status.
+func (r *Runner) statusTaken(taskID uuid.UUID) bool {
+	cur, err := r.store.GetTask(context.Background(), taskID)
+	if err != nil {
+		return false
+	}
+	if cur.Status == "cancelled" {
+		return true
+	}
+	return cur.Status == "failed" && cur.StopReason != nil && *cur.StopReason == StopReasonDeadlineExceeded
+}
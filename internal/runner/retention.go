@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"context"
+	"time"
+
+	"changkun.de/wallfacer/internal/logger"
+	"github.com/google/uuid"
+)
+
+// retentionSweepInterval is how often archived tasks are checked against
+// the retention period. A variable so tests can shorten it.
+var retentionSweepInterval = time.Hour
+
+// RunRetentionSweeper deletes archived tasks that have not been updated for
+// longer than the configured retention, once at start and then every
+// retentionSweepInterval, until ctx is done. It returns at once when
+// retention is disabled.
+func (r *Runner) RunRetentionSweeper(ctx context.Context) {
+	if r.archivedRetention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(retentionSweepInterval)
+	defer ticker.Stop()
+	for {
+		r.pruneArchived(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pruneArchived deletes every archived task last updated before now minus
+// the retention through DeleteTask, as deleting it from the board does, and
+// returns how many were deleted.
+func (r *Runner) pruneArchived(now time.Time) int {
+	bgCtx := context.Background()
+	tasks, err := r.store.ListTasks(bgCtx, true)
+	if err != nil {
+		logger.Runner.Warn("retention: list tasks", "error", err)
+		return 0
+	}
+	cutoff := now.Add(-r.archivedRetention)
+	deleted := 0
+	for _, t := range tasks {
+		if !t.Archived || !t.UpdatedAt.Before(cutoff) {
+			continue
+		}
+		if err := r.DeleteTask(t.ID); err != nil {
+			logger.Runner.Warn("retention: delete task", "task", t.ID, "error", err)
+			continue
+		}
+		logger.Runner.Info("retention: deleted archived task", "task", t.ID,
+			"title", t.Title, "updated_at", t.UpdatedAt.Format(time.RFC3339))
+		deleted++
+	}
+	return deleted
+}
+
+// compactOutputs gzip-compresses the saved turn outputs of a task that has
+// finished. Outputs are capped per turn, so this is quick enough to run
+// inline; failures only cost disk space and are logged.
+func (r *Runner) compactOutputs(taskID uuid.UUID) {
+	n, err := r.store.CompactTask(context.Background(), taskID)
+	if err != nil {
+		logger.Runner.Warn("compact outputs", "task", taskID, "error", err)
+		return
+	}
+	if n > 0 {
+		logger.Runner.Debug("compacted outputs", "task", taskID, "files", n)
+	}
+}
@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// TestPruneArchived verifies that only archived tasks older than the
+// retention are deleted, together with their worktrees.
+func TestPruneArchived(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupTestRunner(t, []string{repo})
+	r.archivedRetention = 24 * time.Hour
+
+	archived, _ := s.CreateTask(bg(), "archived", 5, false)
+	worktreePaths, branchName, err := r.setupWorktrees(archived.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.UpdateTaskWorktrees(bg(), archived.ID, worktreePaths, branchName)
+	s.UpdateTaskStatus(bg(), archived.ID, "done")
+	s.SetTaskArchived(bg(), archived.ID, true)
+	active, _ := s.CreateTask(bg(), "done but not archived", 5, false)
+	s.UpdateTaskStatus(bg(), active.ID, "done")
+
+	if n := r.pruneArchived(time.Now().Add(12 * time.Hour)); n != 0 {
+		t.Fatalf("pruned %d tasks within the retention", n)
+	}
+	if n := r.pruneArchived(time.Now().Add(48 * time.Hour)); n != 1 {
+		t.Fatalf("pruned %d tasks, want 1", n)
+	}
+	if _, err := s.GetTask(bg(), archived.ID); err == nil {
+		t.Error("archived task was not deleted")
+	}
+	if _, err := os.Stat(worktreePaths[repo]); !os.IsNotExist(err) {
+		t.Errorf("worktree of the pruned task still exists: %v", err)
+	}
+	if gitRun(t, repo, "branch", "--list", branchName) != "" {
+		t.Errorf("branch %s of the pruned task still exists", branchName)
+	}
+	if _, err := s.GetTask(bg(), active.ID); err != nil {
+		t.Error("task that is not archived was deleted")
+	}
+}
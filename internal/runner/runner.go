@@ -3,8 +3,10 @@ package runner
 import (
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"changkun.de/wallfacer/internal/forge"
 	"changkun.de/wallfacer/internal/store"
 	"github.com/google/uuid"
 )
@@ -21,8 +23,9 @@ type ContainerInfo struct {
 }
 
 const (
-	maxRebaseRetries   = 3
-	defaultTaskTimeout = 15 * time.Minute
+	// DefaultRebaseRetries is the default of the -rebase-retries flag.
+	DefaultRebaseRetries = 3
+	defaultTaskTimeout   = 15 * time.Minute
 )
 
 // RunnerConfig holds all configuration needed to construct a Runner.
@@ -32,8 +35,228 @@ type RunnerConfig struct {
 	Workspaces       string // space-separated workspace paths
 	WorktreesDir     string
 	InstructionsPath string
+
+	// SyncRemoteBeforeMerge fast-forwards the local default branch from
+	// origin before rebasing a task onto it.
+	SyncRemoteBeforeMerge bool
+
+	// AutoPush pushes the merged default branch of each repo to origin at
+	// the end of the commit pipeline.
+	AutoPush bool
+
+	// MergeStrategy is how the commit pipeline lands tasks that do not set
+	// their own: one of the store.MergeStrategy* constants. Empty means
+	// store.MergeStrategyFF.
+	MergeStrategy string
+
+	// RebaseConflictStrategy decides what happens when rebasing a task
+	// branch conflicts: RebaseConflictResolver (default),
+	// RebaseConflictTheirs, RebaseConflictOurs, or RebaseConflictAbort.
+	RebaseConflictStrategy string
+
+	// ResolveMode decides who resolves conflicts the strategy leaves:
+	// ResolveAuto (default, also when empty) or ResolveManual.
+	ResolveMode string
+
+	// RebaseRetries is how many times a conflicting rebase is retried, each
+	// after a conflict resolver run. Zero never runs the resolver and fails
+	// on the first conflict.
+	RebaseRetries int
+
+	// MaxHourlySpendUSD caps the total cost of turns started across all
+	// tasks within a rolling hour. Zero disables the cap.
+	MaxHourlySpendUSD float64
+
+	// DailyCostLimitUSD caps the total cost of turns recorded since local
+	// midnight across all tasks. A task about to start a turn past it is
+	// queued until the next day. Zero disables the cap.
+	DailyCostLimitUSD float64
+
+	// PromptPrefix and PromptSuffix are short global directives wrapped
+	// around every prompt sent to a task's sandbox. They are applied at
+	// runtime and never stored on the task.
+	PromptPrefix string
+	PromptSuffix string
+
+	// BranchTemplate names task branches; see renderBranchName for the
+	// placeholders. Empty uses DefaultBranchTemplate.
+	BranchTemplate string
+
+	// CommitMessageMode decides how commit messages are written:
+	// CommitMessageClaude (default, also when empty), CommitMessageTemplate,
+	// or CommitMessagePrompt.
+	CommitMessageMode string
+
+	// CommitMessageTemplate is the text/template rendered in
+	// CommitMessageTemplate mode and when Claude generation fails; see
+	// commitMessageFields for its fields. Empty uses
+	// DefaultCommitMessageTemplate.
+	CommitMessageTemplate string
+
+	// SkipSubmodules leaves the submodules of new task worktrees
+	// uninitialized instead of running git submodule update --init.
+	SkipSubmodules bool
+
+	// LFSMode decides whether Git LFS objects are pulled into new task
+	// worktrees: LFSAuto (default, also when empty), LFSOn, or LFSOff.
+	LFSMode string
+
+	// WorktreesNearRepo places each task's worktree in a
+	// ".wallfacer-worktrees" directory next to its workspace instead of
+	// under WorktreesDir, keeping it on the repo's filesystem.
+	WorktreesNearRepo bool
+
+	// NoWorktree runs tasks directly in the workspaces instead of isolated
+	// worktrees and commits in place without rebase/merge. Only one task
+	// may be active at a time in this mode.
+	NoWorktree bool
+
+	// GlobalInstructionsPath is an instructions file applied to every
+	// workspace combination, prepended to the file at InstructionsPath.
+	GlobalInstructionsPath string
+
+	// WebhookURL receives a JSON POST whenever a task reaches one of
+	// WebhookEvents. Empty disables notifications.
+	WebhookURL string
+
+	// WebhookKind selects the payload format: WebhookKindGeneric (default,
+	// also when empty) or WebhookKindSlack.
+	WebhookKind string
+
+	// WebhookEvents lists the task statuses that trigger the webhook, from
+	// WebhookEventStatuses. Empty uses DefaultWebhookEvents.
+	WebhookEvents []string
+
+	// WebhookIncludeDiffStat adds files changed, insertions, deletions and
+	// commit hashes to the done payload.
+	WebhookIncludeDiffStat bool
+
+	// MaxWorktreesDiskBytes caps the total size of task worktrees. A task
+	// that would exceed it is held in the backlog until space frees. Zero
+	// disables the cap.
+	MaxWorktreesDiskBytes int64
+
+	// PrimaryWorkspace is the workspace whose changes lead the generated
+	// commit message in multi-repo tasks. Defaults to the first workspace.
+	PrimaryWorkspace string
+
+	// ArchivedRetention deletes archived tasks, with their worktrees and
+	// data, once they have gone this long without an update. Zero keeps
+	// them forever.
+	ArchivedRetention time.Duration
+
+	// EmptyStopReasonPolicy decides what happens when a turn ends with an
+	// empty or unknown stop_reason: EmptyStopReasonWait (default),
+	// EmptyStopReasonComplete, or EmptyStopReasonFail.
+	EmptyStopReasonPolicy string
+
+	// MaxTurnOutputBytes bounds the stored stdout and stderr of each turn.
+	// Longer output keeps its head and tail around an elision marker. Zero
+	// disables the limit.
+	MaxTurnOutputBytes int64
+
+	// TurnTimeout bounds each turn of a task inside its total timeout. A
+	// turn that runs past it is killed and retried once; tasks can override
+	// it with Task.TurnTimeout. Zero disables the per-turn bound.
+	TurnTimeout time.Duration
+
+	// AutoExtendStep is how far the deadline of a task with AutoExtend set
+	// moves back after a productive turn near it; AutoExtendMax bounds the
+	// task's total budget. Zero values use 15 minutes and 4 hours.
+	AutoExtendStep time.Duration
+	AutoExtendMax  time.Duration
+
+	// SandboxImage is the image task sandboxes are created from. Empty uses
+	// the docker sandbox default for the claude agent.
+	SandboxImage string
+
+	// SandboxImageAllowlist lists the images a task may select as its own
+	// sandbox image. SandboxImage is always allowed.
+	SandboxImageAllowlist []string
+
+	// MemoryLimit and CPULimit bound each sandbox with docker's --memory
+	// and --cpus (e.g. "4g", "1.5"). Tasks can override them with
+	// Task.MemoryLimit and Task.CPULimit. Empty means no limit.
+	MemoryLimit string
+	CPULimit    string
+
+	// ReadOnlyMounts are directories mounted read-only into every task
+	// sandbox for reference, e.g. docs or a sibling repo. They get no
+	// worktrees and take no part in the commit pipeline.
+	ReadOnlyMounts []string
+
+	// NetworkMode is the network of every sandbox: NetworkHost (default,
+	// also when empty), NetworkBridge, or NetworkNone.
+	NetworkMode string
+
+	// ModelAllowlist lists the Claude models a task may select as its own
+	// model. Empty allows any model; the env-file model is always allowed.
+	ModelAllowlist []string
+
+	// AutoStartDependents starts a backlog task automatically once the last
+	// of its dependencies is done.
+	AutoStartDependents bool
+
+	// MaxConcurrent caps how many tasks may run at once. Tasks started
+	// beyond it wait in the "queued" status until a slot frees. Zero
+	// means unlimited.
+	MaxConcurrent int
 }
 
+// Policies for a turn that ends with an empty or unknown stop_reason.
+const (
+	// EmptyStopReasonWait moves the task to waiting for user feedback.
+	EmptyStopReasonWait = "wait"
+	// EmptyStopReasonComplete commits the task as if it ended with
+	// end_turn. Work the agent meant to continue may be committed as-is.
+	EmptyStopReasonComplete = "complete"
+	// EmptyStopReasonFail marks the task as failed.
+	EmptyStopReasonFail = "fail"
+)
+
+// Whether Git LFS objects are pulled into task worktrees.
+const (
+	// LFSAuto pulls LFS objects in repos whose .gitattributes use LFS,
+	// when git-lfs is installed.
+	LFSAuto = "auto"
+	// LFSOn pulls LFS objects in repos that use LFS and fails worktree
+	// setup when that is not possible.
+	LFSOn = "true"
+	// LFSOff never pulls LFS objects; worktrees keep pointer files.
+	LFSOff = "false"
+)
+
+// Sandbox network modes.
+const (
+	// NetworkHost leaves the sandbox on the host network, the docker
+	// sandbox default.
+	NetworkHost = "host"
+	// NetworkBridge puts the sandbox on docker's bridge network: outbound
+	// access without the host's local services.
+	NetworkBridge = "bridge"
+	// NetworkNone gives the sandbox no network. Claude Code cannot reach
+	// the API, so this only suits offline tooling.
+	NetworkNone = "none"
+)
+
+// Ways a conflicting rebase of a task branch is settled.
+const (
+	// RebaseConflictResolver runs a Claude resolver container on every
+	// conflict.
+	RebaseConflictResolver = "resolver"
+	// RebaseConflictTheirs rebases with -X theirs, keeping the task's side
+	// of conflicting hunks, and runs the resolver only on conflicts that
+	// remain.
+	RebaseConflictTheirs = "theirs"
+	// RebaseConflictOurs rebases with -X ours, keeping the target branch's
+	// side of conflicting hunks, and runs the resolver only on conflicts
+	// that remain.
+	RebaseConflictOurs = "ours"
+	// RebaseConflictAbort fails on the first conflict without running the
+	// resolver.
+	RebaseConflictAbort = "abort"
+)
+
 // Runner orchestrates Claude Code container execution for tasks.
 // It manages worktree isolation, container lifecycle, and the commit pipeline.
 type Runner struct {
@@ -43,19 +266,145 @@ type Runner struct {
 	workspaces       string
 	worktreesDir     string
 	instructionsPath string
+	syncRemote       bool
+	autoPush         bool
+	mergeStrategy    string
+	rebaseConflict   string
+	resolveMode      string
+	rebaseRetries    int
 	repoMu           sync.Map // per-repo *sync.Mutex for serializing rebase+merge
+	commitRuns       sync.Map // taskID → *commitRun of running commit pipelines
+	sandboxCmds      sync.Map // taskID → shell-quoted command that created its sandbox
+
+	// forgeFor picks the code host API that opens pull requests for a
+	// remote; tests replace it with a fake.
+	forgeFor func(remoteURL string) (forge.Provider, error)
+
+	maxHourlySpend float64
+	dailyLimit     float64
+
+	authMu        sync.Mutex
+	authFailed    bool   // the API rejected rejectedCreds
+	rejectedCreds string // env file credentials when they were rejected
+
+	promptPrefix string
+	promptSuffix string
+
+	worktreesNearRepo bool
+	noWorktree        bool
+	startMu           sync.Mutex // serialises ClaimTask in no-worktree mode
+	branchTemplate    string
+	skipSubmodules    bool
+	lfsMode           string
+
+	commitMessageMode     string
+	commitMessageTemplate string
+
+	globalInstructionsPath string
+
+	webhookURL      string
+	webhookDiffStat bool
+	webhookKind     string
+	webhookEvents   []string
+
+	maxWorktreesDisk int64
+	primary          string
+	emptyStopReason  string
+	maxTurnOutput    int64
+
+	autoExtendStep time.Duration
+	autoExtendMax  time.Duration
+	turnTimeout    time.Duration
+
+	sandboxImage  string
+	allowedImages []string
+	memoryLimit   string
+	cpuLimit      string
+	networkMode   string
+	refMounts     []string
+
+	allowedModels []string
+
+	autoStartDependents bool
+
+	slots   chan struct{} // one token per running task; nil when unlimited
+	running atomic.Int32
+	queued  atomic.Int32
+
+	titleMu   sync.Mutex
+	titleGens map[uuid.UUID][]*titleGen // in-flight title generations per task
+
+	metrics metrics
+
+	archivedRetention time.Duration // see RunnerConfig.ArchivedRetention
 }
 
 // NewRunner constructs a Runner from the given store and config.
 func NewRunner(s *store.Store, cfg RunnerConfig) *Runner {
-	return &Runner{
+	r := &Runner{
 		store:            s,
 		command:          cfg.Command,
 		envFile:          cfg.EnvFile,
 		workspaces:       cfg.Workspaces,
 		worktreesDir:     cfg.WorktreesDir,
 		instructionsPath: cfg.InstructionsPath,
+		syncRemote:       cfg.SyncRemoteBeforeMerge,
+		autoPush:         cfg.AutoPush,
+		mergeStrategy:    cfg.MergeStrategy,
+		rebaseConflict:   cfg.RebaseConflictStrategy,
+		resolveMode:      cfg.ResolveMode,
+		rebaseRetries:    max(cfg.RebaseRetries, 0),
+		forgeFor:         forge.Detect,
+		maxHourlySpend:   cfg.MaxHourlySpendUSD,
+		dailyLimit:       cfg.DailyCostLimitUSD,
+		promptPrefix:     cfg.PromptPrefix,
+		promptSuffix:     cfg.PromptSuffix,
+
+		worktreesNearRepo: cfg.WorktreesNearRepo,
+		noWorktree:        cfg.NoWorktree,
+		branchTemplate:    cfg.BranchTemplate,
+		skipSubmodules:    cfg.SkipSubmodules,
+		lfsMode:           cfg.LFSMode,
+
+		commitMessageMode:     cfg.CommitMessageMode,
+		commitMessageTemplate: cfg.CommitMessageTemplate,
+
+		globalInstructionsPath: cfg.GlobalInstructionsPath,
+
+		webhookURL:      cfg.WebhookURL,
+		webhookDiffStat: cfg.WebhookIncludeDiffStat,
+		webhookKind:     cfg.WebhookKind,
+		webhookEvents:   cfg.WebhookEvents,
+
+		maxWorktreesDisk: cfg.MaxWorktreesDiskBytes,
+		primary:          cfg.PrimaryWorkspace,
+		emptyStopReason:  cfg.EmptyStopReasonPolicy,
+		maxTurnOutput:    cfg.MaxTurnOutputBytes,
+
+		autoExtendStep: cfg.AutoExtendStep,
+		autoExtendMax:  cfg.AutoExtendMax,
+		turnTimeout:    cfg.TurnTimeout,
+
+		sandboxImage:  cfg.SandboxImage,
+		allowedImages: cfg.SandboxImageAllowlist,
+		memoryLimit:   cfg.MemoryLimit,
+		cpuLimit:      cfg.CPULimit,
+		networkMode:   cfg.NetworkMode,
+		refMounts:     cfg.ReadOnlyMounts,
+
+		allowedModels: cfg.ModelAllowlist,
+
+		autoStartDependents: cfg.AutoStartDependents,
+
+		archivedRetention: cfg.ArchivedRetention,
 	}
+	if cfg.MaxConcurrent > 0 {
+		r.slots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	if len(r.webhookEvents) == 0 {
+		r.webhookEvents = DefaultWebhookEvents
+	}
+	return r
 }
 
 // Command returns the container runtime binary path (docker).
@@ -68,6 +417,40 @@ func (r *Runner) EnvFile() string {
 	return r.envFile
 }
 
+// SandboxImageAllowed reports whether a task may use image as its sandbox
+// image: either the global image or one on the configured allowlist.
+func (r *Runner) SandboxImageAllowed(image string) bool {
+	if image == r.sandboxImage {
+		return true
+	}
+	for _, allowed := range r.allowedImages {
+		if image == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelAllowed reports whether a task may use model as its Claude model:
+// any model when no allowlist is configured, otherwise the env-file model
+// or one on the allowlist.
+func (r *Runner) ModelAllowed(model string) bool {
+	if len(r.allowedModels) == 0 || model == r.modelFromEnv() {
+		return true
+	}
+	for _, allowed := range r.allowedModels {
+		if model == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedModels returns the configured model allowlist.
+func (r *Runner) AllowedModels() []string {
+	return r.allowedModels
+}
+
 // Workspaces returns the list of configured workspace paths.
 func (r *Runner) Workspaces() []string {
 	if r.workspaces == "" {
@@ -76,6 +459,21 @@ func (r *Runner) Workspaces() []string {
 	return strings.Fields(r.workspaces)
 }
 
+// primaryWorkspace returns the configured primary workspace when it is one
+// of the workspaces, otherwise the first workspace.
+func (r *Runner) primaryWorkspace() string {
+	ws := r.Workspaces()
+	for _, w := range ws {
+		if w == r.primary {
+			return w
+		}
+	}
+	if len(ws) == 0 {
+		return ""
+	}
+	return ws[0]
+}
+
 // repoLock returns a per-repo mutex, creating one on first access.
 // Used to serialize rebase+merge operations on the same repository.
 func (r *Runner) repoLock(repoPath string) *sync.Mutex {
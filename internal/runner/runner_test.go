@@ -5,11 +5,13 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"changkun.de/wallfacer/internal/forge"
 	"changkun.de/wallfacer/internal/store"
 	"github.com/google/uuid"
 )
@@ -66,10 +68,11 @@ func setupTestRunner(t *testing.T, workspaces []string) (*store.Store, *Runner)
 	}
 
 	runner := NewRunner(s, RunnerConfig{
-		Command:      "echo", // dummy — not used for host-side operations
-		EnvFile:      "",
-		Workspaces:   strings.Join(workspaces, " "),
-		WorktreesDir: worktreesDir,
+		Command:       "echo", // dummy — not used for host-side operations
+		EnvFile:       "",
+		Workspaces:    strings.Join(workspaces, " "),
+		WorktreesDir:  worktreesDir,
+		RebaseRetries: DefaultRebaseRetries,
 	})
 	return s, runner
 }
@@ -133,6 +136,44 @@ func TestWorktreeSetup(t *testing.T) {
 	}
 }
 
+// TestWorktreeSetupNearRepo verifies that WorktreesNearRepo places the
+// worktree next to the workspace and that cleanup removes it again.
+func TestWorktreeSetupNearRepo(t *testing.T) {
+	repo := setupTestRepo(t)
+	_, runner := setupTestRunner(t, []string{repo})
+	runner.worktreesNearRepo = true
+
+	taskID := uuid.New()
+	worktreePaths, branchName, err := runner.setupWorktrees(taskID)
+	if err != nil {
+		t.Fatal("setupWorktrees:", err)
+	}
+
+	root := filepath.Join(filepath.Dir(repo), adjacentWorktreesDirName)
+	want := filepath.Join(root, taskID.String(), filepath.Base(repo))
+	if worktreePaths[repo] != want {
+		t.Fatalf("expected worktree at %q, got %q", want, worktreePaths[repo])
+	}
+	if branch := gitRun(t, want, "branch", "--show-current"); branch != branchName {
+		t.Fatalf("expected branch %q, got %q", branchName, branch)
+	}
+
+	// Switching the setting off must still reuse the existing worktree.
+	runner.worktreesNearRepo = false
+	again, _, err := runner.setupWorktrees(taskID)
+	if err != nil {
+		t.Fatal("second setupWorktrees:", err)
+	}
+	if again[repo] != want {
+		t.Fatalf("expected existing worktree %q to be reused, got %q", want, again[repo])
+	}
+
+	runner.cleanupWorktrees(taskID, worktreePaths, branchName)
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Fatalf("expected adjacent worktrees dir to be removed, stat err: %v", err)
+	}
+}
+
 // TestWorktreeGitFilePointsToHost verifies the root cause: the .git file in
 // a worktree contains an absolute host path. This proves that git commands
 // inside a container (where that host path doesn't exist) would fail.
@@ -237,6 +278,38 @@ func TestHostStageAndCommitNoChanges(t *testing.T) {
 	}
 }
 
+// TestHostStageAndCommitRefusesConflictMarkers verifies that changes adding
+// leftover conflict markers are not committed.
+func TestHostStageAndCommitRefusesConflictMarkers(t *testing.T) {
+	repo := setupTestRepo(t)
+	_, runner := setupTestRunner(t, []string{repo})
+
+	taskID := uuid.New()
+	worktreePaths, branchName, err := runner.setupWorktrees(taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { runner.cleanupWorktrees(taskID, worktreePaths, branchName) })
+
+	wt := worktreePaths[repo]
+	before := gitRun(t, wt, "rev-parse", "HEAD")
+	broken := "# Test\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> task\n"
+	if err := os.WriteFile(filepath.Join(wt, "README.md"), []byte(broken), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	committed, err := runner.hostStageAndCommit(taskID, worktreePaths, "Resolve conflict")
+	if err == nil || !strings.Contains(err.Error(), "conflict markers") || !strings.Contains(err.Error(), "README.md:2") {
+		t.Fatalf("hostStageAndCommit error = %v, want one naming README.md:2", err)
+	}
+	if committed {
+		t.Error("reported a commit despite conflict markers")
+	}
+	if after := gitRun(t, wt, "rev-parse", "HEAD"); after != before {
+		t.Error("conflict markers were committed")
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Commit pipeline
 // ---------------------------------------------------------------------------
@@ -307,6 +380,367 @@ func TestCommitPipelineBasic(t *testing.T) {
 	}
 }
 
+// TestCommitPipelineReconcilesTargetBranch verifies that the branch recorded
+// at setup time matches the default branch, and that a stale record is
+// corrected (with an event) when the merge resolves a different branch.
+func TestCommitPipelineReconcilesTargetBranch(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, runner := setupTestRunner(t, []string{repo})
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "Target branch", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktreePaths, branchName, err := runner.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := runner.targetBranches(worktreePaths); got[repo] != "main" {
+		t.Fatalf("expected target branch main, got %v", got)
+	}
+	s.UpdateTaskWorktrees(ctx, task.ID, worktreePaths, branchName)
+	s.UpdateTaskTargetBranches(ctx, task.ID, map[string]string{repo: "develop"})
+	s.UpdateTaskStatus(ctx, task.ID, "committing")
+
+	if err := os.WriteFile(filepath.Join(worktreePaths[repo], "f.txt"), []byte("x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	commitCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	runner.commit(commitCtx, task.ID, "", 1, worktreePaths, branchName)
+
+	got, _ := s.GetTask(ctx, task.ID)
+	if got.TargetBranches[repo] != "main" {
+		t.Fatalf("expected target branch corrected to main, got %v", got.TargetBranches)
+	}
+	events, _ := s.GetEvents(ctx, task.ID)
+	found := false
+	for _, ev := range events {
+		if ev.EventType == store.EventTypeSystem && strings.Contains(string(ev.Data), "changed from develop to main") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected an event noting the target branch change")
+	}
+}
+
+// TestCommitPipelineMergesIntoRecordedTarget verifies that switching the
+// main checkout to another branch mid-task does not redirect the merge away
+// from the target branch recorded when the worktree was created.
+func TestCommitPipelineMergesIntoRecordedTarget(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, runner := setupTestRunner(t, []string{repo})
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "Recorded target", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktreePaths, branchName, err := runner.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.UpdateTaskWorktrees(ctx, task.ID, worktreePaths, branchName)
+	s.UpdateTaskTargetBranches(ctx, task.ID, runner.targetBranches(worktreePaths))
+	s.UpdateTaskStatus(ctx, task.ID, "committing")
+
+	// The user switches the main checkout to another branch while the task runs.
+	gitRun(t, repo, "checkout", "-b", "experiment")
+
+	if err := os.WriteFile(filepath.Join(worktreePaths[repo], "f.txt"), []byte("x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	commitCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	if err := runner.commit(commitCtx, task.ID, "", 1, worktreePaths, branchName); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	if out := gitRun(t, repo, "ls-tree", "--name-only", "main"); !strings.Contains(out, "f.txt") {
+		t.Fatalf("expected the task's change merged into main, got tree:\n%s", out)
+	}
+	if out := gitRun(t, repo, "ls-tree", "--name-only", "experiment"); strings.Contains(out, "f.txt") {
+		t.Fatal("the task's change must not be merged into the checked-out branch")
+	}
+	got, _ := s.GetTask(ctx, task.ID)
+	if got.TargetBranches[repo] != "main" {
+		t.Fatalf("expected recorded target main, got %v", got.TargetBranches)
+	}
+}
+
+// TestCommitPipelineRecordsPhaseTiming verifies that the pipeline emits a
+// system event summarising how long each phase took.
+func TestCommitPipelineRecordsPhaseTiming(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, runner := setupTestRunner(t, []string{repo})
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "Timing", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktreePaths, branchName, err := runner.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(worktreePaths[repo], "t.txt"), []byte("t\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.commit(ctx, task.ID, "", 1, worktreePaths, branchName); err != nil {
+		t.Fatal(err)
+	}
+
+	events, _ := s.GetEvents(ctx, task.ID)
+	var summary string
+	for _, ev := range events {
+		if strings.Contains(string(ev.Data), "Commit pipeline timing:") {
+			summary = string(ev.Data)
+		}
+	}
+	if summary == "" {
+		t.Fatal("expected a commit pipeline timing event")
+	}
+	base := filepath.Base(repo)
+	for _, want := range []string{"stage ", "rebase[" + base + "]", "merge[" + base + "]", "cleanup ", "total "} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("timing summary missing %q: %s", want, summary)
+		}
+	}
+}
+
+// TestCommitPipelineNoWorktree verifies that in no-worktree mode the task
+// works on the workspace itself and the pipeline commits in place without
+// removing the workspace.
+func TestCommitPipelineNoWorktree(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, runner := setupTestRunner(t, []string{repo})
+	runner.noWorktree = true
+	ctx := context.Background()
+
+	task, err := s.CreateTask(ctx, "In place", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktreePaths, branchName, err := runner.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if worktreePaths[repo] != repo || branchName != "" {
+		t.Fatalf("expected in-place paths, got %v branch %q", worktreePaths, branchName)
+	}
+	initialHash := gitRun(t, repo, "rev-parse", "HEAD")
+	s.UpdateTaskWorktrees(ctx, task.ID, worktreePaths, branchName)
+	s.UpdateTaskBaseCommitHashes(ctx, task.ID, headHashes(worktreePaths))
+
+	if err := os.WriteFile(filepath.Join(repo, "inplace.txt"), []byte("x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.commit(ctx, task.ID, "", 1, worktreePaths, branchName); err != nil {
+		t.Fatal(err)
+	}
+
+	finalHash := gitRun(t, repo, "rev-parse", "HEAD")
+	if finalHash == initialHash {
+		t.Fatal("expected a new commit in the workspace")
+	}
+	if branch := gitRun(t, repo, "branch", "--show-current"); branch != "main" {
+		t.Fatalf("expected to stay on main, got %q", branch)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "inplace.txt")); err != nil {
+		t.Fatal("workspace must not be removed by cleanup:", err)
+	}
+	got, _ := s.GetTask(ctx, task.ID)
+	if got.CommitHashes[repo] != finalHash || got.BaseCommitHashes[repo] != initialHash {
+		t.Fatalf("unexpected hashes: commit=%v base=%v", got.CommitHashes, got.BaseCommitHashes)
+	}
+}
+
+// TestCommitPipelineSyncRemoteBeforeMerge verifies that with syncRemote set
+// the local default branch is fast-forwarded from origin before the task is
+// rebased, so the merged result sits on top of the remote tip.
+func TestCommitPipelineSyncRemoteBeforeMerge(t *testing.T) {
+	src := setupTestRepo(t)
+	origin := filepath.Join(t.TempDir(), "origin.git")
+	gitRun(t, src, "clone", "--bare", src, origin)
+	repo := filepath.Join(t.TempDir(), "repo")
+	gitRun(t, src, "clone", origin, repo)
+	gitRun(t, repo, "config", "user.email", "test@test.com")
+	gitRun(t, repo, "config", "user.name", "Test")
+
+	s, runner := setupTestRunner(t, []string{repo})
+	runner.syncRemote = true
+	ctx := context.Background()
+	task, err := s.CreateTask(ctx, "Add a file", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktreePaths, branchName, err := runner.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(worktreePaths[repo], "task.txt"), []byte("task\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone else pushes to origin after the task started.
+	other := filepath.Join(t.TempDir(), "other")
+	gitRun(t, src, "clone", origin, other)
+	gitRun(t, other, "config", "user.email", "test@test.com")
+	gitRun(t, other, "config", "user.name", "Test")
+	if err := os.WriteFile(filepath.Join(other, "remote.txt"), []byte("remote\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, other, "add", ".")
+	gitRun(t, other, "commit", "-m", "remote change")
+	gitRun(t, other, "push", "origin", "main")
+	remoteTip := gitRun(t, other, "rev-parse", "HEAD")
+
+	if err := runner.commit(ctx, task.ID, "", 1, worktreePaths, branchName); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if _, err := gitRunMayFail(repo, "merge-base", "--is-ancestor", remoteTip, "main"); err != nil {
+		t.Fatal("expected merged main to contain the remote tip")
+	}
+	if _, err := os.Stat(filepath.Join(repo, "task.txt")); err != nil {
+		t.Fatal("task.txt should exist after merge:", err)
+	}
+}
+
+// TestCommitPipelineAutoPush verifies that with autoPush set the merged
+// default branch lands on origin, rebasing onto commits pushed by someone
+// else in the meantime and retrying the rejected push.
+func TestCommitPipelineAutoPush(t *testing.T) {
+	src := setupTestRepo(t)
+	origin := filepath.Join(t.TempDir(), "origin.git")
+	gitRun(t, src, "clone", "--bare", src, origin)
+	repo := filepath.Join(t.TempDir(), "repo")
+	gitRun(t, src, "clone", origin, repo)
+	gitRun(t, repo, "config", "user.email", "test@test.com")
+	gitRun(t, repo, "config", "user.name", "Test")
+
+	s, runner := setupTestRunner(t, []string{repo})
+	runner.autoPush = true
+	ctx := context.Background()
+	task, err := s.CreateTask(ctx, "Add a file", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktreePaths, branchName, err := runner.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(worktreePaths[repo], "task.txt"), []byte("task\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone else pushes to origin, so the first push is rejected.
+	other := filepath.Join(t.TempDir(), "other")
+	gitRun(t, src, "clone", origin, other)
+	gitRun(t, other, "config", "user.email", "test@test.com")
+	gitRun(t, other, "config", "user.name", "Test")
+	if err := os.WriteFile(filepath.Join(other, "remote.txt"), []byte("remote\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, other, "add", ".")
+	gitRun(t, other, "commit", "-m", "remote change")
+	gitRun(t, other, "push", "origin", "main")
+	remoteTip := gitRun(t, other, "rev-parse", "HEAD")
+
+	if err := runner.commit(ctx, task.ID, "", 1, worktreePaths, branchName); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if got, want := gitRun(t, origin, "rev-parse", "main"), gitRun(t, repo, "rev-parse", "main"); got != want {
+		t.Fatalf("origin main = %s, want local main %s", got, want)
+	}
+	// The retry rebased the task's commit, so its recorded hashes must
+	// point at what was pushed.
+	got, _ := s.GetTask(ctx, task.ID)
+	if want := gitRun(t, origin, "rev-parse", "main"); got.CommitHashes[repo] != want {
+		t.Errorf("commit hash = %s, want pushed main %s", got.CommitHashes[repo], want)
+	}
+	if base, ok := got.BaseCommitHashes[repo]; ok && base != remoteTip {
+		t.Errorf("base commit hash = %s, want the remote tip %s", base, remoteTip)
+	}
+	if _, err := gitRunMayFail(origin, "cat-file", "-e", "main:task.txt"); err != nil {
+		t.Error("task.txt missing from origin main")
+	}
+	if _, err := gitRunMayFail(origin, "cat-file", "-e", "main:remote.txt"); err != nil {
+		t.Error("remote change lost from origin main")
+	}
+	events, _ := s.GetEvents(ctx, task.ID)
+	for _, e := range events {
+		if e.EventType == store.EventTypeError {
+			t.Errorf("unexpected error event: %s", e.Data)
+		}
+	}
+}
+
+// fakeForge records the pull requests it is asked to open.
+type fakeForge struct {
+	head, base, title string
+}
+
+func (f *fakeForge) OpenPullRequest(_, head, base, title, _ string) (string, error) {
+	f.head, f.base, f.title = head, base, title
+	return "https://forge.example/pr/1", nil
+}
+
+// TestCommitPipelinePullRequest verifies that the pull-request strategy
+// pushes the task branch and opens a PR instead of merging into main.
+func TestCommitPipelinePullRequest(t *testing.T) {
+	src := setupTestRepo(t)
+	origin := filepath.Join(t.TempDir(), "origin.git")
+	gitRun(t, src, "clone", "--bare", src, origin)
+	repo := filepath.Join(t.TempDir(), "repo")
+	gitRun(t, src, "clone", origin, repo)
+	gitRun(t, repo, "config", "user.email", "test@test.com")
+	gitRun(t, repo, "config", "user.name", "Test")
+
+	s, runner := setupTestRunner(t, []string{repo})
+	fake := &fakeForge{}
+	runner.forgeFor = func(string) (forge.Provider, error) { return fake, nil }
+	runner.autoPush = true // must not push main for a PR task
+	ctx := context.Background()
+	task, err := s.CreateTask(ctx, "Add a file\n\nwith details", 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetTaskMergeStrategy(ctx, task.ID, store.MergeStrategyPullRequest)
+	mainBefore := gitRun(t, repo, "rev-parse", "main")
+	worktreePaths, branchName, err := runner.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(worktreePaths[repo], "task.txt"), []byte("task\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runner.commit(ctx, task.ID, "", 1, worktreePaths, branchName); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if got := gitRun(t, repo, "rev-parse", "main"); got != mainBefore {
+		t.Error("local main moved; the PR strategy must not merge")
+	}
+	if got := gitRun(t, origin, "rev-parse", "main"); got != mainBefore {
+		t.Error("origin main moved; the PR strategy must not push main")
+	}
+	if _, err := gitRunMayFail(origin, "cat-file", "-e", branchName+":task.txt"); err != nil {
+		t.Errorf("task branch %s with task.txt not pushed to origin", branchName)
+	}
+	if fake.head != branchName || fake.base != "main" || fake.title != "Add a file" {
+		t.Errorf("PR opened with head=%q base=%q title=%q", fake.head, fake.base, fake.title)
+	}
+	got, _ := s.GetTask(ctx, task.ID)
+	if got.PullRequestURLs[repo] != "https://forge.example/pr/1" {
+		t.Errorf("PullRequestURLs = %v", got.PullRequestURLs)
+	}
+	if got.CommitHashes[repo] == "" {
+		t.Error("task head commit not recorded")
+	}
+}
+
 // TestCommitPipelineDivergedBranch tests the pipeline when the default branch
 // has advanced since the worktree was created. The task's changes must be
 // rebased on top of the latest default branch.
@@ -771,6 +1205,71 @@ func TestParallelTasksConflictingChanges(t *testing.T) {
 	}
 }
 
+// TestSetupWorktreesEmptyRepo verifies that a repository without commits
+// gets an empty initial commit, recorded as an event, so the task can branch.
+func TestSetupWorktreesEmptyRepo(t *testing.T) {
+	repo := t.TempDir()
+	gitRun(t, repo, "init", "-b", "main")
+	gitRun(t, repo, "config", "user.email", "test@test.com")
+	gitRun(t, repo, "config", "user.name", "Test")
+	s, runner := setupTestRunner(t, []string{repo})
+	task, _ := s.CreateTask(context.Background(), "scaffold", 5, false)
+
+	worktreePaths, _, err := runner.setupWorktrees(task.ID)
+	if err != nil {
+		t.Fatal("setupWorktrees on an empty repository:", err)
+	}
+	if _, err := os.Stat(worktreePaths[repo]); err != nil {
+		t.Fatal("worktree not created:", err)
+	}
+	if gitRun(t, repo, "rev-list", "--count", "main") != "1" {
+		t.Error("main should have exactly the initial commit")
+	}
+	if !hasEvent(t, s, task.ID, "created an empty initial commit on main") {
+		t.Error("missing event about the initial commit")
+	}
+}
+
+// TestGitDirMounts checks that the sandbox mounts the git directory of a
+// repository kept outside its work tree (GIT_DIR elsewhere), and nothing for
+// a workspace that contains its own .git or a worktree of such a checkout.
+func TestGitDirMounts(t *testing.T) {
+	gitDir := filepath.Join(t.TempDir(), "repo.git")
+	repo := t.TempDir()
+	gitRun(t, repo, "init", "-b", "main", "--separate-git-dir", gitDir)
+	gitRun(t, repo, "config", "user.email", "test@test.com")
+	gitRun(t, repo, "config", "user.name", "Test")
+	gitRun(t, repo, "commit", "--allow-empty", "-m", "initial commit")
+	gitDir, _ = filepath.EvalSymlinks(gitDir)
+
+	if got := gitDirMounts([]string{repo}); !slices.Equal(got, []string{gitDir}) {
+		t.Errorf("in place: gitDirMounts = %v, want [%s]", got, gitDir)
+	}
+
+	_, runner := setupTestRunner(t, []string{repo})
+	worktreePaths, _, err := runner.setupWorktrees(uuid.New())
+	if err != nil {
+		t.Fatal("setupWorktrees:", err)
+	}
+	if got := gitDirMounts([]string{worktreePaths[repo]}); !slices.Equal(got, []string{gitDir}) {
+		t.Errorf("worktree: gitDirMounts = %v, want [%s]", got, gitDir)
+	}
+
+	plain := setupTestRepo(t)
+	if got := gitDirMounts([]string{plain, t.TempDir()}); len(got) != 0 {
+		t.Errorf("plain repository: gitDirMounts = %v, want none", got)
+	}
+
+	_, runner = setupTestRunner(t, []string{plain})
+	worktreePaths, _, err = runner.setupWorktrees(uuid.New())
+	if err != nil {
+		t.Fatal("setupWorktrees:", err)
+	}
+	if got := gitDirMounts([]string{worktreePaths[plain]}); len(got) != 0 {
+		t.Errorf("worktree of a plain repository: gitDirMounts = %v, want none", got)
+	}
+}
+
 // TestSetupWorktreesRecreatesMissingDir reproduces the bug where a waiting
 // task's worktree directory is deleted (e.g. server restart, OS tmpfs cleanup)
 // while the underlying git branch survives. setupWorktrees must recreate the
@@ -0,0 +1,82 @@
+package runner
+
+import (
+	"context"
+	"time"
+
+	"changkun.de/wallfacer/internal/cron"
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+)
+
+// scheduleInterval is how often the scheduler checks the template
+// schedules. A variable so tests can shorten it.
+var scheduleInterval = 30 * time.Second
+
+// RunScheduler creates a backlog task from each scheduled template whenever
+// its cron schedule fires, until ctx is done.
+func (r *Runner) RunScheduler(ctx context.Context) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		r.scheduleStep(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scheduleStep creates a task from every template whose schedule fired
+// since its last run. A template seen for the first time only has its last
+// run set to now, so a new schedule waits for its next fire time. Runs
+// missed while the server was down are made up for once, not once per
+// missed fire. The last run is saved before the task is created, so a crash
+// in between loses a run instead of repeating it.
+func (r *Runner) scheduleStep(now time.Time) {
+	ctx := context.Background()
+	for _, tmpl := range r.store.Templates() {
+		if tmpl.Schedule == "" {
+			continue
+		}
+		sched, err := cron.Parse(tmpl.Schedule)
+		if err != nil {
+			logger.Runner.Warn("scheduler: invalid schedule", "template", tmpl.Name, "error", err)
+			continue
+		}
+		if tmpl.LastRun != nil {
+			next := sched.Next(tmpl.LastRun.In(now.Location()))
+			if next.IsZero() || next.After(now) {
+				continue
+			}
+		}
+		if err := r.store.SetTemplateLastRun(ctx, tmpl.Name, now); err != nil {
+			logger.Runner.Warn("scheduler: save last run", "template", tmpl.Name, "error", err)
+			continue
+		}
+		if tmpl.LastRun != nil {
+			r.createScheduledTask(tmpl)
+		}
+	}
+}
+
+// createScheduledTask adds a backlog task built from tmpl.
+func (r *Runner) createScheduledTask(tmpl store.Template) {
+	ctx := context.Background()
+	task, err := r.store.CreateTaskWith(ctx, tmpl.Prompt, tmpl.Timeout, false, func(t *store.Task) {
+		t.Model = tmpl.Model
+	})
+	if err != nil {
+		logger.Runner.Error("scheduler: create task", "template", tmpl.Name, "error", err)
+		return
+	}
+	r.store.InsertEvent(ctx, task.ID, store.EventTypeStateChange, map[string]string{
+		"to": "backlog",
+	})
+	r.store.InsertEvent(ctx, task.ID, store.EventTypeSystem, map[string]string{
+		"result": "Created by the schedule of template " + tmpl.Name + " (" + tmpl.Schedule + ").",
+	})
+	logger.Runner.Info("scheduled task created", "template", tmpl.Name, "task", task.ID)
+	go r.GenerateTitle(task.ID, task.Prompt)
+}
@@ -0,0 +1,98 @@
+package runner
+
+import (
+	"fmt"
+	"time"
+
+	"changkun.de/wallfacer/internal/logger"
+	"github.com/google/uuid"
+)
+
+// spendWindow is the rolling window over which the hourly spend cap applies.
+// A variable so tests can shorten it.
+var spendWindow = time.Hour
+
+// spendPollInterval is how often a task queued by the hourly spend cap
+// re-checks the spend of the last hour and its own status. A variable so
+// tests can shorten it.
+var spendPollInterval = time.Minute
+
+// MaxHourlySpend returns the configured server-wide spend cap in USD per
+// rolling hour. Zero means unlimited.
+func (r *Runner) MaxHourlySpend() float64 {
+	return r.maxHourlySpend
+}
+
+// HourlySpend returns the total cost of turns recorded within the last
+// hour. It is read from the store's spend ledger, so neither a restart nor
+// deleting tasks lowers it.
+func (r *Runner) HourlySpend() float64 {
+	return r.store.SpendSince(time.Now().Add(-spendWindow))
+}
+
+// waitForSpendBudget holds a task in the "queued" status while the spend of
+// the last hour is at the hourly cap, moving it back to in_progress once
+// enough of it ages out of the window. Time spent queued is added back to
+// deadline. Returns false if the task left the queue while waiting
+// (cancelled or deleted), in which case the caller must stop without
+// touching its status.
+func (r *Runner) waitForSpendBudget(taskID uuid.UUID, deadline *taskDeadline) bool {
+	if r.maxHourlySpend <= 0 {
+		return true
+	}
+	spent := r.HourlySpend()
+	if spent < r.maxHourlySpend {
+		return true
+	}
+	logger.Runner.Warn("hourly spend cap reached, queueing task",
+		"task", taskID, "spent", spent, "cap", r.maxHourlySpend)
+	reason := fmt.Sprintf("Hourly spend cap reached ($%.2f of $%.2f in the last hour). "+
+		"The task is queued until enough of that spend is an hour old.", spent, r.maxHourlySpend)
+	return r.holdQueued(taskID, deadline, spendPollInterval, reason, func() bool {
+		return r.HourlySpend() >= r.maxHourlySpend
+	})
+}
+
+// dailyBudgetPollInterval is how often a task queued by the daily cost limit
+// re-checks the day's spend and its own status. A variable so tests can
+// shorten it.
+var dailyBudgetPollInterval = time.Minute
+
+// DailyCostLimit returns the configured cap in USD on spend per day across
+// all tasks. Zero means unlimited.
+func (r *Runner) DailyCostLimit() float64 {
+	return r.dailyLimit
+}
+
+// DailySpend returns the cost of all turns recorded since local midnight,
+// and that midnight. It is read from the store's spend ledger, so neither a
+// restart nor deleting the tasks that spent it lowers it.
+func (r *Runner) DailySpend() (float64, time.Time) {
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return r.store.DailySpend(now), midnight
+}
+
+// waitForDailyBudget holds a task in the "queued" status while the day's
+// spend is at the daily cost limit, moving it back to in_progress once the
+// cap resets at midnight. Time spent queued is
+// added back to deadline. Returns false if the task left the queue while
+// waiting (cancelled or deleted), in which case the caller must stop
+// without touching its status.
+func (r *Runner) waitForDailyBudget(taskID uuid.UUID, deadline *taskDeadline) bool {
+	if r.dailyLimit <= 0 {
+		return true
+	}
+	spent, _ := r.DailySpend()
+	if spent < r.dailyLimit {
+		return true
+	}
+	logger.Runner.Warn("daily cost limit reached, queueing task",
+		"task", taskID, "spent", spent, "limit", r.dailyLimit)
+	reason := fmt.Sprintf("Daily cost limit reached ($%.2f of $%.2f spent today). "+
+		"The task is queued until the limit resets at midnight.", spent, r.dailyLimit)
+	return r.holdQueued(taskID, deadline, dailyBudgetPollInterval, reason, func() bool {
+		spent, _ := r.DailySpend()
+		return spent >= r.dailyLimit
+	})
+}
@@ -0,0 +1,123 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"changkun.de/wallfacer/internal/store"
+)
+
+func TestHourlySpendFromLedger(t *testing.T) {
+	s, r := setupTestRunner(t, nil)
+	a, _ := s.CreateTask(bg(), "a", 5, false)
+	b, _ := s.CreateTask(bg(), "b", 5, false)
+	s.AccumulateTaskUsage(bg(), a.ID, store.TaskUsage{CostUSD: 0.25})
+	s.AccumulateTaskUsage(bg(), b.ID, store.TaskUsage{CostUSD: 0.5})
+	if got := r.HourlySpend(); got != 0.75 {
+		t.Fatalf("HourlySpend = %v, want 0.75", got)
+	}
+
+	// The spend is read from the store, so a restarted runner sees it.
+	restarted := NewRunner(s, RunnerConfig{Command: "echo"})
+	if got := restarted.HourlySpend(); got != 0.75 {
+		t.Fatalf("HourlySpend after restart = %v, want 0.75", got)
+	}
+
+	// Deleting the task that spent the money does not lower it.
+	s.DeleteTask(bg(), b.ID)
+	if got := r.HourlySpend(); got != 0.75 {
+		t.Fatalf("HourlySpend after deleting a task = %v, want 0.75", got)
+	}
+}
+
+// TestRunQueuesAtHourlySpendCap verifies that a task is queued instead of
+// starting a turn while the last hour's spend is at the cap, keeps its
+// status if cancelled while queued, stays queued when the task that spent
+// the money is deleted, and resumes once the spend ages out of the window.
+func TestRunQueuesAtHourlySpendCap(t *testing.T) {
+	oldPoll, oldWindow := spendPollInterval, spendWindow
+	spendPollInterval = 10 * time.Millisecond
+	spendWindow = time.Second
+	t.Cleanup(func() { spendPollInterval, spendWindow = oldPoll, oldWindow })
+
+	repo := setupTestRepo(t)
+	s, r := setupRunnerWithCmd(t, []string{repo}, fakeCmdScript(t, endTurnOutput, 0))
+	r.maxHourlySpend = 1
+	spender, _ := s.CreateTask(bg(), "earlier work", 5, false)
+	s.AccumulateTaskUsage(bg(), spender.ID, store.TaskUsage{CostUSD: 2})
+
+	cancelled, _ := s.CreateTask(bg(), "cancelled", 5, false)
+	s.UpdateTaskStatus(bg(), cancelled.ID, "in_progress")
+	cancelledDone := make(chan struct{})
+	go func() { r.Run(cancelled.ID, "p", "", false); close(cancelledDone) }()
+	waitStatus(t, s, cancelled.ID, "queued")
+	s.UpdateTaskStatus(bg(), cancelled.ID, "cancelled")
+	<-cancelledDone
+	if got, _ := s.GetTask(bg(), cancelled.ID); got.Status != "cancelled" || got.Turns != 0 {
+		t.Fatalf("cancelled task: status %q after %d turns", got.Status, got.Turns)
+	}
+	if !hasEvent(t, s, cancelled.ID, "Hourly spend cap reached") {
+		t.Error("expected a system event explaining the hold")
+	}
+
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	s.UpdateTaskStatus(bg(), task.ID, "in_progress")
+	runDone := make(chan struct{})
+	go func() { r.Run(task.ID, "p", "", false); close(runDone) }()
+	waitStatus(t, s, task.ID, "queued")
+
+	s.DeleteTask(bg(), spender.ID)
+	time.Sleep(5 * spendPollInterval)
+	if got, _ := s.GetTask(bg(), task.ID); got.Status != "queued" {
+		t.Fatalf("status = %q after deleting the spender, want queued", got.Status)
+	}
+	<-runDone
+	if got, _ := s.GetTask(bg(), task.ID); got.Status != "done" {
+		t.Fatalf("status = %q after the spend aged out, want done", got.Status)
+	}
+}
+
+// TestRunQueuesAtDailyCostLimit verifies that a task is queued instead of
+// starting a turn while the day's spend is at the daily limit, stays queued
+// when the task that spent it is deleted, and never runs if cancelled while
+// queued.
+func TestRunQueuesAtDailyCostLimit(t *testing.T) {
+	old := dailyBudgetPollInterval
+	dailyBudgetPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { dailyBudgetPollInterval = old })
+
+	repo := setupTestRepo(t)
+	s, r := setupRunnerWithCmd(t, []string{repo}, fakeCmdScript(t, endTurnOutput, 0))
+	r.dailyLimit = 5
+	spender, _ := s.CreateTask(bg(), "earlier work", 5, false)
+	s.AccumulateTaskUsage(bg(), spender.ID, store.TaskUsage{CostUSD: 5})
+
+	cancelled, _ := s.CreateTask(bg(), "cancelled", 5, false)
+	s.UpdateTaskStatus(bg(), cancelled.ID, "in_progress")
+	cancelledDone := make(chan struct{})
+	go func() { r.Run(cancelled.ID, "p", "", false); close(cancelledDone) }()
+	waitStatus(t, s, cancelled.ID, "queued")
+	s.UpdateTaskStatus(bg(), cancelled.ID, "cancelled")
+	<-cancelledDone
+	if got, _ := s.GetTask(bg(), cancelled.ID); got.Status != "cancelled" || got.Turns != 0 {
+		t.Fatalf("cancelled task: status %q after %d turns", got.Status, got.Turns)
+	}
+
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	s.UpdateTaskStatus(bg(), task.ID, "in_progress")
+	runDone := make(chan struct{})
+	go func() { r.Run(task.ID, "p", "", false); close(runDone) }()
+	waitStatus(t, s, task.ID, "queued")
+
+	// Deleting the task that spent the money does not lift the limit.
+	s.DeleteTask(bg(), spender.ID)
+	time.Sleep(5 * dailyBudgetPollInterval)
+	if spent, _ := r.DailySpend(); spent != 5 {
+		t.Fatalf("DailySpend after deleting the spender = %v, want 5", spent)
+	}
+	if got, _ := s.GetTask(bg(), task.ID); got.Status != "queued" {
+		t.Fatalf("status = %q after deleting the spender, want queued", got.Status)
+	}
+	s.UpdateTaskStatus(bg(), task.ID, "cancelled")
+	<-runDone
+}
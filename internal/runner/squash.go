@@ -0,0 +1,61 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"changkun.de/wallfacer/internal/gitutil"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// squashTaskCommits folds the commits a task made on top of target into one
+// commit with a generated message, so the merge lands a single commit. It
+// applies to tasks landed with store.MergeStrategySquash, runs before the
+// rebase, and leaves tasks with at most one commit as they are. Caller must hold the repo lock.
+func (r *Runner) squashTaskCommits(taskID uuid.UUID, repoPath, worktreePath, target string, timer *phaseTimer) error {
+	bgCtx := context.Background()
+	task, err := r.store.GetTask(bgCtx, taskID)
+	if err != nil || r.mergeStrategyFor(task) != store.MergeStrategySquash {
+		return nil
+	}
+	base, err := gitutil.MergeBase(worktreePath, target, "HEAD")
+	if err != nil {
+		return fmt.Errorf("squash %s: %w", repoPath, err)
+	}
+	commits, err := gitutil.CommitsInRange(worktreePath, base, "HEAD")
+	if err != nil {
+		return fmt.Errorf("squash %s: %w", repoPath, err)
+	}
+	if len(commits) < 2 {
+		return nil
+	}
+
+	start := time.Now()
+	msg := r.rangeCommitMessage(task, worktreePath, base)
+	err = gitutil.SquashOnto(worktreePath, base, msg, hostIdentityArgs()...)
+	timer.track("squash", repoPath, start)
+	if err != nil {
+		return fmt.Errorf("squash %s: %w", repoPath, err)
+	}
+	r.store.InsertEvent(bgCtx, taskID, store.EventTypeSystem, map[string]string{
+		"result": fmt.Sprintf("Squashed %d commits of %s into one.", len(commits), repoPath),
+	})
+	return nil
+}
+
+// rangeCommitMessage generates a commit message for everything the task
+// changed in worktreePath since base, labelled with the task title per its
+// CommitTitle mode. It is used for squashed commits and merge commits.
+func (r *Runner) rangeCommitMessage(task *store.Task, worktreePath, base string) string {
+	statOut, _ := exec.Command("git", "-C", worktreePath, "diff", "--stat", base, "HEAD").Output()
+	logOut, _ := exec.Command("git", "-C", worktreePath, "log", "--format=%s", "-5", base).Output()
+	msg := r.generateCommitMessage(task.ID, task.Prompt, strings.TrimSpace(string(statOut)), strings.TrimSpace(string(logOut)))
+	if task.CommitTitle != "" {
+		msg = labelCommitMessage(msg, task.Title, task.CommitTitle)
+	}
+	return msg
+}
@@ -0,0 +1,62 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"changkun.de/wallfacer/internal/logger"
+	"github.com/google/uuid"
+)
+
+// phaseTiming is the measured duration of one commit-pipeline phase. Repo is
+// empty for phases that span all workspaces (stage, cleanup).
+type phaseTiming struct {
+	phase    string
+	repo     string
+	duration time.Duration
+}
+
+// phaseTimer collects per-phase durations of a task's commit pipeline. Each
+// phase is logged as it finishes with the same fields (task, phase, repo,
+// duration_ms) so the timeline can be queried in JSON log mode. A nil
+// *phaseTimer is valid and records nothing.
+type phaseTimer struct {
+	taskID uuid.UUID
+	start  time.Time
+	phases []phaseTiming
+}
+
+func newPhaseTimer(taskID uuid.UUID) *phaseTimer {
+	return &phaseTimer{taskID: taskID, start: time.Now()}
+}
+
+// track records the time elapsed since start as the duration of phase.
+func (t *phaseTimer) track(phase, repo string, start time.Time) {
+	if t == nil {
+		return
+	}
+	d := time.Since(start)
+	t.phases = append(t.phases, phaseTiming{phase: phase, repo: repo, duration: d})
+	logger.Runner.Info("commit phase", "task", t.taskID, "phase", phase, "repo", repo,
+		"duration_ms", d.Milliseconds())
+}
+
+// summary renders the recorded phases in order, e.g.
+// "stage 120ms, rebase[app] 1.5s, merge[app] 30ms (total 1.7s)".
+func (t *phaseTimer) summary() string {
+	if t == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(t.phases))
+	for _, p := range t.phases {
+		name := p.phase
+		if p.repo != "" {
+			name += "[" + filepath.Base(p.repo) + "]"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s", name, p.duration.Round(time.Millisecond)))
+	}
+	return fmt.Sprintf("%s (total %s)", strings.Join(parts, ", "),
+		time.Since(t.start).Round(time.Millisecond))
+}
@@ -2,6 +2,8 @@ package runner
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 
@@ -9,17 +11,32 @@ import (
 	"github.com/google/uuid"
 )
 
+// titleTimeout bounds a single title-generation sandbox run.
+const titleTimeout = 60 * time.Second
+
 // GenerateTitle runs a lightweight one-shot sandbox to produce a 2-5 word title
 // summarising the task prompt, then persists it via the store.
 // Errors are logged and silently dropped so callers can fire-and-forget.
 func (r *Runner) GenerateTitle(taskID uuid.UUID, prompt string) {
+	if _, err := r.GenerateTitleContext(context.Background(), taskID, prompt); err != nil {
+		logger.Runner.Warn("title generation failed", "task", taskID, "error", err)
+	}
+}
+
+// GenerateTitleContext is the synchronous form of GenerateTitle: it returns
+// the persisted title, or the existing one if the task already has a title.
+// The run is bounded by ctx and by titleTimeout, whichever ends first.
+func (r *Runner) GenerateTitleContext(ctx context.Context, taskID uuid.UUID, prompt string) (string, error) {
 	// Skip if the task already has a title.
 	if t, err := r.store.GetTask(context.Background(), taskID); err == nil && t.Title != "" {
-		return
+		return t.Title, nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, titleTimeout)
 	defer cancel()
+	gen := &titleGen{cancel: cancel}
+	r.trackTitleGen(taskID, gen)
+	defer r.untrackTitleGen(taskID, gen)
 
 	name := "wf-t-" + taskID.String()[:8]
 
@@ -28,19 +45,68 @@ func (r *Runner) GenerateTitle(taskID uuid.UUID, prompt string) {
 
 	output, err := r.runOneShotSandbox(ctx, name, titlePrompt, nil)
 	if err != nil {
-		logger.Runner.Warn("title generation failed", "task", taskID, "error", err)
-		return
+		return "", err
 	}
 
 	title := strings.TrimSpace(output.Result)
 	title = strings.Trim(title, `"'`)
 	title = strings.TrimSpace(title)
 	if title == "" {
-		logger.Runner.Warn("title generation: blank result", "task", taskID)
-		return
+		return "", errors.New("blank result")
 	}
 
 	if err := r.store.UpdateTaskTitle(context.Background(), taskID, title); err != nil {
-		logger.Runner.Warn("title generation: store update failed", "task", taskID, "error", err)
+		return "", fmt.Errorf("store update: %w", err)
+	}
+	return title, nil
+}
+
+// titleGen is an in-flight title generation that can be aborted.
+type titleGen struct {
+	cancel context.CancelFunc
+}
+
+// trackTitleGen registers an in-flight title generation for a task.
+func (r *Runner) trackTitleGen(taskID uuid.UUID, gen *titleGen) {
+	r.titleMu.Lock()
+	defer r.titleMu.Unlock()
+	if r.titleGens == nil {
+		r.titleGens = make(map[uuid.UUID][]*titleGen)
+	}
+	r.titleGens[taskID] = append(r.titleGens[taskID], gen)
+}
+
+// untrackTitleGen removes a finished title generation.
+func (r *Runner) untrackTitleGen(taskID uuid.UUID, gen *titleGen) {
+	r.titleMu.Lock()
+	defer r.titleMu.Unlock()
+	gens := r.titleGens[taskID]
+	for i, g := range gens {
+		if g == gen {
+			gens = append(gens[:i], gens[i+1:]...)
+			break
+		}
+	}
+	if len(gens) == 0 {
+		delete(r.titleGens, taskID)
+	} else {
+		r.titleGens[taskID] = gens
+	}
+}
+
+// cancelTitleGeneration aborts any title generation still running for a
+// task, so its one-shot sandbox does not compete with the task's own run.
+// Returns the number of generations cancelled.
+func (r *Runner) cancelTitleGeneration(taskID uuid.UUID) int {
+	r.titleMu.Lock()
+	gens := r.titleGens[taskID]
+	delete(r.titleGens, taskID)
+	r.titleMu.Unlock()
+	for _, g := range gens {
+		g.cancel()
+	}
+	if len(gens) > 0 {
+		logger.Runner.Info("cancelled title generation for starting task", "task", taskID)
 	}
+	return len(gens)
 }
@@ -0,0 +1,257 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"changkun.de/wallfacer/internal/forge"
+	"changkun.de/wallfacer/internal/gitutil"
+	"changkun.de/wallfacer/internal/logger"
+	"changkun.de/wallfacer/internal/store"
+	"github.com/google/uuid"
+)
+
+// webhookTimeout bounds a single webhook delivery.
+const webhookTimeout = 10 * time.Second
+
+// webhookPayload is the JSON body posted to the webhook URL when a task
+// finishes. Text is a one-line summary so chat services such as Slack
+// incoming webhooks can render it directly.
+type webhookPayload struct {
+	Event    string       `json:"event"`
+	TaskID   uuid.UUID    `json:"task_id"`
+	Title    string       `json:"title,omitempty"`
+	Status   string       `json:"status"`
+	Text     string       `json:"text"`
+	DiffStat *webhookDiff `json:"diff_stat,omitempty"`
+}
+
+// webhookDiff is the size of the change a task merged, summed across repos.
+type webhookDiff struct {
+	gitutil.DiffStat
+	Commits map[string]string `json:"commits,omitempty"` // repoPath → commit hash
+}
+
+// Kinds of webhook payload.
+const (
+	// WebhookKindGeneric posts webhookPayload as JSON.
+	WebhookKindGeneric = "generic"
+	// WebhookKindSlack posts a Slack incoming-webhook message with blocks.
+	WebhookKindSlack = "slack"
+)
+
+// DefaultWebhookEvents are the task statuses that trigger the webhook when
+// none are configured.
+var DefaultWebhookEvents = []string{"done", "failed"}
+
+// WebhookEventStatuses are the task statuses the webhook can be sent for.
+var WebhookEventStatuses = []string{"done", "failed", "waiting"}
+
+// NotifyDone is called whenever a task reaches done. It starts dependents
+// that became ready (with AutoStartDependents), compacts the task's turn
+// outputs and posts a task_done payload to the configured webhook in the
+// background, if any.
+func (r *Runner) NotifyDone(taskID uuid.UUID) {
+	r.startReadyDependents(taskID)
+	r.compactOutputs(taskID)
+	r.notifyWebhook(taskID, "done")
+}
+
+// NotifyFailed is called whenever a task reaches failed, e.g. when the
+// commit pipeline started by mark-done fails. It compacts the task's turn
+// outputs and posts a task_failed payload if failures are among the webhook
+// events.
+func (r *Runner) NotifyFailed(taskID uuid.UUID) {
+	r.compactOutputs(taskID)
+	r.notifyWebhook(taskID, "failed")
+}
+
+// notifyWebhook posts the webhook for a task that has reached status, in
+// the background, when a webhook is configured and status is among its
+// events.
+func (r *Runner) notifyWebhook(taskID uuid.UUID, status string) {
+	if r.webhookURL == "" || !slices.Contains(r.webhookEvents, status) {
+		return
+	}
+	task, err := r.store.GetTask(context.Background(), taskID)
+	if err != nil {
+		logger.Runner.Warn("webhook: get task", "task", taskID, "error", err)
+		return
+	}
+	var payload any = r.donePayload(task)
+	if r.webhookKind == WebhookKindSlack {
+		payload = slackPayload(task)
+	}
+	go r.postWebhook(taskID, payload)
+}
+
+// taskName is how notifications refer to a task: its title, or the short
+// ID while it has none.
+func taskName(task *store.Task) string {
+	if task.Title != "" {
+		return task.Title
+	}
+	return task.ID.String()[:8]
+}
+
+// donePayload builds the webhook body for a finished task, attaching the
+// diff stat when enabled and the task merged commits.
+func (r *Runner) donePayload(task *store.Task) webhookPayload {
+	name := taskName(task)
+	p := webhookPayload{
+		Event:  "task_" + task.Status,
+		TaskID: task.ID,
+		Title:  task.Title,
+		Status: task.Status,
+		Text:   fmt.Sprintf("task %s %s", name, task.Status),
+	}
+	if !r.webhookDiffStat || len(task.CommitHashes) == 0 {
+		return p
+	}
+
+	diff := &webhookDiff{Commits: task.CommitHashes}
+	repos := make([]string, 0, len(task.CommitHashes))
+	for repo := range task.CommitHashes {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	var short []string
+	for _, repo := range repos {
+		commit := task.CommitHashes[repo]
+		short = append(short, shortHash(commit))
+		base := task.BaseCommitHashes[repo]
+		if base == "" {
+			continue
+		}
+		st, err := gitutil.DiffShortStat(repo, base, commit)
+		if err != nil {
+			logger.Runner.Warn("webhook: diff stat", "task", task.ID, "repo", repo, "error", err)
+			continue
+		}
+		diff.FilesChanged += st.FilesChanged
+		diff.Insertions += st.Insertions
+		diff.Deletions += st.Deletions
+	}
+	p.DiffStat = diff
+	p.Text = fmt.Sprintf("task %s done: %d files, +%d/-%d, commit %s",
+		name, diff.FilesChanged, diff.Insertions, diff.Deletions, strings.Join(short, ", "))
+	return p
+}
+
+// Slack attachment colors per task status.
+var slackColors = map[string]string{
+	"done":    "#2eb67d",
+	"failed":  "#e01e5a",
+	"waiting": "#ecb22e",
+}
+
+// slackResultLimit caps the result snippet in Slack messages.
+const slackResultLimit = 300
+
+// slackPayload formats a task notification as a Slack incoming-webhook
+// message: a colored attachment holding the title and status, the cost,
+// the merged commits (linked when the repo's origin is a known code host),
+// and the start of the task's result.
+func slackPayload(task *store.Task) map[string]any {
+	name := taskName(task)
+	fields := []map[string]any{
+		{"type": "mrkdwn", "text": "*Status*\n" + task.Status},
+		{"type": "mrkdwn", "text": fmt.Sprintf("*Cost*\n$%.4f", task.Usage.CostUSD)},
+	}
+	if commits := slackCommits(task.CommitHashes); commits != "" {
+		fields = append(fields, map[string]any{"type": "mrkdwn", "text": "*Commit*\n" + commits})
+	}
+	blocks := []map[string]any{
+		{"type": "section", "text": map[string]any{"type": "mrkdwn", "text": fmt.Sprintf("*%s* %s", slackEscape(name), task.Status)}},
+		{"type": "section", "fields": fields},
+	}
+	if task.Result != nil && strings.TrimSpace(*task.Result) != "" {
+		snippet := strings.TrimSpace(*task.Result)
+		if r := []rune(snippet); len(r) > slackResultLimit {
+			snippet = string(r[:slackResultLimit]) + "…"
+		}
+		blocks = append(blocks, map[string]any{
+			"type":     "context",
+			"elements": []map[string]any{{"type": "mrkdwn", "text": slackEscape(snippet)}},
+		})
+	}
+	color := slackColors[task.Status]
+	if color == "" {
+		color = "#868686"
+	}
+	return map[string]any{
+		"text":        fmt.Sprintf("task %s %s", name, task.Status),
+		"attachments": []map[string]any{{"color": color, "blocks": blocks}},
+	}
+}
+
+// slackCommits lists the short hash of each merged commit, sorted by repo,
+// linking those whose origin is a recognised code host.
+func slackCommits(hashes map[string]string) string {
+	repos := slices.Sorted(maps.Keys(hashes))
+	var parts []string
+	for _, repo := range repos {
+		hash := hashes[repo]
+		link := shortHash(hash)
+		if remote, err := gitutil.RemoteURL(repo, "origin"); err == nil {
+			if u := forge.CommitURL(remote, hash); u != "" {
+				link = fmt.Sprintf("<%s|%s>", u, shortHash(hash))
+			}
+		}
+		if len(repos) > 1 {
+			link = filepath.Base(repo) + " " + link
+		}
+		parts = append(parts, link)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// slackEscape escapes the characters Slack's mrkdwn treats as control
+// sequences.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// postWebhook delivers the payload, logging rather than returning failures
+// so a broken endpoint never affects task state.
+func (r *Runner) postWebhook(taskID uuid.UUID, payload any) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.Runner.Warn("webhook: marshal", "task", taskID, "error", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		logger.Runner.Warn("webhook: build request", "task", taskID, "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Runner.Warn("webhook: deliver", "task", taskID, "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logger.Runner.Warn("webhook: unexpected status", "task", taskID, "status", resp.StatusCode)
+	}
+}
+
+// shortHash abbreviates a commit hash to 7 characters.
+func shortHash(h string) string {
+	if len(h) > 7 {
+		return h[:7]
+	}
+	return h
+}
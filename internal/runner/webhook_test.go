@@ -0,0 +1,142 @@
+package runner
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDonePayloadDiffStat verifies that the done payload carries the summed
+// diff stat and a one-line summary when the diff stat is enabled.
+func TestDonePayloadDiffStat(t *testing.T) {
+	repo := setupTestRepo(t)
+	s, r := setupTestRunner(t, []string{repo})
+	r.webhookDiffStat = true
+
+	base := gitRun(t, repo, "rev-parse", "HEAD")
+	os.WriteFile(filepath.Join(repo, "a.txt"), []byte("one\ntwo\n"), 0644)
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-m", "add a")
+	head := gitRun(t, repo, "rev-parse", "HEAD")
+
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	s.UpdateTaskTitle(bg(), task.ID, "Add a")
+	s.UpdateTaskBaseCommitHashes(bg(), task.ID, map[string]string{repo: base})
+	s.UpdateTaskCommitHashes(bg(), task.ID, map[string]string{repo: head})
+	s.UpdateTaskStatus(bg(), task.ID, "done")
+	task, _ = s.GetTask(bg(), task.ID)
+
+	p := r.donePayload(task)
+	if p.DiffStat == nil {
+		t.Fatal("expected diff_stat in payload")
+	}
+	if p.DiffStat.FilesChanged != 1 || p.DiffStat.Insertions != 2 || p.DiffStat.Deletions != 0 {
+		t.Errorf("diff stat = %+v", p.DiffStat.DiffStat)
+	}
+	if want := "task Add a done: 1 files, +2/-0, commit " + head[:7]; p.Text != want {
+		t.Errorf("text = %q, want %q", p.Text, want)
+	}
+
+	r.webhookDiffStat = false
+	if p := r.donePayload(task); p.DiffStat != nil || p.Text != "task Add a done" {
+		t.Errorf("diff stat disabled: got %+v", p)
+	}
+}
+
+// TestNotifyDonePosts verifies that NotifyDone delivers a JSON payload to
+// the configured URL.
+func TestNotifyDonePosts(t *testing.T) {
+	got := make(chan webhookPayload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var p webhookPayload
+		json.NewDecoder(req.Body).Decode(&p)
+		got <- p
+	}))
+	defer srv.Close()
+
+	s, r := setupTestRunner(t, nil)
+	r.webhookURL = srv.URL
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	s.UpdateTaskStatus(bg(), task.ID, "done")
+
+	r.NotifyDone(task.ID)
+	select {
+	case p := <-got:
+		if p.Event != "task_done" || p.TaskID != task.ID || p.Status != "done" {
+			t.Errorf("unexpected payload %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+}
+
+// TestSlackPayload verifies the Slack message carries the status color,
+// the linked commit and the result snippet.
+func TestSlackPayload(t *testing.T) {
+	repo := setupTestRepo(t)
+	gitRun(t, repo, "remote", "add", "origin", "git@github.com:acme/widgets.git")
+	s, _ := setupTestRunner(t, []string{repo})
+	head := gitRun(t, repo, "rev-parse", "HEAD")
+
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	s.UpdateTaskTitle(bg(), task.ID, "Fix <login>")
+	s.UpdateTaskCommitHashes(bg(), task.ID, map[string]string{repo: head})
+	s.UpdateTaskResult(bg(), task.ID, strings.Repeat("x", slackResultLimit+50), "sess", "end_turn", 1)
+	s.UpdateTaskStatus(bg(), task.ID, "failed")
+	task, _ = s.GetTask(bg(), task.ID)
+
+	body, _ := json.Marshal(slackPayload(task))
+	got := string(body)
+	for _, want := range []string{
+		`"color":"#e01e5a"`,
+		`*Fix \u0026lt;login\u0026gt;* failed`,
+		"https://github.com/acme/widgets/commit/" + head + "|" + head[:7],
+		strings.Repeat("x", slackResultLimit) + "…",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("payload missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, strings.Repeat("x", slackResultLimit+1)) {
+		t.Error("result snippet was not truncated")
+	}
+}
+
+// TestNotifyWebhookEventFilter verifies that only statuses among the
+// configured events are delivered.
+func TestNotifyWebhookEventFilter(t *testing.T) {
+	got := make(chan webhookPayload, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var p webhookPayload
+		json.NewDecoder(req.Body).Decode(&p)
+		got <- p
+	}))
+	defer srv.Close()
+
+	s, r := setupTestRunner(t, nil)
+	r.webhookURL = srv.URL
+	r.webhookEvents = []string{"failed"}
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	s.UpdateTaskStatus(bg(), task.ID, "failed")
+
+	r.NotifyDone(task.ID)
+	r.NotifyFailed(task.ID)
+	select {
+	case p := <-got:
+		if p.Event != "task_failed" {
+			t.Errorf("event = %q, want task_failed", p.Event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+	select {
+	case p := <-got:
+		t.Errorf("unexpected extra delivery %+v", p)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 
 	"changkun.de/wallfacer/internal/gitutil"
 	"changkun.de/wallfacer/internal/logger"
@@ -12,6 +15,36 @@ import (
 	"github.com/google/uuid"
 )
 
+// adjacentWorktreesDirName is the directory created next to each workspace to
+// hold task worktrees when WorktreesNearRepo is enabled.
+const adjacentWorktreesDirName = ".wallfacer-worktrees"
+
+// worktreeRoot returns the directory under which new task worktrees for ws
+// are created.
+func (r *Runner) worktreeRoot(ws string) string {
+	if r.worktreesNearRepo {
+		return filepath.Join(filepath.Dir(ws), adjacentWorktreesDirName)
+	}
+	return r.worktreesDir
+}
+
+// worktreeRoots returns every directory that may hold task worktrees: the
+// central worktreesDir followed by the directories adjacent to each
+// workspace. Cleanup and pruning scan all of them so worktrees are found
+// regardless of the setting they were created under.
+func (r *Runner) worktreeRoots() []string {
+	roots := []string{r.worktreesDir}
+	seen := map[string]bool{r.worktreesDir: true}
+	for _, ws := range r.Workspaces() {
+		dir := filepath.Join(filepath.Dir(ws), adjacentWorktreesDirName)
+		if !seen[dir] {
+			seen[dir] = true
+			roots = append(roots, dir)
+		}
+	}
+	return roots
+}
+
 // setupWorktrees creates an isolated working directory for each workspace.
 // For git-backed workspaces a proper git worktree is created.
 // For non-git workspaces a snapshot copy is created and tracked with a local
@@ -19,16 +52,28 @@ import (
 // Returns (worktreePaths, branchName, error).
 // Idempotent: if the worktree/snapshot directory already exists it is reused.
 func (r *Runner) setupWorktrees(taskID uuid.UUID) (map[string]string, string, error) {
-	branchName := "task/" + taskID.String()[:8]
+	branchName := renderBranchName(r.branchTemplate, taskID, "")
+	if task, err := r.store.GetTask(context.Background(), taskID); err == nil {
+		branchName = r.branchNameFor(task)
+	}
 	worktreePaths := make(map[string]string)
 
+	if r.noWorktree {
+		// In-place mode: the task works on the workspaces themselves.
+		for _, ws := range r.Workspaces() {
+			worktreePaths[ws] = ws
+		}
+		return worktreePaths, "", nil
+	}
+
 	for _, ws := range r.Workspaces() {
 		basename := filepath.Base(ws)
-		worktreePath := filepath.Join(r.worktreesDir, taskID.String(), basename)
+		worktreePath := filepath.Join(r.worktreeRoot(ws), taskID.String(), basename)
 
-		// Idempotent: reuse existing worktree/snapshot (e.g. task resumed from waiting).
-		if _, err := os.Stat(worktreePath); err == nil {
-			worktreePaths[ws] = worktreePath
+		// Idempotent: reuse existing worktree/snapshot (e.g. task resumed from
+		// waiting), even if it was created under the other root setting.
+		if existing := r.existingWorktree(taskID, ws); existing != "" {
+			worktreePaths[ws] = existing
 			continue
 		}
 
@@ -38,10 +83,28 @@ func (r *Runner) setupWorktrees(taskID uuid.UUID) (map[string]string, string, er
 		}
 
 		if gitutil.IsGitRepo(ws) {
+			if err := r.ensureInitialCommit(taskID, ws); err != nil {
+				r.cleanupWorktrees(taskID, worktreePaths, branchName)
+				return nil, "", err
+			}
 			if err := gitutil.CreateWorktree(ws, worktreePath, branchName); err != nil {
 				r.cleanupWorktrees(taskID, worktreePaths, branchName)
 				return nil, "", fmt.Errorf("createWorktree for %s: %w", ws, err)
 			}
+			if !r.skipSubmodules {
+				if err := gitutil.InitSubmodules(worktreePath); err != nil {
+					worktreePaths[ws] = worktreePath
+					r.cleanupWorktrees(taskID, worktreePaths, branchName)
+					return nil, "", fmt.Errorf("init submodules for %s: %w", ws, err)
+				}
+			}
+			if r.pullLFS(worktreePath) {
+				if err := gitutil.PullLFS(worktreePath); err != nil {
+					worktreePaths[ws] = worktreePath
+					r.cleanupWorktrees(taskID, worktreePaths, branchName)
+					return nil, "", fmt.Errorf("pull LFS objects for %s: %w", ws, err)
+				}
+			}
 		} else {
 			if err := setupNonGitSnapshot(ws, worktreePath); err != nil {
 				r.cleanupWorktrees(taskID, worktreePaths, branchName)
@@ -55,6 +118,92 @@ func (r *Runner) setupWorktrees(taskID uuid.UUID) (map[string]string, string, er
 	return worktreePaths, branchName, nil
 }
 
+// ensureInitialCommit gives a repository without commits, such as a fresh
+// "git init", an empty initial commit on its current branch, since a
+// worktree needs a commit to branch from. A system event records it.
+func (r *Runner) ensureInitialCommit(taskID uuid.UUID, ws string) error {
+	if gitutil.HasCommits(ws) {
+		return nil
+	}
+	if err := gitutil.CreateInitialCommit(ws); err != nil {
+		return fmt.Errorf("%s has no commits to branch the task from, and creating an empty initial commit failed "+
+			"(commit something to it first): %w", ws, err)
+	}
+	branch, _ := gitutil.CurrentBranch(ws)
+	logger.Runner.Info("created initial commit in empty repository", "workspace", ws, "branch", branch)
+	r.store.InsertEvent(context.Background(), taskID, store.EventTypeSystem, map[string]string{
+		"result": fmt.Sprintf("%s had no commits; created an empty initial commit on %s to branch the task from.", ws, branch),
+	})
+	return nil
+}
+
+// pullLFS reports whether LFS objects should be pulled into the new
+// worktree at worktreePath. Unless LFSOn is set, repos that use LFS are skipped
+// with a warning when git-lfs is not installed, leaving pointer files.
+func (r *Runner) pullLFS(worktreePath string) bool {
+	if r.lfsMode == LFSOff || !gitutil.UsesLFS(worktreePath) {
+		return false
+	}
+	if r.lfsMode != LFSOn && !gitutil.LFSAvailable() {
+		logger.Runner.Warn("repo uses Git LFS but git-lfs is not installed; worktree keeps pointer files", "worktree", worktreePath)
+		return false
+	}
+	return true
+}
+
+// existingWorktree returns the path of a worktree already created for taskID
+// and ws in any worktree root, preferring the currently configured one, or
+// "" if there is none.
+func (r *Runner) existingWorktree(taskID uuid.UUID, ws string) string {
+	basename := filepath.Base(ws)
+	candidates := []string{
+		filepath.Join(r.worktreeRoot(ws), taskID.String(), basename),
+		filepath.Join(r.worktreesDir, taskID.String(), basename),
+		filepath.Join(filepath.Dir(ws), adjacentWorktreesDirName, taskID.String(), basename),
+	}
+	for _, p := range candidates {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// targetBranches returns the branch each git workspace's work will be merged
+// into, resolved the same way rebaseAndMerge resolves it. Non-git workspaces
+// are omitted since their changes are copied back rather than merged. In
+// in-place mode the target is whatever branch the workspace has checked out.
+func (r *Runner) targetBranches(worktreePaths map[string]string) map[string]string {
+	targets := make(map[string]string)
+	for repoPath := range worktreePaths {
+		if !gitutil.IsGitRepo(repoPath) {
+			continue
+		}
+		resolve := gitutil.DefaultBranch
+		if r.noWorktree {
+			resolve = gitutil.CurrentBranch
+		}
+		if branch, err := resolve(repoPath); err == nil {
+			targets[repoPath] = branch
+		}
+	}
+	return targets
+}
+
+// headHashes returns the HEAD commit of each git workspace in worktreePaths.
+func headHashes(worktreePaths map[string]string) map[string]string {
+	hashes := make(map[string]string)
+	for repoPath := range worktreePaths {
+		if !gitutil.IsGitRepo(repoPath) {
+			continue
+		}
+		if hash, err := gitutil.GetCommitHash(repoPath); err == nil {
+			hashes[repoPath] = hash
+		}
+	}
+	return hashes
+}
+
 // CleanupWorktrees is the exported variant of cleanupWorktrees for handler use.
 func (r *Runner) CleanupWorktrees(taskID uuid.UUID, worktreePaths map[string]string, branchName string) {
 	r.cleanupWorktrees(taskID, worktreePaths, branchName)
@@ -64,6 +213,10 @@ func (r *Runner) CleanupWorktrees(taskID uuid.UUID, worktreePaths map[string]str
 // directory. Safe to call multiple times — errors are logged as warnings.
 func (r *Runner) cleanupWorktrees(taskID uuid.UUID, worktreePaths map[string]string, branchName string) {
 	for repoPath, wt := range worktreePaths {
+		if wt == repoPath {
+			// In-place task: the "worktree" is the workspace itself.
+			continue
+		}
 		if !gitutil.IsGitRepo(repoPath) {
 			// Non-git snapshots are cleaned by os.RemoveAll below.
 			continue
@@ -72,24 +225,21 @@ func (r *Runner) cleanupWorktrees(taskID uuid.UUID, worktreePaths map[string]str
 			logger.Runner.Warn("remove worktree", "task", taskID, "repo", repoPath, "error", err)
 		}
 	}
-	taskWorktreeDir := filepath.Join(r.worktreesDir, taskID.String())
-	if err := os.RemoveAll(taskWorktreeDir); err != nil {
-		logger.Runner.Warn("remove worktree dir", "task", taskID, "error", err)
+	for _, root := range r.worktreeRoots() {
+		taskWorktreeDir := filepath.Join(root, taskID.String())
+		if err := os.RemoveAll(taskWorktreeDir); err != nil {
+			logger.Runner.Warn("remove worktree dir", "task", taskID, "error", err)
+		}
+		if root != r.worktreesDir {
+			os.Remove(root) // drop the adjacent dir once empty; fails harmlessly otherwise
+		}
 	}
 }
 
-// pruneOrphanedWorktrees scans worktreesDir for directories whose UUID does not
-// match any known task, removes them, and runs `git worktree prune` on all
-// git workspaces to clean up stale internal references.
+// pruneOrphanedWorktrees scans every worktree root for directories whose UUID
+// does not match any known task, removes them, and runs `git worktree prune`
+// on all git workspaces to clean up stale internal references.
 func (r *Runner) PruneOrphanedWorktrees(s *store.Store) {
-	entries, err := os.ReadDir(r.worktreesDir)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			logger.Runner.Warn("read worktrees dir", "error", err)
-		}
-		return
-	}
-
 	ctx := context.Background()
 	tasks, _ := s.ListTasks(ctx, true)
 	knownIDs := make(map[string]bool, len(tasks))
@@ -97,16 +247,32 @@ func (r *Runner) PruneOrphanedWorktrees(s *store.Store) {
 		knownIDs[t.ID.String()] = true
 	}
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
+	for _, root := range r.worktreeRoots() {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logger.Runner.Warn("read worktrees dir", "dir", root, "error", err)
+			}
 			continue
 		}
-		if knownIDs[entry.Name()] {
-			continue
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			if knownIDs[entry.Name()] {
+				continue
+			}
+			if _, err := uuid.Parse(entry.Name()); err != nil && root != r.worktreesDir {
+				// Adjacent roots live in user directories; only touch task dirs.
+				continue
+			}
+			orphanDir := filepath.Join(root, entry.Name())
+			logger.Runner.Warn("pruning orphaned worktree dir", "dir", orphanDir)
+			os.RemoveAll(orphanDir)
+		}
+		if root != r.worktreesDir {
+			os.Remove(root)
 		}
-		orphanDir := filepath.Join(r.worktreesDir, entry.Name())
-		logger.Runner.Warn("pruning orphaned worktree dir", "dir", orphanDir)
-		os.RemoveAll(orphanDir)
 	}
 
 	// Run `git worktree prune` on all workspaces to clean stale references.
@@ -121,3 +287,49 @@ func gitPrune(repoPath string) {
 	// best-effort; errors are silently ignored
 	_ = runGit(repoPath, "worktree", "prune")
 }
+
+// gitDirMounts returns the git directories the sandbox must mount, besides
+// the workspaces themselves, for git to work inside it: those of
+// repositories created with --separate-git-dir and of bare repositories,
+// which live outside any work tree. The .git directory of an ordinary
+// checkout is never returned; it holds the hooks and config that host-side
+// commits run with. Directories already inside a mounted workspace are left
+// out.
+func gitDirMounts(workspaces []string) []string {
+	covered := func(dir string) bool {
+		for _, ws := range workspaces {
+			if rel, err := filepath.Rel(canonicalDir(ws), dir); err == nil && !strings.HasPrefix(rel, "..") {
+				return true
+			}
+		}
+		return false
+	}
+	var mounts []string
+	for _, ws := range workspaces {
+		if !gitutil.IsGitRepo(ws) {
+			continue
+		}
+		dir, err := gitutil.GitCommonDir(ws)
+		if err != nil {
+			logger.Runner.Warn("resolve git directory", "workspace", ws, "error", err)
+			continue
+		}
+		if filepath.Base(dir) == ".git" {
+			continue // the main checkout's own .git
+		}
+		if !covered(dir) && !slices.Contains(mounts, dir) {
+			mounts = append(mounts, dir)
+		}
+	}
+	sort.Strings(mounts)
+	return mounts
+}
+
+// canonicalDir resolves symlinks in dir so it compares equal to the paths
+// git reports.
+func canonicalDir(dir string) string {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		return resolved
+	}
+	return filepath.Clean(dir)
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"changkun.de/wallfacer/internal/logger"
+	"github.com/google/uuid"
+)
+
+// backend persists tasks, events, and settings. The Store keeps everything
+// in memory and writes each mutation through to its backend while holding
+// s.mu, so implementations need no locking of their own.
+type backend interface {
+	// load returns every persisted task with its events in ID order.
+	load() (map[uuid.UUID]*Task, map[uuid.UUID][]TaskEvent, error)
+	createTask(t *Task) error
+	saveTask(t *Task) error
+	deleteTask(id uuid.UUID) error
+	saveEvent(e TaskEvent) error
+	// loadSettings fills s from storage, leaving it untouched when no
+	// settings were saved yet.
+	loadSettings(s *Settings) error
+	saveSettings(s Settings) error
+	close() error
+}
+
+// jsonBackend is the default backend: one directory per task holding
+// task.json and a traces/ directory with one file per event, plus
+// settings.json at the top level. All writes are atomic (temp-file + rename).
+type jsonBackend struct {
+	dir string
+}
+
+func (b *jsonBackend) taskDir(id uuid.UUID) string {
+	return filepath.Join(b.dir, id.String())
+}
+
+// load scans the data directory, skipping entries that are not task
+// directories or whose task.json cannot be read.
+func (b *jsonBackend) load() (map[uuid.UUID]*Task, map[uuid.UUID][]TaskEvent, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tasks := make(map[uuid.UUID]*Task)
+	events := make(map[uuid.UUID][]TaskEvent)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		id, err := uuid.Parse(entry.Name())
+		if err != nil {
+			continue // skip non-UUID directories
+		}
+
+		raw, err := os.ReadFile(filepath.Join(b.taskDir(id), "task.json"))
+		if err != nil {
+			logger.Store.Warn("skipping task", "name", entry.Name(), "error", err)
+			continue
+		}
+		var task Task
+		if err := jsonUnmarshal(raw, &task); err != nil {
+			logger.Store.Warn("skipping task", "name", entry.Name(), "error", err)
+			continue
+		}
+		tasks[id] = &task
+
+		evts, err := b.loadEvents(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		events[id] = evts
+	}
+	return tasks, events, nil
+}
+
+// loadEvents reads the trace files of a single task, sorted by event ID.
+func (b *jsonBackend) loadEvents(id uuid.UUID) ([]TaskEvent, error) {
+	tracesDir := filepath.Join(b.taskDir(id), "traces")
+	traceEntries, err := os.ReadDir(tracesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var events []TaskEvent
+	for _, te := range traceEntries {
+		if te.IsDir() || !strings.HasSuffix(te.Name(), ".json") {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(tracesDir, te.Name()))
+		if err != nil {
+			logger.Store.Warn("skipping trace", "task", id, "trace", te.Name(), "error", err)
+			continue
+		}
+		var evt TaskEvent
+		if err := jsonUnmarshal(raw, &evt); err != nil {
+			logger.Store.Warn("skipping trace", "task", id, "trace", te.Name(), "error", err)
+			continue
+		}
+		events = append(events, evt)
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+	return events, nil
+}
+
+func (b *jsonBackend) createTask(t *Task) error {
+	if err := os.MkdirAll(filepath.Join(b.taskDir(t.ID), "traces"), 0700); err != nil {
+		return err
+	}
+	return b.saveTask(t)
+}
+
+func (b *jsonBackend) saveTask(t *Task) error {
+	return atomicWriteJSON(filepath.Join(b.taskDir(t.ID), "task.json"), t)
+}
+
+func (b *jsonBackend) deleteTask(id uuid.UUID) error {
+	return os.RemoveAll(b.taskDir(id))
+}
+
+func (b *jsonBackend) saveEvent(e TaskEvent) error {
+	tracesDir := filepath.Join(b.taskDir(e.TaskID), "traces")
+	if err := os.MkdirAll(tracesDir, 0700); err != nil {
+		return err
+	}
+	return atomicWriteJSON(filepath.Join(tracesDir, fmt.Sprintf("%04d.json", e.ID)), e)
+}
+
+func (b *jsonBackend) settingsPath() string {
+	return filepath.Join(b.dir, "settings.json")
+}
+
+func (b *jsonBackend) loadSettings(s *Settings) error {
+	raw, err := os.ReadFile(b.settingsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return jsonUnmarshal(raw, s)
+}
+
+func (b *jsonBackend) saveSettings(s Settings) error {
+	return atomicWriteJSON(b.settingsPath(), s)
+}
+
+func (b *jsonBackend) close() error { return nil }
@@ -1,11 +1,11 @@
 package store
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -34,15 +34,50 @@ func (s *Store) InsertEvent(_ context.Context, taskID uuid.UUID, eventType Event
 		CreatedAt: time.Now(),
 	}
 
-	if err := s.saveEvent(taskID, seq, event); err != nil {
+	if err := s.backend.saveEvent(event); err != nil {
 		return err
 	}
 
 	s.events[taskID] = append(s.events[taskID], event)
 	s.nextSeq[taskID] = seq + 1
+	s.notifyEvents(taskID)
 	return nil
 }
 
+// EventFilter selects a subset of a task's events. The zero value matches
+// every event.
+type EventFilter struct {
+	Types   []EventType // keep only these types; empty keeps all
+	SinceID int64       // keep only events with a greater ID
+}
+
+// Match reports whether ev passes the filter.
+func (f EventFilter) Match(ev TaskEvent) bool {
+	if ev.ID <= f.SinceID {
+		return false
+	}
+	return len(f.Types) == 0 || slices.Contains(f.Types, ev.EventType)
+}
+
+// GetEventsFiltered returns the events of a task that match f, in order.
+func (s *Store) GetEventsFiltered(_ context.Context, taskID uuid.UUID, f EventFilter) ([]TaskEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := s.events[taskID]
+	// IDs ascend, so skip the events at or before SinceID in one step.
+	start, _ := slices.BinarySearchFunc(events, f.SinceID+1, func(ev TaskEvent, id int64) int {
+		return cmp.Compare(ev.ID, id)
+	})
+	out := make([]TaskEvent, 0, len(events)-start)
+	for _, ev := range events[start:] {
+		if f.Match(ev) {
+			out = append(out, ev)
+		}
+	}
+	return out, nil
+}
+
 // GetEvents returns a copy of all events for a task in order.
 func (s *Store) GetEvents(_ context.Context, taskID uuid.UUID) ([]TaskEvent, error) {
 	s.mu.RLock()
@@ -53,14 +88,3 @@ func (s *Store) GetEvents(_ context.Context, taskID uuid.UUID) ([]TaskEvent, err
 	copy(out, events)
 	return out, nil
 }
-
-// saveEvent writes a single event to the task's traces directory.
-// Must be called with s.mu held for writing.
-func (s *Store) saveEvent(taskID uuid.UUID, seq int, event TaskEvent) error {
-	tracesDir := filepath.Join(s.dir, taskID.String(), "traces")
-	if err := os.MkdirAll(tracesDir, 0700); err != nil {
-		return err
-	}
-	path := filepath.Join(tracesDir, fmt.Sprintf("%04d.json", seq))
-	return atomicWriteJSON(path, event)
-}
@@ -1,19 +1,24 @@
 package store
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/google/uuid"
 )
 
-// saveTask atomically writes a task's metadata to its task.json file.
+// saveTask writes a task's metadata through to the backend.
 // Must be called with s.mu held for writing.
-func (s *Store) saveTask(id uuid.UUID, task *Task) error {
-	path := filepath.Join(s.dir, id.String(), "task.json")
-	return atomicWriteJSON(path, task)
+func (s *Store) saveTask(_ uuid.UUID, task *Task) error {
+	return s.backend.saveTask(task)
 }
 
 // SaveTurnOutput persists raw stdout/stderr for a given turn to the outputs directory.
@@ -38,6 +43,128 @@ func (s *Store) SaveTurnOutput(taskID uuid.UUID, turn int, stdout, stderr []byte
 	return nil
 }
 
+// compressedSuffix is appended to output files compacted by CompactTask.
+const compressedSuffix = ".gz"
+
+// CompactTask gzip-compresses the saved turn outputs (turn-*.json and
+// turn-*.stderr.txt) of a done, failed or cancelled task in place, replacing
+// each file with its .gz counterpart. It returns the number of files
+// compacted; outputs already compacted are skipped. ReadOutput and
+// ListOutputs hide the compression from readers.
+func (s *Store) CompactTask(_ context.Context, id uuid.UUID) (int, error) {
+	s.mu.RLock()
+	t, ok := s.tasks[id]
+	var status string
+	if ok {
+		status = t.Status
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("task not found: %s", id)
+	}
+	if status != "done" && status != "failed" && status != "cancelled" {
+		return 0, fmt.Errorf("task %s is %s; only done, failed or cancelled tasks can be compacted", id, status)
+	}
+
+	dir := s.OutputsDir(id)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("read outputs dir: %w", err)
+	}
+	n := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "turn-") ||
+			(!strings.HasSuffix(name, ".json") && !strings.HasSuffix(name, ".stderr.txt")) {
+			continue
+		}
+		if err := gzipFile(filepath.Join(dir, name)); err != nil {
+			return n, fmt.Errorf("compact %s: %w", name, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// gzipFile replaces path with a gzip-compressed path.gz. The compressed
+// file is written under a temporary name and renamed into place before the
+// original is removed, so a crash leaves at worst both copies.
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + compressedSuffix + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+compressedSuffix)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// ListOutputs returns the names of the files saved in a task's outputs
+// directory, sorted, with compacted files listed under their original
+// names. A task without outputs has none.
+func (s *Store) ListOutputs(taskID uuid.UUID) ([]string, error) {
+	entries, err := os.ReadDir(s.OutputsDir(taskID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(name, compressedSuffix))
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// ReadOutput returns the content of a file in a task's outputs directory,
+// decompressing it if CompactTask has replaced it with a .gz file. The
+// error satisfies errors.Is(err, os.ErrNotExist) when neither exists.
+func (s *Store) ReadOutput(taskID uuid.UUID, name string) ([]byte, error) {
+	path := filepath.Join(s.OutputsDir(taskID), name)
+	data, err := os.ReadFile(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return data, err
+	}
+	f, err := os.Open(path + compressedSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
 // atomicWriteJSON marshals v to JSON and writes it atomically via temp+rename.
 func atomicWriteJSON(path string, v any) error {
 	raw, err := json.MarshalIndent(v, "", "  ")
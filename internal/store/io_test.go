@@ -1,9 +1,10 @@
-// Tests for io.go: SaveTurnOutput and atomic persistence helpers.
+// Tests for io.go: SaveTurnOutput, output compaction and atomic persistence helpers.
 package store
 
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 )
 
@@ -66,3 +67,61 @@ func TestSaveTurnOutput_TurnNumberFormatted(t *testing.T) {
 		t.Errorf("expected file turn-0042.json: %v", err)
 	}
 }
+
+func TestCompactTask(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	if err := s.SaveTurnOutput(task.ID, 1, []byte(`{"result":"ok"}`), []byte("warning")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.OutputsDir(task.ID), "diagnose-1.txt"), []byte("diag"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.CompactTask(bg(), task.ID); err == nil {
+		t.Fatal("expected an error compacting a backlog task")
+	}
+
+	s.UpdateTaskStatus(bg(), task.ID, "done")
+	n, err := s.CompactTask(bg(), task.ID)
+	if err != nil {
+		t.Fatalf("CompactTask: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("compacted %d files, want 2", n)
+	}
+	outputs := s.OutputsDir(task.ID)
+	for _, name := range []string{"turn-0001.json", "turn-0001.stderr.txt"} {
+		if _, err := os.Stat(filepath.Join(outputs, name)); !os.IsNotExist(err) {
+			t.Errorf("%s still exists uncompressed", name)
+		}
+		if _, err := os.Stat(filepath.Join(outputs, name+".gz")); err != nil {
+			t.Errorf("%s.gz missing: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outputs, "diagnose-1.txt")); err != nil {
+		t.Errorf("diagnostics should be left alone: %v", err)
+	}
+
+	if data, err := s.ReadOutput(task.ID, "turn-0001.json"); err != nil || string(data) != `{"result":"ok"}` {
+		t.Errorf("ReadOutput = %q, %v", data, err)
+	}
+	if data, err := s.ReadOutput(task.ID, "diagnose-1.txt"); err != nil || string(data) != "diag" {
+		t.Errorf("ReadOutput of an uncompressed file = %q, %v", data, err)
+	}
+	if _, err := s.ReadOutput(task.ID, "turn-0002.json"); !os.IsNotExist(err) {
+		t.Errorf("ReadOutput of a missing file: %v, want not-exist", err)
+	}
+	names, err := s.ListOutputs(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"diagnose-1.txt", "turn-0001.json", "turn-0001.stderr.txt"}; !slices.Equal(names, want) {
+		t.Errorf("ListOutputs = %v, want %v", names, want)
+	}
+
+	if n, err := s.CompactTask(bg(), task.ID); err != nil || n != 0 {
+		t.Errorf("second CompactTask = %d, %v; want 0, nil", n, err)
+	}
+}
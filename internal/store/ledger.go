@@ -0,0 +1,141 @@
+package store
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+// spendLedgerDays is how many days of spend Settings.DailySpend keeps.
+const spendLedgerDays = 31
+
+// recentSpendRetention is how long Settings.RecentSpend keeps the cost of
+// each turn, and so the longest window SpendSince can total.
+const recentSpendRetention = 24 * time.Hour
+
+// SpendEntry is the cost of a single turn and when it was recorded.
+type SpendEntry struct {
+	At      time.Time `json:"at"`
+	CostUSD float64   `json:"cost_usd"`
+}
+
+// ledgerDay is the Settings.DailySpend key of t's local day.
+func ledgerDay(t time.Time) string {
+	return t.Local().Format(time.DateOnly)
+}
+
+// DailySpend returns the USD spent on the local day of t. It is read from
+// the spend ledger rather than the task usage logs, so deleting a task does
+// not lower it.
+func (s *Store) DailySpend(t time.Time) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings.DailySpend[ledgerDay(t)]
+}
+
+// SpendSince returns the USD spent at or after since, which should be no
+// longer ago than recentSpendRetention. Like DailySpend it is read from the
+// spend ledger, so deleting a task does not lower it.
+func (s *Store) SpendSince(since time.Time) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var sum float64
+	for _, e := range s.settings.RecentSpend {
+		if !e.At.Before(since) {
+			sum += e.CostUSD
+		}
+	}
+	return sum
+}
+
+// UsageTotals returns the usage of every turn the store has recorded.
+// Unlike SummarizeUsage it counts deleted tasks too, so it only grows.
+func (s *Store) UsageTotals() TaskUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings.UsageTotals
+}
+
+// recordUsage adds the usage of a turn recorded at at to the usage totals
+// and its cost to the ledger entry of at's day and to the recent spend,
+// dropping what fell out of the ledger. s.mu must be held.
+func (s *Store) recordUsage(at time.Time, delta TaskUsage) error {
+	if delta == (TaskUsage{}) {
+		return nil
+	}
+	settings := s.settings
+	settings.UsageTotals.add(delta)
+	if delta.CostUSD != 0 {
+		settings.DailySpend = maps.Clone(s.settings.DailySpend)
+		if settings.DailySpend == nil {
+			settings.DailySpend = make(map[string]float64)
+		}
+		settings.DailySpend[ledgerDay(at)] += delta.CostUSD
+		cutoff := ledgerDay(at.AddDate(0, 0, -spendLedgerDays))
+		maps.DeleteFunc(settings.DailySpend, func(day string, _ float64) bool { return day < cutoff })
+
+		since := at.Add(-recentSpendRetention)
+		settings.RecentSpend = slices.DeleteFunc(slices.Clone(s.settings.RecentSpend), func(e SpendEntry) bool {
+			return e.At.Before(since)
+		})
+		settings.RecentSpend = append(settings.RecentSpend, SpendEntry{At: at, CostUSD: delta.CostUSD})
+	}
+	if err := s.backend.saveSettings(settings); err != nil {
+		return err
+	}
+	s.settings = settings
+	return nil
+}
+
+// seedUsageLedger fills an empty spend ledger, recent spend, and zero usage
+// totals from the tasks still present, for data directories written before
+// they existed. s.mu must be held.
+func (s *Store) seedUsageLedger() error {
+	settings := s.settings
+	changed := false
+	if settings.DailySpend == nil {
+		cutoff := ledgerDay(time.Now().AddDate(0, 0, -spendLedgerDays))
+		ledger := make(map[string]float64)
+		for _, t := range s.tasks {
+			for _, e := range t.UsageLog {
+				if day := ledgerDay(e.At); day >= cutoff && e.CostUSD != 0 {
+					ledger[day] += e.CostUSD
+				}
+			}
+		}
+		if len(ledger) > 0 {
+			settings.DailySpend = ledger
+			changed = true
+		}
+	}
+	if settings.RecentSpend == nil {
+		since := time.Now().Add(-recentSpendRetention)
+		var recent []SpendEntry
+		for _, t := range s.tasks {
+			for _, e := range t.UsageLog {
+				if !e.At.Before(since) && e.CostUSD != 0 {
+					recent = append(recent, SpendEntry{At: e.At, CostUSD: e.CostUSD})
+				}
+			}
+		}
+		if len(recent) > 0 {
+			slices.SortFunc(recent, func(a, b SpendEntry) int { return a.At.Compare(b.At) })
+			settings.RecentSpend = recent
+			changed = true
+		}
+	}
+	if settings.UsageTotals == (TaskUsage{}) {
+		for _, t := range s.tasks {
+			settings.UsageTotals.add(t.Usage)
+		}
+		changed = settings.UsageTotals != (TaskUsage{}) || changed
+	}
+	if !changed {
+		return nil
+	}
+	if err := s.backend.saveSettings(settings); err != nil {
+		return err
+	}
+	s.settings = settings
+	return nil
+}
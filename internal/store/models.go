@@ -16,6 +16,29 @@ type TaskUsage struct {
 	CostUSD              float64 `json:"cost_usd"`
 }
 
+// add accumulates o into u.
+func (u *TaskUsage) add(o TaskUsage) {
+	u.InputTokens += o.InputTokens
+	u.OutputTokens += o.OutputTokens
+	u.CacheReadInputTokens += o.CacheReadInputTokens
+	u.CacheCreationTokens += o.CacheCreationTokens
+	u.CostUSD += o.CostUSD
+}
+
+// UsageSummary is usage totalled over many tasks. A task that ran in several
+// workspaces counts toward each of them in ByWorkspace.
+type UsageSummary struct {
+	Total       TaskUsage            `json:"total"`
+	ByStatus    map[string]TaskUsage `json:"by_status"`
+	ByWorkspace map[string]TaskUsage `json:"by_workspace"`
+}
+
+// UsageEntry is the usage of a single turn and when it was recorded.
+type UsageEntry struct {
+	At time.Time `json:"at"`
+	TaskUsage
+}
+
 // Task is the core domain model: a unit of work executed by Claude Code.
 type Task struct {
 	ID            uuid.UUID `json:"id"`
@@ -37,23 +60,181 @@ type Task struct {
 
 	// Worktree isolation fields (populated when task moves to in_progress).
 	WorktreePaths    map[string]string `json:"worktree_paths,omitempty"`     // host repoPath → worktree path
-	BranchName       string            `json:"branch_name,omitempty"`        // "task/<uuid8>"
+	BranchName       string            `json:"branch_name,omitempty"`        // from -branch-template, "task/<uuid8>" by default
 	CommitHashes     map[string]string `json:"commit_hashes,omitempty"`      // host repoPath → commit hash after merge
 	BaseCommitHashes map[string]string `json:"base_commit_hashes,omitempty"` // host repoPath → defBranch HEAD before merge
+	ConflictRepo     string            `json:"conflict_repo,omitempty"`      // host repoPath whose rebase waits for manual resolution
+	ConflictWorktree string            `json:"conflict_worktree,omitempty"`  // worktree of ConflictRepo, stopped mid-rebase
+	TargetBranches   map[string]string `json:"target_branches,omitempty"`    // host repoPath → branch the task merges into
 	MountWorktrees   bool              `json:"mount_worktrees,omitempty"`
+
+	// CommitTitle embeds the task title in the generated commit message:
+	// "prefix", "suffix", or empty to leave the message untouched.
+	CommitTitle string `json:"commit_title,omitempty"`
+
+	// Labels are free-form tags, e.g. a shared label grouping a batch.
+	Labels []string `json:"labels,omitempty"`
+
+	// Model overrides the env-file model for this task's turns when set.
+	Model string `json:"model,omitempty"`
+
+	// Reviewed marks a done task whose changes the user has checked.
+	Reviewed   bool       `json:"reviewed,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+
+	// AutoExtend lets a productive turn near the deadline extend the
+	// task's timeout, up to the server's hard maximum.
+	AutoExtend bool `json:"auto_extend,omitempty"`
+
+	// SandboxImage overrides the server's sandbox image for this task.
+	// Empty uses the global image.
+	SandboxImage string `json:"sandbox_image,omitempty"`
+
+	// MemoryLimit and CPULimit override the server's sandbox resource
+	// limits for this task, in docker's --memory and --cpus formats (e.g.
+	// "4g", "1.5"). Empty uses the global limit.
+	MemoryLimit string `json:"memory_limit,omitempty"`
+	CPULimit    string `json:"cpu_limit,omitempty"`
+
+	// Env holds extra environment variables passed to the task's Claude
+	// Code process on top of the env file, e.g. per-task secrets. Values
+	// are redacted from logs and never copied into events.
+	Env map[string]string `json:"env,omitempty"`
+
+	// Priority orders the backlog ahead of Position: one of the Priority*
+	// constants. Empty means PriorityNormal.
+	Priority string `json:"priority,omitempty"`
+
+	// DependsOn lists tasks that must be done before this one may start.
+	// Dependencies that have since been deleted no longer block it.
+	DependsOn []uuid.UUID `json:"depends_on,omitempty"`
+
+	// MaxCostUSD fails the task once its accumulated cost reaches it
+	// instead of starting another turn. Zero means unlimited.
+	MaxCostUSD float64 `json:"max_cost_usd,omitempty"`
+
+	// Deadline fails the task if it has not finished by then, whatever
+	// status it is waiting in. Nil means no deadline.
+	Deadline *time.Time `json:"deadline,omitempty"`
+
+	// UsageLog records the usage of each of the last maxUsageLogEntries
+	// turns with its time, so usage can be totalled over a period across
+	// tasks. Usage holds the sum of all turns. Spend limits read the
+	// store's spend ledger instead.
+	UsageLog []UsageEntry `json:"usage_log,omitempty"`
+
+	// MergeStrategy decides how the commit pipeline lands the task: one of
+	// the MergeStrategy* constants. Empty uses the server's default.
+	MergeStrategy string `json:"merge_strategy,omitempty"`
+	// PullRequestURLs maps host repoPath → URL of the pull request opened
+	// for the task under MergeStrategyPullRequest.
+	PullRequestURLs map[string]string `json:"pull_request_urls,omitempty"`
+
+	// TurnTimeout bounds each turn in minutes, overriding the server's
+	// per-turn timeout. Zero uses the server setting.
+	TurnTimeout int `json:"turn_timeout,omitempty"`
+
+	// StartedAt is when the task first moved to in_progress, and EndedAt
+	// when it last reached done, failed, or cancelled; moving it back to
+	// backlog or in_progress, including by a retry or resume, clears EndedAt. ActiveSeconds totals the wall-clock time
+	// of its turns, across retries like Usage.
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	EndedAt       *time.Time `json:"ended_at,omitempty"`
+	ActiveSeconds float64    `json:"active_seconds,omitempty"`
+}
+
+// Accepted values for Task.CommitTitle.
+const (
+	CommitTitlePrefix = "prefix"
+	CommitTitleSuffix = "suffix"
+)
+
+// Accepted values for Task.MergeStrategy and the server default.
+const (
+	MergeStrategyFF          = "ff"           // fast-forward the target branch
+	MergeStrategyMerge       = "merge"        // explicit merge commit per task
+	MergeStrategySquash      = "squash"       // squash into one commit, then fast-forward
+	MergeStrategyPullRequest = "pull-request" // push the task branch and open a PR
+)
+
+// ValidMergeStrategy reports whether v is one of the MergeStrategy*
+// constants.
+func ValidMergeStrategy(v string) bool {
+	switch v {
+	case MergeStrategyFF, MergeStrategyMerge, MergeStrategySquash, MergeStrategyPullRequest:
+		return true
+	}
+	return false
+}
+
+// Accepted values for Task.Priority, lowest first.
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+	PriorityUrgent = "urgent"
+)
+
+// PriorityRank orders priorities: higher ranks run first. Unknown values,
+// including the empty string, rank as PriorityNormal.
+func PriorityRank(p string) int {
+	switch p {
+	case PriorityLow:
+		return 0
+	case PriorityHigh:
+		return 2
+	case PriorityUrgent:
+		return 3
+	}
+	return 1
+}
+
+// ValidPriority reports whether p is an accepted Task.Priority value.
+func ValidPriority(p string) bool {
+	switch p {
+	case PriorityLow, PriorityNormal, PriorityHigh, PriorityUrgent:
+		return true
+	}
+	return false
 }
 
 // EventType identifies the kind of event stored in a task's audit trail.
 type EventType string
 
 const (
-	EventTypeStateChange EventType = "state_change"
-	EventTypeOutput      EventType = "output"
-	EventTypeFeedback    EventType = "feedback"
-	EventTypeError       EventType = "error"
-	EventTypeSystem      EventType = "system"
+	EventTypeStateChange    EventType = "state_change"
+	EventTypeOutput         EventType = "output"
+	EventTypeFeedback       EventType = "feedback"
+	EventTypeError          EventType = "error"
+	EventTypeSystem         EventType = "system"
+	EventTypeCheckpoint     EventType = "checkpoint"
+	EventTypeConflict       EventType = "conflict"
+	EventTypeContainerStart EventType = "container_start"
 )
 
+// ContainerStart is the data of an EventTypeContainerStart event: the
+// command a turn's Claude Code process was started with, so a failing turn
+// can be reproduced by hand. Values passed with -e are redacted and the env
+// file is referenced by path. The prompt is saved once per distinct text to
+// PromptFile in the task's outputs, and Command and Args read it from there
+// instead of repeating it in every event.
+type ContainerStart struct {
+	Command    string   `json:"command"`               // shell-quoted, ready to paste
+	Args       []string `json:"args"`                  // the same command as argv
+	PromptFile string   `json:"prompt_file,omitempty"` // outputs file holding the prompt
+	Sandbox    string   `json:"sandbox,omitempty"`     // command that created the sandbox, if known
+}
+
+// ValidEventType reports whether t is one of the event types above.
+func ValidEventType(t EventType) bool {
+	switch t {
+	case EventTypeStateChange, EventTypeOutput, EventTypeFeedback, EventTypeError,
+		EventTypeSystem, EventTypeCheckpoint, EventTypeConflict, EventTypeContainerStart:
+		return true
+	}
+	return false
+}
+
 // TaskEvent is a single event in a task's audit trail (event sourcing).
 type TaskEvent struct {
 	ID        int64           `json:"id"`
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// Settings holds server-wide state that is changed at runtime and must
+// survive restarts. It is persisted by the store backend (settings.json in the data
+// directory for the JSON store).
+type Settings struct {
+	Autopilot bool `json:"autopilot"`
+	// RunQueue is the order in which tasks waiting for a concurrency slot
+	// start, first to run first.
+	RunQueue []uuid.UUID `json:"run_queue,omitempty"`
+	// Templates are the saved task templates, sorted by name.
+	Templates []Template `json:"templates,omitempty"`
+	// DailySpend is the USD spent on each local day ("2006-01-02") of the
+	// last spendLedgerDays, kept apart from the tasks so that deleting or
+	// pruning them does not lower it.
+	DailySpend map[string]float64 `json:"daily_spend,omitempty"`
+	// RecentSpend is the cost of each turn of the last
+	// recentSpendRetention, oldest first, for spend caps over windows
+	// shorter than a day. Like DailySpend it survives task deletion.
+	RecentSpend []SpendEntry `json:"recent_spend,omitempty"`
+	// UsageTotals is the usage of every turn ever recorded, deleted tasks
+	// included, for counters that must never go down.
+	UsageTotals TaskUsage `json:"usage_totals"`
+}
+
+// Settings returns a copy of the persisted server settings.
+func (s *Store) Settings() Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	settings := s.settings
+	settings.RunQueue = slices.Clone(s.settings.RunQueue)
+	settings.Templates = slices.Clone(s.settings.Templates)
+	settings.DailySpend = maps.Clone(s.settings.DailySpend)
+	settings.RecentSpend = slices.Clone(s.settings.RecentSpend)
+	return settings
+}
+
+// SetAutopilot records whether autopilot is enabled.
+func (s *Store) SetAutopilot(_ context.Context, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.settings.Autopilot = enabled
+	if err := s.backend.saveSettings(s.settings); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// RunQueue returns the saved run order of tasks waiting for a slot.
+func (s *Store) RunQueue() []uuid.UUID {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.settings.RunQueue)
+}
+
+// EnqueueRun appends id to the run queue unless it is already queued, in
+// which case it keeps its place.
+func (s *Store) EnqueueRun(_ context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slices.Contains(s.settings.RunQueue, id) {
+		return nil
+	}
+	return s.saveRunQueue(append(slices.Clone(s.settings.RunQueue), id))
+}
+
+// DequeueRun removes id from the run queue, if present.
+func (s *Store) DequeueRun(_ context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.Index(s.settings.RunQueue, id)
+	if i < 0 {
+		return nil
+	}
+	return s.saveRunQueue(slices.Delete(slices.Clone(s.settings.RunQueue), i, i+1))
+}
+
+// SetRunQueue replaces the run queue with ids.
+func (s *Store) SetRunQueue(_ context.Context, ids []uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.saveRunQueue(slices.Clone(ids))
+}
+
+// saveRunQueue persists queue as the run queue. s.mu must be held.
+func (s *Store) saveRunQueue(queue []uuid.UUID) error {
+	settings := s.settings
+	settings.RunQueue = queue
+	if err := s.backend.saveSettings(settings); err != nil {
+		return err
+	}
+	s.settings = settings
+	s.notify()
+	return nil
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// SQLiteFile is the name of the database file the SQLite backend keeps in
+// the data directory.
+const SQLiteFile = "wallfacer.db"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS tasks (
+	id         TEXT PRIMARY KEY,
+	status     TEXT NOT NULL,
+	archived   INTEGER NOT NULL DEFAULT 0,
+	updated_at TEXT NOT NULL,
+	data       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS tasks_status ON tasks(status);
+CREATE TABLE IF NOT EXISTS events (
+	task_id TEXT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+	id      INTEGER NOT NULL,
+	type    TEXT NOT NULL,
+	data    TEXT NOT NULL,
+	PRIMARY KEY (task_id, id)
+);
+CREATE TABLE IF NOT EXISTS settings (
+	id   INTEGER PRIMARY KEY CHECK (id = 1),
+	data TEXT NOT NULL
+);
+`
+
+// sqliteBackend keeps tasks, events, and settings in a single SQLite
+// database. Each row stores the full JSON document next to a few columns
+// (status, archived, event type) that can be queried directly.
+type sqliteBackend struct {
+	db *sql.DB
+}
+
+// openSQLite opens (or creates) the database at path and applies the schema.
+func openSQLite(path string) (*sqliteBackend, error) {
+	q := url.Values{}
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Add("_pragma", "busy_timeout(5000)")
+	q.Add("_pragma", "foreign_keys(1)")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	// One connection serializes writers the same way s.mu already does and
+	// keeps the per-connection pragmas in effect.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("apply schema: %w", err)
+	}
+	return &sqliteBackend{db: db}, nil
+}
+
+func (b *sqliteBackend) load() (map[uuid.UUID]*Task, map[uuid.UUID][]TaskEvent, error) {
+	tasks := make(map[uuid.UUID]*Task)
+	events := make(map[uuid.UUID][]TaskEvent)
+
+	rows, err := b.db.Query(`SELECT data FROM tasks`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, nil, err
+		}
+		var t Task
+		if err := jsonUnmarshal([]byte(raw), &t); err != nil {
+			return nil, nil, fmt.Errorf("decode task: %w", err)
+		}
+		tasks[t.ID] = &t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	evRows, err := b.db.Query(`SELECT data FROM events ORDER BY task_id, id`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer evRows.Close()
+	for evRows.Next() {
+		var raw string
+		if err := evRows.Scan(&raw); err != nil {
+			return nil, nil, err
+		}
+		var e TaskEvent
+		if err := jsonUnmarshal([]byte(raw), &e); err != nil {
+			return nil, nil, fmt.Errorf("decode event: %w", err)
+		}
+		events[e.TaskID] = append(events[e.TaskID], e)
+	}
+	return tasks, events, evRows.Err()
+}
+
+func (b *sqliteBackend) createTask(t *Task) error {
+	return b.saveTask(t)
+}
+
+func (b *sqliteBackend) saveTask(t *Task) error {
+	return upsertTask(b.db, t)
+}
+
+func (b *sqliteBackend) deleteTask(id uuid.UUID) error {
+	_, err := b.db.Exec(`DELETE FROM tasks WHERE id = ?`, id.String())
+	return err
+}
+
+func (b *sqliteBackend) saveEvent(e TaskEvent) error {
+	return insertEvent(b.db, e)
+}
+
+func (b *sqliteBackend) loadSettings(s *Settings) error {
+	var raw string
+	err := b.db.QueryRow(`SELECT data FROM settings WHERE id = 1`).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return jsonUnmarshal([]byte(raw), s)
+}
+
+func (b *sqliteBackend) saveSettings(s Settings) error {
+	return upsertSettings(b.db, s)
+}
+
+func (b *sqliteBackend) close() error {
+	return b.db.Close()
+}
+
+// execer is the subset of *sql.DB and *sql.Tx the write helpers need, so the
+// migration can run them inside one transaction.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func upsertTask(db execer, t *Task) error {
+	raw, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO tasks (id, status, archived, updated_at, data) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET status = excluded.status, archived = excluded.archived,
+			updated_at = excluded.updated_at, data = excluded.data`,
+		t.ID.String(), t.Status, t.Archived, t.UpdatedAt.UTC().Format(time.RFC3339Nano), string(raw))
+	return err
+}
+
+func insertEvent(db execer, e TaskEvent) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO events (task_id, id, type, data) VALUES (?, ?, ?, ?)`,
+		e.TaskID.String(), e.ID, string(e.EventType), string(raw))
+	return err
+}
+
+func upsertSettings(db execer, s Settings) error {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO settings (id, data) VALUES (1, ?)
+		ON CONFLICT(id) DO UPDATE SET data = excluded.data`, string(raw))
+	return err
+}
+
+// MigrateToSQLite imports the task directories, event traces, and settings
+// under dir into a new SQLite database at dir/wallfacer.db, in a single
+// transaction. It returns the number of imported tasks. The JSON files are
+// left in place; they are no longer updated once the server runs with the
+// SQLite backend. Migrating into a database that already holds tasks fails.
+func MigrateToSQLite(dir string) (int, error) {
+	src := &jsonBackend{dir: dir}
+	tasks, events, err := src.load()
+	if err != nil {
+		return 0, fmt.Errorf("load json store: %w", err)
+	}
+	var settings Settings
+	if err := src.loadSettings(&settings); err != nil {
+		return 0, fmt.Errorf("load settings: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return 0, fmt.Errorf("create data dir: %w", err)
+	}
+	dst, err := openSQLite(filepath.Join(dir, SQLiteFile))
+	if err != nil {
+		return 0, err
+	}
+	defer dst.close()
+
+	var n int
+	if err := dst.db.QueryRow(`SELECT COUNT(*) FROM tasks`).Scan(&n); err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		return 0, fmt.Errorf("%s already holds %d tasks", SQLiteFile, n)
+	}
+
+	tx, err := dst.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	for id, t := range tasks {
+		if err := upsertTask(tx, t); err != nil {
+			return 0, fmt.Errorf("import task %s: %w", id, err)
+		}
+		for _, e := range events[id] {
+			e.TaskID = id
+			if err := insertEvent(tx, e); err != nil {
+				return 0, fmt.Errorf("import event %d of task %s: %w", e.ID, id, err)
+			}
+		}
+	}
+	if err := upsertSettings(tx, settings); err != nil {
+		return 0, fmt.Errorf("import settings: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(tasks), nil
+}
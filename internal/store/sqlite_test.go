@@ -0,0 +1,99 @@
+// Tests for sqlite.go: the SQLite backend round trip and MigrateToSQLite.
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newSQLiteTestStore(t *testing.T, dir string) *Store {
+	t.Helper()
+	s, err := NewSQLiteStore(dir)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestSQLiteStore_SurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	s := newSQLiteTestStore(t, dir)
+
+	kept, _ := s.CreateTask(bg(), "kept", 5, false)
+	gone, _ := s.CreateTask(bg(), "gone", 5, false)
+	s.UpdateTaskStatus(bg(), kept.ID, "done")
+	s.AccumulateTaskUsage(bg(), kept.ID, TaskUsage{OutputTokens: 7, CostUSD: 0.5})
+	s.InsertEvent(bg(), kept.ID, EventTypeOutput, map[string]string{"n": "1"})
+	s.InsertEvent(bg(), kept.ID, EventTypeOutput, map[string]string{"n": "2"})
+	s.SetAutopilot(bg(), true)
+	if err := s.DeleteTask(bg(), gone.ID); err != nil {
+		t.Fatalf("DeleteTask: %v", err)
+	}
+	s.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, kept.ID.String(), "task.json")); !os.IsNotExist(err) {
+		t.Errorf("task.json written by the SQLite store, stat err = %v", err)
+	}
+
+	s2 := newSQLiteTestStore(t, dir)
+	tasks, _ := s2.ListTasks(bg(), true)
+	if len(tasks) != 1 || tasks[0].ID != kept.ID {
+		t.Fatalf("tasks after reopen = %+v, want only %s", tasks, kept.ID)
+	}
+	if tasks[0].Status != "done" || tasks[0].Usage.OutputTokens != 7 || len(tasks[0].UsageLog) != 1 {
+		t.Errorf("task after reopen = %+v", tasks[0])
+	}
+	events, _ := s2.GetEvents(bg(), kept.ID)
+	if len(events) != 2 || events[0].ID != 1 || events[1].ID != 2 {
+		t.Fatalf("events after reopen = %+v, want IDs 1 and 2", events)
+	}
+	if !s2.Settings().Autopilot {
+		t.Error("autopilot setting lost on reopen")
+	}
+
+	s2.InsertEvent(bg(), kept.ID, EventTypeOutput, map[string]string{"n": "3"})
+	events, _ = s2.GetEvents(bg(), kept.ID)
+	if got := events[len(events)-1].ID; got != 3 {
+		t.Errorf("next event ID = %d, want 3", got)
+	}
+}
+
+func TestMigrateToSQLite(t *testing.T) {
+	dir := t.TempDir()
+	js, _ := NewStore(dir)
+	a, _ := js.CreateTask(bg(), "a", 5, false)
+	b, _ := js.CreateTask(bg(), "b", 5, false)
+	js.SetTaskArchived(bg(), b.ID, true)
+	js.InsertEvent(bg(), a.ID, EventTypeStateChange, map[string]string{"to": "in_progress"})
+	js.SetAutopilot(bg(), true)
+
+	n, err := MigrateToSQLite(dir)
+	if err != nil {
+		t.Fatalf("MigrateToSQLite: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("imported %d tasks, want 2", n)
+	}
+	if _, err := MigrateToSQLite(dir); err == nil {
+		t.Error("second migration into a populated database succeeded")
+	}
+
+	s := newSQLiteTestStore(t, dir)
+	tasks, _ := s.ListTasks(bg(), true)
+	if len(tasks) != 2 {
+		t.Fatalf("tasks = %d, want 2", len(tasks))
+	}
+	got, _ := s.GetTask(bg(), b.ID)
+	if !got.Archived || got.Prompt != "b" {
+		t.Errorf("migrated task b = %+v", got)
+	}
+	events, _ := s.GetEvents(bg(), a.ID)
+	if len(events) != 1 || events[0].EventType != EventTypeStateChange {
+		t.Errorf("migrated events = %+v", events)
+	}
+	if !s.Settings().Autopilot {
+		t.Error("autopilot setting not migrated")
+	}
+}
@@ -4,52 +4,100 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
-	"strconv"
-	"strings"
 	"sync"
 
 	"changkun.de/wallfacer/internal/logger"
 	"github.com/google/uuid"
 )
 
-// Store is the in-memory task database backed by per-task directory persistence.
-// All mutations are atomic (temp-file + rename) and guarded by a RWMutex.
+// Store is the in-memory task database. Every mutation is written through
+// to a backend (per-task JSON files by default, or SQLite) and guarded by a
+// RWMutex. Turn outputs and live logs are plain files under dir either way.
 type Store struct {
 	mu      sync.RWMutex
 	dir     string
+	backend backend
 	tasks   map[uuid.UUID]*Task
 	events  map[uuid.UUID][]TaskEvent
 	nextSeq map[uuid.UUID]int
 
+	settings Settings
+
 	subMu       sync.Mutex
-	subscribers map[int]chan struct{}
+	subscribers map[int]subscriber
 	nextSubID   int
 }
 
-// NewStore loads (or creates) a Store rooted at dir.
+// NewStore loads (or creates) a Store rooted at dir that persists each task
+// as a directory of JSON files.
 func NewStore(dir string) (*Store, error) {
-	s := &Store{
-		dir:         dir,
-		tasks:       make(map[uuid.UUID]*Task),
-		events:      make(map[uuid.UUID][]TaskEvent),
-		nextSeq:     make(map[uuid.UUID]int),
-		subscribers: make(map[int]chan struct{}),
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
 	}
+	return newStore(dir, &jsonBackend{dir: dir})
+}
 
+// NewSQLiteStore loads (or creates) a Store rooted at dir that persists
+// tasks, events, and settings in dir/wallfacer.db. Existing JSON task
+// directories are not read; import them once with MigrateToSQLite.
+func NewSQLiteStore(dir string) (*Store, error) {
 	if err := os.MkdirAll(dir, 0700); err != nil {
 		return nil, fmt.Errorf("create data dir: %w", err)
 	}
+	b, err := openSQLite(filepath.Join(dir, SQLiteFile))
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	s, err := newStore(dir, b)
+	if err != nil {
+		b.close()
+		return nil, err
+	}
+	return s, nil
+}
 
-	if err := s.loadAll(); err != nil {
+// newStore loads the tasks, events, and settings held by b.
+func newStore(dir string, b backend) (*Store, error) {
+	tasks, events, err := b.load()
+	if err != nil {
 		return nil, fmt.Errorf("load store: %w", err)
 	}
-
+	s := &Store{
+		dir:         dir,
+		backend:     b,
+		tasks:       tasks,
+		events:      events,
+		nextSeq:     make(map[uuid.UUID]int, len(tasks)),
+		subscribers: make(map[int]subscriber),
+	}
+	for id := range tasks {
+		s.nextSeq[id] = 1
+		if evts := events[id]; len(evts) > 0 {
+			s.nextSeq[id] = int(evts[len(evts)-1].ID) + 1
+		}
+	}
+	if err := b.loadSettings(&s.settings); err != nil {
+		return nil, fmt.Errorf("load settings: %w", err)
+	}
+	if err := s.seedUsageLedger(); err != nil {
+		return nil, fmt.Errorf("seed usage ledger: %w", err)
+	}
 	return s, nil
 }
 
-// Close is a no-op placeholder for future resource cleanup.
-func (s *Store) Close() {}
+// Close releases the backend, e.g. the SQLite database handle.
+func (s *Store) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.backend.close(); err != nil {
+		logger.Store.Warn("close store", "error", err)
+	}
+}
+
+// Dir returns the data directory the store is rooted at.
+func (s *Store) Dir() string {
+	return s.dir
+}
 
 // OutputsDir returns the path to the outputs directory for a task.
 // Handlers use this to serve turn output files without accessing Store internals.
@@ -62,84 +110,3 @@ func (s *Store) OutputsDir(taskID uuid.UUID) string {
 func (s *Store) LiveLogPath(taskID uuid.UUID) string {
 	return filepath.Join(s.dir, taskID.String(), "live.log")
 }
-
-// loadAll scans the data directory and populates in-memory maps.
-func (s *Store) loadAll() error {
-	entries, err := os.ReadDir(s.dir)
-	if err != nil {
-		return err
-	}
-
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-		id, err := uuid.Parse(entry.Name())
-		if err != nil {
-			continue // skip non-UUID directories
-		}
-
-		taskPath := filepath.Join(s.dir, entry.Name(), "task.json")
-		raw, err := os.ReadFile(taskPath)
-		if err != nil {
-			logger.Store.Warn("skipping task", "name", entry.Name(), "error", err)
-			continue
-		}
-		var task Task
-		if err := jsonUnmarshal(raw, &task); err != nil {
-			logger.Store.Warn("skipping task", "name", entry.Name(), "error", err)
-			continue
-		}
-		s.tasks[id] = &task
-
-		if err := s.loadEvents(id, entry.Name()); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-// loadEvents reads trace files for a single task into memory.
-func (s *Store) loadEvents(id uuid.UUID, dirName string) error {
-	tracesDir := filepath.Join(s.dir, dirName, "traces")
-	traceEntries, err := os.ReadDir(tracesDir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			s.nextSeq[id] = 1
-			return nil
-		}
-		return err
-	}
-
-	maxSeq := 0
-	for _, te := range traceEntries {
-		if te.IsDir() || !strings.HasSuffix(te.Name(), ".json") {
-			continue
-		}
-		raw, err := os.ReadFile(filepath.Join(tracesDir, te.Name()))
-		if err != nil {
-			logger.Store.Warn("skipping trace", "task", dirName, "trace", te.Name(), "error", err)
-			continue
-		}
-		var evt TaskEvent
-		if err := jsonUnmarshal(raw, &evt); err != nil {
-			logger.Store.Warn("skipping trace", "task", dirName, "trace", te.Name(), "error", err)
-			continue
-		}
-		s.events[id] = append(s.events[id], evt)
-
-		base := strings.TrimSuffix(te.Name(), ".json")
-		if seq, err := strconv.Atoi(base); err == nil && seq > maxSeq {
-			maxSeq = seq
-		}
-	}
-
-	// Sort events by ID for consistent ordering.
-	sort.Slice(s.events[id], func(i, j int) bool {
-		return s.events[id][i].ID < s.events[id][j].ID
-	})
-
-	s.nextSeq[id] = maxSeq + 1
-	return nil
-}
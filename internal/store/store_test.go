@@ -5,6 +5,7 @@ package store
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/google/uuid"
@@ -177,3 +178,90 @@ func TestPersistence_DeletedTaskGoneAfterReload(t *testing.T) {
 		t.Error("expected task to be absent after delete + reload")
 	}
 }
+
+func TestPersistence_SettingsSurviveReload(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+	if s.Settings().Autopilot {
+		t.Fatal("autopilot should default to off")
+	}
+	if err := s.SetAutopilot(bg(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s2.Settings().Autopilot {
+		t.Error("expected autopilot to stay enabled after reload")
+	}
+}
+
+func TestPersistence_RunQueueSurvivesReload(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+	a, b, c := uuid.New(), uuid.New(), uuid.New()
+	for _, id := range []uuid.UUID{a, b, c, a} {
+		if err := s.EnqueueRun(bg(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.DequeueRun(bg(), b); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s2.RunQueue(), []uuid.UUID{a, c}; !slices.Equal(got, want) {
+		t.Errorf("run queue = %v, want %v", got, want)
+	}
+}
+
+func TestPersistence_TemplatesSurviveReload(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+	for _, tmpl := range []Template{
+		{Name: "weekly", Prompt: "update dependencies", Timeout: 30},
+		{Name: "audit", Prompt: "audit"},
+		{Name: "weekly", Prompt: "update dependencies and fix the build", Model: "opus"},
+	} {
+		if err := s.SaveTemplate(bg(), tmpl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s2, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Template{
+		{Name: "audit", Prompt: "audit"},
+		{Name: "weekly", Prompt: "update dependencies and fix the build", Model: "opus"},
+	}
+	if got := s2.Templates(); !slices.Equal(got, want) {
+		t.Errorf("templates = %+v, want %+v", got, want)
+	}
+	if got, ok := s2.GetTemplate("weekly"); !ok || got != want[1] {
+		t.Errorf("GetTemplate(weekly) = %+v, %v", got, ok)
+	}
+	if _, ok := s2.GetTemplate("missing"); ok {
+		t.Error("GetTemplate found a template that was never saved")
+	}
+
+	if found, err := s2.DeleteTemplate(bg(), "audit"); err != nil || !found {
+		t.Fatalf("DeleteTemplate(audit) = %v, %v", found, err)
+	}
+	if found, err := s2.DeleteTemplate(bg(), "audit"); err != nil || found {
+		t.Errorf("second DeleteTemplate(audit) = %v, %v; want false", found, err)
+	}
+	s3, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s3.Templates(); !slices.Equal(got, want[1:]) {
+		t.Errorf("templates after delete = %+v, want %+v", got, want[1:])
+	}
+}
@@ -1,20 +1,38 @@
 package store
 
-// subscribe registers a channel that receives a signal whenever task state changes.
-// The caller must call unsubscribe with the returned ID when done.
-func (s *Store) subscribe() (int, <-chan struct{}) {
+import "github.com/google/uuid"
+
+// subscriber is a channel signalled on store changes. A subscriber with a
+// nil taskID follows the whole board; one with a task ID follows the events
+// of that task.
+type subscriber struct {
+	ch     chan struct{}
+	taskID uuid.UUID
+}
+
+// subscribe registers a channel that receives a signal whenever task state
+// changes (taskID is uuid.Nil) or an event is inserted for taskID. The
+// caller must call unsubscribe with the returned ID when done.
+func (s *Store) subscribe(taskID uuid.UUID) (int, <-chan struct{}) {
 	s.subMu.Lock()
 	defer s.subMu.Unlock()
 	id := s.nextSubID
 	s.nextSubID++
 	ch := make(chan struct{}, 1)
-	s.subscribers[id] = ch
+	s.subscribers[id] = subscriber{ch: ch, taskID: taskID}
 	return id, ch
 }
 
 // Subscribe is the exported variant of subscribe for use outside the package.
 func (s *Store) Subscribe() (int, <-chan struct{}) {
-	return s.subscribe()
+	return s.subscribe(uuid.Nil)
+}
+
+// SubscribeEvents returns a channel signalled whenever an event is inserted
+// for taskID, and when the task is deleted. Signals coalesce, so a receiver
+// reads every event after the last one it saw.
+func (s *Store) SubscribeEvents(taskID uuid.UUID) (int, <-chan struct{}) {
+	return s.subscribe(taskID)
 }
 
 func (s *Store) Unsubscribe(id int) {
@@ -23,14 +41,27 @@ func (s *Store) Unsubscribe(id int) {
 	delete(s.subscribers, id)
 }
 
-// notify wakes all SSE subscribers. Non-blocking: if a subscriber's buffer is
-// already full it already has a pending signal, so no additional send is needed.
+// notify wakes all SSE subscribers of the board. Non-blocking: if a
+// subscriber's buffer is already full it already has a pending signal, so no
+// additional send is needed.
 func (s *Store) notify() {
+	s.notifySubscribers(uuid.Nil)
+}
+
+// notifyEvents wakes the subscribers of taskID's events.
+func (s *Store) notifyEvents(taskID uuid.UUID) {
+	s.notifySubscribers(taskID)
+}
+
+func (s *Store) notifySubscribers(taskID uuid.UUID) {
 	s.subMu.Lock()
 	defer s.subMu.Unlock()
-	for _, ch := range s.subscribers {
+	for _, sub := range s.subscribers {
+		if sub.taskID != taskID {
+			continue
+		}
 		select {
-		case ch <- struct{}{}:
+		case sub.ch <- struct{}{}:
 		default:
 		}
 	}
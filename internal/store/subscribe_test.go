@@ -126,3 +126,40 @@ func TestSubscribe_IDsAreUnique(t *testing.T) {
 		seen[id] = true
 	}
 }
+
+func TestSubscribeEvents_OnlyThatTask(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	other, _ := s.CreateTask(bg(), "q", 5, false)
+
+	id, ch := s.SubscribeEvents(task.ID)
+	defer s.Unsubscribe(id)
+	boardID, board := s.Subscribe()
+	defer s.Unsubscribe(boardID)
+
+	s.InsertEvent(bg(), other.ID, EventTypeSystem, map[string]string{"result": "x"})
+	select {
+	case <-ch:
+		t.Error("notified for another task's event")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	s.InsertEvent(bg(), task.ID, EventTypeSystem, map[string]string{"result": "y"})
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Error("expected notification after InsertEvent, timed out")
+	}
+	select {
+	case <-board:
+		t.Error("board subscribers should not be woken by events")
+	default:
+	}
+
+	s.DeleteTask(bg(), task.ID)
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Error("expected notification after DeleteTask, timed out")
+	}
+}
@@ -1,36 +1,106 @@
 package store
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-// ListTasks returns all tasks sorted by position then creation time.
+// ListTasks returns all tasks sorted by position then creation time, with
+// higher-priority backlog tasks ahead of the rest.
 // Archived tasks are excluded unless includeArchived is true.
-func (s *Store) ListTasks(_ context.Context, includeArchived bool) ([]Task, error) {
+func (s *Store) ListTasks(ctx context.Context, includeArchived bool) ([]Task, error) {
+	tasks, _, err := s.ListTasksFiltered(ctx, TaskFilter{IncludeArchived: includeArchived})
+	return tasks, err
+}
+
+// TaskFilter selects and pages the tasks returned by ListTasksFiltered.
+type TaskFilter struct {
+	IncludeArchived bool
+	// Statuses keeps only tasks in one of these states; empty keeps all.
+	Statuses []string
+	// Offset skips that many matching tasks; Limit caps the page size
+	// (0 = no cap).
+	Offset int
+	Limit  int
+}
+
+// ListTasksFiltered returns the page of tasks matching f in ListTasks order,
+// together with the number of matching tasks before paging.
+func (s *Store) ListTasksFiltered(_ context.Context, f TaskFilter) ([]Task, int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	tasks := make([]Task, 0, len(s.tasks))
 	for _, t := range s.tasks {
-		if !includeArchived && t.Archived {
+		if !f.IncludeArchived && t.Archived {
+			continue
+		}
+		if len(f.Statuses) > 0 && !slices.Contains(f.Statuses, t.Status) {
 			continue
 		}
 		tasks = append(tasks, *t)
 	}
 	sort.Slice(tasks, func(i, j int) bool {
+		if pi, pj := sortPriority(&tasks[i]), sortPriority(&tasks[j]); pi != pj {
+			return pi > pj
+		}
 		if tasks[i].Position != tasks[j].Position {
 			return tasks[i].Position < tasks[j].Position
 		}
 		return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
 	})
-	return tasks, nil
+
+	total := len(tasks)
+	tasks = tasks[min(max(f.Offset, 0), total):]
+	if f.Limit > 0 && f.Limit < len(tasks) {
+		tasks = tasks[:f.Limit]
+	}
+	return tasks, total, nil
+}
+
+// SearchTasks returns the tasks, archived ones included, whose title,
+// prompt, earlier prompts, or result contain query, ignoring case. The most
+// recently updated tasks come first. Both backends keep every task in
+// memory, so this is a scan of the in-memory map.
+func (s *Store) SearchTasks(_ context.Context, query string) ([]Task, error) {
+	q := strings.ToLower(query)
+	contains := func(v string) bool { return strings.Contains(strings.ToLower(v), q) }
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var found []Task
+	for _, t := range s.tasks {
+		if contains(t.Title) || contains(t.Prompt) ||
+			(t.Result != nil && contains(*t.Result)) ||
+			slices.ContainsFunc(t.PromptHistory, contains) {
+			found = append(found, *t)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].UpdatedAt.After(found[j].UpdatedAt)
+	})
+	return found, nil
+}
+
+// sortPriority is the priority rank ListTasks sorts by. Priority only
+// orders the backlog, so every other task ranks as normal and keeps its
+// position order.
+func sortPriority(t *Task) int {
+	if t.Status != "backlog" {
+		return PriorityRank(PriorityNormal)
+	}
+	return PriorityRank(t.Priority)
 }
 
 // GetTask returns a copy of the task with the given ID.
@@ -47,49 +117,166 @@ func (s *Store) GetTask(_ context.Context, id uuid.UUID) (*Task, error) {
 }
 
 // CreateTask creates a new task in backlog status and persists it.
-func (s *Store) CreateTask(_ context.Context, prompt string, timeout int, mountWorktrees bool) (*Task, error) {
+func (s *Store) CreateTask(ctx context.Context, prompt string, timeout int, mountWorktrees bool) (*Task, error) {
+	return s.CreateTaskWith(ctx, prompt, timeout, mountWorktrees, nil)
+}
+
+// CreateTaskWith is CreateTask with init applied to the new task before it
+// is persisted or published, so subscribers never see it half set up. A
+// DependsOn set by init is checked the way SetTaskDependsOn checks it.
+func (s *Store) CreateTaskWith(_ context.Context, prompt string, timeout int, mountWorktrees bool, init func(t *Task)) (*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, err := s.createTaskLocked(prompt, timeout, mountWorktrees, nil, s.nextBacklogPosition(), init)
+	if err != nil {
+		return nil, err
+	}
+	s.notify()
+
+	ret := *task
+	return &ret, nil
+}
+
+// CreateTasks creates one backlog task per prompt under a single lock and a
+// single notification, appending them to the backlog in the given order.
+// Every task receives the same timeout, mount setting, and labels. If any
+// task fails to persist, the ones already created are removed again.
+func (s *Store) CreateTasks(_ context.Context, prompts []string, timeout int, mountWorktrees bool, labels []string) ([]*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pos := s.nextBacklogPosition()
+	created := make([]*Task, 0, len(prompts))
+	for i, prompt := range prompts {
+		task, err := s.createTaskLocked(prompt, timeout, mountWorktrees, labels, pos+i, nil)
+		if err != nil {
+			for _, t := range created {
+				s.removeTaskData(t.ID)
+				delete(s.tasks, t.ID)
+				delete(s.events, t.ID)
+				delete(s.nextSeq, t.ID)
+			}
+			return nil, err
+		}
+		created = append(created, task)
+	}
+	s.notify()
+
+	ret := make([]*Task, len(created))
+	for i, t := range created {
+		cp := *t
+		ret[i] = &cp
+	}
+	return ret, nil
+}
+
+// ImportTask adds a task with its original ID, fields, and events, as read
+// from an export. It returns false without changing anything when a task
+// with that ID already exists. Events keep their IDs and are stored in ID
+// order; their TaskID is set to the task's.
+func (s *Store) ImportTask(_ context.Context, task Task, events []TaskEvent) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if _, ok := s.tasks[task.ID]; ok {
+		return false, nil
+	}
+	evts := slices.Clone(events)
+	slices.SortFunc(evts, func(a, b TaskEvent) int { return cmp.Compare(a.ID, b.ID) })
+	for i := range evts {
+		evts[i].TaskID = task.ID
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.dir, task.ID.String()), 0700); err != nil {
+		return false, err
+	}
+	t := &task
+	if err := s.backend.createTask(t); err != nil {
+		return false, err
+	}
+	for _, e := range evts {
+		if err := s.backend.saveEvent(e); err != nil {
+			s.removeTaskData(t.ID)
+			return false, err
+		}
+	}
+
+	s.tasks[t.ID] = t
+	s.events[t.ID] = evts
+	s.nextSeq[t.ID] = 1
+	if len(evts) > 0 {
+		s.nextSeq[t.ID] = int(evts[len(evts)-1].ID) + 1
+	}
+	s.notify()
+	return true, nil
+}
+
+// removeTaskData deletes a task from the backend and removes its directory
+// of outputs and logs. Caller must hold s.mu.
+func (s *Store) removeTaskData(id uuid.UUID) error {
+	if err := s.backend.deleteTask(id); err != nil {
+		return fmt.Errorf("delete task: %w", err)
+	}
+	if err := os.RemoveAll(filepath.Join(s.dir, id.String())); err != nil {
+		return fmt.Errorf("remove task dir: %w", err)
+	}
+	return nil
+}
+
+// nextBacklogPosition returns the position after the last backlog task.
+// Caller must hold s.mu.
+func (s *Store) nextBacklogPosition() int {
 	maxPos := -1
 	for _, t := range s.tasks {
 		if t.Status == "backlog" && t.Position > maxPos {
 			maxPos = t.Position
 		}
 	}
+	return maxPos + 1
+}
 
-	timeout = clampTimeout(timeout)
-
+// createTaskLocked builds a backlog task, applies init when set, then
+// persists and registers it without notifying subscribers. Caller must hold
+// s.mu.
+func (s *Store) createTaskLocked(prompt string, timeout int, mountWorktrees bool, labels []string, position int, init func(t *Task)) (*Task, error) {
 	now := time.Now()
 	task := &Task{
 		ID:             uuid.New(),
 		Prompt:         prompt,
 		Status:         "backlog",
 		Turns:          0,
-		Timeout:        timeout,
+		Timeout:        clampTimeout(timeout),
 		MountWorktrees: mountWorktrees,
-		Position:       maxPos + 1,
+		Position:       position,
 		CreatedAt:      now,
 		UpdatedAt:      now,
 	}
+	if len(labels) > 0 {
+		task.Labels = append([]string(nil), labels...)
+	}
+	if init != nil {
+		init(task)
+		if len(task.DependsOn) > 0 {
+			deps, err := s.checkDependsOnLocked(task.ID, task.DependsOn)
+			if err != nil {
+				return nil, err
+			}
+			task.DependsOn = deps
+		}
+	}
 
-	taskDir := filepath.Join(s.dir, task.ID.String())
-	tracesDir := filepath.Join(taskDir, "traces")
-	if err := os.MkdirAll(tracesDir, 0700); err != nil {
+	if err := os.MkdirAll(filepath.Join(s.dir, task.ID.String()), 0700); err != nil {
 		return nil, err
 	}
-
-	if err := s.saveTask(task.ID, task); err != nil {
+	if err := s.backend.createTask(task); err != nil {
 		return nil, err
 	}
 
 	s.tasks[task.ID] = task
 	s.events[task.ID] = nil
 	s.nextSeq[task.ID] = 1
-	s.notify()
-
-	ret := *task
-	return &ret, nil
+	return task, nil
 }
 
 // DeleteTask removes a task and all its on-disk data.
@@ -101,20 +288,21 @@ func (s *Store) DeleteTask(_ context.Context, id uuid.UUID) error {
 		return fmt.Errorf("task not found: %s", id)
 	}
 
-	taskDir := filepath.Join(s.dir, id.String())
-	if err := os.RemoveAll(taskDir); err != nil {
-		return fmt.Errorf("remove task dir: %w", err)
+	if err := s.removeTaskData(id); err != nil {
+		return err
 	}
 
 	delete(s.tasks, id)
 	delete(s.events, id)
 	delete(s.nextSeq, id)
 	s.notify()
+	s.notifyEvents(id)
 	return nil
 }
 
-// UpdateTaskStatus sets a task's status field.
-func (s *Store) UpdateTaskStatus(_ context.Context, id uuid.UUID, status string) error {
+// updateTask applies fn to the task with the given id under the write
+// lock, stamps UpdatedAt, persists the task, and notifies subscribers.
+func (s *Store) updateTask(id uuid.UUID, fn func(t *Task)) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -122,7 +310,7 @@ func (s *Store) UpdateTaskStatus(_ context.Context, id uuid.UUID, status string)
 	if !ok {
 		return fmt.Errorf("task not found: %s", id)
 	}
-	t.Status = status
+	fn(t)
 	t.UpdatedAt = time.Now()
 	if err := s.saveTask(id, t); err != nil {
 		return err
@@ -131,26 +319,78 @@ func (s *Store) UpdateTaskStatus(_ context.Context, id uuid.UUID, status string)
 	return nil
 }
 
-// UpdateTaskTitle sets a task's display title.
-func (s *Store) UpdateTaskTitle(_ context.Context, id uuid.UUID, title string) error {
+// UpdateTaskStatus sets a task's status field.
+func (s *Store) UpdateTaskStatus(_ context.Context, id uuid.UUID, status string) error {
+	return s.updateTask(id, func(t *Task) {
+		setStatus(t, status)
+	})
+}
+
+// CompareAndSetStatus moves a task from status from to status to, and
+// reports whether it did. It does nothing when the task is in any other
+// status, so of several callers racing to make the same transition exactly
+// one wins.
+func (s *Store) CompareAndSetStatus(_ context.Context, id uuid.UUID, from, to string) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	t, ok := s.tasks[id]
 	if !ok {
-		return fmt.Errorf("task not found: %s", id)
+		return false, fmt.Errorf("task not found: %s", id)
 	}
-	t.Title = title
-	t.UpdatedAt = time.Now()
+	if t.Status != from {
+		return false, nil
+	}
+	setStatus(t, to)
 	if err := s.saveTask(id, t); err != nil {
-		return err
+		return false, err
 	}
 	s.notify()
-	return nil
+	return true, nil
+}
+
+// setStatus sets t's status and the timestamps that follow from it.
+func setStatus(t *Task, status string) {
+	now := time.Now()
+	t.Status = status
+	t.UpdatedAt = now
+	switch status {
+	case "in_progress":
+		if t.StartedAt == nil {
+			t.StartedAt = &now
+		}
+		t.EndedAt = nil
+	case "backlog":
+		t.EndedAt = nil
+	case "done", "failed", "cancelled":
+		t.EndedAt = &now
+	}
+}
+
+// UpdateTaskTitle sets a task's display title.
+func (s *Store) UpdateTaskTitle(_ context.Context, id uuid.UUID, title string) error {
+	return s.updateTask(id, func(t *Task) {
+		t.Title = title
+	})
 }
 
 // UpdateTaskResult stores the final output, session ID, stop reason, and turn count.
 func (s *Store) UpdateTaskResult(_ context.Context, id uuid.UUID, result, sessionID, stopReason string, turns int) error {
+	return s.updateTask(id, func(t *Task) {
+		t.Result = &result
+		t.SessionID = &sessionID
+		t.StopReason = &stopReason
+		t.Turns = turns
+	})
+}
+
+// maxUsageLogEntries caps Task.UsageLog, which is sent with every task.
+const maxUsageLogEntries = 100
+
+// AccumulateTaskUsage adds token/cost deltas to the task's running totals
+// and records them as one entry in the task's usage log, dropping the
+// oldest entry once it holds maxUsageLogEntries.
+func (s *Store) AccumulateTaskUsage(_ context.Context, id uuid.UUID, delta TaskUsage) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -158,60 +398,207 @@ func (s *Store) UpdateTaskResult(_ context.Context, id uuid.UUID, result, sessio
 	if !ok {
 		return fmt.Errorf("task not found: %s", id)
 	}
-	t.Result = &result
-	t.SessionID = &sessionID
-	t.StopReason = &stopReason
-	t.Turns = turns
+	t.Usage.add(delta)
 	t.UpdatedAt = time.Now()
+	t.UsageLog = append(t.UsageLog, UsageEntry{At: t.UpdatedAt, TaskUsage: delta})
+	if n := len(t.UsageLog) - maxUsageLogEntries; n > 0 {
+		t.UsageLog = slices.Delete(t.UsageLog, 0, n)
+	}
 	if err := s.saveTask(id, t); err != nil {
 		return err
 	}
+	if err := s.recordUsage(t.UpdatedAt, delta); err != nil {
+		return err
+	}
 	s.notify()
 	return nil
 }
 
-// AccumulateTaskUsage adds token/cost deltas to the task's running totals.
-func (s *Store) AccumulateTaskUsage(_ context.Context, id uuid.UUID, delta TaskUsage) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// AccumulateTaskActiveTime adds the wall-clock duration of a turn to a
+// task's ActiveSeconds.
+func (s *Store) AccumulateTaskActiveTime(_ context.Context, id uuid.UUID, d time.Duration) error {
+	return s.updateTask(id, func(t *Task) {
+		t.ActiveSeconds += d.Seconds()
+	})
+}
 
-	t, ok := s.tasks[id]
-	if !ok {
-		return fmt.Errorf("task not found: %s", id)
+// SumUsageSince totals the usage recorded at or after since across all
+// tasks, archived ones included. Usage of deleted tasks is gone with them.
+func (s *Store) SumUsageSince(_ context.Context, since time.Time) TaskUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sum TaskUsage
+	for _, t := range s.tasks {
+		for _, e := range t.UsageLog {
+			if e.At.Before(since) {
+				continue
+			}
+			sum.add(e.TaskUsage)
+		}
 	}
-	t.Usage.InputTokens += delta.InputTokens
-	t.Usage.OutputTokens += delta.OutputTokens
-	t.Usage.CacheReadInputTokens += delta.CacheReadInputTokens
-	t.Usage.CacheCreationTokens += delta.CacheCreationTokens
-	t.Usage.CostUSD += delta.CostUSD
-	t.UpdatedAt = time.Now()
-	if err := s.saveTask(id, t); err != nil {
-		return err
+	return sum
+}
+
+// SummarizeUsage totals usage across all tasks, archived ones included, and
+// breaks it down by task status and by workspace. A zero since sums each
+// task's lifetime usage; otherwise only turns recorded at or after since
+// count.
+func (s *Store) SummarizeUsage(_ context.Context, since time.Time) UsageSummary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sum := UsageSummary{
+		ByStatus:    map[string]TaskUsage{},
+		ByWorkspace: map[string]TaskUsage{},
 	}
-	s.notify()
-	return nil
+	for _, t := range s.tasks {
+		u := t.Usage
+		if !since.IsZero() {
+			u = TaskUsage{}
+			for _, e := range t.UsageLog {
+				if !e.At.Before(since) {
+					u.add(e.TaskUsage)
+				}
+			}
+		}
+		if u == (TaskUsage{}) {
+			continue
+		}
+		sum.Total.add(u)
+		st := sum.ByStatus[t.Status]
+		st.add(u)
+		sum.ByStatus[t.Status] = st
+		for ws := range t.WorktreePaths {
+			w := sum.ByWorkspace[ws]
+			w.add(u)
+			sum.ByWorkspace[ws] = w
+		}
+	}
+	return sum
 }
 
 // UpdateTaskPosition updates the Kanban column sort position.
 func (s *Store) UpdateTaskPosition(_ context.Context, id uuid.UUID, position int) error {
+	return s.updateTask(id, func(t *Task) {
+		t.Position = position
+	})
+}
+
+// RenormalizePositions rewrites the positions within each status column to
+// 0..N-1, preserving the current position-then-creation-time order. Only tasks
+// whose position changes are saved. Returns the number of tasks updated.
+func (s *Store) RenormalizePositions(_ context.Context) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	t, ok := s.tasks[id]
-	if !ok {
-		return fmt.Errorf("task not found: %s", id)
+	columns := make(map[string][]*Task)
+	for _, t := range s.tasks {
+		columns[t.Status] = append(columns[t.Status], t)
 	}
-	t.Position = position
-	t.UpdatedAt = time.Now()
-	if err := s.saveTask(id, t); err != nil {
-		return err
+
+	now := time.Now()
+	updated := 0
+	for _, col := range columns {
+		sort.Slice(col, func(i, j int) bool {
+			if col[i].Position != col[j].Position {
+				return col[i].Position < col[j].Position
+			}
+			return col[i].CreatedAt.Before(col[j].CreatedAt)
+		})
+		for i, t := range col {
+			if t.Position == i {
+				continue
+			}
+			t.Position = i
+			t.UpdatedAt = now
+			if err := s.saveTask(t.ID, t); err != nil {
+				return updated, err
+			}
+			updated++
+		}
 	}
-	s.notify()
-	return nil
+	if updated > 0 {
+		s.notify()
+	}
+	return updated, nil
 }
 
 // UpdateTaskBacklog edits prompt, timeout, fresh_start, and mount_worktrees for backlog tasks.
 func (s *Store) UpdateTaskBacklog(_ context.Context, id uuid.UUID, prompt *string, timeout *int, freshStart *bool, mountWorktrees *bool) error {
+	return s.updateTask(id, func(t *Task) {
+		if prompt != nil {
+			t.Prompt = *prompt
+		}
+		if timeout != nil {
+			t.Timeout = clampTimeout(*timeout)
+		}
+		if freshStart != nil {
+			t.FreshStart = *freshStart
+		}
+		if mountWorktrees != nil {
+			t.MountWorktrees = *mountWorktrees
+		}
+	})
+}
+
+// SetTaskCommitTitle sets how the task title is embedded in its commit message.
+func (s *Store) SetTaskCommitTitle(_ context.Context, id uuid.UUID, mode string) error {
+	return s.updateTask(id, func(t *Task) {
+		t.CommitTitle = mode
+	})
+}
+
+// SetTaskAutoExtend enables or disables deadline auto-extension for a task.
+func (s *Store) SetTaskAutoExtend(_ context.Context, id uuid.UUID, enabled bool) error {
+	return s.updateTask(id, func(t *Task) {
+		t.AutoExtend = enabled
+	})
+}
+
+// SetTaskTurnTimeout sets the per-turn timeout of a task in minutes. Zero
+// reverts to the server's per-turn timeout.
+func (s *Store) SetTaskTurnTimeout(_ context.Context, id uuid.UUID, minutes int) error {
+	return s.updateTask(id, func(t *Task) {
+		t.TurnTimeout = minutes
+	})
+}
+
+// SetTaskSandboxImage sets the sandbox image override for a task. An empty
+// image reverts to the global image.
+func (s *Store) SetTaskSandboxImage(_ context.Context, id uuid.UUID, image string) error {
+	return s.updateTask(id, func(t *Task) {
+		t.SandboxImage = image
+	})
+}
+
+// SetTaskResourceLimits sets the sandbox memory and CPU limit overrides for
+// a task. Empty values revert to the global limits.
+func (s *Store) SetTaskResourceLimits(_ context.Context, id uuid.UUID, memory, cpus string) error {
+	return s.updateTask(id, func(t *Task) {
+		t.MemoryLimit = memory
+		t.CPULimit = cpus
+	})
+}
+
+// SetTaskEnv sets the extra environment variables of a task.
+func (s *Store) SetTaskEnv(_ context.Context, id uuid.UUID, env map[string]string) error {
+	return s.updateTask(id, func(t *Task) {
+		t.Env = maps.Clone(env)
+	})
+}
+
+// SetTaskPriority sets a task's backlog priority. An empty value means
+// PriorityNormal.
+func (s *Store) SetTaskPriority(_ context.Context, id uuid.UUID, priority string) error {
+	return s.updateTask(id, func(t *Task) {
+		t.Priority = priority
+	})
+}
+
+// SetTaskDependsOn sets the tasks that must be done before id may start.
+// Every dependency must exist, and the new edges must not form a cycle.
+func (s *Store) SetTaskDependsOn(_ context.Context, id uuid.UUID, deps []uuid.UUID) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -219,18 +606,11 @@ func (s *Store) UpdateTaskBacklog(_ context.Context, id uuid.UUID, prompt *strin
 	if !ok {
 		return fmt.Errorf("task not found: %s", id)
 	}
-	if prompt != nil {
-		t.Prompt = *prompt
-	}
-	if timeout != nil {
-		t.Timeout = clampTimeout(*timeout)
-	}
-	if freshStart != nil {
-		t.FreshStart = *freshStart
-	}
-	if mountWorktrees != nil {
-		t.MountWorktrees = *mountWorktrees
+	unique, err := s.checkDependsOnLocked(id, deps)
+	if err != nil {
+		return err
 	}
+	t.DependsOn = unique
 	t.UpdatedAt = time.Now()
 	if err := s.saveTask(id, t); err != nil {
 		return err
@@ -239,122 +619,223 @@ func (s *Store) UpdateTaskBacklog(_ context.Context, id uuid.UUID, prompt *strin
 	return nil
 }
 
-// ResetTaskForRetry moves a done/failed/cancelled task back to backlog with a fresh state.
-// freshStart controls whether the task will start a new Claude session (true) or resume the
-// previous one (false, the default) when moved to in_progress.
-func (s *Store) ResetTaskForRetry(_ context.Context, id uuid.UUID, newPrompt string, freshStart bool) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	t, ok := s.tasks[id]
-	if !ok {
-		return fmt.Errorf("task not found: %s", id)
+// checkDependsOnLocked returns deps without duplicates, or an error if id
+// would depend on itself, on a missing task, or through a cycle. Caller
+// must hold s.mu.
+func (s *Store) checkDependsOnLocked(id uuid.UUID, deps []uuid.UUID) ([]uuid.UUID, error) {
+	seen := make(map[uuid.UUID]bool, len(deps))
+	var unique []uuid.UUID
+	for _, dep := range deps {
+		if seen[dep] {
+			continue
+		}
+		seen[dep] = true
+		if dep == id {
+			return nil, fmt.Errorf("task cannot depend on itself")
+		}
+		if _, ok := s.tasks[dep]; !ok {
+			return nil, fmt.Errorf("dependency not found: %s", dep)
+		}
+		if s.dependsOnLocked(dep, id) {
+			return nil, fmt.Errorf("dependency on %s would create a cycle", dep)
+		}
+		unique = append(unique, dep)
 	}
+	return unique, nil
+}
 
-	t.PromptHistory = append(t.PromptHistory, t.Prompt)
-	t.Prompt = newPrompt
-	t.FreshStart = freshStart
-	t.Result = nil
-	t.StopReason = nil
-	t.Turns = 0
-	t.Status = "backlog"
-	t.WorktreePaths = nil
-	t.BranchName = ""
-	t.CommitHashes = nil
-	t.BaseCommitHashes = nil
-	t.UpdatedAt = time.Now()
-	if err := s.saveTask(id, t); err != nil {
-		return err
+// dependsOnLocked reports whether from transitively depends on to.
+// Caller must hold s.mu.
+func (s *Store) dependsOnLocked(from, to uuid.UUID) bool {
+	visited := map[uuid.UUID]bool{}
+	stack := []uuid.UUID{from}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if cur == to {
+			return true
+		}
+		if visited[cur] {
+			continue
+		}
+		visited[cur] = true
+		if t, ok := s.tasks[cur]; ok {
+			stack = append(stack, t.DependsOn...)
+		}
 	}
-	s.notify()
-	return nil
+	return false
 }
 
-// SetTaskArchived sets the archived flag on a task.
-func (s *Store) SetTaskArchived(_ context.Context, id uuid.UUID, archived bool) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// CanStart reports whether all of a task's dependencies are done, and
+// returns the ones that are not. Deleted dependencies are ignored.
+func (s *Store) CanStart(_ context.Context, id uuid.UUID) (bool, []uuid.UUID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	t, ok := s.tasks[id]
 	if !ok {
-		return fmt.Errorf("task not found: %s", id)
+		return false, nil, fmt.Errorf("task not found: %s", id)
 	}
-	t.Archived = archived
-	t.UpdatedAt = time.Now()
-	if err := s.saveTask(id, t); err != nil {
-		return err
+	var pending []uuid.UUID
+	for _, dep := range t.DependsOn {
+		if d, ok := s.tasks[dep]; ok && d.Status != "done" {
+			pending = append(pending, dep)
+		}
 	}
-	s.notify()
-	return nil
+	return len(pending) == 0, pending, nil
 }
 
-// ResumeTask transitions a failed task back to in_progress, optionally updating timeout.
-func (s *Store) ResumeTask(_ context.Context, id uuid.UUID, timeout *int) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// SetTaskMaxCost sets the task's cost ceiling in USD. Zero removes it.
+func (s *Store) SetTaskMaxCost(_ context.Context, id uuid.UUID, usd float64) error {
+	return s.updateTask(id, func(t *Task) {
+		t.MaxCostUSD = usd
+	})
+}
 
-	t, ok := s.tasks[id]
-	if !ok {
-		return fmt.Errorf("task not found: %s", id)
-	}
+// SetTaskDeadline sets or, with nil, clears the time by which the task must
+// have finished.
+func (s *Store) SetTaskDeadline(_ context.Context, id uuid.UUID, deadline *time.Time) error {
+	return s.updateTask(id, func(t *Task) {
+		t.Deadline = deadline
+	})
+}
 
-	t.Status = "in_progress"
-	if timeout != nil {
-		t.Timeout = clampTimeout(*timeout)
-	}
-	t.UpdatedAt = time.Now()
-	if err := s.saveTask(id, t); err != nil {
-		return err
+// SetTaskMergeStrategy sets how the commit pipeline lands the task; empty
+// restores the server default.
+func (s *Store) SetTaskMergeStrategy(_ context.Context, id uuid.UUID, strategy string) error {
+	return s.updateTask(id, func(t *Task) {
+		t.MergeStrategy = strategy
+	})
+}
+
+// SetTaskPullRequestURL records the pull request opened for the task in
+// repoPath.
+func (s *Store) SetTaskPullRequestURL(_ context.Context, id uuid.UUID, repoPath, url string) error {
+	return s.updateTask(id, func(t *Task) {
+		urls := make(map[string]string, len(t.PullRequestURLs)+1)
+		for k, v := range t.PullRequestURLs {
+			urls[k] = v
+		}
+		urls[repoPath] = url
+		t.PullRequestURLs = urls
+	})
+}
+
+// SetTaskModel sets the Claude model used for the task's turns. An empty
+// model falls back to the server-wide default.
+func (s *Store) SetTaskModel(_ context.Context, id uuid.UUID, model string) error {
+	return s.updateTask(id, func(t *Task) {
+		t.Model = model
+	})
+}
+
+// ResetTaskForRetry moves a done/failed/cancelled task back to backlog with a fresh state.
+// freshStart controls whether the task will start a new Claude session (true) or resume the
+// previous one (false, the default) when moved to in_progress. A deadline that has passed
+// is cleared.
+func (s *Store) ResetTaskForRetry(_ context.Context, id uuid.UUID, newPrompt string, freshStart bool) error {
+	return s.updateTask(id, func(t *Task) {
+		t.PromptHistory = append(t.PromptHistory, t.Prompt)
+		t.Prompt = newPrompt
+		t.FreshStart = freshStart
+		t.Result = nil
+		t.StopReason = nil
+		t.Turns = 0
+		setStatus(t, "backlog")
+		t.WorktreePaths = nil
+		t.BranchName = ""
+		t.CommitHashes = nil
+		t.BaseCommitHashes = nil
+		t.TargetBranches = nil
+		t.PullRequestURLs = nil
+		t.Reviewed = false
+		t.ReviewedAt = nil
+		clearPassedDeadline(t)
+	})
+}
+
+// clearPassedDeadline drops a deadline that has already passed, so a task
+// failed for missing it can be retried or resumed.
+func clearPassedDeadline(t *Task) {
+	if t.Deadline != nil && !t.Deadline.After(time.Now()) {
+		t.Deadline = nil
 	}
-	s.notify()
-	return nil
+}
+
+// SetTaskArchived sets the archived flag on a task.
+func (s *Store) SetTaskArchived(_ context.Context, id uuid.UUID, archived bool) error {
+	return s.updateTask(id, func(t *Task) {
+		t.Archived = archived
+	})
+}
+
+// ToggleTaskReviewed flips the reviewed flag on a task, stamping ReviewedAt
+// when it is set, and returns the new value.
+func (s *Store) ToggleTaskReviewed(_ context.Context, id uuid.UUID) (bool, error) {
+	var reviewed bool
+	err := s.updateTask(id, func(t *Task) {
+		t.Reviewed = !t.Reviewed
+		if t.Reviewed {
+			now := time.Now()
+			t.ReviewedAt = &now
+		} else {
+			t.ReviewedAt = nil
+		}
+		reviewed = t.Reviewed
+	})
+	return reviewed, err
+}
+
+// ResumeTask transitions a failed task back to in_progress, optionally updating timeout
+// and model. A deadline that has passed is cleared.
+func (s *Store) ResumeTask(_ context.Context, id uuid.UUID, timeout *int, model *string) error {
+	return s.updateTask(id, func(t *Task) {
+		setStatus(t, "in_progress")
+		if timeout != nil {
+			t.Timeout = clampTimeout(*timeout)
+		}
+		if model != nil {
+			t.Model = *model
+		}
+		clearPassedDeadline(t)
+	})
 }
 
 // UpdateTaskWorktrees persists the worktree paths and branch name for a task.
 func (s *Store) UpdateTaskWorktrees(_ context.Context, id uuid.UUID, worktreePaths map[string]string, branchName string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.updateTask(id, func(t *Task) {
+		t.WorktreePaths = worktreePaths
+		t.BranchName = branchName
+	})
+}
 
-	t, ok := s.tasks[id]
-	if !ok {
-		return fmt.Errorf("task not found: %s", id)
-	}
-	t.WorktreePaths = worktreePaths
-	t.BranchName = branchName
-	t.UpdatedAt = time.Now()
-	if err := s.saveTask(id, t); err != nil {
-		return err
-	}
-	s.notify()
-	return nil
+// UpdateTaskTargetBranches stores the branch each repo's work will merge into.
+func (s *Store) UpdateTaskTargetBranches(_ context.Context, id uuid.UUID, branches map[string]string) error {
+	return s.updateTask(id, func(t *Task) {
+		t.TargetBranches = branches
+	})
 }
 
 // UpdateTaskCommitHashes stores the post-merge commit hash per repo path.
 func (s *Store) UpdateTaskCommitHashes(_ context.Context, id uuid.UUID, hashes map[string]string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.updateTask(id, func(t *Task) {
+		t.CommitHashes = hashes
+	})
+}
 
-	t, ok := s.tasks[id]
-	if !ok {
-		return fmt.Errorf("task not found: %s", id)
-	}
-	t.CommitHashes = hashes
-	t.UpdatedAt = time.Now()
-	return s.saveTask(id, t)
+// SetTaskConflict records the repo and worktree of a task whose rebase is
+// stopped for manual conflict resolution. Empty values clear them.
+func (s *Store) SetTaskConflict(_ context.Context, id uuid.UUID, repoPath, worktreePath string) error {
+	return s.updateTask(id, func(t *Task) {
+		t.ConflictRepo, t.ConflictWorktree = repoPath, worktreePath
+	})
 }
 
 // UpdateTaskBaseCommitHashes stores the default-branch HEAD captured before merge.
 func (s *Store) UpdateTaskBaseCommitHashes(_ context.Context, id uuid.UUID, hashes map[string]string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	t, ok := s.tasks[id]
-	if !ok {
-		return fmt.Errorf("task not found: %s", id)
-	}
-	t.BaseCommitHashes = hashes
-	t.UpdatedAt = time.Now()
-	return s.saveTask(id, t)
+	return s.updateTask(id, func(t *Task) {
+		t.BaseCommitHashes = hashes
+	})
 }
 
 // clampTimeout ensures timeout stays in [1, 1440] minutes with a default of 5.
@@ -2,9 +2,11 @@
 package store
 
 import (
+	"fmt"
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -108,6 +110,54 @@ func TestCreateTask_PersistsToDisk(t *testing.T) {
 	}
 }
 
+func TestCreateTaskWith_SetsFieldsBeforePublishing(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+	dep, _ := s.CreateTask(bg(), "dep", 5, false)
+
+	subID, ch := s.Subscribe()
+	defer s.Unsubscribe(subID)
+	task, err := s.CreateTaskWith(bg(), "p", 5, false, func(t *Task) {
+		t.Model = "opus"
+		t.DependsOn = []uuid.UUID{dep.ID, dep.ID}
+	})
+	if err != nil {
+		t.Fatalf("CreateTaskWith: %v", err)
+	}
+	<-ch
+	tasks, _ := s.ListTasks(bg(), false)
+	if len(tasks) != 2 {
+		t.Fatalf("expected 2 tasks, got %d", len(tasks))
+	}
+	if task.Model != "opus" || len(task.DependsOn) != 1 {
+		t.Errorf("returned task: model %q, depends_on %v", task.Model, task.DependsOn)
+	}
+	select {
+	case <-ch:
+		t.Error("creating one task notified subscribers more than once")
+	default:
+	}
+
+	s2, _ := NewStore(dir)
+	got, _ := s2.GetTask(bg(), task.ID)
+	if got.Model != "opus" || len(got.DependsOn) != 1 {
+		t.Errorf("reloaded task: model %q, depends_on %v", got.Model, got.DependsOn)
+	}
+}
+
+func TestCreateTaskWith_RejectsMissingDependency(t *testing.T) {
+	s := newTestStore(t)
+	_, err := s.CreateTaskWith(bg(), "p", 5, false, func(t *Task) {
+		t.DependsOn = []uuid.UUID{uuid.New()}
+	})
+	if err == nil {
+		t.Fatal("expected an error for a missing dependency")
+	}
+	if tasks, _ := s.ListTasks(bg(), false); len(tasks) != 0 {
+		t.Errorf("a task was created despite the error: %d tasks", len(tasks))
+	}
+}
+
 func TestCreateTask_PositionOnlyCountsBacklog(t *testing.T) {
 	s := newTestStore(t)
 	t1, _ := s.CreateTask(bg(), "a", 5, false)
@@ -119,6 +169,96 @@ func TestCreateTask_PositionOnlyCountsBacklog(t *testing.T) {
 	}
 }
 
+func TestCreateTasks_OrderedBatch(t *testing.T) {
+	s := newTestStore(t)
+	first, _ := s.CreateTask(bg(), "existing", 5, false)
+
+	subID, ch := s.Subscribe()
+	defer s.Unsubscribe(subID)
+
+	tasks, err := s.CreateTasks(bg(), []string{"a", "b", "c"}, 30, true, []string{"batch-1"})
+	if err != nil {
+		t.Fatalf("CreateTasks: %v", err)
+	}
+	if len(tasks) != 3 {
+		t.Fatalf("expected 3 tasks, got %d", len(tasks))
+	}
+	for i, task := range tasks {
+		if want := []string{"a", "b", "c"}[i]; task.Prompt != want {
+			t.Errorf("tasks[%d].Prompt = %q, want %q", i, task.Prompt, want)
+		}
+		if task.Position != first.Position+1+i {
+			t.Errorf("tasks[%d].Position = %d, want %d", i, task.Position, first.Position+1+i)
+		}
+		if task.Timeout != 30 || !task.MountWorktrees || task.Status != "backlog" {
+			t.Errorf("tasks[%d] has unexpected settings: %+v", i, task)
+		}
+		if len(task.Labels) != 1 || task.Labels[0] != "batch-1" {
+			t.Errorf("tasks[%d].Labels = %v, want [batch-1]", i, task.Labels)
+		}
+	}
+
+	select {
+	case <-ch:
+	default:
+		t.Fatal("expected a notification after CreateTasks")
+	}
+}
+
+func TestCreateTasks_PersistsLabels(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+	tasks, _ := s.CreateTasks(bg(), []string{"x"}, 5, false, []string{"grouped"})
+
+	s2, _ := NewStore(dir)
+	got, err := s2.GetTask(bg(), tasks[0].ID)
+	if err != nil {
+		t.Fatalf("GetTask after reload: %v", err)
+	}
+	if len(got.Labels) != 1 || got.Labels[0] != "grouped" {
+		t.Errorf("reloaded Labels = %v, want [grouped]", got.Labels)
+	}
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// ImportTask
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestImportTask_KeepsIDAndEvents(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+	src := newTestStore(t)
+	task, _ := src.CreateTask(bg(), "imported", 5, false)
+	src.UpdateTaskStatus(bg(), task.ID, "done")
+	src.InsertEvent(bg(), task.ID, EventTypeStateChange, map[string]string{"to": "done"})
+	src.InsertEvent(bg(), task.ID, EventTypeSystem, map[string]string{"result": "ok"})
+	exported, _ := src.GetTask(bg(), task.ID)
+	events, _ := src.GetEvents(bg(), task.ID)
+	reversed := []TaskEvent{events[1], events[0]}
+
+	ok, err := s.ImportTask(bg(), *exported, reversed)
+	if err != nil || !ok {
+		t.Fatalf("ImportTask = %v, %v; want true, nil", ok, err)
+	}
+	if ok, err := s.ImportTask(bg(), *exported, nil); ok || err != nil {
+		t.Errorf("second ImportTask = %v, %v; want false, nil", ok, err)
+	}
+	s.InsertEvent(bg(), task.ID, EventTypeOutput, "next")
+
+	s2, _ := NewStore(dir)
+	got, err := s2.GetTask(bg(), task.ID)
+	if err != nil {
+		t.Fatalf("GetTask after reload: %v", err)
+	}
+	if got.Status != "done" || got.Prompt != "imported" {
+		t.Errorf("reloaded task = %+v", got)
+	}
+	evts, _ := s2.GetEvents(bg(), task.ID)
+	if len(evts) != 3 || evts[0].EventType != EventTypeStateChange || evts[2].ID != 3 {
+		t.Errorf("reloaded events = %+v, want the two imported ones then ID 3", evts)
+	}
+}
+
 // ─────────────────────────────────────────────────────────────────────────────
 // GetTask
 // ─────────────────────────────────────────────────────────────────────────────
@@ -182,6 +322,32 @@ func TestListTasks_SamePositionSortedByCreatedAt(t *testing.T) {
 	}
 }
 
+func TestListTasks_BacklogSortedByPriority(t *testing.T) {
+	s := newTestStore(t)
+	low, _ := s.CreateTask(bg(), "low", 5, false)
+	normal, _ := s.CreateTask(bg(), "normal", 5, false)
+	urgent, _ := s.CreateTask(bg(), "urgent", 5, false)
+	high, _ := s.CreateTask(bg(), "high", 5, false)
+	s.SetTaskPriority(bg(), low.ID, PriorityLow)
+	s.SetTaskPriority(bg(), urgent.ID, PriorityUrgent)
+	s.SetTaskPriority(bg(), high.ID, PriorityHigh)
+
+	tasks, _ := s.ListTasks(bg(), false)
+	want := []uuid.UUID{urgent.ID, high.ID, normal.ID, low.ID}
+	for i, id := range want {
+		if tasks[i].ID != id {
+			t.Fatalf("position %d: got %q, want %q", i, tasks[i].Prompt, []string{"urgent", "high", "normal", "low"}[i])
+		}
+	}
+
+	// Priority only orders the backlog.
+	s.UpdateTaskStatus(bg(), urgent.ID, "done")
+	tasks, _ = s.ListTasks(bg(), false)
+	if tasks[0].ID != high.ID {
+		t.Errorf("expected high-priority backlog task first, got %q", tasks[0].Prompt)
+	}
+}
+
 func TestListTasks_ExcludesArchivedByDefault(t *testing.T) {
 	s := newTestStore(t)
 	task, _ := s.CreateTask(bg(), "archive me", 5, false)
@@ -204,6 +370,59 @@ func TestListTasks_IncludesArchivedWhenRequested(t *testing.T) {
 	}
 }
 
+func TestListTasksFiltered(t *testing.T) {
+	s := newTestStore(t)
+	var ids []uuid.UUID
+	for i := range 5 {
+		task, _ := s.CreateTask(bg(), fmt.Sprintf("t%d", i), 5, false)
+		ids = append(ids, task.ID)
+	}
+	s.UpdateTaskStatus(bg(), ids[1], "done")
+	s.UpdateTaskStatus(bg(), ids[3], "done")
+	s.UpdateTaskStatus(bg(), ids[4], "failed")
+	s.SetTaskArchived(bg(), ids[3], true)
+
+	got, total, _ := s.ListTasksFiltered(bg(), TaskFilter{Statuses: []string{"done", "failed"}})
+	if total != 2 || len(got) != 2 || got[0].ID != ids[1] || got[1].ID != ids[4] {
+		t.Errorf("done|failed = %d tasks (total %d), want t1 and t4", len(got), total)
+	}
+
+	got, total, _ = s.ListTasksFiltered(bg(), TaskFilter{IncludeArchived: true, Offset: 1, Limit: 2})
+	if total != 5 || len(got) != 2 || got[0].ID != ids[1] || got[1].ID != ids[2] {
+		t.Errorf("offset 1 limit 2 = %d tasks (total %d), want t1 and t2 of 5", len(got), total)
+	}
+
+	got, total, _ = s.ListTasksFiltered(bg(), TaskFilter{Offset: 10})
+	if total != 4 || len(got) != 0 {
+		t.Errorf("offset past end = %d tasks (total %d), want none of 4", len(got), total)
+	}
+}
+
+func TestSearchTasks(t *testing.T) {
+	s := newTestStore(t)
+	byPrompt, _ := s.CreateTask(bg(), "Fix the Flaky login test", 5, false)
+	byResult, _ := s.CreateTask(bg(), "refactor", 5, false)
+	s.UpdateTaskResult(bg(), byResult.ID, "the login form now validates", "sess", "end_turn", 1)
+	byHistory, _ := s.CreateTask(bg(), "old wording about LOGIN", 5, false)
+	s.ResetTaskForRetry(bg(), byHistory.ID, "new wording", false)
+	s.SetTaskArchived(bg(), byHistory.ID, true)
+	byTitle, _ := s.CreateTask(bg(), "x", 5, false)
+	time.Sleep(time.Millisecond)
+	s.UpdateTaskTitle(bg(), byTitle.ID, "Login page")
+	s.CreateTask(bg(), "unrelated", 5, false)
+
+	got, _ := s.SearchTasks(bg(), "login")
+	if len(got) != 4 {
+		t.Fatalf("found %d tasks, want 4", len(got))
+	}
+	if got[0].ID != byTitle.ID {
+		t.Errorf("first result = %q, want the most recently updated task", got[0].Prompt)
+	}
+	if got, _ := s.SearchTasks(bg(), "FLAKY"); len(got) != 1 || got[0].ID != byPrompt.ID {
+		t.Errorf("case-insensitive search = %+v", got)
+	}
+}
+
 // ─────────────────────────────────────────────────────────────────────────────
 // DeleteTask
 // ─────────────────────────────────────────────────────────────────────────────
@@ -269,6 +488,53 @@ func TestUpdateTaskStatus(t *testing.T) {
 	}
 }
 
+func TestUpdateTaskStatus_RecordsStartAndEnd(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+
+	s.UpdateTaskStatus(bg(), task.ID, "in_progress")
+	got, _ := s.GetTask(bg(), task.ID)
+	if got.StartedAt == nil || got.EndedAt != nil {
+		t.Fatalf("after start: StartedAt = %v, EndedAt = %v", got.StartedAt, got.EndedAt)
+	}
+	started := *got.StartedAt
+
+	s.UpdateTaskStatus(bg(), task.ID, "failed")
+	got, _ = s.GetTask(bg(), task.ID)
+	if got.EndedAt == nil || got.EndedAt.Before(started) {
+		t.Fatalf("after failing: EndedAt = %v", got.EndedAt)
+	}
+
+	// A retry keeps the first start and clears the end.
+	s.UpdateTaskStatus(bg(), task.ID, "in_progress")
+	got, _ = s.GetTask(bg(), task.ID)
+	if !got.StartedAt.Equal(started) || got.EndedAt != nil {
+		t.Errorf("after restart: StartedAt = %v (want %v), EndedAt = %v", got.StartedAt, started, got.EndedAt)
+	}
+}
+
+func TestCompareAndSetStatus(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+
+	if ok, err := s.CompareAndSetStatus(bg(), task.ID, "waiting", "in_progress"); err != nil || ok {
+		t.Fatalf("from the wrong status: ok = %v, err = %v", ok, err)
+	}
+	if ok, err := s.CompareAndSetStatus(bg(), task.ID, "backlog", "in_progress"); err != nil || !ok {
+		t.Fatalf("from backlog: ok = %v, err = %v", ok, err)
+	}
+	got, _ := s.GetTask(bg(), task.ID)
+	if got.Status != "in_progress" || got.StartedAt == nil {
+		t.Errorf("after the move: status = %q, StartedAt = %v", got.Status, got.StartedAt)
+	}
+	if ok, _ := s.CompareAndSetStatus(bg(), task.ID, "backlog", "in_progress"); ok {
+		t.Error("a second move from backlog succeeded")
+	}
+	if _, err := s.CompareAndSetStatus(bg(), uuid.New(), "backlog", "in_progress"); err == nil {
+		t.Error("expected error for unknown task")
+	}
+}
+
 func TestUpdateTaskStatus_NotFound(t *testing.T) {
 	s := newTestStore(t)
 	if err := s.UpdateTaskStatus(bg(), uuid.New(), "done"); err == nil {
@@ -339,6 +605,21 @@ func TestUpdateTaskResult_NotFound(t *testing.T) {
 // AccumulateTaskUsage
 // ─────────────────────────────────────────────────────────────────────────────
 
+func TestAccumulateTaskActiveTime(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+
+	s.AccumulateTaskActiveTime(bg(), task.ID, 1500*time.Millisecond)
+	s.AccumulateTaskActiveTime(bg(), task.ID, 2*time.Second)
+	got, _ := s.GetTask(bg(), task.ID)
+	if got.ActiveSeconds != 3.5 {
+		t.Errorf("ActiveSeconds = %g, want 3.5", got.ActiveSeconds)
+	}
+	if err := s.AccumulateTaskActiveTime(bg(), uuid.New(), time.Second); err == nil {
+		t.Error("expected error for unknown task")
+	}
+}
+
 func TestAccumulateTaskUsage(t *testing.T) {
 	s := newTestStore(t)
 	task, _ := s.CreateTask(bg(), "p", 5, false)
@@ -371,6 +652,147 @@ func TestAccumulateTaskUsage(t *testing.T) {
 	}
 }
 
+// TestUsageLedger verifies that the day's spend, the recent spend, and the
+// usage totals survive deleting the task that spent it and reopening the
+// store, and that a data directory written before the ledger existed is
+// seeded from its tasks.
+func TestUsageLedger(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := s.CreateTask(bg(), "a", 5, false)
+	s.AccumulateTaskUsage(bg(), a.ID, TaskUsage{CostUSD: 1.5})
+	s.AccumulateTaskUsage(bg(), a.ID, TaskUsage{OutputTokens: 10})
+	b, _ := s.CreateTask(bg(), "b", 5, false)
+	s.AccumulateTaskUsage(bg(), b.ID, TaskUsage{CostUSD: 2})
+	s.DeleteTask(bg(), a.ID)
+	now := time.Now()
+	if got := s.DailySpend(now); got != 3.5 {
+		t.Fatalf("DailySpend after delete = %v, want 3.5", got)
+	}
+	if got := s.DailySpend(now.AddDate(0, 0, -1)); got != 0 {
+		t.Errorf("DailySpend yesterday = %v, want 0", got)
+	}
+	hourAgo := now.Add(-time.Hour)
+	if got := s.SpendSince(hourAgo); got != 3.5 {
+		t.Errorf("SpendSince after delete = %v, want 3.5", got)
+	}
+	if got := s.SpendSince(now); got != 0 {
+		t.Errorf("SpendSince now = %v, want 0", got)
+	}
+	if got := s.UsageTotals(); got.CostUSD != 3.5 || got.OutputTokens != 10 {
+		t.Errorf("UsageTotals after delete = %+v, want $3.5 and 10 output tokens", got)
+	}
+
+	reopened, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.DailySpend(now); got != 3.5 {
+		t.Errorf("DailySpend after reopening = %v, want 3.5", got)
+	}
+	if got := reopened.UsageTotals(); got.CostUSD != 3.5 {
+		t.Errorf("UsageTotals after reopening = %+v, want $3.5", got)
+	}
+	if got := reopened.SpendSince(hourAgo); got != 3.5 {
+		t.Errorf("SpendSince after reopening = %v, want 3.5", got)
+	}
+
+	// Drop the ledger as an older version would have written the settings.
+	settings := reopened.Settings()
+	settings.DailySpend = nil
+	settings.RecentSpend = nil
+	settings.UsageTotals = TaskUsage{}
+	if err := reopened.backend.saveSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	seeded, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := seeded.DailySpend(now); got != 2 {
+		t.Errorf("DailySpend seeded from the remaining task = %v, want 2", got)
+	}
+	if got := seeded.UsageTotals(); got.CostUSD != 2 {
+		t.Errorf("UsageTotals seeded from the remaining task = %+v, want $2", got)
+	}
+	if got := seeded.SpendSince(hourAgo); got != 2 {
+		t.Errorf("SpendSince seeded from the remaining task = %v, want 2", got)
+	}
+}
+
+func TestAccumulateTaskUsageCapsLog(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	for range maxUsageLogEntries + 5 {
+		s.AccumulateTaskUsage(bg(), task.ID, TaskUsage{CostUSD: 1})
+	}
+	got, _ := s.GetTask(bg(), task.ID)
+	if len(got.UsageLog) != maxUsageLogEntries {
+		t.Errorf("len(UsageLog) = %d, want %d", len(got.UsageLog), maxUsageLogEntries)
+	}
+	if want := float64(maxUsageLogEntries + 5); got.Usage.CostUSD != want {
+		t.Errorf("Usage.CostUSD = %v, want %v", got.Usage.CostUSD, want)
+	}
+	if spent := s.SpendSince(time.Now().Add(-time.Hour)); spent != float64(maxUsageLogEntries+5) {
+		t.Errorf("SpendSince = %v, want every turn counted", spent)
+	}
+}
+
+func TestSumUsageSince(t *testing.T) {
+	s := newTestStore(t)
+	a, _ := s.CreateTask(bg(), "a", 5, false)
+	b, _ := s.CreateTask(bg(), "b", 5, false)
+
+	s.AccumulateTaskUsage(bg(), a.ID, TaskUsage{OutputTokens: 10, CostUSD: 1})
+	mid := time.Now()
+	time.Sleep(time.Millisecond)
+	s.AccumulateTaskUsage(bg(), a.ID, TaskUsage{OutputTokens: 20, CostUSD: 2})
+	s.AccumulateTaskUsage(bg(), b.ID, TaskUsage{OutputTokens: 40, CostUSD: 4})
+	s.SetTaskArchived(bg(), b.ID, true)
+
+	if got := s.SumUsageSince(bg(), time.Time{}); got.CostUSD != 7 || got.OutputTokens != 70 {
+		t.Errorf("all time = %+v, want $7 and 70 output tokens", got)
+	}
+	if got := s.SumUsageSince(bg(), mid); got.CostUSD != 6 || got.OutputTokens != 60 {
+		t.Errorf("since mid = %+v, want $6 and 60 output tokens", got)
+	}
+}
+
+func TestSummarizeUsage(t *testing.T) {
+	s := newTestStore(t)
+	a, _ := s.CreateTask(bg(), "a", 5, false)
+	b, _ := s.CreateTask(bg(), "b", 5, false)
+	s.CreateTask(bg(), "idle", 5, false)
+	s.UpdateTaskWorktrees(bg(), a.ID, map[string]string{"/repo/x": "/wt/a/x", "/repo/y": "/wt/a/y"}, "task/a")
+	s.UpdateTaskWorktrees(bg(), b.ID, map[string]string{"/repo/x": "/wt/b/x"}, "task/b")
+
+	s.AccumulateTaskUsage(bg(), a.ID, TaskUsage{InputTokens: 5, CostUSD: 1})
+	mid := time.Now()
+	time.Sleep(time.Millisecond)
+	s.AccumulateTaskUsage(bg(), a.ID, TaskUsage{InputTokens: 5, CostUSD: 2})
+	s.AccumulateTaskUsage(bg(), b.ID, TaskUsage{InputTokens: 5, CostUSD: 4})
+	s.UpdateTaskStatus(bg(), b.ID, "done")
+
+	all := s.SummarizeUsage(bg(), time.Time{})
+	if all.Total.CostUSD != 7 || all.Total.InputTokens != 15 {
+		t.Errorf("total = %+v, want $7 and 15 input tokens", all.Total)
+	}
+	if len(all.ByStatus) != 2 || all.ByStatus["backlog"].CostUSD != 3 || all.ByStatus["done"].CostUSD != 4 {
+		t.Errorf("by status = %+v, want backlog $3 and done $4 only", all.ByStatus)
+	}
+	if all.ByWorkspace["/repo/x"].CostUSD != 7 || all.ByWorkspace["/repo/y"].CostUSD != 3 {
+		t.Errorf("by workspace = %+v, want /repo/x $7 and /repo/y $3", all.ByWorkspace)
+	}
+
+	recent := s.SummarizeUsage(bg(), mid)
+	if recent.Total.CostUSD != 6 || recent.ByStatus["backlog"].CostUSD != 2 {
+		t.Errorf("since mid = %+v, want $6 total and backlog $2", recent)
+	}
+}
+
 func TestAccumulateTaskUsage_NotFound(t *testing.T) {
 	s := newTestStore(t)
 	if err := s.AccumulateTaskUsage(bg(), uuid.New(), TaskUsage{}); err == nil {
@@ -402,6 +824,42 @@ func TestUpdateTaskPosition_NotFound(t *testing.T) {
 	}
 }
 
+// ─────────────────────────────────────────────────────────────────────────────
+// RenormalizePositions
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestRenormalizePositions(t *testing.T) {
+	s := newTestStore(t)
+	a, _ := s.CreateTask(bg(), "a", 5, false)
+	b, _ := s.CreateTask(bg(), "b", 5, false)
+	c, _ := s.CreateTask(bg(), "c", 5, false)
+	d, _ := s.CreateTask(bg(), "d", 5, false)
+	s.UpdateTaskPosition(bg(), a.ID, 900)
+	s.UpdateTaskPosition(bg(), b.ID, 7)
+	s.UpdateTaskPosition(bg(), c.ID, 3000)
+	s.UpdateTaskStatus(bg(), d.ID, "done")
+	s.UpdateTaskPosition(bg(), d.ID, 55)
+
+	updated, err := s.RenormalizePositions(bg())
+	if err != nil {
+		t.Fatalf("RenormalizePositions: %v", err)
+	}
+	if updated != 4 {
+		t.Errorf("updated = %d, want 4", updated)
+	}
+	want := map[uuid.UUID]int{b.ID: 0, a.ID: 1, c.ID: 2, d.ID: 0}
+	for id, pos := range want {
+		got, _ := s.GetTask(bg(), id)
+		if got.Position != pos {
+			t.Errorf("task %s position = %d, want %d", got.Prompt, got.Position, pos)
+		}
+	}
+
+	if updated, _ := s.RenormalizePositions(bg()); updated != 0 {
+		t.Errorf("second pass updated %d tasks, want 0", updated)
+	}
+}
+
 // ─────────────────────────────────────────────────────────────────────────────
 // UpdateTaskBacklog
 // ─────────────────────────────────────────────────────────────────────────────
@@ -537,6 +995,189 @@ func TestResetTaskForRetry_PreservesMountWorktrees(t *testing.T) {
 	}
 }
 
+// ─────────────────────────────────────────────────────────────────────────────
+// SetTaskCommitTitle
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestSetTaskCommitTitle(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+
+	if err := s.SetTaskCommitTitle(bg(), task.ID, CommitTitlePrefix); err != nil {
+		t.Fatalf("SetTaskCommitTitle: %v", err)
+	}
+	got, _ := s.GetTask(bg(), task.ID)
+	if got.CommitTitle != CommitTitlePrefix {
+		t.Errorf("CommitTitle = %q, want %q", got.CommitTitle, CommitTitlePrefix)
+	}
+
+	if err := s.SetTaskCommitTitle(bg(), uuid.New(), CommitTitleSuffix); err == nil {
+		t.Error("expected error for unknown task")
+	}
+}
+
+func TestSetTaskModel(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+
+	if err := s.SetTaskModel(bg(), task.ID, "opus"); err != nil {
+		t.Fatalf("SetTaskModel: %v", err)
+	}
+	got, _ := s.GetTask(bg(), task.ID)
+	if got.Model != "opus" {
+		t.Errorf("Model = %q, want %q", got.Model, "opus")
+	}
+
+	if err := s.SetTaskModel(bg(), uuid.New(), "opus"); err == nil {
+		t.Error("expected error for unknown task")
+	}
+}
+
+func TestSetTaskMaxCost(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+
+	if err := s.SetTaskMaxCost(bg(), task.ID, 2.5); err != nil {
+		t.Fatalf("SetTaskMaxCost: %v", err)
+	}
+	got, _ := s.GetTask(bg(), task.ID)
+	if got.MaxCostUSD != 2.5 {
+		t.Errorf("MaxCostUSD = %v, want 2.5", got.MaxCostUSD)
+	}
+
+	if err := s.SetTaskMaxCost(bg(), uuid.New(), 1); err == nil {
+		t.Error("expected error for unknown task")
+	}
+}
+
+func TestSetTaskMergeStrategyAndPullRequestURL(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+
+	if err := s.SetTaskMergeStrategy(bg(), task.ID, MergeStrategyPullRequest); err != nil {
+		t.Fatalf("SetTaskMergeStrategy: %v", err)
+	}
+	s.SetTaskPullRequestURL(bg(), task.ID, "/repo/a", "https://example.com/pr/1")
+	s.SetTaskPullRequestURL(bg(), task.ID, "/repo/b", "https://example.com/pr/2")
+	got, _ := s.GetTask(bg(), task.ID)
+	if got.MergeStrategy != MergeStrategyPullRequest || len(got.PullRequestURLs) != 2 {
+		t.Fatalf("task = %+v", got)
+	}
+
+	s.ResetTaskForRetry(bg(), task.ID, "again", false)
+	got, _ = s.GetTask(bg(), task.ID)
+	if got.PullRequestURLs != nil || got.MergeStrategy != MergeStrategyPullRequest {
+		t.Errorf("after retry: urls = %v, strategy = %q; want urls cleared, strategy kept", got.PullRequestURLs, got.MergeStrategy)
+	}
+	if err := s.SetTaskPullRequestURL(bg(), uuid.New(), "/r", "u"); err == nil {
+		t.Error("expected error for unknown task")
+	}
+}
+
+func TestSetTaskAutoExtend(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+
+	if err := s.SetTaskAutoExtend(bg(), task.ID, true); err != nil {
+		t.Fatalf("SetTaskAutoExtend: %v", err)
+	}
+	got, _ := s.GetTask(bg(), task.ID)
+	if !got.AutoExtend {
+		t.Error("AutoExtend should be true")
+	}
+
+	if err := s.SetTaskAutoExtend(bg(), uuid.New(), true); err == nil {
+		t.Error("expected error for unknown task")
+	}
+}
+
+func TestSetTaskSandboxImage(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+
+	if err := s.SetTaskSandboxImage(bg(), task.ID, "registry.local/claude-go:1.22"); err != nil {
+		t.Fatalf("SetTaskSandboxImage: %v", err)
+	}
+	got, _ := s.GetTask(bg(), task.ID)
+	if got.SandboxImage != "registry.local/claude-go:1.22" {
+		t.Errorf("SandboxImage = %q", got.SandboxImage)
+	}
+
+	if err := s.SetTaskSandboxImage(bg(), task.ID, ""); err != nil {
+		t.Fatalf("SetTaskSandboxImage: %v", err)
+	}
+	got, _ = s.GetTask(bg(), task.ID)
+	if got.SandboxImage != "" {
+		t.Errorf("SandboxImage = %q, want empty", got.SandboxImage)
+	}
+
+	if err := s.SetTaskSandboxImage(bg(), uuid.New(), "x"); err == nil {
+		t.Error("expected error for unknown task")
+	}
+}
+
+func TestSetTaskPriority(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+
+	if err := s.SetTaskPriority(bg(), task.ID, PriorityUrgent); err != nil {
+		t.Fatalf("SetTaskPriority: %v", err)
+	}
+	got, _ := s.GetTask(bg(), task.ID)
+	if got.Priority != PriorityUrgent {
+		t.Errorf("Priority = %q, want urgent", got.Priority)
+	}
+
+	if err := s.SetTaskPriority(bg(), uuid.New(), PriorityHigh); err == nil {
+		t.Error("expected error for unknown task")
+	}
+}
+
+func TestSetTaskDependsOnAndCanStart(t *testing.T) {
+	s := newTestStore(t)
+	refactor, _ := s.CreateTask(bg(), "refactor", 5, false)
+	tests, _ := s.CreateTask(bg(), "tests", 5, false)
+
+	if err := s.SetTaskDependsOn(bg(), tests.ID, []uuid.UUID{refactor.ID, refactor.ID}); err != nil {
+		t.Fatalf("SetTaskDependsOn: %v", err)
+	}
+	got, _ := s.GetTask(bg(), tests.ID)
+	if len(got.DependsOn) != 1 || got.DependsOn[0] != refactor.ID {
+		t.Fatalf("DependsOn = %v, want [refactor]", got.DependsOn)
+	}
+
+	ok, pending, err := s.CanStart(bg(), tests.ID)
+	if err != nil || ok || len(pending) != 1 || pending[0] != refactor.ID {
+		t.Fatalf("CanStart = %v %v %v, want blocked on refactor", ok, pending, err)
+	}
+	s.UpdateTaskStatus(bg(), refactor.ID, "done")
+	if ok, _, _ := s.CanStart(bg(), tests.ID); !ok {
+		t.Fatal("expected task to be startable once its dependency is done")
+	}
+
+	if err := s.SetTaskDependsOn(bg(), refactor.ID, []uuid.UUID{tests.ID}); err == nil {
+		t.Error("expected cycle to be rejected")
+	}
+	if err := s.SetTaskDependsOn(bg(), tests.ID, []uuid.UUID{tests.ID}); err == nil {
+		t.Error("expected self-dependency to be rejected")
+	}
+	if err := s.SetTaskDependsOn(bg(), tests.ID, []uuid.UUID{uuid.New()}); err == nil {
+		t.Error("expected unknown dependency to be rejected")
+	}
+}
+
+func TestCanStartIgnoresDeletedDependency(t *testing.T) {
+	s := newTestStore(t)
+	dep, _ := s.CreateTask(bg(), "dep", 5, false)
+	task, _ := s.CreateTask(bg(), "task", 5, false)
+	s.SetTaskDependsOn(bg(), task.ID, []uuid.UUID{dep.ID})
+	s.DeleteTask(bg(), dep.ID)
+
+	if ok, pending, _ := s.CanStart(bg(), task.ID); !ok {
+		t.Fatalf("expected deleted dependency to be ignored, pending %v", pending)
+	}
+}
+
 // ─────────────────────────────────────────────────────────────────────────────
 // ResetTaskForRetry
 // ─────────────────────────────────────────────────────────────────────────────
@@ -604,6 +1245,7 @@ func TestResetTaskForRetry_ClearsBaseCommitHashes(t *testing.T) {
 	task, _ := s.CreateTask(bg(), "original", 5, false)
 	s.UpdateTaskCommitHashes(bg(), task.ID, map[string]string{"/repo": "abc"})
 	s.UpdateTaskBaseCommitHashes(bg(), task.ID, map[string]string{"/repo": "def"})
+	s.UpdateTaskTargetBranches(bg(), task.ID, map[string]string{"/repo": "main"})
 
 	s.ResetTaskForRetry(bg(), task.ID, "retry prompt", true)
 
@@ -614,6 +1256,9 @@ func TestResetTaskForRetry_ClearsBaseCommitHashes(t *testing.T) {
 	if got.CommitHashes != nil {
 		t.Errorf("CommitHashes should be nil after reset, got %v", got.CommitHashes)
 	}
+	if got.TargetBranches != nil {
+		t.Errorf("TargetBranches should be nil after reset, got %v", got.TargetBranches)
+	}
 }
 
 func TestResetTaskForRetry_NotFound(t *testing.T) {
@@ -651,6 +1296,49 @@ func TestSetTaskArchived_NotFound(t *testing.T) {
 	}
 }
 
+// ─────────────────────────────────────────────────────────────────────────────
+// ToggleTaskReviewed
+// ─────────────────────────────────────────────────────────────────────────────
+
+func TestToggleTaskReviewed(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+
+	reviewed, err := s.ToggleTaskReviewed(bg(), task.ID)
+	if err != nil || !reviewed {
+		t.Fatalf("first toggle: reviewed=%v err=%v", reviewed, err)
+	}
+	got, _ := s.GetTask(bg(), task.ID)
+	if !got.Reviewed || got.ReviewedAt == nil {
+		t.Errorf("expected Reviewed with ReviewedAt, got %v %v", got.Reviewed, got.ReviewedAt)
+	}
+
+	reviewed, _ = s.ToggleTaskReviewed(bg(), task.ID)
+	got, _ = s.GetTask(bg(), task.ID)
+	if reviewed || got.Reviewed || got.ReviewedAt != nil {
+		t.Errorf("expected cleared review state, got %v %v", got.Reviewed, got.ReviewedAt)
+	}
+}
+
+func TestToggleTaskReviewed_ClearedOnRetry(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	s.ToggleTaskReviewed(bg(), task.ID)
+
+	s.ResetTaskForRetry(bg(), task.ID, "again", false)
+	got, _ := s.GetTask(bg(), task.ID)
+	if got.Reviewed || got.ReviewedAt != nil {
+		t.Error("expected retry to clear the reviewed marker")
+	}
+}
+
+func TestToggleTaskReviewed_NotFound(t *testing.T) {
+	s := newTestStore(t)
+	if _, err := s.ToggleTaskReviewed(bg(), uuid.New()); err == nil {
+		t.Error("expected error for unknown task")
+	}
+}
+
 // ─────────────────────────────────────────────────────────────────────────────
 // ResumeTask
 // ─────────────────────────────────────────────────────────────────────────────
@@ -660,7 +1348,7 @@ func TestResumeTask_SetsInProgress(t *testing.T) {
 	task, _ := s.CreateTask(bg(), "p", 5, false)
 	s.UpdateTaskStatus(bg(), task.ID, "failed")
 
-	if err := s.ResumeTask(bg(), task.ID, nil); err != nil {
+	if err := s.ResumeTask(bg(), task.ID, nil, nil); err != nil {
 		t.Fatalf("ResumeTask: %v", err)
 	}
 	got, _ := s.GetTask(bg(), task.ID)
@@ -669,12 +1357,42 @@ func TestResumeTask_SetsInProgress(t *testing.T) {
 	}
 }
 
+func TestResumeTask_ReopensTimestamps(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+	s.UpdateTaskStatus(bg(), task.ID, "in_progress")
+	s.UpdateTaskStatus(bg(), task.ID, "failed")
+	failed, _ := s.GetTask(bg(), task.ID)
+	if failed.StartedAt == nil || failed.EndedAt == nil {
+		t.Fatalf("failed task: started_at %v, ended_at %v; want both set", failed.StartedAt, failed.EndedAt)
+	}
+
+	if err := s.ResumeTask(bg(), task.ID, nil, nil); err != nil {
+		t.Fatalf("ResumeTask: %v", err)
+	}
+	got, _ := s.GetTask(bg(), task.ID)
+	if got.EndedAt != nil {
+		t.Errorf("EndedAt = %v after resume, want nil", got.EndedAt)
+	}
+	if got.StartedAt == nil || !got.StartedAt.Equal(*failed.StartedAt) {
+		t.Errorf("StartedAt = %v after resume, want %v", got.StartedAt, failed.StartedAt)
+	}
+
+	s.UpdateTaskStatus(bg(), task.ID, "failed")
+	if err := s.ResetTaskForRetry(bg(), task.ID, "again", false); err != nil {
+		t.Fatalf("ResetTaskForRetry: %v", err)
+	}
+	if got, _ := s.GetTask(bg(), task.ID); got.EndedAt != nil {
+		t.Errorf("EndedAt = %v after retry, want nil", got.EndedAt)
+	}
+}
+
 func TestResumeTask_WithTimeout(t *testing.T) {
 	s := newTestStore(t)
 	task, _ := s.CreateTask(bg(), "p", 5, false)
 	timeout := 60
 
-	s.ResumeTask(bg(), task.ID, &timeout)
+	s.ResumeTask(bg(), task.ID, &timeout, nil)
 
 	got, _ := s.GetTask(bg(), task.ID)
 	if got.Timeout != 60 {
@@ -687,7 +1405,7 @@ func TestResumeTask_TimeoutClamped(t *testing.T) {
 	task, _ := s.CreateTask(bg(), "p", 5, false)
 	timeout := 9999
 
-	s.ResumeTask(bg(), task.ID, &timeout)
+	s.ResumeTask(bg(), task.ID, &timeout, nil)
 
 	got, _ := s.GetTask(bg(), task.ID)
 	if got.Timeout != 1440 {
@@ -697,7 +1415,7 @@ func TestResumeTask_TimeoutClamped(t *testing.T) {
 
 func TestResumeTask_NotFound(t *testing.T) {
 	s := newTestStore(t)
-	if err := s.ResumeTask(bg(), uuid.New(), nil); err == nil {
+	if err := s.ResumeTask(bg(), uuid.New(), nil, nil); err == nil {
 		t.Error("expected error for unknown task")
 	}
 }
@@ -757,6 +1475,23 @@ func TestUpdateTaskCommitHashes_NotFound(t *testing.T) {
 	}
 }
 
+func TestUpdateTaskTargetBranches(t *testing.T) {
+	s := newTestStore(t)
+	task, _ := s.CreateTask(bg(), "p", 5, false)
+
+	if err := s.UpdateTaskTargetBranches(bg(), task.ID, map[string]string{"/repo/a": "develop"}); err != nil {
+		t.Fatalf("UpdateTaskTargetBranches: %v", err)
+	}
+
+	got, _ := s.GetTask(bg(), task.ID)
+	if got.TargetBranches["/repo/a"] != "develop" {
+		t.Errorf("TargetBranches[/repo/a] = %q", got.TargetBranches["/repo/a"])
+	}
+	if err := s.UpdateTaskTargetBranches(bg(), uuid.New(), nil); err == nil {
+		t.Error("expected error for unknown task")
+	}
+}
+
 func TestUpdateTaskBaseCommitHashes(t *testing.T) {
 	s := newTestStore(t)
 	task, _ := s.CreateTask(bg(), "p", 5, false)
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Template is a saved prompt that new tasks can be created from.
+type Template struct {
+	Name    string `json:"name"`
+	Prompt  string `json:"prompt"`
+	Timeout int    `json:"timeout,omitempty"`
+	Model   string `json:"model,omitempty"`
+	// Schedule is a cron expression; when set, a backlog task is created
+	// from the template each time it fires.
+	Schedule string `json:"schedule,omitempty"`
+	// LastRun is when the schedule last fired, or when it was first seen.
+	LastRun *time.Time `json:"last_run,omitempty"`
+}
+
+// Templates returns the saved task templates sorted by name.
+func (s *Store) Templates() []Template {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.settings.Templates)
+}
+
+// GetTemplate returns the template called name.
+func (s *Store) GetTemplate(name string) (Template, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := s.templateIndex(name)
+	if i < 0 {
+		return Template{}, false
+	}
+	return s.settings.Templates[i], true
+}
+
+// SaveTemplate adds t, replacing any template with the same name. A nil
+// LastRun keeps that of the replaced template.
+func (s *Store) SaveTemplate(_ context.Context, t Template) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := s.templateIndex(t.Name); i >= 0 && t.LastRun == nil {
+		t.LastRun = s.settings.Templates[i].LastRun
+	}
+	templates := slices.DeleteFunc(slices.Clone(s.settings.Templates), func(o Template) bool { return o.Name == t.Name })
+	templates = append(templates, t)
+	slices.SortFunc(templates, func(a, b Template) int { return cmp.Compare(a.Name, b.Name) })
+
+	settings := s.settings
+	settings.Templates = templates
+	if err := s.backend.saveSettings(settings); err != nil {
+		return err
+	}
+	s.settings = settings
+	return nil
+}
+
+// DeleteTemplate removes the template called name, which also stops its
+// schedule, and reports whether there was one.
+func (s *Store) DeleteTemplate(_ context.Context, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.templateIndex(name)
+	if i < 0 {
+		return false, nil
+	}
+	settings := s.settings
+	settings.Templates = slices.Delete(slices.Clone(s.settings.Templates), i, i+1)
+	if err := s.backend.saveSettings(settings); err != nil {
+		return false, err
+	}
+	s.settings = settings
+	return true, nil
+}
+
+// SetTemplateLastRun records when the schedule of the template called name
+// last fired.
+func (s *Store) SetTemplateLastRun(_ context.Context, name string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.templateIndex(name)
+	if i < 0 {
+		return fmt.Errorf("template not found: %s", name)
+	}
+	templates := slices.Clone(s.settings.Templates)
+	templates[i].LastRun = &at
+
+	settings := s.settings
+	settings.Templates = templates
+	if err := s.backend.saveSettings(settings); err != nil {
+		return err
+	}
+	s.settings = settings
+	return nil
+}
+
+// templateIndex returns the index of the template called name, or -1.
+// Caller must hold s.mu.
+func (s *Store) templateIndex(name string) int {
+	return slices.IndexFunc(s.settings.Templates, func(t Template) bool { return t.Name == name })
+}
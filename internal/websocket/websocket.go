@@ -0,0 +1,297 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455), enough for the UI's update channel: the opening handshake,
+// text and binary messages (fragmented or not), ping/pong, and the closing
+// handshake. Extensions and subprotocols are not negotiated.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opcodes of the frames a Conn reads and writes.
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Close status codes sent by a Conn.
+const (
+	CloseNormal        = 1000
+	CloseProtocolError = 1002
+	CloseTooBig        = 1009
+)
+
+// acceptGUID is appended to the client's key to compute
+// Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// writeTimeout bounds every frame write, so a client that stops reading
+// cannot block the writer forever.
+const writeTimeout = 10 * time.Second
+
+// ErrClosed is returned by ReadMessage once the peer has closed the
+// connection.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// Conn is a server-side WebSocket connection. ReadMessage must be called
+// from one goroutine at a time; the write methods are safe for concurrent
+// use.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	// ReadLimit caps the size of a message read; larger messages close the
+	// connection with CloseTooBig.
+	ReadLimit int64
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+// Upgrade performs the opening handshake on a GET request and takes over its
+// connection. Requests from a browser page on another host are refused, as
+// browsers do not apply CORS to WebSockets. On failure Upgrade has replied
+// with an HTTP error.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	fail := func(code int, msg string) (*Conn, error) {
+		http.Error(w, msg, code)
+		return nil, errors.New("websocket: " + msg)
+	}
+	if r.Method != http.MethodGet {
+		return fail(http.StatusMethodNotAllowed, "method not allowed")
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return fail(http.StatusBadRequest, "missing Sec-WebSocket-Key")
+	}
+	if !sameOrigin(r) {
+		return fail(http.StatusForbidden, "cross-origin websocket refused")
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, "hijack: "+err.Error())
+	}
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, br: brw.Reader, ReadLimit: 1 << 20}, nil
+}
+
+// headerHasToken reports whether the comma-separated header contains token,
+// case-insensitively.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether r has no Origin (a non-browser client) or an
+// Origin whose host matches the request's.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// ReadMessage returns the next text or binary message. It answers pings and
+// the closing handshake itself; after the peer closes it returns ErrClosed.
+func (c *Conn) ReadMessage() (op int, data []byte, err error) {
+	op = -1
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOp {
+		case OpPing:
+			if err := c.writeFrame(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			// Echo the status code, if any, to complete the handshake.
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			c.writeFrame(OpClose, payload)
+			c.Close()
+			return 0, nil, ErrClosed
+		case OpText, OpBinary:
+			if op != -1 {
+				return 0, nil, c.fail(CloseProtocolError, "new message inside a fragmented one")
+			}
+			op = frameOp
+		case OpContinuation:
+			if op == -1 {
+				return 0, nil, c.fail(CloseProtocolError, "continuation without a message")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %#x", frameOp))
+		}
+		if int64(len(data))+int64(len(payload)) > c.ReadLimit {
+			return 0, nil, c.fail(CloseTooBig, "message too big")
+		}
+		data = append(data, payload...)
+		if fin {
+			return op, data, nil
+		}
+	}
+}
+
+// readFrame reads and unmasks one frame.
+func (c *Conn) readFrame() (fin bool, op int, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = hdr[0]&0x80 != 0
+	op = int(hdr[0] & 0x0F)
+	if hdr[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	if hdr[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "unmasked client frame")
+	}
+	n := int64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = int64(binary.BigEndian.Uint64(ext[:]) & (1<<63 - 1))
+	}
+	if op >= OpClose && (n > 125 || !fin) {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if n > c.ReadLimit {
+		return false, 0, nil, c.fail(CloseTooBig, "message too big")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// fail closes the connection with code and returns an error with reason.
+func (c *Conn) fail(code int, reason string) error {
+	c.CloseWithCode(code, reason)
+	return errors.New("websocket: " + reason)
+}
+
+// WriteText sends data as one text message.
+func (c *Conn) WriteText(data []byte) error {
+	return c.writeFrame(OpText, data)
+}
+
+// Ping sends a ping. Clients answer with a pong, which keeps proxies from
+// closing an idle connection.
+func (c *Conn) Ping() error {
+	return c.writeFrame(OpPing, nil)
+}
+
+// writeFrame sends one unfragmented, unmasked frame.
+func (c *Conn) writeFrame(op int, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | byte(op)
+	switch n := len(payload); {
+	case n <= 125:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	bufs := net.Buffers{hdr, payload}
+	_, err := bufs.WriteTo(c.conn)
+	return err
+}
+
+// CloseWithCode sends a close frame with code and reason, then closes the
+// connection.
+func (c *Conn) CloseWithCode(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	c.writeFrame(OpClose, append(payload, reason...))
+	return c.Close()
+}
+
+// Close closes the underlying connection without a closing handshake. It is
+// safe to call more than once.
+func (c *Conn) Close() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
@@ -0,0 +1,194 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dial performs a client handshake against srv and returns the connection
+// and the raw response status line.
+func dial(t *testing.T, srv *httptest.Server, origin string) (net.Conn, *bufio.Reader, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET / HTTP/1.1\r\nHost: " + strings.TrimPrefix(srv.URL, "http://") + "\r\n" +
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+	if origin != "" {
+		req += "Origin: " + origin + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// The accept value for this key is given in RFC 6455 section 1.3.
+		if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			t.Errorf("Sec-WebSocket-Accept = %q", got)
+		}
+	}
+	return conn, br, resp.Status
+}
+
+// writeClientFrame writes a masked frame, as a client must.
+func writeClientFrame(t *testing.T, conn net.Conn, fin bool, op int, payload []byte) {
+	t.Helper()
+	b0 := byte(op)
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, 0x80|byte(n))
+	default:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readServerFrame reads one unmasked frame.
+func readServerFrame(t *testing.T, br *bufio.Reader) (int, []byte) {
+	t.Helper()
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	if hdr[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+	n := int(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(br, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(br, ext[:])
+		n = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return int(hdr[0] & 0x0F), payload
+}
+
+// echoServer echoes each message back in upper case until the client
+// closes, then reports the final ReadMessage error on done.
+func echoServer(t *testing.T, done chan<- error) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			_, data, err := c.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+			c.WriteText([]byte(strings.ToUpper(string(data))))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestEchoPingAndClose(t *testing.T) {
+	done := make(chan error, 1)
+	srv := echoServer(t, done)
+	conn, br, status := dial(t, srv, "")
+	if !strings.HasPrefix(status, "101") {
+		t.Fatalf("handshake status = %q", status)
+	}
+
+	writeClientFrame(t, conn, true, OpText, []byte("hello"))
+	if op, data := readServerFrame(t, br); op != OpText || string(data) != "HELLO" {
+		t.Errorf("echo = %d %q", op, data)
+	}
+
+	// A fragmented message with a ping in between.
+	writeClientFrame(t, conn, false, OpText, []byte("frag"))
+	writeClientFrame(t, conn, true, OpPing, []byte("p"))
+	writeClientFrame(t, conn, true, OpContinuation, []byte(strings.Repeat("x", 200)))
+	if op, data := readServerFrame(t, br); op != OpPong || string(data) != "p" {
+		t.Errorf("pong = %d %q", op, data)
+	}
+	if op, data := readServerFrame(t, br); op != OpText || string(data) != "FRAG"+strings.Repeat("X", 200) {
+		t.Errorf("fragmented echo = %d %q", op, data)
+	}
+
+	writeClientFrame(t, conn, true, OpClose, []byte{0x03, 0xE8})
+	if op, data := readServerFrame(t, br); op != OpClose || binary.BigEndian.Uint16(data) != CloseNormal {
+		t.Errorf("close reply = %d %v", op, data)
+	}
+	if err := <-done; err != ErrClosed {
+		t.Errorf("ReadMessage error = %v, want ErrClosed", err)
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	done := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		c.ReadLimit = 100
+		_, _, err = c.ReadMessage()
+		done <- err
+	}))
+	defer srv.Close()
+	conn, br, _ := dial(t, srv, "")
+	writeClientFrame(t, conn, true, OpText, make([]byte, 101))
+	if op, data := readServerFrame(t, br); op != OpClose || binary.BigEndian.Uint16(data) != CloseTooBig {
+		t.Errorf("close = %d %v, want CloseTooBig", op, data)
+	}
+	if err := <-done; err == nil {
+		t.Error("ReadMessage succeeded past ReadLimit")
+	}
+}
+
+func TestUpgradeRefusals(t *testing.T) {
+	srv := echoServer(t, make(chan error, 1))
+	if _, _, status := dial(t, srv, "http://evil.example"); !strings.HasPrefix(status, "403") {
+		t.Errorf("cross-origin handshake status = %q, want 403", status)
+	}
+	if _, _, status := dial(t, srv, srv.URL); !strings.HasPrefix(status, "101") {
+		t.Errorf("same-origin handshake status = %q, want 101", status)
+	}
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET status = %d, want 400", resp.StatusCode)
+	}
+}
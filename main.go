@@ -6,16 +6,20 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 
+	"changkun.de/wallfacer/internal/envconfig"
 	"changkun.de/wallfacer/internal/logger"
 )
 
 func printUsage() {
 	fmt.Fprintf(os.Stderr, "Usage: wallfacer <command> [arguments]\n\n")
 	fmt.Fprintf(os.Stderr, "Commands:\n")
-	fmt.Fprintf(os.Stderr, "  run          start the Kanban server\n")
-	fmt.Fprintf(os.Stderr, "  env          show configuration and env file status\n")
+	fmt.Fprintf(os.Stderr, "  run            start the Kanban server\n")
+	fmt.Fprintf(os.Stderr, "  env            show configuration and env file status\n")
+	fmt.Fprintf(os.Stderr, "  config         print the effective run configuration and where each value came from\n")
+	fmt.Fprintf(os.Stderr, "  migrate-store  import a board's JSON task files into a SQLite database\n")
 	fmt.Fprintf(os.Stderr, "\nRun 'wallfacer <command> -help' for more information on a command.\n")
 }
 
@@ -36,6 +40,10 @@ func main() {
 		runEnvCheck(configDir)
 	case "run":
 		runServer(configDir, os.Args[2:])
+	case "config":
+		runConfigDump(configDir, os.Args[2:])
+	case "migrate-store":
+		runMigrateStore(configDir, os.Args[2:])
 	case "-help", "--help", "-h":
 		printUsage()
 	default:
@@ -87,13 +95,13 @@ func runEnvCheck(configDir string) {
 	oauthToken := vals["CLAUDE_CODE_OAUTH_TOKEN"]
 	apiKey := vals["ANTHROPIC_API_KEY"]
 	switch {
-	case oauthToken != "" && oauthToken != "your-oauth-token-here":
+	case oauthToken != "" && oauthToken != envconfig.PlaceholderOAuthToken:
 		masked := oauthToken[:4] + "..." + oauthToken[len(oauthToken)-4:]
 		if len(oauthToken) <= 8 {
 			masked = strings.Repeat("*", len(oauthToken))
 		}
 		fmt.Printf("[ok] CLAUDE_CODE_OAUTH_TOKEN is set (%s)\n", masked)
-	case apiKey != "":
+	case apiKey != "" && apiKey != envconfig.PlaceholderAPIKey:
 		masked := apiKey[:4] + "..." + apiKey[len(apiKey)-4:]
 		if len(apiKey) <= 8 {
 			masked = strings.Repeat("*", len(apiKey))
@@ -138,8 +146,8 @@ func initConfigDir(configDir, envFile string) {
 
 	if _, err := os.Stat(envFile); os.IsNotExist(err) {
 		content := "# Authentication: set ONE of the two token variables below.\n" +
-			"CLAUDE_CODE_OAUTH_TOKEN=your-oauth-token-here\n" +
-			"# ANTHROPIC_API_KEY=sk-ant-...\n\n" +
+			"CLAUDE_CODE_OAUTH_TOKEN=" + envconfig.PlaceholderOAuthToken + "\n" +
+			"# ANTHROPIC_API_KEY=" + envconfig.PlaceholderAPIKey + "\n\n" +
 			"# Optional: custom Anthropic-compatible API base URL.\n" +
 			"# ANTHROPIC_BASE_URL=https://api.anthropic.com\n\n" +
 			"# Optional: override the model used by Claude Code (e.g. claude-opus-4-5).\n" +
@@ -151,6 +159,22 @@ func initConfigDir(configDir, envFile string) {
 	}
 }
 
+// checkToken exits with instructions when the env file sets neither an
+// OAuth token nor an API key, or only the placeholders initConfigDir writes,
+// since every task would fail to authenticate.
+func checkToken(envFile string) {
+	cfg, err := envconfig.Parse(envFile)
+	if err != nil {
+		logger.Fatal(logger.Main, "read env file", "path", envFile, "error", err)
+	}
+	if cfg.HasToken() {
+		return
+	}
+	logger.Fatal(logger.Main, "no Claude token configured: set CLAUDE_CODE_OAUTH_TOKEN "+
+		"(from 'claude setup-token') or ANTHROPIC_API_KEY in the env file and start again, "+
+		"or pass -skip-token-check to start anyway and set it in Settings", "path", envFile)
+}
+
 func envOrDefault(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -158,6 +182,22 @@ func envOrDefault(key, fallback string) string {
 	return fallback
 }
 
+// envInt is envOrDefault for integer settings; unparsable values fall back.
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+	return fallback
+}
+
+// envFloat is envOrDefault for decimal settings; unparsable values fall back.
+func envFloat(key string, fallback float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return v
+	}
+	return fallback
+}
+
 func openBrowser(url string) {
 	var cmd string
 	switch runtime.GOOS {
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"changkun.de/wallfacer/internal/instructions"
+	"changkun.de/wallfacer/internal/store"
+)
+
+// runMigrateStore imports the JSON board of a workspace set into a SQLite
+// database, so the server can then run with -store=sqlite.
+func runMigrateStore(configDir string, args []string) {
+	fs := flag.NewFlagSet("migrate-store", flag.ExitOnError)
+	dataDir := fs.String("data", envOrDefault("DATA_DIR", filepath.Join(configDir, "data")), "data directory")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: wallfacer migrate-store [-data dir] [workspace ...]\n\n")
+		fmt.Fprintf(os.Stderr, "Import the JSON task store of the board for these workspaces into\n")
+		fmt.Fprintf(os.Stderr, "%s in the same data directory. Run it once, with the server stopped,\n", store.SQLiteFile)
+		fmt.Fprintf(os.Stderr, "then start 'wallfacer run -store=sqlite' with the same workspaces.\n")
+		fmt.Fprintf(os.Stderr, "The JSON files are left in place.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	workspaces := fs.Args()
+	if len(workspaces) == 0 {
+		cwd, err := os.Getwd()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wallfacer: getwd: %v\n", err)
+			os.Exit(1)
+		}
+		workspaces = []string{cwd}
+	}
+	for i, ws := range workspaces {
+		abs, err := filepath.Abs(ws)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wallfacer: resolve workspace %s: %v\n", ws, err)
+			os.Exit(1)
+		}
+		workspaces[i] = abs
+	}
+
+	scopedDataDir := filepath.Join(*dataDir, instructions.Key(workspaces))
+	migrateLegacyData(configDir, *dataDir, scopedDataDir, workspaces)
+	if _, err := os.Stat(scopedDataDir); err != nil {
+		fmt.Fprintf(os.Stderr, "wallfacer: no board for these workspaces: %v\n", err)
+		os.Exit(1)
+	}
+
+	n, err := store.MigrateToSQLite(scopedDataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wallfacer: migrate: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Imported %d tasks into %s\n", n, filepath.Join(scopedDataDir, store.SQLiteFile))
+}
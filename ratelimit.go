@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bucket is the token bucket of one client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a per-client token bucket: each client may make burst
+// requests at once and rps requests per second after that.
+type rateLimiter struct {
+	rps   float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+func newRateLimiter(rps float64) *rateLimiter {
+	return &rateLimiter{
+		rps:     rps,
+		burst:   math.Max(1, math.Ceil(rps)),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow takes a token from client's bucket at now. When the bucket is empty
+// it reports false and how long until the next token.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPrune) > time.Minute {
+		l.prune(now)
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rps)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rps * float64(time.Second))
+	return false, wait
+}
+
+// prune drops the buckets that have refilled completely, which behave the
+// same as a new one. Caller must hold l.mu.
+func (l *rateLimiter) prune(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rps >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastPrune = now
+}
+
+// maxPatchPeek bounds how much of a PATCH body rateLimited reads to find
+// its status; the handler rejects larger bodies anyway.
+const maxPatchPeek = 1 << 20
+
+// rateLimited reports whether the request, with basePath already removed
+// from path, creates tasks or starts task containers: task creation, batch
+// creation, clone and import, PATCHes that move a task to in_progress
+// (which start it), resume, and feedback. Other PATCHes, such as the
+// position updates of a drag on the board, are not limited. It reads a
+// PATCH body to find the status and puts it back for the handler.
+func rateLimited(r *http.Request, path string) bool {
+	switch {
+	case r.Method == http.MethodPost && (path == "/api/tasks" || path == "/api/tasks/batch" || path == "/api/import"):
+		return true
+	case r.Method == http.MethodPatch && strings.HasPrefix(path, "/api/tasks/"):
+		return startsTask(r)
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/api/tasks/"):
+		return strings.HasSuffix(path, "/clone") || strings.HasSuffix(path, "/resume") || strings.HasSuffix(path, "/feedback")
+	}
+	return false
+}
+
+// startsTask reports whether the body of a task PATCH sets its status to
+// in_progress, restoring r.Body so the handler can decode it.
+func startsTask(r *http.Request) bool {
+	if r.Body == nil {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPatchPeek))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return false
+	}
+	var req struct {
+		Status *string `json:"status"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return false
+	}
+	return req.Status != nil && *req.Status == "in_progress"
+}
+
+// rateLimitMiddleware refuses requests that create tasks or start task
+// containers with 429 and a Retry-After header once their client, keyed by
+// remote IP, exceeds rps requests per second. Behind a reverse proxy every
+// request shares the proxy's IP and therefore one bucket. rps <= 0 disables
+// the limit.
+func rateLimitMiddleware(next http.Handler, rps float64, basePath string) http.Handler {
+	if rps <= 0 {
+		return next
+	}
+	limiter := newRateLimiter(rps)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := strings.CutPrefix(r.URL.Path, basePath)
+		if !ok || !rateLimited(r, path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if allowed, wait := limiter.allow(client, time.Now()); !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter(2)
+	now := time.Unix(1000, 0)
+
+	// The burst is the rate rounded up.
+	for i := range 2 {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	ok, wait := l.allow("a", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("allow past the burst = %v, %v; want false, 500ms", ok, wait)
+	}
+	if ok, _ := l.allow("b", now); !ok {
+		t.Fatal("another client shares the first client's bucket")
+	}
+
+	// Half a second refills one token, and no more than the burst.
+	if ok, _ := l.allow("a", now.Add(500*time.Millisecond)); !ok {
+		t.Fatal("request after the refill was refused")
+	}
+	later := now.Add(time.Hour)
+	for i := range 2 {
+		if ok, _ := l.allow("a", later); !ok {
+			t.Fatalf("request %d after a long idle was refused", i+1)
+		}
+	}
+	if ok, _ := l.allow("a", later); ok {
+		t.Fatal("the bucket refilled past the burst")
+	}
+
+	// Buckets that have refilled are pruned.
+	l.allow("c", later.Add(2*time.Minute))
+	if _, ok := l.buckets["b"]; ok {
+		t.Error("refilled bucket was not pruned")
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	tests := []struct {
+		method, path, body string
+		want               bool
+	}{
+		{"POST", "/api/tasks", `{"prompt":"p"}`, true},
+		{"POST", "/api/tasks/batch", `{}`, true},
+		{"POST", "/api/import", `{}`, true},
+		{"POST", "/api/tasks/1/clone", ``, true},
+		{"POST", "/api/tasks/1/resume", ``, true},
+		{"POST", "/api/tasks/1/feedback", `{"message":"m"}`, true},
+		{"PATCH", "/api/tasks/1", `{"status":"in_progress"}`, true},
+		{"PATCH", "/api/tasks/1", `{"status":"in_progress","position":2}`, true},
+		{"PATCH", "/api/tasks/1", `{"position":2}`, false},
+		{"PATCH", "/api/tasks/1", `{"status":"backlog","position":0}`, false},
+		{"PATCH", "/api/tasks/1", `{"prompt":"p"}`, false},
+		{"PATCH", "/api/tasks/1", `not json`, false},
+		{"POST", "/api/tasks/1/archive", ``, false},
+		{"GET", "/api/tasks", ``, false},
+		{"DELETE", "/api/tasks/1", ``, false},
+		{"POST", "/api/git/sync", ``, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if got := rateLimited(r, tt.path); got != tt.want {
+			t.Errorf("rateLimited(%s %s %s) = %v, want %v", tt.method, tt.path, tt.body, got, tt.want)
+		}
+		if body, _ := io.ReadAll(r.Body); string(body) != tt.body {
+			t.Errorf("%s %s: body after rateLimited = %q, want %q", tt.method, tt.path, body, tt.body)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	var bodies []string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusOK)
+	})
+	h := rateLimitMiddleware(next, 1, "/wf")
+	do := func(method, path, body, addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	const start = `{"status":"in_progress"}`
+	if w := do("PATCH", "/wf/api/tasks/1", start, "10.0.0.1:1234"); w.Code != 200 {
+		t.Fatalf("first start returned %d, want 200", w.Code)
+	}
+	if bodies[0] != start {
+		t.Errorf("handler read body %q, want %q", bodies[0], start)
+	}
+	w := do("PATCH", "/wf/api/tasks/2", start, "10.0.0.1:5678")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second start returned %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+
+	// Reordering the board and other clients are not held back.
+	for i := range 5 {
+		if w := do("PATCH", "/wf/api/tasks/1", fmt.Sprintf(`{"position":%d}`, i), "10.0.0.1:1234"); w.Code != 200 {
+			t.Fatalf("position update %d returned %d, want 200", i, w.Code)
+		}
+	}
+	if w := do("PATCH", "/wf/api/tasks/3", start, "10.0.0.2:1234"); w.Code != 200 {
+		t.Fatalf("start from another client returned %d, want 200", w.Code)
+	}
+	// Paths outside basePath are passed through.
+	if w := do("POST", "/api/tasks", `{}`, "10.0.0.1:1234"); w.Code != 200 {
+		t.Fatalf("request outside basePath returned %d, want 200", w.Code)
+	}
+}
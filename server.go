@@ -1,19 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
 	"embed"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"html"
 	fsLib "io/fs"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
+	"changkun.de/wallfacer/internal/gitutil"
 	"changkun.de/wallfacer/internal/handler"
 	"changkun.de/wallfacer/internal/instructions"
 	"changkun.de/wallfacer/internal/logger"
@@ -27,16 +35,200 @@ const containerPollInterval = 5 * time.Second
 //go:embed ui
 var uiFiles embed.FS
 
-func runServer(configDir string, args []string) {
-	fs := flag.NewFlagSet("run", flag.ExitOnError)
+// runFlags holds the flags of `wallfacer run`, shared with `wallfacer config`
+// so both resolve configuration the same way.
+type runFlags struct {
+	fs *flag.FlagSet
+
+	logFormat         *string
+	addr              *string
+	dataDir           *string
+	storeBackend      *string
+	containerCmd      *string
+	envFile           *string
+	noBrowser         *bool
+	skipTokenCheck    *bool
+	syncRemote        *bool
+	autoPush          *bool
+	mergeStrategy     *string
+	rebaseConflict    *string
+	resolveMode       *string
+	rebaseRetries     *int
+	retentionDays     *int
+	promptPrefix      *string
+	promptSuffix      *string
+	worktreesNearRepo *bool
+	noWorktree        *bool
+	branchTemplate    *string
+	commitMsgMode     *string
+	commitMsgTemplate *string
+	skipSubmodules    *bool
+	lfsEnabled        *string
+	webhookURL        *string
+	webhookDiffStat   *bool
+	webhookKind       *string
+	webhookEvents     *string
+	maxTurnOutput     *int64
+	maxWorktreesDisk  *int64
+	primaryWorkspace  *string
+	emptyStopReason   *string
+	autoExtendStep    *time.Duration
+	autoExtendMax     *time.Duration
+	turnTimeout       *time.Duration
+	maxHourlySpend    *float64
+	sandboxImage      *string
+	sandboxImages     *string
+	memLimit          *string
+	cpuLimit          *string
+	networkMode       *string
+	refs              *listFlag
+	basePath          *string
+	apiKey            *string
+	rateLimitRPS      *float64
+	autoStartDeps     *bool
+	maxConcurrent     *int
+	autopilot         *bool
+	models            *string
+	dailyCostLimit    *float64
+}
+
+// runFlagEnv maps each run flag that reads a default from the environment
+// to its environment variable.
+var runFlagEnv = map[string]string{
+	"log-format":                "LOG_FORMAT",
+	"addr":                      "ADDR",
+	"data":                      "DATA_DIR",
+	"store":                     "STORE_BACKEND",
+	"skip-submodules":           "SKIP_SUBMODULES",
+	"lfs-enabled":               "LFS_ENABLED",
+	"branch-template":           "BRANCH_TEMPLATE",
+	"merge-strategy":            "MERGE_STRATEGY",
+	"rebase-conflict-strategy":  "REBASE_CONFLICT_STRATEGY",
+	"resolve-mode":              "RESOLVE_MODE",
+	"rebase-retries":            "REBASE_RETRIES",
+	"retention-days":            "RETENTION_DAYS",
+	"container":                 "CONTAINER_CMD",
+	"env-file":                  "ENV_FILE",
+	"prompt-prefix":             "PROMPT_PREFIX",
+	"prompt-suffix":             "PROMPT_SUFFIX",
+	"commit-message-mode":       "COMMIT_MESSAGE_MODE",
+	"commit-message-template":   "COMMIT_MESSAGE_TEMPLATE",
+	"webhook-url":               "WEBHOOK_URL",
+	"webhook-include-diff-stat": "WEBHOOK_INCLUDE_DIFF_STAT",
+	"webhook-kind":              "WEBHOOK_KIND",
+	"webhook-events":            "WEBHOOK_EVENTS",
+	"primary-workspace":         "PRIMARY_WORKSPACE",
+	"empty-stop-reason":         "EMPTY_STOP_REASON",
+	"sandbox-image":             "SANDBOX_IMAGE",
+	"sandbox-images":            "SANDBOX_IMAGES",
+	"mem-limit":                 "MEM_LIMIT",
+	"cpu-limit":                 "CPU_LIMIT",
+	"network-mode":              "NETWORK_MODE",
+	"ref":                       "REFERENCE_DIRS",
+	"base-path":                 "BASE_PATH",
+	"max-concurrent":            "MAX_CONCURRENT",
+	"models":                    "MODELS",
+	"daily-cost-limit":          "DAILY_COST_LIMIT",
+	"api-key":                   "API_KEY",
+	"rate-limit-rps":            "RATE_LIMIT_RPS",
+}
+
+// newRunFlags defines the `wallfacer run` flags on a new flag set. Defaults
+// come from environment variables where a flag has one (see runFlagEnv).
+func newRunFlags(name, configDir string) *runFlags {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	f := &runFlags{fs: fs}
+	f.logFormat = fs.String("log-format", envOrDefault("LOG_FORMAT", "text"), `log output format: "text" or "json"`)
+	f.addr = fs.String("addr", envOrDefault("ADDR", "127.0.0.1:8080"), `listen address: host:port, or "unix:/path/to.sock" for a Unix domain socket only the current user can connect to`)
+	f.dataDir = fs.String("data", envOrDefault("DATA_DIR", filepath.Join(configDir, "data")), "data directory")
+	f.storeBackend = fs.String("store", envOrDefault("STORE_BACKEND", "json"), `task persistence format, both kept in memory: "json" (one directory of JSON files per task) or "sqlite" (wallfacer.db in the data directory; import an existing board with 'wallfacer migrate-store')`)
+	f.containerCmd = fs.String("container", envOrDefault("CONTAINER_CMD", "docker"), "container runtime command")
+	f.envFile = fs.String("env-file", envOrDefault("ENV_FILE", filepath.Join(configDir, ".env")), "env file for container (Claude token)")
+	f.noBrowser = fs.Bool("no-browser", false, "do not open browser on start")
+	f.skipTokenCheck = fs.Bool("skip-token-check", false, "start even when the env file sets no Claude token, or only the placeholder (set it later in Settings)")
+	f.syncRemote = fs.Bool("sync-remote-before-merge", false, "fast-forward the default branch from origin before merging each task")
+	f.autoPush = fs.Bool("auto-push", false, "push the default branch to origin after a task is merged into it")
+	f.mergeStrategy = fs.String("merge-strategy", envOrDefault("MERGE_STRATEGY", store.MergeStrategyFF), `how a task lands unless it sets merge_strategy: "ff" (fast-forward the target branch), "merge" (explicit merge commit per task), "squash" (one commit per task, then fast-forward), or "pull-request" (push the task branch and open a pull request)`)
+	f.rebaseConflict = fs.String("rebase-conflict-strategy", envOrDefault("REBASE_CONFLICT_STRATEGY", runner.RebaseConflictResolver), `how a conflicting rebase of a task branch is settled: "resolver" (Claude resolver container), "theirs" or "ours" (git -X option first, resolver only for what remains), or "abort" (fail the commit)`)
+	f.resolveMode = fs.String("resolve-mode", envOrDefault("RESOLVE_MODE", runner.ResolveAuto), `who resolves rebase conflicts the strategy leaves: "auto" (as -rebase-conflict-strategy says) or "manual" (pause the task in "conflict" with the rebase left in its worktree until POST /api/tasks/{id}/continue-rebase)`)
+	f.rebaseRetries = fs.Int("rebase-retries", envInt("REBASE_RETRIES", runner.DefaultRebaseRetries), "how many times a conflicting rebase is retried, each after a conflict resolver run (0 = fail on the first conflict)")
+	f.promptPrefix = fs.String("prompt-prefix", envOrDefault("PROMPT_PREFIX", ""), "text prepended to every prompt sent to a task sandbox")
+	f.promptSuffix = fs.String("prompt-suffix", envOrDefault("PROMPT_SUFFIX", ""), "text appended to every prompt sent to a task sandbox")
+	f.worktreesNearRepo = fs.Bool("worktrees-near-repo", false, "create task worktrees in .wallfacer-worktrees next to each workspace (same filesystem) instead of the central worktrees dir")
+	f.noWorktree = fs.Bool("no-worktree", false, "run tasks directly in the workspaces and commit in place (one active task at a time)")
+	f.branchTemplate = fs.String("branch-template", envOrDefault("BRANCH_TEMPLATE", runner.DefaultBranchTemplate), "name of task branches; {short_id}, {id} and {title-slug} are replaced per task, and -{short_id} is appended when neither ID appears")
+	f.commitMsgMode = fs.String("commit-message-mode", envOrDefault("COMMIT_MESSAGE_MODE", runner.CommitMessageClaude), `how task commit messages are written: "claude" (generated in a one-shot sandbox, falling back to -commit-message-template), "template" (rendered locally), or "prompt" (first line of the task prompt)`)
+	f.commitMsgTemplate = fs.String("commit-message-template", envOrDefault("COMMIT_MESSAGE_TEMPLATE", runner.DefaultCommitMessageTemplate), "Go text/template for commit messages; fields: {{.task_id}}, {{.title}}, {{.prompt_first_line}}, {{.diff_stat}}")
+	f.skipSubmodules = fs.Bool("skip-submodules", envOrDefault("SKIP_SUBMODULES", "") == "true", "leave submodules of task worktrees uninitialized instead of running git submodule update --init --recursive")
+	f.lfsEnabled = fs.String("lfs-enabled", envOrDefault("LFS_ENABLED", runner.LFSAuto), `pull Git LFS objects into task worktrees: "auto" (repos that use LFS, when git-lfs is installed), "true" (require git-lfs), or "false"`)
+	f.webhookURL = fs.String("webhook-url", envOrDefault("WEBHOOK_URL", ""), "URL that receives a JSON POST when a task reaches one of -webhook-events")
+	f.webhookDiffStat = fs.Bool("webhook-include-diff-stat", envOrDefault("WEBHOOK_INCLUDE_DIFF_STAT", "") == "true", "include files changed, insertions/deletions and commit hashes in the done webhook payload")
+	f.webhookKind = fs.String("webhook-kind", envOrDefault("WEBHOOK_KIND", runner.WebhookKindGeneric), `webhook payload format: "generic" (JSON summary) or "slack" (Slack message blocks)`)
+	f.webhookEvents = fs.String("webhook-events", envOrDefault("WEBHOOK_EVENTS", strings.Join(runner.DefaultWebhookEvents, ",")), "comma-separated task statuses that trigger the webhook: done, failed, waiting")
+	f.maxTurnOutput = fs.Int64("max-turn-output", 0, "cap in bytes on the stored stdout/stderr of each turn; longer output keeps its head and tail (0 = unlimited)")
+	f.maxWorktreesDisk = fs.Int64("max-worktrees-disk", 0, "cap in bytes on total task worktree size; tasks that would exceed it wait in the backlog (0 = unlimited)")
+	f.primaryWorkspace = fs.String("primary-workspace", envOrDefault("PRIMARY_WORKSPACE", ""), "workspace that leads generated commit messages in multi-repo tasks (default: first workspace)")
+	f.emptyStopReason = fs.String("empty-stop-reason", envOrDefault("EMPTY_STOP_REASON", runner.EmptyStopReasonWait), `what to do when a turn ends with an empty stop_reason: "wait", "complete" (commit as if end_turn), or "fail"`)
+	f.autoExtendStep = fs.Duration("auto-extend-step", 15*time.Minute, "how far a productive turn near the deadline extends the timeout of tasks with auto_extend set")
+	f.autoExtendMax = fs.Duration("auto-extend-max", 4*time.Hour, "hard limit on the total run time of tasks with auto_extend set")
+	f.turnTimeout = fs.Duration("turn-timeout", 0, "bound on a single turn inside the task timeout; a turn that runs past it is killed and retried once, then the task fails (0 = no per-turn bound)")
+	f.maxHourlySpend = fs.Float64("max-hourly-spend", 0, "cap on total USD spent across all tasks per rolling hour; new turns wait while exceeded (0 = unlimited)")
+	f.dailyCostLimit = fs.Float64("daily-cost-limit", envFloat("DAILY_COST_LIMIT", 0), "cap on total USD spent across all tasks per day (since local midnight); tasks queue until the next day when reached (0 = unlimited)")
+	f.sandboxImage = fs.String("sandbox-image", envOrDefault("SANDBOX_IMAGE", ""), "image task sandboxes are created from (default: the docker sandbox claude image)")
+	f.sandboxImages = fs.String("sandbox-images", envOrDefault("SANDBOX_IMAGES", ""), "comma-separated images tasks may select with sandbox_image, in addition to -sandbox-image")
+	f.memLimit = fs.String("mem-limit", envOrDefault("MEM_LIMIT", ""), `memory limit of each sandbox in docker's --memory format, e.g. "4g" (default: none)`)
+	f.cpuLimit = fs.String("cpu-limit", envOrDefault("CPU_LIMIT", ""), `CPU limit of each sandbox in docker's --cpus format, e.g. "1.5" (default: none)`)
+	f.networkMode = fs.String("network-mode", envOrDefault("NETWORK_MODE", runner.NetworkHost), `network of each sandbox: "host", "bridge", or "none" (no network; Claude cannot reach the API, for offline tooling only)`)
+	f.refs = &listFlag{values: splitList(envOrDefault("REFERENCE_DIRS", ""))}
+	fs.Var(f.refs, "ref", "directory mounted read-only into every task sandbox for reference; repeatable (no worktree, never committed)")
+	f.models = fs.String("models", envOrDefault("MODELS", ""), "comma-separated Claude models tasks may select with model; the env-file model is always allowed (default: any model)")
+	f.maxConcurrent = fs.Int("max-concurrent", envInt("MAX_CONCURRENT", 0), "maximum number of tasks running at once; further tasks wait as queued (0 = unlimited)")
+	f.retentionDays = fs.Int("retention-days", envInt("RETENTION_DAYS", 0), "delete archived tasks, with their worktrees and data, after this many days without an update (0 = keep forever)")
+	f.autopilot = fs.Bool("autopilot", false, "start backlog tasks automatically, top of the backlog first (can also be toggled at runtime; the last setting persists)")
+	f.autoStartDeps = fs.Bool("auto-start-dependents", false, "start a backlog task automatically when the last task it depends on is done")
+	f.basePath = fs.String("base-path", envOrDefault("BASE_PATH", ""), `URL path prefix to serve the UI and API under, e.g. "/wallfacer" behind a reverse proxy (default: root)`)
+	f.apiKey = fs.String("api-key", envOrDefault("API_KEY", ""), "require this key as an Authorization: Bearer token (or the login cookie) on every /api request; prefer the API_KEY variable over the flag, which other users can see in the process list (default: no authentication)")
+	f.rateLimitRPS = fs.Float64("rate-limit-rps", envFloat("RATE_LIMIT_RPS", 0), "requests per second each client IP may make to the endpoints that create or start tasks, with a burst of the same size; excess requests get 429 (0 = unlimited)")
+	return f
+}
+
+// splitList splits a comma-separated flag value, dropping blank entries.
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// listFlag is a repeatable flag collecting one value per occurrence;
+// comma-separated values add several at once. The first occurrence
+// replaces the default taken from the environment.
+type listFlag struct {
+	values []string
+	set    bool
+}
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(l.values, ",")
+}
 
-	logFormat := fs.String("log-format", envOrDefault("LOG_FORMAT", "text"), `log output format: "text" or "json"`)
-	addr := fs.String("addr", envOrDefault("ADDR", "127.0.0.1:8080"), "listen address")
-	dataDir := fs.String("data", envOrDefault("DATA_DIR", filepath.Join(configDir, "data")), "data directory")
-	containerCmd := fs.String("container", envOrDefault("CONTAINER_CMD", "docker"), "container runtime command")
-	envFile := fs.String("env-file", envOrDefault("ENV_FILE", filepath.Join(configDir, ".env")), "env file for container (Claude token)")
-	noBrowser := fs.Bool("no-browser", false, "do not open browser on start")
+func (l *listFlag) Set(v string) error {
+	if !l.set {
+		l.values, l.set = nil, true
+	}
+	l.values = append(l.values, splitList(v)...)
+	return nil
+}
 
+func runServer(configDir string, args []string) {
+	f := newRunFlags("run", configDir)
+	fs := f.fs
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: wallfacer run [flags] [workspace ...]\n\n")
 		fmt.Fprintf(os.Stderr, "Start the Kanban server and open the web UI.\n\n")
@@ -48,10 +240,13 @@ func runServer(configDir string, args []string) {
 	fs.Parse(args)
 
 	// Re-initialize loggers with the format chosen by the user.
-	logger.Init(*logFormat)
+	logger.Init(*f.logFormat)
 
 	// Auto-initialize config directory and .env template.
-	initConfigDir(configDir, *envFile)
+	initConfigDir(configDir, *f.envFile)
+	if !*f.skipTokenCheck {
+		checkToken(*f.envFile)
+	}
 
 	// Positional args are workspace directories.
 	workspaces := fs.Args()
@@ -78,16 +273,106 @@ func runServer(configDir string, args []string) {
 		}
 		workspaces[i] = abs
 	}
+	refs := make([]string, len(f.refs.values))
+	for i, ref := range f.refs.values {
+		abs, err := filepath.Abs(ref)
+		if err != nil {
+			logger.Fatal(logger.Main, "resolve -ref", "path", ref, "error", err)
+		}
+		if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+			logger.Fatal(logger.Main, "-ref is not a directory", "path", abs)
+		}
+		if slices.Contains(workspaces, abs) {
+			logger.Fatal(logger.Main, "-ref is also a workspace", "path", abs)
+		}
+		refs[i] = abs
+	}
+	if *f.storeBackend != "json" && *f.storeBackend != "sqlite" {
+		logger.Fatal(logger.Main, "invalid -store", "value", *f.storeBackend)
+	}
+	if !store.ValidMergeStrategy(*f.mergeStrategy) {
+		logger.Fatal(logger.Main, "invalid -merge-strategy", "value", *f.mergeStrategy)
+	}
+	if !runner.ValidMemoryLimit(*f.memLimit) {
+		logger.Fatal(logger.Main, "invalid -mem-limit", "value", *f.memLimit)
+	}
+	if !runner.ValidCPULimit(*f.cpuLimit) {
+		logger.Fatal(logger.Main, "invalid -cpu-limit", "value", *f.cpuLimit)
+	}
+	switch *f.networkMode {
+	case runner.NetworkHost, runner.NetworkBridge, runner.NetworkNone:
+	default:
+		logger.Fatal(logger.Main, "invalid -network-mode", "value", *f.networkMode)
+	}
+	switch *f.rebaseConflict {
+	case runner.RebaseConflictResolver, runner.RebaseConflictTheirs, runner.RebaseConflictOurs, runner.RebaseConflictAbort:
+	default:
+		logger.Fatal(logger.Main, "invalid -rebase-conflict-strategy", "value", *f.rebaseConflict)
+	}
+	switch *f.resolveMode {
+	case runner.ResolveAuto, runner.ResolveManual:
+	default:
+		logger.Fatal(logger.Main, "invalid -resolve-mode", "value", *f.resolveMode)
+	}
+	if *f.retentionDays < 0 {
+		logger.Fatal(logger.Main, "invalid -retention-days", "value", *f.retentionDays)
+	}
+	if *f.rebaseRetries < 0 {
+		logger.Fatal(logger.Main, "invalid -rebase-retries", "value", *f.rebaseRetries)
+	}
+	switch *f.lfsEnabled {
+	case runner.LFSAuto, runner.LFSOff:
+	case runner.LFSOn:
+		if !gitutil.LFSAvailable() {
+			logger.Fatal(logger.Main, "-lfs-enabled=true but git-lfs is not installed")
+		}
+	default:
+		logger.Fatal(logger.Main, "invalid -lfs-enabled", "value", *f.lfsEnabled)
+	}
+	switch *f.webhookKind {
+	case runner.WebhookKindGeneric, runner.WebhookKindSlack:
+	default:
+		logger.Fatal(logger.Main, "invalid -webhook-kind", "value", *f.webhookKind)
+	}
+	for _, ev := range splitList(*f.webhookEvents) {
+		if !slices.Contains(runner.WebhookEventStatuses, ev) {
+			logger.Fatal(logger.Main, "invalid -webhook-events", "value", ev)
+		}
+	}
+	if err := runner.ValidateBranchTemplate(*f.branchTemplate); err != nil {
+		logger.Fatal(logger.Main, "invalid -branch-template", "error", err)
+	}
+	switch *f.commitMsgMode {
+	case runner.CommitMessageClaude, runner.CommitMessageTemplate, runner.CommitMessagePrompt:
+	default:
+		logger.Fatal(logger.Main, "invalid -commit-message-mode", "value", *f.commitMsgMode)
+	}
+	if err := runner.ValidateCommitMessageTemplate(*f.commitMsgTemplate); err != nil {
+		logger.Fatal(logger.Main, "invalid -commit-message-template", "error", err)
+	}
+	switch *f.emptyStopReason {
+	case runner.EmptyStopReasonWait, runner.EmptyStopReasonComplete, runner.EmptyStopReasonFail:
+	default:
+		logger.Fatal(logger.Main, "invalid -empty-stop-reason", "value", *f.emptyStopReason)
+	}
+	if *f.primaryWorkspace != "" {
+		abs, err := filepath.Abs(*f.primaryWorkspace)
+		if err != nil || !slices.Contains(workspaces, abs) {
+			logger.Fatal(logger.Main, "primary workspace is not one of the workspaces", "path", *f.primaryWorkspace)
+		}
+		*f.primaryWorkspace = abs
+	}
 
 	// Scope the data directory to the specific workspace combination.
-	scopedDataDir := filepath.Join(*dataDir, instructions.Key(workspaces))
+	scopedDataDir := filepath.Join(*f.dataDir, instructions.Key(workspaces))
+	migrateLegacyData(configDir, *f.dataDir, scopedDataDir, workspaces)
 
-	s, err := store.NewStore(scopedDataDir)
+	s, err := openStore(*f.storeBackend, scopedDataDir)
 	if err != nil {
 		logger.Fatal(logger.Main, "store", "error", err)
 	}
 	defer s.Close()
-	logger.Main.Info("store loaded", "path", scopedDataDir)
+	logger.Main.Info("store loaded", "path", scopedDataDir, "backend", *f.storeBackend)
 
 	worktreesDir := filepath.Join(configDir, "worktrees")
 	if err := os.MkdirAll(worktreesDir, 0700); err != nil {
@@ -102,65 +387,232 @@ func runServer(configDir string, args []string) {
 	}
 
 	r := runner.NewRunner(s, runner.RunnerConfig{
-		Command:          *containerCmd,
-		EnvFile:          *envFile,
+		Command:          *f.containerCmd,
+		EnvFile:          *f.envFile,
 		Workspaces:       strings.Join(workspaces, " "),
 		WorktreesDir:     worktreesDir,
 		InstructionsPath: instructionsPath,
+
+		SyncRemoteBeforeMerge: *f.syncRemote,
+		AutoPush:              *f.autoPush,
+		MergeStrategy:         *f.mergeStrategy,
+
+		RebaseConflictStrategy: *f.rebaseConflict,
+		ResolveMode:            *f.resolveMode,
+		RebaseRetries:          *f.rebaseRetries,
+
+		MaxHourlySpendUSD: *f.maxHourlySpend,
+		DailyCostLimitUSD: *f.dailyCostLimit,
+		PromptPrefix:      *f.promptPrefix,
+		PromptSuffix:      *f.promptSuffix,
+		WorktreesNearRepo: *f.worktreesNearRepo,
+		NoWorktree:        *f.noWorktree,
+		BranchTemplate:    *f.branchTemplate,
+
+		CommitMessageMode:     *f.commitMsgMode,
+		CommitMessageTemplate: *f.commitMsgTemplate,
+
+		GlobalInstructionsPath: instructions.GlobalFilePath(configDir),
+		WebhookURL:             *f.webhookURL,
+		WebhookIncludeDiffStat: *f.webhookDiffStat,
+		WebhookKind:            *f.webhookKind,
+		WebhookEvents:          splitList(*f.webhookEvents),
+		MaxWorktreesDiskBytes:  *f.maxWorktreesDisk,
+		PrimaryWorkspace:       *f.primaryWorkspace,
+		EmptyStopReasonPolicy:  *f.emptyStopReason,
+		MaxTurnOutputBytes:     *f.maxTurnOutput,
+		AutoExtendStep:         *f.autoExtendStep,
+		AutoExtendMax:          *f.autoExtendMax,
+		TurnTimeout:            *f.turnTimeout,
+		SandboxImage:           *f.sandboxImage,
+		SandboxImageAllowlist:  splitList(*f.sandboxImages),
+		MemoryLimit:            *f.memLimit,
+		CPULimit:               *f.cpuLimit,
+		NetworkMode:            *f.networkMode,
+		ReadOnlyMounts:         refs,
+		AutoStartDependents:    *f.autoStartDeps,
+		MaxConcurrent:          *f.maxConcurrent,
+		ArchivedRetention:      time.Duration(*f.retentionDays) * 24 * time.Hour,
+		ModelAllowlist:         splitList(*f.models),
 	})
 
 	r.PruneOrphanedWorktrees(s)
 	recoverOrphanedTasks(s, r)
 
+	if *f.autopilot {
+		if err := r.SetAutopilot(true); err != nil {
+			logger.Main.Warn("enable autopilot", "error", err)
+		}
+	}
+	go r.RunAutopilot(context.Background())
+	go r.RunScheduler(context.Background())
+	go r.RunDeadlineReaper(context.Background())
+	go r.RunRetentionSweeper(context.Background())
+
 	logger.Main.Info("workspaces", "paths", strings.Join(workspaces, ", "))
 
 	h := handler.NewHandler(s, r, configDir, workspaces)
 
-	mux := buildMux(h, r)
-
-	host, _, _ := net.SplitHostPort(*addr)
-	ln, err := net.Listen("tcp", *addr)
+	basePath, err := normalizeBasePath(*f.basePath)
 	if err != nil {
-		logger.Main.Warn("requested address unavailable, finding free port", "addr", *addr, "error", err)
-		ln, err = net.Listen("tcp", net.JoinHostPort(host, "0"))
+		logger.Fatal(logger.Main, "base path", "error", err)
+	}
+	mux := buildMux(h, r, basePath)
+	if *f.apiKey != "" {
+		logger.Main.Info("API key authentication enabled")
+	}
+	if *f.rateLimitRPS > 0 {
+		logger.Main.Info("rate limiting task endpoints", "rps", *f.rateLimitRPS)
+	}
+
+	var ln net.Listener
+	if sockPath, ok := strings.CutPrefix(*f.addr, "unix:"); ok {
+		// No TCP port to fall back from or open a browser on; the reverse
+		// proxy in front is the way in.
+		if ln, err = listenUnix(sockPath); err != nil {
+			logger.Fatal(logger.Main, "listen", "addr", *f.addr, "error", err)
+		}
+	} else {
+		host, _, _ := net.SplitHostPort(*f.addr)
+		ln, err = net.Listen("tcp", *f.addr)
 		if err != nil {
-			logger.Fatal(logger.Main, "listen", "error", err)
+			logger.Main.Warn("requested address unavailable, finding free port", "addr", *f.addr, "error", err)
+			ln, err = net.Listen("tcp", net.JoinHostPort(host, "0"))
+			if err != nil {
+				logger.Fatal(logger.Main, "listen", "error", err)
+			}
 		}
-	}
 
-	actualPort := ln.Addr().(*net.TCPAddr).Port
-	if !*noBrowser {
-		browserHost := host
-		if browserHost == "" {
-			browserHost = "localhost"
+		actualPort := ln.Addr().(*net.TCPAddr).Port
+		if !*f.noBrowser {
+			browserHost := host
+			if browserHost == "" {
+				browserHost = "localhost"
+			}
+			go openBrowser(fmt.Sprintf("http://%s:%d%s/", browserHost, actualPort, basePath))
 		}
-		go openBrowser(fmt.Sprintf("http://%s:%d", browserHost, actualPort))
 	}
 
-	logger.Main.Info("listening", "addr", ln.Addr().String())
+	logger.Main.Info("listening", "addr", ln.Addr().String(), "base_path", basePath+"/")
 	srv := &http.Server{
-		Handler:           securityMiddleware(loggingMiddleware(mux)),
+		Handler:           securityMiddleware(rateLimitMiddleware(loggingMiddleware(mux), *f.rateLimitRPS, basePath), *f.apiKey, basePath),
 		ReadHeaderTimeout: 10 * time.Second,
 		IdleTimeout:       120 * time.Second,
 	}
+	// The store is loaded and the listener bound, so connections queue in
+	// the backlog until Serve picks them up; tell systemd we are ready.
+	if ok, err := sdNotify("READY=1"); err != nil {
+		logger.Main.Warn("sd_notify", "error", err)
+	} else if ok {
+		logger.Main.Info("notified systemd readiness")
+	}
 	if err := srv.Serve(ln); err != nil {
 		logger.Fatal(logger.Main, "server", "error", err)
 	}
 }
 
-// buildMux constructs the HTTP request router.
-func buildMux(h *handler.Handler, _ *runner.Runner) *http.ServeMux {
+// listenUnix listens on a Unix domain socket at sockPath that only the current
+// user can connect to. A socket file left behind by an earlier run is
+// removed first; a live socket, or any other file, at sockPath is an error.
+func listenUnix(sockPath string) (net.Listener, error) {
+	if sockPath == "" {
+		return nil, errors.New("empty unix socket path")
+	}
+	if info, err := os.Lstat(sockPath); err == nil {
+		if info.Mode().Type() != fsLib.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", sockPath)
+		}
+		if c, err := net.Dial("unix", sockPath); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use by another process", sockPath)
+		}
+		if err := os.Remove(sockPath); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+		logger.Main.Info("removed stale socket", "path", sockPath)
+	}
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(sockPath, 0o600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("restrict socket permissions: %w", err)
+	}
+	return ln, nil
+}
+
+// migrateLegacyData moves the board and the workspace instructions stored
+// under the previous workspace key to the current one, so repeating a
+// workspace argument does not make existing tasks and instructions
+// disappear. Each is left alone when its new location already exists.
+func migrateLegacyData(configDir, dataDir, scopedDataDir string, workspaces []string) {
+	key := instructions.LegacyKey(workspaces)
+	if key == "" {
+		return
+	}
+	migrateLegacyPath(filepath.Join(dataDir, key), scopedDataDir)
+	migrateLegacyPath(filepath.Join(configDir, "instructions", key+".md"), instructions.FilePath(configDir, workspaces))
+}
+
+// migrateLegacyPath renames legacy to current when legacy exists and
+// current does not.
+func migrateLegacyPath(legacy, current string) {
+	if _, err := os.Stat(current); err == nil {
+		return
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		return
+	}
+	if err := os.Rename(legacy, current); err != nil {
+		logger.Main.Warn("migrate legacy path", "from", legacy, "to", current, "error", err)
+		return
+	}
+	logger.Main.Info("migrated legacy path", "from", legacy, "to", current)
+}
+
+// openStore opens the board in dir with the named storage backend.
+func openStore(backend, dir string) (*store.Store, error) {
+	if backend == "sqlite" {
+		return store.NewSQLiteStore(dir)
+	}
+	return store.NewStore(dir)
+}
+
+// buildMux constructs the HTTP request router. With a non-empty basePath
+// every route is served under that prefix instead of the root.
+func buildMux(h *handler.Handler, _ *runner.Runner, basePath string) http.Handler {
 	mux := http.NewServeMux()
 
-	// Static files (Kanban UI).
+	// Static files (Kanban UI). The UI loads its assets and calls the API
+	// through relative URLs resolved against the <base> tag, so the index
+	// page is served with the tag pointing at the base path.
 	uiFS, _ := fsLib.Sub(uiFiles, "ui")
 	mux.Handle("GET /", http.FileServer(http.FS(uiFS)))
+	mux.HandleFunc("GET /{$}", serveIndex(uiFS, basePath))
 
 	// Container monitoring.
 	mux.HandleFunc("GET /api/containers", h.GetContainers)
+	mux.HandleFunc("GET /api/runner/status", h.GetRunnerStatus)
+	mux.HandleFunc("POST /api/runner/autopilot", h.SetAutopilot)
+	mux.HandleFunc("GET /api/runner/queue", h.GetRunQueue)
+	mux.HandleFunc("POST /api/runner/queue", h.SetRunQueue)
 
 	// Configuration & instructions.
 	mux.HandleFunc("GET /api/config", h.GetConfig)
+	mux.HandleFunc("GET /api/stats", h.GetStats)
+	mux.HandleFunc("GET /metrics", h.Metrics)
+	mux.HandleFunc("GET /api/health", h.Health)
+	mux.HandleFunc("GET /api/usage", h.GetUsage)
+	mux.HandleFunc("GET /api/usage/today", h.GetUsageToday)
+	mux.HandleFunc("POST /api/admin/renormalize-positions", h.RenormalizePositions)
+	mux.HandleFunc("POST /api/admin/reset-claude-config", h.ResetClaudeConfig)
+	mux.HandleFunc("GET /api/export", h.ExportTasks)
+	mux.HandleFunc("POST /api/import", h.ImportTasks)
+	mux.HandleFunc("GET /api/templates", h.ListTemplates)
+	mux.HandleFunc("POST /api/templates", h.SaveTemplate)
+	mux.HandleFunc("DELETE /api/templates/{name}", h.DeleteTemplate)
+	mux.HandleFunc("GET /api/schedules", h.ListSchedules)
 	mux.HandleFunc("GET /api/env", h.GetEnvConfig)
 	mux.HandleFunc("PUT /api/env", h.UpdateEnvConfig)
 	mux.HandleFunc("GET /api/instructions", h.GetInstructions)
@@ -172,11 +624,18 @@ func buildMux(h *handler.Handler, _ *runner.Runner) *http.ServeMux {
 	mux.HandleFunc("GET /api/git/stream", h.GitStatusStream)
 	mux.HandleFunc("POST /api/git/push", h.GitPush)
 	mux.HandleFunc("POST /api/git/sync", h.GitSyncWorkspace)
+	mux.HandleFunc("GET /api/git/blame", h.GitBlame)
+	mux.HandleFunc("GET /api/git/patches", h.GitPatches)
 
 	// Task collection.
 	mux.HandleFunc("GET /api/tasks", h.ListTasks)
 	mux.HandleFunc("GET /api/tasks/stream", h.StreamTasks)
+	mux.HandleFunc("GET /api/ws", h.StreamWS)
+	mux.HandleFunc("GET /api/tasks/search", h.SearchTasks)
 	mux.HandleFunc("POST /api/tasks", h.CreateTask)
+	mux.HandleFunc("POST /api/tasks/preview", h.PreviewTask)
+	mux.HandleFunc("POST /api/tasks/batch", h.BatchCreateTasks)
+	mux.HandleFunc("POST /api/tasks/bulk", h.BulkTasks)
 	mux.HandleFunc("POST /api/tasks/generate-titles", h.GenerateMissingTitles)
 
 	// Task instance routes (require UUID parsing).
@@ -194,15 +653,27 @@ func buildMux(h *handler.Handler, _ *runner.Runner) *http.ServeMux {
 	mux.HandleFunc("PATCH /api/tasks/{id}", withID(h.UpdateTask))
 	mux.HandleFunc("DELETE /api/tasks/{id}", withID(h.DeleteTask))
 	mux.HandleFunc("GET /api/tasks/{id}/events", withID(h.GetEvents))
+	mux.HandleFunc("GET /api/tasks/{id}/events/stream", withID(h.StreamEvents))
+	mux.HandleFunc("GET /api/tasks/{id}/session", withID(h.GetSession))
+	mux.HandleFunc("GET /api/tasks/{id}/command", withID(h.GetCommand))
+	mux.HandleFunc("POST /api/tasks/{id}/compact", withID(h.CompactTask))
 	mux.HandleFunc("POST /api/tasks/{id}/feedback", withID(h.SubmitFeedback))
 	mux.HandleFunc("POST /api/tasks/{id}/done", withID(h.CompleteTask))
 	mux.HandleFunc("POST /api/tasks/{id}/cancel", withID(h.CancelTask))
 	mux.HandleFunc("POST /api/tasks/{id}/resume", withID(h.ResumeTask))
+	mux.HandleFunc("POST /api/tasks/{id}/clone", withID(h.CloneTask))
 	mux.HandleFunc("POST /api/tasks/{id}/archive", withID(h.ArchiveTask))
 	mux.HandleFunc("POST /api/tasks/{id}/unarchive", withID(h.UnarchiveTask))
+	mux.HandleFunc("POST /api/tasks/{id}/review/toggle", withID(h.ToggleReviewTask))
+	mux.HandleFunc("POST /api/tasks/{id}/title/generate", withID(h.GenerateTaskTitle))
 	mux.HandleFunc("POST /api/tasks/{id}/sync", withID(h.SyncTask))
 	mux.HandleFunc("GET /api/tasks/{id}/diff", withID(h.TaskDiff))
+	mux.HandleFunc("GET /api/tasks/{id}/diff/stream", withID(h.TaskDiffStream))
+	mux.HandleFunc("POST /api/tasks/{id}/checkpoint", withID(h.CheckpointTask))
+	mux.HandleFunc("POST /api/tasks/{id}/diagnose", withID(h.DiagnoseTask))
+	mux.HandleFunc("POST /api/tasks/{id}/continue-rebase", withID(h.ContinueRebase))
 	mux.HandleFunc("GET /api/tasks/{id}/logs", withID(h.StreamLogs))
+	mux.HandleFunc("GET /api/tasks/{id}/logs/download", withID(h.DownloadLogs))
 	mux.HandleFunc("GET /api/tasks/{id}/outputs/{filename}", func(w http.ResponseWriter, r *http.Request) {
 		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
@@ -223,7 +694,45 @@ func buildMux(h *handler.Handler, _ *runner.Runner) *http.ServeMux {
 		h.ServeArtifact(w, r, id)
 	})
 
-	return mux
+	if basePath == "" {
+		return mux
+	}
+	root := http.NewServeMux()
+	root.Handle(basePath+"/", http.StripPrefix(basePath, mux))
+	root.Handle(basePath, http.RedirectHandler(basePath+"/", http.StatusMovedPermanently))
+	return root
+}
+
+// normalizeBasePath turns the -base-path value into "" (serve at the root)
+// or a cleaned prefix with a leading and no trailing slash, e.g. "/wallfacer".
+func normalizeBasePath(p string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "" || p == "/" {
+		return "", nil
+	}
+	if strings.ContainsAny(p, "?#%\"<>' ") {
+		return "", fmt.Errorf("invalid base path %q", p)
+	}
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return "", nil
+	}
+	return p, nil
+}
+
+// serveIndex serves the UI's index.html with its <base> tag set to basePath,
+// so relative asset and API URLs resolve under the prefix.
+func serveIndex(uiFS fsLib.FS, basePath string) http.HandlerFunc {
+	page, err := fsLib.ReadFile(uiFS, "index.html")
+	if err != nil {
+		logger.Fatal(logger.Main, "read embedded index.html", "error", err)
+	}
+	page = bytes.Replace(page, []byte(`<base href="/">`),
+		[]byte(`<base href="`+html.EscapeString(basePath+"/")+`">`), 1)
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	}
 }
 
 // statusResponseWriter wraps http.ResponseWriter to capture the HTTP status code.
@@ -243,8 +752,22 @@ func (w *statusResponseWriter) Flush() {
 	}
 }
 
-// securityMiddleware sets security headers and enforces CORS for all responses.
-func securityMiddleware(next http.Handler) http.Handler {
+// Unwrap lets http.ResponseController reach the underlying writer, which
+// the WebSocket endpoint hijacks.
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// apiKeyCookie is the cookie that carries the API key for browsers, which
+// cannot add an Authorization header to EventSource requests.
+const apiKeyCookie = "wallfacer_api_key"
+
+// securityMiddleware sets security headers and enforces CORS for all
+// responses. With a non-empty apiKey, requests under basePath+"/api/" and
+// to basePath+"/metrics" must carry the key as an "Authorization: Bearer"
+// header or in apiKeyCookie and are refused with 401 otherwise; the static
+// UI and POST /api/login, which sets the cookie, stay open.
+func securityMiddleware(next http.Handler, apiKey, basePath string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "DENY")
@@ -261,7 +784,7 @@ func securityMiddleware(next http.Handler) http.Handler {
 			if isAllowedOrigin(origin) {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
 				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-				w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 			}
 			if r.Method == "OPTIONS" {
 				w.WriteHeader(http.StatusNoContent)
@@ -269,10 +792,63 @@ func securityMiddleware(next http.Handler) http.Handler {
 			}
 		}
 
+		if apiKey != "" && (strings.HasPrefix(r.URL.Path, basePath+"/api/") || r.URL.Path == basePath+"/metrics") {
+			if r.URL.Path == basePath+"/api/login" && r.Method == http.MethodPost {
+				serveLogin(w, r, apiKey, basePath)
+				return
+			}
+			if !validAPIKey(r, apiKey) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="wallfacer"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// validAPIKey reports whether r carries apiKey as a bearer token or in
+// apiKeyCookie. Keys are compared in constant time.
+func validAPIKey(r *http.Request, apiKey string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		c, err := r.Cookie(apiKeyCookie)
+		if err != nil {
+			return false
+		}
+		got = c.Value
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(apiKey)) == 1
+}
+
+// serveLogin checks the key posted as {"key": "..."} and, if it matches,
+// stores it in apiKeyCookie so the UI's requests and event streams carry
+// it.
+func serveLogin(w http.ResponseWriter, r *http.Request, apiKey, basePath string) {
+	var req struct {
+		Key string `json:"key"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 4<<10)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Key), []byte(apiKey)) != 1 {
+		http.Error(w, "invalid API key", http.StatusUnauthorized)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     apiKeyCookie,
+		Value:    apiKey,
+		Path:     basePath + "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // isAllowedOrigin returns true for localhost and 127.0.0.1 origins.
 func isAllowedOrigin(origin string) bool {
 	u, err := url.Parse(origin)
@@ -298,11 +874,13 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// recoverOrphanedTasks reconciles in_progress/committing tasks on startup by
+// recoverOrphanedTasks reconciles in_progress/queued/committing tasks on startup by
 // checking which containers are still running.
 //
 //   - committing tasks are always moved to failed; the commit pipeline cannot be
 //     safely resumed after a restart.
+//   - queued tasks re-enter the run queue in their saved order; see
+//     runner.ResumeQueue.
 //   - in_progress tasks whose container is still running are left in_progress; a
 //     background goroutine monitors the container and moves the task to waiting
 //     once it stops.
@@ -371,6 +949,7 @@ func recoverOrphanedTasks(s *store.Store, r *runner.Runner) {
 			}
 		}
 	}
+	r.ResumeQueue()
 }
 
 // monitorContainerUntilStopped polls the container runtime until the container
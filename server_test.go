@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"changkun.de/wallfacer/internal/instructions"
+)
+
+func TestSecurityMiddlewareAPIKey(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	const key = "s3cret"
+
+	tests := []struct {
+		name     string
+		apiKey   string
+		method   string
+		path     string
+		bearer   string
+		cookie   string
+		body     string
+		want     int
+		wantAuth bool // expect a WWW-Authenticate challenge
+	}{
+		{name: "disabled by default", method: "GET", path: "/wf/api/tasks", want: 200},
+		{name: "missing key", apiKey: key, method: "GET", path: "/wf/api/tasks", want: 401, wantAuth: true},
+		{name: "wrong bearer", apiKey: key, method: "GET", path: "/wf/api/tasks", bearer: "nope", want: 401, wantAuth: true},
+		{name: "bearer", apiKey: key, method: "GET", path: "/wf/api/tasks", bearer: key, want: 200},
+		{name: "cookie", apiKey: key, method: "GET", path: "/wf/api/tasks", cookie: key, want: 200},
+		{name: "wrong cookie", apiKey: key, method: "GET", path: "/wf/api/tasks", cookie: "nope", want: 401, wantAuth: true},
+		{name: "bearer wins over cookie", apiKey: key, method: "GET", path: "/wf/api/tasks", bearer: "nope", cookie: key, want: 401, wantAuth: true},
+		{name: "health is gated", apiKey: key, method: "GET", path: "/wf/api/health", want: 401, wantAuth: true},
+		{name: "metrics is gated", apiKey: key, method: "GET", path: "/wf/metrics", want: 401, wantAuth: true},
+		{name: "metrics with bearer", apiKey: key, method: "GET", path: "/wf/metrics", bearer: key, want: 200},
+		{name: "static UI stays open", apiKey: key, method: "GET", path: "/wf/", want: 200},
+		{name: "outside base path", apiKey: key, method: "GET", path: "/api/tasks", want: 200},
+		{name: "login", apiKey: key, method: "POST", path: "/wf/api/login", body: `{"key":"s3cret"}`, want: 204},
+		{name: "login with wrong key", apiKey: key, method: "POST", path: "/wf/api/login", body: `{"key":"nope"}`, want: 401},
+		{name: "login with bad JSON", apiKey: key, method: "POST", path: "/wf/api/login", body: `{`, want: 400},
+		{name: "GET login is gated", apiKey: key, method: "GET", path: "/wf/api/login", want: 401, wantAuth: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: apiKeyCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			securityMiddleware(next, tt.apiKey, "/wf").ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if got := w.Header().Get("WWW-Authenticate") != ""; got != tt.wantAuth {
+				t.Errorf("WWW-Authenticate present = %v, want %v", got, tt.wantAuth)
+			}
+		})
+	}
+}
+
+// TestServeLoginSetsCookie verifies that a successful login stores the key
+// in an HttpOnly cookie scoped to the base path, and that the cookie then
+// authenticates API requests.
+func TestServeLoginSetsCookie(t *testing.T) {
+	w := httptest.NewRecorder()
+	serveLogin(w, httptest.NewRequest("POST", "/wf/api/login", strings.NewReader(`{"key":"k"}`)), "k", "/wf")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	c := cookies[0]
+	if c.Name != apiKeyCookie || c.Value != "k" || c.Path != "/wf/" || !c.HttpOnly || c.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("cookie = %+v", c)
+	}
+
+	req := httptest.NewRequest("GET", "/wf/api/tasks", nil)
+	req.AddCookie(c)
+	if !validAPIKey(req, "k") {
+		t.Error("the login cookie does not authenticate")
+	}
+}
+
+// TestMigrateLegacyData verifies that a board and instructions file stored
+// under the previous workspace key are moved to the current key, and that
+// existing data under the current key is never overwritten.
+func TestMigrateLegacyData(t *testing.T) {
+	configDir := t.TempDir()
+	dataDir := filepath.Join(configDir, "data")
+	workspaces := []string{"/ws/b", "/ws/a", "/ws/b"}
+	legacy := instructions.LegacyKey(workspaces)
+	if legacy == "" {
+		t.Fatal("expected a legacy key for duplicated workspaces")
+	}
+	legacyBoard := filepath.Join(dataDir, legacy)
+	legacyInstructions := filepath.Join(configDir, "instructions", legacy+".md")
+	os.MkdirAll(legacyBoard, 0o755)
+	os.WriteFile(filepath.Join(legacyBoard, "marker"), []byte("board"), 0o644)
+	os.MkdirAll(filepath.Dir(legacyInstructions), 0o755)
+	os.WriteFile(legacyInstructions, []byte("notes"), 0o644)
+
+	scoped := filepath.Join(dataDir, instructions.Key(workspaces))
+	migrateLegacyData(configDir, dataDir, scoped, workspaces)
+
+	if got, err := os.ReadFile(filepath.Join(scoped, "marker")); err != nil || string(got) != "board" {
+		t.Fatalf("board not migrated: %q, %v", got, err)
+	}
+	if got, err := os.ReadFile(instructions.FilePath(configDir, workspaces)); err != nil || string(got) != "notes" {
+		t.Fatalf("instructions not migrated: %q, %v", got, err)
+	}
+
+	// A second legacy copy does not replace what is already there.
+	os.MkdirAll(legacyBoard, 0o755)
+	os.WriteFile(legacyInstructions, []byte("stale"), 0o644)
+	migrateLegacyData(configDir, dataDir, scoped, workspaces)
+	if got, _ := os.ReadFile(instructions.FilePath(configDir, workspaces)); string(got) != "notes" {
+		t.Fatalf("instructions overwritten with %q", got)
+	}
+	if _, err := os.Stat(legacyBoard); err != nil {
+		t.Fatal("legacy board was moved over an existing board")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// sdNotify sends state (e.g. "READY=1") to the systemd notification socket
+// named by $NOTIFY_SOCKET, so Type=notify units know when startup is done.
+// It reports false without error when not running under systemd.
+func sdNotify(state string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	// A leading '@' denotes a Linux abstract socket.
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -5,6 +5,7 @@ async function api(path, opts = {}) {
     headers: { 'Content-Type': 'application/json' },
     ...opts,
   });
+  if (res.status === 401) promptLogin();
   if (!res.ok && res.status !== 204) {
     const text = await res.text();
     throw new Error(text);
@@ -13,11 +14,88 @@ async function api(path, opts = {}) {
   return res.json();
 }
 
-// --- Tasks SSE stream ---
+// promptLogin asks for the server's API key after a request was refused
+// with 401 and reloads once the key is accepted; the server keeps it in a
+// cookie, which event streams send as well.
+async function promptLogin() {
+  if (loginPrompted) return;
+  loginPrompted = true;
+  const key = window.prompt('This server requires an API key:');
+  if (!key) return;
+  const res = await fetch('api/login', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ key }),
+  });
+  if (res.ok) {
+    location.reload();
+    return;
+  }
+  loginPrompted = false;
+  showAlert('Invalid API key.');
+}
+
+// --- Tasks stream (WebSocket, or SSE as fallback) ---
 
 function startTasksStream() {
-  if (tasksSource) tasksSource.close();
-  const url = showArchived ? '/api/tasks/stream?include_archived=true' : '/api/tasks/stream';
+  if (tasksSource) {
+    tasksSource.close();
+    tasksSource = null;
+  }
+  if (tasksSocket) {
+    const ws = tasksSocket;
+    tasksSocket = null;
+    ws.close();
+  }
+  if (!wsUnavailable && 'WebSocket' in window) {
+    startTasksSocket();
+  } else {
+    startTasksSSE();
+  }
+}
+
+function startTasksSocket() {
+  const url = new URL(showArchived ? 'api/ws?include_archived=true' : 'api/ws', document.baseURI);
+  url.protocol = url.protocol === 'https:' ? 'wss:' : 'ws:';
+  const ws = new WebSocket(url);
+  let opened = false;
+  tasksSocket = ws;
+  ws.onopen = function() {
+    opened = true;
+    tasksRetryDelay = 1000;
+    if (wsEventSubscribe) wsEventSubscribe();
+  };
+  ws.onmessage = function(e) {
+    let msg;
+    try {
+      msg = JSON.parse(e.data);
+    } catch (err) {
+      console.error('tasks WebSocket parse error:', err);
+      return;
+    }
+    if (msg.type === 'tasks') {
+      tasks = msg.tasks;
+      render();
+    } else if (msg.type === 'event' && wsEventHandler) {
+      wsEventHandler(msg.task_id, msg.event);
+    }
+  };
+  ws.onclose = function() {
+    if (tasksSocket !== ws) return;
+    tasksSocket = null;
+    if (!opened) {
+      // Blocked by a proxy or an older server: use SSE from now on.
+      wsUnavailable = true;
+      startTasksStream();
+      return;
+    }
+    setTimeout(startTasksStream, tasksRetryDelay);
+    tasksRetryDelay = Math.min(tasksRetryDelay * 2, 30000);
+  };
+}
+
+function startTasksSSE() {
+  const url = showArchived ? 'api/tasks/stream?include_archived=true' : 'api/tasks/stream';
   tasksSource = new EventSource(url);
   tasksSource.onmessage = function(e) {
     tasksRetryDelay = 1000;
@@ -38,7 +116,7 @@ function startTasksStream() {
 }
 
 async function fetchTasks() {
-  const url = showArchived ? '/api/tasks?include_archived=true' : '/api/tasks';
+  const url = showArchived ? 'api/tasks?include_archived=true' : 'api/tasks';
   tasks = await api(url);
   render();
 }
@@ -48,3 +126,28 @@ function toggleShowArchived() {
   localStorage.setItem('wallfacer-show-archived', showArchived ? 'true' : 'false');
   startTasksStream();
 }
+
+// --- Runner status & autopilot ---
+
+async function fetchRunnerStatus() {
+  try {
+    const st = await api('api/runner/status');
+    document.getElementById('autopilot-toggle').checked = st.autopilot;
+    const limit = st.max_concurrent ? ` of ${st.max_concurrent}` : '';
+    const held = st.auth_failed ? ' Held: Claude rejected the token; refresh it in Settings.' : '';
+    document.getElementById('autopilot-status').textContent =
+      `${st.running}${limit} running, ${st.queued} queued.${held}`;
+  } catch (e) {
+    console.error('runner status:', e);
+  }
+}
+
+async function toggleAutopilot() {
+  const enabled = document.getElementById('autopilot-toggle').checked;
+  try {
+    await api('api/runner/autopilot', { method: 'POST', body: JSON.stringify({ enabled }) });
+  } catch (e) {
+    showAlert('Failed to toggle autopilot: ' + e.message);
+  }
+  fetchRunnerStatus();
+}
@@ -47,7 +47,7 @@ function setContainerMonitorState(state) {
 }
 
 function fetchContainers() {
-  fetch('/api/containers')
+  fetch('api/containers')
     .then(function(res) {
       return res.json().then(function(data) {
         return { ok: res.ok, data: data };
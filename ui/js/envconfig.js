@@ -6,7 +6,7 @@ async function showEnvConfigEditor(event) {
 
   let cfg = { oauth_token: '', api_key: '', base_url: '', model: '' };
   try {
-    cfg = await api('/api/env');
+    cfg = await api('api/env');
   } catch (e) {
     console.error('Failed to load env config:', e);
   }
@@ -48,7 +48,7 @@ async function saveEnvConfig() {
   const statusEl = document.getElementById('env-config-status');
   statusEl.textContent = 'Saving…';
   try {
-    await api('/api/env', { method: 'PUT', body: JSON.stringify(body) });
+    await api('api/env', { method: 'PUT', body: JSON.stringify(body) });
     statusEl.textContent = 'Saved.';
     // Clear token inputs after saving so they don't linger in the DOM.
     document.getElementById('env-oauth-token').value = '';
@@ -2,7 +2,7 @@
 
 function startGitStream() {
   if (gitStatusSource) gitStatusSource.close();
-  gitStatusSource = new EventSource('/api/git/stream');
+  gitStatusSource = new EventSource('api/git/stream');
   gitStatusSource.onmessage = function(e) {
     gitRetryDelay = 1000;
     try {
@@ -52,7 +52,7 @@ async function pushWorkspace(btn) {
   btn.disabled = true;
   btn.textContent = '...';
   try {
-    await api('/api/git/push', { method: 'POST', body: JSON.stringify({ workspace: ws.path }) });
+    await api('api/git/push', { method: 'POST', body: JSON.stringify({ workspace: ws.path }) });
   } catch (e) {
     showAlert('Push failed: ' + e.message + (e.message.includes('non-fast-forward') ? '\n\nTip: Use Sync to rebase onto upstream first.' : ''));
     btn.disabled = false;
@@ -67,7 +67,7 @@ async function syncWorkspace(btn) {
   btn.disabled = true;
   btn.textContent = '...';
   try {
-    await api('/api/git/sync', { method: 'POST', body: JSON.stringify({ workspace: ws.path }) });
+    await api('api/git/sync', { method: 'POST', body: JSON.stringify({ workspace: ws.path }) });
     // Status stream will update behind_count automatically.
   } catch (e) {
     if (e.message && e.message.includes('rebase conflict')) {
@@ -26,7 +26,7 @@ async function showInstructionsEditor(event, preloadedContent) {
   }
 
   try {
-    var config = await api('/api/config');
+    var config = await api('api/config');
     if (config.instructions_path) {
       pathEl.textContent = config.instructions_path;
     }
@@ -35,7 +35,7 @@ async function showInstructionsEditor(event, preloadedContent) {
   if (preloadedContent != null) return;
 
   try {
-    var data = await api('/api/instructions');
+    var data = await api('api/instructions');
     textarea.value = data.content || '';
     statusEl.textContent = '';
   } catch (e) {
@@ -54,7 +54,7 @@ async function saveInstructions() {
   var statusEl = document.getElementById('instructions-status');
   statusEl.textContent = 'Saving\u2026';
   try {
-    await api('/api/instructions', {
+    await api('api/instructions', {
       method: 'PUT',
       body: JSON.stringify({ content: content }),
     });
@@ -73,7 +73,7 @@ async function reinitInstructionsFromEditor() {
   var statusEl = document.getElementById('instructions-status');
   if (statusEl) statusEl.textContent = 'Re-initializing\u2026';
   try {
-    var data = await api('/api/instructions/reinit', { method: 'POST' });
+    var data = await api('api/instructions/reinit', { method: 'POST' });
     var textarea = document.getElementById('instructions-content');
     if (textarea) textarea.value = data.content || '';
     if (statusEl) {
@@ -36,7 +36,7 @@ async function loadArtifacts(taskId) {
   var countEl = document.getElementById('modal-artifacts-count');
 
   try {
-    var artifacts = await api('/api/tasks/' + taskId + '/artifacts');
+    var artifacts = await api('api/tasks/' + taskId + '/artifacts');
     if (!artifacts || artifacts.length === 0) {
       section.classList.add('hidden');
       previewEl.style.display = 'none';
@@ -76,7 +76,7 @@ async function previewArtifact(taskId, index) {
   if (activeBtn) activeBtn.classList.add('artifact-btn-active');
 
   previewEl.style.display = 'block';
-  var url = '/api/tasks/' + taskId + '/artifacts/' + encodeURI(artifact.path);
+  var url = 'api/tasks/' + taskId + '/artifacts/' + encodeURI(artifact.path);
 
   switch (artifact.type) {
     case 'image':
@@ -231,24 +231,6 @@ function renderResultsFromEvents(results) {
 
 // --- Diff helpers ---
 
-function parseDiffByFile(diff) {
-  const files = [];
-  const blocks = diff.split(/(?=^diff --git )/m);
-  for (const block of blocks) {
-    if (!block.trim()) continue;
-    const lines = block.split('\n');
-    const match = lines[0].match(/^diff --git a\/.+ b\/(.+)$/);
-    const filename = match ? match[1] : lines[0];
-    let adds = 0, dels = 0;
-    for (const line of lines.slice(1)) {
-      if (line.startsWith('+') && !line.startsWith('+++')) adds++;
-      if (line.startsWith('-') && !line.startsWith('---')) dels++;
-    }
-    files.push({ filename, content: block, adds, dels });
-  }
-  return files;
-}
-
 function renderDiffLine(line) {
   const escaped = escapeHtml(line);
   if (line.startsWith('+') && !line.startsWith('+++')) return `<span class="diff-line diff-add">${escaped}</span>`;
@@ -258,43 +240,112 @@ function renderDiffLine(line) {
   return `<span class="diff-line">${escaped}</span>`;
 }
 
-function renderDiffFiles(container, diff) {
-  if (!diff) {
+// renderDiffFileTree lists the changed files reported by the diff API and
+// loads each file's patch only when it is expanded, so large diffs stay
+// usable.
+function renderDiffFileTree(container, taskId, files) {
+  if (!files || files.length === 0) {
     container.innerHTML = '<span class="text-xs text-v-muted">No changes</span>';
     return;
   }
-  const files = parseDiffByFile(diff);
-  if (files.length === 0) {
-    container.innerHTML = '<span class="text-xs text-v-muted">No changes</span>';
-    return;
-  }
-  container.innerHTML = files.map(f => {
-    const statsHtml = [
-      f.adds > 0 ? `<span class="diff-add">+${f.adds}</span>` : '',
-      f.dels > 0 ? `<span class="diff-del">&minus;${f.dels}</span>` : '',
+  const multiRepo = new Set(files.map(f => f.repo)).size > 1;
+  // Keep files the user expanded open when the list is re-rendered.
+  const wasOpen = new Set([...container.querySelectorAll('details[open][data-diff-key]')].map(el => el.dataset.diffKey));
+  container.innerHTML = files.map((f, i) => {
+    const statsHtml = f.binary ? '<span class="text-v-muted">binary</span>' : [
+      f.additions > 0 ? `<span class="diff-add">+${f.additions}</span>` : '',
+      f.deletions > 0 ? `<span class="diff-del">&minus;${f.deletions}</span>` : '',
     ].filter(Boolean).join(' ');
-    const diffHtml = f.content.split('\n').map(renderDiffLine).join('\n');
-    return `<details class="diff-file">
+    const name = multiRepo ? `${f.repo}/${f.path}` : f.path;
+    return `<details class="diff-file" data-diff-index="${i}" data-diff-key="${escapeHtml(f.repo + '/' + f.path)}">
       <summary class="diff-file-summary">
-        <span class="diff-filename">${escapeHtml(f.filename)}</span>
+        <span class="diff-filename">${escapeHtml(name)}</span>
         <span class="diff-stats">${statsHtml}</span>
       </summary>
-      <pre class="diff-block diff-block-modal">${diffHtml}</pre>
+      <pre class="diff-block diff-block-modal"><span class="text-xs text-v-muted">Loading\u2026</span></pre>
     </details>`;
   }).join('');
+  container.querySelectorAll('details[data-diff-index]').forEach(el => {
+    el.addEventListener('toggle', () => {
+      if (!el.open || el.dataset.loaded) return;
+      el.dataset.loaded = '1';
+      const f = files[Number(el.dataset.diffIndex)];
+      const pre = el.querySelector('pre');
+      api(`api/tasks/${taskId}/diff?file=${encodeURIComponent(f.path)}`).then(data => {
+        pre.innerHTML = (data.diff || '').split('\n').map(renderDiffLine).join('\n');
+      }).catch(() => {
+        delete el.dataset.loaded;
+        pre.innerHTML = '<span class="text-xs ev-error">Failed to load diff</span>';
+      });
+    });
+    if (wasOpen.has(el.dataset.diffKey)) el.open = true;
+  });
 }
 
 // --- Modal ---
 
+// renderEventRow renders one entry of the modal's event trace.
+function renderEventRow(e) {
+  const time = new Date(e.created_at).toLocaleTimeString();
+  let detail = '';
+  const data = e.data || {};
+  if (e.event_type === 'state_change') {
+    detail = `${escapeHtml(data.from || '(new)')} → ${escapeHtml(data.to || '')}`;
+  } else if (e.event_type === 'feedback') {
+    detail = `"${escapeHtml(data.message || '')}"`;
+  } else if (e.event_type === 'output') {
+    detail = `stop_reason: ${escapeHtml(data.stop_reason || '(none)')}`;
+  } else if (e.event_type === 'system') {
+    detail = escapeHtml(data.result || '');
+  } else if (e.event_type === 'error') {
+    detail = escapeHtml(data.error || '');
+  } else if (e.event_type === 'checkpoint') {
+    detail = `"${escapeHtml(data.label || '')}"`;
+  } else if (e.event_type === 'conflict') {
+    detail = `${escapeHtml(data.repo || '')} → ${escapeHtml(data.target || '')}: ${escapeHtml((data.files || []).join(', '))} (${escapeHtml(data.reason || '')})`;
+    if (data.commit) detail += `<br>while applying "${escapeHtml(data.commit)}"`;
+    if (data.hunks) {
+      detail += `<details><summary class="cursor-pointer">conflict hunks</summary><pre class="whitespace-pre overflow-x-auto">${escapeHtml(data.hunks)}</pre></details>`;
+    }
+  } else if (e.event_type === 'container_start') {
+    detail = `<details><summary class="cursor-pointer">sandbox command</summary><pre class="whitespace-pre-wrap break-all">${escapeHtml(data.command || '')}</pre>`;
+    if (data.sandbox) detail += `<pre class="whitespace-pre-wrap break-all text-v-muted">${escapeHtml(data.sandbox)}</pre>`;
+    if (data.prompt_file) detail += `<div class="text-v-muted">prompt saved as ${escapeHtml(data.prompt_file)}</div>`;
+    detail += '</details>';
+  }
+  const typeClasses = {
+    state_change: 'ev-state',
+    output: 'ev-output',
+    system: 'ev-system',
+    feedback: 'ev-feedback',
+    error: 'ev-error',
+    checkpoint: 'ev-system',
+    conflict: 'ev-error',
+    container_start: 'ev-system',
+  };
+  return `<div class="flex items-start gap-2 text-xs">
+    <span class="text-v-muted shrink-0">${time}</span>
+    <span class="${typeClasses[e.event_type] || 'text-v-muted'} shrink-0">${escapeHtml(e.event_type)}</span>
+    <span class="text-v-secondary">${detail}</span>
+  </div>`;
+}
+
 async function openModal(id) {
   currentTaskId = id;
-  const task = tasks.find(t => t.id === id);
+  const task = tasks.find(t => t.id === id) || searchResults.find(t => t.id === id);
   if (!task) return;
 
   document.getElementById('modal-badge').className = `badge badge-${task.status}`;
   document.getElementById('modal-badge').textContent = task.status === 'in_progress' ? 'in progress' : task.status;
   document.getElementById('modal-time').textContent = new Date(task.created_at).toLocaleString();
   document.getElementById('modal-id').textContent = `ID: ${task.id}`;
+  const sessionEl = document.getElementById('modal-session');
+  if (task.session_id) {
+    sessionEl.textContent = `Session: ${task.session_id}`;
+    sessionEl.classList.remove('hidden');
+  } else {
+    sessionEl.classList.add('hidden');
+  }
 
   const editSection = document.getElementById('modal-edit-section');
   if (task.status === 'backlog') {
@@ -304,6 +355,12 @@ async function openModal(id) {
     editSection.classList.remove('hidden');
     document.getElementById('modal-edit-prompt').value = task.prompt;
     document.getElementById('modal-edit-timeout').value = String(task.timeout || 5);
+    document.getElementById('modal-edit-priority').value = task.priority || 'normal';
+    const modelSelect = document.getElementById('modal-edit-model');
+    if (task.model && ![...modelSelect.options].some(o => o.value === task.model)) {
+      modelSelect.add(new Option(task.model, task.model));
+    }
+    modelSelect.value = task.model || '';
     const resumeRow = document.getElementById('modal-edit-resume-row');
     if (task.session_id) {
       resumeRow.classList.remove('hidden');
@@ -312,6 +369,9 @@ async function openModal(id) {
       resumeRow.classList.add('hidden');
     }
     document.getElementById('modal-edit-mount-worktrees').checked = !!task.mount_worktrees;
+    document.getElementById('modal-edit-auto-extend').checked = !!task.auto_extend;
+    document.getElementById('modal-edit-max-cost').value = task.max_cost_usd || '';
+    document.getElementById('modal-edit-merge-strategy').value = task.merge_strategy || '';
   } else {
     const promptRaw = document.getElementById('modal-prompt');
     const promptRendered = document.getElementById('modal-prompt-rendered');
@@ -331,6 +391,19 @@ async function openModal(id) {
     document.getElementById('modal-result-section').classList.add('hidden');
   }
 
+  // Pull requests opened for tasks using the pull-request merge strategy
+  const prSection = document.getElementById('modal-pull-requests-section');
+  const prs = Object.entries(task.pull_request_urls || {});
+  if (prs.length) {
+    document.getElementById('modal-pull-requests-list').innerHTML = prs.map(([repo, url]) =>
+      `<div class="text-xs"><span class="text-v-muted">${escapeHtml(repo.split('/').pop())}</span> ` +
+      `<a href="${escapeHtml(url)}" target="_blank" rel="noopener" style="color:var(--accent);">${escapeHtml(url)}</a></div>`
+    ).join('');
+    prSection.classList.remove('hidden');
+  } else {
+    prSection.classList.add('hidden');
+  }
+
   // Usage stats (show when any tokens have been used)
   const usageSection = document.getElementById('modal-usage-section');
   const u = task.usage;
@@ -339,7 +412,8 @@ async function openModal(id) {
     document.getElementById('modal-usage-output').textContent = u.output_tokens.toLocaleString();
     document.getElementById('modal-usage-cache-read').textContent = u.cache_read_input_tokens.toLocaleString();
     document.getElementById('modal-usage-cache-creation').textContent = u.cache_creation_input_tokens.toLocaleString();
-    document.getElementById('modal-usage-cost').textContent = '$' + u.cost_usd.toFixed(4);
+    document.getElementById('modal-usage-cost').textContent = '$' + u.cost_usd.toFixed(4) +
+      (task.max_cost_usd ? ' of $' + task.max_cost_usd.toFixed(2) + ' budget' : '');
     usageSection.classList.remove('hidden');
   } else {
     usageSection.classList.add('hidden');
@@ -351,6 +425,7 @@ async function openModal(id) {
   const logsSection = document.getElementById('modal-logs-section');
   if (task.status !== 'backlog') {
     logsSection.classList.remove('hidden');
+    document.getElementById('modal-logs-download').href = `api/tasks/${id}/logs/download`;
     startLogStream(id);
   } else {
     logsSection.classList.add('hidden');
@@ -364,7 +439,9 @@ async function openModal(id) {
   const modalRight = document.getElementById('modal-right');
   const hasWorktrees = task.worktree_paths && Object.keys(task.worktree_paths).length > 0;
   const modalBody = document.getElementById('modal-body');
-  if ((task.status === 'waiting' || task.status === 'failed') && hasWorktrees) {
+  if (diffSource) diffSource.close();
+  diffSource = null;
+  if ((task.status === 'in_progress' || task.status === 'waiting' || task.status === 'failed') && hasWorktrees) {
     modalCard.classList.add('modal-wide');
     modalRight.classList.remove('hidden');
     modalBody.style.display = 'flex';
@@ -373,9 +450,9 @@ async function openModal(id) {
     const behindEl = document.getElementById('modal-diff-behind');
     filesEl.innerHTML = '<span class="text-xs text-v-muted">Loading diff\u2026</span>';
     if (behindEl) behindEl.classList.add('hidden');
-    api(`/api/tasks/${task.id}/diff`).then(data => {
+    const showDiff = data => {
       const el = document.getElementById('modal-diff-files');
-      if (el) renderDiffFiles(el, data.diff);
+      if (el) renderDiffFileTree(el, task.id, data.files);
       const behindCounts = data.behind_counts || {};
       const entries = Object.entries(behindCounts);
       const totalBehind = entries.reduce((s, [, n]) => s + n, 0);
@@ -393,10 +470,20 @@ async function openModal(id) {
           warnEl.classList.add('hidden');
         }
       }
-    }).catch(() => {
-      const el = document.getElementById('modal-diff-files');
-      if (el) el.innerHTML = '<span class="text-xs ev-error">Failed to load diff</span>';
-    });
+    };
+    if (task.status === 'in_progress') {
+      // Follow Claude's edits live while the task runs.
+      diffSource = new EventSource(`api/tasks/${task.id}/diff/stream?format=stat`);
+      diffSource.onmessage = e => showDiff(JSON.parse(e.data));
+      // The server ends the stream once the diff can no longer change.
+      const source = diffSource;
+      source.addEventListener('end', () => source.close());
+    } else {
+      api(`api/tasks/${task.id}/diff?format=stat`).then(showDiff).catch(() => {
+        const el = document.getElementById('modal-diff-files');
+        if (el) el.innerHTML = '<span class="text-xs ev-error">Failed to load diff</span>';
+      });
+    }
   } else {
     modalCard.classList.remove('modal-wide');
     modalRight.classList.add('hidden');
@@ -416,9 +503,17 @@ async function openModal(id) {
     resumeSection.classList.add('hidden');
   }
 
-  // Cancel section (backlog / in_progress / waiting / failed)
+  // Diagnose section (failed with worktrees)
+  document.getElementById('modal-diagnose-section').classList.toggle('hidden',
+    !(task.status === 'failed' && hasWorktrees));
+
+  // Conflict section (rebase paused for manual resolution)
+  document.getElementById('modal-conflict-section').classList.toggle('hidden', task.status !== 'conflict');
+  document.getElementById('modal-conflict-worktree').textContent = task.conflict_worktree || '';
+
+  // Cancel section (backlog / queued / in_progress / waiting / conflict / failed)
   const cancelSection = document.getElementById('modal-cancel-section');
-  const cancellable = ['backlog', 'in_progress', 'waiting', 'failed'];
+  const cancellable = ['backlog', 'queued', 'in_progress', 'waiting', 'committing', 'conflict', 'failed'];
   cancelSection.classList.toggle('hidden', !cancellable.includes(task.status));
 
   // Retry section (failed / waiting / cancelled)
@@ -438,6 +533,18 @@ async function openModal(id) {
     retryResumeRow.classList.add('hidden');
   }
 
+  // Review section (done tasks)
+  const reviewSection = document.getElementById('modal-review-section');
+  if (task.status === 'done') {
+    reviewSection.classList.remove('hidden');
+    document.getElementById('modal-review-status').textContent = task.reviewed
+      ? `Reviewed ${timeAgo(task.reviewed_at)}.`
+      : 'The changes from this task have not been reviewed yet.';
+    document.getElementById('modal-review-btn').textContent = task.reviewed ? 'Mark unreviewed' : 'Mark reviewed';
+  } else {
+    reviewSection.classList.add('hidden');
+  }
+
   // Archive/Unarchive section (done or cancelled tasks)
   const archiveSection = document.getElementById('modal-archive-section');
   const unarchiveSection = document.getElementById('modal-unarchive-section');
@@ -465,47 +572,46 @@ async function openModal(id) {
     historySection.classList.add('hidden');
   }
 
-  // Load events
+  // Load events, then follow new ones live
+  if (eventsSource) eventsSource.close();
+  eventsSource = null;
+  stopWsEvents();
   try {
-    const events = await api(`/api/tasks/${id}/events`);
-
-    // Replace single-result fallback with all turn results from output events
-    const outputResults = events
-      .filter(e => e.event_type === 'output' && e.data && e.data.result)
-      .map(e => e.data.result);
-    if (outputResults.length > 0) {
-      renderResultsFromEvents(outputResults);
-    }
-
-    const container = document.getElementById('modal-events');
-    container.innerHTML = events.map(e => {
-      const time = new Date(e.created_at).toLocaleTimeString();
-      let detail = '';
-      const data = e.data || {};
-      if (e.event_type === 'state_change') {
-        detail = `${escapeHtml(data.from || '(new)')} → ${escapeHtml(data.to || '')}`;
-      } else if (e.event_type === 'feedback') {
-        detail = `"${escapeHtml(data.message || '')}"`;
-      } else if (e.event_type === 'output') {
-        detail = `stop_reason: ${escapeHtml(data.stop_reason || '(none)')}`;
-      } else if (e.event_type === 'system') {
-        detail = escapeHtml(data.result || '');
-      } else if (e.event_type === 'error') {
-        detail = escapeHtml(data.error || '');
+    const events = await api(`api/tasks/${id}/events`);
+    const outputResults = [];
+    const addEvents = evs => {
+      const container = document.getElementById('modal-events');
+      container.insertAdjacentHTML('beforeend', evs.map(renderEventRow).join(''));
+      // Replace single-result fallback with all turn results from output events
+      const results = evs.filter(e => e.event_type === 'output' && e.data && e.data.result).map(e => e.data.result);
+      if (results.length > 0) {
+        outputResults.push(...results);
+        renderResultsFromEvents(outputResults);
       }
-      const typeClasses = {
-        state_change: 'ev-state',
-        output: 'ev-output',
-        system: 'ev-system',
-        feedback: 'ev-feedback',
-        error: 'ev-error',
+    };
+    document.getElementById('modal-events').innerHTML = '';
+    addEvents(events);
+
+    let lastId = events.length ? events[events.length - 1].id : 0;
+    if (tasksSocket && tasksSocket.readyState === WebSocket.OPEN) {
+      wsEventHandler = (taskId, ev) => {
+        if (taskId !== id || currentTaskId !== id || ev.id <= lastId) return;
+        lastId = ev.id;
+        addEvents([ev]);
       };
-      return `<div class="flex items-start gap-2 text-xs">
-        <span class="text-v-muted shrink-0">${time}</span>
-        <span class="${typeClasses[e.event_type] || 'text-v-muted'} shrink-0">${escapeHtml(e.event_type)}</span>
-        <span class="text-v-secondary">${detail}</span>
-      </div>`;
-    }).join('');
+      wsEventSubscribe = () => {
+        if (tasksSocket && tasksSocket.readyState === WebSocket.OPEN) {
+          tasksSocket.send(JSON.stringify({ type: 'subscribe', task_id: id, since_id: lastId }));
+        }
+      };
+      wsEventSubscribe();
+    } else {
+      eventsSource = new EventSource(`api/tasks/${id}/events/stream?since_id=${lastId}`);
+      eventsSource.onmessage = e => {
+        if (currentTaskId !== id) return;
+        addEvents([JSON.parse(e.data)]);
+      };
+    }
   } catch (e) {
     document.getElementById('modal-events').innerHTML = '<span class="text-xs ev-error">Failed to load events</span>';
   }
@@ -514,7 +620,26 @@ async function openModal(id) {
   document.getElementById('modal').classList.add('flex');
 }
 
+// stopWsEvents ends the modal's event subscription on tasksSocket, if any.
+function stopWsEvents() {
+  if (!wsEventSubscribe) return;
+  wsEventHandler = null;
+  wsEventSubscribe = null;
+  if (tasksSocket && tasksSocket.readyState === WebSocket.OPEN) {
+    tasksSocket.send(JSON.stringify({ type: 'unsubscribe' }));
+  }
+}
+
 function closeModal() {
+  if (diffSource) {
+    diffSource.close();
+    diffSource = null;
+  }
+  if (eventsSource) {
+    eventsSource.close();
+    eventsSource = null;
+  }
+  stopWsEvents();
   if (logsAbort) {
     logsAbort.abort();
     logsAbort = null;
@@ -719,7 +844,7 @@ function _fetchLogs(id, retryDelay) {
   }
   const delay = retryDelay || 1000;
   const decoder = new TextDecoder();
-  const url = `/api/tasks/${id}/logs?raw=true`;
+  const url = `api/tasks/${id}/logs?raw=true`;
 
   function reconnect() {
     // Only reconnect if this task modal is still open and task is running.
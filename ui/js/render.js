@@ -33,7 +33,7 @@ async function fetchDiff(card, taskId, updatedAt) {
   }
   diffCache.set(taskId, 'loading');
   try {
-    const data = await api(`/api/tasks/${taskId}/diff`);
+    const data = await api(`api/tasks/${taskId}/diff`);
     const behindCounts = data.behind_counts || {};
     diffCache.set(taskId, { diff: data.diff, behindCounts, updatedAt });
     const latestEl = card.querySelector('[data-diff]');
@@ -62,16 +62,21 @@ function applyDiffToCard(el, diff, behindCounts, taskId) {
 }
 
 function render() {
-  const columns = { backlog: [], in_progress: [], waiting: [], committing: [], done: [], failed: [], cancelled: [] };
+  const columns = { backlog: [], queued: [], in_progress: [], waiting: [], committing: [], conflict: [], done: [], failed: [], cancelled: [] };
   for (const t of tasks) {
     const col = columns[t.status];
     if (col) col.push(t);
   }
 
-  // Failed and committing tasks show in the Waiting column.
+  // Queued tasks show in the In Progress column until a slot frees.
+  columns.in_progress = columns.in_progress.concat(columns.queued);
+  delete columns.queued;
+
+  // Failed, committing and conflict tasks show in the Waiting column.
   // Failed tasks are visually distinguished by a red left border on the card.
-  columns.waiting = columns.waiting.concat(columns.failed).concat(columns.committing);
+  columns.waiting = columns.waiting.concat(columns.failed).concat(columns.committing).concat(columns.conflict);
   delete columns.committing;
+  delete columns.conflict;
   delete columns.failed;
 
   // Cancelled tasks show in the Done column.
@@ -90,8 +95,15 @@ function render() {
       existing.set(child.dataset.id, child);
     }
 
-    // Sort by last updated descending (most recently updated first)
-    items.sort((a, b) => new Date(b.updated_at) - new Date(a.updated_at));
+    // Sort by last updated descending (most recently updated first); the
+    // backlog puts higher-priority tasks first.
+    items.sort((a, b) => {
+      if (status === 'backlog') {
+        const diff = priorityRank(b.priority) - priorityRank(a.priority);
+        if (diff !== 0) return diff;
+      }
+      return new Date(b.updated_at) - new Date(a.updated_at);
+    });
 
     const newIds = new Set(items.map(t => t.id));
 
@@ -156,6 +168,8 @@ function buildCardActions(t) {
       parts.push(`<button class="card-action-btn card-action-resume" onclick="event.stopPropagation();quickResumeTask('${t.id}',${t.timeout || 15})" title="Resume in existing session">&#8635; Resume</button>`);
     }
     parts.push(`<button class="card-action-btn card-action-retry" onclick="event.stopPropagation();quickRetryTask('${t.id}')" title="Move back to Backlog">&#8617; Retry</button>`);
+  } else if (t.status === 'conflict') {
+    parts.push(`<button class="card-action-btn card-action-resume" onclick="event.stopPropagation();continueRebase('${t.id}')" title="Continue the rebase after resolving the conflict in ${escapeHtml(t.conflict_worktree || '')}">&#8635; Continue rebase</button>`);
   } else if (t.status === 'cancelled') {
     parts.push(`<button class="card-action-btn card-action-retry" onclick="event.stopPropagation();quickRetryTask('${t.id}')" title="Move back to Backlog">&#8617; Retry</button>`);
   }
@@ -163,6 +177,28 @@ function buildCardActions(t) {
   return `<div class="card-actions">${parts.join('')}</div>`;
 }
 
+// priorityRank mirrors store.PriorityRank: higher ranks run first and a
+// missing priority counts as normal.
+function priorityRank(p) {
+  return { low: 0, high: 2, urgent: 3 }[p] ?? 1;
+}
+
+// pendingDependencies counts the dependencies of t that are not done yet.
+// Dependencies missing from the board (deleted) do not count, as in
+// store.CanStart.
+function pendingDependencies(t) {
+  return (t.depends_on || []).filter(id => {
+    const dep = tasks.find(x => x.id === id);
+    return dep && dep.status !== 'done';
+  }).length;
+}
+
+// targetBranchLabel returns the distinct branches the task will merge into.
+function targetBranchLabel(t) {
+  if (!t.target_branches) return '';
+  return [...new Set(Object.values(t.target_branches))].join(', ');
+}
+
 function updateCard(card, t) {
   const isArchived = !!t.archived;
   const badgeClass = isArchived ? 'badge-archived' : `badge-${t.status}`;
@@ -187,9 +223,15 @@ function updateCard(card, t) {
       <div class="flex items-center gap-1.5">
         <span class="badge ${badgeClass}">${statusLabel}</span>
         ${showSpinner ? '<span class="spinner"></span>' : ''}
+        ${t.status === 'done' && t.reviewed ? '<span class="badge badge-reviewed" title="Reviewed">reviewed</span>' : ''}
+        ${t.status === 'backlog' && t.priority && t.priority !== 'normal' ? `<span class="badge badge-priority-${escapeHtml(t.priority)}" title="Priority">${escapeHtml(t.priority)}</span>` : ''}
+        ${t.status === 'backlog' && pendingDependencies(t) ? `<span class="badge badge-blocked" title="Waiting on unfinished dependencies">waiting on ${pendingDependencies(t)}</span>` : ''}
       </div>
       <div class="flex items-center gap-1.5">
         ${t.mount_worktrees ? '<span class="text-[10px] text-v-muted" title="Sibling worktrees mounted">worktrees</span>' : ''}
+        ${targetBranchLabel(t) ? `<span class="text-[10px] text-v-muted" title="Merges into">&rarr; ${escapeHtml(targetBranchLabel(t))}</span>` : ''}
+        ${t.model ? `<span class="text-[10px] text-v-muted" title="Model">${escapeHtml(t.model)}</span>` : ''}
+        ${t.merge_strategy === 'pull-request' ? `<span class="text-[10px] text-v-muted" title="Lands as a pull request">PR</span>` : ''}
         <span class="text-[10px] text-v-muted" title="Timeout">${formatTimeout(t.timeout)}</span>
         <span class="text-[10px] text-v-muted">${timeAgo(t.created_at)}</span>
       </div>
@@ -0,0 +1,50 @@
+// --- Task search ---
+
+async function searchTasks(q) {
+  const box = document.getElementById('search-results');
+  try {
+    searchResults = await api('api/tasks/search?q=' + encodeURIComponent(q));
+  } catch (e) {
+    box.innerHTML = `<div class="search-result-text" style="padding: 8px 10px; color: var(--text-muted);">${escapeHtml(e.message)}</div>`;
+    box.classList.remove('hidden');
+    return;
+  }
+  if (searchResults.length === 0) {
+    box.innerHTML = '<div class="search-result-text" style="padding: 8px 10px; color: var(--text-muted);">No matching tasks</div>';
+  } else {
+    box.innerHTML = searchResults.map(t => {
+      const status = t.status === 'in_progress' ? 'in progress' : t.status;
+      return `<button class="search-result" onclick="openSearchResult('${t.id}')">` +
+        `<span class="badge badge-${t.status}">${escapeHtml(status)}</span>` +
+        (t.archived ? ' <span style="font-size: 11px; color: var(--text-muted);">archived</span>' : '') +
+        `<span class="search-result-text">${escapeHtml(t.title || t.prompt)}</span>` +
+        '</button>';
+    }).join('');
+  }
+  box.classList.remove('hidden');
+}
+
+function onSearchKey(e) {
+  if (e.key === 'Escape') {
+    closeSearch();
+    return;
+  }
+  if (e.key !== 'Enter') return;
+  const q = e.target.value.trim();
+  if (q) searchTasks(q);
+  else closeSearch();
+}
+
+function openSearchResult(id) {
+  closeSearch();
+  openModal(id);
+}
+
+function closeSearch() {
+  document.getElementById('search-results').classList.add('hidden');
+}
+
+// Close search results on outside click
+document.addEventListener('click', function(e) {
+  if (!document.getElementById('search-box').contains(e.target)) closeSearch();
+});
@@ -2,12 +2,22 @@
 let tasks = [];
 let currentTaskId = null;
 let logsAbort = null;
+let diffSource = null; // EventSource of the live diff in the modal
+let eventsSource = null; // EventSource of the live event trace in the modal
 let rawLogBuffer = '';
 let logsPrettyMode = true;
 let showArchived = localStorage.getItem('wallfacer-show-archived') === 'true';
+let searchResults = []; // last api/tasks/search response, so archived hits can open
+let taskModels = []; // model allowlist from api/config; empty = any model
+let taskTemplates = []; // saved task templates from api/templates
+let loginPrompted = false; // the API key prompt is open or was dismissed
 
-// Tasks SSE state
+// Tasks stream state: a WebSocket when the server accepts one, else SSE
 let tasksSource = null;
+let tasksSocket = null;
+let wsUnavailable = false; // the WebSocket never opened; stay on SSE
+let wsEventHandler = null; // receives the modal task's events from tasksSocket
+let wsEventSubscribe = null; // (re)subscribes tasksSocket to the modal task's events
 let tasksRetryDelay = 1000;
 
 // Git SSE state
@@ -14,7 +14,12 @@ async function createTask() {
   try {
     const timeout = parseInt(document.getElementById('new-timeout').value, 10) || DEFAULT_TASK_TIMEOUT;
     const mount_worktrees = document.getElementById('new-mount-worktrees').checked;
-    await api('/api/tasks', { method: 'POST', body: JSON.stringify({ prompt, timeout, mount_worktrees }) });
+    const auto_extend = document.getElementById('new-auto-extend').checked;
+    const priority = document.getElementById('new-priority').value;
+    const model = document.getElementById('new-model').value;
+    const max_cost_usd = parseFloat(document.getElementById('new-max-cost').value) || 0;
+    const merge_strategy = document.getElementById('new-merge-strategy').value;
+    await api('api/tasks', { method: 'POST', body: JSON.stringify({ prompt, timeout, mount_worktrees, auto_extend, priority, model, max_cost_usd, merge_strategy }) });
     hideNewTaskForm();
     fetchTasks();
   } catch (e) {
@@ -26,10 +31,60 @@ function showNewTaskForm() {
   document.getElementById('new-task-btn').classList.add('hidden');
   document.getElementById('new-task-form').classList.remove('hidden');
   document.getElementById('new-timeout').value = DEFAULT_TASK_TIMEOUT;
+  document.getElementById('new-priority').value = 'normal';
+  document.getElementById('new-model').value = '';
+  document.getElementById('new-max-cost').value = '';
+  document.getElementById('new-merge-strategy').value = '';
   const textarea = document.getElementById('new-prompt');
   textarea.value = '';
   textarea.style.height = '';
   textarea.focus();
+  loadTaskTemplates();
+}
+
+// --- Templates ---
+
+// loadTaskTemplates fills the template picker of the new-task form. The
+// picker stays hidden while no template is saved.
+async function loadTaskTemplates() {
+  try {
+    taskTemplates = await api('api/templates');
+  } catch (e) {
+    console.error('load templates:', e);
+    return;
+  }
+  const select = document.getElementById('new-template');
+  select.length = 1;
+  for (const t of taskTemplates) select.add(new Option(t.name, t.name));
+  select.value = '';
+  select.classList.toggle('hidden', !taskTemplates.length);
+}
+
+// applyTaskTemplate copies a template's prompt, timeout, and model into the
+// new-task form.
+function applyTaskTemplate(name) {
+  const t = taskTemplates.find(t => t.name === name);
+  if (!t) return;
+  const textarea = document.getElementById('new-prompt');
+  textarea.value = t.prompt;
+  textarea.dispatchEvent(new Event('input'));
+  document.getElementById('new-timeout').value = t.timeout || DEFAULT_TASK_TIMEOUT;
+  document.getElementById('new-model').value = t.model || '';
+}
+
+async function saveTaskTemplate() {
+  const prompt = document.getElementById('new-prompt').value.trim();
+  if (!prompt) return;
+  const name = window.prompt('Template name (letters, digits, ".", "_" or "-"):');
+  if (!name) return;
+  try {
+    const timeout = parseInt(document.getElementById('new-timeout').value, 10) || DEFAULT_TASK_TIMEOUT;
+    const model = document.getElementById('new-model').value;
+    await api('api/templates', { method: 'POST', body: JSON.stringify({ name, prompt, timeout, model }) });
+    loadTaskTemplates();
+  } catch (e) {
+    showAlert('Error saving template: ' + e.message);
+  }
 }
 
 function hideNewTaskForm() {
@@ -39,13 +94,14 @@ function hideNewTaskForm() {
   textarea.value = '';
   textarea.style.height = '';
   document.getElementById('new-mount-worktrees').checked = false;
+  document.getElementById('new-auto-extend').checked = false;
 }
 
 // --- Task status updates ---
 
 async function updateTaskStatus(id, status) {
   try {
-    await api(`/api/tasks/${id}`, { method: 'PATCH', body: JSON.stringify({ status }) });
+    await api(`api/tasks/${id}`, { method: 'PATCH', body: JSON.stringify({ status }) });
     fetchTasks();
   } catch (e) {
     showAlert('Error updating task: ' + e.message);
@@ -54,7 +110,7 @@ async function updateTaskStatus(id, status) {
 
 async function toggleFreshStart(id, freshStart) {
   try {
-    await api(`/api/tasks/${id}`, { method: 'PATCH', body: JSON.stringify({ fresh_start: freshStart }) });
+    await api(`api/tasks/${id}`, { method: 'PATCH', body: JSON.stringify({ fresh_start: freshStart }) });
   } catch (e) {
     showAlert('Error updating task: ' + e.message);
   }
@@ -64,7 +120,7 @@ async function toggleFreshStart(id, freshStart) {
 
 async function deleteTask(id) {
   try {
-    await api(`/api/tasks/${id}`, { method: 'DELETE' });
+    await api(`api/tasks/${id}`, { method: 'DELETE' });
     fetchTasks();
   } catch (e) {
     showAlert('Error deleting task: ' + e.message);
@@ -85,7 +141,7 @@ async function submitFeedback() {
   const message = textarea.value.trim();
   if (!message || !currentTaskId) return;
   try {
-    await api(`/api/tasks/${currentTaskId}/feedback`, {
+    await api(`api/tasks/${currentTaskId}/feedback`, {
       method: 'POST',
       body: JSON.stringify({ message }),
     });
@@ -100,7 +156,7 @@ async function submitFeedback() {
 async function completeTask() {
   if (!currentTaskId) return;
   try {
-    await api(`/api/tasks/${currentTaskId}/done`, { method: 'POST' });
+    await api(`api/tasks/${currentTaskId}/done`, { method: 'POST' });
     closeModal();
     fetchTasks();
   } catch (e) {
@@ -120,7 +176,7 @@ async function retryTask() {
     if (retryResumeRow && !retryResumeRow.classList.contains('hidden')) {
       body.fresh_start = !document.getElementById('modal-retry-resume').checked;
     }
-    await api(`/api/tasks/${currentTaskId}`, {
+    await api(`api/tasks/${currentTaskId}`, {
       method: 'PATCH',
       body: JSON.stringify(body),
     });
@@ -136,7 +192,7 @@ async function resumeTask() {
   try {
     const timeoutEl = document.getElementById('modal-resume-timeout');
     const timeout = timeoutEl ? parseInt(timeoutEl.value, 10) || DEFAULT_TASK_TIMEOUT : DEFAULT_TASK_TIMEOUT;
-    await api(`/api/tasks/${currentTaskId}/resume`, {
+    await api(`api/tasks/${currentTaskId}/resume`, {
       method: 'POST',
       body: JSON.stringify({ timeout }),
     });
@@ -147,13 +203,33 @@ async function resumeTask() {
   }
 }
 
+async function diagnoseTask() {
+  if (!currentTaskId) return;
+  try {
+    const res = await api(`api/tasks/${currentTaskId}/diagnose`, { method: 'POST' });
+    window.open(res.url, '_blank');
+  } catch (e) {
+    showAlert('Error running diagnostics: ' + e.message);
+  }
+}
+
+async function continueRebase(id) {
+  if (!id) return;
+  try {
+    await api(`api/tasks/${id}/continue-rebase`, { method: 'POST' });
+    fetchTasks();
+  } catch (e) {
+    showAlert('Error continuing rebase: ' + e.message);
+  }
+}
+
 // --- Backlog editing ---
 
 async function saveResumeOption(resume) {
   if (!currentTaskId) return;
   const statusEl = document.getElementById('modal-edit-status');
   try {
-    await api(`/api/tasks/${currentTaskId}`, {
+    await api(`api/tasks/${currentTaskId}`, {
       method: 'PATCH',
       body: JSON.stringify({ fresh_start: !resume }),
     });
@@ -174,10 +250,17 @@ function scheduleBacklogSave() {
     if (!prompt) return;
     const timeout = parseInt(document.getElementById('modal-edit-timeout').value, 10) || DEFAULT_TASK_TIMEOUT;
     const mount_worktrees = document.getElementById('modal-edit-mount-worktrees').checked;
+    const auto_extend = document.getElementById('modal-edit-auto-extend').checked;
+    const priority = document.getElementById('modal-edit-priority').value;
+    const max_cost_usd = parseFloat(document.getElementById('modal-edit-max-cost').value) || 0;
+    const merge_strategy = document.getElementById('modal-edit-merge-strategy').value;
+    const body = { prompt, timeout, mount_worktrees, auto_extend, priority, max_cost_usd, merge_strategy };
+    // Without an allowlist the model picker is hidden; leave the model alone.
+    if (taskModels.length) body.model = document.getElementById('modal-edit-model').value;
     try {
-      await api(`/api/tasks/${currentTaskId}`, {
+      await api(`api/tasks/${currentTaskId}`, {
         method: 'PATCH',
-        body: JSON.stringify({ prompt, timeout, mount_worktrees }),
+        body: JSON.stringify(body),
       });
       statusEl.textContent = 'Saved';
       setTimeout(() => { if (statusEl.textContent === 'Saved') statusEl.textContent = ''; }, 1500);
@@ -190,6 +273,30 @@ function scheduleBacklogSave() {
 
 document.getElementById('modal-edit-prompt').addEventListener('input', scheduleBacklogSave);
 document.getElementById('modal-edit-timeout').addEventListener('change', scheduleBacklogSave);
+document.getElementById('modal-edit-priority').addEventListener('change', scheduleBacklogSave);
+document.getElementById('modal-edit-model').addEventListener('change', scheduleBacklogSave);
+document.getElementById('modal-edit-max-cost').addEventListener('input', scheduleBacklogSave);
+document.getElementById('modal-edit-merge-strategy').addEventListener('change', scheduleBacklogSave);
+
+// --- Model selection ---
+
+// loadTaskModels fills the model pickers from the server's model allowlist.
+// The pickers stay hidden when no allowlist is configured.
+async function loadTaskModels() {
+  try {
+    const config = await api('api/config');
+    taskModels = config.models || [];
+  } catch (e) {
+    console.error('load models:', e);
+    return;
+  }
+  for (const id of ['new-model', 'modal-edit-model']) {
+    const select = document.getElementById(id);
+    for (const m of taskModels) select.add(new Option(m, m));
+  }
+  document.getElementById('new-model').classList.toggle('hidden', !taskModels.length);
+  document.querySelectorAll('.model-option').forEach(el => el.classList.toggle('hidden', !taskModels.length));
+}
 
 // --- Cancel ---
 
@@ -197,7 +304,7 @@ async function cancelTask() {
   if (!currentTaskId) return;
   if (!confirm('Cancel this task? The sandbox will be cleaned up and all prepared changes discarded. History and logs will be preserved.')) return;
   try {
-    await api(`/api/tasks/${currentTaskId}/cancel`, { method: 'POST' });
+    await api(`api/tasks/${currentTaskId}/cancel`, { method: 'POST' });
     closeModal();
     fetchTasks();
   } catch (e) {
@@ -205,12 +312,25 @@ async function cancelTask() {
   }
 }
 
+// --- Review ---
+
+async function toggleReviewed() {
+  if (!currentTaskId) return;
+  try {
+    await api(`api/tasks/${currentTaskId}/review/toggle`, { method: 'POST' });
+    closeModal();
+    fetchTasks();
+  } catch (e) {
+    showAlert('Error updating review state: ' + e.message);
+  }
+}
+
 // --- Archive / Unarchive ---
 
 async function archiveTask() {
   if (!currentTaskId) return;
   try {
-    await api(`/api/tasks/${currentTaskId}/archive`, { method: 'POST' });
+    await api(`api/tasks/${currentTaskId}/archive`, { method: 'POST' });
     closeModal();
     fetchTasks();
   } catch (e) {
@@ -221,7 +341,7 @@ async function archiveTask() {
 async function unarchiveTask() {
   if (!currentTaskId) return;
   try {
-    await api(`/api/tasks/${currentTaskId}/unarchive`, { method: 'POST' });
+    await api(`api/tasks/${currentTaskId}/unarchive`, { method: 'POST' });
     closeModal();
     fetchTasks();
   } catch (e) {
@@ -229,11 +349,24 @@ async function unarchiveTask() {
   }
 }
 
+// --- Clone ---
+
+async function cloneTask() {
+  if (!currentTaskId) return;
+  try {
+    await api(`api/tasks/${currentTaskId}/clone`, { method: 'POST' });
+    closeModal();
+    fetchTasks();
+  } catch (e) {
+    showAlert('Error cloning task: ' + e.message);
+  }
+}
+
 // --- Quick card actions (no modal required) ---
 
 async function quickDoneTask(id) {
   try {
-    await api(`/api/tasks/${id}/done`, { method: 'POST' });
+    await api(`api/tasks/${id}/done`, { method: 'POST' });
     fetchTasks();
   } catch (e) {
     showAlert('Error completing task: ' + e.message);
@@ -242,7 +375,7 @@ async function quickDoneTask(id) {
 
 async function quickResumeTask(id, timeout) {
   try {
-    await api(`/api/tasks/${id}/resume`, { method: 'POST', body: JSON.stringify({ timeout }) });
+    await api(`api/tasks/${id}/resume`, { method: 'POST', body: JSON.stringify({ timeout }) });
     fetchTasks();
   } catch (e) {
     showAlert('Error resuming task: ' + e.message);
@@ -251,7 +384,7 @@ async function quickResumeTask(id, timeout) {
 
 async function quickRetryTask(id) {
   try {
-    await api(`/api/tasks/${id}`, { method: 'PATCH', body: JSON.stringify({ status: 'backlog' }) });
+    await api(`api/tasks/${id}`, { method: 'PATCH', body: JSON.stringify({ status: 'backlog' }) });
     fetchTasks();
   } catch (e) {
     showAlert('Error retrying task: ' + e.message);
@@ -262,7 +395,7 @@ async function quickRetryTask(id) {
 
 async function syncTask(id) {
   try {
-    await api(`/api/tasks/${id}/sync`, { method: 'POST' });
+    await api(`api/tasks/${id}/sync`, { method: 'POST' });
     diffCache.delete(id);
     fetchTasks();
   } catch (e) {
@@ -285,7 +418,7 @@ async function generateMissingTitles() {
 
   try {
     const params = new URLSearchParams({ limit });
-    const res = await api(`/api/tasks/generate-titles?${params}`, { method: 'POST' });
+    const res = await api(`api/tasks/generate-titles?${params}`, { method: 'POST' });
     const { queued, total_without_title, task_ids } = res;
 
     if (queued === 0) {
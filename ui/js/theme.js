@@ -31,7 +31,9 @@ window.matchMedia('(prefers-color-scheme: dark)').addEventListener('change', fun
 
 function toggleSettings(e) {
   e.stopPropagation();
-  document.getElementById('settings-panel').classList.toggle('hidden');
+  var panel = document.getElementById('settings-panel');
+  panel.classList.toggle('hidden');
+  if (!panel.classList.contains('hidden')) fetchRunnerStatus();
 }
 
 // Close settings panel on outside click